@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/agent"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/spf13/pflag"
+	"google.golang.org/grpc/credentials"
+)
+
+type AgentCmd struct {
+	log logger.Logger
+}
+
+func (c *AgentCmd) Name() string { return "agent" }
+
+func (c *AgentCmd) Help() string {
+	return `
+Run on a Docker host as a gRPC agent that 'dockerbackup fleet' drives.
+
+Usage:
+  dockerbackup agent <directory> [options]
+
+Options:
+      --listen addr        Address to listen on (default: :9443)
+      --token string       Token the controller must send (default: $DOCKERBACKUP_AGENT_TOKEN;
+                           required)
+      --tls-cert file      Serve TLS with this certificate (PEM)
+      --tls-key file       Private key of --tls-cert
+      --helper-image img   Image of the helper containers that read and write volume data
+      --op-timeout dur     Kill docker commands that hang this long (default: 10m, or
+                           $DOCKERBACKUP_OP_TIMEOUT; 0 disables)
+      --work-dir dir       Directory for the temporary files of backups and restores (default:
+                           $DOCKERBACKUP_WORK_DIR, or $TMPDIR)
+
+The agent backs up into, restores from and streams the archives of the directory. Backups
+and restores run one at a time, and what they log is streamed to the controller. Without
+--tls-cert the connection, token included, is not encrypted: use TLS, or keep the agent on a
+trusted network.
+`
+}
+
+func (c *AgentCmd) Validate(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("directory is required")
+	}
+	return nil
+}
+
+func (c *AgentCmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var listen, token, certFile, keyFile string
+	fs.StringVar(&listen, "listen", ":9443", "Address to listen on")
+	fs.StringVar(&token, "token", os.Getenv(agent.TokenEnv), "Token the controller must send")
+	fs.StringVar(&certFile, "tls-cert", "", "Serve TLS with this certificate")
+	fs.StringVar(&keyFile, "tls-key", "", "Private key of --tls-cert")
+	applyClientFlags := dockerClientFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := applyClientFlags(); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return fmt.Errorf("directory is required")
+	}
+	if (certFile == "") != (keyFile == "") {
+		return fmt.Errorf("--tls-cert and --tls-key go together")
+	}
+	dir := fs.Arg(0)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	var creds credentials.TransportCredentials
+	if certFile != "" {
+		var err error
+		if creds, err = credentials.NewServerTLSFromFile(certFile, keyFile); err != nil {
+			return fmt.Errorf("load --tls-cert: %w", err)
+		}
+	}
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	a := agent.New(func(log logger.Logger) backup.BackupEngine { return newDefaultEngine(log) }, newDockerClient(), dir, c.log)
+	c.log.Infof("Agent serving the backups in %s on %s", dir, ln.Addr())
+	return a.Serve(ctx, ln, token, creds)
+}
+
+func init() {
+	RegisterCommand(&AgentCmd{log: logger.New()})
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/audit"
+	"github.com/spf13/pflag"
+)
+
+type AuditCmd struct {
+	log logger.Logger
+}
+
+func (c *AuditCmd) Name() string { return "audit" }
+
+func (c *AuditCmd) Help() string {
+	return `
+Show the audit log of restores, promotions, pruning and cleanups.
+
+Usage:
+  dockerbackup audit [options]
+
+Options:
+      --file path      Audit log to read (default: $DOCKERBACKUP_AUDIT_LOG or
+                       ~/.local/state/dockerbackup/audit.jsonl)
+      --op name        Only show this operation: restore, restore-compose, promote, prune,
+                       cleanup or migrate (repeatable)
+      --since when     Only show operations since a duration ago (24h, 7d) or a time
+                       ("2006-01-02 15:04", a date or RFC 3339)
+      --user name      Only show operations run by, or through sudo by, this user
+      --target text    Only show operations whose target contains this text
+      --failed         Only show failed operations
+      --last n         Show the n most recent matching operations (default: all)
+  -v, --verbose        Also show each operation's arguments and what it removed or replaced
+      --json           Print the entries as JSON
+      --verify         Check that no entry was edited or removed, and exit non-zero if one was
+
+Every operation that removes or overwrites containers, volume data, networks or backups is
+appended to the log when it ends, with who ran it, from where, its arguments and its outcome.
+If the log cannot be written, the operation does not start. Each entry carries the SHA-256 of
+the one before it, so --verify notices entries that were changed or deleted.
+`
+}
+
+func (c *AuditCmd) Validate(args []string) error { return nil }
+
+func (c *AuditCmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var file, since, user, target string
+	var ops []string
+	var failed, verbose, asJSON, verify bool
+	var last int
+	fs.StringVar(&file, "file", audit.Path(), "Audit log to read")
+	fs.StringArrayVar(&ops, "op", nil, "Only show this operation (repeatable)")
+	fs.StringVar(&since, "since", "", "Only show operations since a duration ago or a time")
+	fs.StringVar(&user, "user", "", "Only show operations of this user")
+	fs.StringVar(&target, "target", "", "Only show operations whose target contains this text")
+	fs.BoolVar(&failed, "failed", false, "Only show failed operations")
+	fs.IntVar(&last, "last", 0, "Show the n most recent matching operations")
+	fs.BoolVarP(&verbose, "verbose", "v", false, "Show arguments and details")
+	fs.BoolVar(&asJSON, "json", false, "Print JSON")
+	fs.BoolVar(&verify, "verify", false, "Check the log's hash chain")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if verify {
+		n, err := audit.Verify(file)
+		if err != nil {
+			return fmt.Errorf("audit log %s: %w", file, err)
+		}
+		fmt.Printf("Audit log %s is intact (%d entries)\n", file, n)
+		return nil
+	}
+	var from time.Time
+	if since != "" {
+		var err error
+		if from, err = parseSince(since); err != nil {
+			return err
+		}
+	}
+	entries, err := audit.Read(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	out := []audit.Entry{}
+	for _, e := range entries {
+		switch {
+		case len(ops) > 0 && !slices.Contains(ops, e.Op),
+			!from.IsZero() && e.Time.Before(from),
+			user != "" && e.User != user && e.RealUser != user,
+			target != "" && !strings.Contains(e.Target, target),
+			failed && e.Result != audit.ResultFailed:
+			continue
+		}
+		out = append(out, e)
+	}
+	if last > 0 && len(out) > last {
+		out = out[len(out)-last:]
+	}
+	if asJSON {
+		return printJSON(out)
+	}
+	if len(out) == 0 {
+		fmt.Println("No audited operations found")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if !verbose {
+		fmt.Fprintln(tw, "TIME\tUSER\tHOST\tOP\tTARGET\tRESULT")
+	}
+	for _, e := range out {
+		who := e.User
+		if e.RealUser != "" {
+			who = e.RealUser + " (as " + e.User + ")"
+		}
+		if e.Via != "" {
+			who += " via " + e.Via
+		}
+		result := e.Result
+		if e.Error != "" {
+			result += ": " + e.Error
+		}
+		if !verbose {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", formatTime(e.Time), who, e.Host, e.Op, orNone(e.Target), result)
+			continue
+		}
+		fmt.Fprintf(tw, "%s %s %s by %s on %s (%s): %s\n", formatTime(e.Time), e.Op, orNone(e.Target), who, e.Host, e.Duration, result)
+		if len(e.Args) > 0 {
+			fmt.Fprintf(tw, "  args: %s\n", strings.Join(e.Args, " "))
+		}
+		for _, d := range e.Details {
+			fmt.Fprintf(tw, "  - %s\n", d)
+		}
+	}
+	return tw.Flush()
+}
+
+// parseSince accepts a duration before now (24h, 7d), RFC 3339 or a local
+// "YYYY-MM-DD[ HH:MM[:SS]]" time; a date means the start of that day.
+func parseSince(s string) (time.Time, error) {
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		if days, err := strconv.Atoi(n); err == nil {
+			return time.Now().AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q (want a duration such as 24h or 7d, \"2006-01-02 15:04\", a date or RFC 3339)", s)
+}
+
+func init() {
+	RegisterCommand(&AuditCmd{log: logger.New()})
+}
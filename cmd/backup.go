@@ -3,9 +3,14 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/brian033/dockerbackup/internal/logger"
 	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/storage"
 	"github.com/spf13/pflag"
 )
 
@@ -18,14 +23,82 @@ func (c *BackupCmd) Name() string { return "backup" }
 
 func (c *BackupCmd) Help() string {
 	return `
-Backup a single container.
+Backup a container, or several into one archive.
 
 Usage:
   dockerbackup backup <container_id_or_name> [options]
+  dockerbackup backup <container> <container>... -o stack.tar.gz [options]
+
+Several containers are stored like a compose project without compose files: each
+container's backup, the networks and volumes they use recorded once, and the data of a
+volume they share archived once. restore (or restore-compose) restores them together.
 
 Options:
   -o, --output string     Output file path (default: <container>_backup.tar.gz)
-  -c, --compress int      Compression level (1-9, default: 6)
+  -c, --compress level    Compression level (1-9, default: 6), or auto to store files that are
+                          compressed already (media, archives, image layers) without
+                          compressing them again
+      --compress-threads n
+                          Compress with n goroutines (parallel gzip; 0 = every CPU, default: 1)
+      --timestamped       Name the archive <container>_<YYYY-MM-DDTHH-MM-SS>.tar.gz (-o is then the
+                          directory) and point <container>_latest.tar.gz at it
+      --storage string    Upload the archive (and its checksum) to a storage location:
+                          a directory, webdav://, sftp:// or s3:// URL (see README)
+      --remove-local      Delete the local archive after a successful upload
+      --chunked           With --storage, upload the archive as content-defined chunks and
+                          send only those the location does not hold yet: nightly backups of
+                          a mostly unchanged container upload just the changed parts
+      --split-size size   Split the archive into <archive>.part001, .part002, ... of at most this
+                          size (e.g. 4G, 700M) plus a <archive>.parts.json manifest; restore
+                          accepts the manifest (or the directory) and joins the parts
+      --skip-unchanged    Store volumes whose files (paths, sizes, mtimes) did not change since
+                          the previous backup as references to the archive holding their data;
+                          use with --timestamped and keep the referenced archives
+      --image-format fmt  Store the image as docker (docker save tar, default) or oci (an OCI
+                          image layout directory image-oci/, usable with skopeo/containerd)
+      --sbom              Also store an SPDX SBOM of the image (needs syft or the docker sbom
+                          plugin) next to its provenance (digests, tags, OCI source labels)
+      --include-volume name
+                          Archive only these named volumes (repeatable, glob patterns allowed)
+      --exclude-volume name
+                          Do not archive these named volumes (repeatable, glob patterns allowed)
+      --skip-bind-mounts  Do not archive bind mount data
+      --one-file-system   Do not cross into other filesystems mounted below a volume or bind
+                          mount (a bind mount of / then skips /proc, /sys, other disks)
+      --nested-mounts policy
+                          What to do with mountpoints found below a mount's host path: include
+                          (archive their contents along, with a warning; default) or skip
+                          (archive them as empty directories)
+      --allow-special-mounts
+                          Also archive bind mounts of special paths (the Docker socket, /proc,
+                          /sys, /dev, /var/lib/docker, device files), skipped by default
+      --skip-remote-volume-data
+                          For NFS/CIFS volumes (local driver, type=nfs/cifs) record only the
+                          mount options; restore recreates them pointing at the same share
+      --wait-lock         Wait for a concurrent backup of the same container instead of failing
+      --lock-timeout dur  Give up waiting for the lock after this long (e.g. 10m)
+      --checkpoint string Also capture the running process state as a CRIU checkpoint with this
+                          name (requires an experimental daemon with CRIU); the container is
+                          stopped afterwards so the filesystem matches the checkpoint
+      --leave-running     Keep the container running after --checkpoint
+      --tag name          Tag the backup (repeatable), e.g. prod or pre-upgrade; filter with
+                          'dockerbackup backups list --tag'
+      --note string       Free-text note stored with the backup
+      --report            Also store the backup report (printed at the end) as report.json in
+                          the archive
+      --dry-run           Only inspect the container(s) and print what would be captured: the
+                          mounts and their sizes, which are skipped and why, the image, the
+                          networks and an estimate of the archive size; nothing is written
+      --helper-image ref  Image of the helper containers archiving volumes not reachable on the
+                          host (default: alpine:3.19, or $DOCKERBACKUP_HELPER_IMAGE)
+      --op-timeout dur    Kill a docker command that hangs: one that streams data (export, save)
+                          once it made no progress for dur, any other once it ran for dur
+                          (default: 10m, or $DOCKERBACKUP_OP_TIMEOUT; 0 disables)
+      --work-dir dir      Directory for the temporary export, image and volume archives before
+                          packaging; a scratch disk when /tmp is small (default: $TMPDIR, or
+                          $DOCKERBACKUP_WORK_DIR). Fails early when it has less space free than
+                          the container filesystem and image need
+      --json              Print the result, report and warnings as JSON instead of the report
 `
 }
 
@@ -39,32 +112,233 @@ func (c *BackupCmd) Validate(args []string) error {
 func (c *BackupCmd) Execute(ctx context.Context, args []string) error {
 	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
 	var output string
-	var compress int
+	var compressThreads int
+	var checkpoint string
+	var leaveRunning bool
+	var timestamped bool
+	var storageLoc string
+	var removeLocal bool
+	var chunked bool
+	var splitSize string
+	var waitLock bool
+	var lockTimeout time.Duration
+	var imageFormat string
+	var sbom bool
+	var skipRemote bool
+	var includeVolumes []string
+	var excludeVolumes []string
+	var skipBindMounts bool
+	var oneFileSystem bool
+	var nestedMounts string
+	var allowSpecialMounts bool
+	var skipUnchanged bool
+	var tags []string
+	var note string
+	var embedReport bool
+	var dryRun bool
+	var asJSON bool
 	fs.StringVarP(&output, "output", "o", "", "Output file path")
-	fs.IntVarP(&compress, "compress", "c", 6, "Compression level (1-9)")
+	parseCompress := compressFlags(fs)
+	fs.IntVar(&compressThreads, "compress-threads", 1, "Compression goroutines (0 = every CPU)")
+	fs.StringVar(&checkpoint, "checkpoint", "", "Create a CRIU checkpoint with this name")
+	fs.StringVar(&storageLoc, "storage", "", "Upload the archive to this storage location (e.g. webdav://host/path)")
+	fs.BoolVar(&removeLocal, "remove-local", false, "Delete the local archive after a successful upload")
+	fs.BoolVar(&chunked, "chunked", false, "Upload the archive in content-defined chunks, sending only new ones")
+	fs.StringVar(&splitSize, "split-size", "", "Split the archive into parts of this size (e.g. 4G)")
+	fs.StringVar(&imageFormat, "image-format", backup.ImageFormatDocker, "Image storage format: docker or oci")
+	fs.BoolVar(&sbom, "sbom", false, "Generate an SBOM of the image with syft or docker sbom")
+	fs.BoolVar(&skipRemote, "skip-remote-volume-data", false, "Record only the mount options of NFS/CIFS volumes, not their data")
+	fs.StringArrayVar(&includeVolumes, "include-volume", nil, "Archive only these named volumes (repeatable)")
+	fs.StringArrayVar(&excludeVolumes, "exclude-volume", nil, "Do not archive these named volumes (repeatable)")
+	fs.BoolVar(&skipBindMounts, "skip-bind-mounts", false, "Do not archive bind mount data")
+	fs.BoolVar(&oneFileSystem, "one-file-system", false, "Do not cross into other filesystems below a mount")
+	fs.StringVar(&nestedMounts, "nested-mounts", backup.NestedMountsInclude, "Mountpoints below a mount: include or skip")
+	fs.BoolVar(&allowSpecialMounts, "allow-special-mounts", false, "Also archive bind mounts of the Docker socket, /proc, /sys, /dev and the like")
+	fs.BoolVar(&waitLock, "wait-lock", false, "Wait for a running backup of the same target instead of failing")
+	fs.DurationVar(&lockTimeout, "lock-timeout", 0, "Maximum time to wait for the lock (e.g. 10m; implies --wait-lock)")
+	fs.BoolVar(&skipUnchanged, "skip-unchanged", false, "Reference volumes unchanged since the previous backup instead of archiving them")
+	fs.BoolVar(&timestamped, "timestamped", false, "Name the archive <name>_<timestamp>.tar.gz and update <name>_latest.tar.gz")
+	fs.BoolVar(&leaveRunning, "leave-running", false, "Leave the container running after checkpointing")
+	fs.StringArrayVar(&tags, "tag", nil, "Tag the backup (repeatable)")
+	fs.StringVar(&note, "note", "", "Note stored with the backup")
+	fs.BoolVar(&embedReport, "report", false, "Store the backup report as report.json in the archive")
+	fs.BoolVar(&dryRun, "dry-run", false, "Print what would be captured without writing anything")
+	applyClientFlags := dockerClientFlags(fs)
+	fs.BoolVar(&asJSON, "json", false, "Print the result, report and warnings as JSON")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
+	if err := applyClientFlags(); err != nil {
+		return err
+	}
+	compress, autoCompress, err := parseCompress()
+	if err != nil {
+		return err
+	}
+	if compressThreads < 0 {
+		return fmt.Errorf("invalid --compress-threads %d", compressThreads)
+	}
 	remaining := fs.Args()
 	if len(remaining) == 0 {
 		return fmt.Errorf("missing container id or name")
 	}
 	containerID := remaining[0]
+	var containerIDs []string
+	if len(remaining) > 1 {
+		if checkpoint != "" || skipUnchanged {
+			return fmt.Errorf("--checkpoint and --skip-unchanged back up a single container")
+		}
+		containerID, containerIDs = "", remaining
+	}
+	if err := validateTags(tags); err != nil {
+		return err
+	}
+
+	if imageFormat != backup.ImageFormatDocker && imageFormat != backup.ImageFormatOCI {
+		return fmt.Errorf("invalid --image-format %q (want docker or oci)", imageFormat)
+	}
+	if nestedMounts != backup.NestedMountsInclude && nestedMounts != backup.NestedMountsSkip {
+		return fmt.Errorf("invalid --nested-mounts %q (want include or skip)", nestedMounts)
+	}
+	var split int64
+	if splitSize != "" {
+		n, err := storage.ParseSize(splitSize)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid --split-size %q", splitSize)
+		}
+		split = n
+	}
+	if chunked && storageLoc == "" {
+		return fmt.Errorf("--chunked needs --storage")
+	}
+	if chunked && split > 0 {
+		return fmt.Errorf("--chunked and --split-size cannot be combined")
+	}
 
 	builder := backup.NewBackupOptionsBuilder().
 		WithOutput(output).
-		WithCompression(compress)
+		WithCompression(compress).
+		WithAutoCompression(autoCompress).
+		WithRsyncable(chunked).
+		WithCompressThreads(compressThreads).
+		WithCheckpoint(checkpoint, leaveRunning).
+		WithTimestamped(timestamped).
+		WithSplitSize(split).
+		WithLock(waitLock, lockTimeout).
+		WithSkipUnchanged(skipUnchanged).
+		WithImageFormat(imageFormat).
+		WithSBOM(sbom).
+		WithSkipRemoteVolumeData(skipRemote).
+		WithMountSelection(includeVolumes, excludeVolumes, skipBindMounts).
+		WithMountBoundary(oneFileSystem, nestedMounts).
+		WithAllowSpecialMounts(allowSpecialMounts).
+		WithAnnotations(tags, note).
+		WithEmbeddedReport(embedReport)
 
 	req := backup.BackupRequest{
-		TargetType:  backup.TargetContainer,
-		ContainerID: containerID,
-		Options:     builder.Build(),
+		TargetType:   backup.TargetContainer,
+		ContainerID:  containerID,
+		ContainerIDs: containerIDs,
+		Options:      builder.Build(),
+	}
+	if dryRun {
+		plan, err := backup.PlanBackup(ctx, newDockerClient(), req)
+		if err != nil {
+			return err
+		}
+		if asJSON {
+			return printJSON(plan)
+		}
+		printBackupPlan(plan)
+		return nil
 	}
 	if c.engine == nil {
 		c.engine = newDefaultEngine(c.log)
 	}
-	_, err := c.engine.Backup(ctx, req)
-	return err
+	res, err := c.engine.Backup(ctx, req)
+	if err != nil {
+		return err
+	}
+	if err := printBackupResult(res, asJSON); err != nil {
+		return err
+	}
+	if storageLoc == "" {
+		return nil
+	}
+	return uploadBackup(ctx, c.log, storageLoc, res, removeLocal, chunked)
+}
+
+// printBackupResult prints res as JSON, or its report.
+func printBackupResult(res *backup.BackupResult, asJSON bool) error {
+	if asJSON {
+		return printJSON(res)
+	}
+	printBackupReport(res)
+	return nil
+}
+
+// printBackupReport prints what a backup captured: stage durations, the size of each
+// component before and after compression, and warnings about what was left out.
+func printBackupReport(res *backup.BackupResult) {
+	r := res.Report
+	if r == nil {
+		return
+	}
+	fmt.Printf("Backup of %s finished in %s: %s (%s)\n", r.Target, seconds(r.Seconds), res.OutputPath, storage.FormatSize(r.ArchiveSize))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nSTAGE\tDURATION")
+	for _, st := range r.Stages {
+		fmt.Fprintf(tw, "%s\t%s\n", st.Name, seconds(st.Seconds))
+	}
+	fmt.Fprintln(tw, "\nCOMPONENT\tORIGINAL\tCOMPRESSED\t")
+	for _, cp := range r.Components {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", cp.Name, sizeOrDash(cp.Size), sizeOrDash(cp.Compressed), cp.Note)
+	}
+	_ = tw.Flush()
+	if len(r.Warnings) > 0 {
+		fmt.Println("\nWarnings:")
+		for _, w := range r.Warnings {
+			fmt.Printf("  - %s\n", w)
+		}
+	}
+}
+
+// printBackupPlan prints what backup --dry-run found would be captured.
+func printBackupPlan(plan *backup.BackupPlan) {
+	for _, cp := range plan.Containers {
+		fmt.Printf("Container %s:\n", cp.Name)
+		fmt.Printf("  filesystem: %s\n", sizeOrDash(cp.FilesystemSize))
+		fmt.Printf("  image: %s\n", orNone(cp.Image))
+		fmt.Printf("  networks: %s\n", orNone(strings.Join(cp.Networks, ", ")))
+		if len(cp.Mounts) == 0 {
+			continue
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "  MOUNT\tTYPE\tDESTINATION\tSIZE\tCAPTURED\t")
+		for _, m := range cp.Mounts {
+			captured := "yes"
+			if m.Skipped != "" {
+				captured = "no (" + m.Skipped + ")"
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\t%s\n", orNone(m.Name), m.Type, m.Destination, sizeOrDash(m.Size), captured, m.Note)
+		}
+		_ = tw.Flush()
+	}
+	for _, img := range plan.Images {
+		fmt.Printf("Image %s: %s\n", img.Ref, sizeOrDash(img.Size))
+	}
+	fmt.Printf("Estimated size before compression: %s\n", sizeOrDash(plan.EstimatedSize))
+}
+
+func seconds(s float64) string {
+	return (time.Duration(s * float64(time.Second))).Round(100 * time.Millisecond).String()
+}
+
+func sizeOrDash(n int64) string {
+	if n <= 0 {
+		return "-"
+	}
+	return storage.FormatSize(n)
 }
 
 func init() {
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/spf13/pflag"
+)
+
+type BackupAllCmd struct {
+	log    logger.Logger
+	engine backup.BackupEngine
+}
+
+// backupAllRun is what backup-all did for one running container.
+type backupAllRun struct {
+	Container string `json:"container"`
+	// Skipped says why the container was not backed up: ignore label, excluded name or label
+	Skipped string `json:"skipped,omitempty"`
+	Backup  string `json:"backup,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (c *BackupAllCmd) Name() string { return "backup-all" }
+
+func (c *BackupAllCmd) Help() string {
+	return `
+Back up every running container, each into its own timestamped archive.
+
+Usage:
+  dockerbackup backup-all <directory> [options]
+
+Options:
+      --exclude-name pattern
+                          Skip containers with this name (repeatable, glob patterns allowed)
+      --exclude-label label
+                          Skip containers carrying this label, as key or key=value (repeatable)
+  -c, --compress level    Compression level (1-9, default: 6), or auto (see backup)
+      --tag name          Tag the backups (repeatable)
+      --dry-run           List what would be backed up and skipped without doing it
+      --json              Print what was done for each container as JSON
+
+Containers labeled dockerbackup.ignore=true are always skipped, so ephemeral workloads (CI
+runners, build caches) opt out on their own. Each archive is written as with
+'backup --timestamped' into the directory. A failed backup is reported and the others still
+run; the exit status is an error when any failed.
+`
+}
+
+func (c *BackupAllCmd) Validate(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("directory is required")
+	}
+	return nil
+}
+
+func (c *BackupAllCmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var excludeNames, excludeLabels, tags []string
+	var dryRun, asJSON bool
+	fs.StringArrayVar(&excludeNames, "exclude-name", nil, "Skip containers with this name (repeatable)")
+	fs.StringArrayVar(&excludeLabels, "exclude-label", nil, "Skip containers carrying this label (repeatable)")
+	parseCompress := compressFlags(fs)
+	fs.StringArrayVar(&tags, "tag", nil, "Tag the backups (repeatable)")
+	fs.BoolVar(&dryRun, "dry-run", false, "Show what would be done")
+	fs.BoolVar(&asJSON, "json", false, "Print JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	compress, autoCompress, err := parseCompress()
+	if err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return fmt.Errorf("directory is required")
+	}
+	filter := backup.BackupAllFilter{ExcludeNames: excludeNames, ExcludeLabels: excludeLabels}
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+	dir := fs.Arg(0)
+	if !dryRun {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	containers, err := backup.BackupAllTargets(ctx, docker.NewCLIClient(), filter)
+	if err != nil {
+		return err
+	}
+	if c.engine == nil {
+		c.engine = newDefaultEngine(c.log)
+	}
+
+	runs := make([]backupAllRun, 0, len(containers))
+	failed := 0
+	for _, ref := range containers {
+		run := backupAllRun{Container: ref.Name, Skipped: ref.Skipped}
+		if run.Skipped == "" && !dryRun {
+			res, err := c.engine.Backup(ctx, backup.BackupRequest{
+				TargetType:  backup.TargetContainer,
+				ContainerID: ref.ID,
+				Options: backup.NewBackupOptionsBuilder().
+					WithOutput(dir).
+					WithTimestamped(true).
+					WithCompression(compress).
+					WithAutoCompression(autoCompress).
+					WithAnnotations(tags, "").
+					Build(),
+			})
+			if err != nil {
+				run.Error = err.Error()
+				failed++
+			} else {
+				run.Backup = res.OutputPath
+			}
+		}
+		runs = append(runs, run)
+	}
+
+	if asJSON {
+		if err := printJSON(runs); err != nil {
+			return err
+		}
+	} else if len(runs) == 0 {
+		fmt.Println("No running containers")
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "CONTAINER\tRESULT")
+		for _, r := range runs {
+			result := "backed up"
+			switch {
+			case r.Skipped != "":
+				result = "skipped (" + r.Skipped + ")"
+			case r.Error != "":
+				result = "failed: " + r.Error
+			case r.Backup != "":
+				result = filepath.Base(r.Backup)
+			case dryRun:
+				result = "would back up"
+			}
+			fmt.Fprintf(tw, "%s\t%s\n", r.Container, result)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d container backups failed", failed, len(runs))
+	}
+	return nil
+}
+
+func init() {
+	RegisterCommand(&BackupAllCmd{log: logger.New()})
+}
@@ -2,9 +2,12 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/brian033/dockerbackup/internal/logger"
 	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/storage"
 	"github.com/spf13/pflag"
 )
 
@@ -25,6 +28,54 @@ Usage:
 Options:
   -o, --output string        Output file path (default: <project>_compose_backup.tar.gz)
   -p, --project-name string  Override project name
+  -c, --compress level       Compression level (1-9, default: 6), or auto to store files that are
+                             compressed already (media, archives, image layers) as they are
+      --compress-threads n   Compress with n goroutines (parallel gzip; 0 = every CPU,
+                             default: 1)
+      --timestamped          Name the archive <project>_compose_<timestamp>.tar.gz (-o is then the
+                             directory) and point <project>_compose_latest.tar.gz at it
+      --storage string       Upload the archive (and its checksum) to a storage location
+      --remove-local         Delete the local archive after a successful upload
+      --chunked              With --storage, upload the archive as content-defined chunks and send
+                             only those the location does not hold yet
+      --split-size size      Split the archive into numbered parts of at most this size (e.g. 4G)
+                             plus a <archive>.parts.json manifest used by restore-compose
+      --image-format fmt     docker (docker save tar, default) or oci (OCI image layout
+                             directory, usable with skopeo/containerd)
+      --sbom                 Store an SPDX SBOM of each service image (needs syft or docker sbom)
+      --include-volume name  Archive only these named volumes (repeatable, globs allowed)
+      --exclude-volume name  Do not archive these named volumes (repeatable, globs allowed)
+      --skip-bind-mounts     Do not archive bind mount data
+      --one-file-system      Do not cross into other filesystems mounted below a volume or bind
+                             mount
+      --nested-mounts policy include (default) or skip the contents of mountpoints found below
+                             a mount's host path
+      --allow-special-mounts Also archive bind mounts of the Docker socket, /proc, /sys, /dev,
+                             /var/lib/docker and device files, skipped by default
+      --skip-remote-volume-data
+                             Record only the mount options of NFS/CIFS volumes, not their data
+      --wait-lock            Wait for a concurrent backup of the same project instead of failing
+      --lock-timeout dur     Give up waiting for the lock after this long (e.g. 10m)
+      --include-build-context
+                             Archive the local build context of services defined with build:
+                             (honors .dockerignore) so the project can be rebuilt on the target
+      --tag name             Tag the backup (repeatable), e.g. prod or pre-upgrade
+      --note string          Free-text note stored with the backup
+      --report               Also store the backup report (printed at the end) as report.json
+                             in the archive
+      --offline              When the project has no containers, back up the volumes and networks
+                             its compose files declare (named as compose names them) instead
+                             of failing
+      --helper-image ref     Image of the helper containers archiving volumes not reachable on
+                             the host (default: alpine:3.19, or $DOCKERBACKUP_HELPER_IMAGE)
+      --op-timeout dur       Kill a docker command that hangs: one that streams data once it
+                             made no progress for dur, any other once it ran for dur (default:
+                             10m, or $DOCKERBACKUP_OP_TIMEOUT; 0 disables)
+      --work-dir dir         Directory for the temporary export, image and volume archives
+                             before packaging; a scratch disk when /tmp is small (default:
+                             $DOCKERBACKUP_WORK_DIR, or $TMPDIR). Fails early when it has less
+                             space free than the container filesystem and image need
+      --json                 Print the result, report and warnings as JSON instead of the report
 `
 }
 
@@ -34,19 +85,118 @@ func (c *BackupComposeCmd) Execute(ctx context.Context, args []string) error {
 	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
 	var output string
 	var projectName string
+	var includeBuildContext bool
+	var timestamped bool
+	var storageLoc string
+	var removeLocal bool
+	var chunked bool
+	var splitSize string
+	var waitLock bool
+	var lockTimeout time.Duration
+	var imageFormat string
+	var sbom bool
+	var skipRemote bool
+	var includeVolumes []string
+	var excludeVolumes []string
+	var skipBindMounts bool
+	var oneFileSystem bool
+	var nestedMounts string
+	var allowSpecialMounts bool
+	var compressThreads int
+	var tags []string
+	var note string
+	var embedReport bool
+	var offline bool
+	var asJSON bool
 	fs.StringVarP(&output, "output", "o", "", "Output file path")
 	fs.StringVarP(&projectName, "project-name", "p", "", "Project name")
+	parseCompress := compressFlags(fs)
+	fs.IntVar(&compressThreads, "compress-threads", 1, "Compression goroutines (0 = every CPU)")
+	fs.StringVar(&storageLoc, "storage", "", "Upload the archive to this storage location (e.g. webdav://host/path)")
+	fs.BoolVar(&removeLocal, "remove-local", false, "Delete the local archive after a successful upload")
+	fs.BoolVar(&chunked, "chunked", false, "Upload the archive in content-defined chunks, sending only new ones")
+	fs.StringVar(&splitSize, "split-size", "", "Split the archive into parts of this size (e.g. 4G)")
+	fs.StringVar(&imageFormat, "image-format", backup.ImageFormatDocker, "Image storage format: docker or oci")
+	fs.BoolVar(&sbom, "sbom", false, "Generate an SBOM of the image with syft or docker sbom")
+	fs.BoolVar(&skipRemote, "skip-remote-volume-data", false, "Record only the mount options of NFS/CIFS volumes, not their data")
+	fs.StringArrayVar(&includeVolumes, "include-volume", nil, "Archive only these named volumes (repeatable)")
+	fs.StringArrayVar(&excludeVolumes, "exclude-volume", nil, "Do not archive these named volumes (repeatable)")
+	fs.BoolVar(&skipBindMounts, "skip-bind-mounts", false, "Do not archive bind mount data")
+	fs.BoolVar(&oneFileSystem, "one-file-system", false, "Do not cross into other filesystems below a mount")
+	fs.StringVar(&nestedMounts, "nested-mounts", backup.NestedMountsInclude, "Mountpoints below a mount: include or skip")
+	fs.BoolVar(&allowSpecialMounts, "allow-special-mounts", false, "Also archive bind mounts of the Docker socket, /proc, /sys, /dev and the like")
+	fs.BoolVar(&waitLock, "wait-lock", false, "Wait for a running backup of the same target instead of failing")
+	fs.DurationVar(&lockTimeout, "lock-timeout", 0, "Maximum time to wait for the lock (e.g. 10m; implies --wait-lock)")
+	fs.BoolVar(&timestamped, "timestamped", false, "Name the archive <project>_compose_<timestamp>.tar.gz and update the _latest link")
+	fs.BoolVar(&includeBuildContext, "include-build-context", false, "Archive local build contexts")
+	fs.StringArrayVar(&tags, "tag", nil, "Tag the backup (repeatable)")
+	fs.StringVar(&note, "note", "", "Note stored with the backup")
+	fs.BoolVar(&embedReport, "report", false, "Store the backup report as report.json in the archive")
+	fs.BoolVar(&offline, "offline", false, "Back up declared volumes and networks when the project has no containers")
+	applyClientFlags := dockerClientFlags(fs)
+	fs.BoolVar(&asJSON, "json", false, "Print the result, report and warnings as JSON")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
+	if err := applyClientFlags(); err != nil {
+		return err
+	}
+	compress, autoCompress, err := parseCompress()
+	if err != nil {
+		return err
+	}
+	if compressThreads < 0 {
+		return fmt.Errorf("invalid --compress-threads %d", compressThreads)
+	}
 	remaining := fs.Args()
 	projectPath := "."
 	if len(remaining) > 0 {
 		projectPath = remaining[0]
 	}
 
+	if err := validateTags(tags); err != nil {
+		return err
+	}
+	if imageFormat != backup.ImageFormatDocker && imageFormat != backup.ImageFormatOCI {
+		return fmt.Errorf("invalid --image-format %q (want docker or oci)", imageFormat)
+	}
+	if nestedMounts != backup.NestedMountsInclude && nestedMounts != backup.NestedMountsSkip {
+		return fmt.Errorf("invalid --nested-mounts %q (want include or skip)", nestedMounts)
+	}
+	var split int64
+	if splitSize != "" {
+		n, err := storage.ParseSize(splitSize)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid --split-size %q", splitSize)
+		}
+		split = n
+	}
+	if chunked && storageLoc == "" {
+		return fmt.Errorf("--chunked needs --storage")
+	}
+	if chunked && split > 0 {
+		return fmt.Errorf("--chunked and --split-size cannot be combined")
+	}
+
 	builder := backup.NewBackupOptionsBuilder().
-		WithOutput(output)
+		WithOutput(output).
+		WithCompression(compress).
+		WithAutoCompression(autoCompress).
+		WithRsyncable(chunked).
+		WithCompressThreads(compressThreads).
+		WithBuildContext(includeBuildContext).
+		WithTimestamped(timestamped).
+		WithSplitSize(split).
+		WithLock(waitLock, lockTimeout).
+		WithImageFormat(imageFormat).
+		WithSBOM(sbom).
+		WithSkipRemoteVolumeData(skipRemote).
+		WithMountSelection(includeVolumes, excludeVolumes, skipBindMounts).
+		WithMountBoundary(oneFileSystem, nestedMounts).
+		WithAllowSpecialMounts(allowSpecialMounts).
+		WithAnnotations(tags, note).
+		WithEmbeddedReport(embedReport).
+		WithOffline(offline)
 
 	req := backup.BackupRequest{
 		TargetType:         backup.TargetCompose,
@@ -56,8 +206,17 @@ func (c *BackupComposeCmd) Execute(ctx context.Context, args []string) error {
 	if c.engine == nil {
 		c.engine = newDefaultEngine(c.log)
 	}
-	_, err := c.engine.Backup(ctx, req)
-	return err
+	res, err := c.engine.Backup(ctx, req)
+	if err != nil {
+		return err
+	}
+	if err := printBackupResult(res, asJSON); err != nil {
+		return err
+	}
+	if storageLoc == "" {
+		return nil
+	}
+	return uploadBackup(ctx, c.log, storageLoc, res, removeLocal, chunked)
 }
 
 func init() {
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/spf13/pflag"
+)
+
+type BackupHostCmd struct {
+	log    logger.Logger
+	engine backup.BackupEngine
+}
+
+func (c *BackupHostCmd) Name() string { return "backup-host" }
+
+func (c *BackupHostCmd) Help() string {
+	return `
+Backup the Docker daemon configuration of this host.
+
+Usage:
+  dockerbackup backup-host [options]
+
+Captures daemon.json, /etc/default/docker, /etc/sysconfig/docker and systemd drop-ins
+(docker.service.d, docker.socket.d), 'docker info', installed plugins with their settings and
+the default bridge network, so a rebuilt host can reproduce the daemon behavior containers
+depend on (storage driver, log driver, address pools, registry mirrors, runtimes, ...).
+
+Options:
+  -o, --output string         Output file path (default: <hostname>_host_backup.tar.gz)
+  -c, --compress level        Compression level (1-9, default: 6), or auto (see backup)
+      --compress-threads n    Compress with n goroutines (parallel gzip; 0 = every CPU,
+                              default: 1)
+      --daemon-config string  daemon.json location (default: /etc/docker/daemon.json; rootless
+                              daemons use ~/.config/docker/daemon.json)
+      --timestamped           Name the archive <hostname>_host_<timestamp>.tar.gz and point
+                              <hostname>_host_latest.tar.gz at it
+      --storage string        Upload the archive (and its checksum) to a storage location
+      --remove-local          Delete the local archive after a successful upload
+      --tag name              Tag the backup (repeatable), e.g. pre-upgrade
+      --note string           Free-text note stored with the backup
+`
+}
+
+func (c *BackupHostCmd) Validate(args []string) error { return nil }
+
+func (c *BackupHostCmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var output string
+	var compressThreads int
+	var daemonConfig string
+	var timestamped bool
+	var storageLoc string
+	var removeLocal bool
+	var tags []string
+	var note string
+	fs.StringVarP(&output, "output", "o", "", "Output file path")
+	parseCompress := compressFlags(fs)
+	fs.IntVar(&compressThreads, "compress-threads", 1, "Compression goroutines (0 = every CPU)")
+	fs.StringVar(&daemonConfig, "daemon-config", backup.DefaultDaemonConfig, "daemon.json location")
+	fs.BoolVar(&timestamped, "timestamped", false, "Name the archive <hostname>_host_<timestamp>.tar.gz and update the _latest link")
+	fs.StringVar(&storageLoc, "storage", "", "Upload the archive to this storage location (e.g. webdav://host/path)")
+	fs.BoolVar(&removeLocal, "remove-local", false, "Delete the local archive after a successful upload")
+	fs.StringArrayVar(&tags, "tag", nil, "Tag the backup (repeatable)")
+	fs.StringVar(&note, "note", "", "Note stored with the backup")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	compress, autoCompress, err := parseCompress()
+	if err != nil {
+		return err
+	}
+	if compressThreads < 0 {
+		return fmt.Errorf("invalid --compress-threads %d", compressThreads)
+	}
+	if len(fs.Args()) > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Args()[0])
+	}
+	if err := validateTags(tags); err != nil {
+		return err
+	}
+
+	builder := backup.NewBackupOptionsBuilder().
+		WithOutput(output).
+		WithCompression(compress).
+		WithAutoCompression(autoCompress).
+		WithCompressThreads(compressThreads).
+		WithTimestamped(timestamped).
+		WithDaemonConfig(daemonConfig).
+		WithAnnotations(tags, note)
+
+	req := backup.BackupRequest{
+		TargetType: backup.TargetHost,
+		Options:    builder.Build(),
+	}
+	if c.engine == nil {
+		c.engine = newDefaultEngine(c.log)
+	}
+	res, err := c.engine.Backup(ctx, req)
+	if err != nil || storageLoc == "" {
+		return err
+	}
+	return uploadBackup(ctx, c.log, storageLoc, res, removeLocal, false)
+}
+
+func init() {
+	RegisterCommand(&BackupHostCmd{
+		log:    logger.New(),
+		engine: nil,
+	})
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/storage"
+	"github.com/spf13/pflag"
+)
+
+type BackupsCmd struct {
+	log logger.Logger
+}
+
+func (c *BackupsCmd) Name() string { return "backups" }
+
+func (c *BackupsCmd) Help() string {
+	return `
+List the backups stored in a directory, or show how their backups, uploads and restores
+trend over time.
+
+Usage:
+  dockerbackup backups list [directory] [options]
+  dockerbackup backups stats [directory] [options]
+
+Options:
+      --tag name       Only list backups with this tag (repeatable: all tags must match)
+      --target name    Only list backups (or stats) of this container or compose project
+      --json           Print the backups and their metadata (or the stats) as JSON
+
+Backups are listed newest first with their source, tags and note (see 'backup --tag/--note').
+
+Every backup written to a directory, and every upload and restore of its archives, records
+its duration, size and per-stage timings in the directory's .dockerbackup-stats.jsonl. 'stats'
+summarizes them per target: the latest size and how fast it grows per day, the latest and
+average duration, and the throughput. With --target it also lists every run with its slowest
+stage, so a backup that grows or slows down stands out.
+
+The directory defaults to the current one.
+`
+}
+
+func (c *BackupsCmd) Validate(args []string) error {
+	if len(args) == 0 || (args[0] != "list" && args[0] != "stats") {
+		return fmt.Errorf("usage: dockerbackup backups list|stats [directory]")
+	}
+	return nil
+}
+
+func (c *BackupsCmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var tags []string
+	var target string
+	var asJSON bool
+	fs.StringArrayVar(&tags, "tag", nil, "Only list backups with this tag (repeatable)")
+	fs.StringVar(&target, "target", "", "Only list backups of this container or compose project")
+	fs.BoolVar(&asJSON, "json", false, "Print JSON")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	dir := "."
+	if fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	if args[0] == "stats" {
+		return c.stats(dir, target, asJSON)
+	}
+	entries, err := backup.Catalog(ctx, dir, target, tags)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(entries)
+	}
+	if len(entries) == 0 {
+		fmt.Println("No backups found")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tCREATED\tSOURCE\tTAGS\tNOTE")
+	for _, en := range entries {
+		source := "host " + en.Hostname
+		switch {
+		case en.ProjectName != "":
+			source = "compose " + en.ProjectName
+		case en.ContainerName != "":
+			source = "container " + strings.TrimPrefix(en.ContainerName, "/")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", en.File, formatTime(en.CreatedAt), source, strings.Join(en.Tags, ","), en.Note)
+	}
+	return tw.Flush()
+}
+
+// stats prints the trend of the backups, uploads and restores recorded in dir.
+func (c *BackupsCmd) stats(dir, target string, asJSON bool) error {
+	recs, err := backup.ReadStats(dir, target)
+	if err != nil {
+		return err
+	}
+	summaries := backup.SummarizeStats(recs)
+	if asJSON {
+		if target != "" {
+			return printJSON(map[string]any{"summary": summaries, "runs": recs})
+		}
+		return printJSON(summaries)
+	}
+	if len(recs) == 0 {
+		fmt.Println("No statistics recorded")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tKIND\tRUNS\tLAST\tSIZE\tGROWTH/DAY\tDURATION\tAVG DURATION\tTHROUGHPUT")
+	for _, s := range summaries {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n", s.Target, s.Kind, s.Count, formatTime(s.Last), sizeOrDash(s.LastBytes),
+			growth(s.GrowthPerDay), seconds(s.LastSeconds), seconds(s.AvgSeconds), rate(s.Throughput))
+	}
+	if target != "" {
+		fmt.Fprintln(tw, "\nTIME\tKIND\tARCHIVE\tSIZE\tDURATION\tTHROUGHPUT\tSLOWEST STAGE")
+		for _, r := range recs {
+			slowest := "-"
+			if len(r.Stages) > 0 {
+				st := slices.MaxFunc(r.Stages, func(a, b backup.ReportStage) int { return cmp.Compare(a.Seconds, b.Seconds) })
+				slowest = fmt.Sprintf("%s (%s)", st.Name, seconds(st.Seconds))
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", formatTime(r.Time), r.Kind, r.Archive, sizeOrDash(r.Bytes), seconds(r.Seconds), rate(r.Throughput()), slowest)
+		}
+	}
+	return tw.Flush()
+}
+
+// rate formats bytes per second.
+func rate(bps float64) string {
+	if bps <= 0 {
+		return "-"
+	}
+	return storage.FormatSize(int64(bps)) + "/s"
+}
+
+// growth formats a change in bytes per day with its sign.
+func growth(perDay float64) string {
+	switch {
+	case perDay > 0:
+		return "+" + storage.FormatSize(int64(perDay))
+	case perDay < 0:
+		return "-" + storage.FormatSize(int64(-perDay))
+	}
+	return "-"
+}
+
+// validateTags rejects empty tags and tags with whitespace or commas, which would not survive
+// the comma-separated listing.
+func validateTags(tags []string) error {
+	for _, t := range tags {
+		if t == "" || strings.ContainsAny(t, ", \t\n") {
+			return fmt.Errorf("invalid --tag %q (tags are single words without commas)", t)
+		}
+	}
+	return nil
+}
+
+func init() {
+	RegisterCommand(&BackupsCmd{log: logger.New()})
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/audit"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/spf13/pflag"
+)
+
+type CleanupCmd struct {
+	log logger.Logger
+}
+
+func (c *CleanupCmd) Name() string { return "cleanup" }
+
+func (c *CleanupCmd) Help() string {
+	return `
+Remove leftovers of interrupted backup and restore runs.
+
+Usage:
+  dockerbackup cleanup [options]
+
+Options:
+      --dry-run             Only list what would be removed
+  -y, --yes                 Remove without listing it and asking to confirm first
+      --force               Also remove helper containers that are still running
+      --temp-older-than dur Remove dockerbackup_* temporary directories older than this
+                            (default: 24h; 0 keeps them)
+
+Removes:
+  - volume extraction helper containers (label dockerbackup.helper)
+  - containers, volumes and networks created by restores that were killed before they could
+    undo their work (recorded in $DOCKERBACKUP_JOURNAL_DIR, default /run/dockerbackup/journal)
+  - stale temporary directories, in the system temporary directory and in
+    $DOCKERBACKUP_WORK_DIR when set
+`
+}
+
+func (c *CleanupCmd) Validate(args []string) error { return nil }
+
+func (c *CleanupCmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var opts backup.CleanupOptions
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "Only list what would be removed")
+	fs.BoolVar(&opts.Force, "force", false, "Also remove running helper containers")
+	fs.DurationVar(&opts.TempOlderThan, "temp-older-than", 24*time.Hour, "Remove temporary directories older than this")
+	confirm := confirmFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	dc := docker.NewCLIClient()
+	if !opts.DryRun && confirm() == backup.ConfirmAsk {
+		// list what would go first, and only remove it once confirmed
+		preview := opts
+		preview.DryRun = true
+		rep, err := backup.Cleanup(ctx, dc, c.log, preview)
+		if err != nil {
+			return err
+		}
+		var what []string
+		for _, g := range cleanupGroups(rep) {
+			if len(g.items) > 0 {
+				what = append(what, fmt.Sprintf("%d %s (%s)", len(g.items), g.plural, strings.Join(g.items, ", ")))
+			}
+		}
+		if len(what) == 0 {
+			fmt.Println("Nothing to clean up")
+			return nil
+		}
+		if err := confirm().Confirm("Cleanup will remove " + strings.Join(what, "; ")); err != nil {
+			return err
+		}
+	}
+	var rec *audit.Record
+	if !opts.DryRun {
+		var err error
+		if rec, err = audit.Begin(c.Name(), "", args); err != nil {
+			return err
+		}
+	}
+	rep, err := backup.Cleanup(ctx, dc, c.log, opts)
+	if rec != nil {
+		if rep != nil {
+			for _, g := range cleanupGroups(rep) {
+				for _, it := range g.items {
+					rec.Details = append(rec.Details, "removed "+g.kind+" "+it)
+				}
+			}
+		}
+		err = rec.End(err)
+	}
+	if err != nil {
+		return err
+	}
+	verb := "Removed"
+	if opts.DryRun {
+		verb = "Would remove"
+	}
+	total := 0
+	for _, g := range cleanupGroups(rep) {
+		if len(g.items) == 0 {
+			continue
+		}
+		total += len(g.items)
+		fmt.Printf("%s %d %s: %s\n", verb, len(g.items), g.plural, strings.Join(g.items, ", "))
+	}
+	if len(rep.Journals) > 0 {
+		fmt.Printf("Interrupted restores: %s\n", strings.Join(rep.Journals, ", "))
+	}
+	if total == 0 {
+		fmt.Println("Nothing to clean up")
+	}
+	return nil
+}
+
+type cleanupGroup struct {
+	kind, plural string
+	items        []string
+}
+
+// cleanupGroups lists what a cleanup removed, or would remove, by kind.
+func cleanupGroups(rep *backup.CleanupReport) []cleanupGroup {
+	return []cleanupGroup{
+		{"helper container", "helper containers", rep.HelperContainers},
+		{"container", "containers", rep.Containers},
+		{"volume", "volumes", rep.Volumes},
+		{"network", "networks", rep.Networks},
+		{"temporary directory", "temporary directories", rep.TempDirs},
+	}
+}
+
+func init() {
+	RegisterCommand(&CleanupCmd{log: logger.New()})
+}
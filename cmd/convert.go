@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/spf13/pflag"
+)
+
+type ConvertCmd struct {
+	log logger.Logger
+}
+
+func (c *ConvertCmd) Name() string { return "convert" }
+
+func (c *ConvertCmd) Help() string {
+	return `
+Upgrade a backup archive to the current backup format.
+
+Usage:
+  dockerbackup convert <backup_file> [options]
+
+Options:
+  -o, --output string   Write the converted archive here (default: replace the input;
+                        required for split archives)
+
+Adds format.json, a backup ID and the current version to metadata.json and converts the
+service archives of compose backups as well. Archives already in the current format are
+left alone.
+`
+}
+
+func (c *ConvertCmd) Validate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing backup file path")
+	}
+	return nil
+}
+
+func (c *ConvertCmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var output string
+	fs.StringVarP(&output, "output", "o", "", "Output file path")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	remaining := fs.Args()
+	if len(remaining) == 0 {
+		return fmt.Errorf("missing backup file path")
+	}
+	src := remaining[0]
+	if output == "" {
+		if strings.HasSuffix(src, archive.SplitManifestSuffix) {
+			return fmt.Errorf("split archives are converted into a single archive; pass --output")
+		}
+		output = src
+	}
+	f, err := backup.ReadFormat(ctx, src)
+	if err != nil {
+		return err
+	}
+	if f.Version == backup.FormatVersion && output == src {
+		fmt.Printf("%s is already in format %d\n", src, backup.FormatVersion)
+		return nil
+	}
+	from, err := backup.ConvertArchive(ctx, src, output)
+	if err != nil {
+		return err
+	}
+	if _, err := archive.WriteChecksumFile(output); err != nil {
+		c.log.Infof("Could not write checksum for %s: %v", output, err)
+	}
+	fmt.Printf("Converted %s from format %d to %d -> %s\n", src, from.Version, backup.FormatVersion, output)
+	return nil
+}
+
+func init() {
+	RegisterCommand(&ConvertCmd{log: logger.New()})
+}
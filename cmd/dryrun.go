@@ -6,9 +6,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/brian033/dockerbackup/internal/logger"
 	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/spf13/pflag"
 )
 
 type DryRunRestoreCmd struct {
@@ -22,7 +27,14 @@ func (c *DryRunRestoreCmd) Help() string {
 Show what would be restored from a backup without making changes.
 
 Usage:
-  dockerbackup dry-run-restore <backup_file>
+  dockerbackup dry-run-restore <backup_file> [options]
+
+Options:
+  -p, --project-name string  Plan a compose restore under a new project name
+
+Compose backups get a per-service plan: start order, networks and volumes to create or
+reuse, images to load, container names and conflicts on this host (existing containers,
+ports in use).
 `
 }
 
@@ -34,9 +46,28 @@ func (c *DryRunRestoreCmd) Validate(args []string) error {
 }
 
 func (c *DryRunRestoreCmd) Execute(ctx context.Context, args []string) error {
-	backupFile := args[0]
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var projectName string
+	fs.StringVarP(&projectName, "project-name", "p", "", "New compose project name")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	remaining := fs.Args()
+	if len(remaining) == 0 {
+		return fmt.Errorf("missing backup file path")
+	}
+	backupFile := remaining[0]
+	if isComposeBackup(ctx, backupFile) {
+		return c.planCompose(ctx, backupFile, projectName)
+	}
 	h := archive.NewTarArchiveHandler()
-	entries, err := h.ListArchive(ctx, backupFile)
+	var volumeArchives []string
+	err := h.WalkArchive(ctx, backupFile, func(e archive.ArchiveEntry) error {
+		if len(e.Path) > 8 && e.Path[:8] == "volumes/" && filepath.Ext(e.Path) == ".gz" {
+			volumeArchives = append(volumeArchives, e.Path)
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
@@ -46,18 +77,12 @@ func (c *DryRunRestoreCmd) Execute(ctx context.Context, args []string) error {
 	fmt.Println("- Ensure networks and volumes exist; restore data for volumes and bind mounts")
 	fmt.Println("- Recreate container with mounts, ports, env, and networking")
 
-	has := map[string]bool{}
-	for _, e := range entries {
-		has[e.Path] = true
-	}
-	for _, e := range entries {
-		if len(e.Path) > 8 && e.Path[:8] == "volumes/" && filepath.Ext(e.Path) == ".gz" {
-			fmt.Printf("  * volume archive: %s\n", e.Path)
-		}
+	for _, p := range volumeArchives {
+		fmt.Printf("  * volume archive: %s\n", p)
 	}
 
 	// Extract to temp dir for richer diff
-	tmp, err := os.MkdirTemp("", "dockerbackup_dryrun_*")
+	tmp, err := os.MkdirTemp(backup.WorkDir(), "dockerbackup_dryrun_*")
 	if err != nil {
 		return err
 	}
@@ -100,6 +125,76 @@ func (c *DryRunRestoreCmd) Execute(ctx context.Context, args []string) error {
 	return nil
 }
 
+func (c *DryRunRestoreCmd) planCompose(ctx context.Context, backupFile, projectName string) error {
+	plan, err := backup.PlanComposeRestore(ctx, docker.NewCLIClient(), backupFile, projectName)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Plan for compose project %s:\n", orNone(plan.ProjectName))
+	if len(plan.Networks) > 0 {
+		fmt.Println("Networks:")
+		for _, n := range plan.Networks {
+			fmt.Printf("  - %s (%s): %s\n", n.Name, orNone(n.Driver), createOrReuse(n.Exists))
+		}
+	}
+	if len(plan.Volumes) > 0 {
+		fmt.Println("Volumes:")
+		for _, v := range plan.Volumes {
+			fmt.Printf("  - %s (%s): %s\n", v.Name, orNone(v.Driver), createOrReuse(v.Exists))
+		}
+	}
+	fmt.Println("Services (in start order):")
+	for i, s := range plan.Services {
+		fmt.Printf("  %d. %s\n", i+1, s.Name)
+		if s.ImageSource == "" {
+			fmt.Println("     (no container backup; skipped)")
+			continue
+		}
+		fmt.Printf("     container: %s\n", orNone(s.Container))
+		if s.Replicas > 1 {
+			fmt.Printf("     replicas:  %d\n", s.Replicas)
+		}
+		if s.ImageSource != "filesystem.tar" {
+			fmt.Printf("     image:     load %s from %s\n", orNone(s.Image), s.ImageSource)
+		} else {
+			fmt.Printf("     image:     import filesystem.tar as %s\n", orNone(s.Image))
+		}
+		if len(s.DependsOn) > 0 {
+			deps := make([]string, 0, len(s.DependsOn))
+			for dep, cond := range s.DependsOn {
+				deps = append(deps, dep+" ("+cond+")")
+			}
+			sort.Strings(deps)
+			fmt.Printf("     depends:   %s\n", strings.Join(deps, ", "))
+		}
+		if len(s.Networks) > 0 {
+			fmt.Printf("     networks:  %s\n", strings.Join(s.Networks, ", "))
+		}
+		if len(s.Volumes) > 0 {
+			fmt.Printf("     mounts:    %s\n", strings.Join(s.Volumes, ", "))
+		}
+		if len(s.Ports) > 0 {
+			fmt.Printf("     ports:     %s\n", strings.Join(s.Ports, ", "))
+		}
+	}
+	if len(plan.Conflicts) == 0 {
+		fmt.Println("Conflicts: none")
+		return nil
+	}
+	fmt.Println("Conflicts:")
+	for _, cf := range plan.Conflicts {
+		fmt.Printf("  ! %s\n", cf)
+	}
+	return nil
+}
+
+func createOrReuse(exists bool) string {
+	if exists {
+		return "exists, reused"
+	}
+	return "create"
+}
+
 func init() {
 	RegisterCommand(&DryRunRestoreCmd{log: logger.New()})
 }
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/convert"
+	"github.com/spf13/pflag"
+)
+
+type ExportComposeCmd struct {
+	log logger.Logger
+}
+
+func (c *ExportComposeCmd) Name() string { return "export-compose" }
+
+func (c *ExportComposeCmd) Help() string {
+	return `
+Generate a docker-compose.yml service definition from a container backup.
+
+Usage:
+  dockerbackup export-compose <backup_file> [options]
+
+Options:
+  -o, --output string   Write the compose file to this path (default: stdout)
+`
+}
+
+func (c *ExportComposeCmd) Validate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing backup file path")
+	}
+	return nil
+}
+
+func (c *ExportComposeCmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var output string
+	fs.StringVarP(&output, "output", "o", "", "Output file path")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	remaining := fs.Args()
+	if len(remaining) == 0 {
+		return fmt.Errorf("missing backup file path")
+	}
+	cj, err := readBackupContainerJSON(ctx, remaining[0])
+	if err != nil {
+		return err
+	}
+	cf, err := convert.ToCompose(cj)
+	if err != nil {
+		return err
+	}
+	b, err := convert.MarshalCompose(cf)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	if err := os.WriteFile(output, b, 0o644); err != nil {
+		return err
+	}
+	c.log.Infof("Wrote compose file -> %s", output)
+	return nil
+}
+
+func init() {
+	RegisterCommand(&ExportComposeCmd{log: logger.New()})
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/convert"
+	"github.com/spf13/pflag"
+)
+
+type ExportK8sCmd struct {
+	log logger.Logger
+}
+
+func (c *ExportK8sCmd) Name() string { return "export-k8s" }
+
+func (c *ExportK8sCmd) Help() string {
+	return `
+Generate Kubernetes manifests (workload, PVCs, Service) from a container backup.
+
+Usage:
+  dockerbackup export-k8s <backup_file> [options]
+
+Options:
+  -o, --output string         Write manifests to this path (default: stdout)
+      --kind string           Workload kind: deployment or pod (default: deployment)
+  -n, --namespace string      Namespace for all objects
+      --pvc-size string       Requested size of each PVC (default: 1Gi)
+      --storage-class string  StorageClass for the PVCs
+      --data-url string       Base URL serving the backup's volumes/*.tar.gz; the restore-data
+                              init container downloads from it instead of waiting for kubectl cp
+
+Each named volume and bind mount becomes a PVC. A restore-data init container seeds empty
+PVCs from the backup's volume archives once, then the application container starts.
+`
+}
+
+func (c *ExportK8sCmd) Validate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing backup file path")
+	}
+	return nil
+}
+
+func (c *ExportK8sCmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var output, kind string
+	var opts convert.K8sOptions
+	fs.StringVarP(&output, "output", "o", "", "Output file path")
+	fs.StringVar(&kind, "kind", "deployment", "Workload kind: deployment or pod")
+	fs.StringVarP(&opts.Namespace, "namespace", "n", "", "Namespace")
+	fs.StringVar(&opts.PVCSize, "pvc-size", "1Gi", "PVC size")
+	fs.StringVar(&opts.StorageClass, "storage-class", "", "PVC storage class")
+	fs.StringVar(&opts.DataURL, "data-url", "", "Base URL serving volume archives")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	remaining := fs.Args()
+	if len(remaining) == 0 {
+		return fmt.Errorf("missing backup file path")
+	}
+	switch strings.ToLower(kind) {
+	case "deployment":
+		opts.Kind = convert.K8sKindDeployment
+	case "pod":
+		opts.Kind = convert.K8sKindPod
+	default:
+		return fmt.Errorf("invalid --kind %q (use deployment or pod)", kind)
+	}
+	cj, err := readBackupContainerJSON(ctx, remaining[0])
+	if err != nil {
+		return err
+	}
+	if opts.Artifacts, err = backup.MountArtifacts(ctx, remaining[0]); err != nil {
+		return fmt.Errorf("read mounts of %s: %w", remaining[0], err)
+	}
+	b, vols, err := convert.ToKubernetes(cj, opts)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		if _, err := os.Stdout.Write(b); err != nil {
+			return err
+		}
+	} else {
+		if err := os.WriteFile(output, b, 0o644); err != nil {
+			return err
+		}
+		c.log.Infof("Wrote Kubernetes manifests -> %s", output)
+	}
+	if opts.DataURL == "" {
+		for _, v := range vols {
+			if v.Archive == "" {
+				c.log.Infof("PVC %s: the backup holds no data for %s; it starts empty", v.ClaimName, v.MountPath)
+				continue
+			}
+			c.log.Infof("PVC %s: extract %s from the backup and copy it in with: kubectl cp %s <pod>:/mnt/%s/.dockerbackup-data.tar.gz -c restore-data", v.ClaimName, v.Archive, v.Archive, v.ClaimName)
+		}
+	}
+	return nil
+}
+
+func init() {
+	RegisterCommand(&ExportK8sCmd{log: logger.New()})
+}
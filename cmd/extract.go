@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/spf13/pflag"
+)
+
+type ExtractCmd struct {
+	log logger.Logger
+}
+
+func (c *ExtractCmd) Name() string { return "extract" }
+
+func (c *ExtractCmd) Help() string {
+	return `
+Extract selected files from a backup without restoring it.
+
+Usage:
+  dockerbackup extract <backup_file> <path>... [options]
+  dockerbackup extract <backup_file> --volume <name> [path...] [options]
+
+Paths are archive entries (see 'dockerbackup list'), directories (everything below them) or
+glob patterns such as 'volumes/*.json'. With --volume they are relative to the volume's root,
+and the volume's files are extracted instead of its archive.
+
+Options:
+  -o, --output string   Directory to extract into (default: current directory)
+      --volume string   Extract the data of this named volume (for compose backups, from the
+                        service that holds it)
+
+Backups are written with a seek index, so only the parts of the archive holding the selected
+entries are decompressed instead of everything before them. Older and split backups are read
+from the start.
+`
+}
+
+func (c *ExtractCmd) Validate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing backup file path")
+	}
+	return nil
+}
+
+func (c *ExtractCmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var output string
+	var volume string
+	fs.StringVarP(&output, "output", "o", ".", "Directory to extract into")
+	fs.StringVar(&volume, "volume", "", "Extract the data of this named volume")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	remaining := fs.Args()
+	if len(remaining) == 0 {
+		return fmt.Errorf("missing backup file path")
+	}
+	backupFile, paths := remaining[0], remaining[1:]
+
+	if volume != "" {
+		if err := backup.ExtractVolume(ctx, backupFile, volume, output, paths); err != nil {
+			return err
+		}
+		c.log.Infof("Extracted volume %s to %s", volume, output)
+		return nil
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("nothing to extract: give entry paths or --volume")
+	}
+	if err := archive.NewTarArchiveHandler().ExtractEntries(ctx, backupFile, output, backup.EntryMatcher("", paths)); err != nil {
+		return err
+	}
+	c.log.Infof("Extracted to %s", output)
+	return nil
+}
+
+func init() {
+	RegisterCommand(&ExtractCmd{log: logger.New()})
+}
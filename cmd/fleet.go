@@ -0,0 +1,459 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/agent"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/storage"
+	"github.com/spf13/pflag"
+)
+
+// AgentsEnv lists the agents, comma-separated, when no --agent is given.
+const AgentsEnv = "DOCKERBACKUP_AGENTS"
+
+type FleetCmd struct {
+	log logger.Logger
+}
+
+func (c *FleetCmd) Name() string { return "fleet" }
+
+func (c *FleetCmd) Help() string {
+	return `
+Back up and restore across many Docker hosts running 'dockerbackup agent'.
+
+Usage:
+  dockerbackup fleet status [options]
+  dockerbackup fleet list [options]
+  dockerbackup fleet backup [container...] [options]
+  dockerbackup fleet restore <agent> <file> [options]
+  dockerbackup fleet fetch <agent> <file> [options]
+
+Options:
+      --agent [name=]host:port   An agent (repeatable; default: $DOCKERBACKUP_AGENTS, comma-separated)
+      --token string             Token of the agents (default: $DOCKERBACKUP_AGENT_TOKEN)
+      --tls                      Connect with TLS, trusting the system's certificate authorities
+      --tls-ca file              Connect with TLS, trusting this certificate authority (PEM)
+      --json                     Print JSON (status, list, backup)
+
+list:
+      --target name              Only list backups of this container or compose project
+      --tag name                 Only list backups with this tag (repeatable)
+backup:
+      --all                      Back up every running container not labeled dockerbackup.ignore=true
+      --project dir              Back up the compose project in this directory (repeatable)
+      --tag name, --note text    Annotate the backups
+restore:
+      --from agent               Copy the archive from this agent first (restore on another host)
+      --name string              Name of the restored container or compose project
+      --start                    Start the restored container
+      --replace                  Replace an existing container of the name
+      --network-map old:new      Restore a network under another name (repeatable)
+      --volume-map old:new       Restore a volume under another name (repeatable)
+  -y, --yes, --force             Do not ask to confirm --replace
+fetch:
+  -o, --output dir               Directory to download into (default: current directory)
+
+Backups run on every agent at once, one container after the other on each host; containers
+and projects an agent's host does not have are skipped there. Archives stay on the agents
+(see 'fleet fetch'). Agent logs are printed as they arrive, prefixed with the agent's name.
+`
+}
+
+func (c *FleetCmd) Validate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing subcommand (status, list, backup, restore or fetch)")
+	}
+	switch args[0] {
+	case "status", "list", "backup", "restore", "fetch":
+		return nil
+	}
+	return fmt.Errorf("unknown subcommand %q (want status, list, backup, restore or fetch)", args[0])
+}
+
+func (c *FleetCmd) Execute(ctx context.Context, args []string) error {
+	sub := args[0]
+	fs := pflag.NewFlagSet(c.Name()+" "+sub, pflag.ContinueOnError)
+	var specs, tags, projects, netMaps, volMaps []string
+	var token, caFile, target, note, from, name, output string
+	var useTLS, asJSON, all, start, replace bool
+	confirm := func() backup.Confirmation { return backup.ConfirmNone }
+	fs.StringArrayVar(&specs, "agent", nil, "An agent: [name=]host:port (repeatable)")
+	fs.StringVar(&token, "token", os.Getenv(agent.TokenEnv), "Token of the agents")
+	fs.BoolVar(&useTLS, "tls", false, "Connect with TLS")
+	fs.StringVar(&caFile, "tls-ca", "", "Trust this certificate authority")
+	fs.BoolVar(&asJSON, "json", false, "Print JSON")
+	switch sub {
+	case "list":
+		fs.StringVar(&target, "target", "", "Only list backups of this container or compose project")
+		fs.StringArrayVar(&tags, "tag", nil, "Only list backups with this tag (repeatable)")
+	case "backup":
+		fs.BoolVar(&all, "all", false, "Back up every running container")
+		fs.StringArrayVar(&projects, "project", nil, "Back up the compose project in this directory (repeatable)")
+		fs.StringArrayVar(&tags, "tag", nil, "Tag the backups (repeatable)")
+		fs.StringVar(&note, "note", "", "Note stored with the backups")
+	case "restore":
+		fs.StringVar(&from, "from", "", "Copy the archive from this agent first")
+		fs.StringVar(&name, "name", "", "Name of the restored container or project")
+		fs.BoolVar(&start, "start", false, "Start the restored container")
+		fs.BoolVar(&replace, "replace", false, "Replace an existing container of the name")
+		fs.StringArrayVar(&netMaps, "network-map", nil, "old:new network name (repeatable)")
+		fs.StringArrayVar(&volMaps, "volume-map", nil, "old:new volume name (repeatable)")
+		confirm = confirmFlags(fs)
+	case "fetch":
+		fs.StringVarP(&output, "output", "o", ".", "Directory to download into")
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if len(specs) == 0 {
+		for _, s := range strings.Split(os.Getenv(AgentsEnv), ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				specs = append(specs, s)
+			}
+		}
+	}
+	if len(specs) == 0 {
+		return fmt.Errorf("no agents: give --agent or set $%s", AgentsEnv)
+	}
+	if token == "" {
+		return fmt.Errorf("no agent token: give --token or set $%s", agent.TokenEnv)
+	}
+	if err := validateTags(tags); err != nil {
+		return err
+	}
+	var tlsCfg *tls.Config
+	if useTLS || caFile != "" {
+		tlsCfg = &tls.Config{MinVersion: tls.VersionTLS12}
+		if caFile != "" {
+			pem, err := os.ReadFile(caFile)
+			if err != nil {
+				return err
+			}
+			tlsCfg.RootCAs = x509.NewCertPool()
+			if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("no certificates in --tls-ca %s", caFile)
+			}
+		}
+	}
+	agents := map[string]*agent.Client{}
+	var names []string
+	for _, spec := range specs {
+		name, addr, ok := strings.Cut(spec, "=")
+		if !ok {
+			name, addr = spec, spec
+		}
+		if _, dup := agents[name]; dup {
+			return fmt.Errorf("agent %s given twice", name)
+		}
+		cl, err := agent.Dial(name, addr, token, tlsCfg)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = cl.Close() }()
+		agents[name] = cl
+		names = append(names, name)
+	}
+	pick := func(name string) (*agent.Client, error) {
+		if cl, ok := agents[name]; ok {
+			return cl, nil
+		}
+		return nil, fmt.Errorf("unknown agent %s (given: %s)", name, strings.Join(names, ", "))
+	}
+
+	switch sub {
+	case "status":
+		return c.status(ctx, agents, names, asJSON)
+	case "list":
+		return c.list(ctx, agents, names, &agent.ListRequest{Target: target, Tags: tags}, asJSON)
+	case "backup":
+		if fs.NArg() == 0 && !all && len(projects) == 0 {
+			return fmt.Errorf("name containers, or give --all or --project")
+		}
+		return c.backup(ctx, agents, names, &agent.BackupRequest{Containers: fs.Args(), All: all, Projects: projects, Tags: tags, Note: note}, asJSON)
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: dockerbackup fleet %s <agent> <file>", sub)
+	}
+	cl, err := pick(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	file := fs.Arg(1)
+	if sub == "fetch" {
+		return c.fetch(ctx, cl, file, output)
+	}
+	if replace {
+		target := name
+		if target == "" {
+			target = "the backup's container"
+		}
+		if err := confirm().Confirm(fmt.Sprintf("On %s, an existing container named %s will be removed, and the data of existing volumes overwritten", cl.Name, target)); err != nil {
+			return err
+		}
+	}
+	if from != "" {
+		src, err := pick(from)
+		if err != nil {
+			return err
+		}
+		if err := c.copyArchive(ctx, src, cl, file); err != nil {
+			return err
+		}
+	}
+	res, err := cl.Restore(ctx, &agent.RestoreRequest{
+		File:       file,
+		Name:       name,
+		Start:      start,
+		Replace:    replace,
+		NetworkMap: parseMappings(netMaps),
+		VolumeMap:  parseMappings(volMaps),
+	}, c.agentLog(cl.Name))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s on %s: %s\n", file, cl.Name, orNone(res.RestoredID))
+	for _, w := range res.Warnings {
+		fmt.Printf("Warning: %s\n", w.Message)
+	}
+	return nil
+}
+
+// agentLog prints the lines an agent logs through the controller's log.
+func (c *FleetCmd) agentLog(name string) func(agent.LogLine) {
+	log := c.log.With("agent", name)
+	return func(l agent.LogLine) {
+		if l.Level == "error" {
+			log.Errorf("%s", l.Message)
+			return
+		}
+		log.Infof("%s", l.Message)
+	}
+}
+
+// fleetStatus is an agent's answer to 'fleet status'.
+type fleetStatus struct {
+	Agent string `json:"agent"`
+	Addr  string `json:"addr"`
+	*agent.InfoResponse
+	Error string `json:"error,omitempty"`
+}
+
+func (c *FleetCmd) status(ctx context.Context, agents map[string]*agent.Client, names []string, asJSON bool) error {
+	out := make([]fleetStatus, len(names))
+	each(names, func(i int, name string) {
+		cl := agents[name]
+		out[i] = fleetStatus{Agent: name, Addr: cl.Addr}
+		info, err := cl.Info(ctx)
+		if err != nil {
+			out[i].Error = err.Error()
+			return
+		}
+		out[i].InfoResponse = info
+	})
+	if asJSON {
+		return printJSON(out)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "AGENT\tADDRESS\tHOST\tCONTAINERS\tBACKUPS\tERROR")
+	for _, s := range out {
+		if s.InfoResponse == nil {
+			fmt.Fprintf(tw, "%s\t%s\t-\t-\t-\t%s\n", s.Agent, s.Addr, s.Error)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t-\n", s.Agent, s.Addr, s.Hostname, len(s.Containers), s.Backups)
+	}
+	return tw.Flush()
+}
+
+func (c *FleetCmd) list(ctx context.Context, agents map[string]*agent.Client, names []string, req *agent.ListRequest, asJSON bool) error {
+	lists := make([][]agent.ListEntry, len(names))
+	errs := make([]error, len(names))
+	each(names, func(i int, name string) {
+		lists[i], errs[i] = agents[name].List(ctx, req)
+	})
+	entries := []agent.ListEntry{}
+	for i, l := range lists {
+		if errs[i] != nil {
+			c.log.Errorf("%v", errs[i])
+		}
+		entries = append(entries, l...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].CreatedAt.After(entries[j].CreatedAt) })
+	if asJSON {
+		if err := printJSON(entries); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "AGENT\tFILE\tCREATED\tSOURCE\tSIZE\tTAGS")
+		for _, en := range entries {
+			source := "host " + en.Hostname
+			switch {
+			case en.ProjectName != "":
+				source = "compose " + en.ProjectName
+			case en.ContainerName != "":
+				source = "container " + strings.TrimPrefix(en.ContainerName, "/")
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", en.Agent, en.File, formatTime(en.CreatedAt), source, storage.FormatSize(en.Size), strings.Join(en.Tags, ","))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return fmt.Errorf("some agents could not be listed")
+		}
+	}
+	return nil
+}
+
+// fleetBackup is what one agent did for one container or project.
+type fleetBackup struct {
+	Agent string `json:"agent"`
+	agent.BackupResult
+}
+
+func (c *FleetCmd) backup(ctx context.Context, agents map[string]*agent.Client, names []string, req *agent.BackupRequest, asJSON bool) error {
+	results := make([][]fleetBackup, len(names))
+	each(names, func(i int, name string) {
+		res, err := agents[name].Backup(ctx, req, c.agentLog(name))
+		for _, r := range res {
+			results[i] = append(results[i], fleetBackup{Agent: name, BackupResult: r})
+		}
+		if err != nil {
+			results[i] = append(results[i], fleetBackup{Agent: name, BackupResult: agent.BackupResult{Target: "-", Error: err.Error()}})
+		}
+	})
+	var all []fleetBackup
+	failed, done := 0, 0
+	for _, rs := range results {
+		for _, r := range rs {
+			if r.Error != "" {
+				failed++
+			} else if r.Skipped == "" {
+				done++
+			}
+			all = append(all, r)
+		}
+	}
+	if asJSON {
+		if all == nil {
+			all = []fleetBackup{}
+		}
+		if err := printJSON(all); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "AGENT\tTARGET\tRESULT")
+		for _, r := range all {
+			result := r.Backup
+			switch {
+			case r.Error != "":
+				result = "failed: " + r.Error
+			case r.Skipped != "":
+				result = "skipped (" + r.Skipped + ")"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Agent, r.Target, result)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d backups failed", failed)
+	}
+	if done == 0 {
+		return fmt.Errorf("no agent had anything to back up")
+	}
+	return nil
+}
+
+func (c *FleetCmd) fetch(ctx context.Context, cl *agent.Client, file, dir string) error {
+	if file != filepath.Base(file) {
+		return fmt.Errorf("invalid archive name %q", file)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	dest := filepath.Join(dir, file)
+	tmp := dest + ".partial"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp) }()
+	n, err := cl.Fetch(ctx, file, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		return err
+	}
+	fmt.Printf("Fetched %s from %s (%s)\n", dest, cl.Name, storage.FormatSize(n))
+	return nil
+}
+
+// copyArchive streams an archive from one agent to another through the controller.
+func (c *FleetCmd) copyArchive(ctx context.Context, src, dst *agent.Client, file string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := src.Fetch(ctx, file, pw)
+		_ = pw.CloseWithError(err)
+	}()
+	c.log.Infof("Copying %s from %s to %s", file, src.Name, dst.Name)
+	res, err := dst.Push(ctx, file, pr)
+	_ = pr.Close()
+	if err != nil {
+		return err
+	}
+	c.log.Infof("Copied %s to %s (%s)", file, dst.Name, storage.FormatSize(res.Size))
+	return nil
+}
+
+// each calls fn for every agent at once and waits for them.
+func each(names []string, fn func(i int, name string)) {
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(i, name)
+		}()
+	}
+	wg.Wait()
+}
+
+// parseMappings parses old:new pairs as restore's --network-map and --volume-map do.
+func parseMappings(items []string) map[string]string {
+	m := map[string]string{}
+	for _, it := range items {
+		parts := strings.SplitN(it, ":", 2)
+		if len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+			m[parts[0]] = parts[1]
+		}
+	}
+	return m
+}
+
+func init() {
+	RegisterCommand(&FleetCmd{log: logger.New()})
+}
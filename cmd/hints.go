@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+)
+
+// remediationHint says what to do about a failure the daemon reported, or "" when it is
+// not of a known kind. The flags it suggests depend on the command that failed.
+func remediationHint(command string, err error) string {
+	de, ok := errors.AsDaemonError(err)
+	if !ok {
+		return ""
+	}
+	restoring := command == "restore" || command == "restore-compose"
+	subject := func(what string) string {
+		if de.Subject == "" {
+			return what
+		}
+		return what + " " + de.Subject
+	}
+	switch de.Kind {
+	case errors.ErrNameConflict:
+		switch command {
+		case "restore":
+			return fmt.Sprintf("%s exists: --replace removes it, --name restores under another name and --on-conflict name=rename picks a free one", subject("container"))
+		case "restore-compose":
+			return fmt.Sprintf("%s exists: --replace removes it, -p restores under another project name and --on-conflict name=rename picks free names", subject("container"))
+		case "migrate":
+			return fmt.Sprintf("%s exists on the target: --replace removes it", subject("container"))
+		}
+		return fmt.Sprintf("%s exists: remove or rename it (docker rm, docker rename)", subject("container"))
+	case errors.ErrPortAllocated:
+		if restoring {
+			return fmt.Sprintf("%s is taken: --on-conflict port=rename publishes on the next free port, --isolated publishes none", subject("host port"))
+		}
+		return fmt.Sprintf("%s is taken: stop what listens on it", subject("host port"))
+	case errors.ErrParentInterface:
+		if command == "restore" {
+			return fmt.Sprintf("the macvlan/ipvlan %s does not exist on this host: name one with --parent-map <network>:<interface>, or use --attach-to <network>", subject("parent interface"))
+		}
+		if command == "restore-compose" {
+			return fmt.Sprintf("the macvlan/ipvlan %s does not exist on this host: use --attach-to <network> or create the network beforehand", subject("parent interface"))
+		}
+		return fmt.Sprintf("the macvlan/ipvlan %s does not exist on this host", subject("parent interface"))
+	case errors.ErrNoSpace:
+		if restoring {
+			return "a disk is full: free space where the daemon keeps images and volumes (docker system df, docker system prune) and in the temporary directory ($TMPDIR, or extract elsewhere with --work-dir), or check the volume's own size limit"
+		}
+		return "a disk is full: free space in the output and temporary ($TMPDIR) directories and where the daemon keeps its data (docker system df), or put the temporary files on a larger disk with --work-dir"
+	}
+	return ""
+}
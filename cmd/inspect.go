@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/spf13/pflag"
+)
+
+type InspectCmd struct {
+	log logger.Logger
+}
+
+func (c *InspectCmd) Name() string { return "inspect" }
+
+func (c *InspectCmd) Help() string {
+	return `
+Show the identity and lineage of a backup, or which backup a restored container came from.
+
+Usage:
+  dockerbackup inspect <backup_file>
+  dockerbackup inspect <container_id_or_name>
+
+Options:
+      --json   Print JSON
+
+For a backup, prints its ID, source host and the chain of parent backups (incremental
+--skip-unchanged backups) found next to it. For a container restored by dockerbackup, prints
+the backup ID, archive and time recorded in its labels.
+`
+}
+
+func (c *InspectCmd) Validate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing backup file or container")
+	}
+	return nil
+}
+
+func (c *InspectCmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var asJSON bool
+	fs.BoolVar(&asJSON, "json", false, "Print JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	remaining := fs.Args()
+	if len(remaining) == 0 {
+		return fmt.Errorf("missing backup file or container")
+	}
+	target := remaining[0]
+	if _, err := os.Stat(target); err != nil {
+		return c.inspectContainer(ctx, target, asJSON)
+	}
+
+	chain, err := backup.Lineage(ctx, target)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(chain)
+	}
+	info := chain[0]
+	fmt.Printf("ID:        %s\n", orNone(info.ID))
+	fmt.Printf("Created:   %s\n", formatTime(info.CreatedAt))
+	switch {
+	case info.ProjectName != "":
+		fmt.Printf("Source:    compose project %s\n", info.ProjectName)
+	case info.ContainerName != "":
+		fmt.Printf("Source:    container %s\n", info.ContainerName)
+	}
+	if info.Hostname != "" || info.HostID != "" {
+		fmt.Printf("Host:      %s (%s)\n", orNone(info.Hostname), orNone(info.HostID))
+	}
+	if len(info.Tags) > 0 {
+		fmt.Printf("Tags:      %s\n", strings.Join(info.Tags, ", "))
+	}
+	if info.Note != "" {
+		fmt.Printf("Note:      %s\n", info.Note)
+	}
+	if info.ParentID != "" {
+		fmt.Printf("Parent:    %s %s\n", info.ParentID, info.Parent)
+	}
+	for _, ref := range info.VolumeRefs {
+		fmt.Printf("Depends:   %s\n", ref)
+	}
+	if len(chain) > 1 || info.ParentID != "" {
+		fmt.Println("Lineage:")
+		file := filepath.Base(target)
+		for _, b := range chain {
+			fmt.Printf("  %s  %s  %s\n", orNone(b.ID), formatTime(b.CreatedAt), file)
+			file = b.Parent
+		}
+		if last := chain[len(chain)-1]; last.ParentID != "" {
+			fmt.Printf("  %s  (not found next to %s)\n", last.ParentID, filepath.Base(target))
+		}
+	}
+	return nil
+}
+
+func (c *InspectCmd) inspectContainer(ctx context.Context, ref string, asJSON bool) error {
+	b, err := docker.NewCLIClient().InspectContainer(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("%s is neither a backup file nor a container: %w", ref, err)
+	}
+	cj, err := docker.ParseContainerJSON(b)
+	if err != nil {
+		return err
+	}
+	var labels map[string]string
+	if cj.Config != nil {
+		labels = cj.Config.Labels
+	}
+	if labels[backup.LabelRestored] != "true" {
+		return fmt.Errorf("container %s was not restored by dockerbackup", ref)
+	}
+	origin := map[string]string{
+		"backupId":   labels[backup.LabelBackupID],
+		"backupFile": labels[backup.LabelBackupFile],
+		"restoredAt": labels[backup.LabelRestoredAt],
+	}
+	if asJSON {
+		return printJSON(origin)
+	}
+	fmt.Printf("Backup ID:   %s\n", orNone(origin["backupId"]))
+	fmt.Printf("Backup file: %s\n", orNone(origin["backupFile"]))
+	fmt.Printf("Restored at: %s\n", orNone(origin["restoredAt"]))
+	return nil
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func init() {
+	RegisterCommand(&InspectCmd{log: logger.New()})
+}
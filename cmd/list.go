@@ -20,7 +20,13 @@ func (c *ListCmd) Help() string {
 List the contents of a backup archive.
 
 Usage:
-  dockerbackup list <backup_file>
+  dockerbackup list <backup_file> [options]
+
+Options:
+  -l, --long   Show type, mode and size of each entry
+
+Backups carry an index of their contents, so listing reads only the start of the archive;
+older backups without one are scanned in full.
 `
 }
 
@@ -33,6 +39,8 @@ func (c *ListCmd) Validate(args []string) error {
 
 func (c *ListCmd) Execute(ctx context.Context, args []string) error {
 	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var long bool
+	fs.BoolVarP(&long, "long", "l", false, "Show type, mode and size of each entry")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
@@ -43,14 +51,15 @@ func (c *ListCmd) Execute(ctx context.Context, args []string) error {
 	backupFile := remaining[0]
 
 	h := archive.NewTarArchiveHandler()
-	entries, err := h.ListArchive(ctx, backupFile)
-	if err != nil {
-		return err
-	}
-	for _, e := range entries {
+	// printed as they are read, so archives with millions of entries list in constant memory
+	return h.WalkArchive(ctx, backupFile, func(e archive.ArchiveEntry) error {
+		if long {
+			fmt.Printf("%-7s %04o %12d  %s\n", e.Type, e.Mode&0o7777, e.Size, e.Path)
+			return nil
+		}
 		fmt.Printf("%s\n", e.Path)
-	}
-	return nil
+		return nil
+	})
 }
 
 func init() {
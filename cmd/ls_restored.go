@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/spf13/pflag"
+)
+
+type LsRestoredCmd struct {
+	log logger.Logger
+}
+
+func (c *LsRestoredCmd) Name() string { return "ls-restored" }
+
+func (c *LsRestoredCmd) Help() string {
+	return `
+List containers, volumes, networks and images created by restores.
+
+Usage:
+  dockerbackup ls-restored [options]
+
+Options:
+      --backup-id string  Only list resources restored from this backup
+      --json              Print the resources and their labels as JSON
+
+Restored resources carry the labels dockerbackup.restored=true, dockerbackup.backup-id,
+dockerbackup.backup-file and dockerbackup.restored-at.
+`
+}
+
+func (c *LsRestoredCmd) Validate(args []string) error { return nil }
+
+func (c *LsRestoredCmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var backupID string
+	var asJSON bool
+	fs.StringVar(&backupID, "backup-id", "", "Only list resources restored from this backup")
+	fs.BoolVar(&asJSON, "json", false, "Print JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	label := backup.LabelRestored + "=true"
+	if backupID != "" {
+		label = backup.LabelBackupID + "=" + backupID
+	}
+	res, err := docker.NewCLIClient().ListResourcesByLabel(ctx, label)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Labels[backup.LabelRestoredAt] < res[j].Labels[backup.LabelRestoredAt]
+	})
+	if asJSON {
+		if res == nil {
+			res = []docker.LabeledResource{}
+		}
+		return printJSON(res)
+	}
+	if len(res) == 0 {
+		fmt.Println("No restored resources found")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAME\tBACKUP ID\tBACKUP FILE\tRESTORED AT")
+	for _, r := range res {
+		name := r.Name
+		if name == "" {
+			name = r.ID
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Kind, name, r.Labels[backup.LabelBackupID], r.Labels[backup.LabelBackupFile], r.Labels[backup.LabelRestoredAt])
+	}
+	return tw.Flush()
+}
+
+func init() {
+	RegisterCommand(&LsRestoredCmd{log: logger.New()})
+}
@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/audit"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/remote"
+	"github.com/spf13/pflag"
+)
+
+type MigrateCmd struct {
+	log    logger.Logger
+	engine backup.BackupEngine
+}
+
+func (c *MigrateCmd) Name() string { return "migrate" }
+
+func (c *MigrateCmd) Help() string {
+	return `
+Move a container to another host: backup, upload over ssh, restore remotely, verify and
+retire the source.
+
+Usage:
+  dockerbackup migrate <container_id_or_name> --to ssh://[user@]host[:port] [options] [-- restore flags]
+
+Options:
+      --to string             Target host (required); uses the local ssh client and config
+  -n, --name string           Container name on the target (default: original)
+      --network-map old:new   Forwarded to restore (repeatable)
+      --volume-map old:new    Forwarded to restore (repeatable)
+      --replace               Replace an existing container with the same name on the target
+      --remote-dir string     Directory for the uploaded archive on the target (default: /tmp)
+      --remote-bin string     dockerbackup binary on the target (default: dockerbackup)
+      --stop-first            Stop the source before the backup for a consistent copy
+      --verify                Wait until the migrated container is running (and healthy, if
+                              it has a healthcheck) before retiring the source
+      --wait-timeout int      Seconds to wait with --verify (default: 120)
+      --keep-source           Leave the source container running
+      --remove-source         Remove the source container after a successful migration
+  -y, --yes, --force          Do not ask to confirm --replace and --remove-source, and let the
+                              remote restore replace and overwrite what its conflict policies
+                              say (it cannot ask: it has no terminal)
+
+Arguments after -- are passed to the remote restore unchanged. Without --keep-source the
+source container is stopped once the target is up.
+`
+}
+
+func (c *MigrateCmd) Validate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing container id or name")
+	}
+	return nil
+}
+
+func (c *MigrateCmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var to, name, remoteDir, remoteBin string
+	var netMaps, volMaps []string
+	var replace, stopFirst, verify, keepSource, removeSource bool
+	var waitTimeout int
+	fs.StringVar(&to, "to", "", "Target host (ssh://[user@]host[:port])")
+	fs.StringVarP(&name, "name", "n", "", "Container name on the target")
+	fs.StringArrayVar(&netMaps, "network-map", nil, "Network mapping old:new (repeatable)")
+	fs.StringArrayVar(&volMaps, "volume-map", nil, "Volume mapping old:new (repeatable)")
+	fs.BoolVar(&replace, "replace", false, "Replace an existing container on the target")
+	fs.StringVar(&remoteDir, "remote-dir", "/tmp", "Upload directory on the target")
+	fs.StringVar(&remoteBin, "remote-bin", "dockerbackup", "dockerbackup binary on the target")
+	fs.BoolVar(&stopFirst, "stop-first", false, "Stop the source before backup")
+	fs.BoolVar(&verify, "verify", false, "Verify the migrated container is running/healthy")
+	fs.IntVar(&waitTimeout, "wait-timeout", 120, "Seconds to wait with --verify")
+	fs.BoolVar(&keepSource, "keep-source", false, "Leave the source container running")
+	fs.BoolVar(&removeSource, "remove-source", false, "Remove the source container afterwards")
+	confirm := confirmFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	positional, passthrough := fs.Args(), []string(nil)
+	if dash := fs.ArgsLenAtDash(); dash >= 0 {
+		positional, passthrough = fs.Args()[:dash], fs.Args()[dash:]
+	}
+	if len(positional) == 0 {
+		return fmt.Errorf("missing container id or name")
+	}
+	if to == "" {
+		return fmt.Errorf("--to is required")
+	}
+	if keepSource && removeSource {
+		return fmt.Errorf("--keep-source and --remove-source are mutually exclusive")
+	}
+	target, err := remote.ParseSSHURL(to)
+	if err != nil {
+		return err
+	}
+	source := positional[0]
+
+	dc := docker.NewCLIClient()
+	inspect, err := dc.InspectContainer(ctx, source)
+	if err != nil {
+		return err
+	}
+	info, err := docker.ParseContainerInfo(inspect)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = strings.TrimPrefix(info.Name, "/")
+	}
+	// confirmed here, the remote restore (which cannot ask) is told to go ahead
+	confirmed := confirm() == backup.ConfirmYes
+	if replace || removeSource {
+		var what []string
+		if replace {
+			what = append(what, fmt.Sprintf("Container %s on %s will be replaced, and the data of its volumes there overwritten", name, target.Host))
+		}
+		if removeSource {
+			what = append(what, fmt.Sprintf("Source container %s will be removed", source))
+		}
+		if err := confirm().Confirm(strings.Join(what, "; ")); err != nil {
+			return err
+		}
+		confirmed = true
+	}
+
+	if stopFirst {
+		c.log.Infof("Stopping source container %s", source)
+		if err := dc.StopContainer(ctx, info.ID); err != nil {
+			return err
+		}
+	}
+	migrated := false
+	defer func() {
+		if stopFirst && !migrated {
+			c.log.Infof("Migration failed; restarting source container %s", source)
+			_ = dc.StartContainer(context.Background(), info.ID)
+		}
+	}()
+
+	// 1. Backup locally
+	workDir, err := os.MkdirTemp(backup.WorkDir(), "dockerbackup_migrate_*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(workDir) }()
+	archiveName := fmt.Sprintf("%s_migrate_%d.tar.gz", name, time.Now().Unix())
+	localPath := filepath.Join(workDir, archiveName)
+	if c.engine == nil {
+		c.engine = newDefaultEngine(c.log)
+	}
+	if _, err := c.engine.Backup(ctx, backup.BackupRequest{
+		TargetType:  backup.TargetContainer,
+		ContainerID: info.ID,
+		Options:     backup.NewBackupOptionsBuilder().WithOutput(localPath).Build(),
+	}); err != nil {
+		return err
+	}
+
+	// 2. Stream to the target
+	remotePath := strings.TrimRight(remoteDir, "/") + "/" + archiveName
+	c.log.Infof("Uploading backup to %s:%s", target.Host, remotePath)
+	if err := target.Upload(ctx, localPath, remotePath); err != nil {
+		return err
+	}
+	defer func() {
+		if err := target.Run(context.Background(), "rm", "-f", remotePath); err != nil {
+			c.log.Infof("Could not remove %s on %s: %v", remotePath, target.Host, err)
+		}
+	}()
+
+	// 3. Restore remotely
+	restoreArgs := []string{remoteBin, "restore", remotePath, "--name", name, "--start"}
+	for _, m := range netMaps {
+		restoreArgs = append(restoreArgs, "--network-map", m)
+	}
+	for _, m := range volMaps {
+		restoreArgs = append(restoreArgs, "--volume-map", m)
+	}
+	if replace {
+		restoreArgs = append(restoreArgs, "--replace")
+	}
+	if confirmed {
+		restoreArgs = append(restoreArgs, "--yes")
+	}
+	restoreArgs = append(restoreArgs, passthrough...)
+	c.log.Infof("Restoring %s on %s", name, target)
+	if err := target.Run(ctx, restoreArgs...); err != nil {
+		return err
+	}
+
+	// 4. Verify before touching the source
+	if verify {
+		if err := waitRemoteReady(ctx, target, name, time.Duration(waitTimeout)*time.Second); err != nil {
+			return fmt.Errorf("migrated container %s on %s is not ready; source left untouched: %w", name, target.Host, err)
+		}
+		c.log.Infof("Container %s is up on %s", name, target.Host)
+	}
+
+	// 5. Retire the source
+	migrated = true
+	switch {
+	case keepSource:
+		c.log.Infof("Leaving source container %s running (--keep-source)", source)
+	case removeSource:
+		rec, err := audit.Begin(c.Name(), source, args)
+		if err != nil {
+			return fmt.Errorf("source container %s not removed: %w", source, err)
+		}
+		rec.Details = []string{"removed container " + source + " (" + info.ID + ") after migrating it to " + target.Host}
+		c.log.Infof("Removing source container %s", source)
+		if err := rec.End(dc.RemoveContainer(ctx, info.ID)); err != nil {
+			return err
+		}
+	default:
+		if !stopFirst {
+			c.log.Infof("Stopping source container %s", source)
+			if err := dc.StopContainer(ctx, info.ID); err != nil {
+				return err
+			}
+		}
+	}
+	c.log.Infof("Migrated %s to %s", source, target)
+	return nil
+}
+
+// waitRemoteReady polls the container state on the target until it is running and, when it
+// defines a healthcheck, healthy.
+func waitRemoteReady(ctx context.Context, target *remote.SSHTarget, name string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	last := ""
+	for {
+		out, err := target.Output(ctx, "docker", "inspect", "-f", "{{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{end}}", name)
+		if err == nil {
+			fields := strings.Fields(out)
+			last = strings.Join(fields, "/")
+			if len(fields) > 0 && fields[0] == "running" && (len(fields) == 1 || fields[1] == "healthy") {
+				return nil
+			}
+			if len(fields) > 0 && (fields[0] == "exited" || fields[0] == "dead") {
+				return fmt.Errorf("container %s", fields[0])
+			}
+			if len(fields) > 1 && fields[1] == "unhealthy" {
+				return fmt.Errorf("container unhealthy")
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s (last state %q)", timeout, last)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func init() {
+	RegisterCommand(&MigrateCmd{log: logger.New()})
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/audit"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/spf13/pflag"
+)
+
+type PromoteCmd struct {
+	log logger.Logger
+}
+
+func (c *PromoteCmd) Name() string { return "promote" }
+
+func (c *PromoteCmd) Help() string {
+	return `
+Put containers restored with --isolated into service.
+
+Usage:
+  dockerbackup promote <container>... [options]
+
+Options:
+      --name string      Name the promoted container takes (default: its name in the backup;
+                         only with one container)
+      --remove-replaced  Remove the container it replaces instead of keeping it stopped
+      --json             Print what was done for each container as JSON
+  -y, --yes, --force     Replace the container holding the name without asking to confirm
+
+Blue/green restores: restore the backup next to production with --isolated --name web-green,
+check it, then 'promote web-green'. A copy with the networks and published ports it was
+restored without (recorded in its dockerbackup.isolated label) is created first; then the
+isolated container and the container holding the name are stopped, that one is renamed to
+<name>_replaced and the copy takes the name and is started if either was running. If a step
+fails, the copy is removed and the replaced container gets its name back and is restarted.
+
+Volumes are kept; changes made to the isolated container's own filesystem are not. The
+isolated network is removed once no container uses it, so promote every service of an
+isolated compose restore to drop it.
+`
+}
+
+func (c *PromoteCmd) Validate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing container name")
+	}
+	return nil
+}
+
+func (c *PromoteCmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var opts backup.PromoteOptions
+	var asJSON bool
+	fs.StringVar(&opts.Name, "name", "", "Name the promoted container takes")
+	fs.BoolVar(&opts.RemoveReplaced, "remove-replaced", false, "Remove the replaced container")
+	fs.BoolVar(&asJSON, "json", false, "Print JSON")
+	confirm := confirmFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	opts.Confirm = confirm()
+	if fs.NArg() == 0 {
+		return fmt.Errorf("missing container name")
+	}
+	if opts.Name != "" && fs.NArg() > 1 {
+		return fmt.Errorf("--name can only be given when promoting one container")
+	}
+	dc := newDockerClient()
+	var results []*backup.PromoteResult
+	for _, name := range fs.Args() {
+		rec, err := audit.Begin(c.Name(), name, args)
+		if err != nil {
+			return err
+		}
+		res, err := backup.Promote(ctx, dc, c.log, name, opts)
+		if res != nil {
+			switch {
+			case res.ReplacedRemoved:
+				rec.Details = append(rec.Details, "removed container "+res.Replaced)
+			case res.Replaced != "":
+				rec.Details = append(rec.Details, "stopped container kept as "+res.Replaced)
+			}
+			if res.NetworkRemoved != "" {
+				rec.Details = append(rec.Details, "removed network "+res.NetworkRemoved)
+			}
+		}
+		if err := rec.End(err); err != nil {
+			return err
+		}
+		results = append(results, res)
+		if asJSON {
+			continue
+		}
+		state := "created"
+		if res.Started {
+			state = "started"
+		}
+		fmt.Printf("Promoted %s as %s (%s)\n", name, res.Container, state)
+		switch {
+		case res.ReplacedRemoved:
+			fmt.Printf("Removed the replaced container\n")
+		case res.Replaced != "":
+			fmt.Printf("Replaced container kept stopped as %s\n", res.Replaced)
+		}
+		if res.NetworkRemoved != "" {
+			fmt.Printf("Removed isolated network %s\n", res.NetworkRemoved)
+		}
+	}
+	if asJSON {
+		return printJSON(results)
+	}
+	return nil
+}
+
+func init() {
+	RegisterCommand(&PromoteCmd{log: logger.New()})
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/audit"
 	"github.com/brian033/dockerbackup/pkg/backup"
 	"github.com/spf13/pflag"
 )
@@ -23,11 +24,84 @@ func (c *RestoreCmd) Help() string {
 Restore a container from a backup file.
 
 Usage:
-  dockerbackup restore <backup_file> [options]
+  dockerbackup restore <backup_file|backup_dir> [options]
+
+A directory selects the newest valid backup in it. Container and compose backups are told
+apart automatically; compose backups are restored like restore-compose with default options.
+
+Point-in-time restore from a directory of (timestamped) backups:
+  dockerbackup restore /backups --target web --at "2024-06-01 03:00"
 
 Options:
+  --type string       Backup type: auto, container or compose (default: auto)
+  --target name       With a backup directory, only consider backups of this container or
+                      compose project
+  --at time           With a backup directory, pick the newest backup taken at or before this
+                      local time ("2024-06-01 03:00", RFC 3339, or a date for the end of that
+                      day). Incremental backups whose referenced archives are missing are
+                      passed over
   -n, --name string   New container name (default: original)
   --start             Start container after restore
+  --create-only       Create the container without starting it, to review it with docker
+                      inspect before running it (docker start)
+  --paused            Start the container and pause it right away (docker unpause resumes it)
+  --isolated          Attach the container only to an internal network <name>_isolated and
+                      publish no ports, so it cannot act on production until 'promote'
+  --lenient           Warn instead of failing when restored volume data does not match the
+                      content digest recorded at backup time
+  --checkpoint        Resume the process state from the CRIU checkpoint stored in the backup
+  --image-override repo:tag
+                      Create the container from this image (pulled if missing) instead of the
+                      embedded one, keeping config, volumes and networks; changes made to the
+                      container's own filesystem are not restored
+  --install-plugins   Install missing volume driver plugins (e.g. rexray/ebs) recorded in the
+                      backup before creating its volumes
+  --volumes-only name Restore only the data of this named volume (repeatable), creating the
+                      volume if needed; no container is created. Only the volume's part of
+                      the archive is read. Combine with --volume-map old:new to restore
+                      under another name
+  --skip-existing     Succeed without changes when a container with the target name was
+                      already restored from this backup, so re-running a restore is safe
+  --no-overwrite-volumes
+                      Keep volumes that already hold data instead of extracting the backup
+                      into them
+  --data-refresh      Keep the existing container(s) as they are: stop them, replace the
+                      contents of their named volumes with the backup's data and start them
+                      again ("restore last night's data"). Works for compose backups too
+  --uid-map old:new   Give restored volume and bind mount files owned by user ID old to new
+                      instead (repeatable), for hosts that number users differently or
+                      daemons running with userns-remap
+  --gid-map old:new   The same for group IDs
+  --ip-map old:new    Give the container static address new where the backup has old
+                      (repeatable, IPv4 or IPv6). Saved static addresses outside the target
+                      network's subnets or held by another container are dropped with a
+                      warning and Docker assigns one
+  --on-conflict policy
+                      What to do about a container name, host port, network or volume (with
+                      data) that already exists: fail, skip, rename, replace or prompt.
+                      kind=policy sets one kind (name, port, network, volume; repeatable).
+                      Defaults: name=fail, volume=replace, network=skip (reuse); ports are
+                      only checked when a policy is given. --replace is name=replace and
+                      --no-overwrite-volumes volume=skip
+  -y, --yes, --force  Replace containers and networks and overwrite volume data as the
+                      conflict policies say without asking first. Otherwise each is
+                      confirmed on the terminal, and the restore fails without one
+  --attach-to network Create no networks from the backup and attach the container to this
+                      existing network instead, with the aliases and links of all its saved
+                      networks. Static IPs are moved into the network's subnet, keeping
+                      their host part (172.18.0.5 in 172.18.0.0/16 -> 10.5.0.5 in
+                      10.5.0.0/16), or dropped when they do not fit
+  --helper-image ref  Image of the helper containers writing volume data; any image with sh,
+                      tar, cp and find, e.g. busybox loaded on an offline host (default:
+                      alpine:3.19, or $DOCKERBACKUP_HELPER_IMAGE)
+  --op-timeout dur    Kill a docker command that hangs: one that streams data (load, volume
+                      helpers) once it made no progress for dur, any other once it ran for
+                      dur (default: 10m, or $DOCKERBACKUP_OP_TIMEOUT; 0 disables)
+  --work-dir dir      Directory the backup is extracted into (default: $TMPDIR, or
+                      $DOCKERBACKUP_WORK_DIR); fails early when it has less space free than the
+                      archive takes
+  --json              Print the restored container ID and the warnings (dropped settings,
+                      remapped log drivers, ...) as JSON
 `
 }
 
@@ -42,7 +116,12 @@ func (c *RestoreCmd) Execute(ctx context.Context, args []string) error {
 	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
 	var name string
 	var start bool
+	var createOnly bool
+	var paused bool
+	var isolated bool
+	var lenient bool
 	var netMaps []string
+	var volMaps []string
 	var parentMaps []string
 	var dropHostIPs bool
 	var reassignIPs bool
@@ -57,10 +136,38 @@ func (c *RestoreCmd) Execute(ctx context.Context, args []string) error {
 	var dropCaps bool
 	var dropSeccomp bool
 	var dropAppArmor bool
+	var loadAppArmor bool
 	var autoRelaxIPs bool
+	var ipMaps []string
+	var attachTo string
+	var onConflict []string
+	var preserveMAC bool
+	var checkpoint bool
+	var strictHostConfig bool
+	var logDriverMaps []string
+	var defaultLogDriver string
+	var dropGPUs bool
+	var gpuMaps []string
+	var imageOverride string
+	var installPlugins bool
+	var volumesOnly []string
+	var skipExisting bool
+	var noOverwriteVolumes bool
+	var dataRefresh bool
+	var uidMaps []string
+	var gidMaps []string
+	var asJSON bool
+	var targetName string
+	var at string
+	var targetType string
 	fs.StringVarP(&name, "name", "n", "", "New container name")
 	fs.BoolVar(&start, "start", false, "Start container after restore")
+	fs.BoolVar(&createOnly, "create-only", false, "Create the container without starting it")
+	fs.BoolVar(&paused, "paused", false, "Start the container paused")
+	fs.BoolVar(&isolated, "isolated", false, "Attach only to an internal network, publish no ports")
+	fs.BoolVar(&lenient, "lenient", false, "Warn instead of failing on volume content mismatches")
 	fs.StringArrayVar(&netMaps, "network-map", nil, "Map networks old:new (repeatable)")
+	fs.StringArrayVar(&volMaps, "volume-map", nil, "Map named volumes old:new (repeatable)")
 	fs.StringArrayVar(&parentMaps, "parent-map", nil, "Override macvlan/ipvlan parent: network:parentIf (repeatable)")
 	fs.BoolVar(&dropHostIPs, "drop-host-ips", false, "Ignore HostIp in port bindings if not present on host")
 	fs.BoolVar(&reassignIPs, "reassign-ips", false, "Ignore saved static container IPs; let Docker assign")
@@ -75,15 +182,93 @@ func (c *RestoreCmd) Execute(ctx context.Context, args []string) error {
 	fs.BoolVar(&dropCaps, "drop-caps", false, "Drop HostConfig.CapAdd/CapDrop on restore (safe mode)")
 	fs.BoolVar(&dropSeccomp, "drop-seccomp", false, "Drop HostConfig.SecurityOpt seccomp profile (safe mode)")
 	fs.BoolVar(&dropAppArmor, "drop-apparmor", false, "Drop HostConfig.SecurityOpt apparmor profile (safe mode)")
+	fs.BoolVar(&loadAppArmor, "load-apparmor-profiles", false, "Install and load captured AppArmor profiles missing on this host (runs apparmor_parser; asks first unless --yes)")
 	fs.BoolVar(&autoRelaxIPs, "auto-relax-ips", false, "If container has static IPs conflicting with host networks, drop IPAM to let Docker assign")
+	fs.StringArrayVar(&ipMaps, "ip-map", nil, "Use static container IP new instead of the saved old: old:new (repeatable)")
+	fs.StringArrayVar(&onConflict, "on-conflict", nil, "Policy for existing containers, ports, networks and volumes: fail, skip, rename, replace or prompt, or kind=policy (repeatable)")
+	fs.StringVar(&attachTo, "attach-to", "", "Attach the container to this existing network instead of recreating the saved ones")
+	fs.BoolVar(&preserveMAC, "preserve-mac", false, "Keep the per-network static MAC addresses from the backup")
+	fs.BoolVar(&checkpoint, "checkpoint", false, "Resume from the CRIU checkpoint stored in the backup")
+	fs.BoolVar(&strictHostConfig, "strict-hostconfig", false, "Fail if any HostConfig field (restart policy, limits, ulimits, log opts) is not applied by the target daemon")
+	fs.StringArrayVar(&logDriverMaps, "log-driver-map", nil, "Map log drivers old:new (repeatable)")
+	fs.StringVar(&defaultLogDriver, "default-log-driver", "", "Log driver to use when the saved one is not available on this host")
+	fs.BoolVar(&dropGPUs, "drop-gpus", false, "Drop GPU device requests (--gpus) and the nvidia runtime on restore")
+	fs.StringArrayVar(&gpuMaps, "gpu-map", nil, "Map GPU device IDs old:new, by index or UUID (repeatable)")
+	fs.StringVar(&targetType, "type", "auto", "Backup type: auto, container or compose")
+	fs.StringVar(&imageOverride, "image-override", "", "Use this image (repo:tag) instead of the one in the backup")
+	fs.BoolVar(&installPlugins, "install-plugins", false, "Install missing volume driver plugins recorded in the backup")
+	fs.StringArrayVar(&volumesOnly, "volumes-only", nil, "Restore only the data of this named volume (repeatable)")
+	fs.BoolVar(&skipExisting, "skip-existing", false, "Do nothing if the container was already restored from this backup")
+	fs.BoolVar(&noOverwriteVolumes, "no-overwrite-volumes", false, "Leave volumes that already hold data untouched")
+	fs.BoolVar(&dataRefresh, "data-refresh", false, "Replace the volume data of the existing container(s), keeping their definition")
+	fs.StringArrayVar(&uidMaps, "uid-map", nil, "Map owner user IDs of restored files old:new (repeatable)")
+	fs.StringArrayVar(&gidMaps, "gid-map", nil, "Map owner group IDs of restored files old:new (repeatable)")
+	applyClientFlags := dockerClientFlags(fs)
+	fs.BoolVar(&asJSON, "json", false, "Print the result and warnings as JSON")
+	fs.StringVar(&targetName, "target", "", "In a backup directory, only consider backups of this container or compose project")
+	fs.StringVar(&at, "at", "", "In a backup directory, pick the newest backup taken at or before this time")
+	confirm := confirmFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
+	if err := applyClientFlags(); err != nil {
+		return err
+	}
 	remaining := fs.Args()
 	if len(remaining) == 0 {
 		return fmt.Errorf("missing backup file path")
 	}
-	backupFile := remaining[0]
+	uidMap, err := parseIDMap("uid-map", uidMaps)
+	if err != nil {
+		return err
+	}
+	gidMap, err := parseIDMap("gid-map", gidMaps)
+	if err != nil {
+		return err
+	}
+	ipMap, err := parseIPMap(ipMaps)
+	if err != nil {
+		return err
+	}
+	conflicts, err := parseOnConflict(onConflict)
+	if err != nil {
+		return err
+	}
+	var target backup.BackupTargetType
+	switch targetType {
+	case "auto", "":
+	case string(backup.TargetContainer), string(backup.TargetCompose):
+		target = backup.BackupTargetType(targetType)
+	default:
+		return fmt.Errorf("invalid --type %q (want auto, container or compose)", targetType)
+	}
+	if c.engine == nil {
+		c.engine = newDefaultEngine(c.log)
+	}
+	fetched, cleanup, err := fetchBackup(ctx, c.log, remaining[0])
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	valid := func(ctx context.Context, path string) bool {
+		if target != backup.TargetContainer && isComposeBackup(ctx, path) {
+			return target == "" || target == backup.TargetCompose
+		}
+		if target == backup.TargetCompose {
+			return false
+		}
+		res, err := c.engine.Validate(ctx, path)
+		return err == nil && res != nil && res.Valid
+	}
+	var backupFile string
+	if targetName != "" || at != "" {
+		backupFile, err = resolveBackupAt(ctx, c.log, fetched, targetName, at, valid)
+	} else {
+		backupFile, err = resolveBackupFile(ctx, c.log, fetched, valid)
+	}
+	if err != nil {
+		return err
+	}
 
 	parseMap := func(items []string) map[string]string {
 		m := map[string]string{}
@@ -101,7 +286,12 @@ func (c *RestoreCmd) Execute(ctx context.Context, args []string) error {
 		Options: backup.RestoreOptions{
 			ContainerName:      name,
 			Start:              start,
+			CreateOnly:         createOnly,
+			Paused:             paused,
+			Isolated:           isolated,
+			Lenient:            lenient,
 			NetworkMap:         parseMap(netMaps),
+			VolumeMap:          parseMap(volMaps),
 			ParentMap:          parseMap(parentMaps),
 			DropHostIPs:        dropHostIPs,
 			ReassignIPs:        reassignIPs,
@@ -116,15 +306,42 @@ func (c *RestoreCmd) Execute(ctx context.Context, args []string) error {
 			DropCaps:           dropCaps,
 			DropSeccomp:        dropSeccomp,
 			DropAppArmor:       dropAppArmor,
+			LoadAppArmorProfiles: loadAppArmor,
 			AutoRelaxIPs:      autoRelaxIPs,
+			IPMap:              ipMap,
+			AttachTo:           attachTo,
+			OnConflict:         conflicts,
+			PreserveMAC:        preserveMAC,
+			StrictHostConfig:   strictHostConfig,
+			LogDriverMap:       parseMap(logDriverMaps),
+			DefaultLogDriver:   defaultLogDriver,
+			DropGPUs:           dropGPUs,
+			GPUMap:             parseMap(gpuMaps),
+			Checkpoint:         checkpoint,
+			ImageOverride:      imageOverride,
+			InstallPlugins:     installPlugins,
+			VolumesOnly:        volumesOnly,
+			SkipExisting:       skipExisting,
+			NoOverwriteVolumes: noOverwriteVolumes,
+			DataRefresh:        dataRefresh,
+			UIDMap:             uidMap,
+			GIDMap:             gidMap,
+			Confirm:            confirm(),
 		},
-		TargetType: backup.TargetContainer,
+		TargetType: target,
 	}
-	if c.engine == nil {
-		c.engine = newDefaultEngine(c.log)
+	rec, err := audit.Begin(c.Name(), backupFile, args)
+	if err != nil {
+		return err
+	}
+	res, err := c.engine.Restore(ctx, req)
+	if res != nil {
+		rec.Details = audit.Conflicts(res.Warnings)
+	}
+	if err := rec.End(err); err != nil || !asJSON {
+		return err
 	}
-	_, err := c.engine.Restore(ctx, req)
-	return err
+	return printJSON(res)
 }
 
 func init() {
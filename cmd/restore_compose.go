@@ -3,8 +3,12 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/audit"
 	"github.com/brian033/dockerbackup/pkg/backup"
 	"github.com/spf13/pflag"
 )
@@ -21,11 +25,53 @@ func (c *RestoreComposeCmd) Help() string {
 Restore a Docker Compose project from a backup file.
 
 Usage:
-  dockerbackup restore-compose <backup_file> [options]
+  dockerbackup restore-compose <backup_file|backup_dir> [options]
+
+A directory selects the newest valid compose backup in it.
 
 Options:
-  -p, --project-name string  New project name (default: original)
-  --start                    Start services after restore
+  -p, --project-name string  New project name (default: original); renames prefixed
+                             containers, networks, volumes and compose project labels
+  --start                    Start services after restore, honoring depends_on conditions
+                             (service_healthy, service_completed_successfully)
+  --create-only              Create the services without starting them, to review them with
+                             docker inspect before running them
+  --paused                   Start the services, then pause them all (docker unpause resumes)
+  --isolated                 Attach the services only to an internal network <project>_isolated
+                             and publish no ports, until each is promoted with 'promote'
+  --lenient                  Warn instead of failing when restored volume data does not match
+                             the content digest recorded at backup time
+  --wait-healthy             Also wait for every service to report healthy
+  --wait-timeout int         Seconds to wait per service (default: 120)
+  --service-timeout svc:sec  Per-service wait timeout override (repeatable)
+  --compose-up               Write compose files to --compose-dir and run 'docker compose up -d'
+  --compose-dir string       Target directory for --compose-up (default: ./<project>)
+  --replace                  Overwrite existing compose files in --compose-dir
+  --install-plugins          Install missing volume driver plugins recorded in the backup
+  --skip-existing            Leave service containers already restored from this backup as
+                             they are, so re-running a restore is safe
+  --no-overwrite-volumes     Keep volumes that already hold data instead of extracting into them
+  --uid-map old:new          Give restored volume and bind mount files owned by user ID old
+                             to new instead (repeatable)
+  --gid-map old:new          The same for group IDs
+  --on-conflict policy       What to do about existing container names, ports, networks and
+                             volumes: fail, skip, rename, replace or prompt, or kind=policy
+                             (repeatable; see restore)
+  -y, --yes, --force         Replace containers, networks and compose files and overwrite
+                             volume data without asking first; otherwise each is confirmed on
+                             the terminal, and the restore fails without one
+  --attach-to network        Create no networks from the backup and attach every service to
+                             this existing network instead, with its aliases merged; static
+                             IPs are moved into its subnet. Not with --compose-up
+  --helper-image ref         Image of the helper containers writing volume data (default:
+                             alpine:3.19, or $DOCKERBACKUP_HELPER_IMAGE)
+  --op-timeout dur           Kill a docker command that hangs: one that streams data once it
+                             made no progress for dur, any other once it ran for dur (default:
+                             10m, or $DOCKERBACKUP_OP_TIMEOUT; 0 disables)
+  --work-dir dir             Directory the backup is extracted into (default: $TMPDIR, or
+                             $DOCKERBACKUP_WORK_DIR); fails early when it has less space free
+                             than the archive takes
+  --json                     Print the warnings (with the service they concern) as JSON
 `
 }
 
@@ -40,30 +86,132 @@ func (c *RestoreComposeCmd) Execute(ctx context.Context, args []string) error {
 	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
 	var projectName string
 	var start bool
+	var createOnly bool
+	var paused bool
+	var isolated bool
+	var lenient bool
+	var composeUp bool
+	var composeDir string
+	var replace bool
+	var waitHealthy bool
+	var waitTimeout int
+	var serviceTimeouts []string
+	var installPlugins bool
+	var skipExisting bool
+	var noOverwriteVolumes bool
+	var uidMaps []string
+	var gidMaps []string
+	var attachTo string
+	var onConflict []string
+	var asJSON bool
 	fs.StringVarP(&projectName, "project-name", "p", "", "New project name")
 	fs.BoolVar(&start, "start", false, "Start services after restore")
+	fs.BoolVar(&createOnly, "create-only", false, "Create the services without starting them")
+	fs.BoolVar(&paused, "paused", false, "Start the services paused")
+	fs.BoolVar(&isolated, "isolated", false, "Attach only to an internal network, publish no ports")
+	fs.BoolVar(&lenient, "lenient", false, "Warn instead of failing on volume content mismatches")
+	fs.BoolVar(&composeUp, "compose-up", false, "Write compose files and run 'docker compose up -d' so compose manages the project")
+	fs.StringVar(&composeDir, "compose-dir", "", "Target directory for compose files with --compose-up")
+	fs.BoolVar(&replace, "replace", false, "Replace existing containers or compose files")
+	fs.BoolVar(&waitHealthy, "wait-healthy", false, "Wait until every service reports healthy after start")
+	fs.IntVar(&waitTimeout, "wait-timeout", int((2 * time.Minute).Seconds()), "Max seconds to wait per service")
+	fs.StringArrayVar(&serviceTimeouts, "service-timeout", nil, "Per-service wait timeout svc:seconds (repeatable)")
+	fs.BoolVar(&installPlugins, "install-plugins", false, "Install missing volume driver plugins recorded in the backup")
+	fs.BoolVar(&skipExisting, "skip-existing", false, "Leave containers already restored from this backup as they are")
+	fs.BoolVar(&noOverwriteVolumes, "no-overwrite-volumes", false, "Leave volumes that already hold data untouched")
+	fs.StringArrayVar(&uidMaps, "uid-map", nil, "Map owner user IDs of restored files old:new (repeatable)")
+	fs.StringArrayVar(&gidMaps, "gid-map", nil, "Map owner group IDs of restored files old:new (repeatable)")
+	fs.StringArrayVar(&onConflict, "on-conflict", nil, "Policy for existing containers, ports, networks and volumes: fail, skip, rename, replace or prompt, or kind=policy (repeatable)")
+	fs.StringVar(&attachTo, "attach-to", "", "Attach all services to this existing network instead of recreating the saved ones")
+	applyClientFlags := dockerClientFlags(fs)
+	fs.BoolVar(&asJSON, "json", false, "Print the result and warnings as JSON")
+	confirm := confirmFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
+	if err := applyClientFlags(); err != nil {
+		return err
+	}
 	remaining := fs.Args()
 	if len(remaining) == 0 {
 		return fmt.Errorf("missing backup file path")
 	}
-	backupFile := remaining[0]
+	fetched, cleanup, err := fetchBackup(ctx, c.log, remaining[0])
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	backupFile, err := resolveBackupFile(ctx, c.log, fetched, isComposeBackup)
+	if err != nil {
+		return err
+	}
+
+	uidMap, err := parseIDMap("uid-map", uidMaps)
+	if err != nil {
+		return err
+	}
+	gidMap, err := parseIDMap("gid-map", gidMaps)
+	if err != nil {
+		return err
+	}
+	conflicts, err := parseOnConflict(onConflict)
+	if err != nil {
+		return err
+	}
+
+	timeouts := map[string]int{}
+	for _, it := range serviceTimeouts {
+		parts := strings.SplitN(it, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("invalid --service-timeout %q (want svc:seconds)", it)
+		}
+		secs, err := strconv.Atoi(parts[1])
+		if err != nil || secs <= 0 {
+			return fmt.Errorf("invalid --service-timeout %q: seconds must be a positive integer", it)
+		}
+		timeouts[parts[0]] = secs
+	}
 
 	req := backup.RestoreRequest{
 		BackupPath:  backupFile,
 		ProjectName: projectName,
 		Options: backup.RestoreOptions{
-			Start: start,
+			Start:               start,
+			CreateOnly:          createOnly,
+			Paused:              paused,
+			Isolated:            isolated,
+			Lenient:             lenient,
+			ComposeUp:           composeUp,
+			ComposeDir:          composeDir,
+			ReplaceExisting:     replace,
+			WaitHealthy:         waitHealthy,
+			WaitTimeoutSeconds:  waitTimeout,
+			ServiceWaitTimeouts: timeouts,
+			SkipExisting:        skipExisting,
+			NoOverwriteVolumes:  noOverwriteVolumes,
+			UIDMap:              uidMap,
+			GIDMap:              gidMap,
+			AttachTo:            attachTo,
+			OnConflict:          conflicts,
+			Confirm:             confirm(),
 		},
 		TargetType: backup.TargetCompose,
 	}
 	if c.engine == nil {
 		c.engine = newDefaultEngine(c.log)
 	}
-	_, err := c.engine.Restore(ctx, req)
-	return err
+	rec, err := audit.Begin(c.Name(), backupFile, args)
+	if err != nil {
+		return err
+	}
+	res, err := c.engine.Restore(ctx, req)
+	if res != nil {
+		rec.Details = audit.Conflicts(res.Warnings)
+	}
+	if err := rec.End(err); err != nil || !asJSON {
+		return err
+	}
+	return printJSON(res)
 }
 
 func init() {
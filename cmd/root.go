@@ -3,8 +3,11 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -14,8 +17,10 @@ import (
 	"github.com/brian033/dockerbackup/pkg/backup"
 	"github.com/brian033/dockerbackup/pkg/docker"
 	"github.com/brian033/dockerbackup/pkg/filesystem"
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/network"
+	"github.com/spf13/pflag"
 )
 
 type Command interface {
@@ -33,16 +38,85 @@ func RegisterCommand(cmd Command) {
 
 func newDefaultEngine(log logger.Logger) backup.BackupEngine {
 	arch := archive.NewTarArchiveHandler()
-	// Prefer SDK client when available
-	var dc docker.DockerClient
+	fs := filesystem.NewHandler()
+	return backup.NewDefaultBackupEngine(arch, newDockerClient(), fs, log)
+}
+
+// newDockerClient prefers the SDK client when available.
+func newDockerClient() docker.DockerClient {
 	if sdk, err := docker.NewSDKClient(); err == nil {
 		// Wrap SDK to satisfy DockerClient via CreateContainerFromSpec while reusing CLI for other methods
-		dc = &compositeClient{sdk: sdk, cli: docker.NewCLIClient()}
-	} else {
-		dc = docker.NewCLIClient()
+		return &compositeClient{sdk: sdk, cli: docker.NewCLIClient()}
+	}
+	return docker.NewCLIClient()
+}
+
+// dockerClientFlags registers --helper-image, --op-timeout and --work-dir on fs. The returned
+// function, called once fs is parsed, makes the docker clients and engines created afterwards
+// use them; they are passed on through the environment variables they override.
+func dockerClientFlags(fs *pflag.FlagSet) func() error {
+	helperImage := fs.String("helper-image", "", "Image of the helper containers that read and write volume data")
+	opTimeout := fs.Duration("op-timeout", docker.OpTimeout(), "Kill docker commands that hang this long (0 disables)")
+	workDir := fs.String("work-dir", "", "Directory for the temporary files of backups and restores")
+	return func() error {
+		if *workDir != "" {
+			if fi, err := os.Stat(*workDir); err != nil || !fi.IsDir() {
+				return fmt.Errorf("invalid --work-dir %s: not a directory", *workDir)
+			}
+			if err := os.Setenv(backup.WorkDirEnv, *workDir); err != nil {
+				return err
+			}
+		}
+		if *helperImage != "" {
+			if err := os.Setenv(docker.HelperImageEnv, *helperImage); err != nil {
+				return err
+			}
+		}
+		if *opTimeout < 0 {
+			return fmt.Errorf("invalid --op-timeout %s", *opTimeout)
+		}
+		return os.Setenv(docker.OpTimeoutEnv, opTimeout.String())
+	}
+}
+
+// confirmFlags registers --yes (-y) and, unless the command has a --force of its own, --force
+// on fs. The returned function, called once fs is parsed, says whether destructive steps go
+// ahead: confirmed up front by either flag, or else asked about on the terminal.
+func confirmFlags(fs *pflag.FlagSet) func() backup.Confirmation {
+	yes := fs.BoolP("yes", "y", false, "Go ahead without asking to confirm removals and overwrites")
+	force := new(bool)
+	if fs.Lookup("force") == nil {
+		fs.BoolVar(force, "force", false, "Same as --yes")
+	}
+	return func() backup.Confirmation {
+		if *yes || *force {
+			return backup.ConfirmYes
+		}
+		return backup.ConfirmAsk
+	}
+}
+
+// compressFlags registers -c/--compress (also accepted as --compression): a gzip level from 1
+// to 9, or auto to store already-compressed files as they are and compress the rest at the
+// default level. The returned function parses the value.
+func compressFlags(fs *pflag.FlagSet) func() (level int, auto bool, err error) {
+	compress := fs.StringP("compress", "c", strconv.Itoa(archive.DefaultCompressionLevel), "Compression level (1-9) or auto")
+	fs.SetNormalizeFunc(func(_ *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "compression" {
+			name = "compress"
+		}
+		return pflag.NormalizedName(name)
+	})
+	return func() (int, bool, error) {
+		if *compress == "auto" {
+			return archive.DefaultCompressionLevel, true, nil
+		}
+		level, err := strconv.Atoi(*compress)
+		if err != nil || level < 1 || level > 9 {
+			return 0, false, fmt.Errorf("invalid --compress %q (want a level from 1 to 9, or auto)", *compress)
+		}
+		return level, false, nil
 	}
-	fs := filesystem.NewHandler()
-	return backup.NewDefaultBackupEngine(arch, dc, fs, log)
 }
 
 type compositeClient struct {
@@ -53,6 +127,9 @@ type compositeClient struct {
 func (c *compositeClient) InspectContainer(ctx context.Context, containerID string) ([]byte, error) {
 	return c.cli.InspectContainer(ctx, containerID)
 }
+func (c *compositeClient) ContainerSize(ctx context.Context, containerID string) (int64, error) {
+	return c.cli.ContainerSize(ctx, containerID)
+}
 func (c *compositeClient) ExportContainerFilesystem(ctx context.Context, containerID string, destTarPath string) error {
 	return c.cli.ExportContainerFilesystem(ctx, containerID, destTarPath)
 }
@@ -65,17 +142,23 @@ func (c *compositeClient) InspectVolume(ctx context.Context, name string) (*dock
 func (c *compositeClient) InspectNetwork(ctx context.Context, name string) (*docker.NetworkConfig, error) {
 	return c.cli.InspectNetwork(ctx, name)
 }
-func (c *compositeClient) ImportImage(ctx context.Context, tarPath string, ref string) (string, error) {
-	return c.cli.ImportImage(ctx, tarPath, ref)
+func (c *compositeClient) ImportImage(ctx context.Context, tarPath string, ref string, labels map[string]string) (string, error) {
+	return c.cli.ImportImage(ctx, tarPath, ref, labels)
 }
-func (c *compositeClient) VolumeCreate(ctx context.Context, name string) error {
-	return c.cli.VolumeCreate(ctx, name)
+func (c *compositeClient) VolumeCreate(ctx context.Context, name string, labels map[string]string) error {
+	return c.cli.VolumeCreate(ctx, name, labels)
 }
 func (c *compositeClient) ExtractTarGzToVolume(ctx context.Context, volumeName string, tarGzPath string, expectedRoot string) error {
-	return c.cli.ExtractTarGzToVolume(ctx, volumeName, tarGzPath, expectedRoot)
+	return c.sdk.ExtractTarGzToVolume(ctx, volumeName, tarGzPath, expectedRoot)
 }
-func (c *compositeClient) CreateContainer(ctx context.Context, imageRef string, name string, mounts []docker.Mount) (string, error) {
-	return c.cli.CreateContainer(ctx, imageRef, name, mounts)
+func (c *compositeClient) VolumeHasData(ctx context.Context, volumeName string) (bool, error) {
+	return c.cli.VolumeHasData(ctx, volumeName)
+}
+func (c *compositeClient) ClearVolume(ctx context.Context, volumeName string) error {
+	return c.cli.ClearVolume(ctx, volumeName)
+}
+func (c *compositeClient) CreateContainer(ctx context.Context, imageRef string, name string, mounts []docker.Mount, opts docker.CreateOptions) (string, error) {
+	return c.cli.CreateContainer(ctx, imageRef, name, mounts, opts)
 }
 func (c *compositeClient) CreateContainerFromSpec(ctx context.Context, cfg *container.Config, hostCfg *container.HostConfig, netCfg *network.NetworkingConfig, name string) (string, error) {
 	return c.sdk.CreateContainerFromSpec(ctx, cfg, hostCfg, netCfg, name)
@@ -83,6 +166,26 @@ func (c *compositeClient) CreateContainerFromSpec(ctx context.Context, cfg *cont
 func (c *compositeClient) StartContainer(ctx context.Context, containerID string) error {
 	return c.cli.StartContainer(ctx, containerID)
 }
+func (c *compositeClient) PauseContainer(ctx context.Context, containerID string) error {
+	return c.cli.PauseContainer(ctx, containerID)
+}
+
+func (c *compositeClient) RenameContainer(ctx context.Context, containerID, newName string) error {
+	return c.cli.RenameContainer(ctx, containerID, newName)
+}
+
+func (c *compositeClient) StopContainer(ctx context.Context, containerID string) error {
+	return c.cli.StopContainer(ctx, containerID)
+}
+func (c *compositeClient) RemoveContainer(ctx context.Context, containerID string) error {
+	return c.cli.RemoveContainer(ctx, containerID)
+}
+func (c *compositeClient) CheckpointCreate(ctx context.Context, containerID, name, checkpointDir string, leaveRunning bool) error {
+	return c.cli.CheckpointCreate(ctx, containerID, name, checkpointDir, leaveRunning)
+}
+func (c *compositeClient) StartContainerFromCheckpoint(ctx context.Context, containerID, name, checkpointDir string) error {
+	return c.cli.StartContainerFromCheckpoint(ctx, containerID, name, checkpointDir)
+}
 func (c *compositeClient) EnsureVolume(ctx context.Context, cfg docker.VolumeConfig) error {
 	return c.sdk.EnsureVolume(ctx, cfg)
 }
@@ -92,10 +195,43 @@ func (c *compositeClient) EnsureNetwork(ctx context.Context, cfg docker.NetworkC
 func (c *compositeClient) ImageSave(ctx context.Context, imageRef string, destTarPath string) error {
 	return c.cli.ImageSave(ctx, imageRef, destTarPath)
 }
+func (c *compositeClient) ImageSaveAll(ctx context.Context, imageRefs []string, destTarPath string) error {
+	return c.cli.ImageSaveAll(ctx, imageRefs, destTarPath)
+}
 func (c *compositeClient) ImageLoad(ctx context.Context, tarPath string) error {
 	return c.cli.ImageLoad(ctx, tarPath)
 }
+func (c *compositeClient) EnsureImage(ctx context.Context, ref string) error {
+	return c.cli.EnsureImage(ctx, ref)
+}
+func (c *compositeClient) InspectImage(ctx context.Context, ref string) (*docker.ImageInfo, error) {
+	return c.cli.InspectImage(ctx, ref)
+}
 func (c *compositeClient) HostIPs(ctx context.Context) ([]string, error) { return c.cli.HostIPs(ctx) }
+func (c *compositeClient) LogDrivers(ctx context.Context) ([]string, error) {
+	return c.cli.LogDrivers(ctx)
+}
+func (c *compositeClient) Runtimes(ctx context.Context) ([]string, error) {
+	return c.cli.Runtimes(ctx)
+}
+func (c *compositeClient) UsernsRemap(ctx context.Context) (*docker.UsernsRange, error) {
+	return c.cli.UsernsRemap(ctx)
+}
+func (c *compositeClient) DaemonInfo(ctx context.Context) ([]byte, error) {
+	return c.cli.DaemonInfo(ctx)
+}
+func (c *compositeClient) ListPlugins(ctx context.Context) ([]docker.PluginInfo, error) {
+	return c.cli.ListPlugins(ctx)
+}
+func (c *compositeClient) VolumeDrivers(ctx context.Context) ([]string, error) {
+	return c.cli.VolumeDrivers(ctx)
+}
+func (c *compositeClient) InstallPlugin(ctx context.Context, ref string, alias string, settings []string) error {
+	return c.cli.InstallPlugin(ctx, ref, alias, settings)
+}
+func (c *compositeClient) ArchiveVolume(ctx context.Context, volumeName string, destTarGz string) error {
+	return c.cli.ArchiveVolume(ctx, volumeName, destTarGz)
+}
 func (c *compositeClient) ContainerState(ctx context.Context, containerID string) (string, string, error) {
 	return c.cli.ContainerState(ctx, containerID)
 }
@@ -105,10 +241,32 @@ func (c *compositeClient) ListProjectContainers(ctx context.Context, project str
 func (c *compositeClient) ListProjectContainersByLabel(ctx context.Context, project string) ([]docker.ProjectContainerRef, error) {
 	return c.cli.ListProjectContainersByLabel(ctx, project)
 }
+func (c *compositeClient) ComposeUp(ctx context.Context, projectDir string, projectName string, scale map[string]int) error {
+	return c.cli.ComposeUp(ctx, projectDir, projectName, scale)
+}
 func (c *compositeClient) TagImage(ctx context.Context, sourceRef, targetRef string) error {
 	return c.cli.TagImage(ctx, sourceRef, targetRef)
 }
 
+func (c *compositeClient) RemoveVolume(ctx context.Context, name string) error {
+	return c.cli.RemoveVolume(ctx, name)
+}
+
+func (c *compositeClient) RemoveNetwork(ctx context.Context, name string) error {
+	return c.cli.RemoveNetwork(ctx, name)
+}
+
+func (c *compositeClient) ListResourcesByLabel(ctx context.Context, label string) ([]docker.LabeledResource, error) {
+	return c.cli.ListResourcesByLabel(ctx, label)
+}
+
+func (c *compositeClient) ListContainersByLabel(ctx context.Context, label string) ([]docker.ContainerRef, error) {
+	return c.cli.ListContainersByLabel(ctx, label)
+}
+func (c *compositeClient) ListRunningContainers(ctx context.Context) ([]docker.ContainerRef, error) {
+	return c.cli.ListRunningContainers(ctx)
+}
+
 func Execute() {
 	log := logger.New()
 	if len(os.Args) < 2 {
@@ -116,7 +274,12 @@ func Execute() {
 		os.Exit(1)
 	}
 
-	sub := os.Args[1]
+	args := globalFlags(os.Args[1:])
+	if len(args) == 0 {
+		printUsage()
+		os.Exit(1)
+	}
+	sub := args[0]
 	cmd, ok := registered[sub]
 	if !ok {
 		fmt.Fprintf(os.Stderr, "unknown command: %s\n", sub)
@@ -124,7 +287,7 @@ func Execute() {
 		os.Exit(1)
 	}
 
-	if err := cmd.Validate(os.Args[2:]); err != nil {
+	if err := cmd.Validate(args[1:]); err != nil {
 		fmt.Fprintf(os.Stderr, "invalid arguments for %s: %v\n\n", sub, err)
 		fmt.Fprintln(os.Stderr, strings.TrimSpace(cmd.Help()))
 		os.Exit(2)
@@ -134,13 +297,33 @@ func Execute() {
 	defer cancel()
 
 	start := time.Now()
-	if err := cmd.Execute(ctx, os.Args[2:]); err != nil {
+	if err := cmd.Execute(ctx, args[1:]); err != nil {
 		log.Errorf("%s failed: %v", cmd.Name(), err)
+		if hint := remediationHint(cmd.Name(), err); hint != "" {
+			fmt.Fprintf(os.Stderr, "Hint: %s\n", hint)
+		}
 		os.Exit(1)
 	}
 	log.Infof("%s completed in %s", cmd.Name(), time.Since(start).Truncate(time.Millisecond))
 }
 
+// globalFlags removes the flags every command accepts, before or after its name, from args
+// and applies them. --no-prompt is passed on through $DOCKERBACKUP_NO_PROMPT.
+func globalFlags(args []string) []string {
+	out := make([]string, 0, len(args))
+	for i, a := range args {
+		if a == "--" {
+			return append(out, args[i:]...)
+		}
+		if a == "--no-prompt" {
+			_ = os.Setenv(backup.NoPromptEnv, "1")
+			continue
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
 func printUsage() {
 	b := &strings.Builder{}
 	fmt.Fprintln(b, "Usage: dockerbackup <command> [options]")
@@ -150,6 +333,9 @@ func printUsage() {
 		fmt.Fprintf(b, "  %-16s %s\n", name, shortHelp(cmd.Help()))
 	}
 	fmt.Fprintln(b, "")
+	fmt.Fprintln(b, "Global options:")
+	fmt.Fprintln(b, "  --no-prompt      Never ask on the terminal; removals and overwrites need --yes")
+	fmt.Fprintln(b, "")
 	fmt.Fprintln(b, "Run 'dockerbackup <command> --help' for command-specific help.")
 	fmt.Print(b.String())
 }
@@ -161,3 +347,146 @@ func shortHelp(help string) string {
 	}
 	return strings.TrimSpace(lines[0])
 }
+
+// readBackupContainerJSON reads container.json from a container backup without extracting it.
+func readBackupContainerJSON(ctx context.Context, backupFile string) (types.ContainerJSON, error) {
+	b, err := archive.NewTarArchiveHandler().ReadEntry(ctx, backupFile, "container.json")
+	if err != nil {
+		return types.ContainerJSON{}, fmt.Errorf("read container.json from %s: %w", backupFile, err)
+	}
+	return docker.ParseContainerJSON(b)
+}
+
+// resolveBackupFile returns path unchanged unless it is a directory, in which case the newest
+// backup in it that passes valid is selected.
+func resolveBackupFile(ctx context.Context, log logger.Logger, path string, valid func(ctx context.Context, path string) bool) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil || !fi.IsDir() {
+		return path, nil
+	}
+	picked, err := backup.NewestValidBackup(ctx, path, valid)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("Selected newest valid backup %s", picked)
+	return picked, nil
+}
+
+// resolveBackupAt picks the backup of target taken at or before at (see backup.BackupAt)
+// from the backup directory dir.
+func resolveBackupAt(ctx context.Context, log logger.Logger, dir, target, at string, valid func(ctx context.Context, path string) bool) (string, error) {
+	fi, err := os.Stat(dir)
+	if err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("--target and --at select a backup from a directory; %s is not one", dir)
+	}
+	var t time.Time
+	if at != "" {
+		if t, err = parsePointInTime(at); err != nil {
+			return "", err
+		}
+	}
+	picked, err := backup.BackupAt(ctx, dir, target, t, valid)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("Selected backup %s", picked)
+	return picked, nil
+}
+
+// parsePointInTime accepts RFC 3339 or a local "YYYY-MM-DD[ HH:MM[:SS]]" time.
+func parsePointInTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			if layout == "2006-01-02" {
+				// a date means the end of that day
+				t = t.Add(24*time.Hour - time.Second)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --at %q (want \"2006-01-02 15:04\", a date or RFC 3339)", s)
+}
+
+// parseIDMap parses repeated old:new --uid-map or --gid-map values.
+func parseIDMap(flag string, items []string) (map[int]int, error) {
+	m := map[int]int{}
+	for _, it := range items {
+		parts := strings.SplitN(it, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid --%s %q (want old:new)", flag, it)
+		}
+		from, err1 := strconv.Atoi(parts[0])
+		to, err2 := strconv.Atoi(parts[1])
+		if err1 != nil || err2 != nil || from < 0 || to < 0 {
+			return nil, fmt.Errorf("invalid --%s %q: IDs must be non-negative integers", flag, it)
+		}
+		m[from] = to
+	}
+	return m, nil
+}
+
+// parseIPMap parses repeated old:new --ip-map values. IPv6 addresses contain colons, so the
+// two addresses are split at the colon that leaves a valid address on both sides.
+func parseIPMap(items []string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, it := range items {
+		var from, to net.IP
+		for i := range it {
+			if it[i] != ':' {
+				continue
+			}
+			if from, to = net.ParseIP(it[:i]), net.ParseIP(it[i+1:]); from != nil && to != nil {
+				break
+			}
+		}
+		if from == nil || to == nil {
+			return nil, fmt.Errorf("invalid --ip-map %q (want old:new addresses)", it)
+		}
+		if (from.To4() == nil) != (to.To4() == nil) {
+			return nil, fmt.Errorf("invalid --ip-map %q: addresses must be of the same family", it)
+		}
+		m[from.String()] = to.String()
+	}
+	return m, nil
+}
+
+// parseOnConflict parses repeated --on-conflict values: a policy for every kind, or
+// kind=policy for one. Later values win, so "--on-conflict skip --on-conflict name=rename"
+// renames containers and skips the rest. A bare replace leaves ports alone, which cannot be
+// replaced.
+func parseOnConflict(items []string) (map[backup.ConflictKind]backup.ConflictPolicy, error) {
+	m := map[backup.ConflictKind]backup.ConflictPolicy{}
+	for _, it := range items {
+		kinds, value := backup.ConflictKinds, it
+		k, p, single := strings.Cut(it, "=")
+		if single {
+			if !slices.Contains(backup.ConflictKinds, backup.ConflictKind(k)) {
+				return nil, fmt.Errorf("invalid --on-conflict %q: unknown kind %q (want name, port, network or volume)", it, k)
+			}
+			kinds, value = []backup.ConflictKind{backup.ConflictKind(k)}, p
+		}
+		policy := backup.ConflictPolicy(value)
+		if !backup.ConflictName.Allows(policy) {
+			return nil, fmt.Errorf("invalid --on-conflict %q: unknown policy %q (want fail, skip, rename, replace or prompt)", it, value)
+		}
+		for _, k := range kinds {
+			if !k.Allows(policy) {
+				if single {
+					return nil, fmt.Errorf("invalid --on-conflict %q: %s conflicts cannot be settled with %s", it, k, value)
+				}
+				continue
+			}
+			m[k] = policy
+		}
+	}
+	return m, nil
+}
+
+// isComposeBackup reports whether the archive is a compose backup.
+func isComposeBackup(ctx context.Context, path string) bool {
+	t, err := backup.DetectTargetType(ctx, path)
+	return err == nil && t == backup.TargetCompose
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/spf13/pflag"
+)
+
+type SBOMCmd struct {
+	log logger.Logger
+}
+
+func (c *SBOMCmd) Name() string { return "sbom" }
+
+func (c *SBOMCmd) Help() string {
+	return `
+Show the image provenance and SBOM stored in a backup.
+
+Usage:
+  dockerbackup sbom <backup_file> [options]
+
+Options:
+      --service string  Compose service whose SBOM to print (default: the first one found)
+  -o, --output string   Write the SBOM to this file instead of stdout
+      --provenance      Print image provenance (ID, digests, platform, source labels) instead
+      --json            With --provenance, print JSON
+
+The SBOM (SPDX JSON) is only present for backups taken with --sbom; provenance is recorded
+for every backup whose image could be inspected. Feed the SBOM to a scanner such as
+'grype sbom:<file>' to audit a workload before restoring it.
+`
+}
+
+func (c *SBOMCmd) Validate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing backup file path")
+	}
+	return nil
+}
+
+func (c *SBOMCmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var service string
+	var output string
+	var showProvenance bool
+	var asJSON bool
+	fs.StringVar(&service, "service", "", "Compose service")
+	fs.StringVarP(&output, "output", "o", "", "Write the SBOM to this file")
+	fs.BoolVar(&showProvenance, "provenance", false, "Print image provenance instead of the SBOM")
+	fs.BoolVar(&asJSON, "json", false, "Print provenance as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	remaining := fs.Args()
+	if len(remaining) == 0 {
+		return fmt.Errorf("missing backup file path")
+	}
+	backupPath := remaining[0]
+
+	if showProvenance {
+		return c.printProvenance(ctx, backupPath, service, asJSON)
+	}
+	sbom, err := backup.ReadSBOM(ctx, backupPath, service)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		_, err = os.Stdout.Write(sbom)
+		return err
+	}
+	if err := os.WriteFile(output, sbom, 0o644); err != nil {
+		return err
+	}
+	c.log.Infof("SBOM written to %s", output)
+	return nil
+}
+
+func (c *SBOMCmd) printProvenance(ctx context.Context, backupPath, service string, asJSON bool) error {
+	all, err := backup.ReadProvenance(ctx, backupPath)
+	if err != nil {
+		return err
+	}
+	if service != "" {
+		p, ok := all[service]
+		if !ok {
+			return fmt.Errorf("no provenance for service %s in %s", service, backupPath)
+		}
+		all = map[string]*backup.Provenance{service: p}
+	}
+	if len(all) == 0 {
+		return fmt.Errorf("no image provenance recorded in %s", backupPath)
+	}
+	if asJSON {
+		if p, ok := all[""]; ok && len(all) == 1 {
+			return printJSON(p)
+		}
+		return printJSON(all)
+	}
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		p := all[name]
+		if i > 0 {
+			fmt.Println()
+		}
+		if name != "" {
+			fmt.Printf("Service:   %s\n", name)
+		}
+		fmt.Printf("Image:     %s\n", orNone(p.Image))
+		fmt.Printf("Image ID:  %s\n", orNone(p.ImageID))
+		for _, d := range p.RepoDigests {
+			fmt.Printf("Digest:    %s\n", d)
+		}
+		if p.Platform != "" {
+			fmt.Printf("Platform:  %s\n", p.Platform)
+		}
+		if p.Created != "" {
+			fmt.Printf("Built:     %s\n", p.Created)
+		}
+		keys := make([]string, 0, len(p.Annotations))
+		for k := range p.Annotations {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%-10s %s\n", strings.TrimPrefix(k, "org.opencontainers.image.")+":", p.Annotations[k])
+		}
+		if p.SBOM != "" {
+			fmt.Printf("SBOM:      %s (%s)\n", p.SBOM, p.SBOMTool)
+		} else {
+			fmt.Println("SBOM:      -")
+		}
+	}
+	return nil
+}
+
+func init() {
+	RegisterCommand(&SBOMCmd{log: logger.New()})
+}
@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/audit"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/spf13/pflag"
+)
+
+type ScheduledCmd struct {
+	log    logger.Logger
+	engine backup.BackupEngine
+}
+
+// scheduledRun is what a scheduled run did for one enrolled container.
+type scheduledRun struct {
+	Container string `json:"container"`
+	Schedule  string `json:"schedule"`
+	Policy    string `json:"policy,omitempty"`
+	Due       bool   `json:"due"`
+	// Backup is the archive written by this run
+	Backup  string   `json:"backup,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+func (c *ScheduledCmd) Name() string { return "scheduled" }
+
+func (c *ScheduledCmd) Help() string {
+	return `
+Back up the containers enrolled through labels that are due, and prune their old backups.
+
+Usage:
+  dockerbackup scheduled <directory> [options]
+
+Options:
+      --dry-run        Show what is due and what would be removed without doing it
+      --json           Print what was done for each container as JSON
+  -y, --yes, --force   Remove the backups the policies let go without asking to confirm; cron
+                       jobs and timers need it, as there is no terminal to ask on
+
+Containers enroll themselves with labels:
+  dockerbackup.schedule=daily            hourly, daily, weekly or an interval (6h, 2d)
+  dockerbackup.policy=keep=7,keep-within=30d
+                                         keep the newest 7 backups and those younger than 30
+                                         days; without a policy every backup is kept
+
+Run it from cron or a systemd timer more often than the shortest schedule. A container is
+due when its newest own backup in the directory is older than its schedule; it is then backed
+up with --timestamped into the directory. Backups the policy lets go are removed once
+confirmed, never the newest one; unconfirmed, they are kept and the container is reported
+as failed. A container whose labels are invalid or whose backup fails is reported and the
+others still run; the exit status is an error when any failed.
+`
+}
+
+func (c *ScheduledCmd) Validate(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("directory is required")
+	}
+	return nil
+}
+
+func (c *ScheduledCmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var dryRun, asJSON bool
+	fs.BoolVar(&dryRun, "dry-run", false, "Show what would be done")
+	fs.BoolVar(&asJSON, "json", false, "Print JSON")
+	confirm := confirmFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return fmt.Errorf("directory is required")
+	}
+	dir := fs.Arg(0)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	dc := docker.NewCLIClient()
+	containers, err := dc.ListContainersByLabel(ctx, backup.LabelSchedule)
+	if err != nil {
+		return err
+	}
+	if c.engine == nil {
+		c.engine = newDefaultEngine(c.log)
+	}
+	runs := make([]scheduledRun, 0, len(containers))
+	failed := 0
+	for _, ref := range containers {
+		run := c.runContainer(ctx, dc, dir, ref, dryRun, confirm())
+		if run.Error != "" {
+			failed++
+		}
+		runs = append(runs, run)
+	}
+
+	if asJSON {
+		if err := printJSON(runs); err != nil {
+			return err
+		}
+	} else if len(runs) == 0 {
+		fmt.Printf("No containers carry the %s label\n", backup.LabelSchedule)
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "CONTAINER\tSCHEDULE\tPOLICY\tBACKUP\tREMOVED\tERROR")
+		for _, r := range runs {
+			b := "-"
+			switch {
+			case r.Backup != "":
+				b = filepath.Base(r.Backup)
+			case r.Due && dryRun:
+				b = "due"
+			case !r.Due && r.Error == "":
+				b = "not due"
+			}
+			errText := r.Error
+			if errText == "" {
+				errText = "-"
+			}
+			policy := r.Policy
+			if policy == "" {
+				policy = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", r.Container, r.Schedule, policy, b, len(r.Removed), errText)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d scheduled containers failed", failed, len(runs))
+	}
+	return nil
+}
+
+// runContainer backs up the container when it is due and applies its retention policy.
+func (c *ScheduledCmd) runContainer(ctx context.Context, dc docker.DockerClient, dir string, ref docker.ContainerRef, dryRun bool, confirm backup.Confirmation) scheduledRun {
+	run := scheduledRun{Container: ref.Name}
+	b, err := dc.InspectContainer(ctx, ref.ID)
+	if err != nil {
+		run.Error = err.Error()
+		return run
+	}
+	cj, err := docker.ParseContainerJSON(b)
+	if err != nil {
+		run.Error = err.Error()
+		return run
+	}
+	var labels map[string]string
+	if cj.Config != nil {
+		labels = cj.Config.Labels
+	}
+	run.Schedule, run.Policy = labels[backup.LabelSchedule], labels[backup.LabelPolicy]
+	interval, err := backup.ParseSchedule(run.Schedule)
+	if err != nil {
+		run.Error = err.Error()
+		return run
+	}
+	policy, err := backup.ParsePolicy(run.Policy)
+	if err != nil {
+		run.Error = err.Error()
+		return run
+	}
+
+	now := time.Now()
+	backups, err := backup.ContainerBackups(ctx, dir, ref.Name)
+	if err != nil {
+		run.Error = err.Error()
+		return run
+	}
+	run.Due = len(backups) == 0 || now.Sub(backups[0].Info.CreatedAt) > interval
+	if run.Due && !dryRun {
+		res, err := c.engine.Backup(ctx, backup.BackupRequest{
+			TargetType:  backup.TargetContainer,
+			ContainerID: ref.ID,
+			Options:     backup.NewBackupOptionsBuilder().WithOutput(dir).WithTimestamped(true).Build(),
+		})
+		if err != nil {
+			run.Error = err.Error()
+			return run
+		}
+		run.Backup = res.OutputPath
+		if backups, err = backup.ContainerBackups(ctx, dir, ref.Name); err != nil {
+			run.Error = err.Error()
+			return run
+		}
+	}
+	expired := policy.Expired(backups, now)
+	if dryRun {
+		for _, old := range expired {
+			run.Removed = append(run.Removed, old.Path)
+		}
+		return run
+	}
+	if len(expired) == 0 {
+		return run
+	}
+	names := make([]string, 0, len(expired))
+	for _, old := range expired {
+		names = append(names, filepath.Base(old.Path))
+	}
+	if err := confirm.Confirm(fmt.Sprintf("Policy %q of %s lets go of %d backups, which will be deleted: %s", run.Policy, ref.Name, len(expired), strings.Join(names, ", "))); err != nil {
+		run.Error = err.Error()
+		return run
+	}
+	rec, err := audit.Begin("prune", ref.Name, []string{dir, backup.LabelPolicy + "=" + run.Policy})
+	if err != nil {
+		run.Error = err.Error()
+		return run
+	}
+	for _, old := range expired {
+		if err = backup.RemoveBackup(old.Path); err != nil {
+			err = fmt.Errorf("remove %s: %w", filepath.Base(old.Path), err)
+			break
+		}
+		run.Removed = append(run.Removed, old.Path)
+		rec.Details = append(rec.Details, "removed backup "+old.Path)
+	}
+	if err := rec.End(err); err != nil {
+		run.Error = err.Error()
+	}
+	return run
+}
+
+func init() {
+	RegisterCommand(&ScheduledCmd{log: logger.New()})
+}
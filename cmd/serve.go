@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/server"
+	"github.com/spf13/pflag"
+)
+
+type ServeCmd struct {
+	log logger.Logger
+}
+
+func (c *ServeCmd) Name() string { return "serve" }
+
+func (c *ServeCmd) Help() string {
+	return `
+Run a web UI and REST API to trigger backups and restores, follow them and download archives.
+
+Usage:
+  dockerbackup serve <directory> [options]
+
+Options:
+      --listen addr        Address to listen on (default: :8080)
+      --token string       Token clients send as "Authorization: Bearer <token>"
+                           (default: $DOCKERBACKUP_API_TOKEN; required)
+      --helper-image img   Image of the helper containers that read and write volume data
+      --op-timeout dur     Kill docker commands that hang this long (default: 10m, or
+                           $DOCKERBACKUP_OP_TIMEOUT; 0 disables)
+      --work-dir dir       Directory for the temporary files of backups and restores (default:
+                           $DOCKERBACKUP_WORK_DIR, or $TMPDIR)
+
+The web UI, at http://<addr>/, asks for the token once. It lists and inspects backups,
+restores them with a form for the network and volume mappings and follows jobs live.
+
+Endpoints (JSON):
+  GET  /api/health            liveness, without a token
+  GET  /api/backups           the backups in the directory (?tag=, repeatable, ?target=)
+  GET  /api/backups/<file>    download an archive
+  GET  /api/backups/<file>/contents
+                              entries, lineage, networks and volumes of an archive
+  POST /api/backups           queue a backup: {"container": "web"}, {"containers": [...]} or
+                              {"project": "/srv/app"}, with optional "tags" and "note"
+  POST /api/restores          queue a restore: {"backup": "<file>", "name", "start", "replace",
+                              "isolated", "networkMap", "volumeMap", "dropHostIPs",
+                              "fallbackBridge"}
+  GET  /api/jobs              queued, running and the last 100 finished jobs
+  GET  /api/jobs/<id>         one job: status, error, archive written, warnings
+  GET  /api/jobs/<id>/events  the job's log and status changes as server-sent events
+
+Backups are written to the directory with --timestamped names. Jobs run one at a time in the
+order they were queued; POST returns 202 with the job and its URL in the Location header.
+The API is plain HTTP: put it behind a reverse proxy with TLS when it leaves the host.
+`
+}
+
+func (c *ServeCmd) Validate(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("directory is required")
+	}
+	return nil
+}
+
+func (c *ServeCmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var listen, token string
+	fs.StringVar(&listen, "listen", ":8080", "Address to listen on")
+	fs.StringVar(&token, "token", os.Getenv(server.TokenEnv), "API token")
+	applyClientFlags := dockerClientFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := applyClientFlags(); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return fmt.Errorf("directory is required")
+	}
+	dir := fs.Arg(0)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	srv, err := server.New(func(log logger.Logger) backup.BackupEngine { return newDefaultEngine(log) }, dir, token, c.log)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	hs := &http.Server{
+		Handler:           srv.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		// ends the event streams on shutdown
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go srv.Run(ctx)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = hs.Shutdown(shutdownCtx)
+	}()
+	c.log.Infof("Serving the backups in %s on %s", dir, ln.Addr())
+	if err := hs.Serve(ln); err != nil && !stdErrors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func init() {
+	RegisterCommand(&ServeCmd{log: logger.New()})
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/convert"
+)
+
+type ShowRunCmd struct {
+	log logger.Logger
+}
+
+func (c *ShowRunCmd) Name() string { return "show-run" }
+
+func (c *ShowRunCmd) Help() string {
+	return `
+Print the docker run command equivalent to a container backup.
+
+Usage:
+  dockerbackup show-run <backup_file>
+`
+}
+
+func (c *ShowRunCmd) Validate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing backup file path")
+	}
+	return nil
+}
+
+func (c *ShowRunCmd) Execute(ctx context.Context, args []string) error {
+	cj, err := readBackupContainerJSON(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	runArgs, err := convert.RunArgs(cj)
+	if err != nil {
+		return err
+	}
+	fmt.Println(convert.ShellJoin(runArgs))
+	return nil
+}
+
+func init() {
+	RegisterCommand(&ShowRunCmd{log: logger.New()})
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/spf13/pflag"
+)
+
+type StatusCmd struct {
+	log logger.Logger
+}
+
+func (c *StatusCmd) Name() string { return "status" }
+
+func (c *StatusCmd) Help() string {
+	return `
+Show when each running container was last backed up.
+
+Usage:
+  dockerbackup status [directory] [options]
+
+Options:
+      --max-age dur    Mark containers never backed up or whose newest backup is older than
+                       this (e.g. 24h) as stale and exit with an error when there are any
+      --json           Print the status of each container as JSON
+
+A container is covered by a backup of itself, of its compose project or a multi-container
+backup including it; the newest one in the directory (default: the current one) counts.
+Containers labeled dockerbackup.ignore=true are left out, as backup-all skips them.
+With --max-age the exit status tells whether every container is fresh, for cron jobs and
+monitoring agents.
+`
+}
+
+func (c *StatusCmd) Validate(args []string) error { return nil }
+
+func (c *StatusCmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var maxAge time.Duration
+	var asJSON bool
+	fs.DurationVar(&maxAge, "max-age", 0, "Maximum age of the newest backup (e.g. 24h)")
+	fs.BoolVar(&asJSON, "json", false, "Print JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if maxAge < 0 {
+		return fmt.Errorf("invalid --max-age %s", maxAge)
+	}
+	dir := "."
+	if fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	containers, err := runningContainers(ctx, docker.NewCLIClient())
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	statuses, err := backup.BackupStatus(ctx, dir, containers, maxAge, now)
+	if err != nil {
+		return err
+	}
+	stale := 0
+	for _, st := range statuses {
+		if st.Stale {
+			stale++
+		}
+	}
+	if asJSON {
+		if err := printJSON(statuses); err != nil {
+			return err
+		}
+	} else if len(statuses) == 0 {
+		fmt.Println("No running containers")
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "CONTAINER\tLAST BACKUP\tAGE\tSIZE\tFILE\tSTATUS")
+		for _, st := range statuses {
+			age, file, status := "-", "-", "ok"
+			if st.Backup != "" {
+				age = now.Sub(st.CreatedAt).Round(time.Minute).String()
+				file = filepath.Base(st.Backup)
+			}
+			switch {
+			case st.Backup == "":
+				status = "never backed up"
+			case st.Stale:
+				status = "stale"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", st.Container, formatTime(st.CreatedAt), age, sizeOrDash(st.Size), file, status)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if stale > 0 {
+		return fmt.Errorf("%d of %d running containers have no backup newer than %s", stale, len(statuses), maxAge)
+	}
+	return nil
+}
+
+// runningContainers lists the running containers but those labeled dockerbackup.ignore=true.
+func runningContainers(ctx context.Context, dc docker.DockerClient) ([]docker.ContainerRef, error) {
+	targets, err := backup.BackupAllTargets(ctx, dc, backup.BackupAllFilter{})
+	if err != nil {
+		return nil, err
+	}
+	var containers []docker.ContainerRef
+	for _, t := range targets {
+		if t.Skipped == "" {
+			containers = append(containers, t.ContainerRef)
+		}
+	}
+	return containers, nil
+}
+
+func init() {
+	RegisterCommand(&StatusCmd{log: logger.New()})
+}
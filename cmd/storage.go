@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/storage"
+)
+
+// uploadBackup copies a finished archive (or its parts and split manifest) and its checksum
+// file to a storage location. The manifest goes last so its presence implies complete parts.
+// chunked uploads the archive as content-defined chunks, sending only the new ones.
+func uploadBackup(ctx context.Context, log logger.Logger, location string, res *backup.BackupResult, removeLocal, chunked bool) error {
+	backend, err := storage.Open(location)
+	if err != nil {
+		return err
+	}
+	files := append([]string{}, res.Parts...)
+	sum := strings.TrimSuffix(res.OutputPath, archive.SplitManifestSuffix) + archive.ChecksumSuffix
+	if _, err := os.Stat(sum); err == nil {
+		files = append(files, sum)
+	}
+	files = append(files, res.OutputPath)
+	start := time.Now()
+	var sent int64
+	for _, p := range files {
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		if chunked && p == res.OutputPath {
+			var st storage.ChunkStats
+			st, err = storage.PutChunked(ctx, backend, filepath.Base(p), f)
+			_ = f.Close()
+			if err == nil {
+				log.Infof("Uploaded %s -> %s in %d chunks, %d new: sent %s of %s", filepath.Base(p), backend, st.Chunks, st.Uploaded, storage.FormatSize(st.Sent), storage.FormatSize(st.Size))
+				sent += st.Sent
+			}
+		} else {
+			log.Infof("Uploading %s -> %s", filepath.Base(p), backend)
+			if fi, err := f.Stat(); err == nil {
+				sent += fi.Size()
+			}
+			err = backend.Put(ctx, filepath.Base(p), f)
+			_ = f.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("upload %s: %w", filepath.Base(p), err)
+		}
+	}
+	if res.Report != nil {
+		rec := backup.StatsRecord{Kind: backup.StatsUpload, Target: res.Report.Target, Archive: filepath.Base(res.OutputPath), Time: time.Now().UTC(), Seconds: time.Since(start).Seconds(), Bytes: sent}
+		if err := backup.AppendStats(filepath.Dir(res.OutputPath), rec); err != nil {
+			log.Infof("Could not record upload statistics: %v", err)
+		}
+	}
+	if removeLocal {
+		for _, p := range files {
+			_ = os.Remove(p)
+		}
+	}
+	return nil
+}
+
+// fetchBackup downloads a backup addressed by a remote storage URL into a temporary directory,
+// with the earlier archives a --skip-unchanged backup keeps unchanged volumes in. Local paths
+// are returned unchanged. The returned cleanup removes the download.
+func fetchBackup(ctx context.Context, log logger.Logger, location string) (string, func(), error) {
+	noop := func() {}
+	var backend storage.Backend
+	var name string
+	if !storage.IsRemote(location) {
+		// a local archive, unless it was stored there in chunks
+		if _, err := os.Stat(location); err == nil || !chunkedLocal(location) {
+			return location, noop, nil
+		}
+		backend, name = storage.NewLocal(filepath.Dir(location)), filepath.Base(location)
+	} else {
+		i := strings.LastIndex(location, "/")
+		if i < 0 || i == len(location)-1 {
+			return "", noop, fmt.Errorf("remote backup location must name an archive: %s", location)
+		}
+		var err error
+		if backend, err = storage.Open(location[:i]); err != nil {
+			return "", noop, err
+		}
+		name = location[i+1:]
+	}
+	dir, err := os.MkdirTemp(backup.WorkDir(), "dockerbackup_fetch_*")
+	if err != nil {
+		return "", noop, err
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+	local, err := backup.FetchBackup(ctx, log, backend, name, dir)
+	if err != nil {
+		cleanup()
+		return "", noop, err
+	}
+	return local, cleanup, nil
+}
+
+// chunkedLocal reports whether the local path names an archive stored in chunks, or its
+// chunk manifest.
+func chunkedLocal(path string) bool {
+	if strings.HasSuffix(path, storage.ChunkManifestSuffix) {
+		return true
+	}
+	_, err := os.Stat(path + storage.ChunkManifestSuffix)
+	return err == nil
+}
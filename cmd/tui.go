@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/server"
+	"github.com/brian033/dockerbackup/pkg/tui"
+	"github.com/moby/term"
+	"github.com/spf13/pflag"
+)
+
+type TUICmd struct {
+	log logger.Logger
+}
+
+func (c *TUICmd) Name() string { return "tui" }
+
+func (c *TUICmd) Help() string {
+	return `
+Show a terminal dashboard of the running containers and their backups, and back them up or
+restore them with a key press.
+
+Usage:
+  dockerbackup tui [directory] [options]
+
+Options:
+      --log file           Write the log of the jobs to this file (default: not kept; the
+                           dashboard shows each job's latest line)
+      --helper-image img   Image of the helper containers that read and write volume data
+      --op-timeout dur     Kill docker commands that hang this long (default: 10m, or
+                           $DOCKERBACKUP_OP_TIMEOUT; 0 disables)
+      --work-dir dir       Directory for the temporary files of backups and restores (default:
+                           $DOCKERBACKUP_WORK_DIR, or $TMPDIR)
+
+The dashboard lists the running containers (as 'status' does) with their newest backup in the
+directory (default: the current one), and below them the backups and restores started from it
+with their progress: the last line each one logged, such as how much of a docker export is
+written. Jobs run one at a time, like those of 'serve'.
+
+Keys:
+  ↑/↓, k/j   select a container
+  b          back up the selected container into the directory (--timestamped names)
+  r          restore the selected container's newest backup, replacing the running
+             container (a compose or multi-container backup restores all its containers);
+             asks first
+  R          reload the containers and backups
+  q, Ctrl-C  quit; running and queued jobs are canceled, after asking
+`
+}
+
+func (c *TUICmd) Validate(args []string) error { return nil }
+
+func (c *TUICmd) Execute(ctx context.Context, args []string) error {
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var logFile string
+	fs.StringVar(&logFile, "log", "", "Write the log of the jobs to this file")
+	applyClientFlags := dockerClientFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := applyClientFlags(); err != nil {
+		return err
+	}
+	dir := "."
+	if fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	inFd, inTerm := term.GetFdInfo(os.Stdin)
+	outFd, outTerm := term.GetFdInfo(os.Stdout)
+	if !inTerm || !outTerm {
+		return fmt.Errorf("tui needs a terminal; use status and backup from scripts")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	// the log would scribble over the dashboard
+	var logOut io.Writer = io.Discard
+	if logFile != "" {
+		f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		logOut = f
+	}
+	log.SetOutput(logOut)
+	defer log.SetOutput(os.Stderr)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	srv := server.NewLocal(func(log logger.Logger) backup.BackupEngine { return newDefaultEngine(log) }, dir, c.log)
+	go srv.Run(ctx)
+	dc := newDockerClient()
+	d := tui.New(dir, srv, func(ctx context.Context) ([]docker.ContainerRef, error) { return runningContainers(ctx, dc) })
+
+	state, err := term.SetRawTerminal(inFd)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = term.RestoreTerminal(inFd, state) }()
+	return tui.Run(ctx, d, os.Stdin, os.Stdout, func() (int, int) {
+		if ws, err := term.GetWinsize(outFd); err == nil && ws.Width > 0 && ws.Height > 0 {
+			return int(ws.Width), int(ws.Height)
+		}
+		return 80, 24
+	})
+}
+
+func init() {
+	RegisterCommand(&TUICmd{log: logger.New()})
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/spf13/pflag"
 )
 
 type ValidateCmd struct {
@@ -15,10 +16,19 @@ func (c *ValidateCmd) Name() string { return "validate" }
 
 func (c *ValidateCmd) Help() string {
 	return `
-Validate a backup archive.
+Validate a container or compose backup archive.
 
 Usage:
-  dockerbackup validate <backup_file>
+  dockerbackup validate <backup_file> [options]
+
+Options:
+      --deep   Read the whole archive: verify gzip CRCs and lengths, tar headers, the nested
+               volume, filesystem and image archives, and that container.json and
+               metadata.json parse
+
+Without --deep only the archive's table of contents and format are checked, which does not
+detect a truncated or corrupted archive. Compose backups are checked for the project fields
+of metadata.json, the compose-files directory and a valid container.tar.gz per service.
 `
 }
 
@@ -30,9 +40,22 @@ func (c *ValidateCmd) Validate(args []string) error {
 }
 
 func (c *ValidateCmd) Execute(ctx context.Context, args []string) error {
-	backupFile := args[0]
+	fs := pflag.NewFlagSet(c.Name(), pflag.ContinueOnError)
+	var deep bool
+	fs.BoolVar(&deep, "deep", false, "Read and verify the whole archive")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("missing backup file path")
+	}
+	backupFile := fs.Arg(0)
 	eng := newDefaultEngine(c.log)
-	res, err := eng.Validate(ctx, backupFile)
+	validate := eng.Validate
+	if deep {
+		validate = eng.ValidateDeep
+	}
+	res, err := validate(ctx, backupFile)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,85 @@
+package errors
+
+import (
+	stdErrors "errors"
+	"regexp"
+	"strings"
+)
+
+// DaemonErrorKind is a class of failures reported by the docker daemon that the user can do
+// something about.
+type DaemonErrorKind string
+
+const (
+	// ErrNameConflict: a container of the name exists
+	ErrNameConflict DaemonErrorKind = "name-conflict"
+	// ErrPortAllocated: a published host port is taken
+	ErrPortAllocated DaemonErrorKind = "port-allocated"
+	// ErrParentInterface: the parent interface of a macvlan/ipvlan network does not exist
+	ErrParentInterface DaemonErrorKind = "parent-interface"
+	// ErrNoSpace: the daemon's (or a volume's) filesystem is full
+	ErrNoSpace DaemonErrorKind = "no-space"
+)
+
+// DaemonError is a daemon failure of a known kind. Subject is what it concerns, when the
+// daemon names it: the container name, host address and port, or interface.
+type DaemonError struct {
+	Kind    DaemonErrorKind
+	Subject string
+	Err     error
+}
+
+func (e *DaemonError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DaemonError) Unwrap() error {
+	return e.Err
+}
+
+// daemonErrorPatterns recognize the daemon's messages, as printed by the docker CLI or
+// returned by the Engine API; the first group, if any, is the subject.
+var daemonErrorPatterns = []struct {
+	kind DaemonErrorKind
+	re   *regexp.Regexp
+}{
+	{ErrNameConflict, regexp.MustCompile(`container name "/?([^"]+)" is already in use`)},
+	{ErrPortAllocated, regexp.MustCompile(`Bind for (\S+) failed: port is already allocated`)},
+	{ErrPortAllocated, regexp.MustCompile(`listen (?:tcp|udp)[46]? (\S+): bind: address already in use`)},
+	{ErrPortAllocated, regexp.MustCompile(`port is already allocated|address already in use`)},
+	{ErrParentInterface, regexp.MustCompile(`parent interface (?:was )?not found on the host: (\S+)`)},
+	{ErrParentInterface, regexp.MustCompile(`parent interface|invalid subinterface vlan name`)},
+	{ErrNoSpace, regexp.MustCompile(`(?i)no space left on device`)},
+}
+
+// ClassifyDaemonError returns err as a *DaemonError when its message is a daemon failure of a
+// known kind, and err unchanged otherwise (or when it already is one).
+func ClassifyDaemonError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var de *DaemonError
+	if stdErrors.As(err, &de) {
+		return err
+	}
+	msg := err.Error()
+	for _, p := range daemonErrorPatterns {
+		m := p.re.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+		de := &DaemonError{Kind: p.kind, Err: err}
+		if len(m) > 1 {
+			de.Subject = strings.TrimRight(m[1], ":")
+		}
+		return de
+	}
+	return err
+}
+
+// AsDaemonError finds the *DaemonError in err's chain, classifying err when there is none.
+func AsDaemonError(err error) (*DaemonError, bool) {
+	var de *DaemonError
+	ok := stdErrors.As(ClassifyDaemonError(err), &de)
+	return de, ok
+}
@@ -1,7 +1,9 @@
 package logger
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"log"
 	"os"
 )
@@ -61,3 +63,54 @@ func (l *SimpleLogger) printf(level string, format string, args ...any) {
 		log.Printf("%s %s", level, fmt.Sprintf(format, args...))
 	}
 }
+
+// maxDebugLine bounds how much of one line DebugWriter logs, as subprocesses may print
+// binary data.
+const maxDebugLine = 4096
+
+// DebugWriter returns a writer logging every line written to it with l.Debugf behind prefix,
+// to show what a subprocess prints. Close logs an unterminated last line. A command's stdout
+// and stderr are usually copied from different goroutines, so l must be safe for concurrent
+// use.
+func DebugWriter(l Logger, prefix string) io.WriteCloser {
+	return &debugWriter{log: l, prefix: prefix}
+}
+
+type debugWriter struct {
+	log    Logger
+	prefix string
+	buf    []byte
+}
+
+func (w *debugWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.line(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) > maxDebugLine {
+		w.line(w.buf)
+		w.buf = w.buf[:0]
+	}
+	return len(p), nil
+}
+
+func (w *debugWriter) Close() error {
+	if len(w.buf) > 0 {
+		w.line(w.buf)
+		w.buf = nil
+	}
+	return nil
+}
+
+func (w *debugWriter) line(b []byte) {
+	b = bytes.TrimRight(b, "\r")
+	if len(b) > maxDebugLine {
+		b = append(b[:maxDebugLine:maxDebugLine], "..."...)
+	}
+	w.log.Debugf("%s%s", w.prefix, b)
+}
@@ -0,0 +1,115 @@
+// Package retry repeats operations that fail for transient reasons (a dropped connection, a
+// restarting daemon, a throttling server) with exponential backoff and jitter.
+package retry
+
+import (
+	"context"
+	stdErrors "errors"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+)
+
+// Policy says how often and how patiently an operation is retried. The zero Policy runs an
+// operation once.
+type Policy struct {
+	// Retries is the number of attempts after the first
+	Retries int
+	// Delay is the wait before the first retry; it doubles after each one up to MaxDelay
+	Delay    time.Duration
+	MaxDelay time.Duration
+	// Jitter varies each wait randomly by up to this fraction of it (0.2 = ±20%), so clients
+	// failing together do not retry in lockstep
+	Jitter float64
+	// OnRetry, if set, is called before each wait
+	OnRetry func(attempt int, err error, wait time.Duration)
+}
+
+// Default returns the policy used when nothing is configured: 5 retries, waiting about
+// 1s, 2s, 4s, 8s and 16s.
+func Default() Policy {
+	return Policy{Retries: 5, Delay: time.Second, MaxDelay: 30 * time.Second, Jitter: 0.2}
+}
+
+// FromEnv returns Default adjusted by DOCKERBACKUP_RETRIES, DOCKERBACKUP_RETRY_DELAY,
+// DOCKERBACKUP_RETRY_MAX_DELAY and DOCKERBACKUP_RETRY_JITTER, logging each retry. Invalid
+// values are ignored.
+func FromEnv() Policy {
+	p := Default()
+	log := logger.New()
+	p.OnRetry = func(attempt int, err error, wait time.Duration) {
+		log.Infof("%v; retrying in %s (retry %d)", err, wait.Truncate(time.Millisecond), attempt)
+	}
+	if n, err := strconv.Atoi(os.Getenv("DOCKERBACKUP_RETRIES")); err == nil && n >= 0 {
+		p.Retries = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("DOCKERBACKUP_RETRY_DELAY")); err == nil && d >= 0 {
+		p.Delay = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("DOCKERBACKUP_RETRY_MAX_DELAY")); err == nil && d >= 0 {
+		p.MaxDelay = d
+	}
+	if j, err := strconv.ParseFloat(os.Getenv("DOCKERBACKUP_RETRY_JITTER"), 64); err == nil && j >= 0 && j <= 1 {
+		p.Jitter = j
+	}
+	return p
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as one a retry cannot fix, so Do returns it at once.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Do runs op until it succeeds, returns a Permanent error, or the retries are used up, and
+// returns op's last error (unwrapped from Permanent). Waiting stops when ctx is done.
+func (p Policy) Do(ctx context.Context, op func() error) error {
+	wait := p.Delay
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil {
+			return nil
+		}
+		var perm *permanentError
+		if stdErrors.As(err, &perm) {
+			return perm.err
+		}
+		if attempt >= p.Retries || ctx.Err() != nil {
+			return err
+		}
+		d := p.jitter(wait)
+		if p.OnRetry != nil {
+			p.OnRetry(attempt+1, err, d)
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		wait *= 2
+		if p.MaxDelay > 0 && wait > p.MaxDelay {
+			wait = p.MaxDelay
+		}
+	}
+}
+
+func (p Policy) jitter(d time.Duration) time.Duration {
+	if p.Jitter <= 0 || d <= 0 {
+		return d
+	}
+	return d + time.Duration((rand.Float64()*2-1)*p.Jitter*float64(d))
+}
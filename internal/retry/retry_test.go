@@ -0,0 +1,74 @@
+package retry
+
+import (
+	"context"
+	stdErrors "errors"
+	"testing"
+	"time"
+)
+
+func TestDo_RetriesUntilSuccess(t *testing.T) {
+	calls := 0
+	var waits []time.Duration
+	p := Policy{Retries: 5, Delay: time.Millisecond, MaxDelay: 3 * time.Millisecond, OnRetry: func(_ int, _ error, d time.Duration) { waits = append(waits, d) }}
+	err := p.Do(context.Background(), func() error {
+		calls++
+		if calls < 4 {
+			return stdErrors.New("connection reset")
+		}
+		return nil
+	})
+	if err != nil || calls != 4 {
+		t.Fatalf("Do = %v after %d calls", err, calls)
+	}
+	want := []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond}
+	if len(waits) != len(want) {
+		t.Fatalf("waits = %v, want %v", waits, want)
+	}
+	for i := range want {
+		if waits[i] != want[i] {
+			t.Fatalf("waits = %v, want %v", waits, want)
+		}
+	}
+}
+
+func TestDo_StopsOnPermanentAndExhaustion(t *testing.T) {
+	denied := stdErrors.New("permission denied")
+	calls := 0
+	err := Policy{Retries: 3}.Do(context.Background(), func() error {
+		calls++
+		return Permanent(denied)
+	})
+	if err != denied || calls != 1 {
+		t.Fatalf("Do = %v after %d calls; want the permanent error after 1", err, calls)
+	}
+
+	calls = 0
+	err = Policy{Retries: 2}.Do(context.Background(), func() error {
+		calls++
+		return stdErrors.New("timeout")
+	})
+	if err == nil || calls != 3 {
+		t.Fatalf("Do = %v after %d calls; want failure after 3", err, calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls = 0
+	err = Policy{Retries: 10, Delay: time.Hour}.Do(ctx, func() error {
+		calls++
+		cancel()
+		return stdErrors.New("timeout")
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("Do = %v after %d calls; cancellation must stop retrying", err, calls)
+	}
+}
+
+func TestPolicy_Jitter(t *testing.T) {
+	p := Policy{Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		if d := p.jitter(time.Second); d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("jitter(1s) = %s, want within ±20%%", d)
+		}
+	}
+}
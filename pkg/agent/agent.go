@@ -0,0 +1,396 @@
+// Package agent runs dockerbackup on a Docker host as a gRPC service, and is the client a
+// controller uses to back up and restore across many such hosts from one place.
+package agent
+
+import (
+	"context"
+	"crypto/subtle"
+	stdErrors "errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/audit"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ServiceName is the agent's gRPC service.
+const ServiceName = "dockerbackup.agent.v1.Agent"
+
+// TokenEnv holds the token agents and the controller share when --token is not given.
+const TokenEnv = "DOCKERBACKUP_AGENT_TOKEN"
+
+// pushFileKey is the metadata key naming the archive a Push uploads.
+const pushFileKey = "dockerbackup-file"
+
+// chunkSize is how much of an archive goes into one Chunk.
+const chunkSize = 1 << 20
+
+// Agent serves backups, restores and the archives in one directory of a Docker host.
+type Agent struct {
+	// newEngine returns the engine an operation runs on, logging to the caller
+	newEngine func(log logger.Logger) backup.BackupEngine
+	dc        docker.DockerClient
+	dir       string
+	log       logger.Logger
+	// busy runs one backup or restore at a time, as the job queue of pkg/server does and for
+	// the same reasons
+	busy chan struct{}
+}
+
+// New returns an agent keeping its backups in dir.
+func New(newEngine func(log logger.Logger) backup.BackupEngine, dc docker.DockerClient, dir string, log logger.Logger) *Agent {
+	return &Agent{newEngine: newEngine, dc: dc, dir: dir, log: log, busy: make(chan struct{}, 1)}
+}
+
+// Serve serves the agent on ln until ctx is done, when operations in progress are canceled.
+// Every call must carry "authorization: Bearer <token>"; creds, when set, add TLS.
+func (a *Agent) Serve(ctx context.Context, ln net.Listener, token string, creds credentials.TransportCredentials) error {
+	if token == "" {
+		return &errors.ValidationError{Field: "token", Msg: "an agent token is required (--token or $" + TokenEnv + ")"}
+	}
+	check := func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, v := range md.Get("authorization") {
+			if got, ok := strings.CutPrefix(v, "Bearer "); ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+				return nil
+			}
+		}
+		return status.Error(codes.Unauthenticated, "missing or invalid token")
+	}
+	opts := []grpc.ServerOption{
+		grpc.ForceServerCodec(codec{}),
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := check(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := check(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	}
+	if creds != nil {
+		opts = append(opts, grpc.Creds(creds))
+	}
+	s := grpc.NewServer(opts...)
+	s.RegisterService(&serviceDesc, a)
+	go func() {
+		<-ctx.Done()
+		s.Stop()
+	}()
+	return s.Serve(ln)
+}
+
+func (a *Agent) info(ctx context.Context, _ *InfoRequest) (*InfoResponse, error) {
+	res := &InfoResponse{Dir: a.dir, Containers: []string{}}
+	res.Hostname, _ = os.Hostname()
+	refs, err := a.dc.ListRunningContainers(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	for _, r := range refs {
+		res.Containers = append(res.Containers, r.Name)
+	}
+	if files, err := backup.ListBackups(a.dir); err == nil {
+		res.Backups = len(files)
+	}
+	return res, nil
+}
+
+func (a *Agent) list(ctx context.Context, req *ListRequest) (*ListResponse, error) {
+	entries, err := backup.Catalog(ctx, a.dir, req.Target, req.Tags)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ListResponse{Backups: entries}, nil
+}
+
+func (a *Agent) backup(req *BackupRequest, stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	events := &eventStream{stream: stream}
+	log := &streamLogger{base: a.log, events: events}
+	release, err := a.acquire(ctx, log)
+	if err != nil {
+		return toStatus(err)
+	}
+	defer release()
+
+	containers := req.Containers
+	if req.All {
+		targets, err := backup.BackupAllTargets(ctx, a.dc, backup.BackupAllFilter{})
+		if err != nil {
+			return toStatus(err)
+		}
+		for _, t := range targets {
+			if t.Skipped == "" {
+				containers = append(containers, t.Name)
+			}
+		}
+	}
+	opts := backup.NewBackupOptionsBuilder().WithOutput(a.dir).WithTimestamped(true).WithAnnotations(req.Tags, req.Note).Build()
+	run := func(target string, breq backup.BackupRequest) error {
+		res := &BackupResult{Target: target}
+		if out, err := a.newEngine(log).Backup(ctx, breq); err != nil {
+			log.Errorf("Backup of %s failed: %v", target, err)
+			res.Error = err.Error()
+		} else {
+			res.Backup, res.Warnings = filepath.Base(out.OutputPath), out.Warnings
+		}
+		return events.send(&Event{Backup: res})
+	}
+	for _, name := range containers {
+		if b, err := a.dc.InspectContainer(ctx, name); err != nil || len(b) == 0 {
+			if err := events.send(&Event{Backup: &BackupResult{Target: name, Skipped: "no such container on this host"}}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := run(name, backup.BackupRequest{TargetType: backup.TargetContainer, ContainerID: name, Options: opts}); err != nil {
+			return err
+		}
+	}
+	for _, dir := range req.Projects {
+		if st, err := os.Stat(dir); err != nil || !st.IsDir() {
+			if err := events.send(&Event{Backup: &BackupResult{Target: dir, Skipped: "no such project directory on this host"}}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := run(dir, backup.BackupRequest{TargetType: backup.TargetCompose, ComposeProjectPath: dir, Options: opts}); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+func (a *Agent) restore(req *RestoreRequest, stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	events := &eventStream{stream: stream}
+	log := &streamLogger{base: a.log, events: events}
+	path, err := backup.FindBackup(a.dir, req.File)
+	if err != nil {
+		return toStatus(err)
+	}
+	release, err := a.acquire(ctx, log)
+	if err != nil {
+		return toStatus(err)
+	}
+	defer release()
+	args := []string{req.File}
+	if req.Name != "" {
+		args = append(args, "--name="+req.Name)
+	}
+	if req.Start {
+		args = append(args, "--start")
+	}
+	if req.Replace {
+		args = append(args, "--replace")
+	}
+	args = append(args, audit.MapFlags("network-map", req.NetworkMap)...)
+	rec, err := audit.Begin("restore", path, append(args, audit.MapFlags("volume-map", req.VolumeMap)...))
+	if err != nil {
+		return toStatus(err)
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		rec.Via = "agent " + p.Addr.String()
+	}
+	res, err := a.newEngine(log).Restore(ctx, backup.RestoreRequest{
+		BackupPath:  path,
+		ProjectName: req.Name,
+		Options: backup.RestoreOptions{
+			ContainerName:   req.Name,
+			Start:           req.Start,
+			ReplaceExisting: req.Replace,
+			NetworkMap:      req.NetworkMap,
+			VolumeMap:       req.VolumeMap,
+		},
+	})
+	if res != nil {
+		rec.Details = audit.Conflicts(res.Warnings)
+	}
+	if err := rec.End(err); err != nil {
+		return toStatus(err)
+	}
+	return events.send(&Event{Restore: &RestoreResult{RestoredID: res.RestoredID, Warnings: res.Warnings}})
+}
+
+func (a *Agent) fetch(req *FetchRequest, stream grpc.ServerStream) error {
+	path, err := backup.FindBackup(a.dir, req.File)
+	if err != nil {
+		return toStatus(err)
+	}
+	if strings.HasSuffix(path, archive.SplitManifestSuffix) {
+		return status.Errorf(codes.FailedPrecondition, "%s is split into parts, which cannot be fetched", req.File)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return toStatus(err)
+	}
+	defer func() { _ = f.Close() }()
+	for {
+		// a fresh buffer per chunk: gRPC may still hold the previous one
+		buf := make([]byte, chunkSize)
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			if err := stream.SendMsg(&Chunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if stdErrors.Is(err, io.EOF) || stdErrors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return toStatus(err)
+		}
+	}
+}
+
+// push receives an archive into the directory, under a temporary name until it is complete.
+func (a *Agent) push(stream grpc.ServerStream) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	var name string
+	if v := md.Get(pushFileKey); len(v) > 0 {
+		name = v[0]
+	}
+	if name == "" || name != filepath.Base(name) || !strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, "_latest.tar.gz") {
+		return status.Errorf(codes.InvalidArgument, "invalid archive name %q", name)
+	}
+	dest := filepath.Join(a.dir, name)
+	if _, err := os.Lstat(dest); err == nil {
+		return status.Errorf(codes.AlreadyExists, "%s exists on this host", name)
+	}
+	tmp, err := os.CreateTemp(a.dir, "."+name+".*.partial")
+	if err != nil {
+		return toStatus(err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	var size int64
+	for {
+		var c Chunk
+		err := stream.RecvMsg(&c)
+		if stdErrors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		n, err := tmp.Write(c.Data)
+		size += int64(n)
+		if err != nil {
+			_ = tmp.Close()
+			return toStatus(err)
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return toStatus(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return toStatus(err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return toStatus(err)
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return toStatus(err)
+	}
+	a.log.Infof("Received %s (%d bytes)", name, size)
+	return stream.SendMsg(&PushResponse{File: name, Size: size})
+}
+
+// acquire waits for the operation in progress, if any, to finish.
+func (a *Agent) acquire(ctx context.Context, log logger.Logger) (func(), error) {
+	select {
+	case a.busy <- struct{}{}:
+	default:
+		log.Infof("Waiting for the operation in progress on this host")
+		select {
+		case a.busy <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return func() { <-a.busy }, nil
+}
+
+// toStatus gives errors the gRPC code that fits them.
+func toStatus(err error) error {
+	var nf *errors.NotFoundError
+	var ve *errors.ValidationError
+	switch {
+	case stdErrors.As(err, &nf):
+		return status.Error(codes.NotFound, err.Error())
+	case stdErrors.As(err, &ve):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case stdErrors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case stdErrors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+// eventStream sends Events from the goroutines an operation logs from.
+type eventStream struct {
+	mu     sync.Mutex
+	stream grpc.ServerStream
+}
+
+func (s *eventStream) send(ev *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stream.SendMsg(ev)
+}
+
+// streamLogger logs to the agent's log and, at info level and above, to the caller.
+type streamLogger struct {
+	base   logger.Logger
+	events *eventStream
+	prefix string
+}
+
+func (l *streamLogger) Infof(format string, args ...any) {
+	l.base.Infof(format, args...)
+	l.send("info", format, args...)
+}
+
+func (l *streamLogger) Errorf(format string, args ...any) {
+	l.base.Errorf(format, args...)
+	l.send("error", format, args...)
+}
+
+func (l *streamLogger) Debugf(format string, args ...any) {
+	l.base.Debugf(format, args...)
+}
+
+func (l *streamLogger) With(key string, value any) logger.Logger {
+	return &streamLogger{base: l.base.With(key, value), events: l.events, prefix: l.prefix + fmt.Sprintf("[%s=%v] ", key, value)}
+}
+
+func (l *streamLogger) send(level, format string, args ...any) {
+	// a caller that went away does not fail the operation; its context is canceled instead
+	_ = l.events.send(&Event{Log: &LogLine{Time: time.Now(), Level: level, Message: l.prefix + fmt.Sprintf(format, args...)}})
+}
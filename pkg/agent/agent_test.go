@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	stdErrors "errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/audit"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/docker"
+)
+
+// fakeDocker knows one running container, web; other DockerClient methods are not used.
+type fakeDocker struct {
+	docker.DockerClient
+}
+
+func (fakeDocker) ListRunningContainers(ctx context.Context) ([]docker.ContainerRef, error) {
+	return []docker.ContainerRef{{ID: "1", Name: "web"}}, nil
+}
+
+func (fakeDocker) ListContainersByLabel(ctx context.Context, label string) ([]docker.ContainerRef, error) {
+	return nil, nil
+}
+
+func (fakeDocker) InspectContainer(ctx context.Context, name string) ([]byte, error) {
+	if name != "web" {
+		return nil, stdErrors.New("no such container")
+	}
+	return []byte(`{"Id":"1"}`), nil
+}
+
+type fakeEngine struct {
+	dir string
+	log logger.Logger
+}
+
+func (f *fakeEngine) Backup(ctx context.Context, req backup.BackupRequest) (*backup.BackupResult, error) {
+	f.log.Infof("Backing up %s", req.ContainerID)
+	out := filepath.Join(f.dir, req.ContainerID+"_2024-01-02T03-04-05.tar.gz")
+	return &backup.BackupResult{OutputPath: out}, os.WriteFile(out, bytes.Repeat([]byte("x"), chunkSize+10), 0o644)
+}
+
+func (f *fakeEngine) Restore(ctx context.Context, req backup.RestoreRequest) (*backup.RestoreResult, error) {
+	return &backup.RestoreResult{RestoredID: "abc"}, nil
+}
+
+func (f *fakeEngine) Validate(ctx context.Context, backupPath string) (*backup.ValidationResult, error) {
+	return &backup.ValidationResult{Valid: true}, nil
+}
+
+func (f *fakeEngine) ValidateDeep(ctx context.Context, backupPath string) (*backup.ValidationResult, error) {
+	return &backup.ValidationResult{Valid: true}, nil
+}
+
+func startAgent(t *testing.T, dir string) string {
+	t.Helper()
+	a := New(func(log logger.Logger) backup.BackupEngine { return &fakeEngine{dir: dir, log: log} }, fakeDocker{}, dir, logger.New())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = a.Serve(ctx, ln, "secret", nil)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return ln.Addr().String()
+}
+
+func TestAgent(t *testing.T) {
+	dir := t.TempDir()
+	auditLog := filepath.Join(t.TempDir(), "audit.jsonl")
+	t.Setenv(audit.PathEnv, auditLog)
+	addr := startAgent(t, dir)
+	ctx := context.Background()
+	c, err := Dial("host1", addr, "secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	info, err := c.Info(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Containers) != 1 || info.Containers[0] != "web" || info.Dir != dir {
+		t.Fatalf("info = %+v", info)
+	}
+
+	var logged []string
+	results, err := c.Backup(ctx, &BackupRequest{Containers: []string{"web", "ghost"}}, func(l LogLine) { logged = append(logged, l.Message) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Backup != "web_2024-01-02T03-04-05.tar.gz" || results[1].Skipped == "" {
+		t.Fatalf("results = %+v", results)
+	}
+	if !strings.Contains(strings.Join(logged, "\n"), "Backing up web") {
+		t.Fatalf("logged = %q", logged)
+	}
+
+	var buf bytes.Buffer
+	n, err := c.Fetch(ctx, results[0].Backup, &buf)
+	if err != nil || n != chunkSize+10 || buf.Len() != chunkSize+10 {
+		t.Fatalf("fetch: %d bytes, %v", n, err)
+	}
+	if _, err := c.Fetch(ctx, "../etc/passwd", &bytes.Buffer{}); err == nil {
+		t.Fatal("fetched a file outside the directory")
+	}
+
+	pushed, err := c.Push(ctx, "db_2024-01-01T00-00-00.tar.gz", bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pushed.Size != int64(buf.Len()) {
+		t.Fatalf("pushed %d bytes, want %d", pushed.Size, buf.Len())
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "db_2024-01-01T00-00-00.tar.gz")); err != nil || !bytes.Equal(b, buf.Bytes()) {
+		t.Fatalf("pushed archive differs: %v", err)
+	}
+	var aerr *Error
+	if _, err := c.Push(ctx, "db_2024-01-01T00-00-00.tar.gz", strings.NewReader("x")); !stdErrors.As(err, &aerr) || aerr.Code != "AlreadyExists" {
+		t.Fatalf("push over an archive: %v", err)
+	}
+
+	res, err := c.Restore(ctx, &RestoreRequest{File: "db_2024-01-01T00-00-00.tar.gz", Name: "db2"}, nil)
+	if err != nil || res.RestoredID != "abc" {
+		t.Fatalf("restore: %+v, %v", res, err)
+	}
+	if entries, err := audit.Read(auditLog); err != nil || len(entries) != 1 || !strings.HasPrefix(entries[0].Via, "agent ") || entries[0].Args[1] != "--name=db2" {
+		t.Fatalf("audit log = %+v, %v", entries, err)
+	}
+	if _, err := c.Restore(ctx, &RestoreRequest{File: "missing.tar.gz"}, nil); !stdErrors.As(err, &aerr) || aerr.Code != "NotFound" {
+		t.Fatalf("restore of a missing archive: %v", err)
+	}
+
+	bad, err := Dial("host1", addr, "wrong", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bad.Close()
+	if _, err := bad.Info(ctx); !stdErrors.As(err, &aerr) || aerr.Code != "Unauthenticated" {
+		t.Fatalf("wrong token: %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	stdErrors "errors"
+	"fmt"
+	"io"
+
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Client calls one agent.
+type Client struct {
+	// Name identifies the agent in output, its address unless named
+	Name string
+	Addr string
+	conn *grpc.ClientConn
+}
+
+// Dial returns a client of the agent at addr (host:port). Without tlsCfg the connection is not
+// encrypted, and the token travels in the clear.
+func Dial(name, addr, token string, tlsCfg *tls.Config) (*Client, error) {
+	if name == "" {
+		name = addr
+	}
+	creds := insecure.NewCredentials()
+	if tlsCfg != nil {
+		creds = credentials.NewTLS(tlsCfg)
+	}
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithPerRPCCredentials(tokenAuth{token: token, secure: tlsCfg != nil}),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(codec{})),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("agent %s: %w", name, err)
+	}
+	return &Client{Name: name, Addr: addr, conn: conn}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Info describes the agent's host.
+func (c *Client) Info(ctx context.Context) (*InfoResponse, error) {
+	var res InfoResponse
+	if err := c.conn.Invoke(ctx, methodName("Info"), &InfoRequest{}, &res); err != nil {
+		return nil, c.wrap(err)
+	}
+	return &res, nil
+}
+
+// List returns the agent's backups, newest first.
+func (c *Client) List(ctx context.Context, req *ListRequest) ([]ListEntry, error) {
+	var res ListResponse
+	if err := c.conn.Invoke(ctx, methodName("List"), req, &res); err != nil {
+		return nil, c.wrap(err)
+	}
+	out := make([]ListEntry, 0, len(res.Backups))
+	for _, b := range res.Backups {
+		out = append(out, ListEntry{Agent: c.Name, CatalogEntry: b})
+	}
+	return out, nil
+}
+
+// Backup runs a backup on the agent, calling onLog for each line it logs, and returns the
+// result of each container and project.
+func (c *Client) Backup(ctx context.Context, req *BackupRequest, onLog func(LogLine)) ([]BackupResult, error) {
+	var results []BackupResult
+	err := c.events(ctx, "Backup", req, onLog, func(ev *Event) {
+		if ev.Backup != nil {
+			results = append(results, *ev.Backup)
+		}
+	})
+	return results, err
+}
+
+// Restore restores one of the agent's backups, calling onLog for each line it logs.
+func (c *Client) Restore(ctx context.Context, req *RestoreRequest, onLog func(LogLine)) (*RestoreResult, error) {
+	var res *RestoreResult
+	err := c.events(ctx, "Restore", req, onLog, func(ev *Event) {
+		if ev.Restore != nil {
+			res = ev.Restore
+		}
+	})
+	if err == nil && res == nil {
+		err = fmt.Errorf("agent %s: the restore ended without a result", c.Name)
+	}
+	return res, err
+}
+
+func (c *Client) events(ctx context.Context, method string, req any, onLog func(LogLine), onEvent func(ev *Event)) error {
+	stream, err := c.conn.NewStream(ctx, streamDesc(method), methodName(method))
+	if err != nil {
+		return c.wrap(err)
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return c.wrap(err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		return c.wrap(err)
+	}
+	for {
+		var ev Event
+		err := stream.RecvMsg(&ev)
+		if stdErrors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return c.wrap(err)
+		}
+		if ev.Log != nil && onLog != nil {
+			onLog(*ev.Log)
+		}
+		onEvent(&ev)
+	}
+}
+
+// Fetch writes the archive file of the agent's directory to w.
+func (c *Client) Fetch(ctx context.Context, file string, w io.Writer) (int64, error) {
+	stream, err := c.conn.NewStream(ctx, streamDesc("Fetch"), methodName("Fetch"))
+	if err != nil {
+		return 0, c.wrap(err)
+	}
+	if err := stream.SendMsg(&FetchRequest{File: file}); err != nil {
+		return 0, c.wrap(err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		return 0, c.wrap(err)
+	}
+	var n int64
+	for {
+		var chunk Chunk
+		err := stream.RecvMsg(&chunk)
+		if stdErrors.Is(err, io.EOF) {
+			return n, nil
+		}
+		if err != nil {
+			return n, c.wrap(err)
+		}
+		m, err := w.Write(chunk.Data)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// Push uploads an archive read from r into the agent's directory as file, which must not
+// exist there yet.
+func (c *Client) Push(ctx context.Context, file string, r io.Reader) (*PushResponse, error) {
+	// canceling the call when r fails makes the agent drop what it received
+	ctx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(ctx, pushFileKey, file))
+	defer cancel()
+	stream, err := c.conn.NewStream(ctx, streamDesc("Push"), methodName("Push"))
+	if err != nil {
+		return nil, c.wrap(err)
+	}
+	for {
+		// a fresh buffer per chunk: gRPC may still hold the previous one
+		buf := make([]byte, chunkSize)
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if serr := stream.SendMsg(&Chunk{Data: buf[:n]}); serr != nil {
+				// the agent ended the call; RecvMsg tells why
+				break
+			}
+		}
+		if stdErrors.Is(err, io.EOF) || stdErrors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, c.wrap(err)
+	}
+	var res PushResponse
+	if err := stream.RecvMsg(&res); err != nil {
+		return nil, c.wrap(err)
+	}
+	return &res, nil
+}
+
+// wrap names the agent in an error and drops the gRPC framing of the message.
+func (c *Client) wrap(err error) error {
+	if st, ok := status.FromError(err); ok {
+		return &Error{Agent: c.Name, Code: st.Code().String(), Msg: st.Message()}
+	}
+	return fmt.Errorf("agent %s: %w", c.Name, err)
+}
+
+// Error is an error returned by an agent.
+type Error struct {
+	Agent string
+	// Code is the gRPC status code, such as NotFound or Unavailable
+	Code string
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("agent %s: %s", e.Agent, e.Msg)
+}
+
+// ListEntry is a backup listed by an agent.
+type ListEntry struct {
+	Agent string `json:"agent"`
+	backup.CatalogEntry
+}
+
+// tokenAuth sends the token with every call.
+type tokenAuth struct {
+	token  string
+	secure bool
+}
+
+func (t tokenAuth) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + t.token}, nil
+}
+
+func (t tokenAuth) RequireTransportSecurity() bool {
+	return t.secure
+}
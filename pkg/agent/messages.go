@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/brian033/dockerbackup/pkg/backup"
+)
+
+type InfoRequest struct{}
+
+// InfoResponse describes an agent's host.
+type InfoResponse struct {
+	Hostname string `json:"hostname"`
+	// Dir is where the agent keeps its backups
+	Dir        string   `json:"dir"`
+	Containers []string `json:"containers"`
+	Backups    int      `json:"backups"`
+}
+
+// ListRequest filters the backups an agent lists, as `backups list --target/--tag` does.
+type ListRequest struct {
+	Target string   `json:"target,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+type ListResponse struct {
+	Backups []backup.CatalogEntry `json:"backups"`
+}
+
+// BackupRequest asks an agent to back up containers and compose projects into its directory,
+// each into its own timestamped archive. Containers the host does not have are skipped, so a
+// controller can send the same request to every agent.
+type BackupRequest struct {
+	Containers []string `json:"containers,omitempty"`
+	// All backs up every running container not labeled dockerbackup.ignore=true
+	All bool `json:"all,omitempty"`
+	// Projects are compose project directories on the agent's host
+	Projects []string `json:"projects,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Note     string   `json:"note,omitempty"`
+}
+
+// BackupResult is the outcome of one container or project of a BackupRequest.
+type BackupResult struct {
+	Target string `json:"target"`
+	// Backup is the archive written, a file name in the agent's directory
+	Backup   string           `json:"backup,omitempty"`
+	Skipped  string           `json:"skipped,omitempty"`
+	Error    string           `json:"error,omitempty"`
+	Warnings []backup.Warning `json:"warnings,omitempty"`
+}
+
+// RestoreRequest asks an agent to restore one of its backups.
+type RestoreRequest struct {
+	// File is the archive's name in the agent's directory
+	File       string            `json:"file"`
+	Name       string            `json:"name,omitempty"`
+	Start      bool              `json:"start,omitempty"`
+	Replace    bool              `json:"replace,omitempty"`
+	NetworkMap map[string]string `json:"networkMap,omitempty"`
+	VolumeMap  map[string]string `json:"volumeMap,omitempty"`
+}
+
+type RestoreResult struct {
+	RestoredID string           `json:"restoredId,omitempty"`
+	Warnings   []backup.Warning `json:"warnings,omitempty"`
+}
+
+// Event is a message of the Backup and Restore streams: a line the operation logged, or a
+// result.
+type Event struct {
+	Log     *LogLine       `json:"log,omitempty"`
+	Backup  *BackupResult  `json:"backup,omitempty"`
+	Restore *RestoreResult `json:"restore,omitempty"`
+}
+
+// LogLine is a message logged by an operation on the agent.
+type LogLine struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+}
+
+type FetchRequest struct {
+	File string `json:"file"`
+}
+
+// Chunk is a piece of an archive streamed by Fetch and Push.
+type Chunk struct {
+	Data []byte
+}
+
+type PushResponse struct {
+	File string `json:"file"`
+	Size int64  `json:"size"`
+}
+
+// codec encodes the agent's messages as JSON, except Chunk, which travels as raw bytes so
+// archives are not inflated by base64. gRPC only needs a codec both ends agree on; with plain
+// Go types for messages there is no protobuf schema to compile.
+type codec struct{}
+
+func (codec) Name() string { return "dockerbackup" }
+
+func (codec) Marshal(v any) ([]byte, error) {
+	if c, ok := v.(*Chunk); ok {
+		return c.Data, nil
+	}
+	return json.Marshal(v)
+}
+
+func (codec) Unmarshal(data []byte, v any) error {
+	if c, ok := v.(*Chunk); ok {
+		// data belongs to gRPC once Unmarshal returns
+		c.Data = append(c.Data[:0], data...)
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
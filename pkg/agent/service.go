@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// service is what the Agent implements for serviceDesc.
+type service interface {
+	info(ctx context.Context, req *InfoRequest) (*InfoResponse, error)
+	list(ctx context.Context, req *ListRequest) (*ListResponse, error)
+	backup(req *BackupRequest, stream grpc.ServerStream) error
+	restore(req *RestoreRequest, stream grpc.ServerStream) error
+	fetch(req *FetchRequest, stream grpc.ServerStream) error
+	push(stream grpc.ServerStream) error
+}
+
+// serviceDesc describes the agent's service as protoc-gen-go-grpc would, for the messages of
+// messages.go:
+//
+//	rpc Info(InfoRequest) returns (InfoResponse)
+//	rpc List(ListRequest) returns (ListResponse)
+//	rpc Backup(BackupRequest) returns (stream Event)
+//	rpc Restore(RestoreRequest) returns (stream Event)
+//	rpc Fetch(FetchRequest) returns (stream Chunk)
+//	rpc Push(stream Chunk) returns (PushResponse)
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*service)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("Info", service.info),
+		unaryMethod("List", service.list),
+	},
+	Streams: []grpc.StreamDesc{
+		serverStream("Backup", service.backup),
+		serverStream("Restore", service.restore),
+		serverStream("Fetch", service.fetch),
+		{
+			StreamName:    "Push",
+			ClientStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				return srv.(service).push(stream)
+			},
+		},
+	},
+}
+
+func unaryMethod[Req, Resp any](name string, fn func(s service, ctx context.Context, req *Req) (*Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			call := func(ctx context.Context, req any) (any, error) {
+				return fn(srv.(service), ctx, req.(*Req))
+			}
+			if interceptor == nil {
+				return call(ctx, req)
+			}
+			return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: methodName(name)}, call)
+		},
+	}
+}
+
+func serverStream[Req any](name string, fn func(s service, req *Req, stream grpc.ServerStream) error) grpc.StreamDesc {
+	return grpc.StreamDesc{
+		StreamName:    name,
+		ServerStreams: true,
+		Handler: func(srv any, stream grpc.ServerStream) error {
+			req := new(Req)
+			if err := stream.RecvMsg(req); err != nil {
+				return err
+			}
+			return fn(srv.(service), req, stream)
+		},
+	}
+}
+
+// methodName is the full gRPC name of a method of the service.
+func methodName(name string) string {
+	return "/" + ServiceName + "/" + name
+}
+
+// streamDesc returns the description of the stream called name.
+func streamDesc(name string) *grpc.StreamDesc {
+	for i := range serviceDesc.Streams {
+		if serviceDesc.Streams[i].StreamName == name {
+			return &serviceDesc.Streams[i]
+		}
+	}
+	panic("unknown stream " + name)
+}
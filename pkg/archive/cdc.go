@@ -0,0 +1,98 @@
+package archive
+
+import (
+	"io"
+	"math/bits"
+)
+
+// Content-defined chunking cuts a stream where a rolling hash of the last 64 bytes matches a
+// pattern, so the cuts move with the data: inserting or removing bytes changes the chunks
+// around the edit only, and the rest of the stream yields the same chunks as before.
+
+// gearTable maps each byte to a fixed pseudo-random value of the gear rolling hash. It must
+// never change: chunks cut by older versions would no longer match.
+var gearTable = func() (t [256]uint64) {
+	// splitmix64
+	x := uint64(0x6462626163647263)
+	for i := range t {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		t[i] = z ^ z>>31
+	}
+	return t
+}()
+
+// cutMask matches on average every avg bytes; it tests the high bits of the hash, which
+// depend on all of the last 64 bytes.
+func cutMask(avg int) uint64 {
+	n := bits.Len(uint(avg)) - 1
+	return (uint64(1)<<n - 1) << (64 - n)
+}
+
+// Rsyncable gzip members: a new member starts at a content-defined point of the uncompressed
+// data, so unchanged data compresses to the same bytes whatever changed before it.
+const (
+	rsyncMinMember = 64 << 10
+	rsyncAvgMember = 1 << 20
+	rsyncMaxMember = 8 << 20
+)
+
+var rsyncMask = cutMask(rsyncAvgMember)
+
+// Chunk sizes of NewChunker.
+const (
+	ChunkMinSize = 256 << 10
+	ChunkAvgSize = 1 << 20
+	ChunkMaxSize = 8 << 20
+)
+
+// Chunker splits a stream into content-defined chunks of ChunkMinSize to ChunkMaxSize bytes.
+type Chunker struct {
+	r    io.Reader
+	buf  []byte
+	n    int
+	eof  bool
+	mask uint64
+}
+
+func NewChunker(r io.Reader) *Chunker {
+	return &Chunker{r: r, buf: make([]byte, ChunkMaxSize), mask: cutMask(ChunkAvgSize)}
+}
+
+// Next returns the next chunk, or io.EOF after the last one.
+func (c *Chunker) Next() ([]byte, error) {
+	for !c.eof && c.n < len(c.buf) {
+		k, err := c.r.Read(c.buf[c.n:])
+		c.n += k
+		if err == io.EOF {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if c.n == 0 {
+		return nil, io.EOF
+	}
+	cut := c.n
+	if c.n > ChunkMinSize {
+		var h uint64
+		// warm the hash up on the 64 bytes before the first possible cut
+		for _, b := range c.buf[ChunkMinSize-64 : ChunkMinSize] {
+			h = h<<1 + gearTable[b]
+		}
+		for i := ChunkMinSize; i < c.n; i++ {
+			h = h<<1 + gearTable[c.buf[i]]
+			if h&c.mask == 0 {
+				cut = i + 1
+				break
+			}
+		}
+	}
+	// hand out a copy of the chunk and keep the rest for the next call
+	out := make([]byte, cut)
+	copy(out, c.buf[:cut])
+	c.n = copy(c.buf, c.buf[cut:c.n])
+	return out, nil
+}
@@ -1,3 +1,101 @@
 package archive
 
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
 const DefaultCompressionLevel = 6
+
+// compressBlockSize is the amount of input each pgzip goroutine compresses at a time.
+const compressBlockSize = 1 << 20
+
+// With auto compression, files of at least autoMinSize whose content is already compressed
+// are stored in a gzip member of their own without compressing them again. Smaller files are
+// not worth ending a member for.
+const autoMinSize = 1 << 20
+
+// autoSampleSize is the size of each window isCompressed tries to compress, and
+// autoMaxRatio the compressed/original ratio above which a file counts as compressed.
+const (
+	autoSampleSize = 64 << 10
+	autoMaxRatio   = 0.95
+)
+
+// compressedExts are extensions of formats that are compressed already.
+var compressedExts = map[string]bool{
+	".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".txz": true, ".zst": true, ".lz4": true,
+	".zip": true, ".7z": true, ".rar": true, ".br": true, ".jar": true, ".war": true, ".apk": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".avif": true,
+	".mp4": true, ".m4v": true, ".mkv": true, ".webm": true, ".mov": true, ".avi": true,
+	".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".opus": true, ".flac": true,
+	".woff2": true,
+}
+
+// compressedMagic are the leading bytes of compressed formats, for files without a telling
+// extension such as the layer blobs of an OCI image.
+var compressedMagic = [][]byte{
+	{0x1f, 0x8b},                       // gzip
+	{0x28, 0xb5, 0x2f, 0xfd},           // zstd
+	{0xfd, '7', 'z', 'X', 'Z', 0x00},   // xz
+	[]byte("BZh"),                      // bzip2
+	{0x04, 0x22, 0x4d, 0x18},           // lz4
+	[]byte("PK\x03\x04"),               // zip, jar, docx
+	{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}, // 7z
+	[]byte("Rar!"),                     // rar
+	{0xff, 0xd8, 0xff},                 // jpeg
+	{0x89, 'P', 'N', 'G'},              // png
+	[]byte("GIF8"),                     // gif
+	{0x1a, 0x45, 0xdf, 0xa3},           // matroska, webm
+	[]byte("OggS"),                     // ogg
+	[]byte("fLaC"),                     // flac
+	[]byte("ID3"),                      // mp3
+}
+
+// isCompressed reports whether the regular file at path holds data that gzip would barely
+// shrink: a known compressed format by extension or leading bytes, or else content that
+// does not compress in samples from its start, middle and end.
+func isCompressed(path string, size int64) (bool, error) {
+	if compressedExts[strings.ToLower(filepath.Ext(path))] {
+		return true, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = f.Close() }()
+	buf := make([]byte, autoSampleSize)
+	var in, out int64
+	for i, off := range []int64{0, size/2 - autoSampleSize/2, size - autoSampleSize} {
+		n, err := f.ReadAt(buf, max(off, 0))
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		if i == 0 && hasCompressedMagic(buf[:n]) {
+			return true, nil
+		}
+		c := &countingWriter{w: io.Discard}
+		fw, _ := flate.NewWriter(c, flate.BestSpeed)
+		_, _ = fw.Write(buf[:n])
+		_ = fw.Close()
+		in, out = in+int64(n), out+c.n
+	}
+	return in > 0 && float64(out) >= autoMaxRatio*float64(in), nil
+}
+
+func hasCompressedMagic(b []byte) bool {
+	for _, m := range compressedMagic {
+		if bytes.HasPrefix(b, m) {
+			return true
+		}
+	}
+	// ISO base media (mp4, mov, m4a, heic): a box size, then "ftyp"; RIFF with WEBP or AVI
+	if len(b) >= 12 && (string(b[4:8]) == "ftyp" || string(b[:4]) == "RIFF" && (string(b[8:12]) == "WEBP" || string(b[8:12]) == "AVI ")) {
+		return true
+	}
+	return false
+}
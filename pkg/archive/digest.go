@@ -0,0 +1,138 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ContentSummary describes the data under one directory of an archive independently of how
+// it was archived: ContentDigest yields the same summary for a volume's backup archive and for
+// an archive of the volume taken after it was restored, unless files went missing or changed.
+type ContentSummary struct {
+	// Digest covers the path and type of every entry, the size and content of regular files
+	// and the target of symlinks; owners, modes and times are left out as restores may map them
+	Digest string `json:"digest"`
+	Files  int    `json:"files"`
+	Bytes  int64  `json:"bytes"`
+	// Sparse counts the files stored without their holes; it is not part of Digest
+	Sparse int `json:"sparse,omitempty"`
+}
+
+// ContentDigest summarizes the entries under root/ in the tar.gz at tarGzPath (all entries
+// when root is empty). Hard links count as the file they link to.
+func ContentDigest(ctx context.Context, tarGzPath, root string) (*ContentSummary, error) {
+	f, err := os.Open(tarGzPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return ContentDigestReader(ctx, f, root)
+}
+
+// ContentDigestReader is ContentDigest for a tar.gz read from r.
+func ContentDigestReader(ctx context.Context, r io.Reader, root string) (*ContentSummary, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = gr.Close() }()
+
+	root = strings.Trim(path.Clean("/"+root), "/")
+	type record struct {
+		kind string
+		size int64
+		sum  string
+		link string
+	}
+	records := map[string]record{}
+	sparse := 0
+	tr := tar.NewReader(gr)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.Trim(path.Clean("/"+hdr.Name), "/")
+		if root != "" {
+			rel, ok := strings.CutPrefix(name, root+"/")
+			if !ok {
+				continue
+			}
+			name = rel
+		} else if name == SeekEntryName || name == IndexEntryName {
+			continue
+		}
+		if name == "" {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			records[name] = record{kind: "d"}
+		case tar.TypeReg, tar.TypeRegA:
+			h := sha256.New()
+			n, err := io.Copy(h, tr)
+			if err != nil {
+				return nil, fmt.Errorf("read %s: %w", hdr.Name, err)
+			}
+			records[name] = record{kind: "f", size: n, sum: hex.EncodeToString(h.Sum(nil))}
+			if isSparseHeader(hdr) {
+				sparse++
+			}
+		case tar.TypeSymlink:
+			records[name] = record{kind: "l", link: hdr.Linkname}
+		case tar.TypeLink:
+			target := strings.Trim(path.Clean("/"+hdr.Linkname), "/")
+			if root != "" {
+				target = strings.TrimPrefix(target, root+"/")
+			}
+			records[name] = record{kind: "h", link: target}
+		default:
+			records[name] = record{kind: "o"}
+		}
+	}
+
+	names := make([]string, 0, len(records))
+	for name := range records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	res := &ContentSummary{Sparse: sparse}
+	for _, name := range names {
+		r := records[name]
+		if r.kind == "h" {
+			// a hard link is the file it links to, however each side stored it
+			if target, ok := records[r.link]; ok && target.kind == "f" {
+				r = target
+			}
+		}
+		switch r.kind {
+		case "f":
+			fmt.Fprintf(h, "f %q %d %s\n", name, r.size, r.sum)
+			res.Files++
+			res.Bytes += r.size
+		case "l", "h":
+			fmt.Fprintf(h, "%s %q %q\n", r.kind, name, r.link)
+		default:
+			fmt.Fprintf(h, "%s %q\n", r.kind, name)
+		}
+	}
+	res.Digest = "sha256:" + hex.EncodeToString(h.Sum(nil))
+	return res, nil
+}
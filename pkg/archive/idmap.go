@@ -0,0 +1,127 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// IDMap maps the user and group IDs recorded in an archive to the IDs the extracted files
+// get, for restoring onto a host (or a userns-remap daemon) that numbers users differently.
+// IDs are shifted out of From, mapped through UIDs and GIDs, then shifted into To; IDs
+// outside the ranges and not in a map are kept.
+type IDMap struct {
+	UIDs map[int]int
+	GIDs map[int]int
+	// From is the subordinate range the recorded IDs lie in, when the data was read on the
+	// host of a userns-remap daemon
+	From *IDRange
+	// To is the subordinate range to shift the IDs into, when the data is written on the host
+	// of a userns-remap daemon
+	To *IDRange
+}
+
+// IDRange is a subordinate ID range: namespace IDs 0..Size-1 are host IDs UID.. and GID..
+type IDRange struct {
+	UID, GID, Size int
+}
+
+// Empty reports whether m changes no IDs.
+func (m IDMap) Empty() bool {
+	return len(m.UIDs) == 0 && len(m.GIDs) == 0 && m.From == nil && m.To == nil
+}
+
+// Map returns the IDs for an entry owned by uid and gid. Each ID is mapped once, so
+// swapping two IDs (1000:1001 and 1001:1000) works.
+func (m IDMap) Map(uid, gid int) (int, int) {
+	if r := m.From; r != nil {
+		if uid >= r.UID && uid < r.UID+r.Size {
+			uid -= r.UID
+		}
+		if gid >= r.GID && gid < r.GID+r.Size {
+			gid -= r.GID
+		}
+	}
+	if n, ok := m.UIDs[uid]; ok {
+		uid = n
+	}
+	if n, ok := m.GIDs[gid]; ok {
+		gid = n
+	}
+	if r := m.To; r != nil {
+		if uid < r.Size {
+			uid += r.UID
+		}
+		if gid < r.Size {
+			gid += r.GID
+		}
+	}
+	return uid, gid
+}
+
+// RemapTarGz copies the tar.gz at src to dst with the owners of its entries mapped through
+// ids, for extraction where the ownership cannot be changed on the way (inside a helper
+// container). Owner names are dropped, as they no longer match the renumbered IDs.
+func RemapTarGz(ctx context.Context, src, dst string, ids IDMap) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	gr, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = gr.Close() }()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	// the copy is only extracted once, so favour speed
+	gw, _ := gzip.NewWriterLevel(out, gzip.BestSpeed)
+	tw := tar.NewWriter(gw)
+	err = func() error {
+		tr := tar.NewReader(gr)
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if hdr.Name == SeekEntryName {
+				// its offsets do not hold for the copy
+				continue
+			}
+			hdr.Uid, hdr.Gid = ids.Map(hdr.Uid, hdr.Gid)
+			hdr.Uname, hdr.Gname = "", ""
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				return fmt.Errorf("copy %s: %w", hdr.Name, err)
+			}
+		}
+	}()
+	if err == nil {
+		err = tw.Close()
+	}
+	if cerr := gw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package archive
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// IndexEntryName is the table of contents written as the first entry of archives created by
+// TarArchiveHandler, so listing needs to decompress only the head of the stream.
+const IndexEntryName = ".index.json"
+
+// maxIndexEntries bounds the index: sources with more files (a large volume or bind mount
+// archive) are written without one and listed by scanning.
+const maxIndexEntries = 10000
+
+const indexVersion = 1
+
+type archiveIndex struct {
+	Version int            `json:"version"`
+	Entries []ArchiveEntry `json:"entries"`
+}
+
+var errIndexTooLarge = errors.New("too many entries for an index")
+
+// indexSources walks sources the way writeIndexedTar will and returns their entries, or nil when
+// there are more than maxIndexEntries.
+func indexSources(ctx context.Context, sources []ArchiveSource) ([]ArchiveEntry, error) {
+	var entries []ArchiveEntry
+	for _, src := range sources {
+		err := walkSource(ctx, src, func(path string, fi os.FileInfo, nameInTar string) error {
+			if len(entries) == maxIndexEntries {
+				return errIndexTooLarge
+			}
+			hdr, err := tar.FileInfoHeader(fi, "")
+			if err != nil {
+				return err
+			}
+			sparse := fi.Mode().IsRegular() && fi.Size() >= sparseMinSize && mayBeSparse(fi)
+			entries = append(entries, ArchiveEntry{Path: tarName(nameInTar, fi), Size: hdr.Size, Mode: hdr.Mode, Type: tarTypeToString(hdr.Typeflag), Sparse: sparse})
+			return nil
+		})
+		if errors.Is(err, errIndexTooLarge) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+func writeIndex(tw *tar.Writer, entries []ArchiveEntry) error {
+	b, err := json.Marshal(archiveIndex{Version: indexVersion, Entries: entries})
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: IndexEntryName, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(b)), ModTime: time.Now().Truncate(time.Second)}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = tw.Write(b)
+	return err
+}
+
+// readIndex decodes the index if hdr (the first entry of tr) is one; ok is false otherwise.
+func readIndex(tr *tar.Reader, hdr *tar.Header) ([]ArchiveEntry, bool, error) {
+	if hdr.Name != IndexEntryName || (hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA) {
+		return nil, false, nil
+	}
+	var idx archiveIndex
+	if err := json.NewDecoder(tr).Decode(&idx); err != nil {
+		return nil, false, fmt.Errorf("read archive index: %w", err)
+	}
+	if idx.Version > indexVersion {
+		// written by a newer release: fall back to scanning
+		return nil, false, nil
+	}
+	return idx.Entries, true, nil
+}
+
+func indexHasFile(entries []ArchiveEntry, name string) bool {
+	for _, en := range entries {
+		if en.Type == "file" && strings.TrimPrefix(en.Path, "./") == name {
+			return true
+		}
+	}
+	return false
+}
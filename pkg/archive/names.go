@@ -0,0 +1,31 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"unicode/utf8"
+)
+
+// MaxNameLen bounds the names dockerbackup derives from container, project, volume and host
+// names for files, temp dirs and archive entries. Many filesystems (and Windows) reject path
+// components over 255 bytes; the margin leaves room for the suffixes added to a name, such as
+// timestamps, ".tar.gz", ".part001" or the random part of a temp dir.
+const MaxNameLen = 128
+
+// shortNameHashLen is the number of hex digits of the hash ending a shortened name.
+const shortNameHashLen = 8
+
+// ShortenName returns name if it is at most MaxNameLen bytes long. Longer names are cut (at a
+// UTF-8 boundary) and end in "-" and a hash of the whole name, so the result is the same on
+// every run and distinct long names with a common prefix stay distinct.
+func ShortenName(name string) string {
+	if len(name) <= MaxNameLen {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	cut := MaxNameLen - 1 - shortNameHashLen
+	for cut > 0 && !utf8.RuneStart(name[cut]) {
+		cut--
+	}
+	return name[:cut] + "-" + hex.EncodeToString(sum[:])[:shortNameHashLen]
+}
@@ -0,0 +1,35 @@
+package archive
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestShortenName(t *testing.T) {
+	if got := ShortenName("web"); got != "web" {
+		t.Fatalf("short names must be kept, got %q", got)
+	}
+	exact := strings.Repeat("a", MaxNameLen)
+	if got := ShortenName(exact); got != exact {
+		t.Fatalf("a name of exactly MaxNameLen bytes must be kept")
+	}
+
+	long := strings.Repeat("service-", 40)
+	a, b := ShortenName(long+"a"), ShortenName(long+"b")
+	if len(a) > MaxNameLen || len(b) > MaxNameLen {
+		t.Fatalf("shortened names too long: %d, %d", len(a), len(b))
+	}
+	if a == b || a != ShortenName(long+"a") {
+		t.Fatalf("shortening must be deterministic and keep names distinct: %q, %q", a, b)
+	}
+	if !strings.HasPrefix(a, long[:MaxNameLen-1-shortNameHashLen]) {
+		t.Fatalf("shortened name should keep the start of the name: %q", a)
+	}
+
+	// multi-byte runes are not split
+	wide := ShortenName(strings.Repeat("é", 100))
+	if len(wide) > MaxNameLen || !utf8.ValidString(wide) {
+		t.Fatalf("invalid shortened name %q (%d bytes)", wide, len(wide))
+	}
+}
@@ -0,0 +1,219 @@
+package archive
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OCI media types used when converting `docker save` output.
+const (
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigMediaType   = "application/vnd.oci.image.config.v1+json"
+	ociLayerMediaType    = "application/vnd.oci.image.layer.v1.tar"
+)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// dockerSaveManifest is an entry of manifest.json in `docker save` output.
+type dockerSaveManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// ImageTarToOCILayout converts `docker save` output into an OCI image layout directory
+// (oci-layout, index.json, blobs/sha256/...) usable by skopeo, containerd and other OCI
+// tools. A docker-compatible manifest.json pointing into blobs/ is kept, so the layout
+// packed as a tar can still be loaded with `docker load`. Archives that already are OCI
+// layouts (Docker 25+) are unpacked as they are.
+func ImageTarToOCILayout(ctx context.Context, imageTar, destDir string) error {
+	f, err := os.Open(imageTar)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	raw := destDir + ".raw"
+	if err := os.RemoveAll(raw); err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(raw) }()
+	if err := extractTar(ctx, f, raw); err != nil {
+		return fmt.Errorf("unpack %s: %w", imageTar, err)
+	}
+	if _, err := os.Stat(filepath.Join(raw, "oci-layout")); err == nil {
+		return os.Rename(raw, destDir)
+	}
+
+	b, err := os.ReadFile(filepath.Join(raw, "manifest.json"))
+	if err != nil {
+		return fmt.Errorf("%s is not a docker save archive: %w", imageTar, err)
+	}
+	var saved []dockerSaveManifest
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return fmt.Errorf("parse manifest.json: %w", err)
+	}
+	blobs := filepath.Join(destDir, "blobs", "sha256")
+	if err := os.MkdirAll(blobs, 0o755); err != nil {
+		return err
+	}
+	// layers shared between images appear once, other references are symlinks; resolve them
+	// all before blobs are moved out from under the links
+	rawReal, err := filepath.EvalSymlinks(raw)
+	if err != nil {
+		return err
+	}
+	resolved := map[string]string{}
+	for _, img := range saved {
+		for _, rel := range append([]string{img.Config}, img.Layers...) {
+			p, err := filepath.EvalSymlinks(filepath.Join(raw, filepath.FromSlash(rel)))
+			if err != nil {
+				return err
+			}
+			if r, err := filepath.Rel(rawReal, p); err != nil || strings.HasPrefix(r, "..") {
+				return fmt.Errorf("%s points outside the image archive", rel)
+			}
+			resolved[rel] = p
+		}
+	}
+	moved := map[string]ociDescriptor{}
+	toBlob := func(rel, mediaType string) (ociDescriptor, error) {
+		p := resolved[rel]
+		if d, ok := moved[p]; ok {
+			d.MediaType = mediaType
+			return d, nil
+		}
+		digest, size, err := fileSHA256(p)
+		if err != nil {
+			return ociDescriptor{}, err
+		}
+		if err := os.Rename(p, filepath.Join(blobs, digest)); err != nil {
+			return ociDescriptor{}, err
+		}
+		d := ociDescriptor{MediaType: mediaType, Digest: "sha256:" + digest, Size: size}
+		moved[p] = d
+		return d, nil
+	}
+
+	index := ociIndex{SchemaVersion: 2, MediaType: "application/vnd.oci.image.index.v1+json"}
+	compat := make([]dockerSaveManifest, 0, len(saved))
+	for _, img := range saved {
+		cfg, err := toBlob(img.Config, ociConfigMediaType)
+		if err != nil {
+			return fmt.Errorf("image config %s: %w", img.Config, err)
+		}
+		m := ociManifest{SchemaVersion: 2, MediaType: ociManifestMediaType, Config: cfg}
+		c := dockerSaveManifest{Config: blobPath(cfg), RepoTags: img.RepoTags}
+		for _, l := range img.Layers {
+			d, err := toBlob(l, ociLayerMediaType)
+			if err != nil {
+				return fmt.Errorf("layer %s: %w", l, err)
+			}
+			m.Layers = append(m.Layers, d)
+			c.Layers = append(c.Layers, blobPath(d))
+		}
+		mb, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(mb)
+		md := ociDescriptor{MediaType: ociManifestMediaType, Digest: "sha256:" + hex.EncodeToString(sum[:]), Size: int64(len(mb))}
+		if err := os.WriteFile(filepath.Join(blobs, hex.EncodeToString(sum[:])), mb, 0o644); err != nil {
+			return err
+		}
+		if len(img.RepoTags) == 0 {
+			index.Manifests = append(index.Manifests, md)
+		}
+		for _, ref := range img.RepoTags {
+			tagged := md
+			tagged.Annotations = map[string]string{
+				"io.containerd.image.name":          ref,
+				"org.opencontainers.image.ref.name": refName(ref),
+			}
+			index.Manifests = append(index.Manifests, tagged)
+		}
+		compat = append(compat, c)
+	}
+	for name, v := range map[string]any{"index.json": index, "manifest.json": compat, "oci-layout": map[string]string{"imageLayoutVersion": "1.0.0"}} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(destDir, name), b, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OCILayoutToImageTar packs an OCI layout directory written by ImageTarToOCILayout into a
+// tar that `docker load` accepts.
+func OCILayoutToImageTar(ctx context.Context, layoutDir, dest string) error {
+	entries, err := os.ReadDir(layoutDir)
+	if err != nil {
+		return err
+	}
+	sources := make([]ArchiveSource, 0, len(entries))
+	for _, en := range entries {
+		sources = append(sources, ArchiveSource{Path: filepath.Join(layoutDir, en.Name()), DestPath: en.Name()})
+	}
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	err = NewTarArchiveHandler().writeTar(ctx, out, sources)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func blobPath(d ociDescriptor) string {
+	return "blobs/sha256/" + strings.TrimPrefix(d.Digest, "sha256:")
+}
+
+// refName returns the tag of repo:tag, the part OCI tools use as org.opencontainers.image.ref.name.
+func refName(ref string) string {
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[i+1:]
+	}
+	return "latest"
+}
+
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
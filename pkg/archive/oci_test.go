@@ -0,0 +1,95 @@
+package archive
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestTar(t *testing.T, path string, files map[string]string, links map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, target := range links {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Linkname: target, Typeflag: tar.TypeSymlink, Mode: 0o777}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+}
+
+func TestImageTarToOCILayout_DockerSave(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	saved := filepath.Join(dir, "image.tar")
+	manifest := `[{"Config":"abc.json","RepoTags":["app:1.0"],"Layers":["l1/layer.tar","l2/layer.tar"]}]`
+	writeTestTar(t, saved, map[string]string{
+		"manifest.json": manifest,
+		"abc.json":      `{"architecture":"amd64"}`,
+		"l1/layer.tar":  "layer-one",
+	}, map[string]string{"l2/layer.tar": "../l1/layer.tar"})
+
+	layout := filepath.Join(dir, "oci")
+	if err := ImageTarToOCILayout(ctx, saved, layout); err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	var index ociIndex
+	b, err := os.ReadFile(filepath.Join(layout, "index.json"))
+	if err != nil || json.Unmarshal(b, &index) != nil || len(index.Manifests) != 1 {
+		t.Fatalf("unexpected index.json: %s (%v)", b, err)
+	}
+	if index.Manifests[0].Annotations["org.opencontainers.image.ref.name"] != "1.0" || index.Manifests[0].Annotations["io.containerd.image.name"] != "app:1.0" {
+		t.Fatalf("unexpected annotations: %v", index.Manifests[0].Annotations)
+	}
+	var m ociManifest
+	mb, err := os.ReadFile(filepath.Join(layout, blobPath(index.Manifests[0])))
+	if err != nil || json.Unmarshal(mb, &m) != nil {
+		t.Fatalf("read manifest blob: %v", err)
+	}
+	sum := sha256.Sum256([]byte("layer-one"))
+	want := "sha256:" + hex.EncodeToString(sum[:])
+	if len(m.Layers) != 2 || m.Layers[0].Digest != want || m.Layers[1].Digest != want || m.Layers[0].Size != 9 {
+		t.Fatalf("unexpected layers: %+v", m.Layers)
+	}
+	if _, err := os.Stat(filepath.Join(layout, blobPath(m.Config))); err != nil {
+		t.Fatalf("config blob missing: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(layout, "oci-layout")); err != nil {
+		t.Fatalf("oci-layout missing: %v", err)
+	}
+
+	packed := filepath.Join(dir, "load.tar")
+	if err := OCILayoutToImageTar(ctx, layout, packed); err != nil {
+		t.Fatalf("pack: %v", err)
+	}
+	unpacked := filepath.Join(dir, "unpacked")
+	f, _ := os.Open(packed)
+	defer func() { _ = f.Close() }()
+	if err := extractTar(ctx, f, unpacked); err != nil {
+		t.Fatalf("unpack: %v", err)
+	}
+	var compat []dockerSaveManifest
+	cb, _ := os.ReadFile(filepath.Join(unpacked, "manifest.json"))
+	if json.Unmarshal(cb, &compat) != nil || len(compat) != 1 || compat[0].Layers[0] != blobPath(m.Layers[0]) {
+		t.Fatalf("docker compatible manifest.json missing or wrong: %s", cb)
+	}
+}
@@ -0,0 +1,121 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// RebaseTarGz writes the entries under root/ of the tar.gz at tarGzPath to w as an uncompressed
+// tar with root replaced by newRoot, for extraction through the Engine API, which cannot pick a
+// directory out of an archive. The root entry itself becomes newRoot/, so the owner and mode of
+// the directory are kept. When no entry lies under root (volume archives that hold their data at
+// the top), every entry is moved below newRoot. The index and seek entries are left out.
+func RebaseTarGz(ctx context.Context, tarGzPath, root, newRoot string, w io.Writer) error {
+	root = strings.Trim(path.Clean("/"+root), "/")
+	newRoot = strings.Trim(path.Clean("/"+newRoot), "/")
+	hasRoot := false
+	if root != "" {
+		err := walkTarGz(ctx, tarGzPath, func(hdr *tar.Header, _ *tar.Reader) error {
+			if name := strings.Trim(path.Clean("/"+hdr.Name), "/"); name == root || strings.HasPrefix(name, root+"/") {
+				hasRoot = true
+				return ErrStopWalk
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	rebase := func(name string) (string, bool) {
+		name = strings.Trim(path.Clean("/"+name), "/")
+		if hasRoot {
+			if name == root {
+				return newRoot + "/", true
+			}
+			rel, ok := strings.CutPrefix(name, root+"/")
+			if !ok {
+				return "", false
+			}
+			name = rel
+		}
+		if name == "" {
+			return newRoot + "/", true
+		}
+		return newRoot + "/" + name, true
+	}
+
+	tw := tar.NewWriter(w)
+	err := walkTarGz(ctx, tarGzPath, func(hdr *tar.Header, tr *tar.Reader) error {
+		if name := strings.Trim(path.Clean("/"+hdr.Name), "/"); name == SeekEntryName || name == IndexEntryName {
+			return nil
+		}
+		name, ok := rebase(hdr.Name)
+		if !ok {
+			return nil
+		}
+		if hdr.Typeflag == tar.TypeDir && !strings.HasSuffix(name, "/") {
+			name += "/"
+		}
+		hdr.Name = name
+		if hdr.Typeflag == tar.TypeLink {
+			if hdr.Linkname, ok = rebase(hdr.Linkname); !ok {
+				return fmt.Errorf("hard link %s points outside %s", name, root)
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return fmt.Errorf("copy %s: %w", hdr.Name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// ErrStopWalk, returned by the callback of WalkArchive (or walkTarGz), ends the walk early
+// without an error.
+var ErrStopWalk = errors.New("stop walk")
+
+// walkTarGz calls fn for every entry of the tar.gz at tarGzPath.
+func walkTarGz(ctx context.Context, tarGzPath string, fn func(hdr *tar.Header, tr *tar.Reader) error) error {
+	f, err := os.Open(tarGzPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = gr.Close() }()
+	tr := tar.NewReader(gr)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(hdr, tr); err != nil {
+			if errors.Is(err, ErrStopWalk) {
+				return nil
+			}
+			return err
+		}
+	}
+}
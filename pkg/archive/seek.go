@@ -0,0 +1,355 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Archives larger than seekChunkSize are written as several concatenated gzip members, each
+// starting at a tar header (rsyncable archives also start members within entries). The last
+// member holds SeekEntryName, mapping every entry to the member it starts in, and is followed
+// by an empty gzip member whose extra field records where that member begins. gzip and tar
+// read such archives like any other .tar.gz; readers that know the layout jump straight to
+// the entries they need.
+const seekChunkSize = 4 << 20
+
+// SeekEntryName is the seek index entry, the last one of archives that have it.
+const SeekEntryName = ".seek.json"
+
+// seekMagic prefixes the offset in the footer's gzip extra field.
+const seekMagic = "DBSEEK"
+
+type seekEntry struct {
+	Path string `json:"p"`
+	// Offset is where the entry's gzip member starts in the file; Skip the uncompressed bytes
+	// of that member before the entry's header
+	Offset int64 `json:"o"`
+	Skip   int64 `json:"s,omitempty"`
+}
+
+type seekIndex struct {
+	Version int         `json:"version"`
+	Entries []seekEntry `json:"entries"`
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// memberWriter compresses into a gzip member that next() ends to start a new one.
+type memberWriter struct {
+	out     *countingWriter
+	newGzip func(w io.Writer, level int) (io.WriteCloser, error)
+	gz      io.WriteCloser
+	// level is the compression level of the current member and the ones next() starts
+	level int
+	// start is the file offset of the current member, written its uncompressed bytes so far
+	start   int64
+	written int64
+	// total counts the uncompressed bytes of all members
+	total int64
+	// rsyncable starts members at content-defined points (see rsyncMinMember), hash is the
+	// rolling hash of the last bytes written
+	rsyncable bool
+	hash      uint64
+}
+
+func (m *memberWriter) Write(p []byte) (int, error) {
+	if !m.rsyncable {
+		return m.write(p)
+	}
+	written := 0
+	for len(p) > 0 {
+		cut := len(p)
+		for i, b := range p {
+			m.hash = m.hash<<1 + gearTable[b]
+			if n := m.written + int64(i) + 1; n >= rsyncMaxMember || n >= rsyncMinMember && m.hash&rsyncMask == 0 {
+				cut = i + 1
+				break
+			}
+		}
+		n, err := m.write(p[:cut])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[cut:]
+		if m.written >= rsyncMaxMember || m.written >= rsyncMinMember && m.hash&rsyncMask == 0 {
+			if err := m.next(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (m *memberWriter) write(p []byte) (int, error) {
+	n, err := m.gz.Write(p)
+	m.written += int64(n)
+	m.total += int64(n)
+	return n, err
+}
+
+// flush writes out what the compressor buffered, so out.n covers everything written so far.
+func (m *memberWriter) flush() error {
+	if f, ok := m.gz.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (m *memberWriter) next() error {
+	if err := m.close(); err != nil {
+		return err
+	}
+	gz, err := m.newGzip(m.out, m.level)
+	if err != nil {
+		return err
+	}
+	m.gz, m.start, m.written = gz, m.out.n, 0
+	return nil
+}
+
+// setLevel starts a new member compressed at level, unless the current one already is.
+func (m *memberWriter) setLevel(level int) error {
+	if level == m.level {
+		return nil
+	}
+	m.level = level
+	return m.next()
+}
+
+func (m *memberWriter) close() error {
+	if m.gz == nil {
+		return nil
+	}
+	err := m.gz.Close()
+	m.gz = nil
+	return err
+}
+
+// writeSeekIndex ends the archive with the seek index in its own member and the footer
+// pointing at it.
+func writeSeekIndex(tw *tar.Writer, mw *memberWriter, entries []seekEntry) error {
+	if err := mw.next(); err != nil {
+		return err
+	}
+	offset := mw.start
+	b, err := json.Marshal(seekIndex{Version: indexVersion, Entries: entries})
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: SeekEntryName, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(b)), ModTime: time.Now().Truncate(time.Second)}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(b); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := mw.close(); err != nil {
+		return err
+	}
+	footer := gzip.NewWriter(mw.out)
+	// an RFC 1952 extra subfield ("DB") carrying the offset as 16 hex digits
+	data := fmt.Sprintf("%s%016x", seekMagic, offset)
+	footer.Extra = append([]byte{'D', 'B', byte(len(data)), 0}, data...)
+	return footer.Close()
+}
+
+// readSeekIndex returns the seek index of an archive written with one, or nil.
+func readSeekIndex(f *os.File) ([]seekEntry, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	tail := int64(128)
+	if fi.Size() < tail {
+		tail = fi.Size()
+	}
+	buf := make([]byte, tail)
+	if _, err := f.ReadAt(buf, fi.Size()-tail); err != nil {
+		return nil, err
+	}
+	i := bytes.LastIndex(buf, []byte(seekMagic))
+	if i < 0 || i+len(seekMagic)+16 > len(buf) {
+		return nil, nil
+	}
+	offset, err := strconv.ParseInt(string(buf[i+len(seekMagic):i+len(seekMagic)+16]), 16, 64)
+	if err != nil || offset <= 0 || offset >= fi.Size() {
+		return nil, nil
+	}
+	gz, err := gzip.NewReader(io.NewSectionReader(f, offset, fi.Size()-offset))
+	if err != nil {
+		return nil, nil
+	}
+	defer func() { _ = gz.Close() }()
+	hdr, err := tar.NewReader(gz).Next()
+	if err != nil || hdr.Name != SeekEntryName {
+		return nil, nil
+	}
+	var idx seekIndex
+	if err := json.NewDecoder(io.LimitReader(gz, hdr.Size)).Decode(&idx); err != nil || idx.Version > indexVersion {
+		return nil, nil
+	}
+	return idx.Entries, nil
+}
+
+// seekTo returns the decompressed stream of the archive positioned at en's tar header.
+func seekTo(f *os.File, en seekEntry) (*gzip.Reader, error) {
+	gz, err := gzip.NewReader(io.NewSectionReader(f, en.Offset, 1<<62))
+	if err != nil {
+		return nil, fmt.Errorf("read archive member at %d: %w", en.Offset, err)
+	}
+	if _, err := io.CopyN(io.Discard, gz, en.Skip); err != nil {
+		_ = gz.Close()
+		return nil, fmt.Errorf("read archive member at %d: %w", en.Offset, err)
+	}
+	return gz, nil
+}
+
+// copySeekEntry copies the regular file name to w using the seek index. ok is false when the
+// archive has no seek index, so the caller scans it instead.
+func copySeekEntry(archivePath, name string, w io.Writer) (bool, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return false, nil
+	}
+	defer func() { _ = f.Close() }()
+	index, _ := readSeekIndex(f)
+	if index == nil {
+		return false, nil
+	}
+	for _, en := range index {
+		if strings.TrimPrefix(en.Path, "./") != name {
+			continue
+		}
+		gz, err := seekTo(f, en)
+		if err != nil {
+			return true, err
+		}
+		defer func() { _ = gz.Close() }()
+		hdr, err := tar.NewReader(gz).Next()
+		if err != nil {
+			return true, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			break
+		}
+		_, err = io.Copy(w, io.LimitReader(gz, hdr.Size))
+		return true, err
+	}
+	return true, fmt.Errorf("%s not found in archive: %w", name, fs.ErrNotExist)
+}
+
+// ExtractEntries extracts the entries accepted by match into destDir. Archives written with a
+// seek index are read only from the gzip members holding those entries; others (and split
+// archives) are streamed from the start.
+func (h *TarArchiveHandler) ExtractEntries(ctx context.Context, archivePath, destDir string, match func(name string) bool) error {
+	matched := false
+	opts := ExtractOptions{Match: func(name string) bool {
+		if match(name) {
+			matched = true
+			return true
+		}
+		return false
+	}}
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	// split manifests and unreadable footers fall back to streaming
+	index, _ := readSeekIndex(f)
+	if index == nil {
+		rc, err := OpenArchive(archivePath)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = rc.Close() }()
+		gz, err := gzip.NewReader(rc)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = gz.Close() }()
+		if err := extractTarWith(ctx, gz, destDir, opts); err != nil {
+			return err
+		}
+		if !matched {
+			return fmt.Errorf("no entries match in %s: %w", archivePath, fs.ErrNotExist)
+		}
+		return nil
+	}
+
+	var wanted []seekEntry
+	for _, en := range index {
+		if match(en.Path) {
+			wanted = append(wanted, en)
+		}
+	}
+	if len(wanted) == 0 {
+		return fmt.Errorf("no entries match in %s: %w", archivePath, fs.ErrNotExist)
+	}
+	sort.SliceStable(wanted, func(i, j int) bool {
+		if wanted[i].Offset != wanted[j].Offset {
+			return wanted[i].Offset < wanted[j].Offset
+		}
+		return wanted[i].Skip < wanted[j].Skip
+	})
+	x, err := newExtractor(destDir, opts)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(wanted); {
+		member := wanted[i].Offset
+		gz, err := seekTo(f, wanted[i])
+		if err != nil {
+			return err
+		}
+		// entries of one member are read in order without seeking again
+		tr := tar.NewReader(gz)
+		for i < len(wanted) && wanted[i].Offset == member {
+			select {
+			case <-ctx.Done():
+				_ = gz.Close()
+				return ctx.Err()
+			default:
+			}
+			hdr, err := tr.Next()
+			if err != nil {
+				_ = gz.Close()
+				return fmt.Errorf("read %s: %w", wanted[i].Path, err)
+			}
+			if hdr.Name != wanted[i].Path {
+				continue
+			}
+			if err := x.entry(hdr, tr); err != nil {
+				_ = gz.Close()
+				return err
+			}
+			i++
+		}
+		_ = gz.Close()
+	}
+	return x.finish()
+}
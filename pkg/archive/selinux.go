@@ -0,0 +1,22 @@
+package archive
+
+import "archive/tar"
+
+const (
+	selinuxXattr = "security.selinux"
+	// paxSELinuxLabel is the PAX record GNU tar (--xattrs) and bsdtar use for the label
+	paxSELinuxLabel = "SCHILY.xattr." + selinuxXattr
+)
+
+// addSELinuxLabel records the SELinux label of path in hdr, if it has one, so restored
+// files can get it back instead of a label that containers may be denied.
+func addSELinuxLabel(hdr *tar.Header, path string) {
+	l := selinuxLabel(path)
+	if l == "" {
+		return
+	}
+	if hdr.PAXRecords == nil {
+		hdr.PAXRecords = map[string]string{}
+	}
+	hdr.PAXRecords[paxSELinuxLabel] = l
+}
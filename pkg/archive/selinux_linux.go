@@ -0,0 +1,29 @@
+//go:build linux
+
+package archive
+
+import (
+	"strings"
+	"syscall"
+)
+
+// selinuxLabel returns the SELinux label of path, or "" when it has none (or the filesystem
+// does not support labels). Symlinks are followed, so callers skip them.
+func selinuxLabel(path string) string {
+	buf := make([]byte, 256)
+	n, err := syscall.Getxattr(path, selinuxXattr, buf)
+	if err == syscall.ERANGE {
+		if n, err = syscall.Getxattr(path, selinuxXattr, nil); err == nil {
+			buf = make([]byte, n)
+			n, err = syscall.Getxattr(path, selinuxXattr, buf)
+		}
+	}
+	if err != nil || n <= 0 {
+		return ""
+	}
+	return strings.TrimRight(string(buf[:n]), "\x00")
+}
+
+func setSELinuxLabel(path, label string) error {
+	return syscall.Setxattr(path, selinuxXattr, []byte(label), 0)
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package archive
+
+func selinuxLabel(path string) string { return "" }
+
+func setSELinuxLabel(path, label string) error { return nil }
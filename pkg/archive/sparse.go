@@ -0,0 +1,246 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Sparse files (a database's preallocated data file, a VM disk image) are written as PAX
+// format 1.0 sparse entries, the layout GNU tar uses with --sparse: the entry holds a map of
+// the file's data regions followed by only those regions, and PAX records carry the real
+// name and size. archive/tar reads them (holes read as zeros) but cannot write them, so
+// writeSparseEntry encodes the headers itself.
+
+// sparseMinSize is the smallest file checked for holes.
+const sparseMinSize = 1 << 20
+
+// sparseBlock is the granularity at which extraction looks for runs of zeros to leave as
+// holes.
+const sparseBlock = 4096
+
+const (
+	paxGNUSparseMajor    = "GNU.sparse.major"
+	paxGNUSparseName     = "GNU.sparse.name"
+	paxGNUSparseRealSize = "GNU.sparse.realsize"
+	paxGNUSparseMap      = "GNU.sparse.map"
+	paxGNUSparseSize     = "GNU.sparse.size"
+)
+
+// sparseRegion is a span of a file that holds data; everything between regions is a hole.
+type sparseRegion struct {
+	Offset, Length int64
+}
+
+// isSparseHeader reports whether hdr, as read by archive/tar, was stored as a sparse entry.
+func isSparseHeader(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for _, k := range []string{paxGNUSparseMajor, paxGNUSparseMap, paxGNUSparseSize} {
+		if hdr.PAXRecords[k] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// dataRegions returns the data regions of the regular file f, or nil when it has no holes
+// worth recording (it is small, fully allocated, or holes cannot be detected here).
+func dataRegions(f *os.File, fi os.FileInfo) ([]sparseRegion, error) {
+	if fi.Size() < sparseMinSize || !mayBeSparse(fi) {
+		return nil, nil
+	}
+	regions, err := seekDataRegions(f, fi.Size())
+	if err != nil || len(regions) == 1 && regions[0] == (sparseRegion{0, fi.Size()}) {
+		return nil, err
+	}
+	return regions, nil
+}
+
+// writeSparseEntry writes the regular file f, described by hdr, with only its data regions.
+// w is the stream tw writes to: the headers are encoded here and written to it directly.
+func writeSparseEntry(tw *tar.Writer, w io.Writer, f *os.File, hdr *tar.Header, regions []sparseRegion) error {
+	realSize := hdr.Size
+	// the terminating entry GNU tar writes, so a trailing hole is part of the map
+	if last := regions[len(regions)-1]; last.Offset+last.Length < realSize {
+		regions = append(regions, sparseRegion{realSize, 0})
+	}
+	var sparseMap bytes.Buffer
+	fmt.Fprintf(&sparseMap, "%d\n", len(regions))
+	var dataSize int64
+	for _, r := range regions {
+		fmt.Fprintf(&sparseMap, "%d\n%d\n", r.Offset, r.Length)
+		dataSize += r.Length
+	}
+	sparseMap.Write(make([]byte, blockPadding(int64(sparseMap.Len()))))
+	size := int64(sparseMap.Len()) + dataSize
+
+	records := map[string]string{
+		paxGNUSparseMajor:    "1",
+		"GNU.sparse.minor":   "0",
+		paxGNUSparseName:     hdr.Name,
+		paxGNUSparseRealSize: strconv.FormatInt(realSize, 10),
+	}
+	for k, v := range hdr.PAXRecords {
+		records[k] = v
+	}
+	if size > maxOctal(12) {
+		records["size"] = strconv.FormatInt(size, 10)
+	}
+	for k, v := range map[string]int{"uid": hdr.Uid, "gid": hdr.Gid} {
+		if int64(v) > maxOctal(8) {
+			records[k] = strconv.Itoa(v)
+		}
+	}
+	if len(hdr.Uname) > 31 {
+		records["uname"] = hdr.Uname
+	}
+	if len(hdr.Gname) > 31 {
+		records["gname"] = hdr.Gname
+	}
+
+	// the placeholder name GNU tar uses; readers that do not know the format extract the
+	// entry under it instead of writing the map and data as the file itself
+	base := path.Base(hdr.Name)
+	if len(base) > 80 {
+		base = base[:80]
+	}
+	var head bytes.Buffer
+	pax := encodePAXRecords(records)
+	head.Write(ustarHeader("./PaxHeaders.0/"+base, 0o644, 0, 0, int64(len(pax)), hdr.ModTime.Unix(), tar.TypeXHeader, "", ""))
+	head.WriteString(pax)
+	head.Write(make([]byte, blockPadding(int64(len(pax)))))
+	head.Write(ustarHeader("./GNUSparseFile.0/"+base, hdr.Mode, hdr.Uid, hdr.Gid, size, hdr.ModTime.Unix(), tar.TypeReg, hdr.Uname, hdr.Gname))
+	head.Write(sparseMap.Bytes())
+
+	// archive/tar has padded the previous entry; its state stays valid as the raw entry
+	// below ends on a block boundary
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if _, err := w.Write(head.Bytes()); err != nil {
+		return err
+	}
+	for _, r := range regions {
+		if _, err := io.Copy(w, io.NewSectionReader(f, r.Offset, r.Length)); err != nil {
+			return err
+		}
+	}
+	// a file that shrank while it was read would leave the entry short
+	if fi, err := f.Stat(); err == nil && fi.Size() < realSize {
+		return fmt.Errorf("%s: file shrank while it was archived", hdr.Name)
+	}
+	_, err := w.Write(make([]byte, blockPadding(dataSize)))
+	return err
+}
+
+func blockPadding(n int64) int64 {
+	return -n & 511
+}
+
+func maxOctal(field int) int64 {
+	return 1<<(3*(field-1)) - 1
+}
+
+// encodePAXRecords encodes records sorted by key as "<length> <key>=<value>\n" lines, the
+// length counting the whole line including its own digits.
+func encodePAXRecords(records map[string]string) string {
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		line := " " + k + "=" + records[k] + "\n"
+		n := len(line) + len(strconv.Itoa(len(line)))
+		if len(strconv.Itoa(n)) > len(strconv.Itoa(len(line))) {
+			n++
+		}
+		b.WriteString(strconv.Itoa(n) + line)
+	}
+	return b.String()
+}
+
+// ustarHeader encodes a 512-byte USTAR header block. Values that do not fit are expected in
+// a preceding PAX header and are zeroed here.
+func ustarHeader(name string, mode int64, uid, gid int, size, mtime int64, typeflag byte, uname, gname string) []byte {
+	b := make([]byte, 512)
+	copy(b[0:100], name)
+	octal := func(field []byte, v int64) {
+		if v < 0 || v > maxOctal(len(field)) {
+			v = 0
+		}
+		copy(field, fmt.Sprintf("%0*o", len(field)-1, v))
+	}
+	octal(b[100:108], mode&0o7777)
+	octal(b[108:116], int64(uid))
+	octal(b[116:124], int64(gid))
+	octal(b[124:136], size)
+	octal(b[136:148], mtime)
+	b[156] = typeflag
+	copy(b[257:263], "ustar\x00")
+	copy(b[263:265], "00")
+	if len(uname) < 32 {
+		copy(b[265:297], uname)
+	}
+	if len(gname) < 32 {
+		copy(b[297:329], gname)
+	}
+	copy(b[148:156], "        ")
+	var sum int64
+	for _, c := range b {
+		sum += int64(c)
+	}
+	copy(b[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	return b
+}
+
+// copySparse copies r to out, seeking over blocks of zeros instead of writing them so they
+// stay holes, and returns the number of bytes copied. The caller truncates out to that size,
+// which also creates a trailing hole.
+func copySparse(out *os.File, r io.Reader) (int64, error) {
+	buf := make([]byte, 64*sparseBlock)
+	var total int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		chunk := buf[:n]
+		for len(chunk) > 0 {
+			// the data up to the next block of zeros, or the zeros up to the next data
+			i, zero := 0, isZero(chunk[:min(sparseBlock, len(chunk))])
+			for i < len(chunk) && isZero(chunk[i:min(i+sparseBlock, len(chunk))]) == zero {
+				i = min(i+sparseBlock, len(chunk))
+			}
+			if zero {
+				if _, err := out.Seek(int64(i), io.SeekCurrent); err != nil {
+					return total, err
+				}
+			} else if _, err := out.Write(chunk[:i]); err != nil {
+				return total, err
+			}
+			chunk, total = chunk[i:], total+int64(i)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
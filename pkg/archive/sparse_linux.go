@@ -0,0 +1,52 @@
+//go:build linux
+
+package archive
+
+import (
+	stdErrors "errors"
+	"os"
+	"syscall"
+)
+
+// lseek whence values that find the next data and the next hole
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// mayBeSparse reports whether fewer blocks are allocated to the file than its size needs.
+func mayBeSparse(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && st.Blocks*512 < fi.Size()
+}
+
+// seekDataRegions finds the data regions of f with SEEK_DATA/SEEK_HOLE. Filesystems without
+// support report the whole file as data.
+func seekDataRegions(f *os.File, size int64) ([]sparseRegion, error) {
+	var regions []sparseRegion
+	for off := int64(0); off < size; {
+		data, err := f.Seek(off, seekData)
+		if stdErrors.Is(err, syscall.ENXIO) {
+			// only a hole is left
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		hole, err := f.Seek(data, seekHole)
+		if err != nil {
+			return nil, err
+		}
+		hole = min(hole, size)
+		regions = append(regions, sparseRegion{data, hole - data})
+		off = hole
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	if len(regions) == 0 {
+		// all hole: one empty region keeps the map valid
+		regions = []sparseRegion{{size, 0}}
+	}
+	return regions, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package archive
+
+import "os"
+
+func mayBeSparse(fi os.FileInfo) bool { return false }
+
+func seekDataRegions(f *os.File, size int64) ([]sparseRegion, error) {
+	return []sparseRegion{{0, size}}, nil
+}
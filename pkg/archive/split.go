@@ -0,0 +1,194 @@
+package archive
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// SplitManifestSuffix is appended to an archive path for the manifest of a split archive.
+const SplitManifestSuffix = ".parts.json"
+
+// SplitManifest describes how to reassemble an archive split into fixed-size parts.
+type SplitManifest struct {
+	Archive  string      `json:"archive"`
+	Size     int64       `json:"size"`
+	SHA256   string      `json:"sha256"`
+	PartSize int64       `json:"partSize"`
+	Parts    []SplitPart `json:"parts"`
+}
+
+type SplitPart struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Split cuts the archive at path into <archive>.part001, .part002, ... of at most partSize
+// bytes next to it, writes <archive>.parts.json and removes the original archive. It returns
+// the manifest path and the part paths.
+func Split(path string, partSize int64) (string, []string, error) {
+	if partSize <= 0 {
+		return "", nil, fmt.Errorf("invalid part size %d", partSize)
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() { _ = in.Close() }()
+	fi, err := in.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+	m := SplitManifest{Archive: filepath.Base(path), Size: fi.Size(), PartSize: partSize}
+	var parts []string
+	cleanup := func() {
+		for _, p := range parts {
+			_ = os.Remove(p)
+		}
+	}
+	total := sha256.New()
+	for n := 1; ; n++ {
+		p := fmt.Sprintf("%s.part%03d", path, n)
+		written, sum, err := writePart(p, io.TeeReader(io.LimitReader(in, partSize), total))
+		if err != nil {
+			cleanup()
+			_ = os.Remove(p)
+			return "", nil, err
+		}
+		if written == 0 && n > 1 {
+			_ = os.Remove(p)
+			break
+		}
+		parts = append(parts, p)
+		m.Parts = append(m.Parts, SplitPart{Name: filepath.Base(p), Size: written, SHA256: sum})
+		if written < partSize {
+			break
+		}
+	}
+	m.SHA256 = hex.EncodeToString(total.Sum(nil))
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	manifest := path + SplitManifestSuffix
+	if err := os.WriteFile(manifest, b, 0o644); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if err := os.Remove(path); err != nil {
+		return "", nil, err
+	}
+	return manifest, parts, nil
+}
+
+func writePart(path string, r io.Reader) (int64, string, error) {
+	out, err := os.Create(path)
+	if err != nil {
+		return 0, "", err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, h), r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), err
+}
+
+// ReadSplitManifest parses a split archive manifest.
+func ReadSplitManifest(r io.Reader) (*SplitManifest, error) {
+	var m SplitManifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid split manifest: %w", err)
+	}
+	if len(m.Parts) == 0 {
+		return nil, fmt.Errorf("invalid split manifest: no parts")
+	}
+	return &m, nil
+}
+
+// OpenArchive opens an archive for reading. A split manifest (recognized by content, so a
+// _latest link pointing at one works too) yields the concatenated parts from its directory.
+func OpenArchive(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	if b, err := br.Peek(1); err != nil || b[0] != '{' {
+		return struct {
+			io.Reader
+			io.Closer
+		}{br, f}, nil
+	}
+	m, err := ReadSplitManifest(br)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+	return JoinParts(m, func(name string) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(dir, filepath.Base(name)))
+	}), nil
+}
+
+// JoinParts streams the parts of a split archive in order, verifying each part's size and
+// sha256 as it is read.
+func JoinParts(m *SplitManifest, open func(name string) (io.ReadCloser, error)) io.ReadCloser {
+	return &joinReader{m: m, open: open}
+}
+
+type joinReader struct {
+	m    *SplitManifest
+	open func(name string) (io.ReadCloser, error)
+	idx  int
+	cur  io.ReadCloser
+	n    int64
+	h    hash.Hash
+}
+
+func (j *joinReader) Read(p []byte) (int, error) {
+	for {
+		if j.cur == nil {
+			if j.idx >= len(j.m.Parts) {
+				return 0, io.EOF
+			}
+			rc, err := j.open(j.m.Parts[j.idx].Name)
+			if err != nil {
+				return 0, fmt.Errorf("open part %s: %w", j.m.Parts[j.idx].Name, err)
+			}
+			j.cur, j.n, j.h = rc, 0, sha256.New()
+		}
+		n, err := j.cur.Read(p)
+		j.n += int64(n)
+		j.h.Write(p[:n])
+		if err == io.EOF {
+			part := j.m.Parts[j.idx]
+			_ = j.cur.Close()
+			j.cur = nil
+			j.idx++
+			if j.n != part.Size || (part.SHA256 != "" && hex.EncodeToString(j.h.Sum(nil)) != part.SHA256) {
+				return n, fmt.Errorf("part %s is damaged (size or sha256 mismatch)", part.Name)
+			}
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (j *joinReader) Close() error {
+	if j.cur != nil {
+		return j.cur.Close()
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package archive
+
+import (
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplit_RoundTripThroughHandler(t *testing.T) {
+	ctx := context.Background()
+	h := NewTarArchiveHandler()
+
+	srcDir := t.TempDir()
+	payload := make([]byte, 200<<10)
+	_, _ = rand.Read(payload)
+	if err := os.WriteFile(filepath.Join(srcDir, "data.bin"), payload, 0o644); err != nil {
+		t.Fatalf("write payload: %v", err)
+	}
+	outDir := t.TempDir()
+	arch := filepath.Join(outDir, "backup.tar.gz")
+	if err := h.CreateArchive(ctx, []ArchiveSource{{Path: srcDir, DestPath: "data"}}, arch); err != nil {
+		t.Fatalf("CreateArchive: %v", err)
+	}
+
+	manifest, parts, err := Split(arch, 64<<10)
+	if err != nil {
+		t.Fatalf("Split: %v", err)
+	}
+	if len(parts) < 3 || filepath.Base(parts[0]) != "backup.tar.gz.part001" {
+		t.Fatalf("unexpected parts %v", parts)
+	}
+	if _, err := os.Stat(arch); !os.IsNotExist(err) {
+		t.Fatalf("original archive should be removed after split")
+	}
+
+	// a link pointing at the manifest must work like the manifest itself
+	link := filepath.Join(outDir, "backup_latest.tar.gz")
+	if err := os.Symlink(filepath.Base(manifest), link); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	for _, p := range []string{manifest, link} {
+		data, err := h.ReadEntry(ctx, p, "data/data.bin")
+		if err != nil || len(data) != len(payload) {
+			t.Fatalf("ReadEntry(%s) = %d bytes, %v", filepath.Base(p), len(data), err)
+		}
+	}
+	dest := t.TempDir()
+	if err := h.ExtractArchive(ctx, manifest, dest); err != nil {
+		t.Fatalf("ExtractArchive: %v", err)
+	}
+
+	// a damaged part is reported instead of producing a truncated archive
+	if err := os.WriteFile(parts[1], []byte("garbage"), 0o644); err != nil {
+		t.Fatalf("damage part: %v", err)
+	}
+	rc, err := OpenArchive(manifest)
+	if err != nil {
+		t.Fatalf("OpenArchive: %v", err)
+	}
+	defer rc.Close()
+	if _, err := Verify(ctx, rc); err == nil {
+		t.Fatalf("expected an error for a damaged part")
+	}
+}
@@ -2,6 +2,7 @@ package archive
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
 	"context"
 	"errors"
@@ -11,6 +12,10 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+	"github.com/klauspost/pgzip"
 )
 
 // ArchiveSource describes a source path to include in an archive.
@@ -21,24 +26,36 @@ import (
 type ArchiveSource struct {
 	Path     string
 	DestPath string
+	// Boundary keeps a directory walk out of nested mounts; the directories it stops at are
+	// archived empty
+	Boundary filesystem.Boundary
 }
 
 // ArchiveEntry is a lightweight description returned by ListArchive.
 type ArchiveEntry struct {
-	Path string
-	Size int64
-	Mode int64
-	Type string
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	Mode int64  `json:"mode"`
+	Type string `json:"type"`
+	// Sparse is set for files stored without their holes
+	Sparse bool `json:"sparse,omitempty"`
 }
 
 type ArchiveHandler interface {
 	CreateArchive(ctx context.Context, sources []ArchiveSource, dest string) error
 	ExtractArchive(ctx context.Context, archivePath, destDir string) error
 	ListArchive(ctx context.Context, archivePath string) ([]ArchiveEntry, error)
+	WalkArchive(ctx context.Context, archivePath string, fn func(ArchiveEntry) error) error
 }
 
 type TarArchiveHandler struct {
 	compressionLevel int
+	// compressThreads > 1 compresses blocks in parallel with pgzip
+	compressThreads int
+	// autoCompression stores already-compressed files without compressing them again
+	autoCompression bool
+	// rsyncable starts gzip members at content-defined points
+	rsyncable bool
 }
 
 func NewTarArchiveHandler() *TarArchiveHandler {
@@ -53,7 +70,52 @@ func (h *TarArchiveHandler) SetCompressionLevel(level int) {
 	}
 }
 
+// SetCompressionThreads sets how many goroutines compress the archive; 1 or less keeps the
+// single-threaded compress/gzip writer. Archives are always gzip, so there is no zstd
+// counterpart.
+func (h *TarArchiveHandler) SetCompressionThreads(n int) {
+	h.compressThreads = n
+}
+
+// SetAutoCompression makes the handler detect files whose content is compressed already,
+// such as media, archives and the layers of OCI images, and store them uncompressed in a
+// gzip member of their own instead of spending time compressing them again.
+func (h *TarArchiveHandler) SetAutoCompression(on bool) {
+	h.autoCompression = on
+}
+
+// SetRsyncable makes the handler start a new gzip member wherever the data matches a
+// content-defined pattern, about every MiB, so data that did not change between two backups
+// compresses to the same bytes even when data before it changed. Chunked uploads then only
+// send the chunks around the changes, at the cost of a slightly larger archive.
+func (h *TarArchiveHandler) SetRsyncable(on bool) {
+	h.rsyncable = on
+}
+
 func (h *TarArchiveHandler) CreateArchive(ctx context.Context, sources []ArchiveSource, dest string) error {
+	return h.createArchive(ctx, sources, dest, nil)
+}
+
+// SourceStats is how much of an archive one source takes: Size is its uncompressed tar
+// data, Compressed its share of the gzip stream.
+type SourceStats struct {
+	DestPath   string
+	Size       int64
+	Compressed int64
+}
+
+// CreateArchiveWithStats is CreateArchive that also reports the size of every source. The
+// compressor is flushed after each source so its bytes can be attributed exactly, which
+// costs a few bytes per source.
+func (h *TarArchiveHandler) CreateArchiveWithStats(ctx context.Context, sources []ArchiveSource, dest string) ([]SourceStats, error) {
+	stats := make([]SourceStats, 0, len(sources))
+	if err := h.createArchive(ctx, sources, dest, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (h *TarArchiveHandler) createArchive(ctx context.Context, sources []ArchiveSource, dest string, stats *[]SourceStats) error {
 	if len(sources) == 0 {
 		return fmt.Errorf("no sources provided for archive creation")
 	}
@@ -61,33 +123,184 @@ func (h *TarArchiveHandler) CreateArchive(ctx context.Context, sources []Archive
 		return err
 	}
 
-	outFile, err := os.Create(dest)
+	// Write next to dest and rename on success, so an interrupted run never leaves a truncated
+	// archive under the final name
+	tmp := dest + ".partial"
+	outFile, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = h.writeTarGz(ctx, outFile, sources, stats)
+	if cerr := outFile.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
+		_ = os.Remove(tmp)
 		return err
 	}
-	defer func() { _ = outFile.Close() }()
+	return os.Rename(tmp, dest)
+}
+
+func (h *TarArchiveHandler) writeTarGz(ctx context.Context, w io.Writer, sources []ArchiveSource, stats *[]SourceStats) error {
+	mw := &memberWriter{out: &countingWriter{w: w}, newGzip: h.newGzipWriter, level: h.compressionLevel, rsyncable: h.rsyncable}
+	if err := mw.next(); err != nil {
+		return err
+	}
+	err := h.writeIndexedTar(ctx, mw, sources, stats)
+	// also stops the pgzip workers after a failure
+	if cerr := mw.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (h *TarArchiveHandler) newGzipWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if h.compressThreads > 1 {
+		pw, err := pgzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		if err := pw.SetConcurrency(compressBlockSize, h.compressThreads); err != nil {
+			return nil, err
+		}
+		return pw, nil
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+// entryLevel is the compression level for an entry: the handler's, or with auto compression
+// NoCompression for a large file that is compressed already.
+func (h *TarArchiveHandler) entryLevel(path string, fi os.FileInfo) (int, error) {
+	if !h.autoCompression || !fi.Mode().IsRegular() || fi.Size() < autoMinSize || h.compressionLevel == gzip.NoCompression {
+		return h.compressionLevel, nil
+	}
+	compressed, err := isCompressed(path, fi.Size())
+	if err != nil || !compressed {
+		return h.compressionLevel, err
+	}
+	return gzip.NoCompression, nil
+}
 
-	gzWriter, err := gzip.NewWriterLevel(outFile, h.compressionLevel)
+// writeIndexedTar writes sources preceded by the table of contents and, when the archive is
+// large enough to start several gzip members, followed by the seek index and its footer.
+// A non-nil stats receives the size of every source.
+func (h *TarArchiveHandler) writeIndexedTar(ctx context.Context, mw *memberWriter, sources []ArchiveSource, stats *[]SourceStats) error {
+	tw := tar.NewWriter(mw)
+	index, err := indexSources(ctx, sources)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = gzWriter.Close() }()
+	if index != nil {
+		if err := writeIndex(tw, index); err != nil {
+			return err
+		}
+	}
+	var seek []seekEntry
+	seekable := true
+	for _, src := range sources {
+		size, compressed := mw.total, mw.out.n
+		err := walkSource(ctx, src, func(path string, fi os.FileInfo, nameInTar string) error {
+			// flush the previous entry's padding so the next header starts at a known offset
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+			level, err := h.entryLevel(path, fi)
+			if err != nil {
+				return err
+			}
+			switch {
+			case level != mw.level:
+				if err := mw.setLevel(level); err != nil {
+					return err
+				}
+			case mw.written >= seekChunkSize:
+				if err := mw.next(); err != nil {
+					return err
+				}
+			}
+			switch {
+			case len(seek) == maxIndexEntries:
+				seekable, seek = false, nil
+			case seekable:
+				seek = append(seek, seekEntry{Path: tarName(nameInTar, fi), Offset: mw.start, Skip: mw.written})
+			}
+			return writeSourceEntry(tw, mw, path, fi, nameInTar)
+		})
+		if err != nil {
+			return err
+		}
+		if stats != nil {
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+			if err := mw.flush(); err != nil {
+				return err
+			}
+			dest := src.DestPath
+			if dest == "" {
+				dest = filepath.Base(src.Path)
+			}
+			*stats = append(*stats, SourceStats{DestPath: dest, Size: mw.total - size, Compressed: mw.out.n - compressed})
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if !seekable || mw.start == 0 {
+		// a single member: seeking would not skip anything
+		return tw.Close()
+	}
+	return writeSeekIndex(tw, mw, seek)
+}
 
-	tarWriter := tar.NewWriter(gzWriter)
-	defer func() { _ = tarWriter.Close() }()
+// writeTar writes sources as an uncompressed tar stream.
+func (h *TarArchiveHandler) writeTar(ctx context.Context, w io.Writer, sources []ArchiveSource) error {
+	tarWriter := tar.NewWriter(w)
 
 	// For future: parallelize per-source walking with a file queue feeding a single tar writer.
 	for _, src := range sources {
-		if err := h.addSourceToTar(ctx, tarWriter, src); err != nil {
+		if err := h.addSourceToTar(ctx, tarWriter, w, src); err != nil {
 			return err
 		}
 	}
-	return nil
+	return tarWriter.Close()
 }
 
 // NOTE: Potential improvements for xattrs/ACL/hardlinks can be added here by reading and adding pax headers.
 
-func (h *TarArchiveHandler) addSourceToTar(ctx context.Context, tw *tar.Writer, src ArchiveSource) error {
+func (h *TarArchiveHandler) addSourceToTar(ctx context.Context, tw *tar.Writer, w io.Writer, src ArchiveSource) error {
+	return walkSource(ctx, src, func(path string, fi os.FileInfo, nameInTar string) error {
+		return writeSourceEntry(tw, w, path, fi, nameInTar)
+	})
+}
+
+// writeSourceEntry writes one walked path to tw; w is the stream tw writes to, for entries
+// archive/tar cannot encode itself.
+func writeSourceEntry(tw *tar.Writer, w io.Writer, path string, fi os.FileInfo, nameInTar string) error {
+	if fi.IsDir() {
+		// Write a directory header to ensure empty dirs are preserved
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = tarName(nameInTar, fi)
+		addSELinuxLabel(hdr, path)
+		return tw.WriteHeader(hdr)
+	}
+	return writeFileOrSymlinkToTar(tw, w, path, fi, nameInTar)
+}
+
+// tarName is the header name of a walked path: directories end in a slash.
+func tarName(nameInTar string, fi os.FileInfo) string {
+	if fi.IsDir() {
+		return nameInTar + "/"
+	}
+	return nameInTar
+}
+
+// walkSource calls fn for src and, for a directory, everything below it, with the name each
+// path gets inside the archive.
+func walkSource(ctx context.Context, src ArchiveSource, fn func(path string, fi os.FileInfo, nameInTar string) error) error {
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
@@ -116,21 +329,17 @@ func (h *TarArchiveHandler) addSourceToTar(ctx context.Context, tw *tar.Writer,
 			if err != nil {
 				return err
 			}
-			nameInTar := filepath.ToSlash(filepath.Join(rootName, rel))
 			fi, err := os.Lstat(curr)
 			if err != nil {
 				return err
 			}
-			if fi.IsDir() {
-				// Write a directory header to ensure empty dirs are preserved
-				hdr, err := tar.FileInfoHeader(fi, "")
-				if err != nil {
-					return err
-				}
-				hdr.Name = nameInTar + "/"
-				return tw.WriteHeader(hdr)
+			if err := fn(curr, fi, filepath.ToSlash(filepath.Join(rootName, rel))); err != nil {
+				return err
 			}
-			return writeFileOrSymlinkToTar(tw, curr, fi, nameInTar)
+			if curr != src.Path && src.Boundary.Stops(info, curr, fi) {
+				return filepath.SkipDir
+			}
+			return nil
 		})
 	}
 	// Single file
@@ -138,10 +347,10 @@ func (h *TarArchiveHandler) addSourceToTar(ctx context.Context, tw *tar.Writer,
 	if nameInTar == "" {
 		nameInTar = filepath.Base(src.Path)
 	}
-	return writeFileOrSymlinkToTar(tw, src.Path, info, filepath.ToSlash(nameInTar))
+	return fn(src.Path, info, filepath.ToSlash(nameInTar))
 }
 
-func writeFileOrSymlinkToTar(tw *tar.Writer, srcPath string, fi os.FileInfo, nameInTar string) error {
+func writeFileOrSymlinkToTar(tw *tar.Writer, w io.Writer, srcPath string, fi os.FileInfo, nameInTar string) error {
 	if fi.Mode()&os.ModeSymlink != 0 {
 		// Symlink: store as a symlink entry
 		target, err := os.Readlink(srcPath)
@@ -161,14 +370,22 @@ func writeFileOrSymlinkToTar(tw *tar.Writer, srcPath string, fi os.FileInfo, nam
 		return err
 	}
 	hdr.Name = nameInTar
-	if err := tw.WriteHeader(hdr); err != nil {
-		return err
-	}
+	addSELinuxLabel(hdr, srcPath)
 	f, err := os.Open(srcPath)
 	if err != nil {
 		return err
 	}
 	defer func() { _ = f.Close() }()
+	regions, err := dataRegions(f, fi)
+	if err != nil {
+		return err
+	}
+	if regions != nil {
+		return writeSparseEntry(tw, w, f, hdr, regions)
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
 	_, err = io.Copy(tw, f)
 	return err
 }
@@ -177,7 +394,7 @@ func (h *TarArchiveHandler) ExtractArchive(ctx context.Context, archivePath, des
 	if err := os.MkdirAll(destDir, 0o755); err != nil {
 		return err
 	}
-	file, err := os.Open(archivePath)
+	file, err := OpenArchive(archivePath)
 	if err != nil {
 		return err
 	}
@@ -189,8 +406,54 @@ func (h *TarArchiveHandler) ExtractArchive(ctx context.Context, archivePath, des
 	}
 	defer func() { _ = gzReader.Close() }()
 
-	tr := tar.NewReader(gzReader)
-	for {
+	return extractTar(ctx, gzReader, destDir)
+}
+
+// ExtractOptions tunes ExtractTarGz.
+type ExtractOptions struct {
+	// StripRoot extracts the entries under this top-level directory relative to it, so the
+	// directory's own mode, owner and times apply to destDir
+	StripRoot string
+	// Match limits extraction to the entries (tar names) it accepts
+	Match func(name string) bool
+	// IDs maps the owners of the entries; it applies when running as root, like ownership
+	IDs IDMap
+	// SELinuxLabels restores the recorded SELinux labels of directories and regular files,
+	// when running as root. Labels the host rejects (no SELinux, another policy) are skipped
+	SELinuxLabels bool
+}
+
+// ExtractTarGz unpacks a tar.gz into destDir with the same handling as ExtractArchive.
+func ExtractTarGz(ctx context.Context, archivePath, destDir string, opts ExtractOptions) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = gz.Close() }()
+	return extractTarWith(ctx, gz, destDir, opts)
+}
+
+// extractTar unpacks an uncompressed tar stream into destDir, rejecting paths outside it.
+func extractTar(ctx context.Context, r io.Reader, destDir string) error {
+	return extractTarWith(ctx, r, destDir, ExtractOptions{})
+}
+
+// extractTarWith restores directories (including empty ones), regular files, symlinks and
+// hard links with their modes and modification times, and their uid/gid when running as
+// root. Entries are rejected if their path, or a symlink already extracted on the way to
+// it, leads outside destDir.
+func extractTarWith(ctx context.Context, r io.Reader, destDir string, opts ExtractOptions) error {
+	x, err := newExtractor(destDir, opts)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for first := true; ; first = false {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
@@ -203,81 +466,351 @@ func (h *TarArchiveHandler) ExtractArchive(ctx context.Context, archivePath, des
 		if err != nil {
 			return err
 		}
-		destPath, err := secureJoin(destDir, hdr.Name)
-		if err != nil {
-			return fmt.Errorf("unsafe path %q in archive: %w", hdr.Name, err)
+		if (first && hdr.Name == IndexEntryName) || hdr.Name == SeekEntryName {
+			continue
+		}
+		if opts.Match != nil && !opts.Match(hdr.Name) {
+			continue
+		}
+		if err := x.entry(hdr, tr); err != nil {
+			return err
+		}
+	}
+	return x.finish()
+}
+
+// extractor writes tar entries below root (the resolved destination directory).
+type extractor struct {
+	root  string
+	opts  ExtractOptions
+	chown bool
+	// directory modes and times are set last: a read-only directory could not be filled, and
+	// creating entries inside a directory updates its mtime
+	dirs []dirAttrs
+}
+
+type dirAttrs struct {
+	path  string
+	mode  os.FileMode
+	mtime time.Time
+}
+
+func newExtractor(destDir string, opts ExtractOptions) (*extractor, error) {
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return nil, err
+	}
+	root, err := filepath.EvalSymlinks(destDir)
+	if err != nil {
+		return nil, err
+	}
+	return &extractor{root: root, opts: opts, chown: os.Geteuid() == 0}, nil
+}
+
+// entry extracts hdr, reading a regular file's content from r.
+func (x *extractor) entry(hdr *tar.Header, r io.Reader) error {
+	name := hdr.Name
+	if x.opts.StripRoot != "" {
+		if strings.TrimSuffix(name, "/") == x.opts.StripRoot {
+			name = "."
+		} else {
+			name = strings.TrimPrefix(name, x.opts.StripRoot+"/")
 		}
-		switch hdr.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(destPath, os.FileMode(hdr.Mode)); err != nil {
+	}
+	destPath, err := secureJoin(x.root, name)
+	if err != nil {
+		return fmt.Errorf("unsafe path %q in archive: %w", hdr.Name, err)
+	}
+	if err := checkParentWithin(x.root, destPath); err != nil {
+		return fmt.Errorf("unsafe path %q in archive: %w", hdr.Name, err)
+	}
+	mode := os.FileMode(hdr.Mode).Perm()
+	if hdr.Mode&0o4000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if hdr.Mode&0o2000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if hdr.Mode&0o1000 != 0 {
+		mode |= os.ModeSticky
+	}
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if fi, err := os.Lstat(destPath); err == nil && !fi.IsDir() {
+			if err := os.Remove(destPath); err != nil {
 				return err
 			}
-		case tar.TypeSymlink:
-			// Create parent directory
-			if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
+		}
+		if err := os.MkdirAll(destPath, 0o755); err != nil {
+			return err
+		}
+		x.dirs = append(x.dirs, dirAttrs{destPath, mode, hdr.ModTime})
+	case tar.TypeSymlink:
+		if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
+			return err
+		}
+		if err := removeNonDir(destPath); err != nil {
+			return err
+		}
+		if err := os.Symlink(hdr.Linkname, destPath); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		target, err := secureJoin(x.root, strings.TrimPrefix(hdr.Linkname, x.opts.StripRoot+"/"))
+		if err != nil {
+			return fmt.Errorf("unsafe link %q in archive: %w", hdr.Linkname, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
+			return err
+		}
+		if err := removeNonDir(destPath); err != nil {
+			return err
+		}
+		if err := os.Link(target, destPath); err != nil {
+			return err
+		}
+		return nil
+	case tar.TypeReg, tar.TypeRegA:
+		if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
+			return err
+		}
+		// never write through a symlink left by an earlier entry
+		if err := removeNonDir(destPath); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(destPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm())
+		if err != nil {
+			return err
+		}
+		if err := copyEntryData(out, hdr, r); err != nil {
+			_ = out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+		if err := os.Chmod(destPath, mode); err != nil {
+			return err
+		}
+		if err := os.Chtimes(destPath, hdr.ModTime, hdr.ModTime); err != nil {
+			return err
+		}
+	default:
+		// devices, fifos and other special files are not restored
+		return nil
+	}
+	if x.chown {
+		uid, gid := x.opts.IDs.Map(hdr.Uid, hdr.Gid)
+		if err := os.Lchown(destPath, uid, gid); err != nil {
+			return err
+		}
+		if (hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA) && mode&(os.ModeSetuid|os.ModeSetgid) != 0 {
+			// chown clears setuid/setgid
+			if err := os.Chmod(destPath, mode); err != nil {
 				return err
 			}
-			if err := os.Symlink(hdr.Linkname, destPath); err != nil {
-				return err
+		}
+		if l := hdr.PAXRecords[paxSELinuxLabel]; x.opts.SELinuxLabels && l != "" && hdr.Typeflag != tar.TypeSymlink {
+			_ = setSELinuxLabel(destPath, l)
+		}
+	}
+	return nil
+}
+
+// copyEntryData writes a regular file's content to out; the holes of a sparse entry are left
+// as holes rather than written as zeros.
+func copyEntryData(out *os.File, hdr *tar.Header, r io.Reader) error {
+	if !isSparseHeader(hdr) {
+		_, err := io.Copy(out, r)
+		return err
+	}
+	n, err := copySparse(out, r)
+	if err != nil {
+		return err
+	}
+	return out.Truncate(n)
+}
+
+func (x *extractor) finish() error {
+	for i := len(x.dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(x.dirs[i].path, x.dirs[i].mode); err != nil {
+			return err
+		}
+		_ = os.Chtimes(x.dirs[i].path, x.dirs[i].mtime, x.dirs[i].mtime)
+	}
+	return nil
+}
+
+// checkParentWithin rejects paths whose parent directory resolves (through symlinks created
+// by earlier entries) outside root.
+func checkParentWithin(root, path string) error {
+	if path == root {
+		return nil
+	}
+	dir := filepath.Dir(path)
+	for {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			rel, err := filepath.Rel(root, resolved)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return fmt.Errorf("path escapes the destination through a symlink")
 			}
-		case tar.TypeReg, tar.TypeRegA:
-			if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
-				return err
+			return nil
+		}
+		if !os.IsNotExist(err) || dir == root {
+			return err
+		}
+		// not created yet: check the closest existing ancestor
+		dir = filepath.Dir(dir)
+	}
+}
+
+// removeNonDir removes an existing file or symlink at path so it can be replaced.
+func removeNonDir(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.IsDir() {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// ListArchive returns every entry of the archive. Archives with many entries are better
+// walked with WalkArchive, which does not hold them all in memory.
+func (h *TarArchiveHandler) ListArchive(ctx context.Context, archivePath string) ([]ArchiveEntry, error) {
+	entries := []ArchiveEntry{}
+	err := h.WalkArchive(ctx, archivePath, func(en ArchiveEntry) error {
+		entries = append(entries, en)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// WalkArchive calls fn for every entry of the archive in order, answering from the index when
+// the archive has one and otherwise reading the headers one at a time, so memory stays flat
+// however many entries there are. fn returns ErrStopWalk to end the walk early.
+func (h *TarArchiveHandler) WalkArchive(ctx context.Context, archivePath string, fn func(ArchiveEntry) error) error {
+	file, err := OpenArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	gzReader, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = gzReader.Close() }()
+
+	err = func() error {
+		tr := tar.NewReader(gzReader)
+		for first := true; ; first = false {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				return nil
 			}
-			out, err := os.OpenFile(destPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(hdr.Mode))
 			if err != nil {
 				return err
 			}
-			if _, err := io.Copy(out, tr); err != nil {
-				_ = out.Close()
-				return err
+			if hdr.Name == SeekEntryName {
+				continue
 			}
-			if err := out.Close(); err != nil {
+			if first && hdr.Name == IndexEntryName {
+				// answer from the index instead of decompressing the rest of the stream
+				index, ok, err := readIndex(tr, hdr)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					continue
+				}
+				for _, en := range index {
+					if err := fn(en); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			en := ArchiveEntry{
+				Path:   hdr.Name,
+				Size:   hdr.Size,
+				Mode:   hdr.Mode,
+				Type:   tarTypeToString(hdr.Typeflag),
+				Sparse: isSparseHeader(hdr),
+			}
+			if err := fn(en); err != nil {
 				return err
 			}
-		default:
-			// Skip other types for v0
 		}
+	}()
+	if errors.Is(err, ErrStopWalk) {
+		return nil
 	}
-	return nil
+	return err
 }
 
-func (h *TarArchiveHandler) ListArchive(ctx context.Context, archivePath string) ([]ArchiveEntry, error) {
-	file, err := os.Open(archivePath)
-	if err != nil {
+// ReadEntry returns the content of a single regular file from the archive without extracting
+// the rest. It stops reading as soon as the entry is found.
+func (h *TarArchiveHandler) ReadEntry(ctx context.Context, archivePath, name string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := h.CopyEntry(ctx, archivePath, name, &buf); err != nil {
 		return nil, err
 	}
+	return buf.Bytes(), nil
+}
+
+// CopyEntry streams a single regular file from the archive to w, for entries too large to
+// hold in memory.
+func (h *TarArchiveHandler) CopyEntry(ctx context.Context, archivePath, name string, w io.Writer) error {
+	want := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(name)), "./")
+	if ok, err := copySeekEntry(archivePath, want, w); ok {
+		return err
+	}
+	file, err := OpenArchive(archivePath)
+	if err != nil {
+		return err
+	}
 	defer func() { _ = file.Close() }()
 
 	gzReader, err := gzip.NewReader(file)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer func() { _ = gzReader.Close() }()
 
 	tr := tar.NewReader(gzReader)
-	var entries []ArchiveEntry
-	for {
+	for first := true; ; first = false {
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return ctx.Err()
 		default:
 		}
 		hdr, err := tr.Next()
 		if errors.Is(err, io.EOF) {
-			break
+			return fmt.Errorf("%s not found in archive: %w", name, fs.ErrNotExist)
 		}
 		if err != nil {
-			return nil, err
+			return err
 		}
-		entries = append(entries, ArchiveEntry{
-			Path: hdr.Name,
-			Size: hdr.Size,
-			Mode: hdr.Mode,
-			Type: tarTypeToString(hdr.Typeflag),
-		})
+		if first && hdr.Name == IndexEntryName && want != IndexEntryName {
+			// a missing entry is reported without scanning the whole archive
+			if index, ok, err := readIndex(tr, hdr); err == nil && ok && !indexHasFile(index, want) {
+				return fmt.Errorf("%s not found in archive: %w", name, fs.ErrNotExist)
+			}
+			continue
+		}
+		if strings.TrimPrefix(hdr.Name, "./") != want || (hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA) {
+			continue
+		}
+		_, err = io.Copy(w, tr)
+		return err
 	}
-	return entries, nil
 }
 
 func ensureParentDir(path string) error {
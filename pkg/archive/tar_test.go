@@ -1,10 +1,23 @@
 package archive
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/brian033/dockerbackup/pkg/filesystem"
 )
 
 func TestTarArchive_RoundTrip(t *testing.T) {
@@ -55,3 +68,748 @@ func TestTarArchive_RoundTrip(t *testing.T) {
 		t.Fatalf("unexpected nested file content: %q", string(b))
 	}
 }
+
+func writeTestTarGz(t *testing.T, entries []tar.Header, contents map[string]string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "test.tar.gz")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, hdr := range entries {
+		hdr := hdr
+		body := contents[hdr.Name]
+		hdr.Size = int64(len(body))
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatalf("write header: %v", err)
+		}
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatalf("write body: %v", err)
+		}
+	}
+	_ = tw.Close()
+	_ = gz.Close()
+	_ = f.Close()
+	return p
+}
+
+func TestExtractTarGz_PreservesDirsModesAndTimes(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	p := writeTestTarGz(t, []tar.Header{
+		{Name: "data/", Typeflag: tar.TypeDir, Mode: 0o750, ModTime: mtime},
+		{Name: "data/empty/", Typeflag: tar.TypeDir, Mode: 0o700, ModTime: mtime},
+		{Name: "data/secret.txt", Typeflag: tar.TypeReg, Mode: 0o600, ModTime: mtime},
+		{Name: "data/link", Typeflag: tar.TypeSymlink, Linkname: "secret.txt", ModTime: mtime},
+	}, map[string]string{"data/secret.txt": "s3cret"})
+
+	dest := filepath.Join(t.TempDir(), "restored")
+	if err := ExtractTarGz(context.Background(), p, dest, ExtractOptions{StripRoot: "data"}); err != nil {
+		t.Fatalf("ExtractTarGz: %v", err)
+	}
+	fi, err := os.Stat(filepath.Join(dest, "empty"))
+	if err != nil || !fi.IsDir() {
+		t.Fatalf("empty dir not restored: %v", err)
+	}
+	if fi.Mode().Perm() != 0o700 || !fi.ModTime().Equal(mtime) {
+		t.Fatalf("empty dir mode/mtime = %v %v", fi.Mode().Perm(), fi.ModTime())
+	}
+	if fi, _ := os.Stat(dest); fi.Mode().Perm() != 0o750 {
+		t.Fatalf("root dir mode = %v, want 0750", fi.Mode().Perm())
+	}
+	fi, err = os.Stat(filepath.Join(dest, "secret.txt"))
+	if err != nil || fi.Mode().Perm() != 0o600 || !fi.ModTime().Equal(mtime) {
+		t.Fatalf("file mode/mtime = %v %v (%v)", fi.Mode().Perm(), fi.ModTime(), err)
+	}
+	if target, err := os.Readlink(filepath.Join(dest, "link")); err != nil || target != "secret.txt" {
+		t.Fatalf("symlink = %q (%v)", target, err)
+	}
+}
+
+func TestExtractTarGz_RejectsSymlinkEscape(t *testing.T) {
+	outside := t.TempDir()
+	p := writeTestTarGz(t, []tar.Header{
+		{Name: "a", Typeflag: tar.TypeSymlink, Linkname: outside},
+		{Name: "a/x", Typeflag: tar.TypeReg, Mode: 0o644},
+	}, map[string]string{"a/x": "pwned"})
+
+	if err := ExtractTarGz(context.Background(), p, t.TempDir(), ExtractOptions{}); err == nil {
+		t.Fatalf("expected an error for a path through a symlink")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "x")); err == nil {
+		t.Fatalf("file written outside the destination")
+	}
+}
+
+func TestTarArchive_ParallelCompression(t *testing.T) {
+	ctx := context.Background()
+	h := NewTarArchiveHandler()
+	h.SetCompressionThreads(4)
+
+	srcDir := t.TempDir()
+	// larger than one compression block so several goroutines take part
+	data := bytes.Repeat([]byte("dockerbackup parallel gzip "), 200000)
+	if err := os.WriteFile(filepath.Join(srcDir, "big.bin"), data, 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	archivePath := filepath.Join(t.TempDir(), "parallel.tar.gz")
+	if err := h.CreateArchive(ctx, []ArchiveSource{{Path: srcDir, DestPath: "data"}}, archivePath); err != nil {
+		t.Fatalf("CreateArchive failed: %v", err)
+	}
+
+	destDir := t.TempDir()
+	if err := NewTarArchiveHandler().ExtractArchive(ctx, archivePath, destDir); err != nil {
+		t.Fatalf("ExtractArchive failed: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(destDir, "data", "big.bin"))
+	if err != nil || !bytes.Equal(b, data) {
+		t.Fatalf("round trip mismatch (%d bytes, err %v)", len(b), err)
+	}
+}
+
+func TestTarArchive_RsyncableChunksSurviveEdits(t *testing.T) {
+	ctx := context.Background()
+	h := NewTarArchiveHandler()
+	h.SetRsyncable(true)
+	data := make([]byte, 32<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	// some compressible runs, as in real files
+	for i := 0; i < len(data); i += 64 << 10 {
+		copy(data[i:], bytes.Repeat([]byte("dockerbackup "), 1000))
+	}
+	mtime := time.Unix(1700000000, 0)
+	build := func(content []byte) (string, []byte) {
+		src := t.TempDir()
+		p := filepath.Join(src, "fs.bin")
+		if err := os.WriteFile(p, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Chtimes(p, mtime, mtime)
+		out := filepath.Join(t.TempDir(), "b.tar.gz")
+		if err := h.CreateArchive(ctx, []ArchiveSource{{Path: src, DestPath: "data"}}, out); err != nil {
+			t.Fatalf("CreateArchive: %v", err)
+		}
+		b, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out, b
+	}
+	chunks := func(b []byte) map[string]int {
+		out := map[string]int{}
+		c := NewChunker(bytes.NewReader(b))
+		for {
+			chunk, err := c.Next()
+			if err == io.EOF {
+				return out
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			out[string(chunk)] = len(chunk)
+		}
+	}
+	_, before := build(data)
+	edited := append(append(append([]byte{}, data[:12<<20]...), []byte("an edit in the middle")...), data[12<<20:]...)
+	path, after := build(edited)
+
+	old := chunks(before)
+	shared, total := 0, 0
+	for chunk, n := range chunks(after) {
+		total += n
+		if _, ok := old[chunk]; ok {
+			shared += n
+		}
+	}
+	if shared*10 < total*6 {
+		t.Fatalf("only %d of %d bytes of the edited archive are in chunks of the original", shared, total)
+	}
+
+	dest := t.TempDir()
+	if err := NewTarArchiveHandler().ExtractArchive(ctx, path, dest); err != nil {
+		t.Fatalf("ExtractArchive: %v", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dest, "data", "fs.bin")); err != nil || !bytes.Equal(b, edited) {
+		t.Fatalf("round trip mismatch (%d bytes, err %v)", len(b), err)
+	}
+	if err := NewTarArchiveHandler().ExtractEntries(ctx, path, t.TempDir(), func(name string) bool { return name == "data/fs.bin" }); err != nil {
+		t.Fatalf("ExtractEntries through the seek index: %v", err)
+	}
+}
+
+func TestCreateArchiveWithStats(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	text := bytes.Repeat([]byte("compressible "), 100000)
+	if err := os.WriteFile(filepath.Join(dir, "text.txt"), text, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "small.json"), []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	archivePath := filepath.Join(t.TempDir(), "stats.tar.gz")
+	stats, err := NewTarArchiveHandler().CreateArchiveWithStats(ctx, []ArchiveSource{
+		{Path: filepath.Join(dir, "small.json"), DestPath: "small.json"},
+		{Path: filepath.Join(dir, "text.txt")},
+	}, archivePath)
+	if err != nil {
+		t.Fatalf("CreateArchiveWithStats failed: %v", err)
+	}
+	if len(stats) != 2 || stats[0].DestPath != "small.json" || stats[1].DestPath != "text.txt" {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if stats[1].Size < int64(len(text)) || stats[1].Compressed <= 0 || stats[1].Compressed > stats[1].Size/10 {
+		t.Fatalf("text.txt stats = %+v, want its full size and a much smaller compressed share", stats[1])
+	}
+	fi, err := os.Stat(archivePath)
+	if err != nil || stats[0].Compressed+stats[1].Compressed > fi.Size() {
+		t.Fatalf("compressed shares %+v exceed the archive size", stats)
+	}
+	if err := NewTarArchiveHandler().ExtractArchive(ctx, archivePath, t.TempDir()); err != nil {
+		t.Fatalf("archive with flushed sources does not extract: %v", err)
+	}
+}
+
+func TestTarArchive_Index(t *testing.T) {
+	ctx := context.Background()
+	h := NewTarArchiveHandler()
+	srcDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(srcDir, "empty"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("abc"), 0o640); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	archivePath := filepath.Join(t.TempDir(), "indexed.tar.gz")
+	if err := h.CreateArchive(ctx, []ArchiveSource{{Path: srcDir, DestPath: "data"}}, archivePath); err != nil {
+		t.Fatalf("CreateArchive failed: %v", err)
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	var scanned []ArchiveEntry
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		scanned = append(scanned, ArchiveEntry{Path: hdr.Name, Size: hdr.Size, Mode: hdr.Mode, Type: tarTypeToString(hdr.Typeflag)})
+	}
+	if len(scanned) == 0 || scanned[0].Path != IndexEntryName {
+		t.Fatalf("expected %s as the first entry, got %+v", IndexEntryName, scanned)
+	}
+
+	listed, err := h.ListArchive(ctx, archivePath)
+	if err != nil {
+		t.Fatalf("ListArchive failed: %v", err)
+	}
+	if len(listed) != len(scanned)-1 {
+		t.Fatalf("index lists %d entries, archive has %d", len(listed), len(scanned)-1)
+	}
+	for i, en := range listed {
+		if en != scanned[i+1] {
+			t.Fatalf("index entry %d = %+v, archive has %+v", i, en, scanned[i+1])
+		}
+	}
+
+	if _, err := h.ReadEntry(ctx, archivePath, "data/missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist for a missing entry, got %v", err)
+	}
+	if b, err := h.ReadEntry(ctx, archivePath, "data/a.txt"); err != nil || string(b) != "abc" {
+		t.Fatalf("ReadEntry = %q, %v", b, err)
+	}
+
+	destDir := t.TempDir()
+	if err := h.ExtractArchive(ctx, archivePath, destDir); err != nil {
+		t.Fatalf("ExtractArchive failed: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(destDir, IndexEntryName)); err == nil {
+		t.Fatalf("index extracted to the destination")
+	}
+}
+
+func TestListArchive_WithoutIndex(t *testing.T) {
+	p := writeTestTarGz(t, []tar.Header{
+		{Name: "metadata.json", Typeflag: tar.TypeReg, Mode: 0o644},
+		{Name: "volumes/", Typeflag: tar.TypeDir, Mode: 0o755},
+	}, map[string]string{"metadata.json": "{}"})
+	entries, err := NewTarArchiveHandler().ListArchive(context.Background(), p)
+	if err != nil {
+		t.Fatalf("ListArchive failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Path != "metadata.json" || entries[1].Type != "dir" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestWalkArchive_StopsEarly(t *testing.T) {
+	ctx := context.Background()
+	hdrs := []tar.Header{{Name: "data/", Typeflag: tar.TypeDir, Mode: 0o755}}
+	for i := 0; i < 100; i++ {
+		hdrs = append(hdrs, tar.Header{Name: fmt.Sprintf("data/f%03d", i), Typeflag: tar.TypeReg, Mode: 0o644})
+	}
+	plain := writeTestTarGz(t, hdrs, nil)
+
+	dir := t.TempDir()
+	for i := 0; i < 100; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%03d", i)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	indexed := filepath.Join(t.TempDir(), "indexed.tar.gz")
+	h := NewTarArchiveHandler()
+	if err := h.CreateArchive(ctx, []ArchiveSource{{Path: dir, DestPath: "data"}}, indexed); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{plain, indexed} {
+		var seen []string
+		err := h.WalkArchive(ctx, p, func(en ArchiveEntry) error {
+			seen = append(seen, en.Path)
+			if en.Path == "data/f009" {
+				return ErrStopWalk
+			}
+			return nil
+		})
+		if err != nil || len(seen) != 11 || seen[0] != "data/" {
+			t.Fatalf("walk of %s = %v, %v", filepath.Base(p), seen, err)
+		}
+		failed := errors.New("callback failed")
+		if err := h.WalkArchive(ctx, p, func(ArchiveEntry) error { return failed }); !errors.Is(err, failed) {
+			t.Fatalf("callback error of %s = %v", filepath.Base(p), err)
+		}
+	}
+}
+
+func TestExtractEntries_SeekIndex(t *testing.T) {
+	ctx := context.Background()
+	h := NewTarArchiveHandler()
+	srcDir := t.TempDir()
+	// three entries of 3MiB: the third starts a new gzip member
+	contents := map[string][]byte{}
+	for i, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		contents[name] = bytes.Repeat([]byte{byte('a' + i)}, 3<<20)
+		if err := os.WriteFile(filepath.Join(srcDir, name), contents[name], 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	archivePath := filepath.Join(t.TempDir(), "seek.tar.gz")
+	if err := h.CreateArchive(ctx, []ArchiveSource{{Path: srcDir, DestPath: "data"}}, archivePath); err != nil {
+		t.Fatalf("CreateArchive failed: %v", err)
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	index, err := readSeekIndex(f)
+	_ = f.Close()
+	if err != nil || len(index) == 0 {
+		t.Fatalf("expected a seek index, got %v (%v)", index, err)
+	}
+	members := map[int64]bool{}
+	for _, en := range index {
+		members[en.Offset] = true
+	}
+	if len(members) < 2 {
+		t.Fatalf("expected several gzip members, got %+v", index)
+	}
+
+	dest := t.TempDir()
+	if err := h.ExtractEntries(ctx, archivePath, dest, func(name string) bool { return name == "data/c.bin" }); err != nil {
+		t.Fatalf("ExtractEntries failed: %v", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dest, "data", "c.bin")); err != nil || !bytes.Equal(b, contents["c.bin"]) {
+		t.Fatalf("c.bin not extracted correctly (%d bytes, %v)", len(b), err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "data", "a.bin")); err == nil {
+		t.Fatalf("a.bin should not be extracted")
+	}
+	if b, err := h.ReadEntry(ctx, archivePath, "data/b.bin"); err != nil || !bytes.Equal(b, contents["b.bin"]) {
+		t.Fatalf("ReadEntry through the seek index failed (%d bytes, %v)", len(b), err)
+	}
+	if err := h.ExtractEntries(ctx, archivePath, dest, func(name string) bool { return name == "data/none" }); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist when nothing matches, got %v", err)
+	}
+
+	// plain readers see an ordinary .tar.gz
+	full := t.TempDir()
+	if err := h.ExtractArchive(ctx, archivePath, full); err != nil {
+		t.Fatalf("ExtractArchive failed: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(full, SeekEntryName)); err == nil {
+		t.Fatalf("seek index extracted to the destination")
+	}
+	af, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer func() { _ = af.Close() }()
+	if _, err := Verify(ctx, af); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+}
+
+func TestExtractEntries_WithoutSeekIndex(t *testing.T) {
+	p := writeTestTarGz(t, []tar.Header{
+		{Name: "keep.txt", Typeflag: tar.TypeReg, Mode: 0o644},
+		{Name: "skip.txt", Typeflag: tar.TypeReg, Mode: 0o644},
+	}, map[string]string{"keep.txt": "keep", "skip.txt": "skip"})
+	dest := t.TempDir()
+	if err := NewTarArchiveHandler().ExtractEntries(context.Background(), p, dest, func(name string) bool { return name == "keep.txt" }); err != nil {
+		t.Fatalf("ExtractEntries failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "keep.txt")); err != nil {
+		t.Fatalf("keep.txt missing: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "skip.txt")); err == nil {
+		t.Fatalf("skip.txt should not be extracted")
+	}
+}
+
+func TestRemapTarGz(t *testing.T) {
+	src := writeTestTarGz(t, []tar.Header{
+		{Name: "data/", Typeflag: tar.TypeDir, Mode: 0o755, Uid: 1000, Gid: 1000, Uname: "app"},
+		{Name: "data/a", Typeflag: tar.TypeReg, Mode: 0o644, Uid: 1001, Gid: 50},
+		{Name: "data/b", Typeflag: tar.TypeReg, Mode: 0o644, Uid: 0, Gid: 0},
+	}, map[string]string{"data/a": "a"})
+	dst := filepath.Join(t.TempDir(), "mapped.tar.gz")
+	ids := IDMap{UIDs: map[int]int{1000: 1001, 1001: 1000}, GIDs: map[int]int{1000: 2000}}
+	if err := RemapTarGz(context.Background(), src, dst, ids); err != nil {
+		t.Fatalf("remap: %v", err)
+	}
+	f, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	got := map[string][2]int{}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Uname != "" {
+			t.Fatalf("%s kept owner name %q", hdr.Name, hdr.Uname)
+		}
+		got[hdr.Name] = [2]int{hdr.Uid, hdr.Gid}
+	}
+	want := map[string][2]int{"data/": {1001, 2000}, "data/a": {1000, 50}, "data/b": {0, 0}}
+	for name, w := range want {
+		if got[name] != w {
+			t.Fatalf("%s: owner %v, want %v", name, got[name], w)
+		}
+	}
+}
+
+func TestIDMap_Ranges(t *testing.T) {
+	// read on a host remapped to 100000, written to one remapped to 200000, with user 1000
+	// renumbered to 1500 on the way
+	ids := IDMap{UIDs: map[int]int{1000: 1500}, From: &IDRange{UID: 100000, GID: 100000, Size: 65536}, To: &IDRange{UID: 200000, GID: 300000, Size: 65536}}
+	for _, c := range []struct{ uid, gid, wantUID, wantGID int }{
+		{100000, 100000, 200000, 300000},
+		{101000, 100050, 201500, 300050},
+		// outside the source range: a host ID, shifted like a container ID
+		{0, 5, 200000, 300005},
+		// outside both ranges
+		{70000, 70000, 70000, 70000},
+	} {
+		if uid, gid := ids.Map(c.uid, c.gid); uid != c.wantUID || gid != c.wantGID {
+			t.Fatalf("Map(%d, %d) = %d, %d, want %d, %d", c.uid, c.gid, uid, gid, c.wantUID, c.wantGID)
+		}
+	}
+	if !(IDMap{UIDs: map[int]int{}}).Empty() || (IDMap{To: &IDRange{}}).Empty() {
+		t.Fatalf("Empty misreports")
+	}
+}
+
+func TestExtractTarGz_SELinuxLabels(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("labels are restored as root only")
+	}
+	const label = "system_u:object_r:container_file_t:s0"
+	p := writeTestTarGz(t, []tar.Header{
+		{Name: "data/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "data/a", Typeflag: tar.TypeReg, Mode: 0o644, PAXRecords: map[string]string{paxSELinuxLabel: label}},
+	}, map[string]string{"data/a": "a"})
+	dest := t.TempDir()
+	if err := ExtractTarGz(context.Background(), p, dest, ExtractOptions{StripRoot: "data", SELinuxLabels: true}); err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	got := selinuxLabel(filepath.Join(dest, "a"))
+	if got == "" {
+		t.Skip("filesystem does not keep SELinux labels")
+	}
+	if got != label {
+		t.Fatalf("label = %q, want %q", got, label)
+	}
+	// and backing it up records it again
+	out := filepath.Join(t.TempDir(), "out.tar.gz")
+	if err := NewTarArchiveHandler().CreateArchive(context.Background(), []ArchiveSource{{Path: dest, DestPath: "data"}}, out); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("data/a not found: %v", err)
+		}
+		if hdr.Name == "data/a" {
+			if hdr.PAXRecords[paxSELinuxLabel] != label {
+				t.Fatalf("recorded label = %q", hdr.PAXRecords[paxSELinuxLabel])
+			}
+			return
+		}
+	}
+}
+
+func TestContentDigest(t *testing.T) {
+	ctx := context.Background()
+	h := NewTarArchiveHandler()
+	src := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(src, "sub"), 0o755)
+	_ = os.WriteFile(filepath.Join(src, "a.txt"), []byte("alpha"), 0o644)
+	_ = os.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("bravo"), 0o600)
+	_ = os.Symlink("a.txt", filepath.Join(src, "link"))
+	digest := func(destPath string) *ContentSummary {
+		t.Helper()
+		out := filepath.Join(t.TempDir(), "v.tar.gz")
+		if err := h.CreateArchive(ctx, []ArchiveSource{{Path: src, DestPath: destPath}}, out); err != nil {
+			t.Fatal(err)
+		}
+		c, err := ContentDigest(ctx, out, destPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	first := digest("data")
+	if first.Files != 2 || first.Bytes != 10 {
+		t.Fatalf("summary = %+v", first)
+	}
+	// the same data under another root, with other modes, summarizes the same
+	_ = os.Chmod(filepath.Join(src, "sub", "b.txt"), 0o644)
+	if again := digest("restored_vol"); again.Digest != first.Digest {
+		t.Fatalf("digest changed with root/mode: %s vs %s", again.Digest, first.Digest)
+	}
+	// a truncated file does not
+	_ = os.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("bra"), 0o644)
+	if cut := digest("data"); cut.Digest == first.Digest || cut.Bytes != 8 {
+		t.Fatalf("truncation not detected: %+v", cut)
+	}
+}
+
+func TestRebaseTarGz(t *testing.T) {
+	ctx := context.Background()
+	h := NewTarArchiveHandler()
+	src := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(src, "sub"), 0o755)
+	_ = os.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("bravo"), 0o644)
+	out := filepath.Join(t.TempDir(), "v.tar.gz")
+	if err := h.CreateArchive(ctx, []ArchiveSource{{Path: src, DestPath: "data"}}, out); err != nil {
+		t.Fatal(err)
+	}
+	names := func(root string) []string {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := RebaseTarGz(ctx, out, root, "restore", &buf); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		tr := tar.NewReader(&buf)
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				return got
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, hdr.Name)
+		}
+	}
+	want := "restore/ restore/sub/ restore/sub/b.txt"
+	if got := strings.Join(names("data"), " "); got != want {
+		t.Fatalf("rebased = %s, want %s", got, want)
+	}
+	// without entries under root everything is moved, but not the index
+	if got := strings.Join(names("other"), " "); got != "restore/data/ restore/data/sub/ restore/data/sub/b.txt" {
+		t.Fatalf("rebased without root = %s", got)
+	}
+}
+
+func TestTarArchive_AutoCompression(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	random := make([]byte, 2<<20)
+	rand.New(rand.NewSource(1)).Read(random)
+	text := bytes.Repeat([]byte("compressible log line\n"), 100000)
+	// a layer blob: no extension, recognised by its gzip header
+	blob := append([]byte{0x1f, 0x8b}, bytes.Repeat([]byte("x"), 2<<20)...)
+	files := map[string][]byte{"random.bin": random, "text.log": text, "blob": blob}
+	for name, b := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]bool{"random.bin": true, "text.log": false, "blob": true} {
+		fi, _ := os.Stat(filepath.Join(dir, name))
+		if got, err := isCompressed(filepath.Join(dir, name), fi.Size()); err != nil || got != want {
+			t.Fatalf("isCompressed(%s) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+
+	h := NewTarArchiveHandler()
+	h.SetAutoCompression(true)
+	archivePath := filepath.Join(t.TempDir(), "auto.tar.gz")
+	stats, err := h.CreateArchiveWithStats(ctx, []ArchiveSource{
+		{Path: filepath.Join(dir, "text.log")},
+		{Path: filepath.Join(dir, "random.bin")},
+		{Path: filepath.Join(dir, "blob")},
+	}, archivePath)
+	if err != nil {
+		t.Fatalf("CreateArchiveWithStats failed: %v", err)
+	}
+	if stats[0].Compressed > stats[0].Size/10 {
+		t.Fatalf("text.log was not compressed: %+v", stats[0])
+	}
+	// stored: a few bytes of gzip framing per 64KiB on top of the data
+	if s := stats[2]; s.Compressed < s.Size || s.Compressed > s.Size+s.Size/100 {
+		t.Fatalf("blob was not stored as is: %+v", s)
+	}
+
+	dest := t.TempDir()
+	if err := NewTarArchiveHandler().ExtractArchive(ctx, archivePath, dest); err != nil {
+		t.Fatalf("ExtractArchive failed: %v", err)
+	}
+	for name, want := range files {
+		if b, err := os.ReadFile(filepath.Join(dest, name)); err != nil || !bytes.Equal(b, want) {
+			t.Fatalf("%s round trip mismatch (%d bytes, %v)", name, len(b), err)
+		}
+	}
+	if b, err := h.ReadEntry(ctx, archivePath, "text.log"); err != nil || !bytes.Equal(b, text) {
+		t.Fatalf("ReadEntry through the seek index failed (%d bytes, %v)", len(b), err)
+	}
+}
+
+func TestTarArchive_SparseFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	p := filepath.Join(dir, "disk.img")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 64MiB with data only at the start and in the middle
+	if err := f.Truncate(64 << 20); err != nil {
+		t.Fatal(err)
+	}
+	head := bytes.Repeat([]byte("head"), 1024)
+	mid := bytes.Repeat([]byte("middle"), 2048)
+	if _, err := f.WriteAt(head, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(mid, 32<<20); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	fi, _ := os.Stat(p)
+	if !mayBeSparse(fi) {
+		t.Skip("the filesystem of the temp dir does not keep holes")
+	}
+	want, _ := os.ReadFile(p)
+
+	// uncompressed, so the archive size shows what was stored
+	h := NewTarArchiveHandler()
+	h.SetCompressionLevel(gzip.NoCompression)
+	archivePath := filepath.Join(t.TempDir(), "sparse.tar.gz")
+	if err := h.CreateArchive(ctx, []ArchiveSource{{Path: dir, DestPath: "data"}}, archivePath); err != nil {
+		t.Fatalf("CreateArchive failed: %v", err)
+	}
+	if ai, _ := os.Stat(archivePath); ai.Size() > 1<<20 {
+		t.Fatalf("archive of a sparse file is %d bytes", ai.Size())
+	}
+	entries, err := h.ListArchive(ctx, archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, en := range entries {
+		if en.Path == "data/disk.img" && (en.Size != 64<<20 || !en.Sparse) {
+			t.Fatalf("listed as %+v", en)
+		}
+	}
+
+	if c, err := ContentDigest(ctx, archivePath, "data"); err != nil || c.Sparse != 1 || c.Bytes != 64<<20 {
+		t.Fatalf("ContentDigest = %+v, %v", c, err)
+	}
+
+	dest := t.TempDir()
+	if err := h.ExtractArchive(ctx, archivePath, dest); err != nil {
+		t.Fatalf("ExtractArchive failed: %v", err)
+	}
+	out := filepath.Join(dest, "data", "disk.img")
+	if got, err := os.ReadFile(out); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("round trip mismatch (%d bytes, %v)", len(got), err)
+	}
+	if oi, _ := os.Stat(out); !mayBeSparse(oi) {
+		t.Fatalf("extracted file is fully allocated")
+	}
+}
+
+func TestCreateArchive_BoundaryKeepsMountpointsEmpty(t *testing.T) {
+	ctx := context.Background()
+	h := NewTarArchiveHandler()
+	srcDir := t.TempDir()
+	mnt := filepath.Join(srcDir, "mnt")
+	if err := os.MkdirAll(filepath.Join(mnt, "deep"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(mnt, "other.txt"), []byte("other fs"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "own.txt"), []byte("own"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	archivePath := filepath.Join(t.TempDir(), "bounded.tar.gz")
+	src := ArchiveSource{Path: srcDir, DestPath: "data", Boundary: filesystem.Boundary{Skip: []string{mnt}}}
+	if err := h.CreateArchive(ctx, []ArchiveSource{src}, archivePath); err != nil {
+		t.Fatalf("CreateArchive failed: %v", err)
+	}
+	entries, err := h.ListArchive(ctx, archivePath)
+	if err != nil {
+		t.Fatalf("ListArchive failed: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Path)
+	}
+	if want := []string{"data/", "data/mnt/", "data/own.txt"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("entries = %v, want %v", names, want)
+	}
+}
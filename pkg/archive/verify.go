@@ -0,0 +1,158 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ChecksumSuffix is appended to an archive path for its sha256sum-compatible sidecar file.
+const ChecksumSuffix = ".sha256"
+
+// VerifyResult summarizes a full read of a backup archive.
+type VerifyResult struct {
+	SHA256  string
+	Entries int
+	Bytes   int64
+}
+
+// Verify reads a gzip-compressed tar stream to the end, failing on any gzip CRC, length or tar
+// header error. Nested archives (per-service container.tar.gz, filesystem.tar, image.tar) are
+// walked as well so corruption inside them is reported with the entry path.
+func Verify(ctx context.Context, r io.Reader) (*VerifyResult, error) {
+	h := sha256.New()
+	counted := &countingReader{r: io.TeeReader(r, h)}
+	res := &VerifyResult{}
+	if err := verifyGzipTar(ctx, counted, "", res); err != nil {
+		return nil, err
+	}
+	// drain trailing bytes (tar padding/concatenated members) so the digest covers the file
+	if _, err := io.Copy(io.Discard, counted); err != nil {
+		return nil, err
+	}
+	res.SHA256 = hex.EncodeToString(h.Sum(nil))
+	res.Bytes = counted.n
+	return res, nil
+}
+
+func verifyGzipTar(ctx context.Context, r io.Reader, prefix string, res *VerifyResult) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("%sgzip header: %w", prefix, err)
+	}
+	defer gz.Close()
+	if err := verifyTar(ctx, gz, prefix, res); err != nil {
+		return err
+	}
+	// reading to EOF makes gzip check the trailer CRC and size
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return fmt.Errorf("%sgzip stream: %w", prefix, err)
+	}
+	return nil
+}
+
+func verifyTar(ctx context.Context, r io.Reader, prefix string, res *VerifyResult) error {
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%star header: %w", prefix, err)
+		}
+		res.Entries++
+		if err := checkHeaderName(hdr.Name); err != nil {
+			return fmt.Errorf("%star header: %w", prefix, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := prefix + hdr.Name
+		switch {
+		case strings.HasSuffix(hdr.Name, ".tar.gz"):
+			err = verifyGzipTar(ctx, tr, name+": ", res)
+		case strings.HasSuffix(hdr.Name, ".tar"):
+			err = verifyTar(ctx, tr, name+": ", res)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+}
+
+// checkHeaderName rejects entry names no backup writes: empty, absolute or climbing out of
+// the extraction directory.
+func checkHeaderName(name string) error {
+	clean := path.Clean(strings.TrimPrefix(name, "./"))
+	switch {
+	case name == "":
+		return fmt.Errorf("empty entry name")
+	case path.IsAbs(name):
+		return fmt.Errorf("absolute entry name %q", name)
+	case clean == ".." || strings.HasPrefix(clean, "../"):
+		return fmt.Errorf("entry name %q escapes the archive root", name)
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// WriteChecksumFile writes "<sha256>  <basename>" next to path, in the format read by
+// `sha256sum -c`, and returns the digest.
+func WriteChecksumFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+	if err := os.WriteFile(path+ChecksumSuffix, []byte(line), 0o644); err != nil {
+		return "", err
+	}
+	return sum, nil
+}
+
+// ParseChecksumFile returns the digest from a sha256sum-style checksum file.
+func ParseChecksumFile(r io.Reader) (string, error) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) > 0 && len(fields[0]) == sha256.Size*2 {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no sha256 digest found")
+}
@@ -0,0 +1,72 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerify_DetectsCorruption(t *testing.T) {
+	ctx := context.Background()
+	h := NewTarArchiveHandler()
+
+	srcDir := t.TempDir()
+	// incompressible-ish content so a flipped byte lands in deflate data
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	if err := os.WriteFile(filepath.Join(srcDir, "data.bin"), payload, 0o644); err != nil {
+		t.Fatalf("write payload: %v", err)
+	}
+	inner := filepath.Join(t.TempDir(), "container.tar.gz")
+	if err := h.CreateArchive(ctx, []ArchiveSource{{Path: srcDir, DestPath: "data"}}, inner); err != nil {
+		t.Fatalf("CreateArchive inner: %v", err)
+	}
+	outer := filepath.Join(t.TempDir(), "backup.tar.gz")
+	if err := h.CreateArchive(ctx, []ArchiveSource{{Path: inner, DestPath: "containers/web/container.tar.gz"}}, outer); err != nil {
+		t.Fatalf("CreateArchive outer: %v", err)
+	}
+
+	sum, err := WriteChecksumFile(outer)
+	if err != nil {
+		t.Fatalf("WriteChecksumFile: %v", err)
+	}
+	f, _ := os.Open(outer + ChecksumSuffix)
+	parsed, err := ParseChecksumFile(f)
+	f.Close()
+	if err != nil || parsed != sum {
+		t.Fatalf("ParseChecksumFile = %q, %v; want %q", parsed, err, sum)
+	}
+
+	f, _ = os.Open(outer)
+	res, err := Verify(ctx, f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("Verify on intact archive: %v", err)
+	}
+	if res.SHA256 != sum || res.Entries < 2 {
+		t.Fatalf("unexpected result %+v (want sha %s)", res, sum)
+	}
+
+	b, _ := os.ReadFile(outer)
+	b[len(b)/2] ^= 0xff
+	if _, err := Verify(ctx, bytes.NewReader(b)); err == nil {
+		t.Fatalf("expected corruption to be detected")
+	}
+	if _, err := Verify(ctx, bytes.NewReader(b[:len(b)-10])); err == nil {
+		t.Fatalf("expected truncation to be detected")
+	}
+}
+
+func TestVerify_RejectsEscapingNames(t *testing.T) {
+	for _, name := range []string{"../etc/passwd", "/etc/passwd", "data/../../x"} {
+		p := writeTestTarGz(t, []tar.Header{{Name: name, Typeflag: tar.TypeReg, Mode: 0o644}}, map[string]string{name: "x"})
+		f, _ := os.Open(p)
+		_, err := Verify(context.Background(), f)
+		f.Close()
+		if err == nil {
+			t.Fatalf("expected %q to be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Package audit keeps an append-only log of the operations that destroy or overwrite data:
+// restores, promotions, backup pruning and cleanups.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	stdErrors "errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/lock"
+)
+
+// PathEnv overrides where the audit log is kept.
+const PathEnv = "DOCKERBACKUP_AUDIT_LOG"
+
+// Results of an operation.
+const (
+	ResultOK     = "ok"
+	ResultFailed = "failed"
+)
+
+// Entry is one operation in the audit log, a line of JSON.
+type Entry struct {
+	Time time.Time `json:"time"`
+	// User runs dockerbackup; RealUser is who invoked it through sudo
+	User     string `json:"user"`
+	RealUser string `json:"realUser,omitempty"`
+	Host     string `json:"host"`
+	// Via is how the operation was requested when not on the command line, such as
+	// "api 10.0.0.5:51234" or "agent 10.0.0.9:40112"
+	Via string `json:"via,omitempty"`
+	// Op is the operation: restore, restore-compose, promote, prune, cleanup or migrate
+	Op string `json:"op"`
+	// Target is the archive restored, or the container or directory operated on
+	Target string `json:"target"`
+	// Args are the operation's arguments and flags, with URL passwords masked
+	Args     []string `json:"args,omitempty"`
+	Result   string   `json:"result"`
+	Error    string   `json:"error,omitempty"`
+	Duration string   `json:"duration"`
+	// Details lists what the operation removed, replaced or overwrote
+	Details []string `json:"details,omitempty"`
+	// Prev is the SHA-256 of the previous line, so edits and deletions show (see Verify)
+	Prev string `json:"prev,omitempty"`
+}
+
+// Path returns the audit log: $DOCKERBACKUP_AUDIT_LOG, or audit.jsonl in
+// $XDG_STATE_HOME/dockerbackup (default ~/.local/state/dockerbackup).
+func Path() string {
+	if p := os.Getenv(PathEnv); p != "" {
+		return p
+	}
+	state := os.Getenv("XDG_STATE_HOME")
+	if state == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			home = os.TempDir()
+		}
+		state = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(state, "dockerbackup", "audit.jsonl")
+}
+
+// Record is an operation in progress, written to the log by End.
+type Record struct {
+	Entry
+	path  string
+	start time.Time
+}
+
+// Begin starts recording an operation. It fails when the audit log cannot be written, so the
+// caller can refuse to go ahead.
+func Begin(op, target string, args []string) (*Record, error) {
+	p := Path()
+	if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+		return nil, &errors.OperationError{Op: "create audit log directory", Err: err}
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, &errors.OperationError{Op: "open audit log", Err: err}
+	}
+	_ = f.Close()
+	masked := make([]string, len(args))
+	for i, a := range args {
+		masked[i] = mask(a)
+	}
+	return &Record{Entry: Entry{Op: op, Target: target, Args: masked}, path: p, start: time.Now()}, nil
+}
+
+// End appends the operation with its outcome, opErr, to the audit log and returns opErr,
+// joined with the reason the log could not be written if it could not.
+func (r *Record) End(opErr error) error {
+	e := r.Entry
+	e.Time = r.start.UTC()
+	e.Duration = time.Since(r.start).Round(time.Millisecond).String()
+	e.Result = ResultOK
+	if opErr != nil {
+		e.Result, e.Error = ResultFailed, opErr.Error()
+	}
+	if u, err := user.Current(); err == nil {
+		e.User = u.Username
+	}
+	if su := os.Getenv("SUDO_USER"); su != "" && su != e.User {
+		e.RealUser = su
+	}
+	e.Host, _ = os.Hostname()
+	if err := Append(r.path, e); err != nil {
+		return stdErrors.Join(opErr, err)
+	}
+	return opErr
+}
+
+// Conflicts returns what a restore decided about existing resources: the containers and
+// networks it replaced or renamed around, and the volumes whose data it overwrote or kept.
+func Conflicts(warnings []backup.Warning) []string {
+	var out []string
+	for _, w := range warnings {
+		if w.Code == backup.WarnConflict {
+			out = append(out, w.String())
+		}
+	}
+	return out
+}
+
+// MapFlags renders a mapping as --flag=old:new arguments, sorted, for the Args of an operation
+// requested through an API.
+func MapFlags(flag string, m map[string]string) []string {
+	out := make([]string, 0, len(m))
+	for k, v := range m {
+		out = append(out, "--"+flag+"="+k+":"+v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Append writes e as the last line of the log at path, chained to the line before it.
+func Append(path string, e Entry) error {
+	l, err := lock.Acquire(context.Background(), "audit-"+path, true, 30*time.Second)
+	if err != nil {
+		return &errors.OperationError{Op: "lock audit log", Err: err}
+	}
+	defer func() { _ = l.Release() }()
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return &errors.OperationError{Op: "open audit log", Err: err}
+	}
+	defer func() { _ = f.Close() }()
+	last, err := lastLine(f)
+	if err != nil {
+		return &errors.OperationError{Op: "read audit log", Err: err}
+	}
+	e.Prev = ""
+	if len(last) > 0 {
+		e.Prev = digest(last)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	// one write, so a reader never sees half a line
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return &errors.OperationError{Op: "write audit log", Err: err}
+	}
+	return f.Sync()
+}
+
+// Read returns the entries of the log at path, oldest first.
+func Read(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	var out []Entry
+	err = scan(f, func(n int, line []byte) error {
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		out = append(out, e)
+		return nil
+	})
+	return out, err
+}
+
+// Verify checks that every line of the log at path carries the digest of the line before it,
+// and returns how many entries it holds. A line that was edited, inserted or removed breaks
+// the chain; Verify reports the first line where it does.
+func Verify(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+	prev, count := "", 0
+	err = scan(f, func(n int, line []byte) error {
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		if e.Prev != prev {
+			return fmt.Errorf("line %d: the chain is broken (the line before it was changed or removed)", n)
+		}
+		prev, count = digest(line), n
+		return nil
+	})
+	return count, err
+}
+
+func scan(r io.Reader, fn func(n int, line []byte) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		if err := fn(n, sc.Bytes()); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+func digest(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// lastLine returns the last line of f without its newline, reading backwards from the end.
+func lastLine(f *os.File) ([]byte, error) {
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil || end == 0 {
+		return nil, err
+	}
+	var line []byte
+	buf := make([]byte, 4096)
+	for pos := end; pos > 0; {
+		n := int64(len(buf))
+		if pos < n {
+			n = pos
+		}
+		pos -= n
+		if _, err := f.ReadAt(buf[:n], pos); err != nil {
+			return nil, err
+		}
+		line = append(append([]byte{}, buf[:n]...), line...)
+		trimmed := bytes.TrimSuffix(line, []byte("\n"))
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], nil
+		}
+	}
+	return bytes.TrimSuffix(line, []byte("\n")), nil
+}
+
+// mask hides the password of a URL argument, such as --to or a remote storage target.
+func mask(arg string) string {
+	i := strings.Index(arg, "://")
+	if i < 0 {
+		return arg
+	}
+	start := strings.LastIndexAny(arg[:i], "= ") + 1
+	u, err := url.Parse(arg[start:])
+	if err != nil || u.User == nil {
+		return arg
+	}
+	if _, ok := u.User.Password(); !ok {
+		return arg
+	}
+	u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	return arg[:start] + u.String()
+}
@@ -0,0 +1,63 @@
+package audit
+
+import (
+	stdErrors "errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndVerify(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "logs", "audit.jsonl")
+	t.Setenv(PathEnv, p)
+	t.Setenv("DOCKERBACKUP_LOCK_DIR", t.TempDir())
+
+	for i, opErr := range []error{nil, stdErrors.New("container web already exists")} {
+		rec, err := Begin("restore", "web_backup.tar.gz", []string{"web_backup.tar.gz", "--replace", "--from=s3://key:secret@bucket/x"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec.Details = []string{"Container web already exists; replacing it"}
+		if i == 1 {
+			rec.Via = "api 10.0.0.5:1234"
+		}
+		if err := rec.End(opErr); err != opErr {
+			t.Fatalf("End = %v, want %v", err, opErr)
+		}
+	}
+	entries, err := Read(p)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Read = %+v, %v", entries, err)
+	}
+	if e := entries[0]; e.Result != ResultOK || e.Prev != "" || e.Op != "restore" || e.Host == "" || e.Time.IsZero() {
+		t.Fatalf("first entry = %+v", e)
+	}
+	if e := entries[1]; e.Result != ResultFailed || e.Error == "" || e.Prev == "" || e.Via == "" {
+		t.Fatalf("second entry = %+v", e)
+	}
+	if got := entries[0].Args[2]; strings.Contains(got, "secret") || !strings.HasPrefix(got, "--from=s3://key:") {
+		t.Fatalf("password not masked: %s", got)
+	}
+	if n, err := Verify(p); err != nil || n != 2 {
+		t.Fatalf("Verify = %d, %v", n, err)
+	}
+
+	// rewriting the outcome of the first operation breaks the chain at the second line
+	b, _ := os.ReadFile(p)
+	if err := os.WriteFile(p, []byte(strings.Replace(string(b), `"result":"ok"`, `"result":"failed"`, 1)), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(p); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("Verify of an edited log = %v", err)
+	}
+}
+
+func TestBeginFailsWhenTheLogCannotBeWritten(t *testing.T) {
+	dir := t.TempDir()
+	// a directory where the file should be
+	t.Setenv(PathEnv, dir)
+	if _, err := Begin("prune", dir, nil); err == nil {
+		t.Fatal("Begin succeeded without a writable log")
+	}
+}
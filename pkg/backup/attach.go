@@ -0,0 +1,132 @@
+package backup
+
+import (
+	"context"
+	"math/big"
+	"net"
+	"slices"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+// attachTarget returns the network --attach-to names, which must exist.
+func (e *DefaultBackupEngine) attachTarget(ctx context.Context, name string) (*docker.NetworkConfig, error) {
+	n, err := e.dockerClient.InspectNetwork(ctx, name)
+	if err != nil || n == nil {
+		return nil, &errors.NotFoundError{Resource: "network", Name: name}
+	}
+	return n, nil
+}
+
+// attachToNetwork replaces the networks of the container with one endpoint on target, for
+// --attach-to. The aliases and links of all saved endpoints are merged; static addresses are
+// translated from the subnet of their saved network into target's subnet of the same
+// family, keeping the host part (172.18.0.5 in 172.18.0.0/16 becomes 10.5.0.5 in
+// 10.5.0.0/16). Addresses that do not fit are dropped with a warning. Containers sharing
+// another container's network namespace keep it.
+func (e *DefaultBackupEngine) attachToNetwork(cj *types.ContainerJSON, saved []docker.NetworkConfig, target *docker.NetworkConfig) {
+	if cj.HostConfig != nil && cj.HostConfig.NetworkMode.IsContainer() {
+		return
+	}
+	if cj.NetworkSettings == nil {
+		cj.NetworkSettings = &types.NetworkSettings{}
+	}
+	subnets := map[string][]docker.IPAMConfig{}
+	for _, nc := range saved {
+		subnets[nc.Name] = nc.IPAM.Config
+	}
+	mode := ""
+	if cj.HostConfig != nil {
+		mode = string(cj.HostConfig.NetworkMode)
+	}
+	merged := &network.EndpointSettings{}
+	var ipam network.EndpointIPAMConfig
+	for _, name := range docker.EndpointOrder(mode, cj.NetworkSettings.Networks) {
+		ns := cj.NetworkSettings.Networks[name]
+		if ns == nil {
+			continue
+		}
+		if merged.MacAddress == "" {
+			merged.MacAddress = ns.MacAddress
+		}
+		for _, a := range ns.Aliases {
+			if !slices.Contains(merged.Aliases, a) {
+				merged.Aliases = append(merged.Aliases, a)
+			}
+		}
+		for _, l := range ns.Links {
+			if !slices.Contains(merged.Links, l) {
+				merged.Links = append(merged.Links, l)
+			}
+		}
+		if ns.IPAMConfig == nil {
+			continue
+		}
+		for _, p := range []struct{ from, to *string }{{&ns.IPAMConfig.IPv4Address, &ipam.IPv4Address}, {&ns.IPAMConfig.IPv6Address, &ipam.IPv6Address}} {
+			if *p.from == "" || *p.to != "" {
+				continue
+			}
+			if ip := translateIP(*p.from, subnets[name], target.IPAM.Config); ip != "" {
+				*p.to = ip
+			} else {
+				e.warn(WarnStaticIP, target.Name, "Static IP %s of network %s has no counterpart in network %s; Docker assigns one", *p.from, name, target.Name)
+			}
+		}
+	}
+	if ipam.IPv4Address != "" || ipam.IPv6Address != "" {
+		merged.IPAMConfig = &ipam
+	}
+	cj.NetworkSettings.Networks = map[string]*network.EndpointSettings{target.Name: merged}
+	if cj.HostConfig != nil {
+		cj.HostConfig.NetworkMode = container.NetworkMode(target.Name)
+	}
+}
+
+// translateIP moves addr from the saved subnet containing it to the subnet of the same
+// family in to, keeping its offset. An address already in a subnet of to is kept. It returns
+// "" when no saved subnet contains addr, to has no subnet of its family, or the offset does
+// not fit or lands on the gateway or broadcast address.
+func translateIP(addr string, from, to []docker.IPAMConfig) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	subnet := func(configs []docker.IPAMConfig, contains bool) (*net.IPNet, string) {
+		for _, c := range configs {
+			_, n, err := net.ParseCIDR(c.Subnet)
+			if err != nil || len(n.IP) != len(ip) {
+				continue
+			}
+			if !contains || n.Contains(ip) {
+				return n, c.Gateway
+			}
+		}
+		return nil, ""
+	}
+	if n, _ := subnet(to, true); n != nil {
+		return ip.String()
+	}
+	src, _ := subnet(from, true)
+	dst, gateway := subnet(to, false)
+	if src == nil || dst == nil {
+		return ""
+	}
+	off := new(big.Int).Sub(new(big.Int).SetBytes(ip), new(big.Int).SetBytes(src.IP))
+	ones, bits := dst.Mask.Size()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	if off.Sign() <= 0 || off.Cmp(size) >= 0 || (len(ip) == net.IPv4len && off.Cmp(new(big.Int).Sub(size, big.NewInt(1))) == 0) {
+		return ""
+	}
+	out := net.IP(new(big.Int).Add(new(big.Int).SetBytes(dst.IP), off).FillBytes(make([]byte, len(ip))))
+	if out.String() == gateway {
+		return ""
+	}
+	return out.String()
+}
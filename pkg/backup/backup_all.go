@@ -0,0 +1,87 @@
+package backup
+
+import (
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/docker/docker/api/types"
+)
+
+// BackupAllFilter is what `dockerbackup backup-all` leaves out of the running containers,
+// besides the ones labeled LabelIgnore=true.
+type BackupAllFilter struct {
+	// ExcludeNames are glob patterns (path.Match) matched against container names
+	ExcludeNames []string
+	// ExcludeLabels are label keys, matching any value, or key=value pairs
+	ExcludeLabels []string
+}
+
+// Validate rejects malformed name patterns.
+func (f BackupAllFilter) Validate() error {
+	for _, p := range f.ExcludeNames {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid --exclude-name %q: %v", p, err)
+		}
+	}
+	return nil
+}
+
+// SkipReason says why the container named name carrying labels is skipped, or "" when it is
+// backed up. The ignore label comes first, then the first excluded label it carries and then
+// the first name pattern matching it.
+func (f BackupAllFilter) SkipReason(name string, labels map[string]string) string {
+	if labels[LabelIgnore] == "true" {
+		return "label " + LabelIgnore + "=true"
+	}
+	for _, l := range f.ExcludeLabels {
+		key, value, withValue := strings.Cut(l, "=")
+		if v, ok := labels[key]; ok && (!withValue || v == value) {
+			return "label " + l
+		}
+	}
+	for _, p := range f.ExcludeNames {
+		if ok, _ := path.Match(p, name); ok {
+			return "name " + p
+		}
+	}
+	return ""
+}
+
+// BackupAllTarget is a running container and why backup-all skips it, if it does.
+type BackupAllTarget struct {
+	docker.ContainerRef
+	Skipped string
+}
+
+// BackupAllTargets lists the running containers with the reason filter skips each for. A
+// container gone since it was listed is left out.
+func BackupAllTargets(ctx context.Context, dc docker.DockerClient, filter BackupAllFilter) ([]BackupAllTarget, error) {
+	refs, err := dc.ListRunningContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]BackupAllTarget, 0, len(refs))
+	for _, ref := range refs {
+		b, err := dc.InspectContainer(ctx, ref.ID)
+		var cj types.ContainerJSON
+		if err == nil {
+			cj, err = docker.ParseContainerJSON(b)
+		}
+		if stdErrors.Is(err, docker.ErrEmptyInspect) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("inspect %s: %w", ref.Name, err)
+		}
+		var labels map[string]string
+		if cj.Config != nil {
+			labels = cj.Config.Labels
+		}
+		targets = append(targets, BackupAllTarget{ContainerRef: ref, Skipped: filter.SkipReason(ref.Name, labels)})
+	}
+	return targets, nil
+}
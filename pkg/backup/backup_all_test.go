@@ -0,0 +1,75 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/brian033/dockerbackup/pkg/docker"
+)
+
+func TestBackupAllFilter_SkipReason(t *testing.T) {
+	filter := BackupAllFilter{
+		ExcludeNames:  []string{"ci-*", "cache"},
+		ExcludeLabels: []string{"tier", "env=dev"},
+	}
+	for _, tc := range []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{"web", nil, ""},
+		{"web", map[string]string{LabelIgnore: "true"}, "label dockerbackup.ignore=true"},
+		{"web", map[string]string{LabelIgnore: "false"}, ""},
+		// a glob, and a plain name matching only itself
+		{"ci-runner-3", nil, "name ci-*"},
+		{"cache", nil, "name cache"},
+		{"cache-2", nil, ""},
+		// a key matches any value, key=value only that value
+		{"web", map[string]string{"tier": ""}, "label tier"},
+		{"web", map[string]string{"tier": "frontend"}, "label tier"},
+		{"web", map[string]string{"env": "dev"}, "label env=dev"},
+		{"web", map[string]string{"env": "prod"}, ""},
+		{"web", map[string]string{"environment": "dev"}, ""},
+		// the ignore label first, then the excluded labels in order, then the names
+		{"ci-runner", map[string]string{LabelIgnore: "true", "tier": "x"}, "label dockerbackup.ignore=true"},
+		{"web", map[string]string{"env": "dev", "tier": "x"}, "label tier"},
+		{"ci-runner", map[string]string{"env": "dev"}, "label env=dev"},
+	} {
+		if got := filter.SkipReason(tc.name, tc.labels); got != tc.want {
+			t.Errorf("SkipReason(%q, %v) = %q, want %q", tc.name, tc.labels, got, tc.want)
+		}
+	}
+	if err := (BackupAllFilter{ExcludeNames: []string{"web["}}).Validate(); err == nil {
+		t.Fatal("expected a malformed pattern to be rejected")
+	}
+}
+
+func TestBackupAllTargets(t *testing.T) {
+	inspect := func(id string, labels map[string]string) []byte {
+		b, _ := json.Marshal([]map[string]any{{"Id": id, "Config": map[string]any{"Labels": labels}}})
+		return b
+	}
+	dc := &fakeDockerClient{
+		running: []docker.ContainerRef{{ID: "1", Name: "web"}, {ID: "2", Name: "runner"}, {ID: "3", Name: "db"}, {ID: "4", Name: "gone"}},
+		containers: map[string][]byte{
+			"1": inspect("1", map[string]string{"tier": "frontend"}),
+			"2": inspect("2", map[string]string{LabelIgnore: "true"}),
+			"3": inspect("3", nil),
+			"4": []byte("[]"),
+		},
+	}
+	targets, err := BackupAllTargets(context.Background(), dc, BackupAllFilter{ExcludeNames: []string{"d?"}})
+	if err != nil {
+		t.Fatalf("BackupAllTargets: %v", err)
+	}
+	got := map[string]string{}
+	for _, tg := range targets {
+		got[tg.Name] = tg.Skipped
+	}
+	want := map[string]string{"web": "", "runner": "label dockerbackup.ignore=true", "db": "name d?"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("targets = %v, want %v", got, want)
+	}
+}
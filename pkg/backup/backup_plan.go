@@ -0,0 +1,197 @@
+package backup
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+// BackupPlan describes what a container backup would capture, for backup --dry-run.
+type BackupPlan struct {
+	Containers []ContainerBackupPlan
+	// Images are saved once, however many containers use them
+	Images []ImagePlan
+	// EstimatedSize is the uncompressed size of everything that would be archived; the archive
+	// is smaller by however well the data compresses. Sizes that are unknown count as 0.
+	EstimatedSize int64
+}
+
+// ContainerBackupPlan is the planned backup of one container.
+type ContainerBackupPlan struct {
+	Name  string
+	Image string
+	// FilesystemSize is the root filesystem docker export streams; 0 when unknown
+	FilesystemSize int64
+	Networks       []string
+	Mounts         []MountPlan
+}
+
+// ImagePlan is an image the backup would save; Size is 0 when unknown.
+type ImagePlan struct {
+	Ref  string
+	Size int64
+}
+
+// MountPlan is a mount of a container and whether its data would be archived. Skipped is
+// empty for archived mounts, otherwise excluded (--include-volume/--exclude-volume/
+// --skip-bind-mounts), remote (--skip-remote-volume-data), shared (archived with an earlier
+// container), special (a bind mount of the Docker socket, /proc and the like, without
+// --allow-special-mounts) or unsupported (tmpfs and other mounts without data to archive).
+type MountPlan struct {
+	Type        string
+	Name        string // volume name or bind source
+	Destination string
+	// Size of the data on this host; 0 when unknown, see Note
+	Size    int64
+	Skipped string
+	Note    string
+}
+
+// PlanBackup inspects the containers of a container backup request and the host without
+// writing anything, and reports what the backup would capture.
+func PlanBackup(ctx context.Context, dc docker.DockerClient, request BackupRequest) (*BackupPlan, error) {
+	ids := request.ContainerIDs
+	if len(ids) == 0 {
+		if request.ContainerID == "" {
+			return nil, &errors.ValidationError{Field: "ContainerID", Msg: "required"}
+		}
+		ids = []string{request.ContainerID}
+	}
+	fsys := filesystem.NewHandler()
+	plan := &BackupPlan{}
+	images := map[string]bool{}
+	archived := map[string]string{}
+	for _, id := range ids {
+		inspectJSON, err := dc.InspectContainer(ctx, id)
+		if err != nil {
+			return nil, &errors.OperationError{Op: "inspect container " + id, Err: err}
+		}
+		info, err := docker.ParseContainerInfo(inspectJSON)
+		if err != nil {
+			return nil, &errors.OperationError{Op: "parse container inspect", Err: err}
+		}
+		cj, err := docker.ParseContainerJSON(inspectJSON)
+		if err != nil {
+			return nil, &errors.OperationError{Op: "parse container inspect", Err: err}
+		}
+		name := strings.TrimPrefix(info.Name, "/")
+		cp := ContainerBackupPlan{Name: name}
+		cp.FilesystemSize, _ = dc.ContainerSize(ctx, info.ID)
+		plan.EstimatedSize += cp.FilesystemSize
+		if cj.ContainerJSONBase != nil && cj.ContainerJSONBase.Image != "" {
+			ref := cj.ContainerJSONBase.Image
+			cp.Image = ref
+			if cj.Config != nil && cj.Config.Image != "" {
+				cp.Image = cj.Config.Image
+			}
+			if !images[ref] {
+				images[ref] = true
+				ip := ImagePlan{Ref: cp.Image}
+				if img, err := dc.InspectImage(ctx, ref); err == nil {
+					ip.Size = img.Size
+				}
+				plan.Images = append(plan.Images, ip)
+				plan.EstimatedSize += ip.Size
+			}
+		}
+		if cj.NetworkSettings != nil {
+			for n := range cj.NetworkSettings.Networks {
+				cp.Networks = append(cp.Networks, n)
+			}
+			sort.Strings(cp.Networks)
+		}
+		for _, m := range info.Mounts {
+			mp := planMount(ctx, fsys, dc, m, request.Options)
+			if mp.Skipped == "" && m.Type == "volume" {
+				if holder, ok := archived[m.Name]; ok {
+					mp.Skipped, mp.Note = "shared", "archived with "+holder
+				} else {
+					archived[m.Name] = name
+				}
+			}
+			if mp.Skipped == "" {
+				plan.EstimatedSize += mp.Size
+			}
+			cp.Mounts = append(cp.Mounts, mp)
+		}
+		plan.Containers = append(plan.Containers, cp)
+	}
+	return plan, nil
+}
+
+// planMount decides like the backup whether the data of m would be archived, and measures it.
+func planMount(ctx context.Context, fsys filesystem.Handler, dc docker.DockerClient, m docker.Mount, opts BackupOptions) MountPlan {
+	mp := MountPlan{Type: m.Type, Name: m.Name, Destination: m.Destination}
+	if m.Type == "bind" {
+		mp.Name = m.Source
+	}
+	switch {
+	case (m.Type != "volume" || m.Name == "") && (m.Type != "bind" || m.Source == ""):
+		mp.Skipped = "unsupported"
+		return mp
+	case !mountSelected(m, opts):
+		mp.Skipped = "excluded"
+		return mp
+	case m.Type == "bind" && !opts.AllowSpecialMounts && specialMount(fsys, m.Source):
+		mp.Skipped = "special"
+		return mp
+	case m.Type == "volume" && opts.SkipRemoteVolumeData && !isPluginDriver(m.Driver):
+		if v, err := dc.InspectVolume(ctx, m.Name); err == nil && isRemoteVolume(v) {
+			mp.Skipped, mp.Note = "remote", "mount options only ("+v.Options["type"]+" "+v.Options["device"]+")"
+			return mp
+		}
+	}
+	if m.Type == "volume" && needsDaemonStreaming(fsys, m) {
+		mp.Note = "archived through the daemon; size unknown"
+		return mp
+	}
+	b := filesystem.Boundary{OneFileSystem: opts.OneFileSystem}
+	if nested, err := filesystem.NestedMounts(m.Source); err == nil && len(nested) > 0 {
+		if opts.NestedMounts == NestedMountsSkip {
+			b.Skip = nested
+			mp.Note = "leaves out the mountpoints " + strings.Join(nested, ", ")
+		} else {
+			mp.Note = "includes the mountpoints " + strings.Join(nested, ", ")
+		}
+	}
+	size, err := dirSize(fsys, m.Source, b)
+	if err != nil {
+		mp.Note = "size unknown: " + err.Error()
+	}
+	mp.Size = size
+	return mp
+}
+
+// dirSize sums the sizes of the regular files under root that a walk limited by b visits.
+func dirSize(fsys filesystem.Handler, root string, b filesystem.Boundary) (int64, error) {
+	rootInfo, err := fsys.Stat(root)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	err = filesystem.WalkDir(fsys, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path != root {
+			if fi, err := d.Info(); err == nil && b.Stops(rootInfo, path, fi) {
+				return filepath.SkipDir
+			}
+		}
+		if d.Type().IsRegular() {
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += fi.Size()
+		}
+		return nil
+	})
+	return total, err
+}
@@ -0,0 +1,26 @@
+package backup
+
+import (
+	"encoding/json"
+	"path/filepath"
+)
+
+// checkpointDirName holds `docker checkpoint create --checkpoint-dir` output inside a backup.
+const checkpointDirName = "checkpoint"
+
+// readCheckpointName returns the checkpoint recorded in metadata.json when the checkpoint
+// data is present in the extracted backup.
+func (e *DefaultBackupEngine) readCheckpointName(dir string) string {
+	b, err := e.filesystem.ReadFile(filepath.Join(dir, "metadata.json"))
+	if err != nil {
+		return ""
+	}
+	var meta backupMetadata
+	if err := json.Unmarshal(b, &meta); err != nil || meta.Checkpoint == "" {
+		return ""
+	}
+	if _, err := e.filesystem.Stat(filepath.Join(dir, checkpointDirName, meta.Checkpoint)); err != nil {
+		return ""
+	}
+	return meta.Checkpoint
+}
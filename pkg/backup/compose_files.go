@@ -0,0 +1,97 @@
+package backup
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+// copyComposeEnvFiles copies .env and env_file entries into the compose-files directory
+// under their project-relative paths. Files outside the project directory cannot be restored
+// next to the compose file and are skipped with a warning.
+func (e *DefaultBackupEngine) copyComposeEnvFiles(projectPath, composeDir string, paths []string) {
+	seen := map[string]bool{}
+	for _, p := range paths {
+		rel := filepath.Clean(p)
+		if seen[rel] {
+			continue
+		}
+		seen[rel] = true
+		if filepath.IsAbs(rel) || !filepath.IsLocal(rel) {
+			e.warn(WarnComposeFile, p, "env_file %s is outside the project directory; not included in backup", p)
+			continue
+		}
+		b, err := e.filesystem.ReadFile(filepath.Join(projectPath, rel))
+		if err != nil {
+			if rel != ".env" {
+				e.warn(WarnComposeFile, p, "env_file %s not readable, skipping: %v", p, err)
+			}
+			continue
+		}
+		dest := filepath.Join(composeDir, rel)
+		if err := e.filesystem.EnsureDir(filepath.Dir(dest), 0o755); err != nil {
+			continue
+		}
+		_ = e.filesystem.WriteFile(dest, b, 0o600)
+	}
+}
+
+// copyBuildContext copies a local build context into the compose-files directory under its
+// project-relative path, honoring the context's .dockerignore. The Dockerfile and
+// .dockerignore are always kept, as docker build does.
+func (e *DefaultBackupEngine) copyBuildContext(projectPath, composeDir, context string) error {
+	rel := filepath.Clean(context)
+	if filepath.IsAbs(rel) || !filepath.IsLocal(rel) {
+		return fmt.Errorf("build context %s is outside the project directory", context)
+	}
+	srcRoot := filepath.Join(projectPath, rel)
+	destRoot := filepath.Join(composeDir, rel)
+	var ignore *filesystem.IgnoreMatcher
+	if b, err := e.filesystem.ReadFile(filepath.Join(srcRoot, ".dockerignore")); err == nil {
+		ignore = filesystem.ParseIgnoreFile(b)
+	}
+	e.log.Infof("Archiving build context %s", srcRoot)
+	return filesystem.WalkDir(e.filesystem, srcRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		r, err := filepath.Rel(srcRoot, path)
+		if err != nil || r == "." {
+			return err
+		}
+		if r != "Dockerfile" && r != ".dockerignore" && ignore.Matches(r) {
+			if d.IsDir() && !ignore.HasExceptions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		dest := filepath.Join(destRoot, r)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return e.filesystem.EnsureDir(dest, 0o755)
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := e.filesystem.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := e.filesystem.EnsureDir(filepath.Dir(dest), 0o755); err != nil {
+				return err
+			}
+			_ = e.filesystem.RemoveAll(dest)
+			return e.filesystem.Symlink(target, dest)
+		case info.Mode().IsRegular():
+			if err := e.filesystem.EnsureDir(filepath.Dir(dest), 0o755); err != nil {
+				return err
+			}
+			return e.filesystem.CopyFile(path, dest, info.Mode().Perm())
+		}
+		return nil
+	})
+}
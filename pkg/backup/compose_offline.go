@@ -0,0 +1,126 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/compose"
+	"github.com/brian033/dockerbackup/pkg/docker"
+)
+
+// backupComposeOffline captures a project without containers (--offline) from its compose
+// files: the volumes and networks they declare, named as compose names them (variables
+// substituted), that exist on this host. Volume data is archived through the daemon into
+// volumes/ and recorded in a mounts.json at the top of the backup, from which restore-compose
+// fills the volumes.
+func (e *DefaultBackupEngine) backupComposeOffline(ctx context.Context, files [][]byte, projectName, workDir string, opts BackupOptions, rep *reporter) ([]docker.NetworkConfig, []docker.VolumeConfig, error) {
+	var vols, nets []compose.Resource
+	seenVols, seenNets := map[string]bool{}, map[string]bool{}
+	// declared names may use variables, from the project's .env (copied already) or the shell
+	dotEnv, _ := e.filesystem.ReadFile(filepath.Join(workDir, "compose-files", ".env"))
+	env := compose.Environ(dotEnv)
+	for _, data := range files {
+		for _, r := range compose.Volumes(data, projectName, env) {
+			if !seenVols[r.Name] {
+				seenVols[r.Name] = true
+				vols = append(vols, r)
+			}
+		}
+		for _, r := range compose.Networks(data, projectName, env) {
+			if !seenNets[r.Name] {
+				seenNets[r.Name] = true
+				nets = append(nets, r)
+			}
+		}
+	}
+	if len(vols) == 0 && len(nets) == 0 {
+		return nil, nil, &errors.OperationError{Op: "discover project resources", Err: fmt.Errorf("no containers found for project %s and its compose files declare no volumes or networks", projectName)}
+	}
+	e.log.Infof("No containers found for project %s; backing up the volumes and networks its compose files declare", projectName)
+
+	var netCfgs []docker.NetworkConfig
+	for _, r := range nets {
+		n, err := e.dockerClient.InspectNetwork(ctx, r.Name)
+		if err != nil || n == nil {
+			e.warn(WarnComposeFile, r.Name, "Network %s (%s) does not exist; not included in backup", r.Name, r.Key)
+			continue
+		}
+		netCfgs = append(netCfgs, *n)
+	}
+
+	var volCfgs []docker.VolumeConfig
+	var mounts mountMap
+	for _, r := range vols {
+		v, err := e.dockerClient.InspectVolume(ctx, r.Name)
+		if err != nil || v == nil {
+			e.warn(WarnComposeFile, r.Name, "Volume %s (%s) does not exist; not included in backup", r.Name, r.Key)
+			continue
+		}
+		volCfgs = append(volCfgs, *v)
+		m := docker.Mount{Type: "volume", Name: r.Name, Driver: v.Driver}
+		a := newMountArtifact(m)
+		if !mountSelected(m, opts) {
+			e.warn(WarnMountExcluded, r.Name, "Skipping data of volume %s (excluded)", r.Name)
+			a.Skipped = "excluded"
+			mounts = append(mounts, a)
+			continue
+		}
+		if e.skipRemoteVolume(ctx, m, opts) {
+			a.Skipped = "remote"
+			mounts = append(mounts, a)
+			continue
+		}
+		volTarGz := filepath.Join(workDir, "volumes", safeName(r.Name)+".tar.gz")
+		a.Artifact, a.Root = "volumes/"+filepath.Base(volTarGz), r.Name
+		mounts = append(mounts, a)
+		if err := e.dockerClient.ArchiveVolume(ctx, r.Name, volTarGz); err != nil {
+			return nil, nil, &errors.OperationError{Op: fmt.Sprintf("archive volume %s", r.Name), Err: err}
+		}
+		c := mountComponent(e.filesystem, "volume "+r.Name, volTarGz, nil, "")
+		c.Note = "archived through the daemon"
+		rep.component(c)
+	}
+	e.recordContent(ctx, workDir, mounts)
+	if err := e.writeMountMap(workDir, mounts); err != nil {
+		return nil, nil, &errors.OperationError{Op: "write " + mountsFileName, Err: err}
+	}
+	rep.stage("archive volumes")
+	return netCfgs, volCfgs, nil
+}
+
+// restoreProjectVolumeData fills the volumes of a compose backup taken with --offline from
+// the archives recorded in its top-level mounts.json. Volumes follow the project rename and
+// the volume conflict policy; backups with services carry their data per service instead.
+func (e *DefaultBackupEngine) restoreProjectVolumeData(ctx context.Context, tmpDir string, renamer *projectRenamer, opts RestoreOptions) error {
+	if _, err := e.filesystem.Stat(filepath.Join(tmpDir, mountsFileName)); err != nil {
+		return nil
+	}
+	var mounts []docker.Mount
+	var names []string
+	for _, a := range e.readMountMap(tmpDir) {
+		if a.Type == "volume" && a.Name != "" {
+			mounts = append(mounts, docker.Mount{Type: "volume", Name: a.Name})
+			names = append(names, a.Name)
+		}
+	}
+	volumeMap := renamer.mapNames(names, nil)
+	keep := map[string]bool{}
+	for _, v := range names {
+		target := v
+		if mapped, ok := volumeMap[v]; ok && mapped != "" {
+			target = mapped
+		}
+		use, skip, err := e.volumeConflict(ctx, opts, target)
+		if err != nil {
+			return err
+		}
+		if use != target {
+			volumeMap[v] = use
+		}
+		keep[use] = skip
+	}
+	e.log.Infof("Restoring project volume data")
+	return e.restoreMountData(ctx, tmpDir, mounts, volumeMap, keep)
+}
@@ -0,0 +1,399 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/compose"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+// restoreComposeUp hands a restored project back to docker compose: the compose files are
+// written to a target directory, volume and bind data is restored from each service backup
+// and `docker compose up -d` creates the containers so compose manages them afterwards.
+// Networks and volumes are expected to have been ensured from their saved configs already.
+func (e *DefaultBackupEngine) restoreComposeUp(ctx context.Context, tmpDir string, request RestoreRequest, renamer *projectRenamer) (*RestoreResult, error) {
+	projectName := request.ProjectName
+	if projectName == "" {
+		projectName = readComposeProjectName(e.filesystem, tmpDir)
+	}
+	if projectName == "" {
+		return nil, &errors.ValidationError{Field: "ProjectName", Msg: "required for compose up (not found in backup metadata)"}
+	}
+	targetDir := request.Options.ComposeDir
+	if targetDir == "" {
+		cwd, _ := os.Getwd()
+		targetDir = filepath.Join(cwd, safeName(projectName))
+	}
+
+	// Write compose files and .env
+	srcDir := filepath.Join(tmpDir, "compose-files")
+	entries, err := e.filesystem.ReadDir(srcDir)
+	if err != nil || len(entries) == 0 {
+		return nil, &errors.OperationError{Op: "read compose files", Err: fmt.Errorf("backup contains no compose files")}
+	}
+	if err := e.filesystem.EnsureDir(targetDir, 0o755); err != nil {
+		return nil, &errors.OperationError{Op: "create compose dir", Err: err}
+	}
+	err = filesystem.WalkDir(e.filesystem, srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(targetDir, rel)
+		if _, err := e.filesystem.Stat(dest); err == nil {
+			if !request.Options.ReplaceExisting {
+				return fmt.Errorf("%s already exists (use --replace to overwrite)", dest)
+			}
+			if err := request.Options.Confirm.Confirm(dest + " exists; it will be overwritten"); err != nil {
+				return err
+			}
+		}
+		if err := e.filesystem.EnsureDir(filepath.Dir(dest), 0o755); err != nil {
+			return err
+		}
+		// env files were archived 0600; keep the archived mode
+		perm := os.FileMode(0o644)
+		if fi, err := d.Info(); err == nil {
+			perm = fi.Mode().Perm()
+		}
+		if err := e.filesystem.CopyFile(path, dest, perm); err != nil {
+			return fmt.Errorf("write %s: %w", rel, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, &errors.OperationError{Op: "write compose files", Err: err}
+	}
+	if err := e.pinComposeNames(tmpDir, targetDir, projectName, renamer); err != nil {
+		return nil, &errors.OperationError{Op: "write compose files", Err: err}
+	}
+
+	// Restore data (and images, so compose does not need to pull or build) per service
+	svcDirs, _ := e.filesystem.ReadDir(filepath.Join(tmpDir, "containers"))
+	for _, sd := range svcDirs {
+		if !sd.IsDir() {
+			continue
+		}
+		// the other replicas of a scaled service hold no data of their own beyond what compose
+		// recreates
+		if tarPath := serviceArchive(e.filesystem, tmpDir, sd.Name()); tarPath != "" {
+			e.log.Infof("Restoring data for service %s", sd.Name())
+			if err := e.restoreServiceData(ctx, tarPath, renamer, request.Options); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// compose creates the replicas of scaled services again; they share the first one's volumes
+	scale := readComposeScale(e.filesystem, tmpDir)
+	e.log.Infof("Running docker compose up for project %s in %s", projectName, targetDir)
+	if err := e.dockerClient.ComposeUp(ctx, targetDir, projectName, scale); err != nil {
+		return nil, &errors.OperationError{Op: "docker compose up", Err: err}
+	}
+	return &RestoreResult{RestoredID: projectName}, nil
+}
+
+// Labels docker compose puts on the volumes and networks it creates, naming their key in the
+// compose file.
+const (
+	composeVolumeLabel  = "com.docker.compose.volume"
+	composeNetworkLabel = "com.docker.compose.network"
+)
+
+// pinComposeNames reconciles the volumes and networks the compose files written to targetDir
+// declare with the restored ones. Each declared resource is matched to the one it named at
+// backup time, by the compose labels of the recorded configs or else by resolving its name
+// with the backed-up .env, and follows the project rename like the restored resources do.
+// Where compose would now resolve the declared name differently (the project was renamed, or
+// the variables in a name: like ${APP}_data differ on this host) the restored name is written
+// into the file, so compose up uses the restored resources instead of creating empty ones.
+func (e *DefaultBackupEngine) pinComposeNames(tmpDir, targetDir, projectName string, renamer *projectRenamer) error {
+	original := readComposeProjectName(e.filesystem, tmpDir)
+	if original == "" {
+		original = projectName
+	}
+	backupEnvFile, _ := e.filesystem.ReadFile(filepath.Join(tmpDir, "compose-files", ".env"))
+	backupEnv := compose.ParseEnvFile(backupEnvFile)
+	targetEnvFile, _ := e.filesystem.ReadFile(filepath.Join(targetDir, ".env"))
+	targetEnv := compose.Environ(targetEnvFile)
+
+	volumeKeys, networkKeys := map[string]string{}, map[string]string{}
+	var volCfgs []docker.VolumeConfig
+	if b, err := e.filesystem.ReadFile(filepath.Join(tmpDir, "volumes", "volume_configs.json")); err == nil {
+		_ = json.Unmarshal(b, &volCfgs)
+	}
+	for _, vc := range volCfgs {
+		if key := vc.Labels[composeVolumeLabel]; key != "" && vc.Labels[composeProjectLabel] == original {
+			volumeKeys[key] = vc.Name
+		}
+	}
+	var netCfgs []docker.NetworkConfig
+	if b, err := e.filesystem.ReadFile(filepath.Join(tmpDir, "networks", "network_configs.json")); err == nil {
+		_ = json.Unmarshal(b, &netCfgs)
+	}
+	for _, nc := range netCfgs {
+		if key := nc.Labels[composeNetworkLabel]; key != "" && nc.Labels[composeProjectLabel] == original {
+			networkKeys[key] = nc.Name
+		}
+	}
+
+	// pins returns the restored name of each declared resource compose would now name otherwise
+	pins := func(kind string, then, now []compose.Resource, recorded map[string]string) map[string]string {
+		current := map[string]string{}
+		for _, r := range now {
+			current[r.Key] = r.Name
+		}
+		out := map[string]string{}
+		for _, r := range then {
+			name := r.Name
+			if n, ok := recorded[r.Key]; ok {
+				name = n
+			}
+			restored := renamer.name(name)
+			if current[r.Key] != restored {
+				e.log.Infof("Setting the name of %s %s to %s in the compose files (it resolves to %s here)", kind, r.Key, restored, current[r.Key])
+				out[r.Key] = restored
+			}
+		}
+		return out
+	}
+	for _, name := range []string{"docker-compose.yml", "docker-compose.yaml", "docker-compose.override.yml"} {
+		path := filepath.Join(targetDir, name)
+		data, err := e.filesystem.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		vols := pins("volume", compose.Volumes(data, original, backupEnv), compose.Volumes(data, projectName, targetEnv), volumeKeys)
+		nets := pins("network", compose.Networks(data, original, backupEnv), compose.Networks(data, projectName, targetEnv), networkKeys)
+		if len(vols) == 0 && len(nets) == 0 {
+			continue
+		}
+		out, changed, err := compose.PinNames(data, vols, nets)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if !changed {
+			continue
+		}
+		perm := os.FileMode(0o644)
+		if fi, err := e.filesystem.Stat(path); err == nil {
+			perm = fi.Mode().Perm()
+		}
+		if err := e.filesystem.WriteFile(path, out, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreServiceData restores the image and mount data of a single service backup without
+// creating its container. Volumes follow the project rename, if any; the compose files name
+// them, so volumes that hold data are overwritten or kept but not renamed.
+func (e *DefaultBackupEngine) restoreServiceData(ctx context.Context, tarPath string, renamer *projectRenamer, opts RestoreOptions) error {
+	dir, err := e.filesystem.MkdirTemp(WorkDir(), "dockerbackup_service_*")
+	if err != nil {
+		return &errors.OperationError{Op: "create temp dir", Err: err}
+	}
+	defer func() { _ = e.filesystem.RemoveAll(dir) }()
+	if err := e.archiveHandler.ExtractArchive(ctx, tarPath, dir); err != nil {
+		return &errors.OperationError{Op: "extract service backup", Err: err}
+	}
+	b, err := e.filesystem.ReadFile(filepath.Join(dir, "container.json"))
+	if err != nil {
+		return &errors.OperationError{Op: "read container.json", Err: err}
+	}
+	cj, err := decodeContainerJSON(b)
+	if err != nil {
+		return &errors.OperationError{Op: "unmarshal container.json", Err: err}
+	}
+	present := cj.ContainerJSONBase != nil && (opts.projectImages[cj.Image] || e.imagePresent(ctx, cj.Image))
+	loaded := false
+	if !present {
+		loaded, err = e.loadSavedImage(ctx, dir)
+	}
+	if err != nil {
+		e.warn(WarnImageLoad, "", "Image load failed; compose will pull or build instead: %v", err)
+	} else if cj.ContainerJSONBase != nil && cj.Image != "" && (loaded || present) {
+		// compose finds the image by the tags docker save dropped
+		configImage := ""
+		if cj.Config != nil {
+			configImage = cj.Config.Image
+		}
+		e.tagRestoredImage(ctx, cj.Image, e.readImageRefs(dir), configImage)
+	}
+	mounts := make([]docker.Mount, 0, len(cj.Mounts))
+	volNames := []string{}
+	for _, m := range cj.Mounts {
+		mounts = append(mounts, docker.Mount{Name: m.Name, Source: m.Source, Destination: m.Destination, Type: string(m.Type), RW: m.RW})
+		if m.Type == "volume" && m.Name != "" {
+			volNames = append(volNames, m.Name)
+		}
+	}
+	volumeMap := renamer.mapNames(volNames, nil)
+	keep := map[string]bool{}
+	for _, v := range volNames {
+		target := v
+		if mapped, ok := volumeMap[v]; ok && mapped != "" {
+			target = mapped
+		}
+		use, skip, err := e.volumeConflict(ctx, opts, target)
+		if err != nil {
+			return err
+		}
+		if use != target {
+			return &errors.ValidationError{Field: "OnConflict", Msg: fmt.Sprintf("volume %s cannot be renamed with --compose-up, the compose files name it", target)}
+		}
+		keep[target] = skip
+	}
+	return e.restoreMountData(ctx, dir, mounts, volumeMap, keep)
+}
+
+func readComposeProjectName(fsys filesystem.Handler, dir string) string {
+	b, err := fsys.ReadFile(filepath.Join(dir, "metadata.json"))
+	if err != nil {
+		return ""
+	}
+	var meta struct {
+		ProjectName string `json:"projectName"`
+	}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return ""
+	}
+	return meta.ProjectName
+}
+
+// readComposeDependsOn returns the depends_on conditions recorded from container labels.
+func readComposeDependsOn(fsys filesystem.Handler, dir string) map[string]map[string]string {
+	b, err := fsys.ReadFile(filepath.Join(dir, "metadata.json"))
+	if err != nil {
+		return nil
+	}
+	var meta struct {
+		DependsOn map[string]map[string]string `json:"dependsOn"`
+	}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return nil
+	}
+	return meta.DependsOn
+}
+
+// composeServiceOrder returns the services of an extracted compose backup in start order
+// (from the compose files, else from depends_on captured in metadata, else by name) and
+// their depends_on conditions.
+func composeServiceOrder(fsys filesystem.Handler, tmpDir string) ([]string, map[string]map[string]string) {
+	services := map[string]struct{}{}
+	order := []string{}
+	composePathYml := filepath.Join(tmpDir, "compose-files", "docker-compose.yml")
+	composePathYaml := filepath.Join(tmpDir, "compose-files", "docker-compose.yaml")
+	var data []byte
+	if b, err := fsys.ReadFile(composePathYml); err == nil {
+		data = b
+	} else if b, err := fsys.ReadFile(composePathYaml); err == nil {
+		data = b
+	}
+	var deps map[string]map[string]string
+	if len(data) > 0 {
+		deps = compose.DependencyConditions(data)
+		ord, names := compose.OrderFromComposeYAML(data)
+		if len(ord) > 0 {
+			order = ord
+		}
+		for _, n := range names {
+			services[n] = struct{}{}
+		}
+	}
+	// Fallback: discover services by directory structure
+	if len(services) == 0 {
+		entries, _ := fsys.ReadDir(filepath.Join(tmpDir, "containers"))
+		for _, e2 := range entries {
+			if e2.IsDir() {
+				services[e2.Name()] = struct{}{}
+			}
+		}
+	}
+	// Without compose files, fall back to depends_on captured from container labels
+	if len(data) == 0 {
+		deps = readComposeDependsOn(fsys, tmpDir)
+		if len(deps) > 0 {
+			names := make([]string, 0, len(services))
+			for s := range services {
+				names = append(names, s)
+			}
+			order = compose.OrderFromDependencies(names, deps)
+		}
+	}
+	if len(order) == 0 {
+		for s := range services {
+			order = append(order, s)
+		}
+		sort.Strings(order)
+	}
+	return order, deps
+}
+
+// serviceArchive returns the container backup of svc inside an extracted compose backup: that
+// of its first replica when the service was scaled.
+func serviceArchive(fsys filesystem.Handler, tmpDir, svc string) string {
+	dir := filepath.Join(tmpDir, "containers", safeName(svc))
+	if _, err := fsys.Stat(filepath.Join(dir, serviceArchiveName)); err == nil {
+		return filepath.Join(dir, serviceArchiveName)
+	}
+	entries, _ := fsys.ReadDir(dir)
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tar.gz") && !strings.HasPrefix(e.Name(), "replica-") {
+			return filepath.Join(dir, e.Name())
+		}
+	}
+	return ""
+}
+
+// replicaArchives returns the backups of the other replicas of a scaled service svc, by
+// replica number.
+func replicaArchives(fsys filesystem.Handler, tmpDir, svc string) []string {
+	dir := filepath.Join(tmpDir, "containers", safeName(svc))
+	entries, _ := fsys.ReadDir(dir)
+	type replica struct {
+		n    int
+		path string
+	}
+	var rs []replica
+	for _, e := range entries {
+		var n int
+		if _, err := fmt.Sscanf(e.Name(), "replica-%d.tar.gz", &n); err == nil && e.Name() == replicaArchiveName(n) {
+			rs = append(rs, replica{n, filepath.Join(dir, e.Name())})
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].n < rs[j].n })
+	out := make([]string, 0, len(rs))
+	for _, r := range rs {
+		out = append(out, r.path)
+	}
+	return out
+}
+
+// readComposeScale returns the replica count of each scaled service recorded in metadata.json.
+func readComposeScale(fsys filesystem.Handler, dir string) map[string]int {
+	b, err := fsys.ReadFile(filepath.Join(dir, "metadata.json"))
+	if err != nil {
+		return nil
+	}
+	var meta struct {
+		Scale map[string]int `json:"scale"`
+	}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return nil
+	}
+	return meta.Scale
+}
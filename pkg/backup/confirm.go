@@ -0,0 +1,55 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+)
+
+// NoPromptEnv, when set to 1, disables every prompt (--no-prompt): steps that need an answer
+// fail unless confirmed up front.
+const NoPromptEnv = "DOCKERBACKUP_NO_PROMPT"
+
+// PromptsDisabled reports whether --no-prompt is in effect.
+func PromptsDisabled() bool {
+	return os.Getenv(NoPromptEnv) == "1"
+}
+
+// Confirmation says whether steps that remove or overwrite something go ahead.
+type Confirmation string
+
+const (
+	// ConfirmNone goes ahead without asking: the zero value, for callers that are themselves
+	// the confirmation, such as an API request
+	ConfirmNone Confirmation = ""
+	// ConfirmAsk asks on the terminal before each step, or fails when it cannot ask
+	ConfirmAsk Confirmation = "ask"
+	// ConfirmYes goes ahead: confirmed up front with --yes or --force
+	ConfirmYes Confirmation = "yes"
+)
+
+// Confirm asks whether to go ahead with action, a description of what would be removed or
+// overwritten, and returns a ValidationError unless the answer is yes.
+func (c Confirmation) Confirm(action string) error {
+	if c != ConfirmAsk {
+		return nil
+	}
+	if PromptsDisabled() {
+		return &errors.ValidationError{Field: "Confirm", Msg: fmt.Sprintf("%s: not confirmed (prompts are disabled by --no-prompt; confirm with --yes)", action)}
+	}
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return &errors.ValidationError{Field: "Confirm", Msg: fmt.Sprintf("%s: cannot ask to confirm, stdin is not a terminal (confirm with --yes)", action)}
+	}
+	fmt.Fprintf(os.Stderr, "%s. Continue? [y/N] ", action)
+	line, err := readLine()
+	if err != nil {
+		return &errors.OperationError{Op: "read answer", Err: err}
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return nil
+	}
+	return &errors.ValidationError{Field: "Confirm", Msg: fmt.Sprintf("%s: declined", action)}
+}
@@ -0,0 +1,320 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/docker/go-connections/nat"
+)
+
+// ConflictKind is a kind of resource a restore can find already present on the target.
+type ConflictKind string
+
+const (
+	// ConflictName is a container with the target name
+	ConflictName ConflictKind = "name"
+	// ConflictPort is a published host port something already listens on
+	ConflictPort ConflictKind = "port"
+	// ConflictNetwork is a network of the same name
+	ConflictNetwork ConflictKind = "network"
+	// ConflictVolume is a volume of the same name that holds data
+	ConflictVolume ConflictKind = "volume"
+)
+
+// ConflictKinds lists the kinds in the order they are documented.
+var ConflictKinds = []ConflictKind{ConflictName, ConflictPort, ConflictNetwork, ConflictVolume}
+
+// ConflictPolicy says what a restore does about a conflict.
+type ConflictPolicy string
+
+const (
+	// ConflictFail stops the restore
+	ConflictFail ConflictPolicy = "fail"
+	// ConflictSkip keeps what exists: the container, the volume's data or the network (which
+	// is reused); a port binding is dropped
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictRename restores under the first free name-1, name-2, ... or the next free port
+	ConflictRename ConflictPolicy = "rename"
+	// ConflictReplace removes the container or network, or overwrites the volume's data;
+	// ports cannot be replaced
+	ConflictReplace ConflictPolicy = "replace"
+	// ConflictPrompt asks on the terminal
+	ConflictPrompt ConflictPolicy = "prompt"
+)
+
+// Allows reports whether the policy can be applied to conflicts of the kind.
+func (k ConflictKind) Allows(p ConflictPolicy) bool {
+	switch p {
+	case ConflictFail, ConflictSkip, ConflictRename, ConflictPrompt:
+		return true
+	case ConflictReplace:
+		return k != ConflictPort
+	}
+	return false
+}
+
+// conflictPolicy returns the policy for the kind: the one given in OnConflict, else what
+// --replace and --no-overwrite-volumes imply, else the default: fail for names, replace for
+// volume data and skip (reuse) for networks. Ports have no default and are only checked when
+// a policy is given.
+func (o RestoreOptions) conflictPolicy(kind ConflictKind) ConflictPolicy {
+	if p, ok := o.OnConflict[kind]; ok && p != "" {
+		return p
+	}
+	switch kind {
+	case ConflictName:
+		if o.ReplaceExisting {
+			return ConflictReplace
+		}
+		return ConflictFail
+	case ConflictVolume:
+		if o.NoOverwriteVolumes {
+			return ConflictSkip
+		}
+		return ConflictReplace
+	case ConflictNetwork:
+		return ConflictSkip
+	}
+	return ""
+}
+
+// conflictResolution is what was decided about a conflict: the policy applied and, for
+// rename, the new name.
+type conflictResolution struct {
+	policy ConflictPolicy
+	name   string
+}
+
+// resolveConflict decides what to do about subject, an existing resource of the kind: the
+// policy of opts, asked for when it is prompt. For rename, candidate(1), candidate(2), ...
+// are tried until one is not taken; an empty candidate means there is none. Decisions hold
+// for the rest of the restore, so compose services sharing a volume or network agree and the
+// user is asked once.
+func (e *DefaultBackupEngine) resolveConflict(opts RestoreOptions, kind ConflictKind, subject string, candidate func(int) string, taken func(string) bool) (conflictResolution, error) {
+	key := string(kind) + "/" + subject
+	if r, ok := e.conflicts[key]; ok {
+		return r, nil
+	}
+	policy := opts.conflictPolicy(kind)
+	what := conflictText(kind, subject)
+	if policy == ConflictPrompt {
+		var err error
+		if policy, err = promptConflict(kind, subject); err != nil {
+			return conflictResolution{}, err
+		}
+	} else if policy == ConflictReplace {
+		// a replace chosen at the prompt needs no second confirmation
+		action := what + "; it will be removed"
+		if kind == ConflictVolume {
+			action = what + "; its data will be overwritten"
+		}
+		if err := opts.Confirm.Confirm(action); err != nil {
+			return conflictResolution{}, err
+		}
+	}
+	r := conflictResolution{policy: policy, name: subject}
+	switch policy {
+	case ConflictFail:
+		return r, &errors.ValidationError{Field: "OnConflict", Msg: fmt.Sprintf("%s (choose another outcome with --on-conflict %s=<policy>)", what, kind)}
+	case ConflictSkip:
+		e.warn(WarnConflict, subject, "%s; keeping it", what)
+	case ConflictRename:
+		for i := 1; r.name == subject; i++ {
+			c := candidate(i)
+			if c == "" {
+				return r, &errors.ValidationError{Field: "OnConflict", Msg: fmt.Sprintf("%s and no free alternative was found", what)}
+			}
+			if !taken(c) {
+				r.name = c
+			}
+		}
+		e.warn(WarnConflict, subject, "%s; using %s instead", what, r.name)
+	case ConflictReplace:
+		e.warn(WarnConflict, subject, "%s; replacing it", what)
+	}
+	if e.conflicts != nil {
+		e.conflicts[key] = r
+	}
+	return r, nil
+}
+
+func conflictText(kind ConflictKind, subject string) string {
+	if kind == ConflictPort {
+		return fmt.Sprintf("Port %s is already in use", subject)
+	}
+	return fmt.Sprintf("%s %s already exists", map[ConflictKind]string{ConflictName: "Container", ConflictNetwork: "Network", ConflictVolume: "Volume"}[kind], subject)
+}
+
+// suffixed returns the rename candidates name-1, name-2, ...
+func suffixed(name string) func(int) string {
+	return func(i int) string { return fmt.Sprintf("%s-%d", name, i) }
+}
+
+// promptConflict asks on the terminal what to do about a conflict.
+func promptConflict(kind ConflictKind, subject string) (ConflictPolicy, error) {
+	if PromptsDisabled() {
+		return "", &errors.ValidationError{Field: "OnConflict", Msg: fmt.Sprintf("cannot ask about %s: prompts are disabled by --no-prompt", subject)}
+	}
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return "", &errors.ValidationError{Field: "OnConflict", Msg: fmt.Sprintf("cannot ask about %s: stdin is not a terminal", subject)}
+	}
+	choices := map[string]ConflictPolicy{"f": ConflictFail, "s": ConflictSkip, "r": ConflictRename}
+	menu := "[f]ail, [s]kip, [r]ename"
+	if kind.Allows(ConflictReplace) {
+		choices["p"] = ConflictReplace
+		menu += ", re[p]lace"
+	}
+	for {
+		fmt.Fprintf(os.Stderr, "%s. %s? ", conflictText(kind, subject), menu)
+		line, err := readLine()
+		if err != nil {
+			return "", &errors.OperationError{Op: "read answer", Err: err}
+		}
+		if p, ok := choices[strings.ToLower(strings.TrimSpace(line))]; ok {
+			return p, nil
+		}
+	}
+}
+
+// readLine reads a line from stdin a byte at a time, so nothing after it is consumed.
+func readLine() (string, error) {
+	var sb strings.Builder
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				return sb.String(), nil
+			}
+			sb.WriteByte(b[0])
+		}
+		if err != nil {
+			return sb.String(), err
+		}
+	}
+}
+
+// containerConflict applies the name policy when a container named name exists. It returns
+// the name to create the container under, whether the existing one is to be removed first,
+// and the ID of the existing container when it is to be kept instead (skip).
+func (e *DefaultBackupEngine) containerConflict(ctx context.Context, opts RestoreOptions, name string) (string, bool, string, error) {
+	existing := e.containerID(ctx, name)
+	if existing == "" {
+		return name, false, "", nil
+	}
+	r, err := e.resolveConflict(opts, ConflictName, name, suffixed(name), func(n string) bool { return e.containerID(ctx, n) != "" })
+	if err != nil {
+		return "", false, "", err
+	}
+	switch r.policy {
+	case ConflictSkip:
+		return name, false, existing, nil
+	case ConflictReplace:
+		return name, true, "", nil
+	}
+	return r.name, false, "", nil
+}
+
+// containerID returns the ID of the container of that name, or "".
+func (e *DefaultBackupEngine) containerID(ctx context.Context, name string) string {
+	out, err := e.dockerClient.InspectContainer(ctx, name)
+	if err != nil {
+		return ""
+	}
+	cj, err := decodeContainerJSON(out)
+	if err != nil || cj.ContainerJSONBase == nil {
+		return ""
+	}
+	return cj.ID
+}
+
+// volumeConflict applies the volume policy when target exists, holds data and was not
+// created by this restore. It returns the volume to restore into and whether its data is to
+// be left untouched.
+func (e *DefaultBackupEngine) volumeConflict(ctx context.Context, opts RestoreOptions, target string) (string, bool, error) {
+	if opts.conflictPolicy(ConflictVolume) == ConflictReplace || e.journal.createdVolume(target) || !e.volumeExists(ctx, target) {
+		return target, false, nil
+	}
+	hasData, err := e.dockerClient.VolumeHasData(ctx, target)
+	if err != nil {
+		return "", false, &errors.OperationError{Op: fmt.Sprintf("check volume %s", target), Err: err}
+	}
+	if !hasData {
+		return target, false, nil
+	}
+	r, err := e.resolveConflict(opts, ConflictVolume, target, suffixed(target), func(n string) bool { return e.volumeExists(ctx, n) })
+	if err != nil {
+		return "", false, err
+	}
+	return r.name, r.policy == ConflictSkip, nil
+}
+
+// networkConflict applies the network policy when name exists and was not created by this
+// restore, removing it for replace. It returns the network to use.
+func (e *DefaultBackupEngine) networkConflict(ctx context.Context, opts RestoreOptions, name string) (string, error) {
+	exists := func(n string) bool {
+		nc, err := e.dockerClient.InspectNetwork(ctx, n)
+		return err == nil && nc != nil
+	}
+	if opts.conflictPolicy(ConflictNetwork) == ConflictSkip || e.journal.createdNetwork(name) || !exists(name) {
+		return name, nil
+	}
+	if r, ok := e.conflicts[string(ConflictNetwork)+"/"+name]; ok {
+		// decided (and replaced) already for another service of the project
+		return r.name, nil
+	}
+	r, err := e.resolveConflict(opts, ConflictNetwork, name, suffixed(name), exists)
+	if err != nil {
+		return "", err
+	}
+	if r.policy == ConflictReplace {
+		if err := e.dockerClient.RemoveNetwork(ctx, name); err != nil {
+			return "", &errors.OperationError{Op: fmt.Sprintf("replace network %s", name), Err: err}
+		}
+	}
+	return r.name, nil
+}
+
+// portConflicts applies the port policy to bindings whose host port is in use, dropping
+// (skip) or moving (rename) them. Without a port policy nothing is checked.
+func (e *DefaultBackupEngine) portConflicts(opts RestoreOptions, bindings nat.PortMap) error {
+	if opts.conflictPolicy(ConflictPort) == "" {
+		return nil
+	}
+	for port, bs := range bindings {
+		kept := bs[:0]
+		for _, b := range bs {
+			p, err := strconv.Atoi(b.HostPort)
+			addr := net.JoinHostPort(b.HostIP, b.HostPort)
+			if err != nil || portFree(port.Proto(), addr) {
+				kept = append(kept, b)
+				continue
+			}
+			taken := func(c string) bool { return !portFree(port.Proto(), net.JoinHostPort(b.HostIP, c)) }
+			next := func(i int) string {
+				if p+i > 65535 {
+					return ""
+				}
+				return strconv.Itoa(p + i)
+			}
+			r, err := e.resolveConflict(opts, ConflictPort, port.Proto()+" "+addr, next, taken)
+			if err != nil {
+				return err
+			}
+			switch r.policy {
+			case ConflictSkip:
+				continue
+			case ConflictRename:
+				b.HostPort = r.name
+			}
+			kept = append(kept, b)
+		}
+		bindings[port] = kept
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package backup
+
+import (
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/docker/docker/api/types"
+)
+
+type refreshTarget struct {
+	name    string
+	id      string
+	running bool
+}
+
+// restoreDataRefresh replaces the contents of the named volumes of existing containers with
+// the data in the backup, leaving the containers themselves alone. Containers are stopped
+// while their volumes are rewritten and started again if they were running. For compose
+// backups every service is refreshed; containers are stopped before any volume is touched.
+func (e *DefaultBackupEngine) restoreDataRefresh(ctx context.Context, request RestoreRequest) (*RestoreResult, error) {
+	tmpDir, err := e.filesystem.MkdirTemp(WorkDir(), "dockerbackup_refresh_*")
+	if err != nil {
+		return nil, &errors.OperationError{Op: "create temp dir", Err: err}
+	}
+	defer func() { _ = e.filesystem.RemoveAll(tmpDir) }()
+
+	th := e.archiveReader()
+	var targets []refreshTarget
+	// refreshed volume -> archive holding its data and the root directory inside it
+	volTars := map[string]string{}
+	volRoots := map[string]string{}
+	volArtifacts := map[string]mountArtifact{}
+	var volOrder []string
+	// volumes a service archive holds no data for; another may (multi-container backups keep
+	// the data of a shared volume once)
+	var noData [][2]string
+	err = forEachContainerArchive(ctx, e.filesystem, th, request.BackupPath, func(service, archivePath string) error {
+		b, err := th.ReadEntry(ctx, archivePath, "container.json")
+		if err != nil {
+			return fmt.Errorf("read container.json: %w", err)
+		}
+		cj, err := decodeContainerJSON(b)
+		if err != nil {
+			return fmt.Errorf("parse container.json: %w", err)
+		}
+		renamer := newProjectRenamer(composeProjectOf(cj), request.ProjectName)
+		name := renamer.name(strings.TrimPrefix(cj.Name, "/"))
+		if service == "" && request.Options.ContainerName != "" {
+			name = request.Options.ContainerName
+		}
+		var cur types.ContainerJSON
+		out, err := e.dockerClient.InspectContainer(ctx, name)
+		if err == nil {
+			cur, err = decodeContainerJSON(out)
+		}
+		if err != nil {
+			return &errors.ValidationError{Field: "ContainerName", Msg: fmt.Sprintf("container %s does not exist; --data-refresh updates an existing deployment, restore without it first", name)}
+		}
+		status, _, _ := e.dockerClient.ContainerState(ctx, cur.ID)
+		targets = append(targets, refreshTarget{name: name, id: cur.ID, running: status == "running"})
+		for _, m := range cj.Mounts {
+			if m.Type != "volume" || m.Name == "" {
+				continue
+			}
+			target := renamer.name(m.Name)
+			if mapped, ok := request.Options.VolumeMap[m.Name]; ok && mapped != "" {
+				target = mapped
+			}
+			if _, ok := volTars[target]; ok {
+				continue
+			}
+			dir := filepath.Join(tmpDir, fmt.Sprintf("%d", len(volOrder)))
+			if err := e.filesystem.EnsureDir(dir, 0o755); err != nil {
+				return err
+			}
+			volTar, recorded, err := findVolumeArchive(ctx, e.filesystem, th, archivePath, m.Name, dir)
+			var ve *errors.ValidationError
+			if stdErrors.As(err, &ve) {
+				noData = append(noData, [2]string{target, m.Name})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			volTars[target], volRoots[target], volArtifacts[target] = volTar, m.Name, recorded
+			volOrder = append(volOrder, target)
+		}
+		return nil
+	})
+	if err != nil {
+		var ve *errors.ValidationError
+		if stdErrors.As(err, &ve) {
+			return nil, err
+		}
+		return nil, &errors.OperationError{Op: "read backup", Err: err}
+	}
+	for _, v := range noData {
+		if _, ok := volTars[v[0]]; !ok {
+			e.warn(WarnVolumeData, v[1], "Backup holds no data for volume %s; leaving it as it is", v[1])
+		}
+	}
+	if len(volOrder) == 0 {
+		return nil, &errors.ValidationError{Field: "BackupPath", Msg: "holds no volume data to refresh"}
+	}
+
+	stopped := make([]string, 0, len(targets))
+	for _, t := range targets {
+		stopped = append(stopped, t.name)
+	}
+	if err := request.Options.Confirm.Confirm(fmt.Sprintf("The contents of volumes %s will be replaced, stopping %s meanwhile", strings.Join(volOrder, ", "), strings.Join(stopped, ", "))); err != nil {
+		return nil, err
+	}
+	for _, t := range targets {
+		if t.running {
+			e.log.Infof("Stopping %s", t.name)
+			if err := e.dockerClient.StopContainer(ctx, t.id); err != nil {
+				return nil, &errors.OperationError{Op: fmt.Sprintf("stop %s", t.name), Err: err}
+			}
+		}
+	}
+	for _, vol := range volOrder {
+		e.log.Infof("Replacing the contents of volume %s", vol)
+		if err := e.dockerClient.ClearVolume(ctx, vol); err != nil {
+			return nil, &errors.OperationError{Op: fmt.Sprintf("clear volume %s (containers left stopped)", vol), Err: err}
+		}
+		if err := e.restoreVolumeData(ctx, vol, volTars[vol], volRoots[vol], volArtifacts[vol]); err != nil {
+			return nil, &errors.OperationError{Op: "containers left stopped", Err: err}
+		}
+	}
+	names := make([]string, 0, len(targets))
+	for _, t := range targets {
+		names = append(names, t.name)
+		if !t.running {
+			continue
+		}
+		if err := e.dockerClient.StartContainer(ctx, t.id); err != nil {
+			return nil, &errors.OperationError{Op: fmt.Sprintf("start %s", t.name), Err: err}
+		}
+	}
+	return &RestoreResult{RestoredID: strings.Join(names, ",")}, nil
+}
@@ -0,0 +1,60 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/brian033/dockerbackup/pkg/archive"
+)
+
+// DetectTargetType tells container and compose backups apart, from metadata.json when it
+// identifies the backup and otherwise from the archive layout (containers/<service>/ for
+// compose, container.json for a single container).
+func DetectTargetType(ctx context.Context, backupPath string) (BackupTargetType, error) {
+	return detectTargetType(ctx, archive.NewTarArchiveHandler(), backupPath)
+}
+
+func detectTargetType(ctx context.Context, th archiveReader, backupPath string) (BackupTargetType, error) {
+	if b, err := th.ReadEntry(ctx, backupPath, "metadata.json"); err == nil {
+		var meta struct {
+			ProjectName   string   `json:"projectName"`
+			Services      []string `json:"services"`
+			ContainerID   string   `json:"containerID"`
+			ContainerName string   `json:"containerName"`
+			Kind          string   `json:"kind"`
+		}
+		if json.Unmarshal(b, &meta) == nil {
+			switch {
+			case meta.Kind == string(TargetHost):
+				return TargetHost, nil
+			case meta.ProjectName != "" || meta.Services != nil:
+				return TargetCompose, nil
+			case meta.ContainerID != "" || meta.ContainerName != "":
+				return TargetContainer, nil
+			}
+		}
+	}
+	compose, hasContainerJSON := false, false
+	err := th.WalkArchive(ctx, backupPath, func(en archive.ArchiveEntry) error {
+		switch {
+		case strings.HasPrefix(en.Path, "containers/"):
+			compose = true
+			return archive.ErrStopWalk
+		case en.Path == "container.json":
+			hasContainerJSON = true
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if compose {
+		return TargetCompose, nil
+	}
+	if hasContainerJSON {
+		return TargetContainer, nil
+	}
+	return "", fmt.Errorf("%s is neither a container nor a compose backup", backupPath)
+}
@@ -1,19 +1,20 @@
 package backup
 
 import (
-	"archive/tar"
-	"compress/gzip"
+	"bytes"
 	"context"
 	"encoding/json"
+	stdErrors "errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"sort"
+	"slices"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/brian033/dockerbackup/internal/errors"
 	"github.com/brian033/dockerbackup/internal/logger"
@@ -21,9 +22,11 @@ import (
 	"github.com/brian033/dockerbackup/pkg/compose"
 	"github.com/brian033/dockerbackup/pkg/docker"
 	"github.com/brian033/dockerbackup/pkg/filesystem"
+	"github.com/brian033/dockerbackup/pkg/lock"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
 )
 
 type BackupTargetType string
@@ -31,29 +34,43 @@ type BackupTargetType string
 const (
 	TargetContainer BackupTargetType = "container"
 	TargetCompose   BackupTargetType = "compose"
+	// Daemon configuration of the host (backup-host); not restored automatically
+	TargetHost BackupTargetType = "host"
 )
 
 type BackupRequest struct {
-	TargetType         BackupTargetType
-	ContainerID        string
+	TargetType  BackupTargetType
+	ContainerID string
+	// ContainerIDs backs up several containers into one archive (TargetContainer), restored
+	// like a compose project
+	ContainerIDs       []string
 	ComposeProjectPath string
 	ProjectName        string
 	Options            BackupOptions
 }
 
 type BackupResult struct {
-	OutputPath string
+	// OutputPath is the archive, or its split manifest when the archive was split
+	OutputPath string   `json:"outputPath"`
+	Parts      []string `json:"parts,omitempty"`
+	// Report summarizes what was captured
+	Report *BackupReport `json:"report,omitempty"`
+	// Warnings lists what could not be captured as it was
+	Warnings []Warning `json:"warnings"`
 }
 
 type RestoreRequest struct {
 	BackupPath  string
 	Options     RestoreOptions
 	ProjectName string
-	TargetType  BackupTargetType // for future use
+	// Empty detects the type from the backup (see DetectTargetType)
+	TargetType BackupTargetType
 }
 
 type RestoreResult struct {
-	RestoredID string
+	RestoredID string `json:"restoredId,omitempty"`
+	// Warnings lists what could not be restored as it was backed up
+	Warnings []Warning `json:"warnings"`
 }
 
 type ValidationResult struct {
@@ -65,6 +82,7 @@ type BackupEngine interface {
 	Backup(ctx context.Context, request BackupRequest) (*BackupResult, error)
 	Restore(ctx context.Context, request RestoreRequest) (*RestoreResult, error)
 	Validate(ctx context.Context, backupPath string) (*ValidationResult, error)
+	ValidateDeep(ctx context.Context, backupPath string) (*ValidationResult, error)
 }
 
 type DefaultBackupEngine struct {
@@ -72,6 +90,18 @@ type DefaultBackupEngine struct {
 	dockerClient   docker.DockerClient
 	filesystem     filesystem.Handler
 	log            logger.Logger
+	// journal, labels and owner mapping of the restore in progress, and the target daemon's
+	// subordinate ID range; nested service restores share the project's
+	journal       *restoreJournal
+	restoreLabels map[string]string
+	idMap         archive.IDMap
+	userns        *docker.UsernsRange
+	// lenient turns volume content mismatches after extraction into warnings
+	lenient bool
+	// conflicts decided so far, by kind/name
+	conflicts map[string]conflictResolution
+	// warnings of the backup or restore in progress
+	warnings *warningList
 }
 
 func NewDefaultBackupEngine(arch archive.ArchiveHandler, dc docker.DockerClient, fs filesystem.Handler, log logger.Logger) BackupEngine {
@@ -84,15 +114,61 @@ func NewDefaultBackupEngine(arch archive.ArchiveHandler, dc docker.DockerClient,
 }
 
 type backupMetadata struct {
+	ID string `json:"id,omitempty"`
+	// Previous backup of an incremental (--skip-unchanged) chain, by ID and archive name
+	ParentID        string    `json:"parentId,omitempty"`
+	Parent          string    `json:"parent,omitempty"`
+	HostID          string    `json:"hostId,omitempty"`
+	Hostname        string    `json:"hostname,omitempty"`
 	Version         int       `json:"version"`
 	CreatedAt       time.Time `json:"createdAt"`
 	ContainerID     string    `json:"containerID"`
 	ContainerName   string    `json:"containerName"`
 	Engine          string    `json:"engine"`
 	IncludesVolumes bool      `json:"includesVolumes"`
+	Checkpoint      string    `json:"checkpoint,omitempty"`
+	// Earlier backups holding volume data of unchanged volumes (--skip-unchanged)
+	VolumeRefs []string `json:"volumeRefs,omitempty"`
+	// NFS/CIFS volumes stored as mount options only (--skip-remote-volume-data)
+	RemoteVolumes []string `json:"remoteVolumes,omitempty"`
+	// Volumes (by name) and bind mounts (by source) whose data was not selected for backup
+	ExcludedMounts []string `json:"excludedMounts,omitempty"`
+	// Tags and digests of the container's image
+	Image *imageRefs `json:"image,omitempty"`
+	// Subordinate ID range of the daemon when it ran with userns-remap
+	Userns *docker.UsernsRange `json:"userns,omitempty"`
+	// --tag and --note
+	Tags []string `json:"tags,omitempty"`
+	Note string   `json:"note,omitempty"`
 }
 
+// Backup backs up a container, compose project or host configuration. Its result lists the
+// warnings recorded on the way.
 func (e *DefaultBackupEngine) Backup(ctx context.Context, request BackupRequest) (*BackupResult, error) {
+	var res *BackupResult
+	var err error
+	warnings := e.collectWarnings(func() { res, err = e.backup(ctx, request) })
+	if res != nil {
+		res.Warnings = warnings
+		if res.Report != nil {
+			res.Report.Warnings = warnings
+		}
+	}
+	// the services of a project are recorded as part of the project's backup
+	if err == nil && res != nil && res.Report != nil && !request.Options.composeService {
+		e.recordStats(filepath.Dir(res.OutputPath), backupStats(res))
+	}
+	return res, err
+}
+
+func (e *DefaultBackupEngine) backup(ctx context.Context, request BackupRequest) (*BackupResult, error) {
+	// before anything is archived, so the volume archives are rsyncable too
+	if th, ok := e.archiveHandler.(*archive.TarArchiveHandler); ok {
+		th.SetRsyncable(request.Options.Rsyncable)
+	}
+	if request.TargetType == TargetHost {
+		return e.backupHost(ctx, request)
+	}
 	if request.TargetType == TargetCompose {
 		projectPath := request.ComposeProjectPath
 		if projectPath == "" {
@@ -103,7 +179,7 @@ func (e *DefaultBackupEngine) Backup(ctx context.Context, request BackupRequest)
 		if projectName == "" {
 			// Try to read compose name
 			for _, name := range []string{"docker-compose.yml", "docker-compose.yaml"} {
-				if b, err := os.ReadFile(filepath.Join(projectPath, name)); err == nil {
+				if b, err := e.filesystem.ReadFile(filepath.Join(projectPath, name)); err == nil {
 					if n := compose.ParseProjectName(b); n != "" {
 						projectName = n
 						break
@@ -114,148 +190,170 @@ func (e *DefaultBackupEngine) Backup(ctx context.Context, request BackupRequest)
 				projectName = filepath.Base(projectPath)
 			}
 		}
+		lk, err := e.acquireLock(ctx, "compose-"+safeName(projectName), request.Options)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = lk.Release() }()
+		rep := newReporter(projectName)
 		// Prepare working dir
-		workDir, err := os.MkdirTemp("", fmt.Sprintf("dockerbackup_compose_%s_*", safeName(projectName)))
+		workDir, err := e.filesystem.MkdirTemp(WorkDir(), fmt.Sprintf("dockerbackup_compose_%s_*", safeName(projectName)))
 		if err != nil {
 			return nil, &errors.OperationError{Op: "create temp dir", Err: err}
 		}
-		defer func() { _ = os.RemoveAll(workDir) }()
+		defer func() { _ = e.filesystem.RemoveAll(workDir) }()
 
 		composeDir := filepath.Join(workDir, "compose-files")
 		containersDir := filepath.Join(workDir, "containers")
 		networksDir := filepath.Join(workDir, "networks")
 		volumesDir := filepath.Join(workDir, "volumes")
-		_ = os.MkdirAll(composeDir, 0o755)
-		_ = os.MkdirAll(containersDir, 0o755)
-		_ = os.MkdirAll(networksDir, 0o755)
-		_ = os.MkdirAll(volumesDir, 0o755)
-
-		// Copy compose files
-		for _, name := range []string{"docker-compose.yml", "docker-compose.yaml", "docker-compose.override.yml", ".env"} {
+		_ = e.filesystem.EnsureDir(composeDir, 0o755)
+		_ = e.filesystem.EnsureDir(containersDir, 0o755)
+		_ = e.filesystem.EnsureDir(networksDir, 0o755)
+		_ = e.filesystem.EnsureDir(volumesDir, 0o755)
+
+		// Copy compose files, plus the env_file entries they reference (relative paths kept)
+		envFiles := []string{".env"}
+		var buildContexts []compose.BuildContext
+		var composeData [][]byte
+		for _, name := range []string{"docker-compose.yml", "docker-compose.yaml", "docker-compose.override.yml"} {
 			src := filepath.Join(projectPath, name)
-			if b, err := os.ReadFile(src); err == nil {
-				_ = os.WriteFile(filepath.Join(composeDir, name), b, 0o644)
+			if b, err := e.filesystem.ReadFile(src); err == nil {
+				_ = e.filesystem.WriteFile(filepath.Join(composeDir, name), b, 0o644)
+				composeData = append(composeData, b)
+				envFiles = append(envFiles, compose.EnvFiles(b)...)
+				buildContexts = append(buildContexts, compose.BuildContexts(b)...)
+			}
+		}
+		e.copyComposeEnvFiles(projectPath, composeDir, envFiles)
+		for _, bc := range buildContexts {
+			if !request.Options.IncludeBuildContext {
+				e.warn(WarnBuildContext, bc.Service, "Service %s is built from %s; use --include-build-context to archive it", bc.Service, bc.Context)
+				continue
+			}
+			if err := e.copyBuildContext(projectPath, composeDir, bc.Context); err != nil {
+				return nil, &errors.OperationError{Op: fmt.Sprintf("archive build context of %s", bc.Service), Err: err}
 			}
 		}
+		rep.stage("copy project files")
 
 		// Discover project containers: prefer label-based, fallback to name heuristic
 		refs, err := e.dockerClient.ListProjectContainersByLabel(ctx, projectName)
 		if err != nil || len(refs) == 0 {
 			refs, _ = e.dockerClient.ListProjectContainers(ctx, projectName)
 		}
+		var netCfgs []docker.NetworkConfig
+		var volCfgs []docker.VolumeConfig
 		if len(refs) == 0 {
-			return nil, &errors.OperationError{Op: "discover project containers", Err: fmt.Errorf("no containers found for project %s", projectName)}
-		}
-		// Backup each service container
-		serviceNames := make([]string, 0, len(refs))
-		for _, r := range refs {
-			serviceNames = append(serviceNames, r.Service)
-			svcDir := filepath.Join(containersDir, r.Service)
-			_ = os.MkdirAll(svcDir, 0o755)
-			outTar := filepath.Join(svcDir, "container.tar.gz")
-			builder := NewBackupOptionsBuilder().WithOutput(outTar).WithCompression(0)
-			_, err := e.Backup(ctx, BackupRequest{TargetType: TargetContainer, ContainerID: r.ID, Options: builder.Build()})
+			if !request.Options.Offline {
+				return nil, &errors.OperationError{Op: "discover project containers", Err: fmt.Errorf("no containers found for project %s (use --offline to back up the volumes and networks its compose files declare)", projectName)}
+			}
+			netCfgs, volCfgs, err = e.backupComposeOffline(ctx, composeData, projectName, workDir, request.Options, rep)
 			if err != nil {
 				return nil, err
 			}
 		}
-
-		// Aggregate networks used by the containers
-		seenNets := map[string]struct{}{}
-		var netCfgs []docker.NetworkConfig
+		// Backup each service container, the replicas of a scaled service next to the first
+		serviceNames := make([]string, 0, len(refs))
+		scale := map[string]int{}
+		archives, shared := e.serviceReplicas(ctx, refs)
 		for _, r := range refs {
-			b, err := e.dockerClient.InspectContainer(ctx, r.ID)
-			if err != nil {
-				continue
-			}
-			var cj types.ContainerJSON
-			if err := json.Unmarshal(b, &cj); err != nil {
-				continue
+			if scale[r.Service] == 0 {
+				serviceNames = append(serviceNames, r.Service)
 			}
-			if cj.NetworkSettings == nil {
-				continue
-			}
-			for name := range cj.NetworkSettings.Networks {
-				if _, ok := seenNets[name]; ok {
-					continue
-				}
-				seenNets[name] = struct{}{}
-				if n, err := e.dockerClient.InspectNetwork(ctx, name); err == nil {
-					netCfgs = append(netCfgs, *n)
-				}
+			scale[r.Service]++
+			if err := e.backupService(ctx, containersDir, archives[r.ID], r, request.Options, shared[r.ID], rep); err != nil {
+				return nil, err
 			}
 		}
-		if len(netCfgs) > 0 {
-			if b, err := json.MarshalIndent(netCfgs, "", "  "); err == nil {
-				_ = os.WriteFile(filepath.Join(networksDir, "network_configs.json"), b, 0o644)
+		for svc, n := range scale {
+			if n == 1 {
+				delete(scale, svc)
 			}
 		}
 
-		// Collect volume configs used across services (by mounts)
-		volSet := map[string]struct{}{}
-		var volCfgs []docker.VolumeConfig
-		for _, r := range refs {
-			b, err := e.dockerClient.InspectContainer(ctx, r.ID)
-			if err != nil {
-				continue
-			}
-			var ci docker.ContainerInfo
-			if info, err := docker.ParseContainerInfo(b); err == nil {
-				ci = info
-			} else {
-				continue
-			}
-			for _, m := range ci.Mounts {
-				if m.Type == "volume" && m.Name != "" {
-					if _, ok := volSet[m.Name]; ok {
-						continue
-					}
-					volSet[m.Name] = struct{}{}
-					if v, err := e.dockerClient.InspectVolume(ctx, m.Name); err == nil && v != nil {
-						volCfgs = append(volCfgs, *v)
-					}
-				}
-			}
+		// Aggregate networks and volumes used by the containers (an offline backup has the
+		// declared ones already)
+		used := e.projectResources(ctx, refs)
+		netCfgs = append(netCfgs, used.networks...)
+		var projectMounts []docker.Mount
+		for _, v := range volCfgs {
+			projectMounts = append(projectMounts, docker.Mount{Type: "volume", Name: v.Name})
 		}
-		if len(volCfgs) > 0 {
-			if b, err := json.MarshalIndent(volCfgs, "", "  "); err == nil {
-				_ = os.WriteFile(filepath.Join(volumesDir, "volume_configs.json"), b, 0o644)
-			}
+		for _, name := range used.volumeNames {
+			projectMounts = append(projectMounts, docker.Mount{Type: "volume", Name: name})
 		}
+		volCfgs = append(volCfgs, used.volumes...)
+		for _, p := range unmatchedPatterns(request.Options.IncludeVolumes, projectMounts) {
+			e.warn(WarnPatternUnmatched, p, "Warning: --include-volume %s matches no volume of project %s", p, projectName)
+		}
+		e.writeProjectResources(ctx, workDir, netCfgs, volCfgs)
+		imagesSaved := e.saveProjectImages(ctx, workDir, used.images, request.Options)
+		rep.stage("save images")
 
 		// Metadata
-		meta := map[string]any{"version": 1, "projectName": projectName, "services": serviceNames}
+		hostname, _ := os.Hostname()
+		meta := map[string]any{"id": newBackupID(), "hostId": hostID(e.filesystem), "hostname": hostname, "version": FormatVersion, "createdAt": time.Now().UTC(), "projectName": projectName, "services": serviceNames}
+		if imagesSaved {
+			meta["images"] = used.images
+		}
+		if len(used.dependsOn) > 0 {
+			meta["dependsOn"] = used.dependsOn
+		}
+		if len(scale) > 0 {
+			meta["scale"] = scale
+		}
+		if len(request.Options.Tags) > 0 {
+			meta["tags"] = request.Options.Tags
+		}
+		if request.Options.Note != "" {
+			meta["note"] = request.Options.Note
+		}
+		if len(refs) == 0 {
+			meta["offline"] = true
+		}
 		if b, err := json.MarshalIndent(meta, "", "  "); err == nil {
-			_ = os.WriteFile(filepath.Join(workDir, "metadata.json"), b, 0o644)
+			_ = e.filesystem.WriteFile(filepath.Join(workDir, "metadata.json"), b, 0o644)
+		}
+		if err := writeFormatManifest(e.filesystem, workDir, TargetCompose); err != nil {
+			return nil, &errors.OperationError{Op: "write format.json", Err: err}
 		}
+		rep.stage("capture configuration")
 
 		// Final archive
 		outputPath := request.Options.OutputPath
-		if outputPath == "" {
+		if request.Options.Timestamped {
+			outputPath = timestampedOutputPath(outputPath, projectPath, safeName(projectName)+"_compose", time.Now())
+		} else if outputPath == "" {
 			outputPath = filepath.Join(projectPath, fmt.Sprintf("%s_compose_backup.tar.gz", safeName(projectName)))
 		}
 		sources := []archive.ArchiveSource{
+			{Path: filepath.Join(workDir, formatManifestName), DestPath: formatManifestName},
+			{Path: filepath.Join(workDir, "metadata.json"), DestPath: "metadata.json"},
 			{Path: composeDir, DestPath: "compose-files"},
 			{Path: containersDir, DestPath: "containers"},
 			{Path: networksDir, DestPath: "networks"},
 			{Path: volumesDir, DestPath: "volumes"},
-			{Path: filepath.Join(workDir, "metadata.json"), DestPath: "metadata.json"},
 		}
-		if th, ok := e.archiveHandler.(*archive.TarArchiveHandler); ok {
-			th.SetCompressionLevel(request.Options.CompressionLevel)
+		if imagesSaved {
+			sources = append(sources, archive.ArchiveSource{Path: filepath.Join(workDir, projectImagesDir), DestPath: projectImagesDir})
 		}
-		if err := e.archiveHandler.CreateArchive(ctx, sources, outputPath); err != nil {
-			return nil, &errors.OperationError{Op: "create compose archive", Err: err}
+		if len(refs) == 0 {
+			sources = append(sources, archive.ArchiveSource{Path: filepath.Join(workDir, mountsFileName), DestPath: mountsFileName})
 		}
-		return &BackupResult{OutputPath: outputPath}, nil
+		return e.packageProject(ctx, workDir, sources, outputPath, safeName(projectName)+"_compose", request.Options, rep)
 	}
 
 	if request.TargetType != TargetContainer {
 		return nil, &errors.ValidationError{Msg: "unsupported target type"}
 	}
+	if len(request.ContainerIDs) > 0 {
+		return e.backupGroup(ctx, request)
+	}
 	if request.ContainerID == "" {
 		return nil, &errors.ValidationError{Field: "ContainerID", Msg: "required"}
 	}
+	rep := newReporter(request.ContainerID)
 	// Inspect container
 	inspectJSON, err := e.dockerClient.InspectContainer(ctx, request.ContainerID)
 	if err != nil {
@@ -265,23 +363,34 @@ func (e *DefaultBackupEngine) Backup(ctx context.Context, request BackupRequest)
 	if err != nil {
 		return nil, &errors.OperationError{Op: "parse container inspect", Err: err}
 	}
+	lk, err := e.acquireLock(ctx, "container-"+info.ID, request.Options)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = lk.Release() }()
 
 	// Determine output path
 	outputPath := request.Options.OutputPath
-	if outputPath == "" {
+	if request.Options.Timestamped {
+		cwd, _ := os.Getwd()
+		outputPath = timestampedOutputPath(outputPath, cwd, safeName(strings.TrimPrefix(info.Name, "/")), time.Now())
+	} else if outputPath == "" {
 		cwd, _ := os.Getwd()
 		base := fmt.Sprintf("%s_backup.tar.gz", safeName(info.Name))
 		outputPath = filepath.Join(cwd, base)
 	}
 
 	// Prepare working dir
-	workDir, err := os.MkdirTemp("", fmt.Sprintf("dockerbackup_%s_*", safeName(info.Name)))
+	workDir, err := e.filesystem.MkdirTemp(WorkDir(), fmt.Sprintf("dockerbackup_%s_*", safeName(info.Name)))
 	if err != nil {
 		return nil, &errors.OperationError{Op: "create temp dir", Err: err}
 	}
 	defer func() {
-		_ = os.RemoveAll(workDir)
+		_ = e.filesystem.RemoveAll(workDir)
 	}()
+	if err := e.checkBackupSpace(ctx, workDir, inspectJSON, request.Options); err != nil {
+		return nil, err
+	}
 
 	containerJSONPath := filepath.Join(workDir, "container.json")
 	filesystemTarPath := filepath.Join(workDir, "filesystem.tar")
@@ -289,43 +398,133 @@ func (e *DefaultBackupEngine) Backup(ctx context.Context, request BackupRequest)
 	metadataPath := filepath.Join(workDir, "metadata.json")
 	imageTarPath := filepath.Join(workDir, "image.tar")
 
-	if err := os.WriteFile(containerJSONPath, inspectJSON, 0o644); err != nil {
+	if err := e.filesystem.WriteFile(containerJSONPath, inspectJSON, 0o644); err != nil {
 		return nil, &errors.OperationError{Op: "write container.json", Err: err}
 	}
+	rep.report.Target = strings.TrimPrefix(info.Name, "/")
+	rep.stage("inspect")
+	// Checkpoint first: unless left running the container stops here, so the filesystem and
+	// volumes captured below match the dumped process state
+	checkpointDir := filepath.Join(workDir, checkpointDirName)
+	if request.Options.Checkpoint != "" {
+		e.log.Infof("Creating checkpoint %s for container %s", request.Options.Checkpoint, info.Name)
+		if err := e.filesystem.EnsureDir(checkpointDir, 0o755); err != nil {
+			return nil, &errors.OperationError{Op: "create checkpoint dir", Err: err}
+		}
+		if err := e.dockerClient.CheckpointCreate(ctx, info.ID, request.Options.Checkpoint, checkpointDir, request.Options.CheckpointLeaveRunning); err != nil {
+			return nil, &errors.OperationError{Op: "docker checkpoint create", Err: err}
+		}
+		rep.stage("checkpoint")
+	}
 	e.log.Infof("Exporting filesystem for container %s", info.Name)
 	if err := e.dockerClient.ExportContainerFilesystem(ctx, info.ID, filesystemTarPath); err != nil {
 		return nil, &errors.OperationError{Op: "export container filesystem", Err: err}
 	}
+	rep.stage("export filesystem")
 
 	// Archive named volumes and bind mounts (Linux supported)
 	includesVolumes := false
-	if err := os.MkdirAll(volumesDir, 0o755); err != nil {
+	if err := e.filesystem.EnsureDir(volumesDir, 0o755); err != nil {
 		return nil, &errors.OperationError{Op: "create volumes dir", Err: err}
 	}
+	var skip *skipTracker
+	if request.Options.SkipUnchanged {
+		skip = newSkipTracker(e.filesystem, outputPath, safeName(strings.TrimPrefix(info.Name, "/")))
+	}
+	var remoteVolumes, excludedMounts []string
+	var mounts mountMap
+	// data read on the host of a userns-remap daemon carries the shifted host IDs
+	userns := e.daemonUserns(ctx)
+	if !request.Options.composeService {
+		for _, p := range unmatchedPatterns(request.Options.IncludeVolumes, info.Mounts) {
+			e.warn(WarnPatternUnmatched, p, "Warning: --include-volume %s matches no volume of %s", p, info.Name)
+		}
+	}
 	for _, m := range info.Mounts {
+		if (m.Type == "volume" && m.Name != "") || (m.Type == "bind" && m.Source != "") {
+			if !mountSelected(m, request.Options) {
+				id := m.Name
+				if m.Type == "bind" {
+					id = m.Source
+				}
+				e.warn(WarnMountExcluded, id, "Skipping data of %s %s (excluded)", m.Type, id)
+				excludedMounts = append(excludedMounts, id)
+				a := newMountArtifact(m)
+				a.Skipped = "excluded"
+				mounts = append(mounts, a)
+				continue
+			}
+		}
 		// Named volumes
-		if m.Type == "volume" && m.Name != "" && m.Source != "" {
+		if m.Type == "volume" && m.Name != "" {
 			includesVolumes = true
+			a := newMountArtifact(m)
+			if holder := request.Options.sharedVolumes[m.Name]; holder != "" {
+				a.Skipped, a.SharedWith = "shared", holder
+				mounts = append(mounts, a)
+				continue
+			}
+			if e.skipRemoteVolume(ctx, m, request.Options) {
+				remoteVolumes = append(remoteVolumes, m.Name)
+				a.Skipped = "remote"
+				mounts = append(mounts, a)
+				continue
+			}
 			volTarGz := filepath.Join(volumesDir, fmt.Sprintf("%s.tar.gz", safeName(m.Name)))
+			a.Artifact, a.Root = "volumes/"+filepath.Base(volTarGz), m.Name
+			mounts = append(mounts, a)
+			if needsDaemonStreaming(e.filesystem, m) {
+				e.log.Infof("Volume %s (driver %s) is not readable on this host, archiving it through the daemon", m.Name, orLocal(m.Driver))
+				if err := e.dockerClient.ArchiveVolume(ctx, m.Name, volTarGz); err != nil {
+					return nil, &errors.OperationError{Op: fmt.Sprintf("archive volume %s", m.Name), Err: err}
+				}
+				c := mountComponent(e.filesystem, "volume "+m.Name, volTarGz, nil, "")
+				c.Note = "archived through the daemon"
+				rep.component(c)
+				continue
+			}
+			mounts[len(mounts)-1].Userns = userns
 			src := archive.ArchiveSource{Path: m.Source, DestPath: m.Name}
-			if err := e.archiveHandler.CreateArchive(ctx, []archive.ArchiveSource{src}, volTarGz); err != nil {
+			src.Boundary, mounts[len(mounts)-1].NestedMounts = e.mountBoundary(m.Name, m.Source, request.Options)
+			stats, ref, err := e.archiveMountData(ctx, src, volTarGz, skip)
+			if err != nil {
 				return nil, &errors.OperationError{Op: fmt.Sprintf("archive volume %s", m.Name), Err: err}
 			}
+			rep.component(mountComponent(e.filesystem, "volume "+m.Name, volTarGz, stats, ref))
 			continue
 		}
 		// Bind mounts (host directories)
 		if m.Type == "bind" && m.Source != "" {
+			if !request.Options.AllowSpecialMounts && specialMount(e.filesystem, m.Source) {
+				e.warn(WarnSpecialMount, m.Source, "Skipping data of bind mount %s (a special path; archive it anyway with --allow-special-mounts)", m.Source)
+				a := newMountArtifact(m)
+				a.Skipped = "special"
+				mounts = append(mounts, a)
+				continue
+			}
 			includesVolumes = true
 			base := filepath.Base(m.Source)
-			name := fmt.Sprintf("bind_%s", safeName(base))
-			volTarGz := filepath.Join(volumesDir, fmt.Sprintf("%s.tar.gz", name))
+			volTarGz := filepath.Join(volumesDir, bindArchiveName(m.Source)+".tar.gz")
+			a := newMountArtifact(m)
+			a.Artifact, a.Root = "volumes/"+filepath.Base(volTarGz), base
+			a.Userns = userns
 			src := archive.ArchiveSource{Path: m.Source, DestPath: base}
-			if err := e.archiveHandler.CreateArchive(ctx, []archive.ArchiveSource{src}, volTarGz); err != nil {
+			src.Boundary, a.NestedMounts = e.mountBoundary(m.Source, m.Source, request.Options)
+			mounts = append(mounts, a)
+			stats, ref, err := e.archiveMountData(ctx, src, volTarGz, skip)
+			if err != nil {
 				return nil, &errors.OperationError{Op: fmt.Sprintf("archive bind mount %s", m.Source), Err: err}
 			}
+			rep.component(mountComponent(e.filesystem, "bind "+m.Source, volTarGz, stats, ref))
 			continue
 		}
 	}
+	rep.stage("archive mounts")
+
+	e.recordContent(ctx, workDir, mounts)
+	if err := e.writeMountMap(workDir, mounts); err != nil {
+		return nil, &errors.OperationError{Op: "write " + mountsFileName, Err: err}
+	}
 
 	// Capture volume configs for named volumes
 	volCfgPath := filepath.Join(volumesDir, "volume_configs.json")
@@ -337,21 +536,21 @@ func (e *DefaultBackupEngine) Backup(ctx context.Context, request BackupRequest)
 			}
 		}
 	}
+	e.captureVolumePlugins(ctx, volCfgs, volumesDir)
 	if len(volCfgs) > 0 {
 		if b, err := json.MarshalIndent(volCfgs, "", "  "); err == nil {
-			_ = os.WriteFile(volCfgPath, b, 0o644)
+			_ = e.filesystem.WriteFile(volCfgPath, b, 0o644)
 		}
 	}
 
 	// Capture network configs for attached networks via container inspect (names only) -> inspect per network
 	netDir := filepath.Join(workDir, "networks")
-	if err := os.MkdirAll(netDir, 0o755); err != nil {
+	if err := e.filesystem.EnsureDir(netDir, 0o755); err != nil {
 		return nil, &errors.OperationError{Op: "create networks dir", Err: err}
 	}
 	var netCfgs []docker.NetworkConfig
 	// Try to read network names from container.json content (cj.NetworkSettings.Networks). Parse quickly.
-	var cj types.ContainerJSON
-	_ = json.Unmarshal(inspectJSON, &cj)
+	cj, _ := decodeContainerJSON(inspectJSON)
 	if cj.NetworkSettings != nil {
 		for name := range cj.NetworkSettings.Networks {
 			if n, err := e.dockerClient.InspectNetwork(ctx, name); err == nil && n != nil {
@@ -362,188 +561,531 @@ func (e *DefaultBackupEngine) Backup(ctx context.Context, request BackupRequest)
 	netCfgPath := filepath.Join(netDir, "network_configs.json")
 	if len(netCfgs) > 0 {
 		if b, err := json.MarshalIndent(netCfgs, "", "  "); err == nil {
-			_ = os.WriteFile(netCfgPath, b, 0o644)
+			_ = e.filesystem.WriteFile(netCfgPath, b, 0o644)
+		}
+	}
+
+	// Capture custom seccomp/AppArmor profiles referenced by SecurityOpt
+	secDir := filepath.Join(workDir, securityDirName)
+	var secProfiles []securityProfile
+	if cj.ContainerJSONBase != nil {
+		secProfiles, err = captureSecurityProfiles(e.filesystem, cj.HostConfig, secDir)
+		if err != nil {
+			return nil, &errors.OperationError{Op: "capture security profiles", Err: err}
 		}
 	}
 
 	// Write metadata
+	hostname, _ := os.Hostname()
 	meta := backupMetadata{
-		Version:         1,
+		ID:              newBackupID(),
+		HostID:          hostID(e.filesystem),
+		Hostname:        hostname,
+		Version:         FormatVersion,
 		CreatedAt:       time.Now().UTC(),
 		ContainerID:     info.ID,
 		ContainerName:   info.Name,
 		Engine:          "default",
 		IncludesVolumes: includesVolumes,
+		Checkpoint:      request.Options.Checkpoint,
+		RemoteVolumes:   remoteVolumes,
+		ExcludedMounts:  excludedMounts,
+		Userns:          userns,
+		Image:           e.captureImageRefs(ctx, cj),
+		Tags:            request.Options.Tags,
+		Note:            request.Options.Note,
+	}
+	if skip != nil {
+		meta.VolumeRefs = skip.referencedArchives()
+		meta.ParentID = skip.prev.BackupID
+		if !isSameArchive(skip.prev.Archive, skip.output) {
+			meta.Parent = skip.prev.Archive
+		}
 	}
 	b, err := json.MarshalIndent(meta, "", "  ")
 	if err != nil {
 		return nil, &errors.OperationError{Op: "marshal metadata", Err: err}
 	}
-	if err := os.WriteFile(metadataPath, b, 0o644); err != nil {
+	if err := e.filesystem.WriteFile(metadataPath, b, 0o644); err != nil {
 		return nil, &errors.OperationError{Op: "write metadata.json", Err: err}
 	}
+	if err := writeFormatManifest(e.filesystem, workDir, TargetContainer); err != nil {
+		return nil, &errors.OperationError{Op: "write format.json", Err: err}
+	}
+	rep.stage("capture configuration")
 
 	// Try to save original image if present in inspect (non-empty Image ID or name)
-	if cj.ContainerJSONBase != nil && cj.ContainerJSONBase.Image != "" {
-		_ = e.dockerClient.ImageSave(ctx, cj.ContainerJSONBase.Image, imageTarPath)
+	switch {
+	case cj.ContainerJSONBase == nil || cj.ContainerJSONBase.Image == "":
+		e.warn(WarnImageNotSaved, "", "Container %s records no image; none is saved", info.Name)
+	case request.Options.projectImages:
+		// saved with the images of the other services, in the project archive
+	default:
+		if err := e.dockerClient.ImageSave(ctx, cj.ContainerJSONBase.Image, imageTarPath); err != nil {
+			e.warn(WarnImageNotSaved, cj.ContainerJSONBase.Image, "Could not save image %s; restore needs it from a registry: %v", cj.ContainerJSONBase.Image, err)
+		}
 	}
+	ociDir := filepath.Join(workDir, ociImageDirName)
+	if _, err := e.filesystem.Stat(imageTarPath); err == nil && request.Options.ImageFormat == ImageFormatOCI {
+		if err := archive.ImageTarToOCILayout(ctx, imageTarPath, ociDir); err != nil {
+			e.warn(WarnImageFormat, cj.ContainerJSONBase.Image, "Could not convert the image to an OCI layout, keeping docker save format: %v", err)
+			_ = e.filesystem.RemoveAll(ociDir)
+		} else {
+			_ = e.filesystem.RemoveAll(imageTarPath)
+		}
+	}
+	hasProvenance := e.captureProvenance(ctx, cj, workDir, request.Options.SBOM)
+	rep.stage("save image")
 
 	// Build final archive
 	e.log.Infof("Packaging backup -> %s", outputPath)
+	// format and metadata first so readers find them without reading the whole archive
 	sources := []archive.ArchiveSource{
+		{Path: filepath.Join(workDir, formatManifestName), DestPath: formatManifestName},
+		{Path: metadataPath, DestPath: "metadata.json"},
 		{Path: containerJSONPath, DestPath: "container.json"},
+		{Path: filepath.Join(workDir, mountsFileName), DestPath: mountsFileName},
 		{Path: filesystemTarPath, DestPath: "filesystem.tar"},
 		{Path: volumesDir, DestPath: "volumes"},
 		{Path: netDir, DestPath: "networks"},
-		{Path: metadataPath, DestPath: "metadata.json"},
 	}
-	if _, err := os.Stat(imageTarPath); err == nil {
+	if _, err := e.filesystem.Stat(ociDir); err == nil {
+		sources = append(sources, archive.ArchiveSource{Path: ociDir, DestPath: ociImageDirName})
+	}
+	if hasProvenance {
+		sources = append(sources, archive.ArchiveSource{Path: filepath.Join(workDir, provenanceDirName), DestPath: provenanceDirName})
+	}
+	if _, err := e.filesystem.Stat(imageTarPath); err == nil {
 		sources = append(sources, archive.ArchiveSource{Path: imageTarPath, DestPath: "image.tar"})
 	}
+	if len(secProfiles) > 0 {
+		sources = append(sources, archive.ArchiveSource{Path: secDir, DestPath: securityDirName})
+	}
+	if request.Options.Checkpoint != "" {
+		sources = append(sources, archive.ArchiveSource{Path: checkpointDir, DestPath: checkpointDirName})
+	}
+	if request.Options.EmbedReport {
+		if err := rep.write(e.filesystem, workDir, e.warnings.list()); err != nil {
+			return nil, &errors.OperationError{Op: "write " + reportFileName, Err: err}
+		}
+		sources = append(sources, archive.ArchiveSource{Path: filepath.Join(workDir, reportFileName), DestPath: reportFileName})
+	}
 	if th, ok := e.archiveHandler.(*archive.TarArchiveHandler); ok {
 		th.SetCompressionLevel(request.Options.CompressionLevel)
+		th.SetCompressionThreads(request.Options.CompressThreads)
+		th.SetAutoCompression(request.Options.CompressionAuto)
 	}
-	if err := e.archiveHandler.CreateArchive(ctx, sources, outputPath); err != nil {
+	stats, err := e.createArchive(ctx, sources, outputPath)
+	if err != nil {
 		return nil, &errors.OperationError{Op: "create final archive", Err: err}
 	}
-
-	return &BackupResult{OutputPath: outputPath}, nil
+	rep.stage("package")
+	rep.packaged(e.filesystem, outputPath, stats, "volumes")
+	res, err := e.finalizeArchive(outputPath, safeName(strings.TrimPrefix(info.Name, "/")), request.Options)
+	if err != nil {
+		return nil, err
+	}
+	rep.stage("finalize")
+	res.Report = rep.done()
+	if skip != nil {
+		if err := skip.save(res.OutputPath, meta.ID); err != nil {
+			e.log.Infof("Could not update backup state %s: %v", skip.statePath, err)
+		}
+	}
+	return res, nil
 }
 
+// Restore restores a container or compose backup. Its result lists the warnings recorded on
+// the way.
 func (e *DefaultBackupEngine) Restore(ctx context.Context, request RestoreRequest) (*RestoreResult, error) {
+	var res *RestoreResult
+	var err error
+	// nested restores (the services of a project) run inside the project's journal
+	nested, start := e.journal != nil, time.Now()
+	warnings := e.collectWarnings(func() { res, err = e.restoreJournaled(ctx, request) })
+	if res != nil {
+		res.Warnings = warnings
+	}
+	if err == nil && !nested {
+		e.recordStats(filepath.Dir(request.BackupPath), restoreStats(ctx, e.filesystem, request.BackupPath, time.Since(start)))
+	}
+	return res, err
+}
+
+// restoreJournaled journals every container, volume and network it creates; when the restore
+// is interrupted (ctx cancelled) they are removed again so no half-restored state is left.
+func (e *DefaultBackupEngine) restoreJournaled(ctx context.Context, request RestoreRequest) (*RestoreResult, error) {
+	if e.journal != nil {
+		return e.restore(ctx, request)
+	}
+	defer func() { e.restoreLabels = nil }()
+	e.idMap = archive.IDMap{UIDs: request.Options.UIDMap, GIDs: request.Options.GIDMap}
+	e.lenient = request.Options.Lenient
+	e.userns = e.daemonUserns(ctx)
+	e.conflicts = map[string]conflictResolution{}
+	defer func() { e.idMap, e.userns, e.conflicts, e.lenient = archive.IDMap{}, nil, nil, false }()
+	j, err := newRestoreJournal(ctx, request.BackupPath)
+	if err != nil {
+		e.log.Infof("Could not create restore journal, interrupted restores will not be undone: %v", err)
+		return e.restore(ctx, request)
+	}
+	e.journal = j
+	defer func() { e.journal = nil }()
+	res, err := e.restore(ctx, request)
+	if err != nil && ctx.Err() != nil {
+		e.log.Infof("Restore interrupted, removing the resources it created")
+		if errs := j.undo(e.dockerClient, e.log); len(errs) > 0 {
+			for _, uerr := range errs {
+				e.log.Errorf("%v", uerr)
+			}
+			// keep the journal so `dockerbackup cleanup` can retry
+			_ = j.lk.Release()
+			return res, err
+		}
+	}
+	j.finish()
+	return res, err
+}
+
+func (e *DefaultBackupEngine) restore(ctx context.Context, request RestoreRequest) (*RestoreResult, error) {
+	if request.TargetType == "" {
+		t, err := DetectTargetType(ctx, request.BackupPath)
+		if err != nil {
+			return nil, &errors.OperationError{Op: "detect backup type", Err: err}
+		}
+		e.log.Debugf("Detected %s backup %s", t, request.BackupPath)
+		request.TargetType = t
+	}
+	if request.TargetType == TargetHost {
+		return nil, &errors.ValidationError{Field: "BackupPath", Msg: "is a host configuration backup; restore daemon.json and plugins by hand (see README, Host Configuration)"}
+	}
+	if request.Options.CreateOnly && (request.Options.Start || request.Options.Paused || request.Options.Checkpoint || request.Options.ComposeUp) {
+		return nil, &errors.ValidationError{Field: "CreateOnly", Msg: "create-only restores start nothing; drop --start, --paused, --checkpoint and --compose-up"}
+	}
+	if request.Options.Paused {
+		request.Options.Start = true
+	}
+	if request.Options.Isolated && (request.Options.ComposeUp || request.Options.AttachTo != "") {
+		return nil, &errors.ValidationError{Field: "Isolated", Msg: "cannot be combined with --compose-up or --attach-to"}
+	}
+	if len(request.Options.VolumesOnly) > 0 {
+		return e.restoreVolumesOnly(ctx, request)
+	}
+	if request.Options.DataRefresh {
+		return e.restoreDataRefresh(ctx, request)
+	}
 	if request.TargetType == TargetCompose {
 		// Extract
-		tmpDir, err := os.MkdirTemp("", "dockerbackup_compose_restore_*")
+		tmpDir, err := e.filesystem.MkdirTemp(WorkDir(), "dockerbackup_compose_restore_*")
 		if err != nil {
 			return nil, &errors.OperationError{Op: "create temp dir", Err: err}
 		}
-		defer func() { _ = os.RemoveAll(tmpDir) }()
+		defer func() { _ = e.filesystem.RemoveAll(tmpDir) }()
+		if err := checkRestoreSpace(e.filesystem, tmpDir, request.BackupPath); err != nil {
+			return nil, err
+		}
 		if err := e.archiveHandler.ExtractArchive(ctx, request.BackupPath, tmpDir); err != nil {
 			return nil, &errors.OperationError{Op: "extract backup", Err: err}
 		}
+		if err := e.checkExtractedFormat(tmpDir); err != nil {
+			return nil, err
+		}
+		e.setRestoreLabels(tmpDir, request.BackupPath)
 
-		// Ensure networks from configs
-		if b, err := os.ReadFile(filepath.Join(tmpDir, "networks", "network_configs.json")); err == nil {
+		// Renaming the project rewrites prefixed network/volume/container names and compose labels
+		renamer := newProjectRenamer(readComposeProjectName(e.filesystem, tmpDir), request.ProjectName)
+
+		if request.Options.ComposeUp && (request.Options.conflictPolicy(ConflictVolume) == ConflictRename || request.Options.conflictPolicy(ConflictNetwork) == ConflictRename) {
+			return nil, &errors.ValidationError{Field: "OnConflict", Msg: "volumes and networks cannot be renamed with --compose-up, the compose files name them"}
+		}
+
+		// Ensure networks from configs, unless the services join the --attach-to network
+		if request.Options.AttachTo != "" {
+			if request.Options.ComposeUp {
+				return nil, &errors.ValidationError{Field: "AttachTo", Msg: "cannot be combined with --compose-up"}
+			}
+			if _, err := e.attachTarget(ctx, request.Options.AttachTo); err != nil {
+				return nil, err
+			}
+		} else if b, err := e.filesystem.ReadFile(filepath.Join(tmpDir, "networks", "network_configs.json")); err == nil {
 			var netCfgs []docker.NetworkConfig
 			_ = json.Unmarshal(b, &netCfgs)
 			for _, nc := range netCfgs {
-				_ = e.dockerClient.EnsureNetwork(ctx, nc)
+				nc.Name, err = e.networkConflict(ctx, request.Options, renamer.name(nc.Name))
+				if err != nil {
+					return nil, err
+				}
+				nc.Labels = renamer.labels(nc.Labels)
+				e.resolveParent(ctx, &nc)
+				e.ensureNetwork(ctx, nc)
 			}
 		}
 		// Ensure volumes from configs
-		if b, err := os.ReadFile(filepath.Join(tmpDir, "volumes", "volume_configs.json")); err == nil {
+		if b, err := e.filesystem.ReadFile(filepath.Join(tmpDir, "volumes", "volume_configs.json")); err == nil {
 			var volCfgs []docker.VolumeConfig
 			_ = json.Unmarshal(b, &volCfgs)
+			if err := e.ensureVolumeDrivers(ctx, tmpDir, volCfgs, request.Options.InstallPlugins); err != nil {
+				return nil, err
+			}
 			for _, vc := range volCfgs {
-				_ = e.dockerClient.EnsureVolume(ctx, vc)
+				vc.Name = renamer.name(vc.Name)
+				vc.Labels = renamer.labels(vc.Labels)
+				e.ensureVolume(ctx, vc)
 			}
 		}
-
-		// Compute service order from compose-files if present
-		services := map[string]struct{}{}
-		order := []string{}
-		composePathYml := filepath.Join(tmpDir, "compose-files", "docker-compose.yml")
-		composePathYaml := filepath.Join(tmpDir, "compose-files", "docker-compose.yaml")
-		var data []byte
-		if b, err := os.ReadFile(composePathYml); err == nil {
-			data = b
-		} else if b, err := os.ReadFile(composePathYaml); err == nil {
-			data = b
+		if err := e.restoreProjectVolumeData(ctx, tmpDir, renamer, request.Options); err != nil {
+			return nil, err
 		}
-		if len(data) > 0 {
-			ord, names := compose.OrderFromComposeYAML(data)
-			if len(ord) > 0 {
-				order = ord
-			}
-			for _, n := range names {
-				services[n] = struct{}{}
-			}
+		request.Options.projectImages = e.loadProjectImages(ctx, tmpDir)
+		if request.Options.ComposeUp {
+			return e.restoreComposeUp(ctx, tmpDir, request, renamer)
 		}
-		// Fallback: discover services by directory structure
-		if len(services) == 0 {
-			entries, _ := os.ReadDir(filepath.Join(tmpDir, "containers"))
-			for _, e2 := range entries {
-				if e2.IsDir() {
-					services[e2.Name()] = struct{}{}
-				}
+
+		order, deps := composeServiceOrder(e.filesystem, tmpDir)
+		isolatedNetwork := ""
+		if request.Options.Isolated {
+			project := request.ProjectName
+			if project == "" {
+				project = readComposeProjectName(e.filesystem, tmpDir)
 			}
-		}
-		if len(order) == 0 {
-			for s := range services {
-				order = append(order, s)
+			if project == "" {
+				project = strings.TrimSuffix(filepath.Base(request.BackupPath), ".tar.gz")
 			}
-			sort.Strings(order)
+			isolatedNetwork = isolatedNetworkName(safeName(project))
 		}
 
 		// Restore each service container tar without starting; then start all if requested
 		restored := []string{}
+		// restoredIDs are the containers of each service, the first replica first
+		restoredIDs := map[string][]string{}
 		for _, svc := range order {
-			svcDir := filepath.Join(tmpDir, "containers", svc)
-			// find a .tar.gz file inside
-			entries, _ := os.ReadDir(svcDir)
-			var tarPath string
-			for _, e2 := range entries {
-				if strings.HasSuffix(e2.Name(), ".tar.gz") {
-					tarPath = filepath.Join(svcDir, e2.Name())
-					break
-				}
-			}
+			tarPath := serviceArchive(e.filesystem, tmpDir, svc)
 			if tarPath == "" {
 				continue
 			}
-			_, err := e.Restore(ctx, RestoreRequest{BackupPath: tarPath, Options: RestoreOptions{Start: false, ReplaceExisting: request.Options.ReplaceExisting, DropHostIPs: request.Options.DropHostIPs, ReassignIPs: request.Options.ReassignIPs, FallbackBridge: request.Options.FallbackBridge, BindRestoreRoot: request.Options.BindRestoreRoot, ForceBindIP: request.Options.ForceBindIP, BindInterface: request.Options.BindInterface, DropDevices: request.Options.DropDevices, DropCaps: request.Options.DropCaps, DropSeccomp: request.Options.DropSeccomp, DropAppArmor: request.Options.DropAppArmor}})
-			if err == nil {
-				restored = append(restored, svc)
+			svcOpts := RestoreOptions{Start: false, ReplaceExisting: request.Options.ReplaceExisting, DropHostIPs: request.Options.DropHostIPs, ReassignIPs: request.Options.ReassignIPs, IPMap: request.Options.IPMap, AttachTo: request.Options.AttachTo, FallbackBridge: request.Options.FallbackBridge, BindRestoreRoot: request.Options.BindRestoreRoot, ForceBindIP: request.Options.ForceBindIP, BindInterface: request.Options.BindInterface, DropDevices: request.Options.DropDevices, DropCaps: request.Options.DropCaps, DropSeccomp: request.Options.DropSeccomp, DropAppArmor: request.Options.DropAppArmor, LoadAppArmorProfiles: request.Options.LoadAppArmorProfiles, PreserveMAC: request.Options.PreserveMAC, StrictHostConfig: request.Options.StrictHostConfig, LogDriverMap: request.Options.LogDriverMap, DefaultLogDriver: request.Options.DefaultLogDriver, DropGPUs: request.Options.DropGPUs, GPUMap: request.Options.GPUMap, InstallPlugins: request.Options.InstallPlugins, SkipExisting: request.Options.SkipExisting, NoOverwriteVolumes: request.Options.NoOverwriteVolumes, OnConflict: request.Options.OnConflict, Confirm: request.Options.Confirm, Isolated: request.Options.Isolated, isolatedNetwork: isolatedNetwork, projectImages: request.Options.projectImages}
+			res, err := e.Restore(ctx, RestoreRequest{BackupPath: tarPath, ProjectName: request.ProjectName, TargetType: TargetContainer, Options: svcOpts})
+			if err != nil {
+				e.warnings.add(Warning{Code: WarnServiceRestore, Subject: svc, Service: svc, Message: fmt.Sprintf("Could not restore service %s: %v", svc, err)})
+				e.log.Errorf("Could not restore service %s: %v", svc, err)
+				continue
+			}
+			restored = append(restored, svc)
+			restoredIDs[svc] = append(restoredIDs[svc], res.RestoredID)
+			for _, w := range res.Warnings {
+				w.Service = svc
+				e.warnings.add(w)
+			}
+			// the other replicas of a scaled service, each under its own name; the data of the
+			// volumes they share came with the first
+			for _, replica := range replicaArchives(e.filesystem, tmpDir, svc) {
+				res, err := e.Restore(ctx, RestoreRequest{BackupPath: replica, ProjectName: request.ProjectName, TargetType: TargetContainer, Options: svcOpts})
+				if err != nil {
+					e.warnings.add(Warning{Code: WarnServiceRestore, Subject: svc, Service: svc, Message: fmt.Sprintf("Could not restore a replica of service %s: %v", svc, err)})
+					e.log.Errorf("Could not restore a replica of service %s: %v", svc, err)
+					continue
+				}
+				restoredIDs[svc] = append(restoredIDs[svc], res.RestoredID)
+				for _, w := range res.Warnings {
+					w.Service = svc
+					e.warnings.add(w)
+				}
 			}
 		}
 		if request.Options.Start {
-			// Start in order and optionally wait healthy
+			// Start in order, gating each service on its depends_on conditions
 			for _, svc := range order {
-				// best-effort: assume container name == svc or was restored with original name
-				_ = execCommand(ctx, "docker", "start", svc)
+				ids := restoredIDs[svc]
+				if len(ids) == 0 {
+					continue
+				}
+				for dep, cond := range deps[svc] {
+					// a scaled dependency is waited on through its first replica
+					depIDs := restoredIDs[dep]
+					if len(depIDs) == 0 || cond == compose.ConditionStarted {
+						continue
+					}
+					depID := depIDs[0]
+					timeout := serviceWaitTimeout(request.Options, dep)
+					e.log.Infof("Waiting for %s (%s) before starting %s", dep, cond, svc)
+					var err error
+					if cond == compose.ConditionCompletedSuccessfully {
+						err = e.waitCompleted(ctx, depID, timeout)
+					} else {
+						err = e.waitHealthy(ctx, depID, timeout)
+					}
+					if err != nil {
+						return nil, &errors.OperationError{Op: fmt.Sprintf("wait for %s before starting %s", dep, svc), Err: err}
+					}
+				}
+				for _, id := range ids {
+					if err := e.dockerClient.StartContainer(ctx, id); err != nil {
+						return nil, &errors.OperationError{Op: fmt.Sprintf("start service %s", svc), Err: err}
+					}
+				}
+			}
+			if request.Options.WaitHealthy {
+				for _, svc := range order {
+					for _, id := range restoredIDs[svc] {
+						if err := e.waitHealthy(ctx, id, serviceWaitTimeout(request.Options, svc)); err != nil {
+							return nil, &errors.OperationError{Op: fmt.Sprintf("wait for service %s", svc), Err: err}
+						}
+					}
+				}
 			}
+			if request.Options.Paused {
+				// Pause once every service is up, so depends_on conditions could be met
+				for _, svc := range order {
+					for _, id := range restoredIDs[svc] {
+						if err := e.dockerClient.PauseContainer(ctx, id); err != nil {
+							return nil, &errors.OperationError{Op: fmt.Sprintf("pause service %s", svc), Err: err}
+						}
+					}
+				}
+				e.log.Infof("Services of %s started paused; resume them with docker unpause", request.ProjectName)
+			}
+		} else if request.Options.CreateOnly {
+			e.log.Infof("Services created without starting them; review them with docker inspect, then docker start them")
 		}
 		return &RestoreResult{RestoredID: strings.Join(restored, ",")}, nil
 	}
 
+	if request.Options.SkipExisting {
+		id, err := e.existingRestore(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		if id != "" {
+			e.log.Infof("Container %s was already restored from this backup; nothing to do", id)
+			return &RestoreResult{RestoredID: id}, nil
+		}
+	}
+
 	// Extract backup to temp dir
-	tmpDir, err := os.MkdirTemp("", "dockerbackup_restore_*")
+	tmpDir, err := e.filesystem.MkdirTemp(WorkDir(), "dockerbackup_restore_*")
 	if err != nil {
 		return nil, &errors.OperationError{Op: "create temp dir", Err: err}
 	}
-	defer func() { _ = os.RemoveAll(tmpDir) }()
+	defer func() { _ = e.filesystem.RemoveAll(tmpDir) }()
+	if err := checkRestoreSpace(e.filesystem, tmpDir, request.BackupPath); err != nil {
+		return nil, err
+	}
 	if err := e.archiveHandler.ExtractArchive(ctx, request.BackupPath, tmpDir); err != nil {
 		return nil, &errors.OperationError{Op: "extract backup", Err: err}
 	}
+	if err := e.checkExtractedFormat(tmpDir); err != nil {
+		return nil, err
+	}
+	if err := e.resolveVolumeRefs(ctx, tmpDir, request.BackupPath); err != nil {
+		return nil, &errors.OperationError{Op: "resolve unchanged volumes", Err: err}
+	}
+	e.setRestoreLabels(tmpDir, request.BackupPath)
+	e.logRemoteVolumes(tmpDir)
 
 	// Read container.json (docker inspect). Support both single object and array forms.
 	containerJSONPath := filepath.Join(tmpDir, "container.json")
-	b, err := os.ReadFile(containerJSONPath)
+	b, err := e.filesystem.ReadFile(containerJSONPath)
 	if err != nil {
 		return nil, &errors.OperationError{Op: "read container.json", Err: err}
 	}
-	var cj types.ContainerJSON
-	if err := json.Unmarshal(b, &cj); err != nil || cj.ContainerJSONBase == nil {
-		var arr []types.ContainerJSON
-		if err2 := json.Unmarshal(b, &arr); err2 != nil || len(arr) == 0 || arr[0].ContainerJSONBase == nil {
-			return nil, &errors.OperationError{Op: "unmarshal container.json", Err: err2}
+	cj, err := decodeContainerJSON(b)
+	if err != nil {
+		return nil, &errors.OperationError{Op: "unmarshal container.json", Err: err}
+	}
+	var checkpoint string
+	if request.Options.Checkpoint {
+		checkpoint = e.readCheckpointName(tmpDir)
+		if checkpoint == "" {
+			return nil, &errors.ValidationError{Field: "Checkpoint", Msg: "backup contains no checkpoint (create one with backup --checkpoint)"}
+		}
+		if request.Options.ImageOverride != "" {
+			return nil, &errors.ValidationError{Field: "ImageOverride", Msg: "a checkpoint can only be resumed on the image it was taken from"}
+		}
+	}
+
+	// GPU device requests: check before any resources are created so failures are cheap
+	if usesGPUs(cj.HostConfig) {
+		if request.Options.DropGPUs {
+			e.log.Infof("Dropping GPU device requests (--drop-gpus)")
+			dropGPUs(cj.HostConfig)
+		} else {
+			remapGPUDeviceIDs(cj.HostConfig, request.Options.GPUMap)
+			if !e.gpuRuntimeAvailable(ctx) {
+				return nil, &errors.ValidationError{Field: "HostConfig.DeviceRequests", Msg: "requests GPUs but the target daemon has no NVIDIA runtime; install nvidia-container-toolkit or restore with --drop-gpus"}
+			}
 		}
-		cj = arr[0]
 	}
 
-	// Prefer image load if image.tar exists; else import filesystem.tar
-	imageTar := filepath.Join(tmpDir, "image.tar")
+	// Compose project rename: derive container, network and volume renames from the project prefix
+	renamer := newProjectRenamer(composeProjectOf(cj), request.ProjectName)
+	if renamer != nil {
+		e.log.Infof("Renaming compose project %s -> %s", renamer.from, renamer.to)
+		var netNames, volNames []string
+		if cj.NetworkSettings != nil {
+			for name := range cj.NetworkSettings.Networks {
+				netNames = append(netNames, name)
+			}
+		}
+		for _, m := range cj.Mounts {
+			if m.Type == "volume" && m.Name != "" {
+				volNames = append(volNames, m.Name)
+			}
+		}
+		request.Options.NetworkMap = renamer.mapNames(netNames, request.Options.NetworkMap)
+		request.Options.VolumeMap = renamer.mapNames(volNames, request.Options.VolumeMap)
+		if request.Options.ContainerName == "" {
+			request.Options.ContainerName = renamer.name(strings.TrimPrefix(cj.Name, "/"))
+		}
+		cj.Config.Labels = renamer.labels(cj.Config.Labels)
+	}
+
+	// Settle a name collision before anything is restored; a replaced container is removed
+	// just before the new one is created
+	newName := strings.TrimPrefix(cj.Name, "/")
+	if request.Options.ContainerName != "" {
+		newName = request.Options.ContainerName
+	}
+	replaceContainer := false
+	if newName != "" {
+		var keptID string
+		newName, replaceContainer, keptID, err = e.containerConflict(ctx, request.Options, newName)
+		if err != nil {
+			return nil, err
+		}
+		if keptID != "" {
+			return &RestoreResult{RestoredID: keptID}, nil
+		}
+	}
+
+	// An image override replaces the embedded image (and the container's filesystem changes);
+	// otherwise use the image when the host already has it, else prefer loading the saved image
+	// (image.tar or image-oci/), else import filesystem.tar
 	imageRef := ""
-	if _, err := os.Stat(imageTar); err == nil {
-		if err := e.dockerClient.ImageLoad(ctx, imageTar); err == nil {
-			// Use original image reference if available; else keep empty and rely on cfg.Image overwritten later
-			imageRef = cj.ContainerJSONBase.Image
+	refs := e.readImageRefs(tmpDir)
+	exact := false
+	if override := request.Options.ImageOverride; override != "" {
+		e.log.Infof("Using image %s instead of the backed-up image", override)
+		if err := e.dockerClient.EnsureImage(ctx, override); err != nil {
+			return nil, &errors.OperationError{Op: "pull override image", Err: err}
 		}
+		imageRef = override
+	} else if cj.ContainerJSONBase != nil && e.imagePresent(ctx, cj.ContainerJSONBase.Image) {
+		e.log.Infof("Image %s is already present; not loading it again", cj.ContainerJSONBase.Image)
+		imageRef, exact = cj.ContainerJSONBase.Image, true
+	} else if loaded, err := e.loadSavedImage(ctx, tmpDir); loaded && err == nil {
+		// Use original image reference if available; else keep empty and rely on cfg.Image overwritten later
+		imageRef = cj.ContainerJSONBase.Image
+		exact = true
+	} else if cj.ContainerJSONBase != nil && request.Options.projectImages[cj.ContainerJSONBase.Image] {
+		// loaded once for the whole project
+		imageRef, exact = cj.ContainerJSONBase.Image, true
+	} else if pinned := e.pullPinnedImage(ctx, refs); pinned != "" {
+		imageRef, exact = pinned, true
 	}
 	if imageRef == "" {
 		fsTarPath := filepath.Join(tmpDir, "filesystem.tar")
-		if _, err := os.Stat(fsTarPath); err == nil {
-			imgID, err := e.dockerClient.ImportImage(ctx, fsTarPath, "")
+		if _, err := e.filesystem.Stat(fsTarPath); err == nil {
+			imgID, err := e.dockerClient.ImportImage(ctx, fsTarPath, "", e.restoreLabels)
 			if err != nil {
 				return nil, &errors.OperationError{Op: "docker import image", Err: err}
 			}
@@ -552,23 +1094,55 @@ func (e *DefaultBackupEngine) Restore(ctx context.Context, request RestoreReques
 			return nil, &errors.OperationError{Op: "filesystem.tar missing", Err: err}
 		}
 	}
-	// If cj.Config.Image looks like repo:tag and we loaded/imported an image ID, retag the ID to that name
-	if cj.Config != nil && cj.Config.Image != "" && imageRef != "" {
-		_ = e.dockerClient.TagImage(ctx, imageRef, cj.Config.Image)
+	// Give a loaded or pulled image all its tags back; an imported filesystem only gets the
+	// name the container was created with
+	if cj.Config != nil && imageRef != "" && request.Options.ImageOverride == "" {
+		if !exact {
+			refs = nil
+		}
+		e.tagRestoredImage(ctx, imageRef, refs, cj.Config.Image)
 	}
 
 	// Load saved volume and network configs if present
 	volCfgs := []docker.VolumeConfig{}
-	if b, err := os.ReadFile(filepath.Join(tmpDir, "volumes", "volume_configs.json")); err == nil {
+	if b, err := e.filesystem.ReadFile(filepath.Join(tmpDir, "volumes", "volume_configs.json")); err == nil {
 		_ = json.Unmarshal(b, &volCfgs)
 	}
 	netCfgs := []docker.NetworkConfig{}
-	if b, err := os.ReadFile(filepath.Join(tmpDir, "networks", "network_configs.json")); err == nil {
+	if b, err := e.filesystem.ReadFile(filepath.Join(tmpDir, "networks", "network_configs.json")); err == nil {
 		_ = json.Unmarshal(b, &netCfgs)
 	}
 
+	// --attach-to replaces the saved networks with the existing one given
+	if request.Options.AttachTo != "" {
+		target, err := e.attachTarget(ctx, request.Options.AttachTo)
+		if err != nil {
+			return nil, err
+		}
+		e.attachToNetwork(&cj, netCfgs, target)
+		netCfgs = nil
+	}
+
+	// Networks that exist already are reused, renamed or replaced
+	for _, nc := range netCfgs {
+		name := nc.Name
+		if m, ok := request.Options.NetworkMap[nc.Name]; ok && m != "" {
+			name = m
+		}
+		use, err := e.networkConflict(ctx, request.Options, name)
+		if err != nil {
+			return nil, err
+		}
+		if use != name {
+			if request.Options.NetworkMap == nil {
+				request.Options.NetworkMap = map[string]string{}
+			}
+			request.Options.NetworkMap[nc.Name] = use
+		}
+	}
+
 	// Apply network name mapping to cj.NetworkSettings before creating netCfg
-	if cj.NetworkSettings != nil && cj.NetworkSettings.Networks != nil && len(request.Options.NetworkMap) > 0 {
+	if request.Options.AttachTo == "" && cj.NetworkSettings != nil && cj.NetworkSettings.Networks != nil && len(request.Options.NetworkMap) > 0 {
 		mapped := map[string]*network.EndpointSettings{}
 		for name, ns := range cj.NetworkSettings.Networks {
 			newName := name
@@ -585,20 +1159,24 @@ func (e *DefaultBackupEngine) Restore(ctx context.Context, request RestoreReques
 		if newName, ok := request.Options.NetworkMap[nc.Name]; ok && newName != "" {
 			nc.Name = newName
 		}
+		nc.Labels = renamer.labels(nc.Labels)
+		resolved := true
 		if parent, ok := request.Options.ParentMap[nc.Name]; ok && parent != "" {
 			if nc.Options == nil {
 				nc.Options = map[string]string{}
 			}
 			nc.Options["parent"] = parent
+		} else {
+			resolved = e.resolveParent(ctx, &nc)
 		}
-		// If still macvlan/ipvlan and no parent present and fallbackBridge is set, convert to bridge
+		// If still macvlan/ipvlan and no usable parent and fallbackBridge is set, convert to bridge
 		if request.Options.FallbackBridge {
-			if (nc.Driver == "macvlan" || nc.Driver == "ipvlan") && (nc.Options == nil || nc.Options["parent"] == "") {
+			if (nc.Driver == "macvlan" || nc.Driver == "ipvlan") && (nc.Options == nil || nc.Options["parent"] == "" || !resolved) {
 				nc.Driver = "bridge"
 				delete(nc.Options, "parent")
 			}
 		}
-		_ = e.dockerClient.EnsureNetwork(ctx, nc)
+		e.ensureNetwork(ctx, nc)
 	}
 
 	// Effective mounts from inspect
@@ -616,37 +1194,44 @@ func (e *DefaultBackupEngine) Restore(ctx context.Context, request RestoreReques
 		})
 	}
 
-	// Ensure volumes exist using captured driver/options before data restore
-	for _, vc := range volCfgs {
-		_ = e.dockerClient.EnsureVolume(ctx, vc)
-	}
-
-	// Restore volumes and bind mounts data; create volumes using VolumeCreate (driver/options not yet wired into CLI variant)
+	// Volumes that already hold data are overwritten, kept or restored under another name
+	keep := map[string]bool{}
 	for _, m := range effectiveMounts {
-		if m.Type == "volume" && m.Name != "" {
-			if err := e.dockerClient.VolumeCreate(ctx, m.Name); err != nil {
-				return nil, &errors.OperationError{Op: fmt.Sprintf("create volume %s", m.Name), Err: err}
-			}
-			volTarGz := filepath.Join(tmpDir, "volumes", fmt.Sprintf("%s.tar.gz", m.Name))
-			if _, err := os.Stat(volTarGz); err == nil {
-				if err := e.dockerClient.ExtractTarGzToVolume(ctx, m.Name, volTarGz, m.Name); err != nil {
-					return nil, &errors.OperationError{Op: fmt.Sprintf("restore volume %s", m.Name), Err: err}
-				}
-			}
+		if m.Type != "volume" || m.Name == "" {
+			continue
 		}
-		if m.Type == "bind" && m.Source != "" {
-			base := filepath.Base(m.Source)
-			bindName := fmt.Sprintf("bind_%s", safeName(base))
-			bindTarGz := filepath.Join(tmpDir, "volumes", fmt.Sprintf("%s.tar.gz", bindName))
-			if _, err := os.Stat(bindTarGz); err == nil {
-				if err := os.MkdirAll(m.Source, 0o755); err != nil {
-					return nil, &errors.OperationError{Op: fmt.Sprintf("mkdir bind path %s", m.Source), Err: err}
-				}
-				if err := extractTarGzToHost(ctx, bindTarGz, m.Source, base); err != nil {
-					return nil, &errors.OperationError{Op: fmt.Sprintf("restore bind mount %s", m.Source), Err: err}
-				}
+		target := m.Name
+		if mapped, ok := request.Options.VolumeMap[m.Name]; ok && mapped != "" {
+			target = mapped
+		}
+		use, skip, err := e.volumeConflict(ctx, request.Options, target)
+		if err != nil {
+			return nil, err
+		}
+		if use != target {
+			if request.Options.VolumeMap == nil {
+				request.Options.VolumeMap = map[string]string{}
 			}
+			request.Options.VolumeMap[m.Name] = use
+		}
+		keep[use] = skip
+	}
+
+	// Ensure volumes exist using captured driver/options before data restore
+	if err := e.ensureVolumeDrivers(ctx, tmpDir, volCfgs, request.Options.InstallPlugins); err != nil {
+		return nil, err
+	}
+	for _, vc := range volCfgs {
+		if newName, ok := request.Options.VolumeMap[vc.Name]; ok && newName != "" {
+			vc.Name = newName
 		}
+		vc.Labels = renamer.labels(vc.Labels)
+		e.ensureVolume(ctx, vc)
+	}
+
+	// Restore volumes and bind mounts data
+	if err := e.restoreMountData(ctx, tmpDir, effectiveMounts, request.Options.VolumeMap, keep); err != nil {
+		return nil, err
 	}
 
 	// Build Docker SDK Config/HostConfig/NetworkingConfig from inspect
@@ -665,7 +1250,7 @@ func (e *DefaultBackupEngine) Restore(ctx context.Context, request RestoreReques
 		hostIPs, _ := e.dockerClient.HostIPs(ctx)
 		present := map[string]struct{}{}
 		for _, ip := range hostIPs {
-			present[ip] = struct{}{}
+			present[canonicalIP(ip)] = struct{}{}
 		}
 		for port, bindings := range hostCfg.PortBindings {
 			filtered := bindings[:0]
@@ -675,10 +1260,10 @@ func (e *DefaultBackupEngine) Restore(ctx context.Context, request RestoreReques
 					filtered = append(filtered, b)
 					continue
 				}
-				if _, ok := present[b.HostIP]; ok {
+				if _, ok := present[canonicalIP(b.HostIP)]; ok {
 					filtered = append(filtered, b)
 				} else {
-					e.log.Infof("Port binding HostIp %s not present; skipping binding for %s", b.HostIP, port)
+					e.warn(WarnHostIPDropped, string(port), "Port binding HostIp %s not present; skipping binding for %s", b.HostIP, port)
 				}
 			}
 			hostCfg.PortBindings[port] = filtered
@@ -690,41 +1275,66 @@ func (e *DefaultBackupEngine) Restore(ctx context.Context, request RestoreReques
 	conflictingStaticIP := false
 	if cj.NetworkSettings != nil && cj.NetworkSettings.Networks != nil {
 		for name, ns := range cj.NetworkSettings.Networks {
-			ep := &network.EndpointSettings{Aliases: ns.Aliases}
+			ep := &network.EndpointSettings{
+				Aliases:    restorableAliases(ns.Aliases, cj.ID),
+				Links:      normalizeLinks(ns.Links),
+				DriverOpts: ns.DriverOpts,
+			}
+			if request.Options.PreserveMAC {
+				ep.MacAddress = ns.MacAddress
+			}
 			ipam := ns.IPAMConfig
-			// simple conflict check: if IPAMConfig has IPv4 address and subnet overlaps with an existing interface network, mark conflict
-			if ipam != nil && ipam.IPv4Address != "" {
-				if conflictWithHostIPv4(ipam.IPv4Address) {
-					conflictingStaticIP = true
-				}
+			if ipam != nil && len(request.Options.IPMap) > 0 {
+				mapped := *ipam
+				mapped.IPv4Address = mapStaticIP(mapped.IPv4Address, request.Options.IPMap)
+				mapped.IPv6Address = mapStaticIP(mapped.IPv6Address, request.Options.IPMap)
+				ipam = &mapped
+			}
+			// simple conflict check: if a static IPv4 or IPv6 address lies in a subnet of a host interface, mark conflict
+			if ipam != nil && (conflictWithHostIP(ipam.IPv4Address) || conflictWithHostIP(ipam.IPv6Address)) {
+				conflictingStaticIP = true
 			}
 			if request.Options.ReassignIPs || (request.Options.AutoRelaxIPs && conflictingStaticIP) {
 				ep.IPAMConfig = nil
 			} else {
-				ep.IPAMConfig = ns.IPAMConfig
+				ep.IPAMConfig = ipam
 			}
 			netCfg.EndpointsConfig[name] = ep
 		}
 	}
 
-	// Replacement: if a container with target name exists, remove it when ReplaceExisting
-	newName := cj.Name
-	if strings.HasPrefix(newName, "/") {
-		newName = strings.TrimPrefix(newName, "/")
-	}
-	if request.Options.ContainerName != "" {
-		newName = request.Options.ContainerName
-	}
-	if request.Options.ReplaceExisting && newName != "" {
+	// Replacement: remove the existing container of the target name (--on-conflict name=replace)
+	if replaceContainer {
 		// best-effort remove existing
-		_ = execCommand(ctx, "docker", "rm", "-f", newName)
+		_ = e.dockerClient.RemoveContainer(ctx, newName)
 	}
+	// checked once a replaced container has released its addresses
+	e.checkStaticIPs(ctx, netCfg)
 
 	// Adjust HostConfig for safe-mode drops
 	hostCfg = cj.HostConfig
 	if hostCfg == nil {
 		hostCfg = &container.HostConfig{}
 	}
+	hostCfg.Links = normalizeLinks(hostCfg.Links)
+	remapVolumeRefs(hostCfg, request.Options.VolumeMap)
+	if len(request.Options.LogDriverMap) > 0 || request.Options.DefaultLogDriver != "" {
+		var available map[string]bool
+		if request.Options.DefaultLogDriver != "" {
+			if drivers, err := e.dockerClient.LogDrivers(ctx); err == nil {
+				available = map[string]bool{}
+				for _, d := range drivers {
+					available[d] = true
+				}
+			} else {
+				e.warn(WarnLogDriver, hostCfg.LogConfig.Type, "Could not list log drivers on target; --default-log-driver not applied: %v", err)
+			}
+		}
+		if lc, changed := remapLogConfig(hostCfg.LogConfig, request.Options.LogDriverMap, request.Options.DefaultLogDriver, available); changed {
+			e.warn(WarnLogDriver, hostCfg.LogConfig.Type, "Log driver %s remapped to %s", hostCfg.LogConfig.Type, lc.Type)
+			hostCfg.LogConfig = lc
+		}
+	}
 	if request.Options.DropDevices {
 		hostCfg.Devices = nil
 	}
@@ -745,16 +1355,27 @@ func (e *DefaultBackupEngine) Restore(ctx context.Context, request RestoreReques
 		}
 		hostCfg.SecurityOpt = filtered
 	}
+	e.restoreSecurityProfiles(ctx, filepath.Join(tmpDir, securityDirName), hostCfg, request.Options)
 
 	// Bind restore root: relocate missing bind sources
 	if request.Options.BindRestoreRoot != "" {
+		bases := map[string]int{}
+		for _, m := range hostCfg.Mounts {
+			if m.Type == "bind" && m.Source != "" {
+				bases[filepath.Base(m.Source)]++
+			}
+		}
 		for i := range hostCfg.Mounts {
 			m := &hostCfg.Mounts[i]
 			if m.Type == "bind" && m.Source != "" {
-				if _, err := os.Stat(m.Source); os.IsNotExist(err) {
+				if _, err := e.filesystem.Stat(m.Source); os.IsNotExist(err) {
 					base := filepath.Base(m.Source)
+					if bases[base] > 1 {
+						// sources sharing a base name (/a/data, /b/data) must not share a directory
+						base = strings.TrimPrefix(bindArchiveName(m.Source), "bind_")
+					}
 					newSrc := filepath.Join(request.Options.BindRestoreRoot, base)
-					_ = os.MkdirAll(newSrc, 0o755)
+					_ = e.filesystem.EnsureDir(newSrc, 0o755)
 					m.Source = newSrc
 				}
 			}
@@ -769,25 +1390,35 @@ func (e *DefaultBackupEngine) Restore(ctx context.Context, request RestoreReques
 
 	// Ports: apply force-bind-ip or bind-interface preference
 	if hostCfg.PortBindings != nil {
-		// If bind-interface set, try to pick its IP
-		preferredIP := request.Options.ForceBindIP
-		if preferredIP == "" && request.Options.BindInterface != "" {
-			if ip, err := primaryIPv4OfInterface(request.Options.BindInterface); err == nil {
-				preferredIP = ip
-			}
+		// If bind-interface set, try to pick its IPs, keeping the address family of each binding
+		var ipv4, ipv6 string
+		if request.Options.ForceBindIP != "" {
+			ipv4, ipv6 = request.Options.ForceBindIP, request.Options.ForceBindIP
+		} else if request.Options.BindInterface != "" {
+			ipv4, ipv6, _ = primaryIPsOfInterface(request.Options.BindInterface)
 		}
-		if preferredIP != "" {
+		if ipv4 != "" || ipv6 != "" {
 			for port, bindings := range hostCfg.PortBindings {
-				for i := range bindings {
-					bindings[i].HostIP = preferredIP
-				}
-				hostCfg.PortBindings[port] = bindings
+				hostCfg.PortBindings[port] = rebindPorts(bindings, ipv4, ipv6)
 			}
 		}
 	}
 
-	// Determine new name (already computed above)
-	// newName is ready
+	if request.Options.Isolated {
+		netName := request.Options.isolatedNetwork
+		if netName == "" {
+			netName = isolatedNetworkName(newName)
+		}
+		if err := e.isolate(ctx, cfg, hostCfg, netCfg, netName, strings.TrimPrefix(cj.Name, "/")); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := e.portConflicts(request.Options, hostCfg.PortBindings); err != nil {
+		return nil, err
+	}
+
+	cfg.Labels = withLabels(cfg.Labels, e.restoreLabels)
 
 	// Prefer SDK-based creation if available
 	containerID, err := e.dockerClient.CreateContainerFromSpec(ctx, cfg, hostCfg, netCfg, newName)
@@ -797,67 +1428,304 @@ func (e *DefaultBackupEngine) Restore(ctx context.Context, request RestoreReques
 	if err != nil {
 		var mounts []docker.Mount
 		for _, m := range effectiveMounts {
-			mounts = append(mounts, docker.Mount{Name: m.Name, Source: m.Source, Destination: m.Destination, Type: m.Type, RW: m.RW})
+			name := m.Name
+			if mapped, ok := request.Options.VolumeMap[name]; ok && mapped != "" && m.Type == "volume" {
+				name = mapped
+			}
+			mounts = append(mounts, docker.Mount{Name: name, Source: m.Source, Destination: m.Destination, Type: m.Type, RW: m.RW})
 		}
-		containerID, err = e.dockerClient.CreateContainer(ctx, imageRef, newName, mounts)
+		containerID, err = e.dockerClient.CreateContainer(ctx, imageRef, newName, mounts, createOptions(cfg, hostCfg, netCfg))
 		if err != nil {
 			return nil, &errors.OperationError{Op: "docker create", Err: err}
 		}
 	}
+	e.journal.addContainer(containerID)
+	if err := e.verifyHostConfig(ctx, containerID, hostCfg, request.Options.StrictHostConfig); err != nil {
+		return nil, err
+	}
 
-	if request.Options.Start {
+	if checkpoint != "" {
+		e.log.Infof("Resuming container %s from checkpoint %s", containerID, checkpoint)
+		if err := e.dockerClient.StartContainerFromCheckpoint(ctx, containerID, checkpoint, filepath.Join(tmpDir, checkpointDirName)); err != nil {
+			return nil, &errors.OperationError{Op: "docker start --checkpoint", Err: err}
+		}
+	} else if request.Options.Start {
 		if err := e.dockerClient.StartContainer(ctx, containerID); err != nil {
 			return nil, &errors.OperationError{Op: "docker start", Err: err}
 		}
+	}
+	if checkpoint != "" || request.Options.Start {
 		if request.Options.WaitHealthy {
 			// If no healthcheck defined in the original inspect, skip waiting
 			noHealthcheck := cj.ContainerJSONBase == nil || cj.ContainerJSONBase.State == nil || cj.ContainerJSONBase.State.Health == nil
 			if !noHealthcheck {
-				timeout := time.Duration(request.Options.WaitTimeoutSeconds) * time.Second
-				if timeout <= 0 {
-					timeout = 2 * time.Minute
-				}
-				deadline := time.Now().Add(timeout)
-				for {
-					if time.Now().After(deadline) {
-						return &RestoreResult{RestoredID: containerID}, nil
-					}
-					status, health, _ := e.dockerClient.ContainerState(ctx, containerID)
-					if status == "exited" || status == "dead" || status == "removing" {
-						return &RestoreResult{RestoredID: containerID}, nil
-					}
-					if health == "healthy" {
-						break
-					}
-					time.Sleep(2 * time.Second)
+				if err := e.waitHealthy(ctx, containerID, serviceWaitTimeout(request.Options, "")); err != nil {
+					e.warn(WarnContainerUnhealthy, containerID, "Container %s not healthy: %v", containerID, err)
 				}
 			}
 		}
+		if request.Options.Paused {
+			if err := e.dockerClient.PauseContainer(ctx, containerID); err != nil {
+				return nil, &errors.OperationError{Op: "docker pause", Err: err}
+			}
+			e.log.Infof("Container %s started paused; resume it with docker unpause %s", containerID, containerID)
+		}
+	} else if request.Options.CreateOnly {
+		e.log.Infof("Container %s created without starting it; review it with docker inspect %s, then docker start it", containerID, containerID)
 	}
 	return &RestoreResult{RestoredID: containerID}, nil
 }
 
+// restoreMountData recreates named volumes and bind mount sources and fills them from the
+// archives under <dir>/volumes; create volumes using VolumeCreate (driver/options not yet wired into CLI variant).
+// Volumes are looked up by their original name and restored under volumeMap[name] when mapped;
+// the data of volumes in keep is left as it is. Archives are found through mounts.json, or
+// by their derived names in older backups.
+func (e *DefaultBackupEngine) restoreMountData(ctx context.Context, dir string, mounts []docker.Mount, volumeMap map[string]string, keep map[string]bool) error {
+	recorded := e.readMountMap(dir)
+	for _, m := range mounts {
+		if m.Type == "volume" && m.Name != "" {
+			target := m.Name
+			if mapped, ok := volumeMap[m.Name]; ok && mapped != "" {
+				target = mapped
+			}
+			if err := e.createVolume(ctx, target); err != nil {
+				return &errors.OperationError{Op: fmt.Sprintf("create volume %s", target), Err: err}
+			}
+			volTarGz := filepath.Join(dir, "volumes", fmt.Sprintf("%s.tar.gz", safeName(m.Name)))
+			root := m.Name
+			a, ok := recorded.volume(m.Name)
+			if ok {
+				volTarGz = artifactPath(dir, a)
+				if a.Root != "" {
+					root = a.Root
+				}
+			}
+			if _, err := e.filesystem.Stat(volTarGz); err == nil && !keep[target] {
+				if err := e.restoreVolumeData(ctx, target, volTarGz, root, a); err != nil {
+					return err
+				}
+			}
+		}
+		if m.Type == "bind" && m.Source != "" {
+			base := filepath.Base(m.Source)
+			bindTarGz := filepath.Join(dir, "volumes", bindArchiveName(m.Source)+".tar.gz")
+			a, ok := recorded.bind(m.Source)
+			if ok {
+				bindTarGz = artifactPath(dir, a)
+				if a.Root != "" {
+					base = a.Root
+				}
+			} else if _, err := e.filesystem.Stat(bindTarGz); err != nil {
+				bindTarGz = filepath.Join(dir, "volumes", legacyBindArchiveName(m.Source)+".tar.gz")
+			}
+			if _, err := e.filesystem.Stat(bindTarGz); err == nil {
+				if err := e.filesystem.EnsureDir(m.Source, 0o755); err != nil {
+					return &errors.OperationError{Op: fmt.Sprintf("mkdir bind path %s", m.Source), Err: err}
+				}
+				ids := e.ownerMap(a, true)
+				if !ids.Empty() && os.Geteuid() != 0 {
+					e.warn(WarnOwnership, m.Source, "Not running as root; files restored to %s keep their recorded owners instead of being mapped (--uid-map/--gid-map, userns-remap)", m.Source)
+				}
+				if err := extractTarGzToHost(ctx, bindTarGz, m.Source, base, ids); err != nil {
+					return &errors.OperationError{Op: fmt.Sprintf("restore bind mount %s", m.Source), Err: err}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// waitHealthy polls the container until its healthcheck reports healthy. Containers without a
+// healthcheck are considered ready once running.
+func (e *DefaultBackupEngine) waitHealthy(ctx context.Context, containerID string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, health, err := e.dockerClient.ContainerState(ctx, containerID)
+		if err == nil {
+			if status == "exited" || status == "dead" || status == "removing" {
+				return fmt.Errorf("container %s is %s", containerID, status)
+			}
+			if health == "healthy" || (health == "" && status == "running") {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for %s to become healthy", timeout, containerID)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// waitCompleted polls the container until it has exited (service_completed_successfully).
+func (e *DefaultBackupEngine) waitCompleted(ctx context.Context, containerID string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if status, _, err := e.dockerClient.ContainerState(ctx, containerID); err == nil && status == "exited" {
+			// service_completed_successfully: a dependency that failed must not let its
+			// dependents start
+			code, err := e.exitCode(ctx, containerID)
+			if err != nil {
+				return err
+			}
+			if code != 0 {
+				return fmt.Errorf("%s exited with code %d", containerID, code)
+			}
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for %s to complete", timeout, containerID)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// exitCode returns the exit code of a container that has exited.
+func (e *DefaultBackupEngine) exitCode(ctx context.Context, containerID string) (int, error) {
+	b, err := e.dockerClient.InspectContainer(ctx, containerID)
+	if err != nil {
+		return 0, err
+	}
+	cj, err := docker.ParseContainerJSON(b)
+	if err != nil || cj.State == nil {
+		return 0, fmt.Errorf("cannot tell the exit code of %s", containerID)
+	}
+	return cj.State.ExitCode, nil
+}
+
+// serviceWaitTimeout returns the per-service timeout if configured, else the global one.
+func serviceWaitTimeout(opts RestoreOptions, service string) time.Duration {
+	secs := opts.WaitTimeoutSeconds
+	if s, ok := opts.ServiceWaitTimeouts[service]; ok && s > 0 {
+		secs = s
+	}
+	if secs <= 0 {
+		return 2 * time.Minute
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// gpuRuntimeAvailable reports whether the daemon can satisfy GPU requests. Only the daemon
+// can tell: the tools on the host running dockerbackup say nothing about a remote one.
+func (e *DefaultBackupEngine) gpuRuntimeAvailable(ctx context.Context) bool {
+	runtimes, _ := e.dockerClient.Runtimes(ctx)
+	info, _ := e.dockerClient.DaemonInfo(ctx)
+	return daemonHasGPUs(runtimes, info)
+}
+
+// daemonHasGPUs reports whether a daemon with these runtimes and `docker info` output has
+// NVIDIA GPUs to hand out: through a registered nvidia runtime, or GPUs it discovered as CDI
+// devices (Docker 28 and later).
+func daemonHasGPUs(runtimes []string, info []byte) bool {
+	if slices.Contains(runtimes, "nvidia") {
+		return true
+	}
+	var di struct {
+		DiscoveredDevices []struct {
+			Source string
+			ID     string
+		}
+	}
+	_ = json.Unmarshal(info, &di)
+	for _, d := range di.DiscoveredDevices {
+		if d.Source == "cdi" && strings.HasPrefix(d.ID, "nvidia.com/gpu=") {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyHostConfig inspects the created container and reports HostConfig fields the target
+// daemon did not apply. In strict mode the container is removed and an error is returned.
+func (e *DefaultBackupEngine) verifyHostConfig(ctx context.Context, containerID string, want *container.HostConfig, strict bool) error {
+	b, err := e.dockerClient.InspectContainer(ctx, containerID)
+	var got types.ContainerJSON
+	if err == nil {
+		got, err = decodeContainerJSON(b)
+	}
+	if err != nil {
+		if strict {
+			_ = e.dockerClient.RemoveContainer(ctx, containerID)
+			return &errors.OperationError{Op: "verify host config", Err: err}
+		}
+		e.log.Debugf("Skipping HostConfig verification for %s: %v", containerID, err)
+		return nil
+	}
+	unsupported := hostConfigMismatches(want, got.HostConfig)
+	if len(unsupported) == 0 {
+		return nil
+	}
+	if strict {
+		_ = e.dockerClient.RemoveContainer(ctx, containerID)
+		return &errors.OperationError{Op: "strict host config", Err: fmt.Errorf("fields not applied by target daemon: %s", strings.Join(unsupported, ", "))}
+	}
+	e.warn(WarnHostConfig, strings.Join(unsupported, ","), "HostConfig fields not applied by target daemon: %s", strings.Join(unsupported, ", "))
+	return nil
+}
+
 func (e *DefaultBackupEngine) Validate(ctx context.Context, backupPath string) (*ValidationResult, error) {
-	entries, err := e.archiveHandler.ListArchive(ctx, backupPath)
+	return e.validate(ctx, backupPath, false)
+}
+
+// ValidateDeep runs Validate and then reads the whole archive: gzip CRCs and lengths, tar
+// headers, the nested service, volume, filesystem and image archives, and that container.json
+// and metadata.json parse.
+func (e *DefaultBackupEngine) ValidateDeep(ctx context.Context, backupPath string) (*ValidationResult, error) {
+	return e.validate(ctx, backupPath, true)
+}
+
+func (e *DefaultBackupEngine) validate(ctx context.Context, backupPath string, deep bool) (*ValidationResult, error) {
+	var res *ValidationResult
+	var err error
+	if kind, kerr := detectTargetType(ctx, e.archiveReader(), backupPath); kerr == nil && kind == TargetCompose {
+		res, err = e.validateCompose(ctx, backupPath, deep)
+	} else {
+		res, err = e.validateContainer(ctx, backupPath, deep)
+	}
+	if err != nil || !res.Valid || !deep {
+		return res, err
+	}
+	rc, err := archive.OpenArchive(backupPath)
 	if err != nil {
-		return nil, &errors.OperationError{Op: "list archive", Err: err}
+		return nil, &errors.OperationError{Op: "open archive", Err: err}
 	}
+	vr, err := archive.Verify(ctx, rc)
+	_ = rc.Close()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return &ValidationResult{Valid: false, Details: "corrupt archive: " + err.Error()}, nil
+	}
+	res.Details += fmt.Sprintf("; %d entries and %d bytes read without errors", vr.Entries, vr.Bytes)
+	return res, nil
+}
+
+// validateContainer checks the layout and format of a container backup; deep also parses
+// container.json and metadata.json.
+func (e *DefaultBackupEngine) validateContainer(ctx context.Context, backupPath string, deep bool) (*ValidationResult, error) {
 	// Required top-level items
 	required := map[string]bool{
 		"container.json": false,
 		"filesystem.tar": false,
 		"metadata.json":  false,
 	}
-	for _, en := range entries {
-		// Normalize names to forward slashes in tar
-		switch en.Path {
-		case "container.json":
-			required["container.json"] = true
-		case "filesystem.tar":
-			required["filesystem.tar"] = true
-		case "metadata.json":
-			required["metadata.json"] = true
+	err := e.archiveHandler.WalkArchive(ctx, backupPath, func(en archive.ArchiveEntry) error {
+		if _, ok := required[en.Path]; ok {
+			required[en.Path] = true
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, &errors.OperationError{Op: "list archive", Err: err}
 	}
 	missing := make([]string, 0)
 	for name, ok := range required {
@@ -871,135 +1739,286 @@ func (e *DefaultBackupEngine) Validate(ctx context.Context, backupPath string) (
 			Details: fmt.Sprintf("missing required entries: %v", missing),
 		}, nil
 	}
+	f, err := readArchiveFormat(ctx, e.archiveReader(), backupPath)
+	if err != nil {
+		return &ValidationResult{Valid: false, Details: err.Error()}, nil
+	}
+	note, err := checkFormat(f)
+	if err != nil {
+		return &ValidationResult{Valid: false, Details: err.Error()}, nil
+	}
+	if deep {
+		if res := checkContainerEntries(ctx, e.archiveReader(), backupPath); res != nil {
+			return res, nil
+		}
+	}
+	if note != "" {
+		return &ValidationResult{Valid: true, Details: "backup structure is valid; " + note}, nil
+	}
 	return &ValidationResult{Valid: true, Details: "backup structure is valid"}, nil
 }
 
+// safeName turns a container, project, volume or host name into a file name component:
+// path separators, whitespace, control characters and characters Windows rejects become '-',
+// other Unicode is kept, and the result is shortened to archive.MaxNameLen bytes.
 func safeName(name string) string {
 	if name == "" {
 		return "container"
 	}
-	// Replace path separators and spaces
-	s := name
-	replacer := []struct{ old, new string }{
-		{"/", "-"}, {"\\", "-"}, {" ", "-"}, {":", "-"}, {"\t", "-"},
+	s := strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || unicode.IsSpace(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '-'
+		}
+		return r
+	}, name)
+	// "." and ".." would name the directory itself or its parent
+	if strings.Trim(s, ".") == "" {
+		s = strings.Repeat("_", len(s))
+	}
+	return archive.ShortenName(s)
+}
+
+// extractTarGzToHost restores a bind mount archive into destDir; the archive's root directory
+// (the bind source's base name) maps to destDir itself. Owners are mapped through ids, and
+// recorded SELinux labels are restored.
+func extractTarGzToHost(ctx context.Context, tarGzPath string, destDir string, expectedRoot string, ids archive.IDMap) error {
+	return archive.ExtractTarGz(ctx, tarGzPath, destDir, archive.ExtractOptions{StripRoot: expectedRoot, IDs: ids, SELinuxLabels: true})
+}
+
+// createOptions translates the container spec into the settings the docker create fallback
+// applies: environment, entrypoint and command, published ports, restart policy and networks.
+func createOptions(cfg *container.Config, hostCfg *container.HostConfig, netCfg *network.NetworkingConfig) docker.CreateOptions {
+	opts := docker.CreateOptions{
+		Labels:     cfg.Labels,
+		Env:        cfg.Env,
+		Entrypoint: cfg.Entrypoint,
+		Cmd:        cfg.Cmd,
+		User:       cfg.User,
+		WorkingDir: cfg.WorkingDir,
+	}
+	ports := make([]string, 0, len(hostCfg.PortBindings))
+	for p := range hostCfg.PortBindings {
+		ports = append(ports, string(p))
+	}
+	slices.Sort(ports)
+	for _, p := range ports {
+		for _, b := range hostCfg.PortBindings[nat.Port(p)] {
+			opts.Ports = append(opts.Ports, docker.PortBinding{HostIP: b.HostIP, HostPort: b.HostPort, ContainerPort: p})
+		}
+	}
+	for p := range cfg.ExposedPorts {
+		if _, ok := hostCfg.PortBindings[p]; !ok {
+			opts.ExposedPorts = append(opts.ExposedPorts, string(p))
+		}
+	}
+	slices.Sort(opts.ExposedPorts)
+	if rp := hostCfg.RestartPolicy; rp.Name != "" && rp.Name != "no" {
+		opts.RestartPolicy = string(rp.Name)
+		if rp.MaximumRetryCount > 0 {
+			opts.RestartPolicy += fmt.Sprintf(":%d", rp.MaximumRetryCount)
+		}
+	}
+	mode := string(hostCfg.NetworkMode)
+	if mode == "host" || mode == "none" || strings.HasPrefix(mode, "container:") {
+		opts.NetworkMode = mode
+		return opts
+	}
+	for _, name := range docker.EndpointOrder(mode, netCfg.EndpointsConfig) {
+		n := docker.NetworkAttachment{Name: name}
+		// the default bridge takes no aliases or static addresses
+		if ep := netCfg.EndpointsConfig[name]; ep != nil && name != "bridge" {
+			n.Aliases = ep.Aliases
+			if ep.IPAMConfig != nil {
+				n.IPv4, n.IPv6 = ep.IPAMConfig.IPv4Address, ep.IPAMConfig.IPv6Address
+			}
+		}
+		opts.Networks = append(opts.Networks, n)
 	}
-	for _, r := range replacer {
-		s = stringReplaceAll(s, r.old, r.new)
+	if len(opts.Networks) == 0 && mode != "" && mode != "default" {
+		opts.Networks = []docker.NetworkAttachment{{Name: mode}}
 	}
-	return s
+	return opts
 }
 
-func stringReplaceAll(s, old, new string) string {
-	for {
-		idx := indexOf(s, old)
-		if idx < 0 {
-			return s
+// normalizeLinks converts links as reported by inspect ("/db:/web/db") into the
+// "name:alias" form accepted on create.
+func normalizeLinks(links []string) []string {
+	if len(links) == 0 {
+		return links
+	}
+	out := make([]string, 0, len(links))
+	for _, l := range links {
+		parts := strings.SplitN(l, ":", 2)
+		name := strings.TrimPrefix(parts[0], "/")
+		if name == "" {
+			continue
 		}
-		s = s[:idx] + new + s[idx+len(old):]
+		alias := name
+		if len(parts) == 2 && parts[1] != "" {
+			alias = filepath.Base(parts[1])
+		}
+		out = append(out, name+":"+alias)
 	}
+	return out
 }
 
-func indexOf(s, sub string) int {
-	// naive search; avoids importing strings to keep imports compact here
-	n := len(s)
-	m := len(sub)
-	if m == 0 {
-		return 0
+// restorableAliases drops the short container ID Docker adds as an implicit alias,
+// since it refers to the original container and would be stale after restore.
+func restorableAliases(aliases []string, containerID string) []string {
+	if len(aliases) == 0 {
+		return aliases
+	}
+	shortID := containerID
+	if len(shortID) > 12 {
+		shortID = shortID[:12]
 	}
-	for i := 0; i+m <= n; i++ {
-		if s[i:i+m] == sub {
-			return i
+	out := make([]string, 0, len(aliases))
+	for _, a := range aliases {
+		if a == "" || (shortID != "" && a == shortID) {
+			continue
 		}
+		out = append(out, a)
 	}
-	return -1
+	return out
 }
 
-func extractTarGzToHost(ctx context.Context, tarGzPath string, destDir string, expectedRoot string) error {
-	f, err := os.Open(tarGzPath)
-	if err != nil {
-		return err
+// acquireLock serializes backups of the same container or project across processes.
+func (e *DefaultBackupEngine) acquireLock(ctx context.Context, name string, opts BackupOptions) (*lock.Lock, error) {
+	wait := opts.WaitLock || opts.LockTimeout > 0
+	lk, err := lock.Acquire(ctx, name, false, 0)
+	if wait && stdErrors.Is(err, lock.ErrLocked) {
+		e.log.Infof("Another backup of %s is running, waiting for it to finish", name)
+		lk, err = lock.Acquire(ctx, name, true, opts.LockTimeout)
 	}
-	defer func() { _ = f.Close() }()
-	gz, err := gzip.NewReader(f)
 	if err != nil {
-		return err
-	}
-	defer func() { _ = gz.Close() }()
-	tr := tar.NewReader(gz)
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-		hdr, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
+		if stdErrors.Is(err, lock.ErrLocked) && !wait {
+			err = fmt.Errorf("%w; use --wait-lock to wait for it", err)
 		}
-		name := hdr.Name
-		if expectedRoot != "" {
-			// strip the root dir prefix
-			if strings.HasPrefix(name, expectedRoot+"/") {
-				name = strings.TrimPrefix(name, expectedRoot+"/")
-			}
-		}
-		outPath := filepath.Join(destDir, name)
-		switch hdr.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(outPath, os.FileMode(hdr.Mode)); err != nil {
-				return err
-			}
-		case tar.TypeReg, tar.TypeRegA:
-			if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
-				return err
-			}
-			out, err := os.OpenFile(outPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(hdr.Mode))
+		return nil, &errors.OperationError{Op: "acquire backup lock", Err: err}
+	}
+	return lk, nil
+}
+
+// finalizeArchive writes the checksum sidecar, splits the archive when requested and updates
+// the _latest link (which points at the split manifest for split archives).
+func (e *DefaultBackupEngine) finalizeArchive(outputPath, name string, opts BackupOptions) (*BackupResult, error) {
+	e.writeChecksum(outputPath)
+	res := &BackupResult{OutputPath: outputPath}
+	if opts.SplitSize > 0 {
+		if fi, err := e.filesystem.Stat(outputPath); err == nil && fi.Size() > opts.SplitSize {
+			manifest, parts, err := archive.Split(outputPath, opts.SplitSize)
 			if err != nil {
-				return err
-			}
-			if _, err := io.Copy(out, tr); err != nil {
-				_ = out.Close()
-				return err
-			}
-			if err := out.Close(); err != nil {
-				return err
+				return nil, &errors.OperationError{Op: "split archive", Err: err}
 			}
+			e.log.Infof("Split %s into %d parts (manifest %s)", filepath.Base(outputPath), len(parts), filepath.Base(manifest))
+			res.OutputPath, res.Parts = manifest, parts
 		}
 	}
-	return nil
+	if opts.Timestamped {
+		e.updateLatest(res.OutputPath, name)
+	}
+	return res, nil
 }
 
-func execCommand(ctx context.Context, name string, args ...string) error {
-	cmd := exec.CommandContext(ctx, name, args...)
+// writeChecksum records a sha256 sidecar used by `check` to detect bit rot later.
+func (e *DefaultBackupEngine) writeChecksum(archivePath string) {
+	if _, err := archive.WriteChecksumFile(archivePath); err != nil {
+		e.warn(WarnChecksum, archivePath, "Could not write checksum for %s: %v", archivePath, err)
+	}
+}
+
+func (e *DefaultBackupEngine) updateLatest(archivePath, name string) {
+	if err := updateLatestLink(e.filesystem, archivePath, name); err != nil {
+		e.log.Infof("Could not update latest link for %s: %v", archivePath, err)
+	}
+}
+
+// runHostCommand runs a host command prepared by execCommand.
+var runHostCommand = func(cmd *exec.Cmd) error {
 	return cmd.Run()
 }
 
-func primaryIPv4OfInterface(ifName string) (string, error) {
+// execCommand runs a host command, logging what it prints at debug level.
+func (e *DefaultBackupEngine) execCommand(ctx context.Context, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	out := logger.DebugWriter(e.log.With("cmd", name), "")
+	defer func() { _ = out.Close() }()
+	var stderr bytes.Buffer
+	cmd.Stdout = out
+	cmd.Stderr = io.MultiWriter(&stderr, out)
+	if err := runHostCommand(cmd); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// primaryIPsOfInterface returns the first IPv4 and the first global IPv6 address of the
+// interface; either may be empty, not both.
+func primaryIPsOfInterface(ifName string) (string, string, error) {
 	itf, err := net.InterfaceByName(ifName)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	addrs, err := itf.Addrs()
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
+	var ipv4, ipv6 string
 	for _, a := range addrs {
-		if ipn, ok := a.(*net.IPNet); ok {
-			ip := ipn.IP.To4()
-			if ip != nil && !ip.IsLoopback() {
-				return ip.String(), nil
+		ipn, ok := a.(*net.IPNet)
+		if !ok || ipn.IP.IsLoopback() {
+			continue
+		}
+		if ip := ipn.IP.To4(); ip != nil {
+			if ipv4 == "" {
+				ipv4 = ip.String()
 			}
+		} else if ipv6 == "" && ipn.IP.IsGlobalUnicast() {
+			ipv6 = ipn.IP.String()
+		}
+	}
+	if ipv4 == "" && ipv6 == "" {
+		return "", "", fmt.Errorf("no IPv4 or global IPv6 address on interface %s", ifName)
+	}
+	return ipv4, ipv6, nil
+}
+
+// rebindPorts moves bindings to ipv4, or ipv6 for those bound to an IPv6 address, falling
+// back to the other family when one is empty. Bindings that end up identical (0.0.0.0 and ::
+// for the same port on an interface with one family) are merged.
+func rebindPorts(bindings []nat.PortBinding, ipv4, ipv6 string) []nat.PortBinding {
+	out := make([]nat.PortBinding, 0, len(bindings))
+	for _, b := range bindings {
+		ip := ipv4
+		if (isIPv6(b.HostIP) && ipv6 != "") || ip == "" {
+			ip = ipv6
 		}
+		b.HostIP = ip
+		if !slices.Contains(out, b) {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+func isIPv6(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.To4() == nil
+}
+
+// canonicalIP returns addr in its canonical form, so ::1 and 0:0::1 compare equal.
+func canonicalIP(addr string) string {
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.String()
 	}
-	return "", fmt.Errorf("no IPv4 on interface %s", ifName)
+	return addr
 }
 
-func conflictWithHostIPv4(addr string) bool {
-	ip := net.ParseIP(addr).To4()
-	if ip == nil {
+// conflictWithHostIP reports whether the IPv4 or IPv6 address lies in a subnet of a host
+// interface. IPv6 link-local subnets are on every interface and do not count.
+func conflictWithHostIP(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.IsLinkLocalUnicast() {
 		return false
 	}
 	ifs, _ := net.Interfaces()
@@ -1007,7 +2026,7 @@ func conflictWithHostIPv4(addr string) bool {
 		addrs, _ := it.Addrs()
 		for _, a := range addrs {
 			if ipn, ok := a.(*net.IPNet); ok {
-				if ipn.IP.To4() != nil && ipn.Contains(ip) {
+				if ipn.Contains(ip) {
 					return true
 				}
 			}
@@ -0,0 +1,231 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	stdErrors "errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+// EntryMatcher accepts archive entries named by patterns: an entry name, a directory (with
+// everything below it) or a path.Match pattern. prefix is prepended to every pattern.
+func EntryMatcher(prefix string, patterns []string) func(name string) bool {
+	return func(name string) bool {
+		name = strings.TrimSuffix(strings.TrimPrefix(name, "./"), "/")
+		for _, p := range patterns {
+			p = strings.Trim(path.Join(prefix, p), "/")
+			if name == p || strings.HasPrefix(name, p+"/") {
+				return true
+			}
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ExtractVolume extracts the data of a named volume (or of the paths below it) from a
+// container or compose backup into destDir/<volume>. Only the parts of the archives holding
+// the volume are read.
+func ExtractVolume(ctx context.Context, backupPath, volume, destDir string, paths []string) error {
+	fsys := filesystem.NewHandler()
+	tmpDir, err := fsys.MkdirTemp(WorkDir(), "dockerbackup_extract_*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = fsys.RemoveAll(tmpDir) }()
+	volTar, _, err := findVolumeArchive(ctx, fsys, archive.NewTarArchiveHandler(), backupPath, volume, tmpDir)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	return archive.NewTarArchiveHandler().ExtractEntries(ctx, volTar, destDir, EntryMatcher(volume, paths))
+}
+
+// errStopWalk ends forEachContainerArchive early once a volume is found.
+var errStopWalk = stdErrors.New("stop")
+
+// findVolumeArchive copies the archive holding a volume's data into tmpDir, following
+// --skip-unchanged references to the archive next to backupPath that stores it. The volume's
+// mounts.json record is returned with it (zero for backups without one).
+func findVolumeArchive(ctx context.Context, fsys filesystem.Handler, th archiveReader, backupPath, volume, tmpDir string) (string, mountArtifact, error) {
+	file := safeName(volume) + ".tar.gz"
+	out := filepath.Join(tmpDir, file)
+	found := false
+	var recorded mountArtifact
+	find := func(service, archivePath string) error {
+		entry := "volumes/" + file
+		if b, err := th.ReadEntry(ctx, archivePath, mountsFileName); err == nil {
+			var mm mountMap
+			if json.Unmarshal(b, &mm) == nil {
+				if a, ok := mm.volume(volume); ok && a.Artifact != "" {
+					entry, recorded = a.Artifact, a
+				}
+			}
+		}
+		err := copyEntryToFile(ctx, fsys, th, archivePath, entry, out)
+		if err == nil {
+			found = true
+			return errStopWalk
+		}
+		if !stdErrors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		b, rerr := th.ReadEntry(ctx, archivePath, strings.TrimSuffix(entry, ".tar.gz")+volumeRefSuffix)
+		if rerr != nil {
+			return nil
+		}
+		var ref volumeRef
+		if err := json.Unmarshal(b, &ref); err != nil {
+			return fmt.Errorf("invalid volume reference for %s: %w", volume, err)
+		}
+		src := filepath.Join(filepath.Dir(backupPath), filepath.Base(ref.Archive))
+		if err := copyEntryToFile(ctx, fsys, th, src, ref.Entry, out); err != nil {
+			return fmt.Errorf("volume %s is stored in %s (backup taken with --skip-unchanged): %w", volume, ref.Archive, err)
+		}
+		found = true
+		return errStopWalk
+	}
+	var err error
+	if kind, _ := detectTargetType(ctx, th, backupPath); kind == TargetCompose {
+		// compose backups taken with --offline keep the volumes at the top of the archive
+		err = find("", backupPath)
+	}
+	if err == nil {
+		err = forEachContainerArchive(ctx, fsys, th, backupPath, find)
+	}
+	if err != nil && !stdErrors.Is(err, errStopWalk) {
+		return "", mountArtifact{}, err
+	}
+	if !found {
+		return "", mountArtifact{}, &errors.ValidationError{Field: "volume", Msg: fmt.Sprintf("no data for volume %s in %s", volume, backupPath)}
+	}
+	return out, recorded, nil
+}
+
+// archiveReader reads single entries of an archive without extracting it. The
+// TarArchiveHandler implements it; the engine uses its ArchiveHandler when that does too.
+type archiveReader interface {
+	WalkArchive(ctx context.Context, archivePath string, fn func(archive.ArchiveEntry) error) error
+	ReadEntry(ctx context.Context, archivePath, name string) ([]byte, error)
+	CopyEntry(ctx context.Context, archivePath, name string, w io.Writer) error
+}
+
+func (e *DefaultBackupEngine) archiveReader() archiveReader {
+	if r, ok := e.archiveHandler.(archiveReader); ok {
+		return r
+	}
+	return archive.NewTarArchiveHandler()
+}
+
+func copyEntryToFile(ctx context.Context, fsys filesystem.Handler, th archiveReader, archivePath, name, dest string) error {
+	f, err := fsys.Create(dest)
+	if err != nil {
+		return err
+	}
+	err = th.CopyEntry(ctx, archivePath, name, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = fsys.RemoveAll(dest)
+	}
+	return err
+}
+
+// restoreVolumesOnly recreates the volumes named in VolumesOnly and restores their data,
+// leaving containers alone. Only the volume entries are read from the backup.
+func (e *DefaultBackupEngine) restoreVolumesOnly(ctx context.Context, request RestoreRequest) (*RestoreResult, error) {
+	tmpDir, err := e.filesystem.MkdirTemp(WorkDir(), "dockerbackup_volumes_restore_*")
+	if err != nil {
+		return nil, &errors.OperationError{Op: "create temp dir", Err: err}
+	}
+	defer func() { _ = e.filesystem.RemoveAll(tmpDir) }()
+	if e.restoreLabels == nil {
+		metaDir := filepath.Join(tmpDir, "meta")
+		if b, err := e.archiveReader().ReadEntry(ctx, request.BackupPath, "metadata.json"); err == nil {
+			_ = e.filesystem.EnsureDir(metaDir, 0o755)
+			_ = e.filesystem.WriteFile(filepath.Join(metaDir, "metadata.json"), b, 0o644)
+		}
+		e.setRestoreLabels(metaDir, request.BackupPath)
+	}
+
+	cfgs, err := readVolumeConfigs(ctx, e.filesystem, request.BackupPath, filepath.Join(tmpDir, "volumes"))
+	if err != nil {
+		return nil, &errors.OperationError{Op: "read volume configs", Err: err}
+	}
+	restored := []string{}
+	for _, name := range request.Options.VolumesOnly {
+		dir := filepath.Join(tmpDir, "data", safeName(name))
+		if err := e.filesystem.EnsureDir(dir, 0o755); err != nil {
+			return nil, &errors.OperationError{Op: "create temp dir", Err: err}
+		}
+		volTar, recorded, err := findVolumeArchive(ctx, e.filesystem, e.archiveReader(), request.BackupPath, name, dir)
+		if err != nil {
+			return nil, err
+		}
+		vc := docker.VolumeConfig{Name: name}
+		for _, c := range cfgs {
+			if c.Name == name {
+				vc = c
+			}
+		}
+		if err := e.ensureVolumeDrivers(ctx, tmpDir, []docker.VolumeConfig{vc}, request.Options.InstallPlugins); err != nil {
+			return nil, err
+		}
+		target := name
+		if mapped, ok := request.Options.VolumeMap[name]; ok && mapped != "" {
+			target = mapped
+		}
+		target, keep, err := e.volumeConflict(ctx, request.Options, target)
+		if err != nil {
+			return nil, err
+		}
+		vc.Name = target
+		e.ensureVolume(ctx, vc)
+		if keep {
+			restored = append(restored, target)
+			continue
+		}
+		e.log.Infof("Restoring volume %s", target)
+		if err := e.restoreVolumeData(ctx, target, volTar, name, recorded); err != nil {
+			return nil, err
+		}
+		restored = append(restored, target)
+	}
+	return &RestoreResult{RestoredID: strings.Join(restored, ",")}, nil
+}
+
+// readVolumeConfigs returns the top-level volume_configs.json of a container or compose
+// backup and copies volume_plugins.json into pluginsDir for ensureVolumeDrivers.
+func readVolumeConfigs(ctx context.Context, fsys filesystem.Handler, backupPath, pluginsDir string) ([]docker.VolumeConfig, error) {
+	th := archive.NewTarArchiveHandler()
+	var cfgs []docker.VolumeConfig
+	if b, err := th.ReadEntry(ctx, backupPath, "volumes/volume_configs.json"); err == nil {
+		if err := json.Unmarshal(b, &cfgs); err != nil {
+			return nil, fmt.Errorf("parse volume_configs.json: %w", err)
+		}
+	}
+	if b, err := th.ReadEntry(ctx, backupPath, "volumes/"+volumePluginsFile); err == nil {
+		if err := fsys.EnsureDir(pluginsDir, 0o755); err != nil {
+			return nil, err
+		}
+		if err := fsys.WriteFile(filepath.Join(pluginsDir, volumePluginsFile), b, 0o644); err != nil {
+			return nil, err
+		}
+	}
+	return cfgs, nil
+}
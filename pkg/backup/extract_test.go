@@ -0,0 +1,97 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+// writeVolumeBackup builds a container backup holding the data of the named volumes.
+func writeVolumeBackup(t *testing.T, volumes ...string) string {
+	t.Helper()
+	ctx := context.Background()
+	arch := archive.NewTarArchiveHandler()
+	work := t.TempDir()
+	_ = os.WriteFile(filepath.Join(work, "container.json"), []byte(`{"Id":"123","Name":"/unit_test"}`), 0o644)
+	_ = os.WriteFile(filepath.Join(work, "metadata.json"), []byte(`{"id":"abc123"}`), 0o644)
+	_ = os.MkdirAll(filepath.Join(work, "volumes"), 0o755)
+	_ = os.WriteFile(filepath.Join(work, "volumes", "volume_configs.json"), []byte(`[{"Name":"data","Driver":"local","Labels":{"tier":"db"}}]`), 0o644)
+	for _, v := range volumes {
+		src := filepath.Join(t.TempDir(), v)
+		_ = os.MkdirAll(filepath.Join(src, "sub"), 0o755)
+		_ = os.WriteFile(filepath.Join(src, "sub", "file.txt"), []byte("content of "+v), 0o644)
+		_ = os.WriteFile(filepath.Join(src, "other.txt"), []byte("other"), 0o644)
+		if err := arch.CreateArchive(ctx, []archive.ArchiveSource{{Path: src, DestPath: v}}, filepath.Join(work, "volumes", v+".tar.gz")); err != nil {
+			t.Fatalf("create volume archive: %v", err)
+		}
+	}
+	backupFile := filepath.Join(t.TempDir(), "backup.tar.gz")
+	if err := arch.CreateArchive(ctx, []archive.ArchiveSource{{Path: work, DestPath: "."}}, backupFile); err != nil {
+		t.Fatalf("create archive: %v", err)
+	}
+	return backupFile
+}
+
+func TestRestore_VolumesOnly(t *testing.T) {
+	t.Setenv("DOCKERBACKUP_JOURNAL_DIR", t.TempDir())
+	t.Setenv("DOCKERBACKUP_LOCK_DIR", t.TempDir())
+	ctx := context.Background()
+	backupFile := writeVolumeBackup(t, "data", "cache")
+
+	fd := &fakeDockerClientRestore{}
+	engine := NewDefaultBackupEngine(archive.NewTarArchiveHandler(), fd, filesystem.NewHandler(), logger.New())
+	res, err := engine.Restore(ctx, RestoreRequest{BackupPath: backupFile, Options: RestoreOptions{
+		VolumesOnly: []string{"data"},
+		VolumeMap:   map[string]string{"data": "data_copy"},
+	}})
+	if err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if len(fd.extractedVolumes) != 1 || fd.extractedVolumes[0] != "data_copy" || res.RestoredID != "data_copy" {
+		t.Fatalf("expected only data_copy to be restored, got %v (%s)", fd.extractedVolumes, res.RestoredID)
+	}
+	if fd.createdContainer != "" {
+		t.Fatalf("no container should be created, got %s", fd.createdContainer)
+	}
+
+	if _, err := engine.Restore(ctx, RestoreRequest{BackupPath: backupFile, Options: RestoreOptions{VolumesOnly: []string{"missing"}}}); err == nil {
+		t.Fatalf("expected an error for a volume not in the backup")
+	}
+}
+
+func TestExtractVolume_Paths(t *testing.T) {
+	ctx := context.Background()
+	backupFile := writeVolumeBackup(t, "data")
+	dest := t.TempDir()
+	if err := ExtractVolume(ctx, backupFile, "data", dest, []string{"sub"}); err != nil {
+		t.Fatalf("ExtractVolume failed: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(dest, "data", "sub", "file.txt"))
+	if err != nil || string(b) != "content of data" {
+		t.Fatalf("sub/file.txt = %q, %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "data", "other.txt")); err == nil {
+		t.Fatalf("other.txt should not be extracted")
+	}
+}
+
+func TestEntryMatcher(t *testing.T) {
+	match := EntryMatcher("", []string{"volumes", "*.json"})
+	for name, want := range map[string]bool{
+		"volumes/":             true,
+		"volumes/data.tar.gz":  true,
+		"metadata.json":        true,
+		"./container.json":     true,
+		"filesystem.tar":       false,
+		"volumesx/data.tar.gz": false,
+	} {
+		if got := match(name); got != want {
+			t.Errorf("match(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
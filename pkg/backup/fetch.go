@@ -0,0 +1,104 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+	"github.com/brian033/dockerbackup/pkg/storage"
+)
+
+// FetchBackup downloads the archive name (stored whole, in chunks or split into parts) from
+// backend into dir and returns its local path. For a backup taken with --skip-unchanged the
+// earlier archives holding its unchanged volumes are downloaded next to it, where the restore
+// reads them.
+func FetchBackup(ctx context.Context, log logger.Logger, backend storage.Backend, name, dir string) (string, error) {
+	fsys := filesystem.NewHandler()
+	local, err := fetchArchive(ctx, fsys, log, backend, name, dir)
+	if err != nil {
+		return "", err
+	}
+	info, err := ReadBackupInfo(ctx, local)
+	if err != nil {
+		// not a backup this version can read; the restore reports it
+		return local, nil
+	}
+	for _, ref := range info.VolumeRefs {
+		if _, err := fsys.Stat(filepath.Join(dir, filepath.Base(ref))); err == nil {
+			continue
+		}
+		if _, err := fetchArchive(ctx, fsys, log, backend, filepath.Base(ref), dir); err != nil {
+			return "", fmt.Errorf("%s keeps unchanged volumes in %s (backup taken with --skip-unchanged): %w", filepath.Base(local), ref, err)
+		}
+	}
+	return local, nil
+}
+
+func fetchArchive(ctx context.Context, fsys filesystem.Handler, log logger.Logger, backend storage.Backend, name, dir string) (string, error) {
+	rc, name, err := storage.OpenStored(ctx, backend, name)
+	if err != nil {
+		return "", fmt.Errorf("download %s: %w", name, err)
+	}
+	defer rc.Close()
+	local := filepath.Join(dir, filepath.Base(name))
+	f, err := fsys.Create(local)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("Downloading %s from %s", name, backend)
+	if _, err := io.Copy(f, rc); err != nil {
+		_ = f.Close()
+		return "", fmt.Errorf("download %s: %w", name, err)
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(name, archive.SplitManifestSuffix) {
+		if err := fetchParts(ctx, fsys, log, backend, local); err != nil {
+			return "", err
+		}
+	}
+	return local, nil
+}
+
+// fetchParts downloads the parts listed in a split manifest next to it.
+func fetchParts(ctx context.Context, fsys filesystem.Handler, log logger.Logger, backend storage.Backend, manifestPath string) error {
+	mf, err := fsys.Open(manifestPath)
+	if err != nil {
+		return err
+	}
+	m, err := archive.ReadSplitManifest(mf)
+	_ = mf.Close()
+	if err != nil {
+		return err
+	}
+	for _, p := range m.Parts {
+		if err := downloadTo(ctx, fsys, backend, filepath.Base(p.Name), filepath.Join(filepath.Dir(manifestPath), filepath.Base(p.Name))); err != nil {
+			return fmt.Errorf("download %s: %w", p.Name, err)
+		}
+		log.Infof("Downloaded %s", p.Name)
+	}
+	return nil
+}
+
+func downloadTo(ctx context.Context, fsys filesystem.Handler, backend storage.Backend, name, dest string) error {
+	rc, err := backend.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	f, err := fsys.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
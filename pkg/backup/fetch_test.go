@@ -0,0 +1,74 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+	"github.com/brian033/dockerbackup/pkg/storage"
+)
+
+func TestFetchBackup_DownloadsVolumeRefs(t *testing.T) {
+	ctx := context.Background()
+	arch := archive.NewTarArchiveHandler()
+	volSrc := t.TempDir()
+	if err := os.WriteFile(filepath.Join(volSrc, "vol.txt"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal([]map[string]any{{
+		"Id":     "123",
+		"Name":   "/unit_test",
+		"Mounts": []map[string]any{{"Name": "myvol", "Source": volSrc, "Destination": "/data", "Type": "volume", "RW": true}},
+	}})
+	engine := NewDefaultBackupEngine(arch, &fakeDockerClient{inspectJSON: b}, filesystem.NewHandler(), logger.New()).(*DefaultBackupEngine)
+	// the storage location: a full backup, an incremental one referencing it, and another
+	remote := t.TempDir()
+	for _, name := range []string{"unit_test_1.tar.gz", "unit_test_2.tar.gz"} {
+		if _, err := engine.Backup(ctx, BackupRequest{TargetType: TargetContainer, ContainerID: "unit_test",
+			Options: BackupOptions{OutputPath: filepath.Join(remote, name), SkipUnchanged: true}}); err != nil {
+			t.Fatalf("backup %s: %v", name, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(remote, "other.tar.gz"), []byte("unrelated"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	local, err := FetchBackup(ctx, logger.New(), storage.NewLocal(remote), "unit_test_2.tar.gz", dir)
+	if err != nil {
+		t.Fatalf("FetchBackup: %v", err)
+	}
+	if local != filepath.Join(dir, "unit_test_2.tar.gz") {
+		t.Fatalf("FetchBackup = %s", local)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "unit_test_1.tar.gz")); err != nil {
+		t.Fatalf("the referenced archive was not downloaded: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "other.tar.gz")); err == nil {
+		t.Fatal("an unreferenced archive was downloaded")
+	}
+	// the restore finds the unchanged volume's data next to the download
+	extracted := t.TempDir()
+	if err := arch.ExtractArchive(ctx, local, extracted); err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if err := engine.resolveVolumeRefs(ctx, extracted, local); err != nil {
+		t.Fatalf("resolveVolumeRefs: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(extracted, "volumes", "myvol.tar.gz")); err != nil {
+		t.Fatalf("referenced volume data not restored: %v", err)
+	}
+
+	// a reference the storage no longer holds fails the download, naming the archive
+	if err := os.Remove(filepath.Join(remote, "unit_test_1.tar.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FetchBackup(ctx, logger.New(), storage.NewLocal(remote), "unit_test_2.tar.gz", t.TempDir()); err == nil {
+		t.Fatal("FetchBackup succeeded without the referenced archive")
+	}
+}
@@ -0,0 +1,208 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+// Backup format versions. Version 1 archives predate format.json; their version comes from
+// metadata.json. Version 2 adds format.json, backup IDs/lineage and stores metadata first.
+const (
+	FormatVersion      = 2
+	formatManifestName = "format.json"
+)
+
+// FormatManifest is format.json, the first entry (after the archive index) of every archive
+// written since version 2.
+// Readers refuse archives whose MinReaderVersion is newer than FormatVersion; archives with a
+// newer Version but an older MinReaderVersion only add data older readers may ignore.
+type FormatManifest struct {
+	Version          int    `json:"version"`
+	MinReaderVersion int    `json:"minReaderVersion"`
+	Kind             string `json:"kind"`
+	Writer           string `json:"writer,omitempty"`
+}
+
+func newFormatManifest(kind BackupTargetType) FormatManifest {
+	return FormatManifest{Version: FormatVersion, MinReaderVersion: 1, Kind: string(kind), Writer: "dockerbackup"}
+}
+
+// writeFormatManifest writes format.json into dir.
+func writeFormatManifest(fsys filesystem.Handler, dir string, kind BackupTargetType) error {
+	b, err := json.MarshalIndent(newFormatManifest(kind), "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsys.WriteFile(filepath.Join(dir, formatManifestName), b, 0o644)
+}
+
+// parseFormat derives the format from format.json, else from the metadata.json version.
+func parseFormat(formatJSON, metadataJSON []byte) (FormatManifest, error) {
+	var f FormatManifest
+	if formatJSON != nil {
+		if err := json.Unmarshal(formatJSON, &f); err != nil {
+			return f, fmt.Errorf("parse %s: %w", formatManifestName, err)
+		}
+		return f, nil
+	}
+	var meta struct {
+		Version int `json:"version"`
+	}
+	if metadataJSON != nil {
+		_ = json.Unmarshal(metadataJSON, &meta)
+	}
+	f.Version = meta.Version
+	if f.Version == 0 {
+		f.Version = 1
+	}
+	f.MinReaderVersion = f.Version
+	return f, nil
+}
+
+// readFormat reads the format of a backup extracted to dir.
+func readFormat(fsys filesystem.Handler, dir string) (FormatManifest, error) {
+	fb, err := fsys.ReadFile(filepath.Join(dir, formatManifestName))
+	if err != nil {
+		fb = nil
+	}
+	mb, err := fsys.ReadFile(filepath.Join(dir, "metadata.json"))
+	if err != nil {
+		mb = nil
+	}
+	return parseFormat(fb, mb)
+}
+
+// ReadFormat reads the format of a backup archive without extracting it.
+func ReadFormat(ctx context.Context, backupPath string) (FormatManifest, error) {
+	return readArchiveFormat(ctx, archive.NewTarArchiveHandler(), backupPath)
+}
+
+func readArchiveFormat(ctx context.Context, th archiveReader, backupPath string) (FormatManifest, error) {
+	fb, err := th.ReadEntry(ctx, backupPath, formatManifestName)
+	if err != nil {
+		fb = nil
+	}
+	var mb []byte
+	if fb == nil {
+		mb, _ = th.ReadEntry(ctx, backupPath, "metadata.json")
+	}
+	return parseFormat(fb, mb)
+}
+
+// checkFormat refuses formats this version cannot read and describes the ones it adapts to.
+func checkFormat(f FormatManifest) (note string, err error) {
+	switch {
+	case f.MinReaderVersion > FormatVersion:
+		return "", &errors.ValidationError{Field: "format", Msg: fmt.Sprintf("backup format %d requires dockerbackup format support %d or later (this version reads up to %d); upgrade dockerbackup", f.Version, f.MinReaderVersion, FormatVersion)}
+	case f.Version > FormatVersion:
+		return fmt.Sprintf("backup format %d is newer than %d; data this version does not know is ignored", f.Version, FormatVersion), nil
+	case f.Version < FormatVersion:
+		return fmt.Sprintf("backup format %d (current %d); upgrade it with `dockerbackup convert`", f.Version, FormatVersion), nil
+	}
+	return "", nil
+}
+
+// checkExtractedFormat refuses to restore unreadable formats and logs how others are handled.
+func (e *DefaultBackupEngine) checkExtractedFormat(dir string) error {
+	f, err := readFormat(e.filesystem, dir)
+	if err != nil {
+		return &errors.OperationError{Op: "read backup format", Err: err}
+	}
+	note, err := checkFormat(f)
+	if err != nil {
+		return err
+	}
+	if note != "" {
+		e.warn(WarnFormat, "", "Note: %s", note)
+	}
+	return nil
+}
+
+// ConvertArchive rewrites a backup in the current format: format.json and metadata.json
+// first, a backup ID and version in metadata, and nested service archives of compose
+// backups converted as well. dst may equal src; the archive is replaced atomically.
+func ConvertArchive(ctx context.Context, src, dst string) (FormatManifest, error) {
+	th := archive.NewTarArchiveHandler()
+	fsys := filesystem.NewHandler()
+	tmpDir, err := fsys.MkdirTemp(WorkDir(), "dockerbackup_convert_*")
+	if err != nil {
+		return FormatManifest{}, &errors.OperationError{Op: "create temp dir", Err: err}
+	}
+	defer func() { _ = fsys.RemoveAll(tmpDir) }()
+	if err := th.ExtractArchive(ctx, src, tmpDir); err != nil {
+		return FormatManifest{}, &errors.OperationError{Op: "extract backup", Err: err}
+	}
+	from, err := readFormat(fsys, tmpDir)
+	if err != nil {
+		return FormatManifest{}, err
+	}
+	if from.MinReaderVersion > FormatVersion || from.Version > FormatVersion {
+		return from, &errors.ValidationError{Field: "format", Msg: fmt.Sprintf("backup format %d is newer than %d and cannot be converted by this version", from.Version, FormatVersion)}
+	}
+	if err := upgradeExtracted(ctx, th, fsys, tmpDir); err != nil {
+		return from, err
+	}
+	if err := th.CreateArchive(ctx, archiveSources(fsys, tmpDir), dst); err != nil {
+		return from, &errors.OperationError{Op: "create converted archive", Err: err}
+	}
+	return from, nil
+}
+
+// upgradeExtracted upgrades an extracted backup in place.
+func upgradeExtracted(ctx context.Context, th *archive.TarArchiveHandler, fsys filesystem.Handler, dir string) error {
+	kind := TargetContainer
+	if _, err := fsys.Stat(filepath.Join(dir, "containers")); err == nil {
+		kind = TargetCompose
+		svcDirs, _ := fsys.ReadDir(filepath.Join(dir, "containers"))
+		for _, sd := range svcDirs {
+			if !sd.IsDir() {
+				continue
+			}
+			if tarPath := serviceArchive(fsys, dir, sd.Name()); tarPath != "" {
+				if _, err := ConvertArchive(ctx, tarPath, tarPath); err != nil {
+					return &errors.OperationError{Op: "convert service " + sd.Name(), Err: err}
+				}
+			}
+		}
+	}
+	metaPath := filepath.Join(dir, "metadata.json")
+	meta := map[string]any{}
+	if b, err := fsys.ReadFile(metaPath); err == nil {
+		_ = json.Unmarshal(b, &meta)
+	}
+	if id, _ := meta["id"].(string); id == "" {
+		meta["id"] = newBackupID()
+	}
+	meta["version"] = FormatVersion
+	b, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fsys.WriteFile(metaPath, b, 0o644); err != nil {
+		return err
+	}
+	return writeFormatManifest(fsys, dir, kind)
+}
+
+// archiveSources lists the top-level entries of dir with format.json and metadata.json first.
+func archiveSources(fsys filesystem.Handler, dir string) []archive.ArchiveSource {
+	sources := []archive.ArchiveSource{
+		{Path: filepath.Join(dir, formatManifestName), DestPath: formatManifestName},
+		{Path: filepath.Join(dir, "metadata.json"), DestPath: "metadata.json"},
+	}
+	entries, _ := fsys.ReadDir(dir)
+	for _, en := range entries {
+		if en.Name() == formatManifestName || en.Name() == "metadata.json" || strings.HasSuffix(en.Name(), ".partial") {
+			continue
+		}
+		sources = append(sources, archive.ArchiveSource{Path: filepath.Join(dir, en.Name()), DestPath: en.Name()})
+	}
+	return sources
+}
@@ -0,0 +1,58 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brian033/dockerbackup/pkg/archive"
+)
+
+func TestConvertArchive_UpgradesLegacyFormat(t *testing.T) {
+	ctx := context.Background()
+	arch := archive.NewTarArchiveHandler()
+	work := t.TempDir()
+	_ = os.WriteFile(filepath.Join(work, "container.json"), []byte("{}"), 0o644)
+	_ = os.WriteFile(filepath.Join(work, "filesystem.tar"), []byte("tar"), 0o644)
+	_ = os.WriteFile(filepath.Join(work, "metadata.json"), []byte(`{"version":1,"containerName":"/web"}`), 0o644)
+	legacy := filepath.Join(t.TempDir(), "web_backup.tar.gz")
+	if err := arch.CreateArchive(ctx, []archive.ArchiveSource{{Path: work, DestPath: "."}}, legacy); err != nil {
+		t.Fatalf("create archive: %v", err)
+	}
+
+	f, err := ReadFormat(ctx, legacy)
+	if err != nil || f.Version != 1 {
+		t.Fatalf("legacy format = %+v, %v", f, err)
+	}
+	if note, err := checkFormat(f); err != nil || !strings.Contains(note, "dockerbackup convert") {
+		t.Fatalf("legacy archives should be accepted with an upgrade hint, got %q, %v", note, err)
+	}
+
+	if _, err := ConvertArchive(ctx, legacy, legacy); err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	f, err = ReadFormat(ctx, legacy)
+	if err != nil || f.Version != FormatVersion || f.Kind != string(TargetContainer) {
+		t.Fatalf("converted format = %+v, %v", f, err)
+	}
+	info, err := ReadBackupInfo(ctx, legacy)
+	if err != nil || info.ID == "" || info.ContainerName != "/web" {
+		t.Fatalf("converted metadata = %+v, %v", info, err)
+	}
+	entries, _ := arch.ListArchive(ctx, legacy)
+	if len(entries) == 0 || entries[0].Path != formatManifestName {
+		t.Fatalf("format.json must be the first entry: %+v", entries)
+	}
+}
+
+func TestCheckFormat_NewerVersions(t *testing.T) {
+	if _, err := checkFormat(FormatManifest{Version: FormatVersion + 1, MinReaderVersion: FormatVersion + 1}); err == nil {
+		t.Fatalf("formats requiring a newer reader must be refused")
+	}
+	note, err := checkFormat(FormatManifest{Version: FormatVersion + 1, MinReaderVersion: 1})
+	if err != nil || note == "" {
+		t.Fatalf("newer but compatible formats should be read with a note, got %q, %v", note, err)
+	}
+}
@@ -0,0 +1,127 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/docker"
+)
+
+// backupGroup backs up the containers of request.ContainerIDs into one archive laid out like a
+// compose backup without compose files: each container under containers/<name>/ and the
+// networks and volumes they use recorded once. The data of a volume several of them mount is
+// archived with the first one only. Restore treats the archive as a compose backup, so the
+// containers are restored together.
+func (e *DefaultBackupEngine) backupGroup(ctx context.Context, request BackupRequest) (*BackupResult, error) {
+	var refs []docker.ProjectContainerRef
+	var names []string
+	// volume -> the container whose archive holds its data
+	holders := map[string]string{}
+	mounted := map[string][]string{}
+	for _, id := range request.ContainerIDs {
+		b, err := e.dockerClient.InspectContainer(ctx, id)
+		if err != nil {
+			return nil, &errors.OperationError{Op: fmt.Sprintf("inspect container %s", id), Err: err}
+		}
+		info, err := docker.ParseContainerInfo(b)
+		if err != nil {
+			return nil, &errors.OperationError{Op: fmt.Sprintf("parse container inspect of %s", id), Err: err}
+		}
+		if _, ok := mounted[info.Name]; ok {
+			return nil, &errors.ValidationError{Field: "ContainerIDs", Msg: fmt.Sprintf("container %s is given more than once", info.Name)}
+		}
+		mounted[info.Name] = nil
+		refs = append(refs, docker.ProjectContainerRef{Service: info.Name, ID: info.ID, ContainerName: info.Name})
+		names = append(names, info.Name)
+		for _, m := range info.Mounts {
+			if m.Type != "volume" || m.Name == "" {
+				continue
+			}
+			if holders[m.Name] == "" {
+				holders[m.Name] = info.Name
+			}
+			mounted[info.Name] = append(mounted[info.Name], m.Name)
+		}
+	}
+	groupName := safeName(strings.Join(names, "_"))
+	rep := newReporter(strings.Join(names, ", "))
+	workDir, err := e.filesystem.MkdirTemp(WorkDir(), "dockerbackup_group_*")
+	if err != nil {
+		return nil, &errors.OperationError{Op: "create temp dir", Err: err}
+	}
+	defer func() { _ = e.filesystem.RemoveAll(workDir) }()
+	containersDir := filepath.Join(workDir, "containers")
+	for _, dir := range []string{"compose-files", "containers", "networks", "volumes"} {
+		_ = e.filesystem.EnsureDir(filepath.Join(workDir, dir), 0o755)
+	}
+
+	for _, r := range refs {
+		shared := map[string]string{}
+		for _, v := range mounted[r.Service] {
+			if holders[v] != r.Service {
+				shared[v] = holders[v]
+			}
+		}
+		if err := e.backupService(ctx, containersDir, serviceArchiveName, r, request.Options, shared, rep); err != nil {
+			return nil, err
+		}
+	}
+	used := e.projectResources(ctx, refs)
+	var groupMounts []docker.Mount
+	for _, name := range used.volumeNames {
+		groupMounts = append(groupMounts, docker.Mount{Type: "volume", Name: name})
+	}
+	for _, p := range unmatchedPatterns(request.Options.IncludeVolumes, groupMounts) {
+		e.warn(WarnPatternUnmatched, p, "Warning: --include-volume %s matches no volume of %s", p, strings.Join(names, ", "))
+	}
+	e.writeProjectResources(ctx, workDir, used.networks, used.volumes)
+	imagesSaved := e.saveProjectImages(ctx, workDir, used.images, request.Options)
+	rep.stage("save images")
+
+	hostname, _ := os.Hostname()
+	meta := map[string]any{"id": newBackupID(), "hostId": hostID(e.filesystem), "hostname": hostname, "version": FormatVersion, "createdAt": time.Now().UTC(), "group": true, "services": names}
+	if imagesSaved {
+		meta["images"] = used.images
+	}
+	if len(request.Options.Tags) > 0 {
+		meta["tags"] = request.Options.Tags
+	}
+	if request.Options.Note != "" {
+		meta["note"] = request.Options.Note
+	}
+	if b, err := json.MarshalIndent(meta, "", "  "); err == nil {
+		_ = e.filesystem.WriteFile(filepath.Join(workDir, "metadata.json"), b, 0o644)
+	}
+	if err := writeFormatManifest(e.filesystem, workDir, TargetCompose); err != nil {
+		return nil, &errors.OperationError{Op: "write format.json", Err: err}
+	}
+	rep.stage("capture configuration")
+
+	outputPath := request.Options.OutputPath
+	if request.Options.Timestamped {
+		cwd, _ := os.Getwd()
+		outputPath = timestampedOutputPath(outputPath, cwd, groupName, time.Now())
+	} else if outputPath == "" {
+		cwd, _ := os.Getwd()
+		outputPath = filepath.Join(cwd, groupName+"_backup.tar.gz")
+	}
+	sources := []archive.ArchiveSource{
+		{Path: filepath.Join(workDir, formatManifestName), DestPath: formatManifestName},
+		{Path: filepath.Join(workDir, "metadata.json"), DestPath: "metadata.json"},
+		{Path: filepath.Join(workDir, "compose-files"), DestPath: "compose-files"},
+		{Path: containersDir, DestPath: "containers"},
+		{Path: filepath.Join(workDir, "networks"), DestPath: "networks"},
+		{Path: filepath.Join(workDir, "volumes"), DestPath: "volumes"},
+	}
+	if imagesSaved {
+		sources = append(sources, archive.ArchiveSource{Path: filepath.Join(workDir, projectImagesDir), DestPath: projectImagesDir})
+	}
+	return e.packageProject(ctx, workDir, sources, outputPath, groupName, request.Options, rep)
+}
@@ -0,0 +1,199 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+// DefaultDaemonConfig is where dockerd reads daemon.json unless started with --config-file.
+const DefaultDaemonConfig = "/etc/docker/daemon.json"
+
+// hostDaemonFiles are other files and directories that shape daemon behavior: distribution
+// defaults (DOCKER_OPTS) and systemd drop-ins overriding ExecStart, proxies or limits.
+var hostDaemonFiles = []string{
+	"/etc/default/docker",
+	"/etc/sysconfig/docker",
+	"/etc/systemd/system/docker.service.d",
+	"/etc/systemd/system/docker.socket.d",
+}
+
+// hostFilesDirName holds captured host files under their absolute paths (daemon/etc/docker/...).
+const hostFilesDirName = "daemon"
+
+type hostMetadata struct {
+	ID        string    `json:"id"`
+	HostID    string    `json:"hostId,omitempty"`
+	Hostname  string    `json:"hostname,omitempty"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Kind      string    `json:"kind"`
+	// Host paths captured under daemon/
+	Files   []string `json:"files"`
+	Plugins []string `json:"plugins,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Note    string   `json:"note,omitempty"`
+}
+
+// backupHost archives the daemon configuration of this host: daemon.json and related files,
+// `docker info`, installed plugins and their settings, and the default bridge network.
+func (e *DefaultBackupEngine) backupHost(ctx context.Context, request BackupRequest) (*BackupResult, error) {
+	lk, err := e.acquireLock(ctx, "host", request.Options)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = lk.Release() }()
+	workDir, err := e.filesystem.MkdirTemp(WorkDir(), "dockerbackup_host_*")
+	if err != nil {
+		return nil, &errors.OperationError{Op: "create temp dir", Err: err}
+	}
+	defer func() { _ = e.filesystem.RemoveAll(workDir) }()
+
+	hostname, _ := os.Hostname()
+	meta := hostMetadata{ID: newBackupID(), HostID: hostID(e.filesystem), Hostname: hostname, Version: FormatVersion, CreatedAt: time.Now().UTC(), Kind: string(TargetHost), Tags: request.Options.Tags, Note: request.Options.Note}
+
+	daemonConfig := request.Options.DaemonConfig
+	if daemonConfig == "" {
+		daemonConfig = DefaultDaemonConfig
+	}
+	filesDir := filepath.Join(workDir, hostFilesDirName)
+	if err := e.filesystem.EnsureDir(filesDir, 0o755); err != nil {
+		return nil, &errors.OperationError{Op: "create daemon dir", Err: err}
+	}
+	if b, err := e.filesystem.ReadFile(daemonConfig); err == nil {
+		if !json.Valid(b) {
+			e.log.Infof("Warning: %s is not valid JSON; archiving it as is", daemonConfig)
+		}
+		if err := copyHostPath(e.filesystem, daemonConfig, filesDir); err != nil {
+			return nil, &errors.OperationError{Op: "copy " + daemonConfig, Err: err}
+		}
+		meta.Files = append(meta.Files, daemonConfig)
+	} else if os.IsNotExist(err) {
+		e.log.Infof("No %s; the daemon runs with default settings", daemonConfig)
+	} else {
+		return nil, &errors.OperationError{Op: "read " + daemonConfig, Err: err}
+	}
+	for _, p := range hostDaemonFiles {
+		if _, err := e.filesystem.Stat(p); err != nil {
+			continue
+		}
+		if err := copyHostPath(e.filesystem, p, filesDir); err != nil {
+			e.log.Infof("Could not capture %s: %v", p, err)
+			continue
+		}
+		meta.Files = append(meta.Files, p)
+	}
+
+	info, err := e.dockerClient.DaemonInfo(ctx)
+	if err != nil {
+		return nil, &errors.OperationError{Op: "docker info", Err: err}
+	}
+	if err := e.filesystem.WriteFile(filepath.Join(workDir, "docker-info.json"), info, 0o644); err != nil {
+		return nil, &errors.OperationError{Op: "write docker-info.json", Err: err}
+	}
+	plugins, err := e.dockerClient.ListPlugins(ctx)
+	if err != nil {
+		e.log.Infof("Could not list plugins: %v", err)
+	}
+	for _, p := range plugins {
+		meta.Plugins = append(meta.Plugins, p.Name)
+	}
+	if err := e.writeJSONFile(filepath.Join(workDir, "plugins.json"), plugins); err != nil {
+		return nil, &errors.OperationError{Op: "write plugins.json", Err: err}
+	}
+
+	netDir := filepath.Join(workDir, "networks")
+	_ = e.filesystem.EnsureDir(netDir, 0o755)
+	var netCfgs []docker.NetworkConfig
+	if n, err := e.dockerClient.InspectNetwork(ctx, "bridge"); err == nil && n != nil {
+		netCfgs = append(netCfgs, *n)
+	} else if err != nil {
+		e.log.Infof("Could not inspect the default bridge network: %v", err)
+	}
+	if len(netCfgs) > 0 {
+		if err := e.writeJSONFile(filepath.Join(netDir, "network_configs.json"), netCfgs); err != nil {
+			return nil, &errors.OperationError{Op: "write network_configs.json", Err: err}
+		}
+	}
+
+	if err := e.writeJSONFile(filepath.Join(workDir, "metadata.json"), meta); err != nil {
+		return nil, &errors.OperationError{Op: "write metadata.json", Err: err}
+	}
+	if err := writeFormatManifest(e.filesystem, workDir, TargetHost); err != nil {
+		return nil, &errors.OperationError{Op: "write format.json", Err: err}
+	}
+
+	name := safeName(hostname) + "_host"
+	outputPath := request.Options.OutputPath
+	cwd, _ := os.Getwd()
+	if request.Options.Timestamped {
+		outputPath = timestampedOutputPath(outputPath, cwd, name, time.Now())
+	} else if outputPath == "" {
+		outputPath = filepath.Join(cwd, name+"_backup.tar.gz")
+	}
+	e.log.Infof("Packaging host configuration -> %s", outputPath)
+	sources := []archive.ArchiveSource{
+		{Path: filepath.Join(workDir, formatManifestName), DestPath: formatManifestName},
+		{Path: filepath.Join(workDir, "metadata.json"), DestPath: "metadata.json"},
+		{Path: filepath.Join(workDir, "docker-info.json"), DestPath: "docker-info.json"},
+		{Path: filepath.Join(workDir, "plugins.json"), DestPath: "plugins.json"},
+		{Path: filesDir, DestPath: hostFilesDirName},
+		{Path: netDir, DestPath: "networks"},
+	}
+	if th, ok := e.archiveHandler.(*archive.TarArchiveHandler); ok {
+		th.SetCompressionLevel(request.Options.CompressionLevel)
+		th.SetCompressionThreads(request.Options.CompressThreads)
+		th.SetAutoCompression(request.Options.CompressionAuto)
+	}
+	if err := e.archiveHandler.CreateArchive(ctx, sources, outputPath); err != nil {
+		return nil, &errors.OperationError{Op: "create host archive", Err: err}
+	}
+	return e.finalizeArchive(outputPath, name, request.Options)
+}
+
+// copyHostPath copies a host file or directory to the same absolute path under destRoot on
+// fsys.
+func copyHostPath(fsys filesystem.Handler, src, destRoot string) error {
+	return filesystem.WalkDir(fsys, src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(destRoot, strings.TrimPrefix(filepath.Clean(path), string(filepath.Separator)))
+		switch {
+		case d.IsDir():
+			return fsys.EnsureDir(dest, 0o755)
+		case !d.Type().IsRegular():
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		b, err := fsys.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := fsys.EnsureDir(filepath.Dir(dest), 0o755); err != nil {
+			return err
+		}
+		return fsys.WriteFile(dest, b, fi.Mode().Perm())
+	})
+}
+
+func (e *DefaultBackupEngine) writeJSONFile(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", filepath.Base(path), err)
+	}
+	return e.filesystem.WriteFile(path, b, 0o644)
+}
@@ -0,0 +1,60 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+func TestBackupHost_CapturesDaemonConfig(t *testing.T) {
+	ctx := context.Background()
+	etc := t.TempDir()
+	daemonJSON := filepath.Join(etc, "daemon.json")
+	_ = os.WriteFile(daemonJSON, []byte(`{"log-driver":"local","default-address-pools":[{"base":"10.10.0.0/16","size":24}]}`), 0o644)
+	dropIns := filepath.Join(etc, "docker.service.d")
+	_ = os.MkdirAll(dropIns, 0o755)
+	_ = os.WriteFile(filepath.Join(dropIns, "http-proxy.conf"), []byte("[Service]\nEnvironment=HTTP_PROXY=http://proxy:3128\n"), 0o644)
+	orig := hostDaemonFiles
+	defer func() { hostDaemonFiles = orig }()
+	hostDaemonFiles = []string{dropIns, filepath.Join(etc, "missing")}
+
+	arch := archive.NewTarArchiveHandler()
+	dc := &fakeDockerClient{plugins: []docker.PluginInfo{{Name: "vieux/sshfs:latest", Enabled: true, Env: []string{"DEBUG=0"}}}}
+	engine := NewDefaultBackupEngine(arch, dc, filesystem.NewHandler(), logger.New())
+	out := filepath.Join(t.TempDir(), "host.tar.gz")
+	if _, err := engine.Backup(ctx, BackupRequest{TargetType: TargetHost,
+		Options: NewBackupOptionsBuilder().WithOutput(out).WithDaemonConfig(daemonJSON).Build()}); err != nil {
+		t.Fatalf("backup-host failed: %v", err)
+	}
+
+	if b, err := arch.ReadEntry(ctx, out, hostFilesDirName+daemonJSON); err != nil || !json.Valid(b) {
+		t.Fatalf("daemon.json not captured under its host path: %q, %v", b, err)
+	}
+	if _, err := arch.ReadEntry(ctx, out, hostFilesDirName+filepath.Join(dropIns, "http-proxy.conf")); err != nil {
+		t.Fatalf("systemd drop-in not captured: %v", err)
+	}
+	var plugins []docker.PluginInfo
+	b, _ := arch.ReadEntry(ctx, out, "plugins.json")
+	if err := json.Unmarshal(b, &plugins); err != nil || len(plugins) != 1 || plugins[0].Env[0] != "DEBUG=0" {
+		t.Fatalf("plugins.json = %s, %v", b, err)
+	}
+	var meta hostMetadata
+	b, _ = arch.ReadEntry(ctx, out, "metadata.json")
+	if err := json.Unmarshal(b, &meta); err != nil || len(meta.Files) != 2 || meta.Kind != string(TargetHost) {
+		t.Fatalf("unexpected metadata %s, %v", b, err)
+	}
+
+	if kind, err := DetectTargetType(ctx, out); err != nil || kind != TargetHost {
+		t.Fatalf("DetectTargetType = %q, %v", kind, err)
+	}
+	if _, err := engine.Restore(ctx, RestoreRequest{BackupPath: out}); err == nil {
+		t.Fatalf("restoring a host backup should be refused")
+	}
+}
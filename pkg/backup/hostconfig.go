@@ -0,0 +1,165 @@
+package backup
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// decodeContainerJSON accepts docker inspect output in either single object or array form.
+func decodeContainerJSON(b []byte) (types.ContainerJSON, error) {
+	return docker.ParseContainerJSON(b)
+}
+
+// hostConfigMismatches compares the HostConfig requested at create time with the one the
+// daemon recorded and returns the fields that were not applied. Only fields that were
+// explicitly set in want are checked.
+func hostConfigMismatches(want, got *container.HostConfig) []string {
+	if want == nil {
+		return nil
+	}
+	if got == nil {
+		got = &container.HostConfig{}
+	}
+	var out []string
+	checkInt := func(field string, w, g int64) {
+		if w != 0 && w != g {
+			out = append(out, field)
+		}
+	}
+	checkStr := func(field string, w, g string) {
+		if w != "" && w != g {
+			out = append(out, field)
+		}
+	}
+
+	if want.RestartPolicy.Name != "" && want.RestartPolicy.Name != container.RestartPolicyDisabled {
+		if want.RestartPolicy != got.RestartPolicy {
+			out = append(out, "RestartPolicy")
+		}
+	}
+	checkInt("Memory", want.Memory, got.Memory)
+	checkInt("MemoryReservation", want.MemoryReservation, got.MemoryReservation)
+	checkInt("MemorySwap", want.MemorySwap, got.MemorySwap)
+	checkInt("NanoCpus", want.NanoCPUs, got.NanoCPUs)
+	checkInt("CpuShares", want.CPUShares, got.CPUShares)
+	checkInt("CpuPeriod", want.CPUPeriod, got.CPUPeriod)
+	checkInt("CpuQuota", want.CPUQuota, got.CPUQuota)
+	checkStr("CpusetCpus", want.CpusetCpus, got.CpusetCpus)
+	checkStr("CpusetMems", want.CpusetMems, got.CpusetMems)
+	if want.PidsLimit != nil && *want.PidsLimit > 0 {
+		if got.PidsLimit == nil || *got.PidsLimit != *want.PidsLimit {
+			out = append(out, "PidsLimit")
+		}
+	}
+	for _, u := range want.Ulimits {
+		if u == nil {
+			continue
+		}
+		found := false
+		for _, g := range got.Ulimits {
+			if g != nil && g.Name == u.Name && g.Soft == u.Soft && g.Hard == u.Hard {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, fmt.Sprintf("Ulimits[%s]", u.Name))
+		}
+	}
+	checkStr("LogConfig.Type", want.LogConfig.Type, got.LogConfig.Type)
+	keys := make([]string, 0, len(want.LogConfig.Config))
+	for k := range want.LogConfig.Config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if got.LogConfig.Config[k] != want.LogConfig.Config[k] {
+			out = append(out, fmt.Sprintf("LogConfig.Config[%s]", k))
+		}
+	}
+	return out
+}
+
+// remapLogConfig rewrites the log driver using the explicit map first and then falls back to
+// defaultDriver when the saved driver is not available on the target. Driver options are
+// dropped whenever the driver changes since they are driver specific.
+func remapLogConfig(lc container.LogConfig, driverMap map[string]string, defaultDriver string, available map[string]bool) (container.LogConfig, bool) {
+	if lc.Type == "" {
+		return lc, false
+	}
+	target := lc.Type
+	if m, ok := driverMap[lc.Type]; ok && m != "" {
+		target = m
+	} else if defaultDriver != "" && available != nil && !available[lc.Type] {
+		target = defaultDriver
+	}
+	if target == lc.Type {
+		return lc, false
+	}
+	return container.LogConfig{Type: target}, true
+}
+
+// isGPURequest reports whether a device request targets GPUs (docker run --gpus).
+func isGPURequest(dr container.DeviceRequest) bool {
+	if dr.Driver == "nvidia" {
+		return true
+	}
+	for _, caps := range dr.Capabilities {
+		for _, c := range caps {
+			if c == "gpu" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// usesGPUs reports whether the HostConfig requests GPUs either through device requests
+// or the legacy nvidia runtime.
+func usesGPUs(hostCfg *container.HostConfig) bool {
+	if hostCfg == nil {
+		return false
+	}
+	if hostCfg.Runtime == "nvidia" {
+		return true
+	}
+	for _, dr := range hostCfg.DeviceRequests {
+		if isGPURequest(dr) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropGPUs removes GPU device requests and the legacy nvidia runtime, keeping other device requests.
+func dropGPUs(hostCfg *container.HostConfig) {
+	kept := hostCfg.DeviceRequests[:0]
+	for _, dr := range hostCfg.DeviceRequests {
+		if !isGPURequest(dr) {
+			kept = append(kept, dr)
+		}
+	}
+	hostCfg.DeviceRequests = kept
+	if hostCfg.Runtime == "nvidia" {
+		hostCfg.Runtime = ""
+	}
+}
+
+// remapGPUDeviceIDs rewrites GPU device IDs (indexes or UUIDs) using gpuMap.
+func remapGPUDeviceIDs(hostCfg *container.HostConfig, gpuMap map[string]string) {
+	for i := range hostCfg.DeviceRequests {
+		dr := &hostCfg.DeviceRequests[i]
+		if !isGPURequest(*dr) {
+			continue
+		}
+		for j, id := range dr.DeviceIDs {
+			if m, ok := gpuMap[id]; ok && m != "" {
+				dr.DeviceIDs[j] = m
+			}
+		}
+	}
+}
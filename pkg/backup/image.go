@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/docker/docker/api/types"
+)
+
+// ociImageDirName holds the image as an OCI layout when backed up with --image-format oci.
+const ociImageDirName = "image-oci"
+
+// loadSavedImage loads the image stored in an extracted container backup, from image.tar or
+// the OCI layout in image-oci/. It reports false when the backup holds no image.
+func (e *DefaultBackupEngine) loadSavedImage(ctx context.Context, dir string) (bool, error) {
+	imageTar := filepath.Join(dir, "image.tar")
+	if _, err := e.filesystem.Stat(imageTar); err == nil {
+		return true, e.dockerClient.ImageLoad(ctx, imageTar)
+	}
+	layout := filepath.Join(dir, ociImageDirName)
+	if _, err := e.filesystem.Stat(layout); err != nil {
+		return false, nil
+	}
+	packed := filepath.Join(dir, "image-oci.tar")
+	if err := archive.OCILayoutToImageTar(ctx, layout, packed); err != nil {
+		return true, err
+	}
+	defer func() { _ = e.filesystem.RemoveAll(packed) }()
+	return true, e.dockerClient.ImageLoad(ctx, packed)
+}
+
+// imagePresent reports whether the image with ID id is already on the host, so a restore can
+// skip loading or importing it again. IDs are content digests, so a match is the same image.
+func (e *DefaultBackupEngine) imagePresent(ctx context.Context, id string) bool {
+	if id == "" {
+		return false
+	}
+	img, err := e.dockerClient.InspectImage(ctx, id)
+	return err == nil && img != nil && img.ID == id
+}
+
+// imageRefs records every reference of the image a container was created from. docker save
+// by ID drops the tags, and only digests pin the exact image in a registry.
+type imageRefs struct {
+	ID          string   `json:"id"`
+	RepoTags    []string `json:"repoTags,omitempty"`
+	RepoDigests []string `json:"repoDigests,omitempty"`
+}
+
+// captureImageRefs returns the tags and digests of the container's image, or nil when it
+// cannot be inspected (the image save reports that).
+func (e *DefaultBackupEngine) captureImageRefs(ctx context.Context, cj types.ContainerJSON) *imageRefs {
+	if cj.ContainerJSONBase == nil || cj.Image == "" {
+		return nil
+	}
+	img, err := e.dockerClient.InspectImage(ctx, cj.Image)
+	if err != nil {
+		return nil
+	}
+	return &imageRefs{ID: img.ID, RepoTags: img.RepoTags, RepoDigests: img.RepoDigests}
+}
+
+// readImageRefs returns the image references recorded in metadata.json under dir, nil for
+// backups taken before they were.
+func (e *DefaultBackupEngine) readImageRefs(dir string) *imageRefs {
+	b, err := e.filesystem.ReadFile(filepath.Join(dir, "metadata.json"))
+	if err != nil {
+		return nil
+	}
+	var meta backupMetadata
+	if json.Unmarshal(b, &meta) != nil {
+		return nil
+	}
+	return meta.Image
+}
+
+// pullPinnedImage pulls the image by one of its recorded digests, for a backup without a
+// saved image, so the restored container runs on exactly the backed-up image. It returns the
+// reference pulled, or "" when no digest is recorded or none could be pulled.
+func (e *DefaultBackupEngine) pullPinnedImage(ctx context.Context, refs *imageRefs) string {
+	if refs == nil {
+		return ""
+	}
+	for _, d := range refs.RepoDigests {
+		e.log.Infof("Backup holds no image; pulling %s", d)
+		if err := e.dockerClient.EnsureImage(ctx, d); err != nil {
+			e.log.Infof("Could not pull %s: %v", d, err)
+			continue
+		}
+		return d
+	}
+	return ""
+}
+
+// tagRestoredImage gives the restored image at ref every tag it had, and configImage (the
+// reference the container was created with) when that is a tag too. Digests cannot be
+// assigned locally; they come back when the image is pushed or pulled.
+func (e *DefaultBackupEngine) tagRestoredImage(ctx context.Context, ref string, refs *imageRefs, configImage string) {
+	var tags []string
+	if refs != nil {
+		tags = append(tags, refs.RepoTags...)
+	}
+	if configImage != "" && !slices.Contains(tags, configImage) {
+		tags = append(tags, configImage)
+	}
+	for _, t := range tags {
+		if t == ref || strings.Contains(t, "@") || strings.HasPrefix(t, "sha256:") {
+			continue
+		}
+		if err := e.dockerClient.TagImage(ctx, ref, t); err != nil {
+			e.log.Infof("Could not tag the restored image %s: %v", t, err)
+		}
+	}
+}
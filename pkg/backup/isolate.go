@@ -0,0 +1,269 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+)
+
+// LabelIsolated is put on containers restored with --isolated. It holds the networks and
+// published ports the container was restored without, which `dockerbackup promote` gives back.
+const LabelIsolated = "dockerbackup.isolated"
+
+// isolatedNetworking is what --isolated took from a container, as stored in LabelIsolated.
+type isolatedNetworking struct {
+	// Name is the container's name in the backup, which promote gives it back
+	Name string `json:"name,omitempty"`
+	// Network is the internal network the container was attached to instead
+	Network         string                               `json:"network"`
+	NetworkMode     string                               `json:"networkMode,omitempty"`
+	Endpoints       map[string]*network.EndpointSettings `json:"endpoints,omitempty"`
+	PortBindings    nat.PortMap                          `json:"portBindings,omitempty"`
+	PublishAllPorts bool                                 `json:"publishAllPorts,omitempty"`
+}
+
+// isolatedNetworkName is the internal network --isolated attaches a container, or the
+// services of a project, to.
+func isolatedNetworkName(name string) string {
+	return name + "_isolated"
+}
+
+// isolate rewires a container about to be created for --isolated: its only network becomes
+// the internal netName, which has no route out of the host, and no ports are published. The
+// aliases of its saved endpoints are kept so services of a project still find each other. The
+// saved networking is recorded in LabelIsolated. A container sharing another container's
+// network namespace keeps it and is only as isolated as that container.
+func (e *DefaultBackupEngine) isolate(ctx context.Context, cfg *container.Config, hostCfg *container.HostConfig, netCfg *network.NetworkingConfig, netName, originalName string) error {
+	if hostCfg.NetworkMode.IsContainer() {
+		e.warn(WarnNotIsolated, string(hostCfg.NetworkMode), "Container shares the network of %s; it is not isolated unless that container is", strings.TrimPrefix(string(hostCfg.NetworkMode), "container:"))
+		return nil
+	}
+	saved := isolatedNetworking{
+		Name:            originalName,
+		Network:         netName,
+		NetworkMode:     string(hostCfg.NetworkMode),
+		Endpoints:       netCfg.EndpointsConfig,
+		PortBindings:    hostCfg.PortBindings,
+		PublishAllPorts: hostCfg.PublishAllPorts,
+	}
+	b, err := json.Marshal(saved)
+	if err != nil {
+		return &errors.OperationError{Op: "record isolated networking", Err: err}
+	}
+	e.ensureNetwork(ctx, docker.NetworkConfig{Name: netName, Driver: "bridge", Internal: true})
+	if _, err := e.attachTarget(ctx, netName); err != nil {
+		return &errors.OperationError{Op: "create isolated network " + netName, Err: err}
+	}
+	var aliases []string
+	for name, ep := range netCfg.EndpointsConfig {
+		if ep == nil || name == "bridge" {
+			continue
+		}
+		for _, a := range ep.Aliases {
+			if !slices.Contains(aliases, a) {
+				aliases = append(aliases, a)
+			}
+		}
+	}
+	slices.Sort(aliases)
+	netCfg.EndpointsConfig = map[string]*network.EndpointSettings{netName: {Aliases: aliases}}
+	hostCfg.NetworkMode = container.NetworkMode(netName)
+	hostCfg.PortBindings = nil
+	hostCfg.PublishAllPorts = false
+	cfg.Labels = withLabels(cfg.Labels, map[string]string{LabelIsolated: string(b)})
+	e.log.Infof("Isolating the container on internal network %s; no ports are published", netName)
+	return nil
+}
+
+// PromoteOptions control Promote.
+type PromoteOptions struct {
+	// Name the container takes, by default the name it had when it was backed up
+	Name string
+	// RemoveReplaced removes the container it replaces instead of keeping it stopped
+	RemoveReplaced bool
+	// Confirm is asked before the container holding the name is stopped
+	Confirm Confirmation
+}
+
+// PromoteResult is what Promote did.
+type PromoteResult struct {
+	Container string `json:"container"`
+	ID        string `json:"id"`
+	Started   bool   `json:"started"`
+	// Replaced is the stopped container that held the name, renamed to <name>_replaced, or
+	// the name it had when it was removed with RemoveReplaced
+	Replaced        string `json:"replaced,omitempty"`
+	ReplacedRemoved bool   `json:"replacedRemoved,omitempty"`
+	// NetworkRemoved is set when the isolated network was removed, no container using it any more
+	NetworkRemoved string `json:"networkRemoved,omitempty"`
+}
+
+// Promote puts a container restored with --isolated into service, blue/green style. A copy
+// with the networks and published ports it was restored without is created next to it first;
+// then the isolated container and the container holding the target name (production) are
+// stopped, production is renamed to <name>_replaced, the copy takes the name and is started if
+// either was running. Should any step fail, the copy is removed and production gets its name
+// back and is started again. The isolated container is removed once the copy runs. Volumes are
+// kept; changes made to the isolated container's own filesystem are not. The isolated network
+// is removed once no container uses it.
+func Promote(ctx context.Context, dc docker.DockerClient, log logger.Logger, name string, opts PromoteOptions) (*PromoteResult, error) {
+	iso, err := inspectContainer(ctx, dc, name)
+	if err != nil {
+		return nil, err
+	}
+	if iso.Config == nil || iso.Config.Labels[LabelIsolated] == "" {
+		return nil, &errors.ValidationError{Field: "container", Msg: fmt.Sprintf("%s was not restored with --isolated", name)}
+	}
+	var saved isolatedNetworking
+	if err := json.Unmarshal([]byte(iso.Config.Labels[LabelIsolated]), &saved); err != nil {
+		return nil, &errors.OperationError{Op: "read " + LabelIsolated + " label", Err: err}
+	}
+	if iso.HostConfig == nil {
+		iso.HostConfig = &container.HostConfig{}
+	}
+	target := opts.Name
+	if target == "" {
+		target = saved.Name
+	}
+	if target == "" {
+		target = strings.TrimPrefix(iso.Name, "/")
+	}
+	replacedName, tmpName := target+"_replaced", target+"_promoting"
+	for _, n := range []string{replacedName, tmpName} {
+		if b, err := dc.InspectContainer(ctx, n); err == nil && len(b) > 0 {
+			return nil, &errors.ValidationError{Field: "container", Msg: fmt.Sprintf("container %s exists; remove or rename it first", n)}
+		}
+	}
+	// the container holding the target name; the isolated one itself when promoted in place
+	var holder *types.ContainerJSON
+	if b, err := dc.InspectContainer(ctx, target); err == nil && len(b) > 0 {
+		if cj, err := docker.ParseContainerJSON(b); err == nil {
+			holder = &cj
+		}
+	}
+	isRunning := func(cj *types.ContainerJSON) bool {
+		return cj != nil && cj.State != nil && (cj.State.Running || cj.State.Paused)
+	}
+	inPlace := holder != nil && holder.ID == iso.ID
+	if holder != nil && !inPlace {
+		fate := "kept stopped as " + replacedName
+		if opts.RemoveReplaced {
+			fate = "removed"
+		}
+		if err := opts.Confirm.Confirm(fmt.Sprintf("Container %s will be stopped and %s", target, fate)); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg := *iso.Config
+	cfg.Image = iso.Image
+	cfg.Labels = withLabels(nil, iso.Config.Labels)
+	delete(cfg.Labels, LabelIsolated)
+	hostCfg := *iso.HostConfig
+	hostCfg.NetworkMode = container.NetworkMode(saved.NetworkMode)
+	hostCfg.PortBindings = saved.PortBindings
+	hostCfg.PublishAllPorts = saved.PublishAllPorts
+	netCfg := &network.NetworkingConfig{EndpointsConfig: saved.Endpoints}
+	if netCfg.EndpointsConfig == nil {
+		netCfg.EndpointsConfig = map[string]*network.EndpointSettings{}
+	}
+	var mounts []docker.Mount
+	for _, m := range iso.Mounts {
+		mounts = append(mounts, docker.Mount{Name: m.Name, Source: m.Source, Destination: m.Destination, Type: string(m.Type), RW: m.RW})
+	}
+	// created, not started: ports and static addresses are only claimed on start
+	id, err := dc.CreateContainerFromSpec(ctx, &cfg, &hostCfg, netCfg, tmpName)
+	if err != nil && strings.Contains(err.Error(), "not implemented") {
+		id, err = dc.CreateContainer(ctx, cfg.Image, tmpName, mounts, createOptions(&cfg, &hostCfg, netCfg))
+	}
+	if err != nil {
+		return nil, &errors.OperationError{Op: "create promoted " + target, Err: err}
+	}
+
+	start := isRunning(&iso) || isRunning(holder)
+	renamed := false
+	rollback := func(op string, err error) (*PromoteResult, error) {
+		_ = dc.RemoveContainer(ctx, id)
+		if renamed {
+			if rerr := dc.RenameContainer(ctx, holder.ID, target); rerr != nil {
+				log.Errorf("Could not give %s its name back: %v", replacedName, rerr)
+			}
+		}
+		if isRunning(holder) {
+			_ = dc.StartContainer(ctx, holder.ID)
+		}
+		if !inPlace && isRunning(&iso) {
+			_ = dc.StartContainer(ctx, iso.ID)
+		}
+		return nil, &errors.OperationError{Op: op, Err: err}
+	}
+	if isRunning(&iso) && !inPlace {
+		if err := dc.StopContainer(ctx, iso.ID); err != nil {
+			return rollback("stop isolated "+name, err)
+		}
+	}
+	if isRunning(holder) {
+		if err := dc.StopContainer(ctx, holder.ID); err != nil {
+			return rollback("stop "+target, err)
+		}
+	}
+	if holder != nil {
+		if err := dc.RenameContainer(ctx, holder.ID, replacedName); err != nil {
+			return rollback("rename "+target, err)
+		}
+		renamed = true
+	}
+	if err := dc.RenameContainer(ctx, id, target); err != nil {
+		return rollback("rename promoted container", err)
+	}
+	res := &PromoteResult{Container: target, ID: id}
+	if start {
+		if err := dc.StartContainer(ctx, id); err != nil {
+			return rollback("start "+target, err)
+		}
+		res.Started = true
+	}
+
+	if err := dc.RemoveContainer(ctx, iso.ID); err != nil {
+		log.Errorf("Could not remove isolated container %s: %v", name, err)
+	}
+	if holder != nil && !inPlace {
+		res.Replaced = replacedName
+		if opts.RemoveReplaced {
+			if err := dc.RemoveContainer(ctx, holder.ID); err != nil {
+				log.Errorf("Could not remove replaced container %s: %v", replacedName, err)
+			} else {
+				res.Replaced, res.ReplacedRemoved = target, true
+			}
+		}
+	}
+	if n, err := dc.InspectNetwork(ctx, saved.Network); err == nil && n != nil && len(n.InUse) == 0 {
+		if err := dc.RemoveNetwork(ctx, saved.Network); err == nil {
+			res.NetworkRemoved = saved.Network
+		}
+	}
+	return res, nil
+}
+
+// inspectContainer inspects and decodes a container.
+func inspectContainer(ctx context.Context, dc docker.DockerClient, name string) (types.ContainerJSON, error) {
+	b, err := dc.InspectContainer(ctx, name)
+	if err != nil || len(b) == 0 {
+		return types.ContainerJSON{}, &errors.NotFoundError{Resource: "container", Name: name}
+	}
+	cj, err := docker.ParseContainerJSON(b)
+	if err != nil {
+		return types.ContainerJSON{}, &errors.OperationError{Op: "parse container inspect of " + name, Err: err}
+	}
+	return cj, nil
+}
@@ -0,0 +1,354 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/lock"
+)
+
+// restoreJournal records the containers, volumes and networks a restore created (resources
+// that already existed are not recorded). It is rewritten after every change so that a run
+// killed outright still leaves a record for `dockerbackup cleanup`; a lock held for the
+// duration of the restore tells cleanup whether the owning run is still alive.
+type restoreJournal struct {
+	ID         string    `json:"id"`
+	Backup     string    `json:"backup"`
+	Started    time.Time `json:"started"`
+	Containers []string  `json:"containers,omitempty"`
+	Volumes    []string  `json:"volumes,omitempty"`
+	Networks   []string  `json:"networks,omitempty"`
+
+	mu   sync.Mutex
+	path string
+	lk   *lock.Lock
+}
+
+// JournalDir returns where restore journals are kept: $DOCKERBACKUP_JOURNAL_DIR or
+// <lock.RuntimeDir()>/journal, a directory only the current user can write to.
+func JournalDir() string {
+	if d := os.Getenv("DOCKERBACKUP_JOURNAL_DIR"); d != "" {
+		return d
+	}
+	return filepath.Join(lock.RuntimeDir(), "journal")
+}
+
+func newRestoreJournal(ctx context.Context, backupPath string) (*restoreJournal, error) {
+	dir := JournalDir()
+	if err := lock.PrivateDir(dir); err != nil {
+		return nil, err
+	}
+	id := fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405"), os.Getpid())
+	lk, err := lock.Acquire(ctx, "restore-"+id, false, 0)
+	if err != nil {
+		return nil, err
+	}
+	j := &restoreJournal{ID: id, Backup: backupPath, Started: time.Now().UTC(), path: filepath.Join(dir, id+".json"), lk: lk}
+	if err := j.write(); err != nil {
+		_ = lk.Release()
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *restoreJournal) record(add func()) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	add()
+	_ = j.write()
+}
+
+func (j *restoreJournal) addContainer(id string) {
+	j.record(func() { j.Containers = append(j.Containers, id) })
+}
+
+func (j *restoreJournal) addVolume(name string) {
+	j.record(func() { j.Volumes = append(j.Volumes, name) })
+}
+
+func (j *restoreJournal) addNetwork(name string) {
+	j.record(func() { j.Networks = append(j.Networks, name) })
+}
+
+// createdVolume and createdNetwork report whether this restore created the resource.
+func (j *restoreJournal) createdVolume(name string) bool {
+	return j.has(func() []string { return j.Volumes }, name)
+}
+
+func (j *restoreJournal) createdNetwork(name string) bool {
+	return j.has(func() []string { return j.Networks }, name)
+}
+
+func (j *restoreJournal) has(list func() []string, name string) bool {
+	if j == nil {
+		return false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return slices.Contains(list(), name)
+}
+
+func (j *restoreJournal) write() error {
+	b, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.path)
+}
+
+// finish removes the journal and releases its lock.
+func (j *restoreJournal) finish() {
+	if j == nil {
+		return
+	}
+	_ = os.Remove(j.path)
+	_ = j.lk.Release()
+}
+
+// undo removes the recorded resources, containers first since they hold volumes and networks.
+// Only resources labelled with this journal's ID are removed: the journal names what to
+// delete, the labels prove the restore created it. It uses its own context: the restore's
+// context is usually the one that was cancelled.
+func (j *restoreJournal) undo(dc docker.DockerClient, log logger.Logger) []error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	labelled, err := dc.ListResourcesByLabel(ctx, LabelRestoreID+"="+j.ID)
+	if err != nil {
+		return []error{fmt.Errorf("list resources of restore %s: %w", j.ID, err)}
+	}
+	ours := map[string]bool{}
+	for _, r := range labelled {
+		ours[r.Kind+":"+r.ID], ours[r.Kind+":"+r.Name] = true, true
+	}
+	owned := func(kind, name string) bool {
+		if ours[kind+":"+name] {
+			return true
+		}
+		log.Infof("Leaving %s %s: it does not carry the %s label of restore %s", kind, name, LabelRestoreID, j.ID)
+		return false
+	}
+	var errs []error
+	for i := len(j.Containers) - 1; i >= 0; i-- {
+		if !owned("container", j.Containers[i]) {
+			continue
+		}
+		if err := dc.RemoveContainer(ctx, j.Containers[i]); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Infof("Removed container %s", j.Containers[i])
+	}
+	for _, v := range j.Volumes {
+		if !owned("volume", v) {
+			continue
+		}
+		if err := dc.RemoveVolume(ctx, v); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Infof("Removed volume %s", v)
+	}
+	for _, n := range j.Networks {
+		if !owned("network", n) {
+			continue
+		}
+		if err := dc.RemoveNetwork(ctx, n); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Infof("Removed network %s", n)
+	}
+	return errs
+}
+
+// ensureNetwork creates the network if needed; new networks get the restore labels and are
+// journaled.
+func (e *DefaultBackupEngine) ensureNetwork(ctx context.Context, nc docker.NetworkConfig) {
+	existed := false
+	if n, err := e.dockerClient.InspectNetwork(ctx, nc.Name); err == nil && n != nil {
+		existed = true
+	} else {
+		nc.Labels = withLabels(nc.Labels, e.restoreLabels)
+	}
+	if err := e.dockerClient.EnsureNetwork(ctx, nc); err == nil && !existed {
+		e.journal.addNetwork(nc.Name)
+	}
+}
+
+// ensureVolume creates the volume with its captured driver/options; new volumes get the
+// restore labels and are journaled.
+func (e *DefaultBackupEngine) ensureVolume(ctx context.Context, vc docker.VolumeConfig) {
+	existed := e.volumeExists(ctx, vc.Name)
+	if !existed {
+		vc.Labels = withLabels(vc.Labels, e.restoreLabels)
+	}
+	if err := e.dockerClient.EnsureVolume(ctx, vc); err == nil && !existed {
+		e.journal.addVolume(vc.Name)
+	}
+}
+
+// createVolume is VolumeCreate with restore labels and journaling of new volumes.
+func (e *DefaultBackupEngine) createVolume(ctx context.Context, name string) error {
+	existed := e.volumeExists(ctx, name)
+	var labels map[string]string
+	if !existed {
+		labels = e.restoreLabels
+	}
+	if err := e.dockerClient.VolumeCreate(ctx, name, labels); err != nil {
+		return err
+	}
+	if !existed {
+		e.journal.addVolume(name)
+	}
+	return nil
+}
+
+func (e *DefaultBackupEngine) volumeExists(ctx context.Context, name string) bool {
+	v, err := e.dockerClient.InspectVolume(ctx, name)
+	return err == nil && v != nil
+}
+
+// trustedJournal checks a journal directory or file before cleanup acts on it: not a symlink,
+// owned by the current user and not writable by anyone else.
+func trustedJournal(path string, fi os.FileInfo, dir bool) error {
+	if dir != fi.IsDir() || (!dir && !fi.Mode().IsRegular()) {
+		return fmt.Errorf("%s is not a plain file or directory", path)
+	}
+	if fi.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("%s is writable by other users", path)
+	}
+	return lock.CheckOwner(path, fi)
+}
+
+// CleanupOptions selects what `dockerbackup cleanup` removes.
+type CleanupOptions struct {
+	// Remove temporary directories older than this (0 keeps them)
+	TempOlderThan time.Duration
+	// Also remove helper containers that are still running
+	Force  bool
+	DryRun bool
+}
+
+// CleanupReport lists what was (or, with DryRun, would be) removed.
+type CleanupReport struct {
+	HelperContainers []string
+	Journals         []string
+	Containers       []string
+	Volumes          []string
+	Networks         []string
+	TempDirs         []string
+}
+
+// Cleanup removes leftovers of interrupted runs: helper containers (by label), resources
+// recorded in journals of restores that are no longer running, and old temporary directories.
+func Cleanup(ctx context.Context, dc docker.DockerClient, log logger.Logger, opts CleanupOptions) (*CleanupReport, error) {
+	rep := &CleanupReport{}
+	helpers, err := dc.ListContainersByLabel(ctx, docker.HelperLabel)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range helpers {
+		// a running helper belongs to an extraction in progress unless forced
+		if h.State == "running" && !opts.Force {
+			continue
+		}
+		rep.HelperContainers = append(rep.HelperContainers, h.Name)
+		if !opts.DryRun {
+			if err := dc.RemoveContainer(ctx, h.ID); err != nil {
+				log.Errorf("remove helper %s: %v", h.Name, err)
+			}
+		}
+	}
+
+	// journals in a directory or file another user controls could name anything to delete
+	jdir := JournalDir()
+	var paths []string
+	if fi, err := os.Lstat(jdir); err == nil {
+		if err := trustedJournal(jdir, fi, true); err != nil {
+			log.Errorf("Ignoring restore journals: %v", err)
+		} else {
+			paths, _ = filepath.Glob(filepath.Join(jdir, "*.json"))
+		}
+	}
+	for _, p := range paths {
+		fi, err := os.Lstat(p)
+		if err != nil {
+			continue
+		}
+		if err := trustedJournal(p, fi, false); err != nil {
+			log.Errorf("Ignoring restore journal: %v", err)
+			continue
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		var j restoreJournal
+		if err := json.Unmarshal(b, &j); err != nil || j.ID == "" {
+			continue
+		}
+		// the owning restore holds this lock while it runs
+		lk, err := lock.Acquire(ctx, "restore-"+j.ID, false, 0)
+		if err != nil {
+			continue
+		}
+		rep.Journals = append(rep.Journals, j.ID)
+		rep.Containers = append(rep.Containers, j.Containers...)
+		rep.Volumes = append(rep.Volumes, j.Volumes...)
+		rep.Networks = append(rep.Networks, j.Networks...)
+		if !opts.DryRun {
+			log.Infof("Undoing interrupted restore %s of %s", j.ID, j.Backup)
+			if errs := j.undo(dc, log); len(errs) > 0 {
+				for _, err := range errs {
+					log.Errorf("%v", err)
+				}
+			} else {
+				_ = os.Remove(p)
+			}
+		}
+		_ = lk.Release()
+	}
+
+	if opts.TempOlderThan > 0 {
+		// work directories are created in $DOCKERBACKUP_WORK_DIR when set, in the system one
+		// otherwise (and were, before it was set)
+		dirs := []string{os.TempDir()}
+		if wd := WorkDir(); wd != "" && filepath.Clean(wd) != filepath.Clean(os.TempDir()) {
+			dirs = append(dirs, wd)
+		}
+		for _, dir := range dirs {
+			entries, _ := os.ReadDir(dir)
+			for _, en := range entries {
+				if !en.IsDir() || !strings.HasPrefix(en.Name(), "dockerbackup_") {
+					continue
+				}
+				info, err := en.Info()
+				if err != nil || time.Since(info.ModTime()) < opts.TempOlderThan {
+					continue
+				}
+				p := filepath.Join(dir, en.Name())
+				rep.TempDirs = append(rep.TempDirs, p)
+				if !opts.DryRun {
+					_ = os.RemoveAll(p)
+				}
+			}
+		}
+	}
+	return rep, nil
+}
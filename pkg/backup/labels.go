@@ -0,0 +1,71 @@
+package backup
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"time"
+
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+// Labels put on every container, volume, network and image a restore creates, so resources
+// created by dockerbackup can be traced back to their backup (`dockerbackup ls-restored`).
+const (
+	LabelRestored   = "dockerbackup.restored"
+	LabelBackupID   = "dockerbackup.backup-id"
+	LabelBackupFile = "dockerbackup.backup-file"
+	LabelRestoredAt = "dockerbackup.restored-at"
+	// LabelRestoreID is the ID of the restore journal that created the resource; cleanup only
+	// undoes resources carrying the ID of the journal it undoes
+	LabelRestoreID = "dockerbackup.restore-id"
+)
+
+// setRestoreLabels sets e.restoreLabels for the restore of the backup extracted to dir, unless
+// an enclosing restore did already.
+func (e *DefaultBackupEngine) setRestoreLabels(dir, backupPath string) {
+	if e.restoreLabels != nil {
+		return
+	}
+	e.restoreLabels = restoreLabels(e.filesystem, dir, backupPath, time.Now())
+	if e.journal != nil {
+		e.restoreLabels[LabelRestoreID] = e.journal.ID
+	}
+}
+
+// restoreLabels builds the labels for resources restored from the backup extracted to dir.
+func restoreLabels(fsys filesystem.Handler, dir, backupPath string, now time.Time) map[string]string {
+	b, _ := fsys.ReadFile(filepath.Join(dir, "metadata.json"))
+	return map[string]string{
+		LabelRestored:   "true",
+		LabelBackupID:   backupID(b, backupPath),
+		LabelBackupFile: filepath.Base(backupPath),
+		LabelRestoredAt: now.UTC().Format(time.RFC3339),
+	}
+}
+
+// backupID returns the ID recorded in metadata; backups made before IDs were recorded fall
+// back to the archive name.
+func backupID(metadata []byte, backupPath string) string {
+	var meta struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(metadata, &meta) == nil && meta.ID != "" {
+		return meta.ID
+	}
+	return filepath.Base(backupPath)
+}
+
+// withLabels returns labels extended with add, without modifying either map.
+func withLabels(labels, add map[string]string) map[string]string {
+	if len(add) == 0 {
+		return labels
+	}
+	out := make(map[string]string, len(labels)+len(add))
+	for k, v := range labels {
+		out[k] = v
+	}
+	for k, v := range add {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,149 @@
+package backup
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+// BackupInfo is the identity and lineage recorded in a backup's metadata.json.
+type BackupInfo struct {
+	ID            string    `json:"id,omitempty"`
+	ParentID      string    `json:"parentId,omitempty"`
+	Parent        string    `json:"parent,omitempty"`
+	HostID        string    `json:"hostId,omitempty"`
+	Hostname      string    `json:"hostname,omitempty"`
+	CreatedAt     time.Time `json:"createdAt,omitempty"`
+	ContainerName string    `json:"containerName,omitempty"`
+	ProjectName   string    `json:"projectName,omitempty"`
+	VolumeRefs    []string  `json:"volumeRefs,omitempty"`
+	Tags          []string  `json:"tags,omitempty"`
+	Note          string    `json:"note,omitempty"`
+	// Services are the compose services, or the containers of a multi-container (Group) backup
+	Services []string `json:"services,omitempty"`
+	Group    bool     `json:"group,omitempty"`
+}
+
+// HasTag reports whether the backup was tagged with tag.
+func (b *BackupInfo) HasTag(tag string) bool {
+	for _, t := range b.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// newBackupID returns a random (version 4) UUID identifying a backup.
+func newBackupID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// hostID identifies the machine a backup was taken on: the systemd/dbus machine ID when
+// available, otherwise the hostname.
+func hostID(fsys filesystem.Handler) string {
+	for _, p := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		if b, err := fsys.ReadFile(p); err == nil {
+			if id := strings.TrimSpace(string(b)); id != "" {
+				return id
+			}
+		}
+	}
+	h, _ := os.Hostname()
+	return h
+}
+
+// ReadBackupInfo reads metadata.json from a backup archive (or split manifest).
+func ReadBackupInfo(ctx context.Context, backupPath string) (*BackupInfo, error) {
+	b, err := archive.NewTarArchiveHandler().ReadEntry(ctx, backupPath, "metadata.json")
+	if err != nil {
+		return nil, fmt.Errorf("read metadata.json from %s: %w", backupPath, err)
+	}
+	var info BackupInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, fmt.Errorf("parse metadata.json from %s: %w", backupPath, err)
+	}
+	return &info, nil
+}
+
+// Lineage returns the backup at backupPath followed by its ancestors. Parents are looked up
+// next to backupPath; the chain stops at the first parent that is missing or unreadable.
+func Lineage(ctx context.Context, backupPath string) ([]*BackupInfo, error) {
+	info, err := ReadBackupInfo(ctx, backupPath)
+	if err != nil {
+		return nil, err
+	}
+	chain := []*BackupInfo{info}
+	seen := map[string]struct{}{info.ID: {}}
+	for info.Parent != "" {
+		parent, err := ReadBackupInfo(ctx, filepath.Join(filepath.Dir(backupPath), info.Parent))
+		if err != nil {
+			break
+		}
+		if _, ok := seen[parent.ID]; ok {
+			break
+		}
+		seen[parent.ID] = struct{}{}
+		chain = append(chain, parent)
+		info = parent
+	}
+	return chain, nil
+}
+
+// CatalogEntry is a backup found in a directory, with its metadata.
+type CatalogEntry struct {
+	File string `json:"file"`
+	Size int64  `json:"size"`
+	*BackupInfo
+}
+
+// Catalog lists the backups in dir, newest first: those of target (a container or compose
+// project name) when it is set, and carrying every one of tags. Archives whose metadata
+// cannot be read are left out. CreatedAt falls back to the archive name or modification time.
+func Catalog(ctx context.Context, dir, target string, tags []string) ([]CatalogEntry, error) {
+	files, err := ListBackups(dir)
+	if err != nil {
+		return nil, err
+	}
+	fsys := filesystem.NewHandler()
+	entries := []CatalogEntry{}
+	for _, f := range files {
+		info, err := ReadBackupInfo(ctx, f.Path)
+		if err != nil {
+			continue
+		}
+		if target != "" && strings.TrimPrefix(info.ContainerName, "/") != target && info.ProjectName != target {
+			continue
+		}
+		matches := true
+		for _, t := range tags {
+			matches = matches && info.HasTag(t)
+		}
+		if !matches {
+			continue
+		}
+		if info.CreatedAt.IsZero() {
+			info.CreatedAt = f.Time
+		}
+		en := CatalogEntry{File: filepath.Base(f.Path), BackupInfo: info}
+		if st, err := fsys.Stat(f.Path); err == nil {
+			en.Size = st.Size()
+		}
+		entries = append(entries, en)
+	}
+	return entries, nil
+}
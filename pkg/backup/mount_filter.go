@@ -0,0 +1,105 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+// mountSelected reports whether a mount's data is archived under the --include-volume,
+// --exclude-volume and --skip-bind-mounts options. Volume names may be glob patterns
+// (e.g. "cache_*"); an exclusion wins over an inclusion.
+func mountSelected(m docker.Mount, opts BackupOptions) bool {
+	if m.Type == "bind" {
+		return !opts.SkipBindMounts
+	}
+	if m.Type != "volume" {
+		return true
+	}
+	if matchesAny(opts.ExcludeVolumes, m.Name) {
+		return false
+	}
+	return len(opts.IncludeVolumes) == 0 || matchesAny(opts.IncludeVolumes, m.Name)
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, err := path.Match(p, name); p == name || (err == nil && ok) {
+			return true
+		}
+	}
+	return false
+}
+
+// unmatchedPatterns returns the --include-volume patterns that match none of the container's
+// volumes, which usually means a typo.
+func unmatchedPatterns(patterns []string, mounts []docker.Mount) []string {
+	var out []string
+	for _, p := range patterns {
+		found := false
+		for _, m := range mounts {
+			if m.Type == "volume" && matchesAny([]string{p}, m.Name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// mountBoundary returns how far the archive of a mount's data at source (id names the mount in
+// warnings) may descend, and the mountpoints below source whose contents are left out under
+// --nested-mounts skip. A bind mount of / or of a directory holding other mounts would
+// otherwise pull in whole other filesystems, so mountpoints archived along are warned about.
+func (e *DefaultBackupEngine) mountBoundary(id, source string, opts BackupOptions) (filesystem.Boundary, []string) {
+	b := filesystem.Boundary{OneFileSystem: opts.OneFileSystem}
+	nested, err := filesystem.NestedMounts(source)
+	if err != nil || len(nested) == 0 {
+		return b, nil
+	}
+	if opts.NestedMounts == NestedMountsSkip {
+		e.warn(WarnNestedMount, id, "Leaving out the contents of the mountpoints below %s: %s", id, strings.Join(nested, ", "))
+		b.Skip = nested
+		return b, nested
+	}
+	if !opts.OneFileSystem {
+		e.warn(WarnNestedMount, id, "%s holds other mounts (%s), archived with it; leave them out with --nested-mounts skip or --one-file-system", id, strings.Join(nested, ", "))
+	}
+	return b, nil
+}
+
+// specialMountPaths are host paths whose bind mounts hold no data worth a backup: the Docker
+// and containerd sockets and state, the daemon's data root, and the kernel's pseudo
+// filesystems. Archiving them fails or captures another container's world.
+var specialMountPaths = []string{
+	"/var/run/docker.sock",
+	"/run/docker.sock",
+	"/var/run/containerd",
+	"/run/containerd",
+	"/var/lib/docker",
+	"/var/lib/containerd",
+	"/proc",
+	"/sys",
+	"/dev",
+}
+
+// specialMount reports whether a bind mount source is one of specialMountPaths or below one,
+// or a socket, device or named pipe anywhere else. Such mounts are skipped unless
+// --allow-special-mounts is given; the restored container still gets the bind mount.
+func specialMount(fsys filesystem.Handler, source string) bool {
+	source = filepath.Clean(source)
+	for _, p := range specialMountPaths {
+		if source == p || strings.HasPrefix(source, p+"/") {
+			return true
+		}
+	}
+	fi, err := fsys.Stat(source)
+	return err == nil && fi.Mode()&(os.ModeSocket|os.ModeDevice|os.ModeNamedPipe) != 0
+}
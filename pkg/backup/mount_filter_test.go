@@ -0,0 +1,69 @@
+package backup
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+func TestMountSelected(t *testing.T) {
+	data := docker.Mount{Type: "volume", Name: "app_data"}
+	cache := docker.Mount{Type: "volume", Name: "app_cache"}
+	bind := docker.Mount{Type: "bind", Source: "/srv/config"}
+	cases := []struct {
+		name string
+		opts BackupOptions
+		want map[string]bool
+	}{
+		{"default archives everything", BackupOptions{}, map[string]bool{"app_data": true, "app_cache": true, "/srv/config": true}},
+		{"include", BackupOptions{IncludeVolumes: []string{"app_data"}}, map[string]bool{"app_data": true, "app_cache": false, "/srv/config": true}},
+		{"exclude glob", BackupOptions{ExcludeVolumes: []string{"*_cache"}}, map[string]bool{"app_data": true, "app_cache": false, "/srv/config": true}},
+		{"exclude wins", BackupOptions{IncludeVolumes: []string{"app_*"}, ExcludeVolumes: []string{"app_cache"}}, map[string]bool{"app_data": true, "app_cache": false, "/srv/config": true}},
+		{"skip binds", BackupOptions{SkipBindMounts: true}, map[string]bool{"app_data": true, "app_cache": true, "/srv/config": false}},
+	}
+	for _, tc := range cases {
+		for _, m := range []docker.Mount{data, cache, bind} {
+			id := m.Name
+			if m.Type == "bind" {
+				id = m.Source
+			}
+			if got := mountSelected(m, tc.opts); got != tc.want[id] {
+				t.Errorf("%s: mountSelected(%s) = %v, want %v", tc.name, id, got, tc.want[id])
+			}
+		}
+	}
+	if got := unmatchedPatterns([]string{"app_*", "db"}, []docker.Mount{data, cache, bind}); len(got) != 1 || got[0] != "db" {
+		t.Errorf("unmatchedPatterns = %v", got)
+	}
+}
+
+func TestSpecialMount(t *testing.T) {
+	dir := t.TempDir()
+	for source, want := range map[string]bool{
+		"/var/run/docker.sock":      true,
+		"/run/docker.sock":          true,
+		"/proc":                     true,
+		"/sys/fs/cgroup":            true,
+		"/dev/":                     true,
+		"/var/lib/docker/volumes/x": true,
+		"/device":                   false,
+		"/srv/config":               false,
+		dir:                         false,
+	} {
+		if got := specialMount(filesystem.NewHandler(), source); got != want {
+			t.Errorf("specialMount(%s) = %v, want %v", source, got, want)
+		}
+	}
+	sock := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	defer l.Close()
+	if !specialMount(filesystem.NewHandler(), sock) {
+		t.Errorf("specialMount(%s) = false for a socket", sock)
+	}
+}
@@ -0,0 +1,182 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/docker"
+)
+
+// mountsFileName is the container backup entry mapping each mount to the archive holding its
+// data, so restore does not have to derive archive names (which changed over time) itself.
+const mountsFileName = "mounts.json"
+
+// mountArtifact records where the data of one mount was archived.
+type mountArtifact struct {
+	Type        string `json:"type"`
+	Name        string `json:"name,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination"`
+	// Artifact is the entry holding the data, e.g. "volumes/data.tar.gz"; a --skip-unchanged
+	// backup may store a .ref.json reference in its place. Empty when the data was not archived.
+	Artifact string `json:"artifact,omitempty"`
+	// Root is the top-level directory of the data inside Artifact
+	Root string `json:"root,omitempty"`
+	// Skipped says why the data was not archived: "excluded", "remote", "shared" or
+	// "special" (a bind mount of the Docker socket, /proc and the like)
+	Skipped string `json:"skipped,omitempty"`
+	// SharedWith is the service whose archive holds the data of a shared volume
+	SharedWith string `json:"sharedWith,omitempty"`
+	// NestedMounts are the mountpoints below the host path whose contents were left out
+	// (--nested-mounts skip); they are archived as empty directories
+	NestedMounts []string `json:"nestedMounts,omitempty"`
+	// Userns is the subordinate range the owners in Artifact lie in, when the data was read on
+	// the host of a userns-remap daemon
+	Userns *docker.UsernsRange `json:"userns,omitempty"`
+	// Content summarizes the archived data of a volume, which restore checks the volume
+	// against once it is extracted
+	Content *archive.ContentSummary `json:"content,omitempty"`
+}
+
+func newMountArtifact(m docker.Mount) mountArtifact {
+	a := mountArtifact{Type: m.Type, Destination: m.Destination}
+	if m.Type == "volume" {
+		a.Name = m.Name
+	} else {
+		a.Source = m.Source
+	}
+	return a
+}
+
+type mountMap []mountArtifact
+
+// readMountMap reads <dir>/mounts.json. Backups taken before it existed yield a nil map.
+func (e *DefaultBackupEngine) readMountMap(dir string) mountMap {
+	b, err := e.filesystem.ReadFile(filepath.Join(dir, mountsFileName))
+	if err != nil {
+		return nil
+	}
+	var mm mountMap
+	if json.Unmarshal(b, &mm) != nil {
+		return nil
+	}
+	return mm
+}
+
+func (e *DefaultBackupEngine) writeMountMap(dir string, mm mountMap) error {
+	if mm == nil {
+		mm = mountMap{}
+	}
+	b, err := json.MarshalIndent(mm, "", "  ")
+	if err != nil {
+		return err
+	}
+	return e.filesystem.WriteFile(filepath.Join(dir, mountsFileName), b, 0o644)
+}
+
+// volume returns the artifact recorded for the named volume.
+func (mm mountMap) volume(name string) (mountArtifact, bool) {
+	for _, a := range mm {
+		if a.Type == "volume" && a.Name == name {
+			return a, true
+		}
+	}
+	return mountArtifact{}, false
+}
+
+// bind returns the artifact recorded for the bind mount of source.
+func (mm mountMap) bind(source string) (mountArtifact, bool) {
+	for _, a := range mm {
+		if a.Type == "bind" && filepath.Clean(a.Source) == filepath.Clean(source) {
+			return a, true
+		}
+	}
+	return mountArtifact{}, false
+}
+
+// recordContent summarizes the archived data of each volume in mm.
+func (e *DefaultBackupEngine) recordContent(ctx context.Context, dir string, mm mountMap) {
+	for i := range mm {
+		a := &mm[i]
+		p := artifactPath(dir, *a)
+		if a.Type != "volume" || !strings.HasSuffix(p, ".tar.gz") {
+			continue
+		}
+		if _, err := e.filesystem.Stat(p); err != nil {
+			continue
+		}
+		c, err := e.contentDigest(ctx, p, a.Root)
+		if err != nil {
+			e.log.Debugf("Could not summarize the data of volume %s: %v", a.Name, err)
+			continue
+		}
+		a.Content = c
+	}
+}
+
+// artifactPath returns where the data of a lies under an extracted backup dir, or "" when
+// it was not archived or the recorded entry is not a file under volumes/.
+func artifactPath(dir string, a mountArtifact) string {
+	p := path.Clean(a.Artifact)
+	if a.Artifact == "" || path.Dir(p) != "volumes" {
+		return ""
+	}
+	return filepath.Join(dir, filepath.FromSlash(p))
+}
+
+// MountArtifacts returns the archive entry holding the data of each mount of the container
+// backup at backupPath, by mount destination. Backups taken before mounts.json was recorded
+// are matched by the names the backup would have given the entries. Mounts whose data was
+// not archived, or lives in another archive (--skip-unchanged), are left out.
+func MountArtifacts(ctx context.Context, backupPath string) (map[string]string, error) {
+	th := archive.NewTarArchiveHandler()
+	out := map[string]string{}
+	if b, err := th.ReadEntry(ctx, backupPath, mountsFileName); err == nil {
+		var mm mountMap
+		if err := json.Unmarshal(b, &mm); err != nil {
+			return nil, err
+		}
+		for _, a := range mm {
+			if p := path.Clean(a.Artifact); a.Skipped == "" && path.Dir(p) == "volumes" && strings.HasSuffix(p, ".tar.gz") {
+				out[a.Destination] = p
+			}
+		}
+		return out, nil
+	}
+	b, err := th.ReadEntry(ctx, backupPath, "container.json")
+	if err != nil {
+		return nil, err
+	}
+	cj, err := docker.ParseContainerJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := th.ListArchive(ctx, backupPath)
+	if err != nil {
+		return nil, err
+	}
+	present := map[string]bool{}
+	for _, en := range entries {
+		present[path.Clean(en.Path)] = true
+	}
+	for _, m := range cj.Mounts {
+		var names []string
+		switch {
+		case m.Type == "volume" && m.Name != "":
+			names = []string{safeName(m.Name)}
+		case m.Type == "bind" && m.Source != "":
+			names = []string{bindArchiveName(m.Source), legacyBindArchiveName(m.Source)}
+		}
+		for _, n := range names {
+			if p := "volumes/" + n + ".tar.gz"; present[p] {
+				out[m.Destination] = p
+				break
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,206 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+// TimestampLayout is used in timestamped archive names; it avoids ':' so names stay valid on
+// every filesystem and sort chronologically.
+const TimestampLayout = "2006-01-02T15-04-05"
+
+const latestSuffix = "_latest.tar.gz"
+
+// bindArchiveName is the name (without .tar.gz) of the archive in volumes/ holding the data of
+// a bind mount of source: its base name and a hash of the full path, since sources often
+// share a base name (/srv/a/data, /srv/b/data).
+func bindArchiveName(source string) string {
+	sum := sha256.Sum256([]byte(filepath.Clean(source)))
+	return "bind_" + safeName(filepath.Base(source)) + "_" + hex.EncodeToString(sum[:4])
+}
+
+// legacyBindArchiveName is the bind_<base> name of backups taken before bindArchiveName.
+func legacyBindArchiveName(source string) string {
+	return "bind_" + strings.NewReplacer("/", "-", "\\", "-", " ", "-", ":", "-", "\t", "-").Replace(filepath.Base(source))
+}
+
+// timestampedOutputPath returns <dir>/<name>_<timestamp>.tar.gz. A non-empty output is
+// treated as the target directory.
+func timestampedOutputPath(output, defaultDir, name string, now time.Time) string {
+	dir := output
+	if dir == "" {
+		dir = defaultDir
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s_%s.tar.gz", name, now.UTC().Format(TimestampLayout)))
+}
+
+// updateLatestLink points <dir>/<name>_latest.tar.gz at archivePath using a relative symlink,
+// replaced atomically so readers never see a missing link.
+func updateLatestLink(fsys filesystem.Handler, archivePath, name string) error {
+	dir := filepath.Dir(archivePath)
+	link := filepath.Join(dir, name+latestSuffix)
+	tmp := link + ".tmp"
+	_ = fsys.RemoveAll(tmp)
+	if err := fsys.Symlink(filepath.Base(archivePath), tmp); err != nil {
+		return err
+	}
+	return fsys.Rename(tmp, link)
+}
+
+// BackupFile is a backup archive found in a directory.
+type BackupFile struct {
+	Path string
+	// Time is parsed from a timestamped name, or the modification time otherwise.
+	Time time.Time
+}
+
+// ListBackups returns the *.tar.gz archives (or split archive manifests) in dir, newest first.
+// _latest symlinks are skipped since they duplicate the archive they point to.
+func ListBackups(dir string) ([]BackupFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []BackupFile
+	for _, en := range entries {
+		name := strings.TrimSuffix(en.Name(), archive.SplitManifestSuffix)
+		if en.IsDir() || !strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, latestSuffix) {
+			continue
+		}
+		p := filepath.Join(dir, en.Name())
+		t, ok := parseArchiveTimestamp(name)
+		if !ok {
+			info, err := en.Info()
+			if err != nil {
+				continue
+			}
+			t = info.ModTime()
+		}
+		out = append(out, BackupFile{Path: p, Time: t})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Time.After(out[j].Time) })
+	return out, nil
+}
+
+// FindBackup returns the path of the archive (or split manifest) named name in dir, as listed by
+// ListBackups; a <name>_latest.tar.gz link resolves to the archive it points to. Names with a
+// directory part are rejected, so callers may pass names from untrusted input.
+func FindBackup(dir, name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return "", &errors.ValidationError{Field: "backup", Msg: fmt.Sprintf("invalid backup name %q", name)}
+	}
+	files, err := ListBackups(dir)
+	if err != nil {
+		return "", &errors.OperationError{Op: "list backups in " + dir, Err: err}
+	}
+	for _, f := range files {
+		if filepath.Base(f.Path) == name {
+			return f.Path, nil
+		}
+	}
+	if strings.HasSuffix(name, latestSuffix) {
+		target, err := filepath.EvalSymlinks(filepath.Join(dir, name))
+		resolvedDir, derr := filepath.EvalSymlinks(dir)
+		if err == nil && derr == nil && filepath.Dir(target) == resolvedDir {
+			return target, nil
+		}
+	}
+	return "", &errors.NotFoundError{Resource: "backup", Name: name}
+}
+
+func parseArchiveTimestamp(name string) (time.Time, bool) {
+	base := strings.TrimSuffix(name, ".tar.gz")
+	if len(base) < len(TimestampLayout) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(TimestampLayout, base[len(base)-len(TimestampLayout):])
+	return t, err == nil
+}
+
+// NewestValidBackup returns the newest archive in dir accepted by valid.
+func NewestValidBackup(ctx context.Context, dir string, valid func(ctx context.Context, path string) bool) (string, error) {
+	files, err := ListBackups(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		if valid(ctx, f.Path) {
+			return f.Path, nil
+		}
+	}
+	return "", fmt.Errorf("no valid backup found in %s", dir)
+}
+
+// BackupAt returns the newest backup in dir of target (a container or compose project name;
+// any backup when empty) taken at or before at (no limit when zero) and accepted by valid.
+// The time comes from metadata.json, else from the archive name or modification time. An
+// incremental (--skip-unchanged) backup is only picked when the earlier archives holding its
+// unchanged volumes are still in dir; otherwise an older, restorable backup is used.
+func BackupAt(ctx context.Context, dir, target string, at time.Time, valid func(ctx context.Context, path string) bool) (string, error) {
+	files, err := ListBackups(dir)
+	if err != nil {
+		return "", err
+	}
+	fsys := filesystem.NewHandler()
+	type candidate struct {
+		path  string
+		taken time.Time
+	}
+	var candidates []candidate
+	for _, f := range files {
+		info, err := ReadBackupInfo(ctx, f.Path)
+		if err != nil {
+			continue
+		}
+		if target != "" && strings.TrimPrefix(info.ContainerName, "/") != target && info.ProjectName != target {
+			continue
+		}
+		taken := f.Time
+		if !info.CreatedAt.IsZero() {
+			taken = info.CreatedAt
+		}
+		if !at.IsZero() && taken.After(at) {
+			continue
+		}
+		if missing := missingVolumeRefs(fsys, dir, info); len(missing) > 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{path: f.Path, taken: taken})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].taken.After(candidates[j].taken) })
+	for _, c := range candidates {
+		if valid(ctx, c.path) {
+			return c.path, nil
+		}
+	}
+	what := "backup"
+	if target != "" {
+		what = "backup of " + target
+	}
+	if !at.IsZero() {
+		return "", fmt.Errorf("no valid %s taken at or before %s in %s", what, at.Format(time.RFC3339), dir)
+	}
+	return "", fmt.Errorf("no valid %s found in %s", what, dir)
+}
+
+// missingVolumeRefs lists the archives referenced for unchanged volumes that are not in dir.
+func missingVolumeRefs(fsys filesystem.Handler, dir string, info *BackupInfo) []string {
+	var missing []string
+	for _, ref := range info.VolumeRefs {
+		if _, err := fsys.Stat(filepath.Join(dir, filepath.Base(ref))); err != nil {
+			missing = append(missing, ref)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,223 @@
+package backup
+
+import (
+	"context"
+	stdErrors "errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+func TestTimestampedNamingAndNewestBackup(t *testing.T) {
+	dir := t.TempDir()
+	t1 := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	older := timestampedOutputPath(dir, "", "web", t1)
+	newer := timestampedOutputPath(dir, "", "web", t1.Add(time.Hour))
+	if filepath.Base(older) != "web_2024-06-01T12-00-00.tar.gz" {
+		t.Fatalf("unexpected name %s", older)
+	}
+	for _, p := range []string{older, newer} {
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := updateLatestLink(filesystem.NewHandler(), older, "web"); err != nil {
+		t.Fatalf("updateLatestLink: %v", err)
+	}
+	if err := updateLatestLink(filesystem.NewHandler(), newer, "web"); err != nil {
+		t.Fatalf("updateLatestLink (replace): %v", err)
+	}
+	target, err := os.Readlink(filepath.Join(dir, "web_latest.tar.gz"))
+	if err != nil || target != filepath.Base(newer) {
+		t.Fatalf("latest link = %q, %v", target, err)
+	}
+
+	files, err := ListBackups(dir)
+	if err != nil || len(files) != 2 || files[0].Path != newer {
+		t.Fatalf("unexpected ListBackups %+v, %v", files, err)
+	}
+	// the newest archive is invalid: fall back to the previous one
+	got, err := NewestValidBackup(context.Background(), dir, func(_ context.Context, p string) bool {
+		return !strings.Contains(p, "13-00-00")
+	})
+	if err != nil || got != older {
+		t.Fatalf("NewestValidBackup = %s, %v; want %s", got, err, older)
+	}
+}
+
+func TestBackupAt(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	base := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	write := func(name, meta string) {
+		work := t.TempDir()
+		if err := os.WriteFile(filepath.Join(work, "metadata.json"), []byte(meta), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := archive.NewTarArchiveHandler().CreateArchive(ctx, []archive.ArchiveSource{{Path: work, DestPath: "."}}, filepath.Join(dir, name)); err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+	}
+	write("web_1.tar.gz", `{"containerName":"web","createdAt":"2024-06-01T01:00:00Z"}`)
+	write("db_1.tar.gz", `{"containerName":"db","createdAt":"2024-06-01T02:30:00Z"}`)
+	write("web_2.tar.gz", `{"containerName":"web","createdAt":"2024-06-01T02:00:00Z"}`)
+	// incremental backup whose referenced archive was pruned
+	write("web_3.tar.gz", `{"containerName":"web","createdAt":"2024-06-01T02:45:00Z","volumeRefs":["web_0.tar.gz"]}`)
+	write("web_4.tar.gz", `{"containerName":"web","createdAt":"2024-06-01T04:00:00Z"}`)
+	all := func(context.Context, string) bool { return true }
+
+	for _, tc := range []struct {
+		target string
+		at     time.Time
+		want   string
+	}{
+		{"web", base.Add(3 * time.Hour), "web_2.tar.gz"},
+		{"web", base.Add(90 * time.Minute), "web_1.tar.gz"},
+		{"web", time.Time{}, "web_4.tar.gz"},
+		{"", base.Add(3 * time.Hour), "db_1.tar.gz"},
+	} {
+		got, err := BackupAt(ctx, dir, tc.target, tc.at, all)
+		if err != nil || filepath.Base(got) != tc.want {
+			t.Fatalf("BackupAt(%q, %s) = %s, %v; want %s", tc.target, tc.at, got, err, tc.want)
+		}
+	}
+	if _, err := BackupAt(ctx, dir, "web", base, all); err == nil {
+		t.Fatalf("expected no backup before the first one")
+	}
+}
+
+func TestBackupStatus(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	write := func(name, meta string) {
+		work := t.TempDir()
+		if err := os.WriteFile(filepath.Join(work, "metadata.json"), []byte(meta), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := archive.NewTarArchiveHandler().CreateArchive(ctx, []archive.ArchiveSource{{Path: work, DestPath: "."}}, filepath.Join(dir, name)); err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+	}
+	write("web_1.tar.gz", `{"containerName":"web","createdAt":"2024-06-01T01:00:00Z"}`)
+	write("web_2.tar.gz", `{"containerName":"/web","createdAt":"2024-06-02T01:00:00Z"}`)
+	write("shop.tar.gz", `{"projectName":"shop","services":["api"],"createdAt":"2024-06-01T12:00:00Z"}`)
+	write("stack.tar.gz", `{"group":true,"services":["cache","queue"],"createdAt":"2024-05-01T00:00:00Z"}`)
+	containers := []docker.ContainerRef{{Name: "web"}, {Name: "shop-api-1", Project: "shop"}, {Name: "cache"}, {Name: "lone"}}
+	now := time.Date(2024, 6, 2, 6, 0, 0, 0, time.UTC)
+
+	got, err := BackupStatus(ctx, dir, containers, 24*time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]struct {
+		file  string
+		stale bool
+	}{"web": {"web_2.tar.gz", false}, "shop-api-1": {"shop.tar.gz", false}, "cache": {"stack.tar.gz", true}, "lone": {"", true}}
+	for _, st := range got {
+		w := want[st.Container]
+		file := ""
+		if st.Backup != "" {
+			file = filepath.Base(st.Backup)
+			if st.Size == 0 {
+				t.Fatalf("%s: no size for %s", st.Container, file)
+			}
+		}
+		if file != w.file || st.Stale != w.stale {
+			t.Fatalf("%s: backup %q stale %v; want %q stale %v", st.Container, file, st.Stale, w.file, w.stale)
+		}
+	}
+
+	got, _ = BackupStatus(ctx, dir, containers, 0, now)
+	for _, st := range got {
+		if st.Stale {
+			t.Fatalf("%s is stale without a maximum age", st.Container)
+		}
+	}
+}
+
+func TestScheduleAndRetentionPolicy(t *testing.T) {
+	for s, want := range map[string]time.Duration{"daily": 24 * time.Hour, "Hourly": time.Hour, "6h": 6 * time.Hour, "2d": 48 * time.Hour} {
+		if d, err := ParseSchedule(s); err != nil || d != want {
+			t.Fatalf("ParseSchedule(%q) = %v, %v; want %v", s, d, err, want)
+		}
+	}
+	for _, s := range []string{"", "often", "-1h", "0d"} {
+		if _, err := ParseSchedule(s); err == nil {
+			t.Fatalf("ParseSchedule(%q) should fail", s)
+		}
+	}
+	p, err := ParsePolicy("keep=2, keep-within=3d")
+	if err != nil || p.KeepLast != 2 || p.KeepWithin != 72*time.Hour {
+		t.Fatalf("ParsePolicy = %+v, %v", p, err)
+	}
+	for _, s := range []string{"keep=0", "keep-within=soon", "max=3"} {
+		if _, err := ParsePolicy(s); err == nil {
+			t.Fatalf("ParsePolicy(%q) should fail", s)
+		}
+	}
+
+	now := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	var backups []ContainerBackup
+	for i := 0; i < 6; i++ {
+		at := now.Add(-time.Duration(i) * 24 * time.Hour)
+		backups = append(backups, ContainerBackup{Path: timestampedOutputPath("/b", "", "web", at), Info: &BackupInfo{ContainerName: "web", CreatedAt: at}})
+	}
+	// the newest backup takes its volumes from the oldest one
+	backups[0].Info.VolumeRefs = []string{filepath.Base(backups[5].Path)}
+	expired := RetentionPolicy{KeepLast: 2}.Expired(backups, now)
+	if len(expired) != 3 || expired[0].Path != backups[2].Path || expired[2].Path != backups[4].Path {
+		t.Fatalf("keep=2 expired %+v", expired)
+	}
+	if got := (RetentionPolicy{KeepWithin: 84 * time.Hour}).Expired(backups, now); len(got) != 1 || got[0].Path != backups[4].Path {
+		t.Fatalf("keep-within expired %+v", got)
+	}
+	if got := (RetentionPolicy{}).Expired(backups, now); got != nil {
+		t.Fatalf("empty policy expired %+v", got)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "web_backup.tar.gz")
+	for _, f := range []string{path, path + archive.ChecksumSuffix} {
+		if err := os.WriteFile(f, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := RemoveBackup(path); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("RemoveBackup left %v", entries)
+	}
+}
+
+func TestFindBackup(t *testing.T) {
+	dir := t.TempDir()
+	p := timestampedOutputPath(dir, "", "web", time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateLatestLink(filesystem.NewHandler(), p, "web"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{filepath.Base(p), "web_latest.tar.gz"} {
+		got, err := FindBackup(dir, name)
+		if err != nil || filepath.Base(got) != filepath.Base(p) {
+			t.Fatalf("FindBackup(%s) = %s, %v", name, got, err)
+		}
+	}
+	var nf *errors.NotFoundError
+	if _, err := FindBackup(dir, "db_latest.tar.gz"); !stdErrors.As(err, &nf) {
+		t.Fatalf("missing backup: %v", err)
+	}
+	var ve *errors.ValidationError
+	if _, err := FindBackup(dir, "../"+filepath.Base(p)); !stdErrors.As(err, &ve) {
+		t.Fatalf("path outside the directory: %v", err)
+	}
+}
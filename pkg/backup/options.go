@@ -1,17 +1,97 @@
 package backup
 
-import "github.com/brian033/dockerbackup/pkg/archive"
+import (
+	"runtime"
+	"time"
+
+	"github.com/brian033/dockerbackup/pkg/archive"
+)
 
 type BackupOptions struct {
 	OutputPath       string
 	CompressionLevel int
+	// Goroutines compressing the archive (parallel gzip when > 1)
+	CompressThreads int
+	// Store files that are compressed already (media, archives, image layers) without
+	// compressing them again
+	CompressionAuto bool
+	// Start gzip members at content-defined points, so unchanged data compresses to the same
+	// bytes in every backup and chunked uploads send only the chunks around changes
+	Rsyncable bool
+	// Name the archive <name>_<timestamp>.tar.gz (OutputPath is then the directory) and
+	// maintain a <name>_latest.tar.gz symlink
+	Timestamped bool
+	// Compose: archive local build contexts of services defined with build:
+	IncludeBuildContext bool
+	// CRIU checkpoint of the running process state; the container is stopped after the
+	// checkpoint unless CheckpointLeaveRunning is set
+	Checkpoint             string
+	CheckpointLeaveRunning bool
+	// Split the final archive into parts of at most SplitSize bytes (0 = no split)
+	SplitSize int64
+	// Reference volumes unchanged since the previous run instead of archiving them again
+	SkipUnchanged bool
+	// Wait for a concurrent backup of the same target instead of failing; LockTimeout bounds
+	// the wait (0 = no limit)
+	WaitLock    bool
+	LockTimeout time.Duration
+	// How the container image is stored: ImageFormatDocker (image.tar from docker save,
+	// default) or ImageFormatOCI (an OCI image layout directory, image-oci/)
+	ImageFormat string
+	// Generate an SBOM of the image (syft or docker sbom) next to its provenance
+	SBOM bool
+	// Host backups: daemon.json location (default DefaultDaemonConfig)
+	DaemonConfig string
+	// Record only the mount options of NFS/CIFS volumes, not their data
+	SkipRemoteVolumeData bool
+	// Mount selection: archive only IncludeVolumes (all when empty) minus ExcludeVolumes
+	// (names or glob patterns), and no bind mount data with SkipBindMounts
+	IncludeVolumes []string
+	ExcludeVolumes []string
+	SkipBindMounts bool
+	// OneFileSystem archives mount data without crossing into other filesystems mounted below
+	// it; NestedMounts is NestedMountsInclude (default) or NestedMountsSkip for the mountpoints
+	// found below a mount's host path
+	OneFileSystem bool
+	NestedMounts  string
+	// AllowSpecialMounts archives bind mounts of special paths (the Docker socket, /proc,
+	// /sys, /dev, device files), which are skipped by default
+	AllowSpecialMounts bool
+	// Recorded in metadata.json to mark significant backups (pre-upgrade, pre-migration)
+	Tags []string
+	Note string
+	// EmbedReport stores the backup report as report.json in the archive
+	EmbedReport bool
+	// Compose: when the project has no containers, back up the volumes and networks its
+	// compose files declare instead of failing
+	Offline bool
+	// set on the per-service backups of a compose project
+	composeService bool
+	// the project saves the images of all services at once; the service archive holds none
+	projectImages bool
+	// volumes whose data another container of a multi-container backup holds, by the
+	// service holding it
+	sharedVolumes map[string]string
 }
 
+const (
+	ImageFormatDocker = "docker"
+	ImageFormatOCI    = "oci"
+)
+
+// Nested mount policies: archive what is mounted below a mount's host path along with it, or
+// keep the mountpoints empty.
+const (
+	NestedMountsInclude = "include"
+	NestedMountsSkip    = "skip"
+)
+
 type RestoreOptions struct {
 	ContainerName      string
 	Start              bool
 	// Portability and mapping
 	NetworkMap         map[string]string
+	VolumeMap          map[string]string
 	ParentMap          map[string]string
 	DropHostIPs        bool
 	ReassignIPs        bool
@@ -19,6 +99,8 @@ type RestoreOptions struct {
 	// Health / readiness
 	WaitHealthy        bool
 	WaitTimeoutSeconds int
+	// Compose: per-service wait timeouts in seconds, keyed by service name
+	ServiceWaitTimeouts map[string]int
 	// Replacement and binds
 	ReplaceExisting    bool
 	BindRestoreRoot    string
@@ -30,8 +112,61 @@ type RestoreOptions struct {
 	DropCaps           bool
 	DropSeccomp        bool
 	DropAppArmor       bool
+	// Install and load captured AppArmor profiles the host lacks, asking with Confirm
+	LoadAppArmorProfiles bool
 	// IP conflicts handling
 	AutoRelaxIPs       bool
+	// Static container addresses to use instead of the saved ones, old to new
+	IPMap              map[string]string
+	// Attach to this existing network instead of recreating the saved ones
+	AttachTo           string
+	// Keep per-network static MAC addresses
+	PreserveMAC        bool
+	// Fail when HostConfig fields are not applied by the target daemon
+	StrictHostConfig   bool
+	// Log driver remapping
+	LogDriverMap       map[string]string
+	DefaultLogDriver   string
+	// GPU device requests
+	DropGPUs           bool
+	GPUMap             map[string]string
+	// Compose: hand the project to `docker compose up -d` instead of creating containers
+	ComposeUp          bool
+	ComposeDir         string
+	// Resume from the CRIU checkpoint stored in the backup (implies Start)
+	Checkpoint         bool
+	// Create the containers but never start them, for review before running
+	CreateOnly         bool
+	// Pause the containers right after they start (implies Start)
+	Paused             bool
+	// Attach the containers only to an internal network and publish no ports, until promoted
+	Isolated           bool
+	// Warn instead of failing when a restored volume does not match its backup
+	Lenient            bool
+	// Create the container from this image (pulled if missing) instead of the embedded one
+	ImageOverride      string
+	// Install missing volume driver plugins recorded in the backup
+	InstallPlugins     bool
+	// Restore only the data of these named volumes (creating them if needed), no containers
+	VolumesOnly        []string
+	// Treat a container already restored from this backup under the target name as done
+	SkipExisting       bool
+	// Leave volumes that already hold data untouched instead of extracting into them
+	NoOverwriteVolumes bool
+	// Replace the volume data of the existing containers (stopped meanwhile), keeping them
+	DataRefresh        bool
+	// What to do about containers, ports, networks and volumes that already exist, per kind;
+	// kinds not given follow ReplaceExisting, NoOverwriteVolumes or their default
+	OnConflict         map[ConflictKind]ConflictPolicy
+	// Whether replacing containers and networks and overwriting volume data is confirmed
+	Confirm            Confirmation
+	// Map the owners of restored volume and bind mount files, old ID to new
+	UIDMap             map[int]int
+	GIDMap             map[int]int
+	// the internal network the services of an isolated compose restore share
+	isolatedNetwork    string
+	// images the compose restore loaded from the project's images/, by ID
+	projectImages      map[string]bool
 }
 
 type BackupOptionsBuilder struct {
@@ -58,6 +193,113 @@ func (b *BackupOptionsBuilder) WithCompression(level int) *BackupOptionsBuilder
 	return b
 }
 
+// WithAutoCompression stores already-compressed files uncompressed (--compress auto).
+func (b *BackupOptionsBuilder) WithAutoCompression(auto bool) *BackupOptionsBuilder {
+	b.options.CompressionAuto = auto
+	return b
+}
+
+// WithRsyncable compresses for chunked uploads (--chunked).
+func (b *BackupOptionsBuilder) WithRsyncable(on bool) *BackupOptionsBuilder {
+	b.options.Rsyncable = on
+	return b
+}
+
+// WithCompressThreads sets the number of compression goroutines; 0 uses every CPU.
+func (b *BackupOptionsBuilder) WithCompressThreads(n int) *BackupOptionsBuilder {
+	if n == 0 {
+		n = runtime.NumCPU()
+	}
+	b.options.CompressThreads = n
+	return b
+}
+
+func (b *BackupOptionsBuilder) WithBuildContext(include bool) *BackupOptionsBuilder {
+	b.options.IncludeBuildContext = include
+	return b
+}
+
+func (b *BackupOptionsBuilder) WithCheckpoint(name string, leaveRunning bool) *BackupOptionsBuilder {
+	b.options.Checkpoint = name
+	b.options.CheckpointLeaveRunning = leaveRunning
+	return b
+}
+
+func (b *BackupOptionsBuilder) WithTimestamped(timestamped bool) *BackupOptionsBuilder {
+	b.options.Timestamped = timestamped
+	return b
+}
+
+func (b *BackupOptionsBuilder) WithSplitSize(size int64) *BackupOptionsBuilder {
+	b.options.SplitSize = size
+	return b
+}
+
+func (b *BackupOptionsBuilder) WithSkipUnchanged(skip bool) *BackupOptionsBuilder {
+	b.options.SkipUnchanged = skip
+	return b
+}
+
+func (b *BackupOptionsBuilder) WithLock(wait bool, timeout time.Duration) *BackupOptionsBuilder {
+	b.options.WaitLock = wait
+	b.options.LockTimeout = timeout
+	return b
+}
+
+func (b *BackupOptionsBuilder) WithImageFormat(format string) *BackupOptionsBuilder {
+	b.options.ImageFormat = format
+	return b
+}
+
+func (b *BackupOptionsBuilder) WithSBOM(sbom bool) *BackupOptionsBuilder {
+	b.options.SBOM = sbom
+	return b
+}
+
+func (b *BackupOptionsBuilder) WithDaemonConfig(path string) *BackupOptionsBuilder {
+	b.options.DaemonConfig = path
+	return b
+}
+
+func (b *BackupOptionsBuilder) WithSkipRemoteVolumeData(skip bool) *BackupOptionsBuilder {
+	b.options.SkipRemoteVolumeData = skip
+	return b
+}
+
+func (b *BackupOptionsBuilder) WithMountSelection(include, exclude []string, skipBindMounts bool) *BackupOptionsBuilder {
+	b.options.IncludeVolumes = include
+	b.options.ExcludeVolumes = exclude
+	b.options.SkipBindMounts = skipBindMounts
+	return b
+}
+
+func (b *BackupOptionsBuilder) WithMountBoundary(oneFileSystem bool, nestedMounts string) *BackupOptionsBuilder {
+	b.options.OneFileSystem = oneFileSystem
+	b.options.NestedMounts = nestedMounts
+	return b
+}
+
+func (b *BackupOptionsBuilder) WithAllowSpecialMounts(allow bool) *BackupOptionsBuilder {
+	b.options.AllowSpecialMounts = allow
+	return b
+}
+
+func (b *BackupOptionsBuilder) WithAnnotations(tags []string, note string) *BackupOptionsBuilder {
+	b.options.Tags = tags
+	b.options.Note = note
+	return b
+}
+
+func (b *BackupOptionsBuilder) WithEmbeddedReport(enabled bool) *BackupOptionsBuilder {
+	b.options.EmbedReport = enabled
+	return b
+}
+
+func (b *BackupOptionsBuilder) WithOffline(offline bool) *BackupOptionsBuilder {
+	b.options.Offline = offline
+	return b
+}
+
 func (b *BackupOptionsBuilder) Build() BackupOptions {
 	return b.options
 }
@@ -0,0 +1,150 @@
+package backup
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/brian033/dockerbackup/pkg/docker"
+)
+
+// hostInterface is a network interface of the restore host that can carry macvlan/ipvlan
+// networks, with its addresses.
+type hostInterface struct {
+	Name  string
+	Addrs []*net.IPNet
+}
+
+// hostInterfaces lists the interfaces that are up and have a hardware address, leaving out
+// loopback and the bridges and veths Docker creates.
+func hostInterfaces() []hostInterface {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var out []hostInterface
+	for _, it := range ifs {
+		if it.Flags&net.FlagUp == 0 || it.Flags&net.FlagLoopback != 0 || len(it.HardwareAddr) == 0 {
+			continue
+		}
+		if it.Name == "docker0" || strings.HasPrefix(it.Name, "br-") || strings.HasPrefix(it.Name, "veth") {
+			continue
+		}
+		hi := hostInterface{Name: it.Name}
+		addrs, _ := it.Addrs()
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok {
+				hi.Addrs = append(hi.Addrs, ipn)
+			}
+		}
+		out = append(out, hi)
+	}
+	return out
+}
+
+// pickParent chooses the parent interface for a macvlan/ipvlan network whose parent is
+// missing on this host. An interface with an address in one of the network's subnets is on
+// the same segment and wins; otherwise a host with a single interface uses it, keeping the
+// VLAN of a parent like eth0.100 (the driver creates the VLAN interface). It returns "" and
+// the candidates when the choice is not clear.
+func pickParent(parent string, subnets []string, ifaces []hostInterface) (string, []string) {
+	var onSubnet, physical []string
+	for _, it := range ifaces {
+		if !strings.Contains(it.Name, ".") {
+			physical = append(physical, it.Name)
+		}
+		for _, s := range subnets {
+			_, sn, err := net.ParseCIDR(s)
+			if err != nil {
+				continue
+			}
+			if addrInSubnet(it.Addrs, sn) {
+				onSubnet = append(onSubnet, it.Name)
+				break
+			}
+		}
+	}
+	if len(onSubnet) == 1 {
+		return onSubnet[0], onSubnet
+	}
+	if len(onSubnet) > 1 {
+		return "", onSubnet
+	}
+	if len(physical) != 1 {
+		return "", physical
+	}
+	if i := strings.LastIndex(parent, "."); i > 0 {
+		return physical[0] + parent[i:], physical
+	}
+	return physical[0], physical
+}
+
+func addrInSubnet(addrs []*net.IPNet, sn *net.IPNet) bool {
+	for _, a := range addrs {
+		if sn.Contains(a.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// parentMissing reports whether nc is a macvlan/ipvlan network whose parent interface, or for
+// a VLAN parent (eth0.100) its base interface, does not exist on this host.
+func parentMissing(nc docker.NetworkConfig, ifaces []hostInterface) bool {
+	if nc.Driver != "macvlan" && nc.Driver != "ipvlan" {
+		return false
+	}
+	parent := nc.Options["parent"]
+	if parent == "" {
+		return false
+	}
+	base := parent
+	if i := strings.LastIndex(parent, "."); i > 0 {
+		base = parent[:i]
+	}
+	for _, it := range ifaces {
+		if it.Name == parent || it.Name == base {
+			return false
+		}
+	}
+	if _, err := net.InterfaceByName(base); err == nil {
+		return false
+	}
+	return true
+}
+
+// networkSubnets returns the subnets of the network's IPAM config.
+func networkSubnets(nc docker.NetworkConfig) []string {
+	var subnets []string
+	for _, c := range nc.IPAM.Config {
+		if c.Subnet != "" {
+			subnets = append(subnets, c.Subnet)
+		}
+	}
+	return subnets
+}
+
+// resolveParent replaces the parent interface of a macvlan/ipvlan network to be created when
+// it does not exist on this host with the one pickParent selects. Without a clear choice the
+// candidates are given in a warning and it reports false, leaving the parent as it is.
+func (e *DefaultBackupEngine) resolveParent(ctx context.Context, nc *docker.NetworkConfig) bool {
+	if nc.Driver != "macvlan" && nc.Driver != "ipvlan" {
+		return true
+	}
+	if n, err := e.dockerClient.InspectNetwork(ctx, nc.Name); err == nil && n != nil {
+		return true
+	}
+	ifaces := hostInterfaces()
+	if !parentMissing(*nc, ifaces) {
+		return true
+	}
+	parent := nc.Options["parent"]
+	picked, candidates := pickParent(parent, networkSubnets(*nc), ifaces)
+	if picked == "" {
+		e.warn(WarnNetworkParent, nc.Name, "Parent interface %s of network %s not found on this host; candidates: %s (choose one with --parent-map %s:<interface>)", parent, nc.Name, strings.Join(candidates, ", "), nc.Name)
+		return false
+	}
+	e.warn(WarnNetworkParent, nc.Name, "Parent interface %s of network %s not found on this host; using %s", parent, nc.Name, picked)
+	nc.Options["parent"] = picked
+	return true
+}
@@ -0,0 +1,214 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+// ComposeRestorePlan describes what restoring a compose backup would do on this host.
+type ComposeRestorePlan struct {
+	ProjectName string
+	Services    []ServicePlan // in start order
+	Networks    []ResourcePlan
+	Volumes     []ResourcePlan
+	Conflicts   []string
+}
+
+// ServicePlan is the planned restore of one service.
+type ServicePlan struct {
+	Name      string
+	Container string
+	// Replicas is the number of containers of a scaled service, 1 otherwise
+	Replicas int
+	Image    string
+	// image.tar or image-oci (loaded), images/image.tar or images/image-oci (loaded once for
+	// the project), or filesystem.tar (imported); empty when the service backup is missing
+	ImageSource string
+	DependsOn   map[string]string
+	Networks    []string
+	Volumes     []string
+	Ports       []string
+}
+
+// ResourcePlan is a network or volume the restore ensures; existing ones are reused.
+type ResourcePlan struct {
+	Name   string
+	Driver string
+	Exists bool
+}
+
+// PlanComposeRestore inspects a compose backup and the target host without changing anything.
+// projectName renames the project like restore-compose --project-name.
+func PlanComposeRestore(ctx context.Context, dc docker.DockerClient, backupPath, projectName string) (*ComposeRestorePlan, error) {
+	fsys := filesystem.NewHandler()
+	tmpDir, err := fsys.MkdirTemp(WorkDir(), "dockerbackup_dryrun_*")
+	if err != nil {
+		return nil, &errors.OperationError{Op: "create temp dir", Err: err}
+	}
+	defer func() { _ = fsys.RemoveAll(tmpDir) }()
+	th := archive.NewTarArchiveHandler()
+	if err := th.ExtractArchive(ctx, backupPath, tmpDir); err != nil {
+		return nil, &errors.OperationError{Op: "extract backup", Err: err}
+	}
+
+	original := readComposeProjectName(fsys, tmpDir)
+	renamer := newProjectRenamer(original, projectName)
+	plan := &ComposeRestorePlan{ProjectName: original}
+	if projectName != "" {
+		plan.ProjectName = projectName
+	}
+
+	if b, err := fsys.ReadFile(filepath.Join(tmpDir, "networks", "network_configs.json")); err == nil {
+		var netCfgs []docker.NetworkConfig
+		_ = json.Unmarshal(b, &netCfgs)
+		for _, nc := range netCfgs {
+			name := renamer.name(nc.Name)
+			n, err := dc.InspectNetwork(ctx, name)
+			exists := err == nil && n != nil
+			plan.Networks = append(plan.Networks, ResourcePlan{Name: name, Driver: nc.Driver, Exists: exists})
+			if exists && n.Driver != "" && nc.Driver != "" && n.Driver != nc.Driver {
+				plan.Conflicts = append(plan.Conflicts, fmt.Sprintf("network %s exists with driver %s, backup uses %s", name, n.Driver, nc.Driver))
+			}
+			if ifaces := hostInterfaces(); !exists && parentMissing(nc, ifaces) {
+				parent := nc.Options["parent"]
+				picked, candidates := pickParent(parent, networkSubnets(nc), ifaces)
+				if picked != "" {
+					plan.Conflicts = append(plan.Conflicts, fmt.Sprintf("network %s: parent interface %s not found, %s will be used", name, parent, picked))
+				} else {
+					plan.Conflicts = append(plan.Conflicts, fmt.Sprintf("network %s: parent interface %s not found (candidates: %s)", name, parent, strings.Join(candidates, ", ")))
+				}
+			}
+		}
+	}
+	if b, err := fsys.ReadFile(filepath.Join(tmpDir, "volumes", "volume_configs.json")); err == nil {
+		var volCfgs []docker.VolumeConfig
+		_ = json.Unmarshal(b, &volCfgs)
+		for _, vc := range volCfgs {
+			name := renamer.name(vc.Name)
+			v, err := dc.InspectVolume(ctx, name)
+			exists := err == nil && v != nil
+			plan.Volumes = append(plan.Volumes, ResourcePlan{Name: name, Driver: vc.Driver, Exists: exists})
+			if exists {
+				plan.Conflicts = append(plan.Conflicts, fmt.Sprintf("volume %s exists; its data will be overwritten", name))
+			}
+		}
+	}
+
+	projectImages := readProjectImages(fsys, tmpDir)
+	projectSource := ""
+	for _, name := range []string{"image.tar", ociImageDirName} {
+		if _, err := fsys.Stat(filepath.Join(tmpDir, projectImagesDir, name)); err == nil {
+			projectSource = projectImagesDir + "/" + name
+		}
+	}
+	order, deps := composeServiceOrder(fsys, tmpDir)
+	ports := map[string]string{}
+	for _, svc := range order {
+		sp := ServicePlan{Name: svc, DependsOn: deps[svc]}
+		tarPath := serviceArchive(fsys, tmpDir, svc)
+		sp.Replicas = 1 + len(replicaArchives(fsys, tmpDir, svc))
+		if tarPath == "" {
+			plan.Conflicts = append(plan.Conflicts, fmt.Sprintf("service %s has no container backup and will be skipped", svc))
+			plan.Services = append(plan.Services, sp)
+			continue
+		}
+		b, err := th.ReadEntry(ctx, tarPath, "container.json")
+		if err != nil {
+			return nil, &errors.OperationError{Op: "read container.json of " + svc, Err: err}
+		}
+		cj, err := decodeContainerJSON(b)
+		if err != nil {
+			return nil, &errors.OperationError{Op: "unmarshal container.json of " + svc, Err: err}
+		}
+		sp.ImageSource = "filesystem.tar"
+		_ = th.WalkArchive(ctx, tarPath, func(en archive.ArchiveEntry) error {
+			switch {
+			case en.Path == "image.tar":
+				sp.ImageSource = "image.tar"
+			case strings.HasPrefix(en.Path, ociImageDirName+"/"):
+				sp.ImageSource = ociImageDirName
+			default:
+				return nil
+			}
+			return archive.ErrStopWalk
+		})
+		if sp.ImageSource == "filesystem.tar" && projectSource != "" && cj.ContainerJSONBase != nil && slices.Contains(projectImages, cj.Image) {
+			sp.ImageSource = projectSource
+		}
+		if cj.Config != nil {
+			sp.Image = cj.Config.Image
+		}
+		if cj.ContainerJSONBase != nil {
+			sp.Container = renamer.name(strings.TrimPrefix(cj.Name, "/"))
+			if b, err := dc.InspectContainer(ctx, sp.Container); err == nil && len(b) > 0 {
+				plan.Conflicts = append(plan.Conflicts, fmt.Sprintf("container %s already exists (restore-compose --replace removes it)", sp.Container))
+			}
+		}
+		if cj.NetworkSettings != nil {
+			for n := range cj.NetworkSettings.Networks {
+				sp.Networks = append(sp.Networks, renamer.name(n))
+			}
+			sort.Strings(sp.Networks)
+		}
+		for _, m := range cj.Mounts {
+			switch {
+			case m.Type == "volume" && m.Name != "":
+				sp.Volumes = append(sp.Volumes, renamer.name(m.Name)+":"+m.Destination)
+			case m.Type == "bind":
+				sp.Volumes = append(sp.Volumes, m.Source+":"+m.Destination)
+			}
+		}
+		if cj.HostConfig != nil {
+			for port, bindings := range cj.HostConfig.PortBindings {
+				for _, pb := range bindings {
+					if pb.HostPort == "" {
+						continue
+					}
+					hostAddr := net.JoinHostPort(pb.HostIP, pb.HostPort)
+					key := port.Proto() + "/" + hostAddr
+					sp.Ports = append(sp.Ports, hostAddr+"->"+string(port))
+					if other, ok := ports[key]; ok {
+						plan.Conflicts = append(plan.Conflicts, fmt.Sprintf("services %s and %s both bind %s %s", other, svc, port.Proto(), hostAddr))
+						continue
+					}
+					ports[key] = svc
+					if !portFree(port.Proto(), hostAddr) {
+						plan.Conflicts = append(plan.Conflicts, fmt.Sprintf("%s port %s used by service %s is already in use on this host", port.Proto(), hostAddr, svc))
+					}
+				}
+			}
+			sort.Strings(sp.Ports)
+		}
+		plan.Services = append(plan.Services, sp)
+	}
+	return plan, nil
+}
+
+// portFree reports whether addr can be bound for proto on this host.
+func portFree(proto, addr string) bool {
+	if proto == "udp" {
+		c, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return false
+		}
+		_ = c.Close()
+		return true
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return false
+	}
+	_ = l.Close()
+	return true
+}
@@ -0,0 +1,132 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+func TestPlanComposeRestore(t *testing.T) {
+	ctx := context.Background()
+	arch := archive.NewTarArchiveHandler()
+	work := t.TempDir()
+	write := func(rel, content string) {
+		p := filepath.Join(work, rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("metadata.json", `{"projectName":"shop"}`)
+	write("compose-files/docker-compose.yml", "services:\n  web:\n    image: web:1\n    depends_on:\n      db:\n        condition: service_healthy\n  db:\n    image: postgres:16\n")
+	vc, _ := json.Marshal([]docker.VolumeConfig{{Name: "shop_dbdata", Driver: "local"}})
+	write("volumes/volume_configs.json", string(vc))
+
+	ports := nat.PortMap{"80/tcp": {{HostPort: "18080"}}}
+	for svc, image := range map[string]string{"web": "web:1", "db": "postgres:16"} {
+		cj := types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{ID: svc, Name: "/shop-" + svc + "-1", HostConfig: &container.HostConfig{PortBindings: ports}},
+			Config:            &container.Config{Image: image},
+		}
+		if svc == "db" {
+			cj.Mounts = []types.MountPoint{{Type: "volume", Name: "shop_dbdata", Destination: "/var/lib/postgresql/data"}}
+		}
+		b, _ := json.Marshal(cj)
+		svcWork := t.TempDir()
+		_ = os.WriteFile(filepath.Join(svcWork, "container.json"), b, 0o644)
+		svcTar := filepath.Join(work, "containers", svc, svc+".tar.gz")
+		if err := arch.CreateArchive(ctx, []archive.ArchiveSource{{Path: svcWork, DestPath: "."}}, svcTar); err != nil {
+			t.Fatalf("create service archive: %v", err)
+		}
+	}
+	backupFile := filepath.Join(t.TempDir(), "shop_compose.tar.gz")
+	if err := arch.CreateArchive(ctx, []archive.ArchiveSource{{Path: work, DestPath: "."}}, backupFile); err != nil {
+		t.Fatalf("create archive: %v", err)
+	}
+
+	plan, err := PlanComposeRestore(ctx, &fakeDockerClientRestore{}, backupFile, "shop2")
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if plan.ProjectName != "shop2" || len(plan.Services) != 2 || plan.Services[0].Name != "db" || plan.Services[1].Name != "web" {
+		t.Fatalf("unexpected services: %+v", plan.Services)
+	}
+	db, web := plan.Services[0], plan.Services[1]
+	if db.Container != "shop2-db-1" || db.ImageSource != "filesystem.tar" || strings.Join(db.Volumes, ",") != "shop2_dbdata:/var/lib/postgresql/data" {
+		t.Fatalf("unexpected db plan: %+v", db)
+	}
+	if web.DependsOn["db"] != "service_healthy" {
+		t.Fatalf("web should depend on db: %+v", web)
+	}
+	if len(plan.Volumes) != 1 || plan.Volumes[0].Name != "shop2_dbdata" || plan.Volumes[0].Exists {
+		t.Fatalf("unexpected volumes: %+v", plan.Volumes)
+	}
+	found := false
+	for _, c := range plan.Conflicts {
+		if strings.Contains(c, "services db and web both bind tcp :18080") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("duplicate port binding not reported: %v", plan.Conflicts)
+	}
+}
+
+func TestPlanBackup(t *testing.T) {
+	ctx := context.Background()
+	data, cache := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(data, "rows.txt"), make([]byte, 3000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mounts := []map[string]any{
+		{"Name": "data", "Source": data, "Destination": "/data", "Type": "volume", "RW": true},
+		{"Name": "cache", "Source": cache, "Destination": "/cache", "Type": "volume", "RW": true},
+		{"Destination": "/run", "Type": "tmpfs"},
+	}
+	container := func(name string, mounts []map[string]any) []byte {
+		b, _ := json.Marshal([]map[string]any{{"Id": name + "-id", "Name": "/" + name, "Image": "sha256:abc", "Config": map[string]any{"Image": "shop:1"},
+			"NetworkSettings": map[string]any{"Networks": map[string]any{"front": map[string]any{}, "back": map[string]any{}}}, "Mounts": mounts}})
+		return b
+	}
+	dc := &fakeDockerClient{
+		containers: map[string][]byte{"web": container("web", mounts), "worker": container("worker", mounts[:1])},
+		image:      &docker.ImageInfo{ID: "sha256:abc", Size: 5000},
+	}
+	opts := NewBackupOptionsBuilder().WithMountSelection(nil, []string{"cache"}, false).Build()
+	plan, err := PlanBackup(ctx, dc, BackupRequest{TargetType: TargetContainer, ContainerIDs: []string{"web", "worker"}, Options: opts})
+	if err != nil {
+		t.Fatalf("PlanBackup: %v", err)
+	}
+	if len(plan.Containers) != 2 || len(plan.Images) != 1 || plan.Images[0].Ref != "shop:1" || plan.Images[0].Size != 5000 {
+		t.Fatalf("plan = %+v", plan)
+	}
+	web := plan.Containers[0]
+	if web.Name != "web" || strings.Join(web.Networks, ",") != "back,front" || len(web.Mounts) != 3 {
+		t.Fatalf("web = %+v", web)
+	}
+	skipped := map[string]string{}
+	for _, m := range web.Mounts {
+		skipped[m.Destination] = m.Skipped
+	}
+	if skipped["/data"] != "" || skipped["/cache"] != "excluded" || skipped["/run"] != "unsupported" || web.Mounts[0].Size != 3000 {
+		t.Fatalf("web mounts = %+v", web.Mounts)
+	}
+	if m := plan.Containers[1].Mounts[0]; m.Skipped != "shared" {
+		t.Fatalf("worker's data volume = %+v, want shared with web", m)
+	}
+	// the image once, the data volume once
+	if plan.EstimatedSize != 8000 {
+		t.Fatalf("estimated size = %d", plan.EstimatedSize)
+	}
+}
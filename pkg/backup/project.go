@@ -0,0 +1,234 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/compose"
+	"github.com/brian033/dockerbackup/pkg/docker"
+)
+
+// Compose and multi-container backups share a layout: each container is backed up under
+// containers/<service>/container.tar.gz and the networks and volumes they use are recorded
+// once under networks/ and volumes/.
+
+// serviceArchiveName is the archive of a service container under containers/<service>/; the
+// other replicas of a scaled service are archived next to it as replica-<n>.tar.gz.
+const serviceArchiveName = "container.tar.gz"
+
+func replicaArchiveName(n int) string {
+	return fmt.Sprintf("replica-%d.tar.gz", n)
+}
+
+// backupService backs up one container of a project into containersDir/<service>/name. The
+// data of the volumes in shared is left to the container holding it.
+func (e *DefaultBackupEngine) backupService(ctx context.Context, containersDir, name string, r docker.ProjectContainerRef, base BackupOptions, shared map[string]string, rep *reporter) error {
+	svcDir := filepath.Join(containersDir, safeName(r.Service))
+	_ = e.filesystem.EnsureDir(svcDir, 0o755)
+	outTar := filepath.Join(svcDir, name)
+	builder := NewBackupOptionsBuilder().WithOutput(outTar).WithCompression(0).WithCompressThreads(base.CompressThreads).WithAutoCompression(base.CompressionAuto).WithRsyncable(base.Rsyncable).
+		WithLock(base.WaitLock, base.LockTimeout).
+		WithImageFormat(base.ImageFormat).
+		WithSBOM(base.SBOM).
+		WithSkipRemoteVolumeData(base.SkipRemoteVolumeData).
+		WithMountSelection(base.IncludeVolumes, base.ExcludeVolumes, base.SkipBindMounts).
+		WithMountBoundary(base.OneFileSystem, base.NestedMounts).
+		WithAllowSpecialMounts(base.AllowSpecialMounts)
+	opts := builder.Build()
+	opts.composeService = true
+	opts.projectImages = true
+	opts.sharedVolumes = shared
+	res, err := e.Backup(ctx, BackupRequest{TargetType: TargetContainer, ContainerID: r.ID, Options: opts})
+	if err != nil {
+		return err
+	}
+	if name == serviceArchiveName {
+		rep.stage("service " + r.Service)
+	} else {
+		rep.stage("service " + r.Service + " " + strings.TrimSuffix(name, ".tar.gz"))
+	}
+	for _, w := range res.Warnings {
+		w.Service = r.Service
+		e.warnings.add(w)
+	}
+	return nil
+}
+
+// serviceReplicas orders the containers of a project by service and replica number and
+// returns, for each container ID, the archive it is written to and the volumes whose data it
+// leaves to the first replica of its service: the replicas of a scaled service (docker
+// compose up --scale web=3) mount the same named volumes, archived once.
+func (e *DefaultBackupEngine) serviceReplicas(ctx context.Context, refs []docker.ProjectContainerRef) (names map[string]string, shared map[string]map[string]string) {
+	sort.SliceStable(refs, func(i, j int) bool {
+		if refs[i].Service != refs[j].Service {
+			return refs[i].Service < refs[j].Service
+		}
+		// containers without a number come after the numbered ones
+		ni, nj := refs[i].Number, refs[j].Number
+		return ni > 0 && (nj <= 0 || ni < nj)
+	})
+	names, shared = map[string]string{}, map[string]map[string]string{}
+	first := map[string]docker.ProjectContainerRef{}
+	holders := map[string]map[string]bool{}
+	taken := map[string]map[int]bool{}
+	for _, r := range refs {
+		p, ok := first[r.Service]
+		if !ok {
+			first[r.Service] = r
+			names[r.ID] = serviceArchiveName
+			holders[r.Service] = e.volumeNames(ctx, r.ID)
+			taken[r.Service] = map[int]bool{max(r.Number, 1): true}
+			continue
+		}
+		// replicas without a number (or a duplicate one) take the next free one
+		n := r.Number
+		for n < 1 || taken[r.Service][n] {
+			n++
+		}
+		taken[r.Service][n] = true
+		names[r.ID] = replicaArchiveName(n)
+		for v := range e.volumeNames(ctx, r.ID) {
+			if holders[r.Service][v] {
+				if shared[r.ID] == nil {
+					shared[r.ID] = map[string]string{}
+				}
+				shared[r.ID][v] = strings.TrimPrefix(p.ContainerName, "/")
+			}
+		}
+	}
+	return names, shared
+}
+
+// volumeNames returns the named volumes a container mounts.
+func (e *DefaultBackupEngine) volumeNames(ctx context.Context, id string) map[string]bool {
+	out := map[string]bool{}
+	b, err := e.dockerClient.InspectContainer(ctx, id)
+	if err != nil {
+		return out
+	}
+	info, err := docker.ParseContainerInfo(b)
+	if err != nil {
+		return out
+	}
+	for _, m := range info.Mounts {
+		if m.Type == "volume" && m.Name != "" {
+			out[m.Name] = true
+		}
+	}
+	return out
+}
+
+// projectResources is what the containers of a project use besides their own archives.
+type projectResources struct {
+	networks []docker.NetworkConfig
+	volumes  []docker.VolumeConfig
+	// every named volume mounted, including those that could not be inspected
+	volumeNames []string
+	// depends_on per service, from the compose labels
+	dependsOn map[string]map[string]string
+	// the images the containers were created from, by ID, each once
+	images []string
+}
+
+// projectResources inspects the networks and named volumes the containers use, each once, and
+// records depends_on from the runtime labels so restore ordering works without the compose
+// files.
+func (e *DefaultBackupEngine) projectResources(ctx context.Context, refs []docker.ProjectContainerRef) projectResources {
+	out := projectResources{dependsOn: map[string]map[string]string{}}
+	seenNets := map[string]bool{}
+	seenVols := map[string]bool{}
+	seenImages := map[string]bool{}
+	for _, r := range refs {
+		b, err := e.dockerClient.InspectContainer(ctx, r.ID)
+		if err != nil {
+			continue
+		}
+		cj, err := decodeContainerJSON(b)
+		if err != nil {
+			continue
+		}
+		if cj.ContainerJSONBase != nil && cj.Image != "" && !seenImages[cj.Image] {
+			seenImages[cj.Image] = true
+			out.images = append(out.images, cj.Image)
+		}
+		if cj.Config != nil {
+			if label := cj.Config.Labels[compose.DependsOnLabel]; label != "" {
+				out.dependsOn[r.Service] = compose.ParseDependsOnLabel(label)
+			}
+		}
+		if cj.NetworkSettings != nil {
+			for name := range cj.NetworkSettings.Networks {
+				if seenNets[name] {
+					continue
+				}
+				seenNets[name] = true
+				if n, err := e.dockerClient.InspectNetwork(ctx, name); err == nil && n != nil {
+					out.networks = append(out.networks, *n)
+				}
+			}
+		}
+		for _, m := range cj.Mounts {
+			if m.Type != "volume" || m.Name == "" || seenVols[m.Name] {
+				continue
+			}
+			seenVols[m.Name] = true
+			out.volumeNames = append(out.volumeNames, m.Name)
+			if v, err := e.dockerClient.InspectVolume(ctx, m.Name); err == nil && v != nil {
+				out.volumes = append(out.volumes, *v)
+			}
+		}
+	}
+	return out
+}
+
+// writeProjectResources records the network and volume configs (and the plugins the volumes
+// need) under workDir/networks and workDir/volumes.
+func (e *DefaultBackupEngine) writeProjectResources(ctx context.Context, workDir string, netCfgs []docker.NetworkConfig, volCfgs []docker.VolumeConfig) {
+	if len(netCfgs) > 0 {
+		if b, err := json.MarshalIndent(netCfgs, "", "  "); err == nil {
+			_ = e.filesystem.WriteFile(filepath.Join(workDir, "networks", "network_configs.json"), b, 0o644)
+		}
+	}
+	volumesDir := filepath.Join(workDir, "volumes")
+	e.captureVolumePlugins(ctx, volCfgs, volumesDir)
+	if len(volCfgs) > 0 {
+		if b, err := json.MarshalIndent(volCfgs, "", "  "); err == nil {
+			_ = e.filesystem.WriteFile(filepath.Join(volumesDir, "volume_configs.json"), b, 0o644)
+		}
+	}
+}
+
+// packageProject writes the project archive from sources (plus the report when embedded) and
+// finalizes it; name is the archive name without timestamp used for the _latest link.
+func (e *DefaultBackupEngine) packageProject(ctx context.Context, workDir string, sources []archive.ArchiveSource, outputPath, name string, opts BackupOptions, rep *reporter) (*BackupResult, error) {
+	if opts.EmbedReport {
+		if err := rep.write(e.filesystem, workDir, e.warnings.list()); err != nil {
+			return nil, &errors.OperationError{Op: "write " + reportFileName, Err: err}
+		}
+		sources = append(sources, archive.ArchiveSource{Path: filepath.Join(workDir, reportFileName), DestPath: reportFileName})
+	}
+	if th, ok := e.archiveHandler.(*archive.TarArchiveHandler); ok {
+		th.SetCompressionLevel(opts.CompressionLevel)
+		th.SetCompressionThreads(opts.CompressThreads)
+		th.SetAutoCompression(opts.CompressionAuto)
+	}
+	stats, err := e.createArchive(ctx, sources, outputPath)
+	if err != nil {
+		return nil, &errors.OperationError{Op: "create project archive", Err: err}
+	}
+	rep.stage("package")
+	rep.packaged(e.filesystem, outputPath, stats)
+	res, err := e.finalizeArchive(outputPath, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	rep.stage("finalize")
+	res.Report = rep.done()
+	return res, nil
+}
@@ -0,0 +1,90 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+// projectImagesDir holds the images of every service of a compose or multi-container backup,
+// saved with a single docker save so layers the services share are stored once. The service
+// archives then hold no image of their own.
+const projectImagesDir = "images"
+
+// saveProjectImages saves images into images/ under workDir, as image.tar or, with
+// --image-format oci, an OCI layout. It reports whether they were saved; when they were not
+// the restore pulls the images by digest or imports the services' filesystems.
+func (e *DefaultBackupEngine) saveProjectImages(ctx context.Context, workDir string, images []string, opts BackupOptions) bool {
+	if len(images) == 0 {
+		return false
+	}
+	dir := filepath.Join(workDir, projectImagesDir)
+	imageTar := filepath.Join(dir, "image.tar")
+	e.log.Infof("Saving %d image(s) of the project", len(images))
+	if err := e.dockerClient.ImageSaveAll(ctx, images, imageTar); err != nil {
+		e.warn(WarnImageNotSaved, strings.Join(images, ","), "Could not save the images of the project; restore needs them from a registry: %v", err)
+		_ = e.filesystem.RemoveAll(dir)
+		return false
+	}
+	if _, err := e.filesystem.Stat(imageTar); err != nil {
+		_ = e.filesystem.RemoveAll(dir)
+		return false
+	}
+	if opts.ImageFormat == ImageFormatOCI {
+		ociDir := filepath.Join(dir, ociImageDirName)
+		if err := archive.ImageTarToOCILayout(ctx, imageTar, ociDir); err != nil {
+			e.warn(WarnImageFormat, strings.Join(images, ","), "Could not convert the images to an OCI layout, keeping docker save format: %v", err)
+			_ = e.filesystem.RemoveAll(ociDir)
+		} else {
+			_ = e.filesystem.RemoveAll(imageTar)
+		}
+	}
+	return true
+}
+
+// readProjectImages returns the images recorded in the project metadata.json under dir: those
+// saved in images/, or nil for backups that saved each service's image in its own archive.
+func readProjectImages(fsys filesystem.Handler, dir string) []string {
+	b, err := fsys.ReadFile(filepath.Join(dir, "metadata.json"))
+	if err != nil {
+		return nil
+	}
+	var meta struct {
+		Images []string `json:"images"`
+	}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return nil
+	}
+	return meta.Images
+}
+
+// loadProjectImages loads the images saved in images/ of an extracted project backup, once for
+// all services, unless the host has them all already, and returns the ones now present.
+func (e *DefaultBackupEngine) loadProjectImages(ctx context.Context, dir string) map[string]bool {
+	images := readProjectImages(e.filesystem, dir)
+	if len(images) == 0 {
+		return nil
+	}
+	out := make(map[string]bool, len(images))
+	for _, img := range images {
+		out[img] = true
+	}
+	if !slices.ContainsFunc(images, func(img string) bool { return !e.imagePresent(ctx, img) }) {
+		e.log.Infof("The images of the project are already present; not loading them again")
+		return out
+	}
+	loaded, err := e.loadSavedImage(ctx, filepath.Join(dir, projectImagesDir))
+	if err != nil {
+		e.warn(WarnImageLoad, "", "Could not load the images of the project; services fall back to pulling or importing theirs: %v", err)
+		return nil
+	}
+	if !loaded {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,226 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+	"github.com/docker/docker/api/types"
+)
+
+// provenanceDirName holds provenance.json and, with --sbom, the image SBOM.
+const provenanceDirName = "provenance"
+
+const sbomFileName = "sbom.spdx.json"
+
+// Provenance records where the image of a backed-up container came from, so a restored
+// workload can be traced back to its source and audited later.
+type Provenance struct {
+	// Image is the reference the container was created from; ImageID the exact image
+	Image       string   `json:"image,omitempty"`
+	ImageID     string   `json:"imageId,omitempty"`
+	RepoDigests []string `json:"repoDigests,omitempty"`
+	RepoTags    []string `json:"repoTags,omitempty"`
+	Created     string   `json:"created,omitempty"`
+	Platform    string   `json:"platform,omitempty"`
+	// org.opencontainers.image.* labels (source, revision, version, ...)
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// SBOM is the file name under provenance/ and SBOMTool the generator that produced it
+	SBOM       string    `json:"sbom,omitempty"`
+	SBOMTool   string    `json:"sbomTool,omitempty"`
+	CapturedAt time.Time `json:"capturedAt"`
+}
+
+// sbomTool generates an SPDX JSON SBOM for an image known to the local daemon.
+type sbomTool struct {
+	name string
+	args func(ref string) []string
+}
+
+// sbomTools are tried in order; the first one that is installed and succeeds wins.
+var sbomTools = []sbomTool{
+	{name: "syft", args: func(ref string) []string { return []string{"syft", "docker:" + ref, "-o", "spdx-json", "-q"} }},
+	{name: "docker sbom", args: func(ref string) []string { return []string{"docker", "sbom", ref, "--format", "spdx-json"} }},
+}
+
+// runSBOMTool runs an SBOM generator and returns its stdout.
+var runSBOMTool = func(ctx context.Context, args []string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// captureProvenance writes provenance/provenance.json (and the SBOM when withSBOM is set)
+// under workDir for the container's image. It reports whether anything was written; a
+// missing image or SBOM tool is logged, not fatal.
+func (e *DefaultBackupEngine) captureProvenance(ctx context.Context, cj types.ContainerJSON, workDir string, withSBOM bool) bool {
+	if cj.ContainerJSONBase == nil || cj.Image == "" {
+		return false
+	}
+	prov := Provenance{ImageID: cj.Image, CapturedAt: time.Now().UTC()}
+	if cj.Config != nil {
+		prov.Image = cj.Config.Image
+	}
+	img, err := e.dockerClient.InspectImage(ctx, cj.Image)
+	if err != nil {
+		e.warn(WarnProvenance, cj.Image, "Could not inspect image %s for provenance: %v", cj.Image, err)
+	} else {
+		prov.ImageID = img.ID
+		prov.RepoDigests = img.RepoDigests
+		prov.RepoTags = img.RepoTags
+		prov.Created = img.Created
+		if img.Os != "" {
+			prov.Platform = img.Os + "/" + img.Architecture
+			if img.Variant != "" {
+				prov.Platform += "/" + img.Variant
+			}
+		}
+		for k, v := range img.Labels {
+			if strings.HasPrefix(k, "org.opencontainers.image.") {
+				if prov.Annotations == nil {
+					prov.Annotations = map[string]string{}
+				}
+				prov.Annotations[k] = v
+			}
+		}
+	}
+
+	dir := filepath.Join(workDir, provenanceDirName)
+	if err := e.filesystem.EnsureDir(dir, 0o755); err != nil {
+		e.log.Infof("Could not record image provenance: %v", err)
+		return false
+	}
+	if withSBOM {
+		e.log.Infof("Generating SBOM for %s", cj.Image)
+		var failures []string
+		for _, tool := range sbomTools {
+			out, err := runSBOMTool(ctx, tool.args(cj.Image))
+			if err != nil || len(out) == 0 {
+				failures = append(failures, fmt.Sprintf("%s: %v", tool.name, err))
+				continue
+			}
+			if err := e.filesystem.WriteFile(filepath.Join(dir, sbomFileName), out, 0o644); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", tool.name, err))
+				continue
+			}
+			prov.SBOM = sbomFileName
+			prov.SBOMTool = tool.name
+			break
+		}
+		if prov.SBOM == "" {
+			e.warn(WarnProvenance, cj.Image, "Could not generate an SBOM (install syft or the docker sbom plugin): %s", strings.Join(failures, "; "))
+		}
+	}
+	b, _ := json.MarshalIndent(prov, "", "  ")
+	if err := e.filesystem.WriteFile(filepath.Join(dir, "provenance.json"), b, 0o644); err != nil {
+		e.log.Infof("Could not record image provenance: %v", err)
+		return false
+	}
+	return true
+}
+
+// ReadProvenance returns the image provenance recorded in a backup, keyed by compose service
+// ("" for a container backup). Services backed up without provenance are omitted.
+func ReadProvenance(ctx context.Context, backupPath string) (map[string]*Provenance, error) {
+	out := map[string]*Provenance{}
+	err := forEachContainerArchive(ctx, filesystem.NewHandler(), archive.NewTarArchiveHandler(), backupPath, func(service, archivePath string) error {
+		b, err := archive.NewTarArchiveHandler().ReadEntry(ctx, archivePath, provenanceDirName+"/provenance.json")
+		if err != nil {
+			return nil
+		}
+		var p Provenance
+		if err := json.Unmarshal(b, &p); err != nil {
+			return fmt.Errorf("parse provenance of %s: %w", orContainer(service), err)
+		}
+		out[service] = &p
+		return nil
+	})
+	return out, err
+}
+
+// ReadSBOM returns the SBOM stored for a container backup, or for service in a compose backup.
+func ReadSBOM(ctx context.Context, backupPath, service string) ([]byte, error) {
+	var sbom []byte
+	found := false
+	err := forEachContainerArchive(ctx, filesystem.NewHandler(), archive.NewTarArchiveHandler(), backupPath, func(svc, archivePath string) error {
+		if found || (service != "" && svc != service) {
+			return nil
+		}
+		b, err := archive.NewTarArchiveHandler().ReadEntry(ctx, archivePath, provenanceDirName+"/"+sbomFileName)
+		if err != nil {
+			return nil
+		}
+		sbom, found = b, true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		if service != "" {
+			return nil, fmt.Errorf("no SBOM for service %s in %s (back up with --sbom)", service, backupPath)
+		}
+		return nil, fmt.Errorf("no SBOM in %s (back up with --sbom)", backupPath)
+	}
+	return sbom, nil
+}
+
+// forEachContainerArchive calls fn with the backup itself for container backups, and with
+// each service's nested container archive (unpacked to a temporary file) for compose backups.
+func forEachContainerArchive(ctx context.Context, fsys filesystem.Handler, th archiveReader, backupPath string, fn func(service, archivePath string) error) error {
+	kind, err := detectTargetType(ctx, th, backupPath)
+	if err != nil {
+		return err
+	}
+	if kind == TargetContainer {
+		return fn("", backupPath)
+	}
+	var services []string
+	err = th.WalkArchive(ctx, backupPath, func(en archive.ArchiveEntry) error {
+		parts := strings.Split(en.Path, "/")
+		if len(parts) == 3 && parts[0] == "containers" && parts[2] == "container.tar.gz" {
+			services = append(services, parts[1])
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(services)
+	tmpDir, err := fsys.MkdirTemp(WorkDir(), "dockerbackup_provenance_*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = fsys.RemoveAll(tmpDir) }()
+	for _, svc := range services {
+		nested := filepath.Join(tmpDir, safeName(svc)+".tar.gz")
+		if err := copyEntryToFile(ctx, fsys, th, backupPath, "containers/"+svc+"/container.tar.gz", nested); err != nil {
+			return fmt.Errorf("read service %s: %w", svc, err)
+		}
+		if err := fn(svc, nested); err != nil {
+			return err
+		}
+		_ = fsys.RemoveAll(nested)
+	}
+	return nil
+}
+
+func orContainer(service string) string {
+	if service == "" {
+		return "container"
+	}
+	return "service " + service
+}
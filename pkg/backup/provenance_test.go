@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+func TestBackup_RecordsProvenanceAndSBOM(t *testing.T) {
+	ctx := context.Background()
+	orig := runSBOMTool
+	defer func() { runSBOMTool = orig }()
+	var ran []string
+	runSBOMTool = func(ctx context.Context, args []string) ([]byte, error) {
+		ran = append(ran, args[0])
+		if args[0] == "syft" {
+			return nil, fmt.Errorf("executable file not found in $PATH")
+		}
+		return []byte(`{"spdxVersion":"SPDX-2.3"}`), nil
+	}
+
+	inspect := []map[string]any{{"Id": "123", "Name": "/unit_test", "Image": "sha256:abc",
+		"Config": map[string]any{"Image": "app:1.0"}, "Mounts": []map[string]any{}}}
+	b, _ := json.Marshal(inspect)
+	dc := &fakeDockerClient{inspectJSON: b, image: &docker.ImageInfo{
+		ID:          "sha256:abc",
+		RepoDigests: []string{"registry.example/app@sha256:def"},
+		Os:          "linux", Architecture: "arm64", Variant: "v8",
+		Labels: map[string]string{"org.opencontainers.image.source": "https://git.example/app", "maintainer": "x"},
+	}}
+	engine := NewDefaultBackupEngine(archive.NewTarArchiveHandler(), dc, filesystem.NewHandler(), logger.New())
+	out := filepath.Join(t.TempDir(), "out.tar.gz")
+	if _, err := engine.Backup(ctx, BackupRequest{TargetType: TargetContainer, ContainerID: "unit_test",
+		Options: NewBackupOptionsBuilder().WithOutput(out).WithSBOM(true).Build()}); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	if len(ran) != 2 {
+		t.Fatalf("expected syft then docker sbom to be tried, got %v", ran)
+	}
+
+	prov, err := ReadProvenance(ctx, out)
+	if err != nil {
+		t.Fatalf("read provenance: %v", err)
+	}
+	p := prov[""]
+	if p == nil || p.Image != "app:1.0" || p.Platform != "linux/arm64/v8" || len(p.RepoDigests) != 1 {
+		t.Fatalf("unexpected provenance %+v", p)
+	}
+	if p.Annotations["org.opencontainers.image.source"] != "https://git.example/app" || len(p.Annotations) != 1 {
+		t.Fatalf("expected only OCI annotations, got %v", p.Annotations)
+	}
+	if p.SBOM != sbomFileName || p.SBOMTool != "docker sbom" {
+		t.Fatalf("expected the docker sbom result to be recorded, got %q from %q", p.SBOM, p.SBOMTool)
+	}
+	sbom, err := ReadSBOM(ctx, out, "")
+	if err != nil || string(sbom) != `{"spdxVersion":"SPDX-2.3"}` {
+		t.Fatalf("ReadSBOM = %q, %v", sbom, err)
+	}
+}
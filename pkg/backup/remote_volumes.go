@@ -0,0 +1,51 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/brian033/dockerbackup/pkg/docker"
+)
+
+// remoteMountTypes are the local-driver `type=` options that mount a network share.
+var remoteMountTypes = map[string]bool{"nfs": true, "nfs4": true, "cifs": true, "smb": true, "smb3": true}
+
+// isRemoteVolume reports whether a volume is a local-driver mount of an NFS or CIFS share,
+// whose data lives on the remote server rather than on this host.
+func isRemoteVolume(v *docker.VolumeConfig) bool {
+	if v == nil || (v.Driver != "" && v.Driver != "local") {
+		return false
+	}
+	return remoteMountTypes[strings.ToLower(v.Options["type"])]
+}
+
+// skipRemoteVolume reports whether the data of a named volume should be left on its share:
+// the volume is recreated on restore from the mount options in volume_configs.json.
+func (e *DefaultBackupEngine) skipRemoteVolume(ctx context.Context, m docker.Mount, opts BackupOptions) bool {
+	if !opts.SkipRemoteVolumeData || isPluginDriver(m.Driver) {
+		return false
+	}
+	v, err := e.dockerClient.InspectVolume(ctx, m.Name)
+	if err != nil || !isRemoteVolume(v) {
+		return false
+	}
+	e.warn(WarnRemoteVolumeData, m.Name, "Volume %s is a %s mount (%s), recording its mount options only", m.Name, v.Options["type"], v.Options["device"])
+	return true
+}
+
+// logRemoteVolumes notes volumes whose data was left on their share at backup time.
+func (e *DefaultBackupEngine) logRemoteVolumes(dir string) {
+	b, err := e.filesystem.ReadFile(filepath.Join(dir, "metadata.json"))
+	if err != nil {
+		return
+	}
+	var meta backupMetadata
+	if json.Unmarshal(b, &meta) != nil {
+		return
+	}
+	for _, name := range meta.RemoteVolumes {
+		e.warn(WarnRemoteVolumeData, name, "Volume %s was backed up without data (--skip-remote-volume-data); it is recreated with its recorded mount options and uses the data on the share", name)
+	}
+}
@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+func TestBackup_SkipRemoteVolumeData(t *testing.T) {
+	ctx := context.Background()
+	arch := archive.NewTarArchiveHandler()
+	nfsData := t.TempDir()
+	_ = os.WriteFile(filepath.Join(nfsData, "big.bin"), []byte("remote"), 0o644)
+	localData := t.TempDir()
+	_ = os.WriteFile(filepath.Join(localData, "a.txt"), []byte("local"), 0o644)
+	inspect := []map[string]any{{"Id": "123", "Name": "/unit_test", "Mounts": []map[string]any{
+		{"Type": "volume", "Name": "share", "Source": nfsData, "Destination": "/share", "Driver": "local", "RW": true},
+		{"Type": "volume", "Name": "cache", "Source": localData, "Destination": "/cache", "Driver": "local", "RW": true},
+	}}}
+	b, _ := json.Marshal(inspect)
+	dc := &fakeDockerClient{inspectJSON: b, volumes: map[string]*docker.VolumeConfig{
+		"share": {Name: "share", Driver: "local", Options: map[string]string{"type": "nfs", "o": "addr=10.0.0.5,rw", "device": ":/export/share"}},
+		"cache": {Name: "cache", Driver: "local"},
+	}}
+	engine := NewDefaultBackupEngine(arch, dc, filesystem.NewHandler(), logger.New())
+
+	for _, skip := range []bool{false, true} {
+		out := filepath.Join(t.TempDir(), "out.tar.gz")
+		if _, err := engine.Backup(ctx, BackupRequest{TargetType: TargetContainer, ContainerID: "unit_test",
+			Options: NewBackupOptionsBuilder().WithOutput(out).WithSkipRemoteVolumeData(skip).Build()}); err != nil {
+			t.Fatalf("backup failed: %v", err)
+		}
+		if _, err := arch.ReadEntry(ctx, out, "volumes/cache.tar.gz"); err != nil {
+			t.Fatalf("local volume data must always be archived: %v", err)
+		}
+		_, err := arch.ReadEntry(ctx, out, "volumes/share.tar.gz")
+		if skip != (err != nil) {
+			t.Fatalf("skip=%v: share data archived = %v", skip, err == nil)
+		}
+		var meta backupMetadata
+		mb, _ := arch.ReadEntry(ctx, out, "metadata.json")
+		_ = json.Unmarshal(mb, &meta)
+		if skip != (len(meta.RemoteVolumes) == 1) {
+			t.Fatalf("skip=%v: remoteVolumes = %v", skip, meta.RemoteVolumes)
+		}
+		cb, _ := arch.ReadEntry(ctx, out, "volumes/volume_configs.json")
+		if !strings.Contains(string(cb), ":/export/share") {
+			t.Fatalf("NFS mount options must be recorded: %s", cb)
+		}
+	}
+}
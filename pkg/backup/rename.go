@@ -0,0 +1,104 @@
+package backup
+
+import (
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+const composeProjectLabel = "com.docker.compose.project"
+
+// projectRenamer rewrites compose-derived resource names and labels when a project is restored
+// under a new name. A nil renamer leaves everything untouched.
+type projectRenamer struct {
+	from string
+	to   string
+}
+
+func newProjectRenamer(from, to string) *projectRenamer {
+	if from == "" || to == "" || from == to {
+		return nil
+	}
+	return &projectRenamer{from: from, to: to}
+}
+
+// name renames "<from>_x" and "<from>-x" (compose v1 and v2 naming) to the new project prefix.
+// Names without the project prefix, e.g. external networks, are returned unchanged.
+func (r *projectRenamer) name(n string) string {
+	if r == nil {
+		return n
+	}
+	for _, sep := range []string{"_", "-"} {
+		if strings.HasPrefix(n, r.from+sep) {
+			return r.to + sep + strings.TrimPrefix(n, r.from+sep)
+		}
+	}
+	return n
+}
+
+// labels returns a copy of l with the compose project label rewritten.
+func (r *projectRenamer) labels(l map[string]string) map[string]string {
+	if r == nil || l[composeProjectLabel] != r.from {
+		return l
+	}
+	out := make(map[string]string, len(l))
+	for k, v := range l {
+		out[k] = v
+	}
+	out[composeProjectLabel] = r.to
+	return out
+}
+
+// mapNames extends base (explicit user mappings win) with renames for names carrying the
+// project prefix. base is not modified.
+func (r *projectRenamer) mapNames(names []string, base map[string]string) map[string]string {
+	out := make(map[string]string, len(base))
+	for k, v := range base {
+		out[k] = v
+	}
+	if r == nil {
+		return out
+	}
+	for _, n := range names {
+		if _, ok := out[n]; ok {
+			continue
+		}
+		if renamed := r.name(n); renamed != n {
+			out[n] = renamed
+		}
+	}
+	return out
+}
+
+func composeProjectOf(cj types.ContainerJSON) string {
+	if cj.Config == nil {
+		return ""
+	}
+	return cj.Config.Labels[composeProjectLabel]
+}
+
+// remapVolumeRefs rewrites named volume references in Binds ("vol:/path[:mode]") and Mounts.
+func remapVolumeRefs(hostCfg *container.HostConfig, volumeMap map[string]string) {
+	if hostCfg == nil || len(volumeMap) == 0 {
+		return
+	}
+	for i, b := range hostCfg.Binds {
+		parts := strings.SplitN(b, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if m, ok := volumeMap[parts[0]]; ok && m != "" {
+			hostCfg.Binds[i] = m + ":" + parts[1]
+		}
+	}
+	for i := range hostCfg.Mounts {
+		m := &hostCfg.Mounts[i]
+		if m.Type != "volume" {
+			continue
+		}
+		if nm, ok := volumeMap[m.Source]; ok && nm != "" {
+			m.Source = nm
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+// reportFileName is the archive entry holding the report with backup --report.
+const reportFileName = "report.json"
+
+// BackupReport summarizes what a backup captured: how long each stage took, how large each
+// component is before and after compression, and what was left out.
+type BackupReport struct {
+	Target      string            `json:"target"`
+	Archive     string            `json:"archive,omitempty"`
+	ArchiveSize int64             `json:"archiveSize,omitempty"`
+	Seconds     float64           `json:"seconds"`
+	Stages      []ReportStage     `json:"stages"`
+	Components  []ReportComponent `json:"components"`
+	Warnings    []Warning         `json:"warnings,omitempty"`
+}
+
+// ReportStage is the duration of one stage of a backup, and how many bytes it processed when
+// known: the uncompressed size of the components it archived, or the archive it packaged.
+type ReportStage struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+	Bytes   int64   `json:"bytes,omitempty"`
+}
+
+// ReportComponent is one part of the archive. Size is its uncompressed size and Compressed
+// what it takes in the archive; either is 0 when unknown.
+type ReportComponent struct {
+	Name       string `json:"name"`
+	Size       int64  `json:"size,omitempty"`
+	Compressed int64  `json:"compressed,omitempty"`
+	Note       string `json:"note,omitempty"`
+}
+
+// reporter collects a BackupReport while a backup runs.
+type reporter struct {
+	report      BackupReport
+	start, last time.Time
+	// components recorded before the current stage
+	counted int
+}
+
+func newReporter(target string) *reporter {
+	now := time.Now()
+	return &reporter{report: BackupReport{Target: target, Stages: []ReportStage{}, Components: []ReportComponent{}}, start: now, last: now}
+}
+
+// stage records the stage that ran since the previous call (or the start) as name.
+func (r *reporter) stage(name string) {
+	now := time.Now()
+	st := ReportStage{Name: name, Seconds: now.Sub(r.last).Seconds()}
+	for _, c := range r.report.Components[r.counted:] {
+		st.Bytes += c.Size
+	}
+	r.report.Stages = append(r.report.Stages, st)
+	r.last, r.counted = now, len(r.report.Components)
+}
+
+func (r *reporter) component(c ReportComponent) {
+	r.report.Components = append(r.report.Components, c)
+}
+
+// write stores the report so far, with warnings, as report.json in dir, for backup --report.
+// The packaging stage and the compressed sizes in the final archive are not known yet at
+// that point.
+func (r *reporter) write(fsys filesystem.Handler, dir string, warnings []Warning) error {
+	rep := r.report
+	rep.Seconds = time.Since(r.start).Seconds()
+	rep.Warnings = warnings
+	b, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsys.WriteFile(filepath.Join(dir, reportFileName), b, 0o644)
+}
+
+// packaged records the sizes of the sources packaged into the archive at outputPath. Small
+// metadata entries are summed up as one component; sources listed in reported were broken
+// down into components already. The stage just recorded, which packaged them, processed the
+// archive's bytes.
+func (r *reporter) packaged(fsys filesystem.Handler, outputPath string, stats []archive.SourceStats, reported ...string) {
+	meta := ReportComponent{Name: "metadata"}
+	for _, s := range stats {
+		switch {
+		case slices.Contains(reported, s.DestPath):
+		case slices.Contains([]string{formatManifestName, "metadata.json", "container.json", mountsFileName, reportFileName, "networks", "volumes", securityDirName, provenanceDirName}, s.DestPath):
+			meta.Size += s.Size
+			meta.Compressed += s.Compressed
+		default:
+			r.component(ReportComponent{Name: s.DestPath, Size: s.Size, Compressed: s.Compressed})
+		}
+	}
+	if meta.Size > 0 {
+		r.component(meta)
+	}
+	r.report.Archive = outputPath
+	if fi, err := fsys.Stat(outputPath); err == nil {
+		r.report.ArchiveSize = fi.Size()
+	}
+	if n := len(r.report.Stages); n > 0 {
+		r.report.Stages[n-1].Bytes = r.report.ArchiveSize
+	}
+	r.counted = len(r.report.Components)
+}
+
+// done returns the finished report.
+func (r *reporter) done() *BackupReport {
+	rep := r.report
+	rep.Seconds = time.Since(r.start).Seconds()
+	return &rep
+}
+
+// createArchive creates an archive, collecting per-source sizes when the handler can
+// report them.
+func (e *DefaultBackupEngine) createArchive(ctx context.Context, sources []archive.ArchiveSource, outputPath string) ([]archive.SourceStats, error) {
+	if th, ok := e.archiveHandler.(*archive.TarArchiveHandler); ok {
+		return th.CreateArchiveWithStats(ctx, sources, outputPath)
+	}
+	return nil, e.archiveHandler.CreateArchive(ctx, sources, outputPath)
+}
+
+// mountComponent describes the data of a mount archived to volTarGz, or referenced from
+// the archive ref by a --skip-unchanged backup. stats are those of the nested archive's
+// creation and give its uncompressed size.
+func mountComponent(fsys filesystem.Handler, name, volTarGz string, stats []archive.SourceStats, ref string) ReportComponent {
+	c := ReportComponent{Name: name}
+	if ref != "" {
+		c.Note = "unchanged, stored in " + ref
+		return c
+	}
+	if fi, err := fsys.Stat(volTarGz); err == nil {
+		c.Compressed = fi.Size()
+	}
+	for _, s := range stats {
+		c.Size += s.Size
+	}
+	return c
+}
@@ -0,0 +1,174 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brian033/dockerbackup/pkg/archive"
+)
+
+// Labels enrolling a container in scheduled backups (`dockerbackup scheduled`): how often it
+// is backed up and which of its backups are kept. LabelIgnore=true opts it out of backup-all.
+const (
+	LabelSchedule = "dockerbackup.schedule"
+	LabelPolicy   = "dockerbackup.policy"
+	LabelIgnore   = "dockerbackup.ignore"
+)
+
+// ParseSchedule reads a dockerbackup.schedule label: hourly, daily, weekly or an interval such
+// as 6h or 2d.
+func ParseSchedule(s string) (time.Duration, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "hourly":
+		return time.Hour, nil
+	case "daily":
+		return 24 * time.Hour, nil
+	case "weekly":
+		return 7 * 24 * time.Hour, nil
+	}
+	d, err := parseInterval(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q (want hourly, daily, weekly or an interval like 6h or 2d)", LabelSchedule, s)
+	}
+	return d, nil
+}
+
+// parseInterval is time.ParseDuration that also accepts whole days (7d).
+func parseInterval(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		days, err := strconv.Atoi(n)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// RetentionPolicy says which backups of a container scheduled runs keep. A backup is kept
+// when either rule keeps it; with neither set, all are kept.
+type RetentionPolicy struct {
+	// KeepLast keeps the newest n backups
+	KeepLast int
+	// KeepWithin keeps the backups younger than this
+	KeepWithin time.Duration
+}
+
+// ParsePolicy reads a dockerbackup.policy label: comma-separated keep=<n> and
+// keep-within=<interval>, e.g. keep=7,keep-within=30d.
+func ParsePolicy(s string) (RetentionPolicy, error) {
+	var p RetentionPolicy
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(item, "=")
+		switch k {
+		case "keep":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return p, fmt.Errorf("invalid %s keep=%q (want a count of at least 1)", LabelPolicy, v)
+			}
+			p.KeepLast = n
+		case "keep-within":
+			d, err := parseInterval(v)
+			if err != nil || d <= 0 {
+				return p, fmt.Errorf("invalid %s keep-within=%q (want an interval like 30d)", LabelPolicy, v)
+			}
+			p.KeepWithin = d
+		default:
+			return p, fmt.Errorf("unknown %s setting %q (want keep or keep-within)", LabelPolicy, k)
+		}
+	}
+	return p, nil
+}
+
+// ContainerBackup is a backup of one container found in a directory.
+type ContainerBackup struct {
+	Path string
+	Info *BackupInfo
+}
+
+// ContainerBackups returns the backups of the container in dir, newest first. Compose and
+// multi-container backups are left out: they are not the container's own.
+func ContainerBackups(ctx context.Context, dir, name string) ([]ContainerBackup, error) {
+	files, err := ListBackups(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []ContainerBackup
+	for _, f := range files {
+		info, err := ReadBackupInfo(ctx, f.Path)
+		if err != nil || info.ContainerName == "" || strings.TrimPrefix(info.ContainerName, "/") != name {
+			continue
+		}
+		if info.CreatedAt.IsZero() {
+			info.CreatedAt = f.Time
+		}
+		out = append(out, ContainerBackup{Path: f.Path, Info: info})
+	}
+	slices.SortStableFunc(out, func(a, b ContainerBackup) int { return b.Info.CreatedAt.Compare(a.Info.CreatedAt) })
+	return out, nil
+}
+
+// Expired returns the backups, given newest first, the policy lets go. The newest backup is
+// always kept, and so are the archives a kept --skip-unchanged backup takes volumes from.
+func (p RetentionPolicy) Expired(backups []ContainerBackup, now time.Time) []ContainerBackup {
+	if p.KeepLast == 0 && p.KeepWithin == 0 {
+		return nil
+	}
+	referenced := map[string]bool{}
+	var expired []ContainerBackup
+	for i, b := range backups {
+		keep := i == 0 || (p.KeepLast > 0 && i < p.KeepLast) || (p.KeepWithin > 0 && now.Sub(b.Info.CreatedAt) <= p.KeepWithin)
+		if keep {
+			for _, ref := range b.Info.VolumeRefs {
+				referenced[filepath.Base(ref)] = true
+			}
+			continue
+		}
+		expired = append(expired, b)
+	}
+	out := expired[:0]
+	for _, b := range expired {
+		if !referenced[filepath.Base(b.Path)] {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// RemoveBackup deletes a backup archive with its checksum file, or a split archive's manifest
+// with its parts.
+func RemoveBackup(path string) error {
+	if strings.HasSuffix(path, archive.SplitManifestSuffix) {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		m, err := archive.ReadSplitManifest(f)
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+		for _, part := range m.Parts {
+			if err := os.Remove(filepath.Join(filepath.Dir(path), part.Name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		_ = os.Remove(strings.TrimSuffix(path, archive.SplitManifestSuffix) + archive.ChecksumSuffix)
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	_ = os.Remove(path + archive.ChecksumSuffix)
+	return nil
+}
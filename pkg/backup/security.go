@@ -0,0 +1,238 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+const (
+	securityDirName        = "security"
+	securityManifestName   = "profiles.json"
+	apparmorProfilesDir    = "/etc/apparmor.d"
+	apparmorLoadedProfiles = "/sys/kernel/security/apparmor/profiles"
+	securityKindSeccomp    = "seccomp"
+	securityKindAppArmor   = "apparmor"
+)
+
+// securityProfile records a profile referenced from HostConfig.SecurityOpt that lives
+// outside the container and was copied into the backup.
+type securityProfile struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	File   string `json:"file"`
+}
+
+// captureSecurityProfiles copies custom seccomp profile files and AppArmor profile sources
+// referenced in SecurityOpt from the host into destDir on fsys and writes a manifest. Inline seccomp JSON and the
+// built-in profiles are already reproducible from container.json and are skipped.
+func captureSecurityProfiles(fsys filesystem.Handler, hostCfg *container.HostConfig, destDir string) ([]securityProfile, error) {
+	if hostCfg == nil {
+		return nil, nil
+	}
+	var profiles []securityProfile
+	for _, opt := range hostCfg.SecurityOpt {
+		kind, value, ok := splitSecurityOpt(opt)
+		if !ok {
+			continue
+		}
+		var src string
+		switch kind {
+		case securityKindSeccomp:
+			if value == "unconfined" || value == "builtin" || strings.HasPrefix(strings.TrimSpace(value), "{") {
+				continue
+			}
+			src = value
+		case securityKindAppArmor:
+			if value == "unconfined" || value == "docker-default" {
+				continue
+			}
+			src = findAppArmorProfileSource(fsys, value)
+		default:
+			continue
+		}
+		if src == "" {
+			continue
+		}
+		b, err := fsys.ReadFile(src)
+		if err != nil {
+			continue
+		}
+		rel := filepath.ToSlash(filepath.Join(kind, safeName(filepath.Base(src))))
+		if err := fsys.EnsureDir(filepath.Join(destDir, kind), 0o755); err != nil {
+			return nil, err
+		}
+		if err := fsys.WriteFile(filepath.Join(destDir, filepath.FromSlash(rel)), b, 0o644); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, securityProfile{Kind: kind, Name: value, Source: src, File: rel})
+	}
+	if len(profiles) == 0 {
+		return nil, nil
+	}
+	b, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := fsys.WriteFile(filepath.Join(destDir, securityManifestName), b, 0o644); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// restoreSecurityProfiles re-points seccomp options at the captured profile (inlined, as the
+// API expects the JSON content) and, with LoadAppArmorProfiles, loads missing AppArmor
+// profiles on the host.
+func (e *DefaultBackupEngine) restoreSecurityProfiles(ctx context.Context, dir string, hostCfg *container.HostConfig, opts RestoreOptions) {
+	b, err := e.filesystem.ReadFile(filepath.Join(dir, securityManifestName))
+	if err != nil || hostCfg == nil {
+		return
+	}
+	var profiles []securityProfile
+	if err := json.Unmarshal(b, &profiles); err != nil {
+		e.log.Infof("Ignoring unreadable security profile manifest: %v", err)
+		return
+	}
+	for _, p := range profiles {
+		idx := -1
+		for i, opt := range hostCfg.SecurityOpt {
+			if kind, value, ok := splitSecurityOpt(opt); ok && kind == p.Kind && value == p.Name {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			// dropped via --drop-seccomp/--drop-apparmor
+			continue
+		}
+		content, err := e.filesystem.ReadFile(filepath.Join(dir, filepath.FromSlash(p.File)))
+		if err != nil {
+			e.warn(WarnSecurityProfile, p.File, "Security profile %s missing from backup: %v", p.File, err)
+			continue
+		}
+		switch p.Kind {
+		case securityKindSeccomp:
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, content); err != nil {
+				e.warn(WarnSecurityProfile, p.Source, "Seccomp profile %s is not valid JSON: %v", p.Source, err)
+				continue
+			}
+			hostCfg.SecurityOpt[idx] = "seccomp=" + compact.String()
+		case securityKindAppArmor:
+			if appArmorProfileLoaded(e.filesystem, p.Name) {
+				continue
+			}
+			if !opts.LoadAppArmorProfiles {
+				e.warn(WarnSecurityProfile, p.Name, "AppArmor profile %s is not loaded on this host; load the copy in the backup with --load-apparmor-profiles, or drop it with --drop-apparmor", p.Name)
+				continue
+			}
+			if err := e.installAppArmorProfile(ctx, p, content, opts.Confirm); err != nil {
+				e.warn(WarnSecurityProfile, p.Name, "Could not load AppArmor profile %s: %v", p.Name, err)
+			}
+		}
+	}
+}
+
+// installAppArmorProfile writes a captured profile to /etc/apparmor.d, unless a file of that
+// name is there already, and loads it. The profile file must declare the profile the container
+// refers to, so a backup cannot load a different one under its name.
+func (e *DefaultBackupEngine) installAppArmorProfile(ctx context.Context, p securityProfile, content []byte, confirm Confirmation) error {
+	if !slices.Contains(appArmorProfileNames(content), p.Name) {
+		return fmt.Errorf("%s does not declare profile %s", p.File, p.Name)
+	}
+	target := filepath.Join(apparmorProfilesDir, filepath.Base(p.File))
+	if err := confirm.Confirm(fmt.Sprintf("AppArmor profile %s will be loaded into the kernel from %s", p.Name, target)); err != nil {
+		return err
+	}
+	if _, err := e.filesystem.Stat(target); os.IsNotExist(err) {
+		if err := e.filesystem.WriteFile(target, content, 0o644); err != nil {
+			return err
+		}
+	}
+	if err := e.execCommand(ctx, "apparmor_parser", "-r", "-W", target); err != nil {
+		return fmt.Errorf("apparmor_parser %s: %w", target, err)
+	}
+	e.log.Infof("Loaded AppArmor profile %s from %s", p.Name, target)
+	return nil
+}
+
+func splitSecurityOpt(opt string) (kind, value string, ok bool) {
+	// SecurityOpt accepts both "key=value" and the legacy "key:value" form
+	sep := strings.IndexAny(opt, "=:")
+	if sep <= 0 {
+		return "", "", false
+	}
+	return opt[:sep], opt[sep+1:], true
+}
+
+func findAppArmorProfileSource(fsys filesystem.Handler, name string) string {
+	candidate := filepath.Join(apparmorProfilesDir, name)
+	if fi, err := fsys.Stat(candidate); err == nil && !fi.IsDir() {
+		return candidate
+	}
+	entries, err := fsys.ReadDir(apparmorProfilesDir)
+	if err != nil {
+		return ""
+	}
+	for _, en := range entries {
+		if en.IsDir() {
+			continue
+		}
+		p := filepath.Join(apparmorProfilesDir, en.Name())
+		b, err := fsys.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		if bytes.Contains(b, []byte("profile "+name+" ")) || bytes.Contains(b, []byte("profile "+name+"{")) {
+			return p
+		}
+	}
+	return ""
+}
+
+// appArmorProfileNames returns the profiles an AppArmor policy file declares at its top level,
+// by name ("profile NAME {") or by attachment path ("/usr/bin/app {").
+func appArmorProfileNames(content []byte) []string {
+	var names []string
+	depth := 0
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if depth == 0 && strings.HasSuffix(line, "{") {
+			fields := strings.Fields(strings.TrimSuffix(line, "{"))
+			switch {
+			case len(fields) > 1 && fields[0] == "profile":
+				names = append(names, strings.Trim(fields[1], `"`))
+			case len(fields) > 0 && strings.HasPrefix(fields[0], "/"):
+				names = append(names, fields[0])
+			}
+		}
+		depth += strings.Count(line, "{") - strings.Count(line, "}")
+	}
+	return names
+}
+
+func appArmorProfileLoaded(fsys filesystem.Handler, name string) bool {
+	b, err := fsys.ReadFile(apparmorLoadedProfiles)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, name+" (") {
+			return true
+		}
+	}
+	return false
+}
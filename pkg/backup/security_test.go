@@ -0,0 +1,188 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+const testAppArmorProfile = `#include <tunables/global>
+
+profile web-restricted flags=(attach_disconnected) {
+  #include <abstractions/base>
+  deny /etc/shadow r,
+}
+`
+
+func TestCaptureSecurityProfiles(t *testing.T) {
+	fsys := filesystem.NewMemHandler()
+	for _, d := range []string{"/etc/docker", apparmorProfilesDir} {
+		if err := fsys.EnsureDir(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = fsys.WriteFile("/etc/docker/seccomp.json", []byte(`{"defaultAction": "SCMP_ACT_ERRNO"}`), 0o644)
+	// found by its file name, and by the profile it declares
+	_ = fsys.WriteFile(filepath.Join(apparmorProfilesDir, "db-restricted"), []byte("profile db-restricted {\n}\n"), 0o644)
+	_ = fsys.WriteFile(filepath.Join(apparmorProfilesDir, "containers"), []byte(testAppArmorProfile), 0o644)
+
+	hostCfg := &container.HostConfig{SecurityOpt: []string{
+		"seccomp=/etc/docker/seccomp.json",
+		`seccomp={"defaultAction": "SCMP_ACT_ALLOW"}`,
+		"seccomp:unconfined",
+		"apparmor=docker-default",
+		"apparmor=db-restricted",
+		"apparmor:web-restricted",
+		"apparmor=not-on-this-host",
+		"no-new-privileges",
+	}}
+	dest := "/backup/security"
+	profiles, err := captureSecurityProfiles(fsys, hostCfg, dest)
+	if err != nil {
+		t.Fatalf("captureSecurityProfiles: %v", err)
+	}
+	want := []securityProfile{
+		{Kind: "seccomp", Name: "/etc/docker/seccomp.json", Source: "/etc/docker/seccomp.json", File: "seccomp/seccomp.json"},
+		{Kind: "apparmor", Name: "db-restricted", Source: filepath.Join(apparmorProfilesDir, "db-restricted"), File: "apparmor/db-restricted"},
+		{Kind: "apparmor", Name: "web-restricted", Source: filepath.Join(apparmorProfilesDir, "containers"), File: "apparmor/containers"},
+	}
+	if !slices.Equal(profiles, want) {
+		t.Fatalf("profiles = %+v, want %+v", profiles, want)
+	}
+	b, err := fsys.ReadFile(filepath.Join(dest, securityManifestName))
+	if err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+	var manifest []securityProfile
+	if err := json.Unmarshal(b, &manifest); err != nil || !slices.Equal(manifest, want) {
+		t.Fatalf("manifest = %s, %v", b, err)
+	}
+	if got, _ := fsys.ReadFile(filepath.Join(dest, "apparmor", "containers")); string(got) != testAppArmorProfile {
+		t.Fatalf("captured AppArmor profile = %q", got)
+	}
+
+	none, err := captureSecurityProfiles(fsys, &container.HostConfig{SecurityOpt: []string{"seccomp=unconfined"}}, "/other")
+	if err != nil || none != nil {
+		t.Fatalf("captureSecurityProfiles without custom profiles = %v, %v", none, err)
+	}
+	if _, err := fsys.Stat("/other"); err == nil {
+		t.Fatal("a manifest was written without custom profiles")
+	}
+}
+
+// stubHostCommands records the host commands the engine runs instead of running them.
+func stubHostCommands(t *testing.T) *[]string {
+	var ran []string
+	prev := runHostCommand
+	runHostCommand = func(cmd *exec.Cmd) error {
+		ran = append(ran, strings.Join(cmd.Args, " "))
+		return nil
+	}
+	t.Cleanup(func() { runHostCommand = prev })
+	return &ran
+}
+
+func TestRestoreSecurityProfiles(t *testing.T) {
+	ctx := context.Background()
+	ran := stubHostCommands(t)
+	fsys := filesystem.NewMemHandler()
+	dir := "/restore/security"
+	for _, d := range []string{filepath.Join(dir, "seccomp"), filepath.Join(dir, "apparmor"), apparmorProfilesDir} {
+		if err := fsys.EnsureDir(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = fsys.WriteFile(filepath.Join(dir, "seccomp", "seccomp.json"), []byte("{\n  \"defaultAction\": \"SCMP_ACT_ERRNO\"\n}\n"), 0o644)
+	_ = fsys.WriteFile(filepath.Join(dir, "apparmor", "containers"), []byte(testAppArmorProfile), 0o644)
+	_ = fsys.WriteFile(filepath.Join(dir, "apparmor", "impostor"), []byte("profile something-else {\n}\n"), 0o644)
+	profiles := []securityProfile{
+		{Kind: "seccomp", Name: "/etc/docker/seccomp.json", Source: "/etc/docker/seccomp.json", File: "seccomp/seccomp.json"},
+		{Kind: "seccomp", Name: "/etc/docker/dropped.json", Source: "/etc/docker/dropped.json", File: "seccomp/dropped.json"},
+		{Kind: "seccomp", Name: "/etc/docker/lost.json", Source: "/etc/docker/lost.json", File: "seccomp/lost.json"},
+		{Kind: "apparmor", Name: "web-restricted", Source: "/etc/apparmor.d/containers", File: "apparmor/containers"},
+		{Kind: "apparmor", Name: "db-restricted", Source: "/etc/apparmor.d/impostor", File: "apparmor/impostor"},
+	}
+	b, _ := json.Marshal(profiles)
+	_ = fsys.WriteFile(filepath.Join(dir, securityManifestName), b, 0o644)
+	e := NewDefaultBackupEngine(nil, nil, fsys, logger.New()).(*DefaultBackupEngine)
+
+	restore := func(opts RestoreOptions) (*container.HostConfig, []Warning) {
+		// the dropped profile is not in SecurityOpt (--drop-seccomp)
+		hostCfg := &container.HostConfig{SecurityOpt: []string{
+			"seccomp=/etc/docker/seccomp.json",
+			"seccomp=/etc/docker/lost.json",
+			"apparmor=web-restricted",
+			"apparmor=db-restricted",
+		}}
+		warnings := e.collectWarnings(func() { e.restoreSecurityProfiles(ctx, dir, hostCfg, opts) })
+		return hostCfg, warnings
+	}
+	subjects := func(ws []Warning) []string {
+		var out []string
+		for _, w := range ws {
+			out = append(out, w.Subject)
+		}
+		return out
+	}
+
+	hostCfg, warnings := restore(RestoreOptions{})
+	if got := hostCfg.SecurityOpt[0]; got != `seccomp={"defaultAction":"SCMP_ACT_ERRNO"}` {
+		t.Fatalf("seccomp option = %q", got)
+	}
+	if got := hostCfg.SecurityOpt[1]; got != "seccomp=/etc/docker/lost.json" {
+		t.Fatalf("seccomp option of a profile missing from the backup = %q", got)
+	}
+	if got := subjects(warnings); !slices.Equal(got, []string{"seccomp/lost.json", "web-restricted", "db-restricted"}) {
+		t.Fatalf("warnings without --load-apparmor-profiles = %v", got)
+	}
+	if len(*ran) != 0 {
+		t.Fatalf("ran %v without --load-apparmor-profiles", *ran)
+	}
+
+	_, warnings = restore(RestoreOptions{LoadAppArmorProfiles: true, Confirm: ConfirmYes})
+	target := filepath.Join(apparmorProfilesDir, "containers")
+	if !slices.Equal(*ran, []string{"apparmor_parser -r -W " + target}) {
+		t.Fatalf("ran %v", *ran)
+	}
+	if got, _ := fsys.ReadFile(target); string(got) != testAppArmorProfile {
+		t.Fatalf("installed profile = %q", got)
+	}
+	// a file declaring another profile is not loaded under the name the container uses
+	if got := subjects(warnings); !slices.Equal(got, []string{"seccomp/lost.json", "db-restricted"}) {
+		t.Fatalf("warnings with --load-apparmor-profiles = %v", got)
+	}
+	if _, err := fsys.Stat(filepath.Join(apparmorProfilesDir, "impostor")); err == nil {
+		t.Fatal("the profile declaring another name was installed")
+	}
+
+	// already loaded profiles are left alone
+	*ran = nil
+	_ = fsys.EnsureDir(filepath.Dir(apparmorLoadedProfiles), 0o755)
+	_ = fsys.WriteFile(apparmorLoadedProfiles, []byte("web-restricted (enforce)\ndocker-default (enforce)\n"), 0o644)
+	restore(RestoreOptions{LoadAppArmorProfiles: true, Confirm: ConfirmYes})
+	if slices.ContainsFunc(*ran, func(c string) bool { return strings.HasSuffix(c, target) }) {
+		t.Fatalf("reloaded a loaded profile: %v", *ran)
+	}
+}
+
+func TestAppArmorProfileNames(t *testing.T) {
+	for content, want := range map[string][]string{
+		testAppArmorProfile:                                                {"web-restricted"},
+		"/usr/bin/app flags=(complain) {\n}\n":                             {"/usr/bin/app"},
+		"profile outer {\n  profile inner {\n  }\n}\nprofile second{\n}\n": {"outer", "second"},
+		"# profile commented {\n":                                          nil,
+	} {
+		if got := appArmorProfileNames([]byte(content)); !slices.Equal(got, want) {
+			t.Errorf("appArmorProfileNames(%q) = %v, want %v", content, got, want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/archive"
+)
+
+// existingRestore returns the ID of the container an earlier restore of this backup created
+// under the target name, or "" when there is none. Only container.json and metadata.json are
+// read, so a repeated restore returns before extracting anything. A container of that name
+// not restored from this backup is an error unless the name conflict policy settles it.
+func (e *DefaultBackupEngine) existingRestore(ctx context.Context, request RestoreRequest) (string, error) {
+	th := archive.NewTarArchiveHandler()
+	b, err := th.ReadEntry(ctx, request.BackupPath, "container.json")
+	if err != nil {
+		// the restore reports unreadable backups
+		return "", nil
+	}
+	cj, err := decodeContainerJSON(b)
+	if err != nil {
+		return "", nil
+	}
+	name := request.Options.ContainerName
+	if name == "" {
+		name = newProjectRenamer(composeProjectOf(cj), request.ProjectName).name(strings.TrimPrefix(cj.Name, "/"))
+	}
+	if name == "" {
+		return "", nil
+	}
+	out, err := e.dockerClient.InspectContainer(ctx, name)
+	if err != nil {
+		return "", nil
+	}
+	existing, err := decodeContainerJSON(out)
+	if err != nil {
+		return "", nil
+	}
+
+	want := e.restoreLabels[LabelBackupID]
+	if want == "" {
+		meta, _ := th.ReadEntry(ctx, request.BackupPath, "metadata.json")
+		want = backupID(meta, request.BackupPath)
+	}
+	if existing.Config != nil && existing.Config.Labels[LabelBackupID] == want {
+		return existing.ID, nil
+	}
+	if request.Options.conflictPolicy(ConflictName) != ConflictFail {
+		return "", nil
+	}
+	return "", &errors.ValidationError{Field: "ContainerName", Msg: fmt.Sprintf("container %s exists and was not restored from this backup; use --replace, --on-conflict name=<policy> or --name", name)}
+}
+
+// restoreVolumeData extracts the volume archive recorded as a into target, mapping owners,
+// and checks the volume against the content summary recorded at backup time.
+func (e *DefaultBackupEngine) restoreVolumeData(ctx context.Context, target, volTarGz, root string, a mountArtifact) error {
+	// the helper's tar does not understand sparse entries: extract them here, keeping their
+	// holes, when the volume's data is on this host, or else expand them for the helper
+	sparse := a.Content != nil && a.Content.Sparse > 0
+	if a.Content == nil {
+		sparse = hasSparseFiles(ctx, volTarGz)
+	}
+	if sparse {
+		if dir := e.localVolumeDir(ctx, target); dir != "" {
+			e.log.Infof("Volume %s holds sparse files; extracting it into %s to keep their holes", target, dir)
+			opts := archive.ExtractOptions{StripRoot: root, IDs: e.ownerMap(a, true), SELinuxLabels: true}
+			if err := archive.ExtractTarGz(ctx, volTarGz, dir, opts); err != nil {
+				return &errors.OperationError{Op: fmt.Sprintf("restore volume %s", target), Err: err}
+			}
+			return e.verifyVolumeContent(ctx, target, a.Content)
+		}
+		e.warn(WarnSparseFiles, target, "Volume %s holds sparse files; restored through a helper container, they take their full size", target)
+	}
+	if ids := e.ownerMap(a, false); !ids.Empty() || sparse {
+		// the helper container extracts as recorded, so map the owners beforehand (the copy
+		// also stores sparse files in full)
+		mapped := volTarGz + ".idmap"
+		if err := archive.RemapTarGz(ctx, volTarGz, mapped, ids); err != nil {
+			return &errors.OperationError{Op: fmt.Sprintf("map owners for volume %s", target), Err: err}
+		}
+		defer func() { _ = e.filesystem.RemoveAll(mapped) }()
+		volTarGz = mapped
+	}
+	if err := e.dockerClient.ExtractTarGzToVolume(ctx, target, volTarGz, root); err != nil {
+		return &errors.OperationError{Op: fmt.Sprintf("restore volume %s", target), Err: err}
+	}
+	return e.verifyVolumeContent(ctx, target, a.Content)
+}
+
+// hasSparseFiles reports whether the volume archive at path stores files without their holes,
+// for backups without a content summary that records it.
+func hasSparseFiles(ctx context.Context, path string) bool {
+	sparse := false
+	_ = archive.NewTarArchiveHandler().WalkArchive(ctx, path, func(en archive.ArchiveEntry) error {
+		if en.Sparse {
+			sparse = true
+			return archive.ErrStopWalk
+		}
+		return nil
+	})
+	return sparse
+}
+
+// localVolumeDir returns the data directory of volume name when dockerbackup can fill it
+// directly: a plain local driver volume whose directory exists on this host, with dockerbackup
+// running as root so owners can be restored. It returns "" otherwise.
+func (e *DefaultBackupEngine) localVolumeDir(ctx context.Context, name string) string {
+	if os.Geteuid() != 0 {
+		return ""
+	}
+	v, err := e.dockerClient.InspectVolume(ctx, name)
+	// a local volume with mount options (NFS, a device) is only mounted while in use
+	if err != nil || v == nil || v.Mountpoint == "" || (v.Driver != "" && v.Driver != "local") || len(v.Options) > 0 {
+		return ""
+	}
+	if fi, err := e.filesystem.Stat(v.Mountpoint); err != nil || !fi.IsDir() {
+		return ""
+	}
+	return v.Mountpoint
+}
+
+// contentDigest is archive.ContentDigest for a tar.gz on the engine's filesystem.
+func (e *DefaultBackupEngine) contentDigest(ctx context.Context, tarGzPath, root string) (*archive.ContentSummary, error) {
+	f, err := e.filesystem.Open(tarGzPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return archive.ContentDigestReader(ctx, f, root)
+}
+
+// verifyVolumeContent archives a restored volume back through the daemon and compares its
+// content with the summary recorded at backup time, to catch data the extraction lost (a
+// helper container running out of space or killed midway). A mismatch fails the restore, or
+// is a warning with --lenient; backups without a summary are not checked.
+func (e *DefaultBackupEngine) verifyVolumeContent(ctx context.Context, target string, want *archive.ContentSummary) error {
+	if want == nil {
+		return nil
+	}
+	tmpDir, err := e.filesystem.MkdirTemp(WorkDir(), "dockerbackup_verify_*")
+	if err != nil {
+		return &errors.OperationError{Op: "create temp dir", Err: err}
+	}
+	defer func() { _ = e.filesystem.RemoveAll(tmpDir) }()
+	tmp := filepath.Join(tmpDir, safeName(target)+".tar.gz")
+	var got *archive.ContentSummary
+	if err = e.dockerClient.ArchiveVolume(ctx, target, tmp); err == nil {
+		got, err = e.contentDigest(ctx, tmp, target)
+	}
+	if err != nil {
+		e.warn(WarnVolumeContent, target, "Could not verify the restored data of volume %s: %v", target, err)
+		return nil
+	}
+	if got.Digest == want.Digest {
+		e.log.Debugf("Volume %s matches the backup (%d files, %d bytes)", target, got.Files, got.Bytes)
+		return nil
+	}
+	msg := fmt.Sprintf("volume %s does not match the backup after extraction: it holds %d files (%d bytes), the backup %d files (%d bytes)", target, got.Files, got.Bytes, want.Files, want.Bytes)
+	if e.lenient {
+		e.warn(WarnVolumeContent, target, "Restored %s", msg)
+		return nil
+	}
+	return &errors.OperationError{Op: "verify volume " + target, Err: fmt.Errorf("%s; use --lenient to keep it anyway", msg)}
+}
@@ -0,0 +1,76 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/docker/docker/api/types/network"
+)
+
+// mapStaticIP returns the address --ip-map gives addr, or addr.
+func mapStaticIP(addr string, ipMap map[string]string) string {
+	if addr == "" {
+		return addr
+	}
+	if to, ok := ipMap[canonicalIP(addr)]; ok {
+		return to
+	}
+	return addr
+}
+
+// checkStaticIPs drops the saved static addresses the target networks cannot give the
+// container, so Docker assigns one instead of refusing to create it: addresses outside the
+// network's subnets and addresses another container holds.
+func (e *DefaultBackupEngine) checkStaticIPs(ctx context.Context, netCfg *network.NetworkingConfig) {
+	for name, ep := range netCfg.EndpointsConfig {
+		if ep == nil || ep.IPAMConfig == nil {
+			continue
+		}
+		nc, err := e.dockerClient.InspectNetwork(ctx, name)
+		if err != nil || nc == nil {
+			continue
+		}
+		ipam := *ep.IPAMConfig
+		for _, addr := range []*string{&ipam.IPv4Address, &ipam.IPv6Address} {
+			if problem := staticIPProblem(*addr, nc); problem != "" {
+				e.warn(WarnStaticIP, name, "Static IP %s not kept on network %s: %s; Docker assigns one", *addr, name, problem)
+				*addr = ""
+			}
+		}
+		if ipam.IPv4Address == "" && ipam.IPv6Address == "" && len(ipam.LinkLocalIPs) == 0 {
+			ep.IPAMConfig = nil
+		} else {
+			ep.IPAMConfig = &ipam
+		}
+	}
+}
+
+// staticIPProblem tells why the network cannot give a container addr, or returns "". Only
+// subnets of the address's family are considered; a network without any is left to Docker.
+func staticIPProblem(addr string, nc *docker.NetworkConfig) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	var subnets []string
+	inSubnet := false
+	for _, c := range nc.IPAM.Config {
+		_, ipn, err := net.ParseCIDR(c.Subnet)
+		if err != nil || (ipn.IP.To4() == nil) != (ip.To4() == nil) {
+			continue
+		}
+		subnets = append(subnets, c.Subnet)
+		if ipn.Contains(ip) {
+			inSubnet = true
+		}
+	}
+	if len(subnets) > 0 && !inSubnet {
+		return fmt.Sprintf("outside the network's subnets %v", subnets)
+	}
+	if holder, ok := nc.InUse[ip.String()]; ok {
+		return fmt.Sprintf("already used by container %s", holder)
+	}
+	return ""
+}
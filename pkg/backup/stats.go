@@ -0,0 +1,187 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+// StatsFileName is the log in a backup directory to which every backup written there, and
+// every upload and restore of its archives, appends a StatsRecord, so `backups stats` can show
+// how backups grow and slow down over time. It outlives the archives retention removes.
+const StatsFileName = ".dockerbackup-stats.jsonl"
+
+// Kinds of StatsRecord.
+const (
+	StatsBackup  = "backup"
+	StatsUpload  = "upload"
+	StatsRestore = "restore"
+)
+
+// StatsRecord is the timing of one backup, upload or restore. Bytes is the archive size, or
+// what was sent for an upload; Size the uncompressed size of what a backup captured.
+type StatsRecord struct {
+	Kind    string        `json:"kind"`
+	Target  string        `json:"target"`
+	Archive string        `json:"archive"`
+	Time    time.Time     `json:"time"`
+	Seconds float64       `json:"seconds"`
+	Bytes   int64         `json:"bytes"`
+	Size    int64         `json:"size,omitempty"`
+	Stages  []ReportStage `json:"stages,omitempty"`
+}
+
+// Throughput returns the bytes per second of the record, or 0 when unknown.
+func (r StatsRecord) Throughput() float64 {
+	if r.Seconds <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Seconds
+}
+
+// backupStats returns the record of a finished backup.
+func backupStats(res *BackupResult) StatsRecord {
+	rep := res.Report
+	rec := StatsRecord{Kind: StatsBackup, Target: rep.Target, Archive: filepath.Base(res.OutputPath), Time: time.Now().UTC(), Seconds: rep.Seconds, Bytes: rep.ArchiveSize, Stages: rep.Stages}
+	for _, c := range rep.Components {
+		rec.Size += c.Size
+	}
+	return rec
+}
+
+// restoreStats returns the record of a restore of backupPath that took d.
+func restoreStats(ctx context.Context, fsys filesystem.Handler, backupPath string, d time.Duration) StatsRecord {
+	rec := StatsRecord{Kind: StatsRestore, Archive: filepath.Base(backupPath), Time: time.Now().UTC(), Seconds: d.Seconds()}
+	if info, err := ReadBackupInfo(ctx, backupPath); err == nil {
+		rec.Target = strings.TrimPrefix(info.ContainerName, "/")
+		if info.ProjectName != "" {
+			rec.Target = info.ProjectName
+		}
+	}
+	rec.Bytes = archiveSize(fsys, backupPath)
+	return rec
+}
+
+// AppendStats adds rec to the stats log of the backup directory dir.
+func AppendStats(dir string, rec StatsRecord) error {
+	return appendStats(filesystem.NewHandler(), dir, rec)
+}
+
+func appendStats(fsys filesystem.Handler, dir string, rec StatsRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := fsys.Append(filepath.Join(dir, StatsFileName), 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// recordStats appends rec to the stats log of dir; a directory that cannot take it only costs
+// the statistics.
+func (e *DefaultBackupEngine) recordStats(dir string, rec StatsRecord) {
+	if err := appendStats(e.filesystem, dir, rec); err != nil {
+		e.log.Infof("Could not record backup statistics in %s: %v", dir, err)
+	}
+}
+
+// ReadStats returns the records of the stats log of dir, oldest first, only those of target
+// unless it is empty. A directory without a log has no records.
+func ReadStats(dir, target string) ([]StatsRecord, error) {
+	f, err := filesystem.NewHandler().Open(filepath.Join(dir, StatsFileName))
+	if os.IsNotExist(err) {
+		return []StatsRecord{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	out := []StatsRecord{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	for sc.Scan() {
+		var rec StatsRecord
+		// a line cut short by a crash or full disk is skipped
+		if json.Unmarshal(sc.Bytes(), &rec) != nil {
+			continue
+		}
+		if target == "" || rec.Target == target {
+			out = append(out, rec)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out, nil
+}
+
+// StatsSummary is the trend of the backups, uploads or restores of one target.
+type StatsSummary struct {
+	Target string    `json:"target"`
+	Kind   string    `json:"kind"`
+	Count  int       `json:"count"`
+	First  time.Time `json:"first"`
+	Last   time.Time `json:"last"`
+	// Bytes and Seconds of the latest run, and the average Seconds and Throughput of all
+	LastBytes   int64   `json:"lastBytes"`
+	LastSeconds float64 `json:"lastSeconds"`
+	AvgSeconds  float64 `json:"avgSeconds"`
+	Throughput  float64 `json:"throughput"`
+	// GrowthPerDay is how much Bytes changed per day from the first run to the latest
+	GrowthPerDay float64 `json:"growthPerDay"`
+}
+
+// SummarizeStats returns the trend of each target and kind in recs (oldest first, as
+// ReadStats returns them), sorted by target and kind.
+func SummarizeStats(recs []StatsRecord) []StatsSummary {
+	type key struct{ target, kind string }
+	groups := map[key][]StatsRecord{}
+	var keys []key
+	for _, r := range recs {
+		k := key{r.Target, r.Kind}
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], r)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].target != keys[j].target {
+			return keys[i].target < keys[j].target
+		}
+		return keys[i].kind < keys[j].kind
+	})
+	out := make([]StatsSummary, 0, len(keys))
+	for _, k := range keys {
+		g := groups[k]
+		first, last := g[0], g[len(g)-1]
+		s := StatsSummary{Target: k.target, Kind: k.kind, Count: len(g), First: first.Time, Last: last.Time, LastBytes: last.Bytes, LastSeconds: last.Seconds}
+		var bytes int64
+		for _, r := range g {
+			s.AvgSeconds += r.Seconds
+			bytes += r.Bytes
+		}
+		if s.AvgSeconds > 0 {
+			s.Throughput = float64(bytes) / s.AvgSeconds
+		}
+		s.AvgSeconds /= float64(len(g))
+		if days := last.Time.Sub(first.Time).Hours() / 24; days > 0 {
+			s.GrowthPerDay = float64(last.Bytes-first.Bytes) / days
+		}
+		out = append(out, s)
+	}
+	return out
+}
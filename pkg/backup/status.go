@@ -0,0 +1,98 @@
+package backup
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+// ContainerStatus is the newest backup covering a container: one of the container itself, of
+// its compose project or a multi-container backup including it.
+type ContainerStatus struct {
+	Container string `json:"container"`
+	Project   string `json:"project,omitempty"`
+	// Backup is the archive, empty when none covers the container
+	Backup    string    `json:"backup,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	// Stale is set, given a maximum age, when there is no backup or it is older
+	Stale bool `json:"stale"`
+}
+
+// BackupStatus returns the status of each container from the backups in dir. With maxAge 0
+// nothing is stale.
+func BackupStatus(ctx context.Context, dir string, containers []docker.ContainerRef, maxAge time.Duration, now time.Time) ([]ContainerStatus, error) {
+	files, err := ListBackups(dir)
+	if err != nil {
+		return nil, err
+	}
+	fsys := filesystem.NewHandler()
+	type backupEntry struct {
+		file BackupFile
+		info *BackupInfo
+	}
+	var backups []backupEntry
+	for _, f := range files {
+		info, err := ReadBackupInfo(ctx, f.Path)
+		if err != nil {
+			continue
+		}
+		if info.CreatedAt.IsZero() {
+			info.CreatedAt = f.Time
+		}
+		backups = append(backups, backupEntry{f, info})
+	}
+	out := make([]ContainerStatus, 0, len(containers))
+	for _, c := range containers {
+		st := ContainerStatus{Container: c.Name, Project: c.Project, Stale: maxAge > 0}
+		for _, b := range backups {
+			if !covers(b.info, c) || (st.Backup != "" && !b.info.CreatedAt.After(st.CreatedAt)) {
+				continue
+			}
+			st.Backup, st.CreatedAt, st.Size = b.file.Path, b.info.CreatedAt, archiveSize(fsys, b.file.Path)
+		}
+		if st.Backup != "" {
+			st.Stale = maxAge > 0 && now.Sub(st.CreatedAt) > maxAge
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+// covers reports whether the backup holds the container.
+func covers(info *BackupInfo, c docker.ContainerRef) bool {
+	switch {
+	case info.ContainerName != "":
+		return strings.TrimPrefix(info.ContainerName, "/") == c.Name
+	case info.Group:
+		return slices.Contains(info.Services, c.Name)
+	case info.ProjectName != "":
+		return info.ProjectName == c.Project
+	}
+	return false
+}
+
+// archiveSize is the size of the archive, or of the archive a split manifest describes.
+func archiveSize(fsys filesystem.Handler, path string) int64 {
+	if strings.HasSuffix(path, archive.SplitManifestSuffix) {
+		f, err := fsys.Open(path)
+		if err != nil {
+			return 0
+		}
+		defer f.Close()
+		if m, err := archive.ReadSplitManifest(f); err == nil {
+			return m.Size
+		}
+		return 0
+	}
+	fi, err := fsys.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
@@ -0,0 +1,174 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+// volumeRefSuffix marks a volume stored as a reference to an earlier backup instead of data.
+const volumeRefSuffix = ".ref.json"
+
+// backupState is kept next to the archives as .<name>.state.json and records the last backup
+// (the parent of the next one) and, per volume archive (volumes/<file>.tar.gz), the tree
+// summary seen by the last run and the backup archive that holds that data.
+type backupState struct {
+	BackupID string                 `json:"backupId,omitempty"`
+	Archive  string                 `json:"archive,omitempty"`
+	Volumes  map[string]volumeState `json:"volumes"`
+}
+
+type volumeState struct {
+	Summary string `json:"summary"`
+	Archive string `json:"archive"`
+}
+
+// volumeRef replaces volumes/<file>.tar.gz in a backup whose volume was unchanged.
+type volumeRef struct {
+	Archive string `json:"archive"`
+	Entry   string `json:"entry"`
+	Summary string `json:"summary"`
+}
+
+// skipTracker implements --skip-unchanged for one backup run.
+type skipTracker struct {
+	fsys      filesystem.Handler
+	statePath string
+	outputDir string
+	output    string
+	prev      backupState
+	next      backupState
+	refs      map[string]struct{}
+}
+
+func newSkipTracker(fsys filesystem.Handler, outputPath, name string) *skipTracker {
+	t := &skipTracker{
+		fsys:      fsys,
+		statePath: filepath.Join(filepath.Dir(outputPath), "."+name+".state.json"),
+		outputDir: filepath.Dir(outputPath),
+		output:    filepath.Base(outputPath),
+		next:      backupState{Volumes: map[string]volumeState{}},
+		refs:      map[string]struct{}{},
+	}
+	if b, err := fsys.ReadFile(t.statePath); err == nil {
+		_ = json.Unmarshal(b, &t.prev)
+	}
+	return t
+}
+
+// archiveMountData archives src into volTarGz. With a tracker, a source whose summary matches
+// the previous run is written as a reference to the archive that already holds it.
+func (e *DefaultBackupEngine) archiveMountData(ctx context.Context, src archive.ArchiveSource, volTarGz string, skip *skipTracker) ([]archive.SourceStats, string, error) {
+	if skip == nil {
+		stats, err := e.createArchive(ctx, []archive.ArchiveSource{src}, volTarGz)
+		return stats, "", err
+	}
+	file := filepath.Base(volTarGz)
+	sum, err := filesystem.TreeSummaryWithin(src.Path, src.Boundary)
+	if err != nil {
+		e.log.Infof("Could not summarize %s, archiving it: %v", src.Path, err)
+		stats, err := e.createArchive(ctx, []archive.ArchiveSource{src}, volTarGz)
+		return stats, "", err
+	}
+	prev, ok := skip.prev.Volumes[file]
+	// the default (non-timestamped) name overwrites the previous archive, so it cannot be referenced
+	if ok && prev.Summary == sum && prev.Archive != "" && !isSameArchive(prev.Archive, skip.output) {
+		if _, err := e.filesystem.Stat(filepath.Join(skip.outputDir, prev.Archive)); err == nil {
+			ref := volumeRef{Archive: prev.Archive, Entry: "volumes/" + file, Summary: sum}
+			b, err := json.MarshalIndent(ref, "", "  ")
+			if err != nil {
+				return nil, "", err
+			}
+			e.log.Infof("%s unchanged since %s, referencing it", src.DestPath, prev.Archive)
+			skip.next.Volumes[file] = prev
+			skip.refs[prev.Archive] = struct{}{}
+			return nil, prev.Archive, e.filesystem.WriteFile(strings.TrimSuffix(volTarGz, ".tar.gz")+volumeRefSuffix, b, 0o644)
+		}
+	}
+	skip.next.Volumes[file] = volumeState{Summary: sum}
+	stats, err := e.createArchive(ctx, []archive.ArchiveSource{src}, volTarGz)
+	return stats, "", err
+}
+
+func isSameArchive(a, b string) bool {
+	return strings.TrimSuffix(a, archive.SplitManifestSuffix) == strings.TrimSuffix(b, archive.SplitManifestSuffix)
+}
+
+// referencedArchives lists the earlier backups this run depends on.
+func (t *skipTracker) referencedArchives() []string {
+	var out []string
+	for a := range t.refs {
+		out = append(out, a)
+	}
+	return out
+}
+
+// save records the state after a successful backup with the given ID; volumes archived in
+// this run point at finalPath (the archive or its split manifest).
+func (t *skipTracker) save(finalPath, id string) error {
+	t.next.BackupID, t.next.Archive = id, filepath.Base(finalPath)
+	for k, v := range t.next.Volumes {
+		if v.Archive == "" {
+			v.Archive = filepath.Base(finalPath)
+			t.next.Volumes[k] = v
+		}
+	}
+	b, err := json.MarshalIndent(t.next, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := t.statePath + ".tmp"
+	if err := t.fsys.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return t.fsys.Rename(tmp, t.statePath)
+}
+
+// resolveVolumeRefs replaces volume references in an extracted backup with the data copied
+// from the referenced archives, which must sit next to backupPath.
+func (e *DefaultBackupEngine) resolveVolumeRefs(ctx context.Context, dir, backupPath string) error {
+	var refs []string
+	entries, _ := e.filesystem.ReadDir(filepath.Join(dir, "volumes"))
+	for _, en := range entries {
+		if strings.HasSuffix(en.Name(), volumeRefSuffix) {
+			refs = append(refs, filepath.Join(dir, "volumes", en.Name()))
+		}
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+	th := e.archiveReader()
+	for _, p := range refs {
+		b, err := e.filesystem.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		var ref volumeRef
+		if err := json.Unmarshal(b, &ref); err != nil {
+			return fmt.Errorf("invalid volume reference %s: %w", filepath.Base(p), err)
+		}
+		src := filepath.Join(filepath.Dir(backupPath), filepath.Base(ref.Archive))
+		if _, err := e.filesystem.Stat(src); err != nil {
+			return fmt.Errorf("volume data is stored in %s (backup taken with --skip-unchanged), which must be next to %s: %w", ref.Archive, filepath.Base(backupPath), err)
+		}
+		e.log.Infof("Reading unchanged volume data from %s", ref.Archive)
+		out, err := e.filesystem.Create(strings.TrimSuffix(p, volumeRefSuffix) + ".tar.gz")
+		if err != nil {
+			return err
+		}
+		err = th.CopyEntry(ctx, src, ref.Entry, out)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("read %s from %s: %w", ref.Entry, ref.Archive, err)
+		}
+		_ = e.filesystem.RemoveAll(p)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package backup
+
+import (
+	"context"
+
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/docker"
+)
+
+// daemonUserns returns the subordinate ID range of the daemon, nil when it does not remap
+// user namespaces or cannot tell.
+func (e *DefaultBackupEngine) daemonUserns(ctx context.Context) *docker.UsernsRange {
+	r, err := e.dockerClient.UsernsRemap(ctx)
+	if err != nil {
+		e.log.Infof("Could not tell whether the daemon remaps user namespaces: %v", err)
+		return nil
+	}
+	if r != nil {
+		e.log.Infof("Daemon remaps user namespaces to IDs %d/%d (+%d)", r.UID, r.GID, r.Size)
+	}
+	return r
+}
+
+// ownerMap returns how to map the owners of the data of a. Owners recorded on the host of a
+// userns-remap daemon are shifted back to container IDs before --uid-map/--gid-map apply.
+// Data written on the host (onHost, bind mounts) of a userns-remap target is shifted into its
+// range; volumes are filled through a helper container, whose IDs the daemon shifts itself.
+func (e *DefaultBackupEngine) ownerMap(a mountArtifact, onHost bool) archive.IDMap {
+	ids := e.idMap
+	if a.Userns != nil {
+		ids.From = idRange(a.Userns)
+	}
+	if onHost && e.userns != nil {
+		ids.To = idRange(e.userns)
+	}
+	return ids
+}
+
+func idRange(r *docker.UsernsRange) *archive.IDRange {
+	return &archive.IDRange{UID: r.UID, GID: r.GID, Size: r.Size}
+}
@@ -0,0 +1,147 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/archive"
+)
+
+// checkContainerEntries parses container.json and metadata.json of a container backup and
+// returns an invalid result when either does not.
+func checkContainerEntries(ctx context.Context, th archiveReader, backupPath string) *ValidationResult {
+	b, err := th.ReadEntry(ctx, backupPath, "container.json")
+	if err == nil {
+		_, err = decodeContainerJSON(b)
+	}
+	if err != nil {
+		return &ValidationResult{Valid: false, Details: fmt.Sprintf("container.json: %v", err)}
+	}
+	var meta backupMetadata
+	b, err = th.ReadEntry(ctx, backupPath, "metadata.json")
+	if err == nil {
+		err = json.Unmarshal(b, &meta)
+	}
+	if err != nil {
+		return &ValidationResult{Valid: false, Details: fmt.Sprintf("metadata.json: %v", err)}
+	}
+	if b, err := th.ReadEntry(ctx, backupPath, mountsFileName); err == nil {
+		var mm mountMap
+		if err := json.Unmarshal(b, &mm); err != nil {
+			return &ValidationResult{Valid: false, Details: fmt.Sprintf("%s: %v", mountsFileName, err)}
+		}
+	}
+	return nil
+}
+
+// validateCompose checks a compose backup: the project fields of metadata.json, the format,
+// the compose-files directory and every service's container.tar.gz as a container backup.
+func (e *DefaultBackupEngine) validateCompose(ctx context.Context, backupPath string, deep bool) (*ValidationResult, error) {
+	hasComposeDir, hasComposeFile := false, false
+	archived := map[string]bool{}
+	err := e.archiveHandler.WalkArchive(ctx, backupPath, func(en archive.ArchiveEntry) error {
+		name := strings.TrimSuffix(strings.TrimPrefix(en.Path, "./"), "/")
+		switch {
+		case name == "compose-files":
+			hasComposeDir = true
+		case name == "compose-files/docker-compose.yml" || name == "compose-files/docker-compose.yaml":
+			hasComposeDir, hasComposeFile = true, true
+		}
+		parts := strings.Split(name, "/")
+		if len(parts) == 3 && parts[0] == "containers" && parts[2] == "container.tar.gz" {
+			archived[parts[1]] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, &errors.OperationError{Op: "list archive", Err: err}
+	}
+	th := e.archiveReader()
+	b, err := th.ReadEntry(ctx, backupPath, "metadata.json")
+	if err != nil {
+		return &ValidationResult{Valid: false, Details: "missing required entries: [metadata.json]"}, nil
+	}
+	var meta struct {
+		ProjectName string   `json:"projectName"`
+		Services    []string `json:"services"`
+		Offline     bool     `json:"offline"`
+		Group       bool     `json:"group"`
+	}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return &ValidationResult{Valid: false, Details: fmt.Sprintf("metadata.json: %v", err)}, nil
+	}
+	if (meta.ProjectName == "" && !meta.Group) || (len(meta.Services) == 0 && !meta.Offline) {
+		return &ValidationResult{Valid: false, Details: "metadata.json: missing projectName or services"}, nil
+	}
+
+	if !hasComposeDir {
+		return &ValidationResult{Valid: false, Details: "missing required entries: [compose-files]"}, nil
+	}
+	services := map[string]bool{}
+	for _, svc := range meta.Services {
+		services[svc] = true
+	}
+	var missing []string
+	for svc := range services {
+		if !archived[safeName(svc)] {
+			missing = append(missing, "containers/"+safeName(svc)+"/container.tar.gz")
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return &ValidationResult{Valid: false, Details: fmt.Sprintf("missing required entries: %v", missing)}, nil
+	}
+
+	f, err := readArchiveFormat(ctx, th, backupPath)
+	if err != nil {
+		return &ValidationResult{Valid: false, Details: err.Error()}, nil
+	}
+	note, err := checkFormat(f)
+	if err != nil {
+		return &ValidationResult{Valid: false, Details: err.Error()}, nil
+	}
+	if !hasComposeFile {
+		note = strings.TrimPrefix(note+"; no compose file, services start in the depends_on order recorded at backup", "; ")
+	}
+
+	tmpDir, err := e.filesystem.MkdirTemp(WorkDir(), "dockerbackup_validate_*")
+	if err != nil {
+		return nil, &errors.OperationError{Op: "create temp dir", Err: err}
+	}
+	defer func() { _ = e.filesystem.RemoveAll(tmpDir) }()
+	names := make([]string, 0, len(services))
+	for svc := range services {
+		names = append(names, svc)
+	}
+	sort.Strings(names)
+	for _, svc := range names {
+		nested := filepath.Join(tmpDir, safeName(svc)+".tar.gz")
+		if err := copyEntryToFile(ctx, e.filesystem, th, backupPath, "containers/"+safeName(svc)+"/container.tar.gz", nested); err != nil {
+			return &ValidationResult{Valid: false, Details: fmt.Sprintf("service %s: %v", svc, err)}, nil
+		}
+		res, err := e.validateContainer(ctx, nested, deep)
+		_ = e.filesystem.RemoveAll(nested)
+		if err != nil {
+			return &ValidationResult{Valid: false, Details: fmt.Sprintf("service %s: %v", svc, err)}, nil
+		}
+		if !res.Valid {
+			return &ValidationResult{Valid: false, Details: fmt.Sprintf("service %s: %s", svc, res.Details)}, nil
+		}
+	}
+	details := fmt.Sprintf("compose backup of project %s is valid (%d services)", meta.ProjectName, len(names))
+	if meta.Offline {
+		details = fmt.Sprintf("compose backup of project %s is valid (offline: volumes and networks only)", meta.ProjectName)
+	}
+	if meta.Group {
+		details = fmt.Sprintf("backup of containers %s is valid", strings.Join(names, ", "))
+	}
+	if note != "" {
+		details += "; " + note
+	}
+	return &ValidationResult{Valid: true, Details: details}, nil
+}
@@ -0,0 +1,154 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+// volumePluginsFile records the managed plugins providing the non-local volume drivers of a
+// backup, so restore can install them before creating the volumes.
+const volumePluginsFile = "volume_plugins.json"
+
+// isPluginDriver reports whether a volume driver is provided by a plugin (rexray, netapp,
+// portworx, ...) rather than the built-in local driver.
+func isPluginDriver(driver string) bool {
+	return driver != "" && driver != "local"
+}
+
+// pluginName normalizes plugin and driver names: docker reports managed plugins with their
+// tag ("rexray/ebs:latest") while volumes may name the driver without it.
+func pluginName(name string) string {
+	return strings.TrimSuffix(name, ":latest")
+}
+
+// needsDaemonStreaming reports whether a named volume's data cannot be read from the host
+// path docker reports: plugin-backed volumes, or a mountpoint this process cannot reach
+// (remote daemon, rootless or VM-backed Docker).
+func needsDaemonStreaming(fsys filesystem.Handler, m docker.Mount) bool {
+	if isPluginDriver(m.Driver) || m.Source == "" {
+		return true
+	}
+	_, err := fsys.Stat(m.Source)
+	return err != nil
+}
+
+// captureVolumePlugins writes volume_plugins.json into volumesDir for the plugin drivers used
+// by volCfgs. Drivers that are not managed plugins (legacy socket plugins) are recorded by
+// name only.
+func (e *DefaultBackupEngine) captureVolumePlugins(ctx context.Context, volCfgs []docker.VolumeConfig, volumesDir string) {
+	drivers := map[string]struct{}{}
+	for _, vc := range volCfgs {
+		if isPluginDriver(vc.Driver) {
+			drivers[pluginName(vc.Driver)] = struct{}{}
+		}
+	}
+	if len(drivers) == 0 {
+		return
+	}
+	installed, err := e.dockerClient.ListPlugins(ctx)
+	if err != nil {
+		e.warn(WarnVolumePlugins, "", "Could not list plugins for volume drivers: %v", err)
+	}
+	var out []docker.PluginInfo
+	for d := range drivers {
+		info := docker.PluginInfo{Name: d}
+		for _, p := range installed {
+			if pluginName(p.Name) == d {
+				info = p
+				break
+			}
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	if err := e.writeJSONFile(filepath.Join(volumesDir, volumePluginsFile), out); err != nil {
+		e.warn(WarnVolumePlugins, "", "Could not record volume plugins: %v", err)
+	}
+}
+
+// ensureVolumeDrivers checks that every plugin driver used by volCfgs is available before the
+// volumes are created. Missing plugins recorded in the backup are installed when install is
+// set; otherwise restore stops with the command that would install them.
+func (e *DefaultBackupEngine) ensureVolumeDrivers(ctx context.Context, tmpDir string, volCfgs []docker.VolumeConfig, install bool) error {
+	needed := map[string]struct{}{}
+	for _, vc := range volCfgs {
+		if isPluginDriver(vc.Driver) {
+			needed[pluginName(vc.Driver)] = struct{}{}
+		}
+	}
+	if len(needed) == 0 {
+		return nil
+	}
+	available, err := e.dockerClient.VolumeDrivers(ctx)
+	if err != nil {
+		return &errors.OperationError{Op: "list volume drivers", Err: err}
+	}
+	for _, d := range available {
+		delete(needed, pluginName(d))
+	}
+	if len(needed) == 0 {
+		return nil
+	}
+	var recorded []docker.PluginInfo
+	if b, err := e.filesystem.ReadFile(filepath.Join(tmpDir, "volumes", volumePluginsFile)); err == nil {
+		_ = json.Unmarshal(b, &recorded)
+	}
+	missing := make([]string, 0, len(needed))
+	for d := range needed {
+		missing = append(missing, d)
+	}
+	sort.Strings(missing)
+	var hints []string
+	for _, d := range missing {
+		p := docker.PluginInfo{Name: d}
+		for _, r := range recorded {
+			if pluginName(r.Name) == d {
+				p = r
+				break
+			}
+		}
+		ref := p.Reference
+		if ref == "" {
+			ref = p.Name
+		}
+		// plugins installed under an alias keep their driver name only with --alias
+		alias := ""
+		if pluginName(ref) != d {
+			alias = d
+		}
+		if !install {
+			hint := "docker plugin install " + ref
+			if alias != "" {
+				hint += " --alias " + alias
+			}
+			if len(p.Env) > 0 {
+				hint += " " + strings.Join(p.Env, " ")
+			}
+			hints = append(hints, hint)
+			continue
+		}
+		e.log.Infof("Installing volume plugin %s", ref)
+		if err := e.dockerClient.InstallPlugin(ctx, ref, alias, p.Env); err != nil {
+			return &errors.OperationError{Op: "install volume plugin " + d, Err: err}
+		}
+	}
+	if len(hints) > 0 {
+		return &errors.ValidationError{Msg: fmt.Sprintf("volume driver(s) %s not installed; run `%s` or restore with --install-plugins", strings.Join(missing, ", "), strings.Join(hints, "`, `"))}
+	}
+	return nil
+}
+
+func orLocal(driver string) string {
+	if driver == "" {
+		return "local"
+	}
+	return driver
+}
@@ -0,0 +1,89 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestBackup_StreamsPluginVolumesThroughDaemon(t *testing.T) {
+	ctx := context.Background()
+	arch := archive.NewTarArchiveHandler()
+	inspect := []map[string]any{{"Id": "123", "Name": "/unit_test", "Mounts": []map[string]any{
+		{"Type": "volume", "Name": "ebsvol", "Source": "/var/lib/docker/plugins/abc/propagated-mount/ebsvol", "Destination": "/data", "Driver": "rexray/ebs", "RW": true},
+	}}}
+	b, _ := json.Marshal(inspect)
+	dc := &fakeDockerClient{
+		inspectJSON: b,
+		volumes:     map[string]*docker.VolumeConfig{"ebsvol": {Name: "ebsvol", Driver: "rexray/ebs", Options: map[string]string{"size": "10"}}},
+		plugins:     []docker.PluginInfo{{Name: "rexray/ebs:latest", Reference: "docker.io/rexray/ebs:latest", Enabled: true, Env: []string{"EBS_REGION=eu-west-1"}}},
+	}
+	engine := NewDefaultBackupEngine(arch, dc, filesystem.NewHandler(), logger.New())
+	out := filepath.Join(t.TempDir(), "out.tar.gz")
+	if _, err := engine.Backup(ctx, BackupRequest{TargetType: TargetContainer, ContainerID: "unit_test",
+		Options: NewBackupOptionsBuilder().WithOutput(out).Build()}); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	if len(dc.streamedVolumes) != 1 || dc.streamedVolumes[0] != "ebsvol" {
+		t.Fatalf("expected ebsvol to be archived through the daemon, got %v", dc.streamedVolumes)
+	}
+	var plugins []docker.PluginInfo
+	pb, err := arch.ReadEntry(ctx, out, "volumes/"+volumePluginsFile)
+	if err != nil || json.Unmarshal(pb, &plugins) != nil || len(plugins) != 1 || plugins[0].Reference != "docker.io/rexray/ebs:latest" {
+		t.Fatalf("volume plugin not recorded: %s, %v", pb, err)
+	}
+}
+
+func TestRestore_EnsuresVolumePlugins(t *testing.T) {
+	t.Setenv("DOCKERBACKUP_JOURNAL_DIR", t.TempDir())
+	t.Setenv("DOCKERBACKUP_LOCK_DIR", t.TempDir())
+	ctx := context.Background()
+	arch := archive.NewTarArchiveHandler()
+
+	work := t.TempDir()
+	cj := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{ID: "123", Name: "/unit_test"},
+		Config:            &container.Config{},
+		Mounts:            []types.MountPoint{{Type: "volume", Name: "ebsvol", Destination: "/data", RW: true, Driver: "rexray/ebs"}},
+	}
+	b, _ := json.Marshal(cj)
+	_ = os.WriteFile(filepath.Join(work, "container.json"), b, 0o644)
+	_ = os.WriteFile(filepath.Join(work, "metadata.json"), []byte(`{"id":"abc123"}`), 0o644)
+	_ = os.WriteFile(filepath.Join(work, "filesystem.tar"), []byte("tar"), 0o644)
+	_ = os.MkdirAll(filepath.Join(work, "volumes"), 0o755)
+	_ = os.WriteFile(filepath.Join(work, "volumes", "volume_configs.json"), []byte(`[{"Name":"ebsvol","Driver":"rexray/ebs"}]`), 0o644)
+	_ = os.WriteFile(filepath.Join(work, "volumes", volumePluginsFile), []byte(`[{"Name":"rexray/ebs:latest","Reference":"rexray/ebs:latest","Env":["EBS_REGION=eu-west-1"]}]`), 0o644)
+	backupFile := filepath.Join(t.TempDir(), "backup.tar.gz")
+	if err := arch.CreateArchive(ctx, []archive.ArchiveSource{{Path: work, DestPath: "."}}, backupFile); err != nil {
+		t.Fatalf("create archive: %v", err)
+	}
+
+	fd := &fakeDockerClientRestore{}
+	engine := NewDefaultBackupEngine(arch, fd, filesystem.NewHandler(), logger.New())
+	_, err := engine.Restore(ctx, RestoreRequest{BackupPath: backupFile})
+	if err == nil || !strings.Contains(err.Error(), "docker plugin install rexray/ebs:latest EBS_REGION=eu-west-1") {
+		t.Fatalf("expected a missing plugin error with the install command, got %v", err)
+	}
+	if fd.createdContainer != "" {
+		t.Fatalf("no container should be created without the volume driver")
+	}
+
+	fd = &fakeDockerClientRestore{}
+	engine = NewDefaultBackupEngine(arch, fd, filesystem.NewHandler(), logger.New())
+	if _, err := engine.Restore(ctx, RestoreRequest{BackupPath: backupFile, Options: RestoreOptions{InstallPlugins: true}}); err != nil {
+		t.Fatalf("restore with --install-plugins: %v", err)
+	}
+	if len(fd.installedPlugins) != 1 || fd.installedPlugins[0] != "rexray/ebs:latest" {
+		t.Fatalf("expected the recorded plugin to be installed, got %v", fd.installedPlugins)
+	}
+}
@@ -0,0 +1,91 @@
+package backup
+
+import "fmt"
+
+// Warning is something a backup or restore could not do as the source had it, without
+// failing: data left out, a setting the target daemon dropped. Code identifies the kind for
+// tooling; Subject names what it concerns (a volume, a port, a profile).
+type Warning struct {
+	Code    string `json:"code"`
+	Subject string `json:"subject,omitempty"`
+	// Service is the compose service the warning came from
+	Service string `json:"service,omitempty"`
+	Message string `json:"message"`
+}
+
+func (w Warning) String() string {
+	if w.Service != "" {
+		return w.Service + ": " + w.Message
+	}
+	return w.Message
+}
+
+// Warning codes.
+const (
+	WarnMountExcluded      = "mount-excluded"
+	WarnRemoteVolumeData   = "remote-volume-data"
+	WarnPatternUnmatched   = "pattern-unmatched"
+	WarnImageNotSaved      = "image-not-saved"
+	WarnImageFormat        = "image-format"
+	WarnProvenance         = "provenance"
+	WarnComposeFile        = "compose-file"
+	WarnBuildContext       = "build-context"
+	WarnVolumePlugins      = "volume-plugins"
+	WarnChecksum           = "checksum"
+	WarnFormat             = "format"
+	WarnHostIPDropped      = "host-ip-dropped"
+	WarnLogDriver          = "log-driver"
+	WarnHostConfig         = "hostconfig-not-applied"
+	WarnSecurityProfile    = "security-profile"
+	WarnImageLoad          = "image-load"
+	WarnVolumeData         = "volume-data"
+	WarnContainerUnhealthy = "container-unhealthy"
+	WarnServiceRestore     = "service-restore-failed"
+	WarnOwnership          = "ownership-not-mapped"
+	WarnStaticIP           = "static-ip-dropped"
+	WarnNetworkParent      = "network-parent"
+	WarnConflict           = "conflict"
+	WarnNotIsolated        = "not-isolated"
+	WarnVolumeContent      = "volume-content"
+	WarnSparseFiles        = "sparse-files"
+	WarnNestedMount        = "nested-mount"
+	WarnSpecialMount       = "special-mount"
+)
+
+// warningList collects the warnings of the backup or restore in progress.
+type warningList struct {
+	items []Warning
+}
+
+func (l *warningList) list() []Warning {
+	if l == nil {
+		return nil
+	}
+	return l.items
+}
+
+func (l *warningList) add(w Warning) {
+	if l != nil {
+		l.items = append(l.items, w)
+	}
+}
+
+// warn logs a warning as before and records it for the result of the backup or restore in
+// progress.
+func (e *DefaultBackupEngine) warn(code, subject, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	e.log.Infof("%s", msg)
+	e.warnings.add(Warning{Code: code, Subject: subject, Message: msg})
+}
+
+// collectWarnings gives a backup or restore its own warning list for the duration of op and
+// returns what was recorded. Nested runs (compose services) get their own list; the project
+// adds their warnings with the service set.
+func (e *DefaultBackupEngine) collectWarnings(op func()) []Warning {
+	prev := e.warnings
+	l := &warningList{}
+	e.warnings = l
+	defer func() { e.warnings = prev }()
+	op()
+	return l.items
+}
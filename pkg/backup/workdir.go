@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+	"github.com/brian033/dockerbackup/pkg/storage"
+)
+
+// WorkDirEnv names the directory in which backups and restores create their temporary work
+// directories: the exported filesystem, saved image and volume archives of a backup, and the
+// extracted archive of a restore. /tmp is often a small tmpfs; point it at a scratch disk.
+const WorkDirEnv = "DOCKERBACKUP_WORK_DIR"
+
+// WorkDir returns $DOCKERBACKUP_WORK_DIR, or "" for the system temporary directory as
+// os.MkdirTemp takes it.
+func WorkDir() string {
+	return strings.TrimSpace(os.Getenv(WorkDirEnv))
+}
+
+// checkWorkSpace fails when the filesystem holding dir has less than need bytes free for what.
+// Where the free space cannot be told the work goes ahead.
+func checkWorkSpace(dir string, need int64, what string) error {
+	free, err := filesystem.FreeSpace(dir)
+	if err != nil || need <= free {
+		return nil
+	}
+	return &errors.ValidationError{Field: "WorkDir", Msg: fmt.Sprintf("%s has %s free, %s needs at least %s there; free some up or move the work elsewhere with --work-dir (or $%s)",
+		dir, storage.FormatSize(free), what, storage.FormatSize(need), WorkDirEnv)}
+}
+
+// checkBackupSpace checks before a container backup starts that its work directory can take
+// the exported root filesystem and the saved image. The volume archives written there as well
+// are compressed and not counted.
+func (e *DefaultBackupEngine) checkBackupSpace(ctx context.Context, workDir string, inspectJSON []byte, opts BackupOptions) error {
+	cj, err := docker.ParseContainerJSON(inspectJSON)
+	if err != nil || cj.ContainerJSONBase == nil {
+		return nil
+	}
+	need, _ := e.dockerClient.ContainerSize(ctx, cj.ID)
+	if cj.Image != "" && !opts.projectImages {
+		if img, err := e.dockerClient.InspectImage(ctx, cj.Image); err == nil {
+			need += img.Size
+		}
+	}
+	return checkWorkSpace(workDir, need, "the backup of "+strings.TrimPrefix(cj.Name, "/"))
+}
+
+// checkRestoreSpace checks before a backup is extracted into tmpDir that it can take at least
+// the archive; extracted, it is larger by however well it compressed.
+func checkRestoreSpace(fsys filesystem.Handler, tmpDir, backupPath string) error {
+	return checkWorkSpace(tmpDir, archiveSize(fsys, backupPath), "extracting "+filepath.Base(backupPath))
+}
@@ -0,0 +1,34 @@
+package backup
+
+import (
+	stdErrors "errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/filesystem"
+)
+
+func TestCheckWorkSpace(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := filesystem.FreeSpace(dir); err != nil {
+		t.Skipf("free space unknown here: %v", err)
+	}
+	t.Setenv(WorkDirEnv, " "+dir+" ")
+	if got := WorkDir(); got != dir {
+		t.Fatalf("WorkDir() = %q, want %q", got, dir)
+	}
+	if err := checkWorkSpace(dir, 1, "the backup of web"); err != nil {
+		t.Fatalf("checkWorkSpace(1 byte): %v", err)
+	}
+	err := checkWorkSpace(dir, math.MaxInt64, "the backup of web")
+	var ve *errors.ValidationError
+	if !stdErrors.As(err, &ve) || ve.Field != "WorkDir" || !strings.Contains(ve.Msg, "the backup of web needs at least") {
+		t.Fatalf("checkWorkSpace(too much) = %v", err)
+	}
+	// free space that cannot be told does not stop the work
+	if err := checkWorkSpace(dir+"/missing", math.MaxInt64, "the backup of web"); err != nil {
+		t.Fatalf("checkWorkSpace(missing dir): %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package compose
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+)
+
+// ParseEnvFile reads the KEY=VALUE lines of a .env file as docker compose does: blank lines
+// and # comments are skipped, an export prefix is allowed, and single- or double-quoted
+// values are unquoted (a # starts a comment after an unquoted value only).
+func ParseEnvFile(data []byte) map[string]string {
+	env := map[string]string{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, val, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		switch {
+		case len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && strings.IndexByte(val[1:], val[0]) >= 0:
+			end := strings.IndexByte(val[1:], val[0]) + 1
+			if val[0] == '"' {
+				val = strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(val[1:end])
+			} else {
+				val = val[1:end]
+			}
+		default:
+			if i := strings.Index(val, " #"); i >= 0 {
+				val = strings.TrimSpace(val[:i])
+			}
+		}
+		env[key] = val
+	}
+	return env
+}
+
+// Environ returns the variables compose substitutes in a project: those of envFile (the
+// project's .env, nil when there is none), overridden by the process environment.
+func Environ(envFile []byte) map[string]string {
+	env := ParseEnvFile(envFile)
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// Interpolate substitutes $VAR, ${VAR} and the ${VAR:-default}, ${VAR-default},
+// ${VAR:+alt}, ${VAR+alt}, ${VAR:?err} and ${VAR?err} forms in s from env, as compose does;
+// $$ is a literal $. Unset variables without a default become empty.
+func Interpolate(s string, env map[string]string) string {
+	if !strings.Contains(s, "$") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch next := s[i+1]; {
+		case next == '$':
+			b.WriteByte('$')
+			i++
+		case next == '{':
+			end := closingBrace(s, i+2)
+			if end < 0 {
+				b.WriteString(s[i:])
+				return b.String()
+			}
+			b.WriteString(expand(s[i+2:end], env))
+			i = end
+		case isNameByte(next, true):
+			j := i + 1
+			for j < len(s) && isNameByte(s[j], false) {
+				j++
+			}
+			b.WriteString(env[s[i+1:j]])
+			i = j - 1
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String()
+}
+
+// closingBrace returns the index of the } closing the ${ whose body starts at from, allowing
+// nested ${...} in defaults; -1 when there is none.
+func closingBrace(s string, from int) int {
+	depth := 1
+	for i := from; i < len(s); i++ {
+		switch {
+		case s[i] == '{' && i > 0 && s[i-1] == '$':
+			depth++
+		case s[i] == '}':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// expand resolves the body of a ${...} expression.
+func expand(expr string, env map[string]string) string {
+	n := 0
+	for n < len(expr) && isNameByte(expr[n], n == 0) {
+		n++
+	}
+	name, rest := expr[:n], expr[n:]
+	val, set := env[name]
+	for _, op := range []string{":-", ":+", ":?", "-", "+", "?"} {
+		if !strings.HasPrefix(rest, op) {
+			continue
+		}
+		arg := Interpolate(rest[len(op):], env)
+		// the : forms treat an empty value as unset
+		present := set && (val != "" || !strings.HasPrefix(op, ":"))
+		switch op[len(op)-1] {
+		case '-':
+			if !present {
+				return arg
+			}
+		case '+':
+			if present {
+				return arg
+			}
+			return ""
+		}
+		// ?: compose fails on a missing variable; here it stays empty like any unset one
+		return val
+	}
+	return val
+}
+
+func isNameByte(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
@@ -0,0 +1,90 @@
+package compose
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	env := ParseEnvFile([]byte(`
+# settings
+APP=shop
+export TIER = prod
+QUOTED="a # b"
+SINGLE='x$y'
+TRAILING=value # comment
+EMPTY=
+broken line
+`))
+	want := map[string]string{"APP": "shop", "TIER": "prod", "QUOTED": "a # b", "SINGLE": "x$y", "TRAILING": "value", "EMPTY": ""}
+	if !reflect.DeepEqual(env, want) {
+		t.Fatalf("ParseEnvFile = %v", env)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	env := map[string]string{"APP": "shop", "EMPTY": ""}
+	for in, want := range map[string]string{
+		"${APP}_data":          "shop_data",
+		"$APP-net":             "shop-net",
+		"${MISSING:-default}":  "default",
+		"${EMPTY:-default}":    "default",
+		"${EMPTY-default}":     "",
+		"${APP:+set}":          "set",
+		"${MISSING+set}":       "",
+		"${MISSING:-${APP}_x}": "shop_x",
+		"${APP:?required}":     "shop",
+		"cost $$5":             "cost $5",
+		"plain":                "plain",
+		"${UNCLOSED":           "${UNCLOSED",
+	} {
+		if got := Interpolate(in, env); got != want {
+			t.Errorf("Interpolate(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestPinNames(t *testing.T) {
+	data := []byte(`# project
+services:
+  app:
+    image: x
+volumes:
+  data:
+    name: ${APP}_data # from .env
+  cache:
+  legacy:
+    external:
+      name: ${OLD}
+networks:
+  front:
+    name: front
+`)
+	env := map[string]string{"APP": "shop", "OLD": "old-volume"}
+	names := func(rs []Resource) map[string]string {
+		out := map[string]string{}
+		for _, r := range rs {
+			out[r.Key] = r.Name
+		}
+		return out
+	}
+	if got := names(Volumes(data, "shop", env)); got["data"] != "shop_data" || got["legacy"] != "old-volume" {
+		t.Fatalf("volumes = %v", got)
+	}
+
+	out, changed, err := PinNames(data, map[string]string{"data": "shop2_data", "cache": "shop2_cache", "legacy": "restored-old", "missing": "x"}, map[string]string{"front": "front"})
+	if err != nil || !changed {
+		t.Fatalf("PinNames: changed=%v, %v", changed, err)
+	}
+	got := names(Volumes(out, "other", nil))
+	if want := map[string]string{"data": "shop2_data", "cache": "shop2_cache", "legacy": "restored-old"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("pinned volumes = %v\n%s", got, out)
+	}
+	if !strings.Contains(string(out), "# project") {
+		t.Fatalf("comments dropped:\n%s", out)
+	}
+	if _, changed, _ := PinNames(out, map[string]string{"data": "shop2_data"}, nil); changed {
+		t.Fatal("pinning the same name again changed the file")
+	}
+}
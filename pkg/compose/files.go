@@ -0,0 +1,110 @@
+package compose
+
+import (
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envFiles accepts env_file as a single path, a list of paths or a list of
+// {path, required} entries.
+type envFiles []string
+
+func (e *envFiles) UnmarshalYAML(value *yaml.Node) error {
+	var out []string
+	switch value.Kind {
+	case yaml.ScalarNode:
+		out = append(out, value.Value)
+	case yaml.SequenceNode:
+		for _, item := range value.Content {
+			switch item.Kind {
+			case yaml.ScalarNode:
+				out = append(out, item.Value)
+			case yaml.MappingNode:
+				var long struct {
+					Path string `yaml:"path"`
+				}
+				if err := item.Decode(&long); err != nil {
+					return err
+				}
+				if long.Path != "" {
+					out = append(out, long.Path)
+				}
+			}
+		}
+	}
+	*e = out
+	return nil
+}
+
+// EnvFiles returns the distinct env_file paths referenced by any service, as written in the
+// compose file (relative paths are relative to the project directory).
+func EnvFiles(data []byte) []string {
+	var cf composeFile
+	if err := yaml.Unmarshal(data, &cf); err != nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var out []string
+	for _, svc := range cf.Services {
+		for _, p := range svc.EnvFile {
+			if p == "" || seen[p] {
+				continue
+			}
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// build accepts build as a context path or as a mapping with context/dockerfile.
+type build struct {
+	Context    string `yaml:"context"`
+	Dockerfile string `yaml:"dockerfile"`
+}
+
+func (b *build) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		b.Context = value.Value
+		return nil
+	}
+	type plain build
+	var p plain
+	if err := value.Decode(&p); err != nil {
+		return err
+	}
+	*b = build(p)
+	if b.Context == "" {
+		b.Context = "."
+	}
+	return nil
+}
+
+// BuildContext is a service's local build context as written in the compose file.
+type BuildContext struct {
+	Service    string
+	Context    string
+	Dockerfile string
+}
+
+// BuildContexts returns the local build contexts of services defined with build:. Remote
+// contexts (git repositories, URLs) are omitted since they can be fetched again.
+func BuildContexts(data []byte) []BuildContext {
+	var cf composeFile
+	if err := yaml.Unmarshal(data, &cf); err != nil {
+		return nil
+	}
+	var out []BuildContext
+	for name, svc := range cf.Services {
+		ctx := svc.Build.Context
+		if ctx == "" || strings.Contains(ctx, "://") || strings.HasPrefix(ctx, "git@") {
+			continue
+		}
+		out = append(out, BuildContext{Service: name, Context: ctx, Dockerfile: svc.Build.Dockerfile})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Service < out[j].Service })
+	return out
+}
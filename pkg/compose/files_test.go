@@ -0,0 +1,99 @@
+package compose
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvFiles(t *testing.T) {
+	data := []byte(`
+services:
+  a:
+    image: x
+    env_file: common.env
+  b:
+    image: x
+    env_file:
+      - common.env
+      - ./config/b.env
+  c:
+    image: x
+    env_file:
+      - path: ./config/c.env
+        required: false
+`)
+	got := strings.Join(EnvFiles(data), ",")
+	if got != "./config/b.env,./config/c.env,common.env" {
+		t.Fatalf("unexpected env files: %s", got)
+	}
+}
+
+func TestBuildContexts(t *testing.T) {
+	data := []byte(`
+services:
+  api:
+    build: ./api
+  web:
+    build:
+      context: ./web
+      dockerfile: Dockerfile.prod
+  worker:
+    build:
+      dockerfile: Dockerfile.worker
+  remote:
+    build: https://github.com/example/app.git
+  db:
+    image: postgres
+`)
+	got := BuildContexts(data)
+	if len(got) != 3 {
+		t.Fatalf("unexpected contexts: %+v", got)
+	}
+	if got[0].Service != "api" || got[0].Context != "./api" {
+		t.Fatalf("unexpected api context: %+v", got[0])
+	}
+	if got[1].Context != "./web" || got[1].Dockerfile != "Dockerfile.prod" {
+		t.Fatalf("unexpected web context: %+v", got[1])
+	}
+	if got[2].Service != "worker" || got[2].Context != "." {
+		t.Fatalf("unexpected worker context: %+v", got[2])
+	}
+}
+
+func TestNetworksAndVolumes(t *testing.T) {
+	data := []byte(`
+services:
+  app:
+    image: x
+  db:
+    image: x
+    networks: [backend]
+volumes:
+  data:
+  cache:
+    name: shared-cache
+  legacy:
+    external:
+      name: old-volume
+  ext:
+    external: true
+networks:
+  backend:
+`)
+	names := func(rs []Resource) string {
+		var out []string
+		for _, r := range rs {
+			out = append(out, r.Key+"="+r.Name)
+		}
+		return strings.Join(out, ",")
+	}
+	if got := names(Volumes(data, "shop", nil)); got != "cache=shared-cache,data=shop_data,ext=ext,legacy=old-volume" {
+		t.Fatalf("unexpected volumes: %s", got)
+	}
+	if got := names(Networks(data, "shop", nil)); got != "backend=shop_backend,default=shop_default" {
+		t.Fatalf("unexpected networks: %s", got)
+	}
+	if got := names(Networks([]byte("services:\n  app:\n    network_mode: host\n"), "shop", nil)); got != "" {
+		t.Fatalf("expected no default network, got %s", got)
+	}
+}
@@ -2,17 +2,65 @@ package compose
 
 import (
 	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 type composeFile struct {
-	Name     string             `yaml:"name"`
-	Services map[string]service `yaml:"services"`
+	Name     string                  `yaml:"name"`
+	Services map[string]service      `yaml:"services"`
+	Volumes  map[string]*resourceDef `yaml:"volumes"`
+	Networks map[string]*resourceDef `yaml:"networks"`
 }
 
 type service struct {
-	DependsOn map[string]any `yaml:"depends_on"`
+	DependsOn   dependsOn `yaml:"depends_on"`
+	EnvFile     envFiles  `yaml:"env_file"`
+	Build       build     `yaml:"build"`
+	Networks    yaml.Node `yaml:"networks"`
+	NetworkMode string    `yaml:"network_mode"`
+}
+
+// Dependency conditions as defined by the compose specification.
+const (
+	ConditionStarted               = "service_started"
+	ConditionHealthy               = "service_healthy"
+	ConditionCompletedSuccessfully = "service_completed_successfully"
+)
+
+// dependsOn accepts both the short list form and the long map form of depends_on and
+// records the condition per dependency (service_started when not specified).
+type dependsOn map[string]string
+
+func (d *dependsOn) UnmarshalYAML(value *yaml.Node) error {
+	out := dependsOn{}
+	switch value.Kind {
+	case yaml.SequenceNode:
+		var names []string
+		if err := value.Decode(&names); err != nil {
+			return err
+		}
+		for _, n := range names {
+			out[n] = ConditionStarted
+		}
+	case yaml.MappingNode:
+		var long map[string]struct {
+			Condition string `yaml:"condition"`
+		}
+		if err := value.Decode(&long); err != nil {
+			return err
+		}
+		for n, v := range long {
+			cond := v.Condition
+			if cond == "" {
+				cond = ConditionStarted
+			}
+			out[n] = cond
+		}
+	}
+	*d = out
+	return nil
 }
 
 func OrderFromComposeYAML(data []byte) (order []string, names []string) {
@@ -20,17 +68,33 @@ func OrderFromComposeYAML(data []byte) (order []string, names []string) {
 	if err := yaml.Unmarshal(data, &cf); err != nil {
 		return nil, nil
 	}
-	for n := range cf.Services {
+	deps := map[string]map[string]string{}
+	for n, svc := range cf.Services {
 		names = append(names, n)
+		deps[n] = svc.DependsOn
+	}
+	return OrderFromDependencies(names, deps), names
+}
+
+// OrderFromDependencies returns names in dependency order (dependencies first) using the
+// per-service dependency map as returned by DependencyConditions. Dependencies on unknown
+// services are ignored; on cycles the order falls back to alphabetical.
+func OrderFromDependencies(names []string, deps map[string]map[string]string) (order []string) {
+	known := map[string]bool{}
+	for _, n := range names {
+		known[n] = true
 	}
 	// Build adjacency and indegree
 	adj := map[string][]string{}
 	indeg := map[string]int{}
-	for n := range cf.Services {
+	for _, n := range names {
 		indeg[n] = 0
 	}
-	for n, svc := range cf.Services {
-		for dep := range svc.DependsOn {
+	for _, n := range names {
+		for dep := range deps[n] {
+			if !known[dep] {
+				continue
+			}
 			adj[dep] = append(adj[dep], n)
 			indeg[n]++
 		}
@@ -61,7 +125,28 @@ func OrderFromComposeYAML(data []byte) (order []string, names []string) {
 		copy(order, names)
 		sort.Strings(order)
 	}
-	return order, names
+	return order
+}
+
+// DependsOnLabel is the container label compose v2 uses to record a service's depends_on.
+const DependsOnLabel = "com.docker.compose.depends_on"
+
+// ParseDependsOnLabel parses the com.docker.compose.depends_on label value
+// ("db:service_healthy:false,cache:service_started:false") into dependency conditions.
+func ParseDependsOnLabel(value string) map[string]string {
+	out := map[string]string{}
+	for _, item := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if parts[0] == "" {
+			continue
+		}
+		cond := ConditionStarted
+		if len(parts) > 1 && parts[1] != "" {
+			cond = parts[1]
+		}
+		out[parts[0]] = cond
+	}
+	return out
 }
 
 func ParseProjectName(data []byte) string {
@@ -71,3 +156,24 @@ func ParseProjectName(data []byte) string {
 	}
 	return cf.Name
 }
+
+// DependencyConditions returns, per service, the services it depends on and the condition
+// that has to be met before it may start.
+func DependencyConditions(data []byte) map[string]map[string]string {
+	var cf composeFile
+	if err := yaml.Unmarshal(data, &cf); err != nil {
+		return nil
+	}
+	out := map[string]map[string]string{}
+	for n, svc := range cf.Services {
+		if len(svc.DependsOn) == 0 {
+			continue
+		}
+		deps := map[string]string{}
+		for dep, cond := range svc.DependsOn {
+			deps[dep] = cond
+		}
+		out[n] = deps
+	}
+	return out
+}
@@ -0,0 +1,43 @@
+package compose
+
+import "testing"
+
+func TestDependencyConditions_ShortAndLongForm(t *testing.T) {
+	data := []byte(`
+services:
+  db:
+    image: postgres
+  migrate:
+    image: app
+    depends_on: [db]
+  web:
+    image: app
+    depends_on:
+      db:
+        condition: service_healthy
+      migrate:
+        condition: service_completed_successfully
+`)
+	deps := DependencyConditions(data)
+	if deps["migrate"]["db"] != ConditionStarted {
+		t.Fatalf("short form should default to service_started: %v", deps["migrate"])
+	}
+	if deps["web"]["db"] != ConditionHealthy || deps["web"]["migrate"] != ConditionCompletedSuccessfully {
+		t.Fatalf("unexpected long form conditions: %v", deps["web"])
+	}
+	order, _ := OrderFromComposeYAML(data)
+	if len(order) != 3 || order[0] != "db" || order[2] != "web" {
+		t.Fatalf("unexpected order: %v", order)
+	}
+}
+
+func TestParseDependsOnLabel(t *testing.T) {
+	deps := ParseDependsOnLabel("db:service_healthy:false,cache:service_started:true,legacy")
+	if deps["db"] != ConditionHealthy || deps["cache"] != ConditionStarted || deps["legacy"] != ConditionStarted {
+		t.Fatalf("unexpected deps: %v", deps)
+	}
+	order := OrderFromDependencies([]string{"web", "db", "cache"}, map[string]map[string]string{"web": deps})
+	if order[len(order)-1] != "web" {
+		t.Fatalf("web should start last: %v", order)
+	}
+}
@@ -0,0 +1,174 @@
+package compose
+
+import (
+	"bytes"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Resource is a top-level volume or network of a compose file with the name the engine
+// knows it by.
+type Resource struct {
+	Key      string
+	Name     string
+	External bool
+}
+
+// resourceDef is a top-level volume or network definition; only its naming matters here.
+type resourceDef struct {
+	Name     string   `yaml:"name"`
+	External external `yaml:"external"`
+}
+
+// external accepts external: true as well as the legacy external: {name: x} form.
+type external struct {
+	Set  bool
+	Name string
+}
+
+func (x *external) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.MappingNode {
+		var legacy struct {
+			Name string `yaml:"name"`
+		}
+		if err := value.Decode(&legacy); err != nil {
+			return err
+		}
+		*x = external{Set: true, Name: legacy.Name}
+		return nil
+	}
+	return value.Decode(&x.Set)
+}
+
+// Volumes returns the top-level volumes of a compose file for the project: name: when given,
+// with the variables in it substituted from env (see Environ), the key for external volumes,
+// else <project>_<key>.
+func Volumes(data []byte, project string, env map[string]string) []Resource {
+	var cf composeFile
+	if err := yaml.Unmarshal(data, &cf); err != nil {
+		return nil
+	}
+	return resources(cf.Volumes, project, env)
+}
+
+// Networks returns the top-level networks of a compose file for the project, named as
+// Volumes names volumes, plus the default network when a service joins it: one that lists
+// no networks and has no network_mode.
+func Networks(data []byte, project string, env map[string]string) []Resource {
+	var cf composeFile
+	if err := yaml.Unmarshal(data, &cf); err != nil {
+		return nil
+	}
+	defs := cf.Networks
+	if _, ok := defs["default"]; !ok {
+		for _, svc := range cf.Services {
+			if svc.NetworkMode == "" && len(svc.Networks.Content) == 0 {
+				if defs == nil {
+					defs = map[string]*resourceDef{}
+				}
+				defs["default"] = nil
+				break
+			}
+		}
+	}
+	return resources(defs, project, env)
+}
+
+func resources(defs map[string]*resourceDef, project string, env map[string]string) []Resource {
+	out := make([]Resource, 0, len(defs))
+	for key, def := range defs {
+		r := Resource{Key: key, Name: project + "_" + key}
+		if def != nil {
+			r.External = def.External.Set
+			switch {
+			case def.External.Name != "":
+				r.Name = Interpolate(def.External.Name, env)
+			case def.Name != "":
+				r.Name = Interpolate(def.Name, env)
+			case r.External:
+				r.Name = key
+			}
+		}
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// PinNames sets the name of the top-level volumes and networks of a compose file given by key
+// in volumes and networks, so compose uses those resources whatever the variables in their
+// declared names resolve to. Keys the file does not declare are left out; changed reports
+// whether anything was set. Comments are kept, the layout is normalized.
+func PinNames(data []byte, volumes, networks map[string]string) (out []byte, changed bool, err error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, false, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return data, false, nil
+	}
+	top := doc.Content[0]
+	for section, names := range map[string]map[string]string{"volumes": volumes, "networks": networks} {
+		defs := mappingValue(top, section)
+		if defs == nil || defs.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 0; i+1 < len(defs.Content); i += 2 {
+			name, ok := names[defs.Content[i].Value]
+			if !ok {
+				continue
+			}
+			def := defs.Content[i+1]
+			if def.Kind != yaml.MappingNode {
+				// key: with no definition
+				*def = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			}
+			// the legacy external: {name: x} form names the resource itself
+			if ext := mappingValue(def, "external"); ext != nil && ext.Kind == yaml.MappingNode {
+				def = ext
+			}
+			if setScalar(def, "name", name) {
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return data, false, nil
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, false, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
+
+// mappingValue returns the value of key in the mapping node m, nil when absent.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setScalar sets key to the string value in the mapping node m and reports whether it changed.
+func setScalar(m *yaml.Node, key, value string) bool {
+	if v := mappingValue(m, key); v != nil {
+		if v.Kind == yaml.ScalarNode && v.Value == value {
+			return false
+		}
+		*v = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+		return true
+	}
+	m.Content = append(m.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+	return true
+}
@@ -0,0 +1,321 @@
+package convert
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"gopkg.in/yaml.v3"
+)
+
+// ComposeFile is the subset of the compose specification produced by ToCompose.
+type ComposeFile struct {
+	Services map[string]ComposeService  `yaml:"services"`
+	Volumes  map[string]ComposeResource `yaml:"volumes,omitempty"`
+	Networks map[string]ComposeResource `yaml:"networks,omitempty"`
+}
+
+type ComposeService struct {
+	Image         string                            `yaml:"image"`
+	ContainerName string                            `yaml:"container_name,omitempty"`
+	Hostname      string                            `yaml:"hostname,omitempty"`
+	User          string                            `yaml:"user,omitempty"`
+	WorkingDir    string                            `yaml:"working_dir,omitempty"`
+	Entrypoint    []string                          `yaml:"entrypoint,omitempty"`
+	Command       []string                          `yaml:"command,omitempty"`
+	Environment   []string                          `yaml:"environment,omitempty"`
+	Ports         []string                          `yaml:"ports,omitempty"`
+	Volumes       []string                          `yaml:"volumes,omitempty"`
+	NetworkMode   string                            `yaml:"network_mode,omitempty"`
+	Networks      map[string]*ComposeServiceNetwork `yaml:"networks,omitempty"`
+	Restart       string                            `yaml:"restart,omitempty"`
+	Healthcheck   *ComposeHealthcheck               `yaml:"healthcheck,omitempty"`
+	Labels        map[string]string                 `yaml:"labels,omitempty"`
+	Privileged    bool                              `yaml:"privileged,omitempty"`
+	CapAdd        []string                          `yaml:"cap_add,omitempty"`
+	CapDrop       []string                          `yaml:"cap_drop,omitempty"`
+	Devices       []string                          `yaml:"devices,omitempty"`
+	SecurityOpt   []string                          `yaml:"security_opt,omitempty"`
+	ExtraHosts    []string                          `yaml:"extra_hosts,omitempty"`
+	DNS           []string                          `yaml:"dns,omitempty"`
+	Logging       *ComposeLogging                   `yaml:"logging,omitempty"`
+	Ulimits       map[string]map[string]int64       `yaml:"ulimits,omitempty"`
+}
+
+type ComposeServiceNetwork struct {
+	Aliases     []string `yaml:"aliases,omitempty"`
+	IPv4Address string   `yaml:"ipv4_address,omitempty"`
+}
+
+type ComposeHealthcheck struct {
+	Test        []string `yaml:"test"`
+	Interval    string   `yaml:"interval,omitempty"`
+	Timeout     string   `yaml:"timeout,omitempty"`
+	StartPeriod string   `yaml:"start_period,omitempty"`
+	Retries     int      `yaml:"retries,omitempty"`
+}
+
+type ComposeLogging struct {
+	Driver  string            `yaml:"driver"`
+	Options map[string]string `yaml:"options,omitempty"`
+}
+
+// ComposeResource declares a volume or network by its exact name so compose reuses the
+// restored resource instead of creating a project-prefixed one.
+type ComposeResource struct {
+	Name     string `yaml:"name"`
+	External bool   `yaml:"external,omitempty"`
+}
+
+// ServiceName derives a compose service name from a container name.
+func ServiceName(containerName string) string {
+	n := strings.TrimPrefix(containerName, "/")
+	b := strings.Builder{}
+	for _, r := range strings.ToLower(n) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	if b.Len() == 0 {
+		return "app"
+	}
+	return b.String()
+}
+
+// ToCompose converts a container inspect document into a single-service compose file.
+func ToCompose(cj types.ContainerJSON) (*ComposeFile, error) {
+	if cj.ContainerJSONBase == nil {
+		return nil, fmt.Errorf("container.json has no container data")
+	}
+	cfg := cj.Config
+	if cfg == nil {
+		cfg = &container.Config{}
+	}
+	hc := cj.HostConfig
+	if hc == nil {
+		hc = &container.HostConfig{}
+	}
+	name := strings.TrimPrefix(cj.Name, "/")
+	svc := ComposeService{
+		Image:         cfg.Image,
+		ContainerName: name,
+		User:          cfg.User,
+		WorkingDir:    cfg.WorkingDir,
+		Entrypoint:    cfg.Entrypoint,
+		Command:       cfg.Cmd,
+		Environment:   cfg.Env,
+		Privileged:    hc.Privileged,
+		CapAdd:        hc.CapAdd,
+		CapDrop:       hc.CapDrop,
+		SecurityOpt:   hc.SecurityOpt,
+		ExtraHosts:    hc.ExtraHosts,
+		DNS:           hc.DNS,
+	}
+	if svc.Image == "" {
+		svc.Image = cj.Image
+	}
+	if cfg.Hostname != "" && !strings.HasPrefix(cj.ID, cfg.Hostname) {
+		svc.Hostname = cfg.Hostname
+	}
+	if rp := string(hc.RestartPolicy.Name); rp != "" && rp != "no" {
+		svc.Restart = rp
+		if rp == "on-failure" && hc.RestartPolicy.MaximumRetryCount > 0 {
+			svc.Restart = fmt.Sprintf("on-failure:%d", hc.RestartPolicy.MaximumRetryCount)
+		}
+	}
+	svc.Ports = PortSpecs(hc)
+	for _, d := range hc.Devices {
+		spec := d.PathOnHost + ":" + d.PathInContainer
+		if d.CgroupPermissions != "" && d.CgroupPermissions != "rwm" {
+			spec += ":" + d.CgroupPermissions
+		}
+		svc.Devices = append(svc.Devices, spec)
+	}
+	if hc.LogConfig.Type != "" && hc.LogConfig.Type != "json-file" {
+		svc.Logging = &ComposeLogging{Driver: hc.LogConfig.Type, Options: hc.LogConfig.Config}
+	}
+	for _, u := range hc.Ulimits {
+		if u == nil {
+			continue
+		}
+		if svc.Ulimits == nil {
+			svc.Ulimits = map[string]map[string]int64{}
+		}
+		svc.Ulimits[u.Name] = map[string]int64{"soft": u.Soft, "hard": u.Hard}
+	}
+	if hcCfg := cfg.Healthcheck; hcCfg != nil && len(hcCfg.Test) > 0 && hcCfg.Test[0] != "NONE" {
+		svc.Healthcheck = &ComposeHealthcheck{Test: hcCfg.Test, Retries: hcCfg.Retries}
+		if hcCfg.Interval > 0 {
+			svc.Healthcheck.Interval = hcCfg.Interval.String()
+		}
+		if hcCfg.Timeout > 0 {
+			svc.Healthcheck.Timeout = hcCfg.Timeout.String()
+		}
+		if hcCfg.StartPeriod > 0 {
+			svc.Healthcheck.StartPeriod = hcCfg.StartPeriod.String()
+		}
+	}
+	for k, v := range cfg.Labels {
+		// compose and image labels are re-created by compose/the image itself
+		if strings.HasPrefix(k, "com.docker.compose.") || strings.HasPrefix(k, "org.opencontainers.") {
+			continue
+		}
+		if svc.Labels == nil {
+			svc.Labels = map[string]string{}
+		}
+		svc.Labels[k] = v
+	}
+
+	out := &ComposeFile{Services: map[string]ComposeService{}}
+	for _, m := range cj.Mounts {
+		mode := ""
+		if !m.RW {
+			mode = ":ro"
+		}
+		switch m.Type {
+		case "volume":
+			if m.Name == "" {
+				continue
+			}
+			key := ServiceName(m.Name)
+			svc.Volumes = append(svc.Volumes, key+":"+m.Destination+mode)
+			if out.Volumes == nil {
+				out.Volumes = map[string]ComposeResource{}
+			}
+			out.Volumes[key] = ComposeResource{Name: m.Name}
+		case "bind":
+			svc.Volumes = append(svc.Volumes, m.Source+":"+m.Destination+mode)
+		}
+	}
+
+	nm := string(hc.NetworkMode)
+	switch {
+	case nm == "host" || nm == "none" || strings.HasPrefix(nm, "container:"):
+		svc.NetworkMode = nm
+	case cj.NetworkSettings != nil:
+		for netName, ep := range cj.NetworkSettings.Networks {
+			if netName == "bridge" || ep == nil {
+				continue
+			}
+			key := ServiceName(netName)
+			attach := &ComposeServiceNetwork{}
+			for _, a := range ep.Aliases {
+				if a != "" && !strings.HasPrefix(cj.ID, a) && a != name {
+					attach.Aliases = append(attach.Aliases, a)
+				}
+			}
+			if ep.IPAMConfig != nil {
+				attach.IPv4Address = ep.IPAMConfig.IPv4Address
+			}
+			if svc.Networks == nil {
+				svc.Networks = map[string]*ComposeServiceNetwork{}
+			}
+			svc.Networks[key] = attach
+			if out.Networks == nil {
+				out.Networks = map[string]ComposeResource{}
+			}
+			out.Networks[key] = ComposeResource{Name: netName, External: true}
+		}
+	}
+	escapeService(&svc)
+	for k, r := range out.Volumes {
+		r.Name = escapeDollars(r.Name)
+		out.Volumes[k] = r
+	}
+	for k, r := range out.Networks {
+		r.Name = escapeDollars(r.Name)
+		out.Networks[k] = r
+	}
+	out.Services[ServiceName(name)] = svc
+	return out, nil
+}
+
+// escapeDollars writes $ as $$ so compose takes a value from the container literally instead
+// of interpolating it: an environment value like pa$word would otherwise lose "$word".
+func escapeDollars(s string) string {
+	return strings.ReplaceAll(s, "$", "$$")
+}
+
+func escapeDollarsAll(ss []string) []string {
+	if ss == nil {
+		return nil
+	}
+	out := make([]string, len(ss))
+	for i, s := range ss {
+		out[i] = escapeDollars(s)
+	}
+	return out
+}
+
+// escapeService escapes every string value of svc; compose interpolates values, not keys.
+func escapeService(svc *ComposeService) {
+	for _, p := range []*string{&svc.Image, &svc.ContainerName, &svc.Hostname, &svc.User, &svc.WorkingDir, &svc.NetworkMode, &svc.Restart} {
+		*p = escapeDollars(*p)
+	}
+	for _, p := range []*[]string{&svc.Entrypoint, &svc.Command, &svc.Environment, &svc.Ports, &svc.Volumes, &svc.CapAdd, &svc.CapDrop, &svc.Devices, &svc.SecurityOpt, &svc.ExtraHosts, &svc.DNS} {
+		*p = escapeDollarsAll(*p)
+	}
+	for k, v := range svc.Labels {
+		svc.Labels[k] = escapeDollars(v)
+	}
+	for _, n := range svc.Networks {
+		n.Aliases = escapeDollarsAll(n.Aliases)
+	}
+	if svc.Healthcheck != nil {
+		svc.Healthcheck.Test = escapeDollarsAll(svc.Healthcheck.Test)
+	}
+	if svc.Logging != nil {
+		// the options map is the container's own; do not modify it
+		l := &ComposeLogging{Driver: escapeDollars(svc.Logging.Driver)}
+		for k, v := range svc.Logging.Options {
+			if l.Options == nil {
+				l.Options = map[string]string{}
+			}
+			l.Options[k] = escapeDollars(v)
+		}
+		svc.Logging = l
+	}
+}
+
+// MarshalCompose renders the compose file as YAML.
+func MarshalCompose(cf *ComposeFile) ([]byte, error) {
+	return yaml.Marshal(cf)
+}
+
+// PortSpecs renders port bindings in the "[ip:]hostPort:containerPort/proto" form shared by
+// compose and `docker run -p`, sorted for stable output.
+func PortSpecs(hc *container.HostConfig) []string {
+	var specs []string
+	for port, bindings := range hc.PortBindings {
+		proto := port.Proto()
+		suffix := ""
+		if proto != "" && proto != "tcp" {
+			suffix = "/" + proto
+		}
+		if len(bindings) == 0 {
+			specs = append(specs, port.Port()+suffix)
+			continue
+		}
+		for _, b := range bindings {
+			spec := port.Port() + suffix
+			if b.HostPort != "" {
+				spec = b.HostPort + ":" + spec
+			}
+			if b.HostIP != "" && b.HostIP != "0.0.0.0" && b.HostIP != "::" {
+				ip := b.HostIP
+				if strings.Contains(ip, ":") {
+					ip = "[" + ip + "]"
+				}
+				spec = ip + ":" + spec
+			}
+			specs = append(specs, spec)
+		}
+	}
+	sort.Strings(specs)
+	return specs
+}
@@ -0,0 +1,108 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+)
+
+func TestToCompose(t *testing.T) {
+	cj := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:   "abcdef123456",
+			Name: "/my_app",
+			HostConfig: &container.HostConfig{
+				RestartPolicy: container.RestartPolicy{Name: "unless-stopped"},
+				PortBindings: nat.PortMap{
+					"80/tcp": {{HostIP: "127.0.0.1", HostPort: "8080"}},
+					"53/udp": {{HostPort: "5353"}},
+				},
+				NetworkMode: "appnet",
+			},
+		},
+		Config: &container.Config{
+			Image:    "nginx:1.27",
+			Hostname: "abcdef123456",
+			Env:      []string{"A=1"},
+			Labels:   map[string]string{"com.docker.compose.project": "x", "team": "web"},
+		},
+		Mounts: []types.MountPoint{
+			{Type: "volume", Name: "app-data", Destination: "/data", RW: true},
+			{Type: "bind", Source: "/etc/app", Destination: "/etc/app", RW: false},
+		},
+		NetworkSettings: &types.NetworkSettings{
+			Networks: map[string]*network.EndpointSettings{
+				"appnet": {Aliases: []string{"abcdef123456", "web"}},
+			},
+		},
+	}
+	cf, err := ToCompose(cj)
+	if err != nil {
+		t.Fatalf("ToCompose: %v", err)
+	}
+	svc, ok := cf.Services["my_app"]
+	if !ok {
+		t.Fatalf("expected service my_app, got %v", cf.Services)
+	}
+	if svc.Hostname != "" {
+		t.Fatalf("generated hostname should be omitted, got %q", svc.Hostname)
+	}
+	if strings.Join(svc.Ports, ",") != "127.0.0.1:8080:80,5353:53/udp" {
+		t.Fatalf("unexpected ports %v", svc.Ports)
+	}
+	if strings.Join(svc.Volumes, ",") != "app-data:/data,/etc/app:/etc/app:ro" {
+		t.Fatalf("unexpected volumes %v", svc.Volumes)
+	}
+	if _, ok := svc.Labels["com.docker.compose.project"]; ok || svc.Labels["team"] != "web" {
+		t.Fatalf("unexpected labels %v", svc.Labels)
+	}
+	if n := svc.Networks["appnet"]; n == nil || strings.Join(n.Aliases, ",") != "web" {
+		t.Fatalf("unexpected network attachment %+v", svc.Networks)
+	}
+	if cf.Volumes["app-data"].Name != "app-data" || !cf.Networks["appnet"].External {
+		t.Fatalf("unexpected top-level resources %+v %+v", cf.Volumes, cf.Networks)
+	}
+	if _, err := MarshalCompose(cf); err != nil {
+		t.Fatalf("MarshalCompose: %v", err)
+	}
+}
+
+func TestToCompose_EscapesDollars(t *testing.T) {
+	cj := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			Name: "/app",
+			HostConfig: &container.HostConfig{
+				LogConfig: container.LogConfig{Type: "syslog", Config: map[string]string{"tag": "$HOST"}},
+			},
+		},
+		Config: &container.Config{
+			Image:      "app:1",
+			Env:        []string{"PASSWORD=pa$word", "PRICE=5$"},
+			Entrypoint: []string{"sh", "-c"},
+			Cmd:        []string{"echo $HOME ${USER}"},
+			Labels:     map[string]string{"cost": "$$1"},
+		},
+	}
+	cf, err := ToCompose(cj)
+	if err != nil {
+		t.Fatalf("ToCompose: %v", err)
+	}
+	svc := cf.Services["app"]
+	if got := strings.Join(svc.Environment, ","); got != "PASSWORD=pa$$word,PRICE=5$$" {
+		t.Fatalf("environment = %s", got)
+	}
+	if got := strings.Join(svc.Command, ","); got != "echo $$HOME $${USER}" {
+		t.Fatalf("command = %s", got)
+	}
+	if svc.Labels["cost"] != "$$$$1" || svc.Logging.Options["tag"] != "$$HOST" {
+		t.Fatalf("labels %v, logging options %v", svc.Labels, svc.Logging.Options)
+	}
+	// the container's own values are left alone
+	if cj.Config.Env[0] != "PASSWORD=pa$word" || cj.HostConfig.LogConfig.Config["tag"] != "$HOST" {
+		t.Fatalf("ToCompose modified the container: %v %v", cj.Config.Env, cj.HostConfig.LogConfig.Config)
+	}
+}
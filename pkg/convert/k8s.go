@@ -0,0 +1,381 @@
+package convert
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"gopkg.in/yaml.v3"
+
+	"github.com/brian033/dockerbackup/pkg/remote"
+)
+
+const (
+	K8sKindDeployment = "Deployment"
+	K8sKindPod        = "Pod"
+
+	// k8sDataFile is where the restore-data init container expects a volume archive when no
+	// data URL is given (copy it in with `kubectl cp ... -c restore-data`).
+	k8sDataFile     = ".dockerbackup-data.tar.gz"
+	k8sRestoredMark = ".dockerbackup-restored"
+	k8sInitImage    = "busybox:1.36"
+)
+
+// K8sOptions controls the manifests produced by ToKubernetes.
+type K8sOptions struct {
+	Kind         string // Deployment (default) or Pod
+	Namespace    string
+	PVCSize      string // default 1Gi
+	StorageClass string
+	// DataURL is a base URL serving the backup's volumes/*.tar.gz files; the init container
+	// downloads from it instead of waiting for the archive to be copied in.
+	DataURL string
+	// Artifacts maps mount destinations to the backup entry holding their data (see
+	// backup.MountArtifacts). The claims of mounts missing from it start empty.
+	Artifacts map[string]string
+}
+
+// K8sVolume describes a PVC generated for a container mount and the backup archive that
+// seeds it.
+type K8sVolume struct {
+	ClaimName string
+	MountPath string
+	Archive   string // path of the data archive inside the backup, e.g. volumes/data.tar.gz; empty when there is none
+	ReadOnly  bool
+}
+
+// ToKubernetes converts a container inspect document into Kubernetes manifests: one PVC per
+// named volume or bind mount, the workload (with an init container that seeds the PVCs from
+// the backup's volume archives) and a Service for published ports.
+func ToKubernetes(cj types.ContainerJSON, opts K8sOptions) ([]byte, []K8sVolume, error) {
+	if cj.ContainerJSONBase == nil {
+		return nil, nil, fmt.Errorf("container.json has no container data")
+	}
+	kind := opts.Kind
+	if kind == "" {
+		kind = K8sKindDeployment
+	}
+	if kind != K8sKindDeployment && kind != K8sKindPod {
+		return nil, nil, fmt.Errorf("unsupported kind %q (use %s or %s)", opts.Kind, K8sKindDeployment, K8sKindPod)
+	}
+	size := opts.PVCSize
+	if size == "" {
+		size = "1Gi"
+	}
+	cfg := cj.Config
+	if cfg == nil {
+		cfg = &container.Config{}
+	}
+	hc := cj.HostConfig
+	if hc == nil {
+		hc = &container.HostConfig{}
+	}
+	name := K8sName(strings.TrimPrefix(cj.Name, "/"))
+	appLabels := map[string]string{"app": name}
+
+	meta := func(n string, labels map[string]string) map[string]any {
+		m := map[string]any{"name": n}
+		if opts.Namespace != "" {
+			m["namespace"] = opts.Namespace
+		}
+		if labels != nil {
+			m["labels"] = labels
+		}
+		return m
+	}
+
+	var docs []any
+	var vols []K8sVolume
+	for _, m := range cj.Mounts {
+		var claim string
+		switch m.Type {
+		case "volume":
+			if m.Name == "" {
+				continue
+			}
+			claim = K8sName(m.Name)
+		case "bind":
+			if m.Source == "" {
+				continue
+			}
+			claim = K8sName(name + "-bind-" + filepath.Base(m.Source) + "-" + bindSourceHash(m.Source))
+		default:
+			continue
+		}
+		vols = append(vols, K8sVolume{ClaimName: claim, MountPath: m.Destination, Archive: opts.Artifacts[m.Destination], ReadOnly: !m.RW})
+		spec := map[string]any{
+			"accessModes": []string{"ReadWriteOnce"},
+			"resources":   map[string]any{"requests": map[string]string{"storage": size}},
+		}
+		if opts.StorageClass != "" {
+			spec["storageClassName"] = opts.StorageClass
+		}
+		docs = append(docs, map[string]any{
+			"apiVersion": "v1",
+			"kind":       "PersistentVolumeClaim",
+			"metadata":   meta(claim, appLabels),
+			"spec":       spec,
+		})
+	}
+
+	ctr := map[string]any{"name": name, "image": imageOf(cj)}
+	if len(cfg.Entrypoint) > 0 {
+		ctr["command"] = cfg.Entrypoint
+	}
+	if len(cfg.Cmd) > 0 {
+		ctr["args"] = cfg.Cmd
+	}
+	if cfg.WorkingDir != "" {
+		ctr["workingDir"] = cfg.WorkingDir
+	}
+	var env []map[string]string
+	for _, kv := range cfg.Env {
+		k, v, _ := strings.Cut(kv, "=")
+		env = append(env, map[string]string{"name": k, "value": v})
+	}
+	if len(env) > 0 {
+		ctr["env"] = env
+	}
+	var ctrPorts []map[string]any
+	var svcPorts []map[string]any
+	for _, p := range containerPorts(cj) {
+		proto := strings.ToUpper(p.Proto())
+		ctrPorts = append(ctrPorts, map[string]any{"containerPort": p.Int(), "protocol": proto})
+		if _, published := hc.PortBindings[p]; published {
+			svcPorts = append(svcPorts, map[string]any{
+				"name":       strings.ToLower(fmt.Sprintf("%s-%d", proto, p.Int())),
+				"port":       p.Int(),
+				"targetPort": p.Int(),
+				"protocol":   proto,
+			})
+		}
+	}
+	if len(ctrPorts) > 0 {
+		ctr["ports"] = ctrPorts
+	}
+	var mounts, podVolumes, initMounts []map[string]any
+	var script []string
+	for _, v := range vols {
+		mounts = append(mounts, map[string]any{"name": v.ClaimName, "mountPath": v.MountPath, "readOnly": v.ReadOnly})
+		podVolumes = append(podVolumes, map[string]any{"name": v.ClaimName, "persistentVolumeClaim": map[string]string{"claimName": v.ClaimName}})
+		dir := "/mnt/" + v.ClaimName
+		if v.Archive == "" {
+			continue
+		}
+		initMounts = append(initMounts, map[string]any{"name": v.ClaimName, "mountPath": dir})
+		script = append(script, seedScript(dir, v, opts.DataURL))
+	}
+	if len(mounts) > 0 {
+		ctr["volumeMounts"] = mounts
+	}
+	if hc.Privileged || len(hc.CapAdd) > 0 || len(hc.CapDrop) > 0 {
+		sc := map[string]any{}
+		if hc.Privileged {
+			sc["privileged"] = true
+		}
+		caps := map[string]any{}
+		if len(hc.CapAdd) > 0 {
+			caps["add"] = hc.CapAdd
+		}
+		if len(hc.CapDrop) > 0 {
+			caps["drop"] = hc.CapDrop
+		}
+		if len(caps) > 0 {
+			sc["capabilities"] = caps
+		}
+		ctr["securityContext"] = sc
+	}
+	if probe := healthProbe(cfg.Healthcheck); probe != nil {
+		ctr["livenessProbe"] = probe
+	}
+	if hc.Memory > 0 || hc.NanoCPUs > 0 {
+		limits := map[string]string{}
+		if hc.Memory > 0 {
+			limits["memory"] = fmt.Sprintf("%d", hc.Memory)
+		}
+		if hc.NanoCPUs > 0 {
+			limits["cpu"] = fmt.Sprintf("%dm", hc.NanoCPUs/1e6)
+		}
+		ctr["resources"] = map[string]any{"limits": limits}
+	}
+
+	podSpec := map[string]any{"containers": []any{ctr}}
+	if cfg.Hostname != "" && !strings.HasPrefix(cj.ID, cfg.Hostname) {
+		podSpec["hostname"] = K8sName(cfg.Hostname)
+	}
+	if len(podVolumes) > 0 {
+		podSpec["volumes"] = podVolumes
+	}
+	if len(initMounts) > 0 {
+		podSpec["initContainers"] = []any{map[string]any{
+			"name":         "restore-data",
+			"image":        k8sInitImage,
+			"command":      []string{"sh", "-c", strings.Join(script, "\n")},
+			"volumeMounts": initMounts,
+		}}
+	}
+	if kind == K8sKindPod {
+		podSpec["restartPolicy"] = podRestartPolicy(hc.RestartPolicy)
+		docs = append(docs, map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"metadata":   meta(name, appLabels),
+			"spec":       podSpec,
+		})
+	} else {
+		docs = append(docs, map[string]any{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   meta(name, appLabels),
+			"spec": map[string]any{
+				"replicas": 1,
+				// a single writer per RWO claim: never run old and new pods together
+				"strategy": map[string]string{"type": "Recreate"},
+				"selector": map[string]any{"matchLabels": appLabels},
+				"template": map[string]any{
+					"metadata": map[string]any{"labels": appLabels},
+					"spec":     podSpec,
+				},
+			},
+		})
+	}
+	if len(svcPorts) > 0 {
+		docs = append(docs, map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   meta(name, appLabels),
+			"spec":       map[string]any{"selector": appLabels, "ports": svcPorts},
+		})
+	}
+
+	var buf bytes.Buffer
+	for i, d := range docs {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		b, err := yaml.Marshal(d)
+		if err != nil {
+			return nil, nil, err
+		}
+		buf.Write(b)
+	}
+	return buf.Bytes(), vols, nil
+}
+
+// K8sName converts an arbitrary docker name into a DNS-1123 label.
+func K8sName(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimPrefix(s, "/")) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+	n := b.String()
+	if len(n) > 63 {
+		n = n[:63]
+	}
+	n = strings.Trim(n, "-")
+	if n == "" {
+		return "app"
+	}
+	return n
+}
+
+// seedScript extracts a volume archive into an empty claim exactly once. Archives store their
+// contents under a single top-level directory, hence --strip-components=1.
+func seedScript(dir string, v K8sVolume, dataURL string) string {
+	q := remote.ShellQuote
+	marker := dir + "/" + k8sRestoredMark
+	data := dir + "/" + k8sDataFile
+	var fetch string
+	if dataURL != "" {
+		fetch = fmt.Sprintf("wget -qO %s %s", q(data), q(strings.TrimRight(dataURL, "/")+"/"+v.Archive))
+	} else {
+		msg := fmt.Sprintf("waiting for %s (kubectl cp <backup>/%s <pod>:%s -c restore-data)", data, v.Archive, data)
+		fetch = fmt.Sprintf("echo %s; until [ -f %s ]; do sleep 5; done", q(msg), q(data))
+	}
+	return fmt.Sprintf("if [ ! -f %s ]; then %s && tar xzf %s -C %s --strip-components=1 && rm -f %s && touch %s; fi", q(marker), fetch, q(data), q(dir), q(data), q(marker))
+}
+
+// bindSourceHash keeps the claims of bind mounts with equal base names apart, like the hash
+// the backup adds to bind mount archive names.
+func bindSourceHash(source string) string {
+	sum := sha256.Sum256([]byte(filepath.Clean(source)))
+	return hex.EncodeToString(sum[:4])
+}
+
+func imageOf(cj types.ContainerJSON) string {
+	if cj.Config != nil && cj.Config.Image != "" {
+		return cj.Config.Image
+	}
+	return cj.Image
+}
+
+func containerPorts(cj types.ContainerJSON) []nat.Port {
+	seen := map[nat.Port]bool{}
+	if cj.Config != nil {
+		for p := range cj.Config.ExposedPorts {
+			seen[p] = true
+		}
+	}
+	if cj.HostConfig != nil {
+		for p := range cj.HostConfig.PortBindings {
+			seen[p] = true
+		}
+	}
+	out := make([]nat.Port, 0, len(seen))
+	for p := range seen {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+func healthProbe(hc *container.HealthConfig) map[string]any {
+	if hc == nil || len(hc.Test) < 2 {
+		return nil
+	}
+	var cmd []string
+	switch hc.Test[0] {
+	case "CMD":
+		cmd = hc.Test[1:]
+	case "CMD-SHELL":
+		cmd = []string{"sh", "-c", hc.Test[1]}
+	default:
+		return nil
+	}
+	probe := map[string]any{"exec": map[string]any{"command": cmd}}
+	if hc.Interval > 0 {
+		probe["periodSeconds"] = int(hc.Interval.Seconds())
+	}
+	if hc.Timeout > 0 {
+		probe["timeoutSeconds"] = int(hc.Timeout.Seconds())
+	}
+	if hc.StartPeriod > 0 {
+		probe["initialDelaySeconds"] = int(hc.StartPeriod.Seconds())
+	}
+	if hc.Retries > 0 {
+		probe["failureThreshold"] = hc.Retries
+	}
+	return probe
+}
+
+func podRestartPolicy(rp container.RestartPolicy) string {
+	switch rp.Name {
+	case "on-failure":
+		return "OnFailure"
+	case "", "no":
+		return "Never"
+	default:
+		return "Always"
+	}
+}
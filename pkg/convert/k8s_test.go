@@ -0,0 +1,92 @@
+package convert
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+func TestToKubernetes(t *testing.T) {
+	cj := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			Name: "/my_db",
+			HostConfig: &container.HostConfig{
+				PortBindings: nat.PortMap{"5432/tcp": {{HostPort: "5432"}}},
+			},
+		},
+		Config: &container.Config{Image: "postgres:16", Env: []string{"POSTGRES_PASSWORD=x"}},
+		Mounts: []types.MountPoint{
+			{Type: "volume", Name: "db_data", Destination: "/var/lib/postgresql/data", RW: true},
+			{Type: "volume", Name: "cache", Destination: "/cache", RW: true},
+		},
+	}
+	// the cache volume's data was not archived
+	artifacts := map[string]string{"/var/lib/postgresql/data": "volumes/db_data.tar.gz"}
+	out, vols, err := ToKubernetes(cj, K8sOptions{DataURL: "http://files/backup/", Artifacts: artifacts})
+	if err != nil {
+		t.Fatalf("ToKubernetes: %v", err)
+	}
+	if len(vols) != 2 || vols[0].ClaimName != "db-data" || vols[0].Archive != "volumes/db_data.tar.gz" || vols[1].ClaimName != "cache" || vols[1].Archive != "" {
+		t.Fatalf("unexpected volumes %+v", vols)
+	}
+	if strings.Contains(string(out), "mnt/cache") {
+		t.Fatalf("the empty cache claim is seeded:\n%s", out)
+	}
+	s := string(out)
+	for _, want := range []string{"kind: PersistentVolumeClaim", "kind: Deployment", "kind: Service", "name: my-db", "claimName: db-data", "http://files/backup/volumes/db_data.tar.gz"} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("expected %q in manifests:\n%s", want, s)
+		}
+	}
+	if _, _, err := ToKubernetes(cj, K8sOptions{Kind: "StatefulSet"}); err == nil {
+		t.Fatalf("expected unsupported kind error")
+	}
+}
+
+func TestSeedScript_QuotesValues(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	dir := t.TempDir()
+	bin := t.TempDir()
+	// wget records its arguments and fails, ending the script before tar
+	wget := "#!/bin/sh\nfor a in \"$@\"; do echo \"$a\"; done > " + filepath.Join(dir, "wget.args") + "\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(bin, "wget"), []byte(wget), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	v := K8sVolume{ClaimName: "data", Archive: "volumes/it's $(touch pwned).tar.gz"}
+
+	script := seedScript(dir, v, "http://files/get?a=1&b=2")
+	if out, err := exec.Command("sh", "-c", script).CombinedOutput(); err == nil {
+		t.Fatalf("the script succeeded with a failing download: %s", out)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "wget.args"))
+	if err != nil {
+		t.Fatalf("wget was not run: %v", err)
+	}
+	want := "-qO\n" + filepath.Join(dir, k8sDataFile) + "\nhttp://files/get?a=1&b=2/volumes/it's $(touch pwned).tar.gz\n"
+	if string(b) != want {
+		t.Fatalf("wget arguments = %q, want %q", b, want)
+	}
+
+	// without a URL the script waits for the archive to be copied in; here it is already there
+	if err := os.WriteFile(filepath.Join(dir, k8sDataFile), []byte("not a tar"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := exec.Command("sh", "-c", seedScript(dir, v, "")).Output()
+	if !strings.HasPrefix(string(out), "waiting for "+filepath.Join(dir, k8sDataFile)+" (kubectl cp <backup>/volumes/it's $(touch pwned).tar.gz ") {
+		t.Fatalf("echo printed %q", out)
+	}
+	for _, d := range []string{dir, "."} {
+		if _, err := os.Stat(filepath.Join(d, "pwned")); err == nil {
+			t.Fatal("a command in the archive name was run")
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package convert
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/brian033/dockerbackup/pkg/remote"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// RunArgs converts a container inspect document into the argument list of an equivalent
+// `docker run -d` invocation (starting with "docker").
+func RunArgs(cj types.ContainerJSON) ([]string, error) {
+	if cj.ContainerJSONBase == nil {
+		return nil, fmt.Errorf("container.json has no container data")
+	}
+	cfg := cj.Config
+	if cfg == nil {
+		cfg = &container.Config{}
+	}
+	hc := cj.HostConfig
+	if hc == nil {
+		hc = &container.HostConfig{}
+	}
+	args := []string{"docker", "run", "-d"}
+	add := func(flag string, values ...string) {
+		for _, v := range values {
+			if v != "" {
+				args = append(args, flag, v)
+			}
+		}
+	}
+	add("--name", strings.TrimPrefix(cj.Name, "/"))
+	if cfg.Hostname != "" && !strings.HasPrefix(cj.ID, cfg.Hostname) {
+		add("--hostname", cfg.Hostname)
+	}
+	add("--user", cfg.User)
+	add("--workdir", cfg.WorkingDir)
+	if rp := string(hc.RestartPolicy.Name); rp != "" && rp != "no" {
+		if rp == "on-failure" && hc.RestartPolicy.MaximumRetryCount > 0 {
+			rp = fmt.Sprintf("on-failure:%d", hc.RestartPolicy.MaximumRetryCount)
+		}
+		add("--restart", rp)
+	}
+	if hc.Privileged {
+		args = append(args, "--privileged")
+	}
+	add("--env", cfg.Env...)
+	add("--publish", PortSpecs(hc)...)
+	for _, m := range cj.Mounts {
+		src := m.Source
+		switch m.Type {
+		case "volume":
+			src = m.Name
+		case "bind":
+		default:
+			continue
+		}
+		spec := src + ":" + m.Destination
+		if !m.RW {
+			spec += ":ro"
+		}
+		add("--volume", spec)
+	}
+	nm := string(hc.NetworkMode)
+	if nm != "" && nm != "default" && nm != "bridge" {
+		add("--network", nm)
+	}
+	if cj.NetworkSettings != nil && !strings.HasPrefix(nm, "container:") && nm != "host" && nm != "none" {
+		// only one network can be given to docker run; aliases/IPs apply to it
+		if ep := cj.NetworkSettings.Networks[nm]; ep != nil {
+			for _, a := range ep.Aliases {
+				if a != "" && !strings.HasPrefix(cj.ID, a) && a != strings.TrimPrefix(cj.Name, "/") {
+					add("--network-alias", a)
+				}
+			}
+			if ep.IPAMConfig != nil {
+				add("--ip", ep.IPAMConfig.IPv4Address)
+			}
+		}
+	}
+	add("--cap-add", hc.CapAdd...)
+	add("--cap-drop", hc.CapDrop...)
+	for _, d := range hc.Devices {
+		spec := d.PathOnHost + ":" + d.PathInContainer
+		if d.CgroupPermissions != "" && d.CgroupPermissions != "rwm" {
+			spec += ":" + d.CgroupPermissions
+		}
+		add("--device", spec)
+	}
+	add("--security-opt", hc.SecurityOpt...)
+	add("--add-host", hc.ExtraHosts...)
+	add("--dns", hc.DNS...)
+	if hc.Memory > 0 {
+		add("--memory", fmt.Sprintf("%d", hc.Memory))
+	}
+	if hc.NanoCPUs > 0 {
+		add("--cpus", fmt.Sprintf("%g", float64(hc.NanoCPUs)/1e9))
+	}
+	if hc.LogConfig.Type != "" && hc.LogConfig.Type != "json-file" {
+		add("--log-driver", hc.LogConfig.Type)
+	}
+	if hc.LogConfig.Type != "" {
+		add("--log-opt", sortedPairs(hc.LogConfig.Config)...)
+	}
+	var labels = map[string]string{}
+	for k, v := range cfg.Labels {
+		if strings.HasPrefix(k, "com.docker.compose.") || strings.HasPrefix(k, "org.opencontainers.") {
+			continue
+		}
+		labels[k] = v
+	}
+	add("--label", sortedPairs(labels)...)
+	if len(cfg.Entrypoint) > 0 {
+		add("--entrypoint", cfg.Entrypoint[0])
+	}
+	image := cfg.Image
+	if image == "" {
+		image = cj.Image
+	}
+	args = append(args, image)
+	if len(cfg.Entrypoint) > 1 {
+		args = append(args, cfg.Entrypoint[1:]...)
+	}
+	args = append(args, cfg.Cmd...)
+	return args, nil
+}
+
+// ShellJoin quotes args for a POSIX shell, continuing each flag on its own line.
+func ShellJoin(args []string) string {
+	var b strings.Builder
+	for i, a := range args {
+		if i > 0 {
+			if strings.HasPrefix(a, "-") {
+				b.WriteString(" \\\n  ")
+			} else {
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString(remote.ShellQuote(a))
+	}
+	return b.String()
+}
+
+func sortedPairs(m map[string]string) []string {
+	out := make([]string, 0, len(m))
+	for k, v := range m {
+		out = append(out, k+"="+v)
+	}
+	sort.Strings(out)
+	return out
+}
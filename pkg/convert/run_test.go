@@ -0,0 +1,38 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+func TestRunArgs(t *testing.T) {
+	cj := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:   "abcdef123456",
+			Name: "/web",
+			HostConfig: &container.HostConfig{
+				PortBindings: nat.PortMap{"80/tcp": {{HostPort: "8080"}}},
+				CapAdd:       []string{"NET_ADMIN"},
+			},
+		},
+		Config: &container.Config{
+			Image: "nginx",
+			Env:   []string{"GREETING=hello world"},
+			Cmd:   []string{"nginx", "-g", "daemon off;"},
+		},
+	}
+	args, err := RunArgs(cj)
+	if err != nil {
+		t.Fatalf("RunArgs: %v", err)
+	}
+	got := ShellJoin(args)
+	for _, want := range []string{"--name web", "--env 'GREETING=hello world'", "--publish 8080:80", "--cap-add NET_ADMIN", "nginx nginx -g 'daemon off;'"} {
+		if !strings.Contains(strings.ReplaceAll(got, " \\\n  ", " "), want) {
+			t.Fatalf("expected %q in:\n%s", want, got)
+		}
+	}
+}
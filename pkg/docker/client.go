@@ -10,9 +10,16 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	internalerrors "github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/internal/retry"
+	"github.com/brian033/dockerbackup/pkg/archive"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/network"
 )
@@ -21,6 +28,9 @@ var ErrEmptyInspect = errors.New("docker inspect returned empty result")
 
 type DockerClient interface {
 	InspectContainer(ctx context.Context, containerID string) ([]byte, error)
+	// ContainerSize returns the size of a container's root filesystem: its image plus the
+	// writable layer, which docker export streams in full
+	ContainerSize(ctx context.Context, containerID string) (int64, error)
 	ExportContainerFilesystem(ctx context.Context, containerID string, destTarPath string) error
 	ListVolumes(ctx context.Context) ([]string, error)
 
@@ -30,7 +40,11 @@ type DockerClient interface {
 
 	// Image fidelity
 	ImageSave(ctx context.Context, imageRef string, destTarPath string) error
+	// ImageSaveAll saves several images into one archive, storing shared layers once
+	ImageSaveAll(ctx context.Context, imageRefs []string, destTarPath string) error
 	ImageLoad(ctx context.Context, tarPath string) error
+	EnsureImage(ctx context.Context, ref string) error
+	InspectImage(ctx context.Context, ref string) (*ImageInfo, error)
 	TagImage(ctx context.Context, sourceRef, targetRef string) error
 
 	// Ensure resources exist with original options (SDK preferred)
@@ -38,67 +52,230 @@ type DockerClient interface {
 	EnsureNetwork(ctx context.Context, cfg NetworkConfig) error
 
 	// Restore-related
-	ImportImage(ctx context.Context, tarPath string, ref string) (string, error)
-	VolumeCreate(ctx context.Context, name string) error
+	// labels are applied to the created image, volume or container
+	ImportImage(ctx context.Context, tarPath string, ref string, labels map[string]string) (string, error)
+	VolumeCreate(ctx context.Context, name string, labels map[string]string) error
 	ExtractTarGzToVolume(ctx context.Context, volumeName string, tarGzPath string, expectedRoot string) error
-	CreateContainer(ctx context.Context, imageRef string, name string, mounts []Mount) (string, error)
+	// VolumeHasData reports whether an existing volume holds any files
+	VolumeHasData(ctx context.Context, volumeName string) (bool, error)
+	// ClearVolume deletes everything in a volume, keeping the volume itself
+	ClearVolume(ctx context.Context, volumeName string) error
+	// ArchiveVolume streams a volume's data through a helper container into a tar.gz whose
+	// entries are rooted at <volumeName>/, for volumes not reachable on the host filesystem
+	ArchiveVolume(ctx context.Context, volumeName string, destTarGz string) error
+	// CreateContainer creates a container with docker create, for when CreateContainerFromSpec
+	// is not implemented
+	CreateContainer(ctx context.Context, imageRef string, name string, mounts []Mount, opts CreateOptions) (string, error)
 	CreateContainerFromSpec(ctx context.Context, cfg *container.Config, hostCfg *container.HostConfig, netCfg *network.NetworkingConfig, name string) (string, error)
 	StartContainer(ctx context.Context, containerID string) error
+	StopContainer(ctx context.Context, containerID string) error
+	// PauseContainer freezes the processes of a running container (docker pause)
+	PauseContainer(ctx context.Context, containerID string) error
+	RemoveContainer(ctx context.Context, containerID string) error
+	RenameContainer(ctx context.Context, containerID, newName string) error
+	// CRIU checkpoints (daemon must run with experimental features and CRIU installed)
+	CheckpointCreate(ctx context.Context, containerID, name, checkpointDir string, leaveRunning bool) error
+	StartContainerFromCheckpoint(ctx context.Context, containerID, name, checkpointDir string) error
 	HostIPs(ctx context.Context) ([]string, error)
+	LogDrivers(ctx context.Context) ([]string, error)
+	Runtimes(ctx context.Context) ([]string, error)
+	// UsernsRemap returns the daemon's subordinate ID range, nil without userns-remap
+	UsernsRemap(ctx context.Context) (*UsernsRange, error)
+	// DaemonInfo returns `docker info` as JSON
+	DaemonInfo(ctx context.Context) ([]byte, error)
+	ListPlugins(ctx context.Context) ([]PluginInfo, error)
+	// VolumeDrivers lists the volume drivers the daemon can use (local, managed and legacy plugins)
+	VolumeDrivers(ctx context.Context) ([]string, error)
+	InstallPlugin(ctx context.Context, ref string, alias string, settings []string) error
 	ContainerState(ctx context.Context, containerID string) (status string, healthStatus string, err error)
 	ListProjectContainers(ctx context.Context, project string) ([]ProjectContainerRef, error)
 	ListProjectContainersByLabel(ctx context.Context, project string) ([]ProjectContainerRef, error)
+	ComposeUp(ctx context.Context, projectDir string, projectName string, scale map[string]int) error
+
+	// Cleanup of resources left behind by interrupted runs
+	RemoveVolume(ctx context.Context, name string) error
+	RemoveNetwork(ctx context.Context, name string) error
+	ListContainersByLabel(ctx context.Context, label string) ([]ContainerRef, error)
+	ListResourcesByLabel(ctx context.Context, label string) ([]LabeledResource, error)
+	// ListRunningContainers lists the running containers with their compose project
+	ListRunningContainers(ctx context.Context) ([]ContainerRef, error)
 }
 
-type CLIClient struct{}
+// CLIClient drives the docker CLI. Idempotent calls (inspects, save, export, load, pull and
+// volume archiving) are retried when they fail in a way that suggests a transient daemon,
+// registry or network problem; calls that change state are run once.
+type CLIClient struct {
+	retry retry.Policy
+	log   logger.Logger
+	// helperImage runs the short-lived containers that read and write volume data
+	helperImage string
+	// opTimeout bounds each docker command, see OpTimeout; 0 disables it
+	opTimeout time.Duration
+}
 
 func NewCLIClient() DockerClient {
-	return &CLIClient{}
+	return &CLIClient{retry: retry.FromEnv(), log: logger.New(), helperImage: HelperImage(), opTimeout: OpTimeout()}
+}
+
+// transientDockerErrors are stderr fragments of failures a retry may fix.
+var transientDockerErrors = []string{
+	"Cannot connect to the Docker daemon",
+	"error during connect",
+	"connection reset by peer",
+	"connection refused",
+	"broken pipe",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"unexpected EOF",
+	"request canceled while waiting for connection",
+	"Bad Gateway",
+	"Service Unavailable",
+	"Gateway Timeout",
+	"Too Many Requests",
+	"toomanyrequests",
+}
+
+func isTransientDockerError(stderr string) bool {
+	for _, s := range transientDockerErrors {
+		if strings.Contains(stderr, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// withRetry runs op, which returns its error and stderr, again while it fails transiently.
+func (c *CLIClient) withRetry(ctx context.Context, op func() (string, error)) (string, error) {
+	var stderr string
+	err := c.retry.Do(ctx, func() error {
+		var err error
+		stderr, err = op()
+		if err != nil && (ctx.Err() != nil || !isTransientDockerError(stderr)) {
+			return retry.Permanent(err)
+		}
+		return err
+	})
+	return stderr, err
+}
+
+// output runs docker with args, retrying transient failures, and returns stdout and the
+// stderr of the last attempt.
+func (c *CLIClient) output(ctx context.Context, args ...string) ([]byte, string, error) {
+	return c.capture(ctx, c.run, args...)
+}
+
+// outputLong is output for commands that take as long as the data they move (docker pull).
+func (c *CLIClient) outputLong(ctx context.Context, args ...string) ([]byte, string, error) {
+	return c.capture(ctx, c.runLong, args...)
+}
+
+func (c *CLIClient) capture(ctx context.Context, run func(*exec.Cmd) error, args ...string) ([]byte, string, error) {
+	var out []byte
+	stderr, err := c.withRetry(ctx, func() (string, error) {
+		cmd := exec.CommandContext(ctx, "docker", args...)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err := run(cmd)
+		out = stdout.Bytes()
+		return stderr.String(), err
+	})
+	return out, stderr, err
+}
+
+// outputToFile runs docker with args writing stdout to dest, which is recreated for each
+// attempt, and returns the stderr of the last attempt. A non-nil prog is logged while the
+// command runs.
+func (c *CLIClient) outputToFile(ctx context.Context, dest string, prog *progress, args ...string) (string, error) {
+	if prog != nil {
+		pctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go prog.run(pctx)
+	}
+	return c.withRetry(ctx, func() (string, error) {
+		f, err := os.Create(dest)
+		if err != nil {
+			return "", retry.Permanent(err)
+		}
+		defer func() { _ = f.Close() }()
+		cmd := exec.CommandContext(ctx, "docker", args...)
+		var stderr bytes.Buffer
+		cmd.Stdout = f
+		if prog != nil {
+			prog.reset()
+			cmd.Stdout = prog.writer(f)
+		}
+		cmd.Stderr = &stderr
+		if err := c.runLong(cmd); err != nil {
+			return stderr.String(), err
+		}
+		return "", f.Close()
+	})
 }
 
 func (c *CLIClient) InspectContainer(ctx context.Context, containerID string) ([]byte, error) {
-	cmd := exec.CommandContext(ctx, "docker", "inspect", containerID)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("docker inspect %s failed: %v: %s", containerID, err, stderr.String())
+	out, stderr, err := c.output(ctx, "inspect", containerID)
+	if err != nil {
+		return nil, fmt.Errorf("docker inspect %s failed: %v: %s", containerID, err, stderr)
 	}
-	if stdout.Len() == 0 {
+	if len(out) == 0 {
 		return nil, ErrEmptyInspect
 	}
-	return stdout.Bytes(), nil
+	return out, nil
 }
 
 func (c *CLIClient) ExportContainerFilesystem(ctx context.Context, containerID string, destTarPath string) error {
 	if err := os.MkdirAll(filepath.Dir(destTarPath), 0o755); err != nil {
 		return err
 	}
-	f, err := os.Create(destTarPath)
+	prog := c.newProgress("docker export "+containerID, c.containerSizeEstimate(ctx, containerID))
+	if stderr, err := c.outputToFile(ctx, destTarPath, prog, "export", containerID); err != nil {
+		return fmt.Errorf("docker export %s failed: %v: %s", containerID, err, stderr)
+	}
+	return nil
+}
+
+func (c *CLIClient) ContainerSize(ctx context.Context, containerID string) (int64, error) {
+	out, stderr, err := c.output(ctx, "container", "inspect", "--size", "-f", "{{.SizeRootFs}}", containerID)
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("docker container inspect --size %s failed: %v: %s", containerID, err, stderr)
 	}
-	defer func() { _ = f.Close() }()
+	return parseSizeOutput(out), nil
+}
 
-	cmd := exec.CommandContext(ctx, "docker", "export", containerID)
-	cmd.Stdout = f
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker export %s failed: %v: %s", containerID, err, stderr.String())
+// containerSizeEstimate returns the size of a container's root filesystem (image plus
+// writable layer, as `docker ps -s` shows it), which docker export streams in full. It is an
+// estimate: export omits mounted volumes and adds tar headers. 0 means unknown.
+func (c *CLIClient) containerSizeEstimate(ctx context.Context, containerID string) int64 {
+	n, _ := c.ContainerSize(ctx, containerID)
+	return n
+}
+
+// imageSizeEstimate returns the unpacked size of an image, close to what docker save writes
+// for it. 0 means unknown.
+func (c *CLIClient) imageSizeEstimate(ctx context.Context, imageRef string) int64 {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", "image", "inspect", "-f", "{{.Size}}", imageRef)
+	cmd.Stdout = &out
+	if err := c.run(cmd); err != nil {
+		return 0
 	}
-	return nil
+	return parseSizeOutput(out.Bytes())
+}
+
+func (c *CLIClient) newProgress(what string, total int64) *progress {
+	if c.log == nil {
+		return nil
+	}
+	return newProgress(c.log, what, total)
 }
 
 func (c *CLIClient) ListVolumes(ctx context.Context) ([]string, error) {
-	cmd := exec.CommandContext(ctx, "docker", "volume", "ls", "--format", "{{.Name}}")
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("docker volume ls failed: %v: %s", err, stderr.String())
+	out, stderr, err := c.output(ctx, "volume", "ls", "--format", "{{.Name}}")
+	if err != nil {
+		return nil, fmt.Errorf("docker volume ls failed: %v: %s", err, stderr)
 	}
-	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
 	var vols []string
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -110,33 +287,28 @@ func (c *CLIClient) ListVolumes(ctx context.Context) ([]string, error) {
 }
 
 func (c *CLIClient) InspectVolume(ctx context.Context, name string) (*VolumeConfig, error) {
-	cmd := exec.CommandContext(ctx, "docker", "volume", "inspect", name)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("docker volume inspect %s failed: %v: %s", name, err, stderr.String())
+	out, stderr, err := c.output(ctx, "volume", "inspect", name)
+	if err != nil {
+		return nil, fmt.Errorf("docker volume inspect %s failed: %v: %s", name, err, stderr)
 	}
 	var arr []struct {
-		Name    string            `json:"Name"`
-		Driver  string            `json:"Driver"`
-		Options map[string]string `json:"Options"`
-		Labels  map[string]string `json:"Labels"`
+		Name       string            `json:"Name"`
+		Driver     string            `json:"Driver"`
+		Options    map[string]string `json:"Options"`
+		Labels     map[string]string `json:"Labels"`
+		Mountpoint string            `json:"Mountpoint"`
 	}
-	if err := json.Unmarshal(stdout.Bytes(), &arr); err != nil || len(arr) == 0 {
+	if err := json.Unmarshal(out, &arr); err != nil || len(arr) == 0 {
 		return nil, fmt.Errorf("parse volume inspect for %s failed: %v", name, err)
 	}
-	v := &VolumeConfig{Name: arr[0].Name, Driver: arr[0].Driver, Options: arr[0].Options, Labels: arr[0].Labels}
+	v := &VolumeConfig{Name: arr[0].Name, Driver: arr[0].Driver, Options: arr[0].Options, Labels: arr[0].Labels, Mountpoint: arr[0].Mountpoint}
 	return v, nil
 }
 
 func (c *CLIClient) InspectNetwork(ctx context.Context, name string) (*NetworkConfig, error) {
-	cmd := exec.CommandContext(ctx, "docker", "network", "inspect", name)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("docker network inspect %s failed: %v: %s", name, err, stderr.String())
+	out, stderr, err := c.output(ctx, "network", "inspect", name)
+	if err != nil {
+		return nil, fmt.Errorf("docker network inspect %s failed: %v: %s", name, err, stderr)
 	}
 	var arr []struct {
 		Name       string            `json:"Name"`
@@ -153,9 +325,14 @@ func (c *CLIClient) InspectNetwork(ctx context.Context, name string) (*NetworkCo
 				IPRange string `json:"IPRange"`
 			} `json:"Config"`
 		} `json:"IPAM"`
-		Labels map[string]string `json:"Labels"`
+		Labels     map[string]string `json:"Labels"`
+		Containers map[string]struct {
+			Name        string `json:"Name"`
+			IPv4Address string `json:"IPv4Address"`
+			IPv6Address string `json:"IPv6Address"`
+		} `json:"Containers"`
 	}
-	if err := json.Unmarshal(stdout.Bytes(), &arr); err != nil || len(arr) == 0 {
+	if err := json.Unmarshal(out, &arr); err != nil || len(arr) == 0 {
 		return nil, fmt.Errorf("parse network inspect for %s failed: %v", name, err)
 	}
 	nc := &NetworkConfig{
@@ -171,62 +348,219 @@ func (c *CLIClient) InspectNetwork(ctx context.Context, name string) (*NetworkCo
 	for _, c := range arr[0].IPAM.Config {
 		nc.IPAM.Config = append(nc.IPAM.Config, IPAMConfig{Subnet: c.Subnet, Gateway: c.Gateway, IPRange: c.IPRange})
 	}
+	for _, ct := range arr[0].Containers {
+		// addresses are reported with their prefix length, 172.18.0.2/16
+		for _, cidr := range []string{ct.IPv4Address, ct.IPv6Address} {
+			if ip, _, err := net.ParseCIDR(cidr); err == nil {
+				if nc.InUse == nil {
+					nc.InUse = map[string]string{}
+				}
+				nc.InUse[ip.String()] = ct.Name
+			}
+		}
+	}
 	return nc, nil
 }
 
-func (c *CLIClient) ImportImage(ctx context.Context, tarPath string, ref string) (string, error) {
+func (c *CLIClient) ImportImage(ctx context.Context, tarPath string, ref string, labels map[string]string) (string, error) {
 	args := []string{"import"}
+	for _, kv := range labelArgs(labels) {
+		args = append(args, "--change", "LABEL "+strconv.Quote(kv))
+	}
+	var in *os.File
 	if tarPath != "" {
-		args = append(args, tarPath)
+		// fed through stdin, so the watchdog sees the upload making progress
+		f, err := os.Open(tarPath)
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = f.Close() }()
+		in = f
+		args = append(args, "-")
 	}
 	if ref != "" {
 		args = append(args, ref)
 	}
 	cmd := exec.CommandContext(ctx, "docker", args...)
+	if in != nil {
+		cmd.Stdin = in
+	}
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
+	if err := c.runLong(cmd); err != nil {
 		return "", fmt.Errorf("docker import failed: %v: %s", err, stderr.String())
 	}
 	imageID := strings.TrimSpace(stdout.String())
 	return imageID, nil
 }
 
-func (c *CLIClient) VolumeCreate(ctx context.Context, name string) error {
-	cmd := exec.CommandContext(ctx, "docker", "volume", "create", name)
+func (c *CLIClient) VolumeCreate(ctx context.Context, name string, labels map[string]string) error {
+	args := []string{"volume", "create"}
+	for _, kv := range labelArgs(labels) {
+		args = append(args, "--label", kv)
+	}
+	cmd := exec.CommandContext(ctx, "docker", append(args, name)...)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
+	if err := c.run(cmd); err != nil {
 		return fmt.Errorf("docker volume create %s failed: %v: %s", name, err, stderr.String())
 	}
 	return nil
 }
 
 func (c *CLIClient) ExtractTarGzToVolume(ctx context.Context, volumeName string, tarGzPath string, expectedRoot string) error {
-	// Mount the tar as read-only and the volume at /restore; then extract and copy contents
-	cmd := exec.CommandContext(
-		ctx,
-		"docker", "run", "--rm",
-		"-v", fmt.Sprintf("%s:/restore", volumeName),
-		"-v", fmt.Sprintf("%s:/in.tgz:ro", tarGzPath),
-		"alpine:3.19",
+	// Mount the tar as read-only and the volume at /restore; then extract and copy contents.
+	// The helper is named and labeled so it can be removed if the run is interrupted: killing
+	// the docker CLI does not stop the container.
+	name := fmt.Sprintf("dockerbackup-helper-%d-%d", os.Getpid(), helperSeq.Add(1))
+	cmd := exec.CommandContext(ctx, "docker", helperRunArgs(c.helperImage, name,
+		[]string{fmt.Sprintf("%s:/restore", volumeName), fmt.Sprintf("%s:/in.tgz:ro", tarGzPath)},
 		"sh", "-c",
-		fmt.Sprintf("set -e; mkdir -p /tmp/e /restore; tar -xzf /in.tgz -C /tmp/e; if [ -d /tmp/e/%s ]; then cp -a /tmp/e/%s/. /restore/; else cp -a /tmp/e/. /restore/; fi", expectedRoot, expectedRoot),
-	)
+		// verbose, so the watchdog sees it making progress; copied with tar for the same reason
+		fmt.Sprintf("set -e; mkdir -p /tmp/e /restore; tar -xzvf /in.tgz -C /tmp/e; rm -f /tmp/e/%s /tmp/e/%s; src=/tmp/e; if [ -d /tmp/e/%s ]; then src=/tmp/e/%s; fi; tar -C \"$src\" -cf - . | tar -C /restore -xvf -", archive.IndexEntryName, archive.SeekEntryName, expectedRoot, expectedRoot),
+	)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := c.runLong(cmd); err != nil {
+		if ctx.Err() != nil {
+			_ = c.run(exec.Command("docker", "rm", "-f", name))
+			return ctx.Err()
+		}
+		return internalerrors.ClassifyDaemonError(fmt.Errorf("extract to volume %s failed: %v: %s%s", volumeName, err, stderr.String(), c.helperHint(stderr.String())))
+	}
+	return nil
+}
+
+func (c *CLIClient) VolumeHasData(ctx context.Context, volumeName string) (bool, error) {
+	name := fmt.Sprintf("dockerbackup-helper-%d-%d", os.Getpid(), helperSeq.Add(1))
+	cmd := exec.CommandContext(ctx, "docker", helperRunArgs(c.helperImage, name, []string{fmt.Sprintf("%s:/v:ro", volumeName)}, "ls", "-A", "/v")...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := c.runLong(cmd); err != nil {
+		if ctx.Err() != nil {
+			_ = c.run(exec.Command("docker", "rm", "-f", name))
+			return false, ctx.Err()
+		}
+		return false, fmt.Errorf("list volume %s failed: %v: %s%s", volumeName, err, stderr.String(), c.helperHint(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()) != "", nil
+}
+
+func (c *CLIClient) ClearVolume(ctx context.Context, volumeName string) error {
+	name := fmt.Sprintf("dockerbackup-helper-%d-%d", os.Getpid(), helperSeq.Add(1))
+	cmd := exec.CommandContext(ctx, "docker", helperRunArgs(c.helperImage, name, []string{fmt.Sprintf("%s:/v", volumeName)}, "find", "/v", "-mindepth", "1", "-delete", "-print")...)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("extract to volume %s failed: %v: %s", volumeName, err, stderr.String())
+	if err := c.runLong(cmd); err != nil {
+		if ctx.Err() != nil {
+			_ = c.run(exec.Command("docker", "rm", "-f", name))
+			return ctx.Err()
+		}
+		return fmt.Errorf("clear volume %s failed: %v: %s%s", volumeName, err, stderr.String(), c.helperHint(stderr.String()))
 	}
 	return nil
 }
 
-func (c *CLIClient) CreateContainer(ctx context.Context, imageRef string, name string, mounts []Mount) (string, error) {
+func (c *CLIClient) ArchiveVolume(ctx context.Context, volumeName string, destTarGz string) error {
+	// a fresh helper name per attempt, since a failed one may still be going away
+	var name string
+	stderr, err := c.withRetry(ctx, func() (string, error) {
+		f, err := os.Create(destTarGz)
+		if err != nil {
+			return "", retry.Permanent(err)
+		}
+		defer func() { _ = f.Close() }()
+		name = fmt.Sprintf("dockerbackup-helper-%d-%d", os.Getpid(), helperSeq.Add(1))
+		cmd := exec.CommandContext(ctx, "docker", helperRunArgs(c.helperImage, name,
+			[]string{fmt.Sprintf("%s:/src/%s:ro", volumeName, volumeName)},
+			"tar", "-czf", "-", "-C", "/src", volumeName,
+		)...)
+		var stderr bytes.Buffer
+		cmd.Stdout = f
+		cmd.Stderr = &stderr
+		if err := c.runLong(cmd); err != nil {
+			return stderr.String(), err
+		}
+		return "", f.Close()
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			_ = c.run(exec.Command("docker", "rm", "-f", name))
+			return ctx.Err()
+		}
+		return internalerrors.ClassifyDaemonError(fmt.Errorf("archive volume %s failed: %v: %s%s", volumeName, err, stderr, c.helperHint(stderr)))
+	}
+	return nil
+}
+
+var helperSeq atomic.Int64
+
+// helperRunArgs builds the docker run command line of a helper container mounting volumes
+// (-v specs) and running command in image.
+func helperRunArgs(image, name string, volumes []string, command ...string) []string {
+	args := []string{"run", "--rm",
+		"--name", name,
+		"--label", HelperLabel + "=true",
+		"--security-opt", helperSecurityOpt,
+	}
+	for _, v := range volumes {
+		args = append(args, "-v", v)
+	}
+	return append(append(args, image), command...)
+}
+
+// helperHint explains a helper container that failed because its image could not be pulled,
+// as happens on hosts without access to Docker Hub.
+func (c *CLIClient) helperHint(stderr string) string {
+	if !strings.Contains(stderr, "Unable to find image") && !strings.Contains(stderr, "pull access denied") {
+		return ""
+	}
+	return fmt.Sprintf(" (the helper image %s is not available; load it with docker load or point %s or --helper-image at an image with sh, tar, cp and find, e.g. busybox)", c.helperImage, HelperImageEnv)
+}
+
+// labelArgs renders labels as sorted key=value pairs.
+func labelArgs(labels map[string]string) []string {
+	out := make([]string, 0, len(labels))
+	for k, v := range labels {
+		out = append(out, k+"="+v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// CreateContainer creates the container with docker create, translating opts into its flags.
+// Networks after the first are connected once the container exists.
+func (c *CLIClient) CreateContainer(ctx context.Context, imageRef string, name string, mounts []Mount, opts CreateOptions) (string, error) {
+	cmd := exec.CommandContext(ctx, "docker", createArgs(imageRef, name, mounts, opts)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := c.runLong(cmd); err != nil {
+		return "", internalerrors.ClassifyDaemonError(fmt.Errorf("docker create failed: %v: %s", err, stderr.String()))
+	}
+	containerID := strings.TrimSpace(stdout.String())
+	if opts.NetworkMode == "" && len(opts.Networks) > 1 {
+		for _, n := range opts.Networks[1:] {
+			if _, stderr, err := c.output(ctx, connectArgs(containerID, n)...); err != nil {
+				_ = c.run(exec.Command("docker", "rm", "-f", containerID))
+				return "", fmt.Errorf("docker network connect %s failed: %v: %s", n.Name, err, stderr)
+			}
+		}
+	}
+	return containerID, nil
+}
+
+// createArgs builds the docker create command line.
+func createArgs(imageRef string, name string, mounts []Mount, opts CreateOptions) []string {
 	args := []string{"create"}
 	if name != "" {
 		args = append(args, "--name", name)
 	}
+	for _, kv := range labelArgs(opts.Labels) {
+		args = append(args, "--label", kv)
+	}
 	for _, m := range mounts {
 		flag := "-v"
 		mode := "rw"
@@ -248,16 +582,74 @@ func (c *CLIClient) CreateContainer(ctx context.Context, imageRef string, name s
 		}
 		args = append(args, flag, spec)
 	}
+	for _, e := range opts.Env {
+		args = append(args, "-e", e)
+	}
+	if opts.User != "" {
+		args = append(args, "--user", opts.User)
+	}
+	if opts.WorkingDir != "" {
+		args = append(args, "--workdir", opts.WorkingDir)
+	}
+	for _, p := range opts.Ports {
+		spec := p.ContainerPort
+		if p.HostPort != "" || p.HostIP != "" {
+			spec = p.HostPort + ":" + spec
+		}
+		if p.HostIP != "" {
+			ip := p.HostIP
+			if strings.Contains(ip, ":") {
+				ip = "[" + ip + "]"
+			}
+			spec = ip + ":" + spec
+		}
+		args = append(args, "-p", spec)
+	}
+	for _, p := range opts.ExposedPorts {
+		args = append(args, "--expose", p)
+	}
+	if opts.RestartPolicy != "" {
+		args = append(args, "--restart", opts.RestartPolicy)
+	}
+	if opts.NetworkMode != "" {
+		args = append(args, "--network", opts.NetworkMode)
+	} else if len(opts.Networks) > 0 {
+		n := opts.Networks[0]
+		args = append(args, "--network", n.Name)
+		for _, a := range n.Aliases {
+			args = append(args, "--network-alias", a)
+		}
+		if n.IPv4 != "" {
+			args = append(args, "--ip", n.IPv4)
+		}
+		if n.IPv6 != "" {
+			args = append(args, "--ip6", n.IPv6)
+		}
+	}
+	// docker create takes a single --entrypoint; the rest of it goes before the command
+	var cmdArgs []string
+	if len(opts.Entrypoint) > 0 {
+		args = append(args, "--entrypoint", opts.Entrypoint[0])
+		cmdArgs = append(cmdArgs, opts.Entrypoint[1:]...)
+	}
+	cmdArgs = append(cmdArgs, opts.Cmd...)
 	args = append(args, imageRef)
-	cmd := exec.CommandContext(ctx, "docker", args...)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("docker create failed: %v: %s", err, stderr.String())
+	return append(args, cmdArgs...)
+}
+
+// connectArgs builds the docker network connect command line for n.
+func connectArgs(containerID string, n NetworkAttachment) []string {
+	args := []string{"network", "connect"}
+	for _, a := range n.Aliases {
+		args = append(args, "--alias", a)
 	}
-	containerID := strings.TrimSpace(stdout.String())
-	return containerID, nil
+	if n.IPv4 != "" {
+		args = append(args, "--ip", n.IPv4)
+	}
+	if n.IPv6 != "" {
+		args = append(args, "--ip6", n.IPv6)
+	}
+	return append(args, n.Name, containerID)
 }
 
 func (c *CLIClient) CreateContainerFromSpec(ctx context.Context, cfg *container.Config, hostCfg *container.HostConfig, netCfg *network.NetworkingConfig, name string) (string, error) {
@@ -268,8 +660,49 @@ func (c *CLIClient) StartContainer(ctx context.Context, containerID string) erro
 	cmd := exec.CommandContext(ctx, "docker", "start", containerID)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker start failed: %v: %s", err, stderr.String())
+	if err := c.run(cmd); err != nil {
+		return internalerrors.ClassifyDaemonError(fmt.Errorf("docker start failed: %v: %s", err, stderr.String()))
+	}
+	return nil
+}
+
+func (c *CLIClient) PauseContainer(ctx context.Context, containerID string) error {
+	cmd := exec.CommandContext(ctx, "docker", "pause", containerID)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := c.run(cmd); err != nil {
+		return fmt.Errorf("docker pause failed: %v: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func (c *CLIClient) RenameContainer(ctx context.Context, containerID, newName string) error {
+	cmd := exec.CommandContext(ctx, "docker", "rename", containerID, newName)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := c.run(cmd); err != nil {
+		return fmt.Errorf("docker rename failed: %v: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func (c *CLIClient) StopContainer(ctx context.Context, containerID string) error {
+	cmd := exec.CommandContext(ctx, "docker", "stop", containerID)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := c.run(cmd); err != nil {
+		return fmt.Errorf("docker stop failed: %v: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// RemoveContainer force-removes a container (volumes are kept).
+func (c *CLIClient) RemoveContainer(ctx context.Context, containerID string) error {
+	cmd := exec.CommandContext(ctx, "docker", "rm", "-f", containerID)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := c.run(cmd); err != nil {
+		return fmt.Errorf("docker rm failed: %v: %s", err, stderr.String())
 	}
 	return nil
 }
@@ -283,50 +716,115 @@ func (c *CLIClient) EnsureNetwork(ctx context.Context, cfg NetworkConfig) error
 }
 
 func (c *CLIClient) ImageSave(ctx context.Context, imageRef string, destTarPath string) error {
+	return c.ImageSaveAll(ctx, []string{imageRef}, destTarPath)
+}
+
+func (c *CLIClient) ImageSaveAll(ctx context.Context, imageRefs []string, destTarPath string) error {
+	if len(imageRefs) == 0 {
+		return fmt.Errorf("no images to save")
+	}
 	if err := os.MkdirAll(filepath.Dir(destTarPath), 0o755); err != nil {
 		return err
 	}
-	f, err := os.Create(destTarPath)
-	if err != nil {
-		return err
+	// the sum overestimates when layers are shared; it only paces the progress line
+	var total int64
+	for _, ref := range imageRefs {
+		total += c.imageSizeEstimate(ctx, ref)
 	}
-	defer func() { _ = f.Close() }()
-	cmd := exec.CommandContext(ctx, "docker", "save", imageRef)
-	cmd.Stdout = f
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker save %s failed: %v: %s", imageRef, err, stderr.String())
+	what := strings.Join(imageRefs, " ")
+	prog := c.newProgress("docker save "+what, total)
+	if stderr, err := c.outputToFile(ctx, destTarPath, prog, append([]string{"save"}, imageRefs...)...); err != nil {
+		return fmt.Errorf("docker save %s failed: %v: %s", what, err, stderr)
 	}
 	return nil
 }
 
 func (c *CLIClient) ImageLoad(ctx context.Context, tarPath string) error {
-	cmd := exec.CommandContext(ctx, "docker", "load", "-i", tarPath)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker load failed: %v: %s", err, stderr.String())
+	// fed through stdin, so the watchdog sees the upload making progress
+	stderr, err := c.withRetry(ctx, func() (string, error) {
+		f, err := os.Open(tarPath)
+		if err != nil {
+			return "", retry.Permanent(err)
+		}
+		defer func() { _ = f.Close() }()
+		cmd := exec.CommandContext(ctx, "docker", "load")
+		cmd.Stdin = f
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		err = c.runLong(cmd)
+		return stderr.String(), err
+	})
+	if err != nil {
+		return internalerrors.ClassifyDaemonError(fmt.Errorf("docker load failed: %v: %s", err, stderr))
+	}
+	return nil
+}
+
+// EnsureImage pulls ref unless it is already present locally.
+func (c *CLIClient) EnsureImage(ctx context.Context, ref string) error {
+	if err := c.run(exec.CommandContext(ctx, "docker", "image", "inspect", ref)); err == nil {
+		return nil
+	}
+	if _, stderr, err := c.outputLong(ctx, "pull", ref); err != nil {
+		return fmt.Errorf("docker pull %s failed: %v: %s", ref, err, stderr)
 	}
 	return nil
 }
 
+func (c *CLIClient) InspectImage(ctx context.Context, ref string) (*ImageInfo, error) {
+	out, stderr, err := c.output(ctx, "image", "inspect", ref)
+	if err != nil {
+		return nil, fmt.Errorf("docker image inspect %s failed: %v: %s", ref, err, stderr)
+	}
+	var arr []struct {
+		ID           string   `json:"Id"`
+		RepoTags     []string `json:"RepoTags"`
+		RepoDigests  []string `json:"RepoDigests"`
+		Created      string   `json:"Created"`
+		Os           string   `json:"Os"`
+		Architecture string   `json:"Architecture"`
+		Variant      string   `json:"Variant"`
+		Size         int64    `json:"Size"`
+		Config       struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"Config"`
+	}
+	if err := json.Unmarshal(out, &arr); err != nil || len(arr) == 0 {
+		return nil, fmt.Errorf("parse image inspect for %s failed: %v", ref, err)
+	}
+	a := arr[0]
+	return &ImageInfo{
+		ID:           a.ID,
+		RepoTags:     a.RepoTags,
+		RepoDigests:  a.RepoDigests,
+		Created:      a.Created,
+		Os:           a.Os,
+		Architecture: a.Architecture,
+		Variant:      a.Variant,
+		Size:         a.Size,
+		Labels:       a.Config.Labels,
+	}, nil
+}
+
 func (c *CLIClient) TagImage(ctx context.Context, sourceRef, targetRef string) error {
 	cmd := exec.CommandContext(ctx, "docker", "tag", sourceRef, targetRef)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
+	if err := c.run(cmd); err != nil {
 		return fmt.Errorf("docker tag %s %s failed: %v: %s", sourceRef, targetRef, err, stderr.String())
 	}
 	return nil
 }
 
+// HostIPs returns the addresses port bindings can use on this host: the IPv4 and IPv6
+// addresses of its interfaces, including loopback, and the wildcards 0.0.0.0 and ::.
+// IPv6 link-local addresses are left out, as binding them needs a zone.
 func (c *CLIClient) HostIPs(ctx context.Context) ([]string, error) {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
 		return nil, err
 	}
-	ips := []string{}
+	ips := []string{"0.0.0.0", "::"}
 	for _, a := range addrs {
 		var ip net.IP
 		switch v := a.(type) {
@@ -335,27 +833,138 @@ func (c *CLIClient) HostIPs(ctx context.Context) ([]string, error) {
 		case *net.IPAddr:
 			ip = v.IP
 		}
-		if ip == nil || ip.IsLoopback() {
+		if ip == nil || ip.IsLinkLocalUnicast() {
 			continue
 		}
-		ip = ip.To4()
-		if ip == nil {
-			continue
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
 		}
 		ips = append(ips, ip.String())
 	}
 	return ips, nil
 }
 
-func (c *CLIClient) ContainerState(ctx context.Context, containerID string) (string, string, error) {
-	cmd := exec.CommandContext(ctx, "docker", "inspect", containerID, "--format", "{{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{end}}")
+// LogDrivers returns the log drivers (built-in and plugins) available on the daemon.
+func (c *CLIClient) LogDrivers(ctx context.Context) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "docker", "info", "--format", "{{json .Plugins.Log}}")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := c.run(cmd); err != nil {
+		return nil, fmt.Errorf("docker info failed: %v: %s", err, stderr.String())
+	}
+	var drivers []string
+	if err := json.Unmarshal(bytes.TrimSpace(stdout.Bytes()), &drivers); err != nil {
+		return nil, fmt.Errorf("parse docker info log plugins failed: %v", err)
+	}
+	return drivers, nil
+}
+
+func (c *CLIClient) VolumeDrivers(ctx context.Context) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "docker", "info", "--format", "{{json .Plugins.Volume}}")
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return "", "", fmt.Errorf("docker inspect state %s failed: %v: %s", containerID, err, stderr.String())
+	if err := c.run(cmd); err != nil {
+		return nil, fmt.Errorf("docker info failed: %v: %s", err, stderr.String())
+	}
+	var drivers []string
+	if err := json.Unmarshal(bytes.TrimSpace(stdout.Bytes()), &drivers); err != nil {
+		return nil, fmt.Errorf("parse docker info volume plugins failed: %v", err)
+	}
+	return drivers, nil
+}
+
+func (c *CLIClient) InstallPlugin(ctx context.Context, ref string, alias string, settings []string) error {
+	args := []string{"plugin", "install", "--grant-all-permissions"}
+	if alias != "" && alias != ref {
+		args = append(args, "--alias", alias)
+	}
+	args = append(args, ref)
+	args = append(args, settings...)
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := c.runLong(cmd); err != nil {
+		return fmt.Errorf("docker plugin install %s failed: %v: %s", ref, err, stderr.String())
 	}
-	parts := strings.Fields(strings.TrimSpace(stdout.String()))
+	return nil
+}
+
+// Runtimes returns the OCI runtimes registered with the daemon (e.g. runc, nvidia).
+func (c *CLIClient) Runtimes(ctx context.Context) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "docker", "info", "--format", "{{json .Runtimes}}")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := c.run(cmd); err != nil {
+		return nil, fmt.Errorf("docker info failed: %v: %s", err, stderr.String())
+	}
+	var runtimes map[string]json.RawMessage
+	if err := json.Unmarshal(bytes.TrimSpace(stdout.Bytes()), &runtimes); err != nil {
+		return nil, fmt.Errorf("parse docker info runtimes failed: %v", err)
+	}
+	names := make([]string, 0, len(runtimes))
+	for name := range runtimes {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (c *CLIClient) DaemonInfo(ctx context.Context) ([]byte, error) {
+	out, stderr, err := c.output(ctx, "info", "--format", "{{json .}}")
+	if err != nil {
+		return nil, fmt.Errorf("docker info failed: %v: %s", err, stderr)
+	}
+	return bytes.TrimSpace(out), nil
+}
+
+func (c *CLIClient) ListPlugins(ctx context.Context) ([]PluginInfo, error) {
+	cmd := exec.CommandContext(ctx, "docker", "plugin", "ls", "-q", "--no-trunc")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := c.run(cmd); err != nil {
+		return nil, fmt.Errorf("docker plugin ls failed: %v: %s", err, stderr.String())
+	}
+	ids := strings.Fields(stdout.String())
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	stdout.Reset()
+	stderr.Reset()
+	cmd = exec.CommandContext(ctx, "docker", append([]string{"plugin", "inspect"}, ids...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := c.run(cmd); err != nil {
+		return nil, fmt.Errorf("docker plugin inspect failed: %v: %s", err, stderr.String())
+	}
+	var arr []struct {
+		ID              string `json:"Id"`
+		Name            string `json:"Name"`
+		PluginReference string `json:"PluginReference"`
+		Enabled         bool   `json:"Enabled"`
+		Settings        struct {
+			Env  []string `json:"Env"`
+			Args []string `json:"Args"`
+		} `json:"Settings"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &arr); err != nil {
+		return nil, fmt.Errorf("parse plugin inspect failed: %v", err)
+	}
+	out := make([]PluginInfo, 0, len(arr))
+	for _, p := range arr {
+		out = append(out, PluginInfo{ID: p.ID, Name: p.Name, Reference: p.PluginReference, Enabled: p.Enabled, Env: p.Settings.Env, Args: p.Settings.Args})
+	}
+	return out, nil
+}
+
+func (c *CLIClient) ContainerState(ctx context.Context, containerID string) (string, string, error) {
+	out, stderr, err := c.output(ctx, "inspect", containerID, "--format", "{{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{end}}")
+	if err != nil {
+		return "", "", fmt.Errorf("docker inspect state %s failed: %v: %s", containerID, err, stderr)
+	}
+	parts := strings.Fields(strings.TrimSpace(string(out)))
 	if len(parts) == 0 {
 		return "", "", nil
 	}
@@ -370,7 +979,7 @@ func (c *CLIClient) ListProjectContainers(ctx context.Context, project string) (
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
+	if err := c.run(cmd); err != nil {
 		return nil, fmt.Errorf("docker ps compose filter failed: %v: %s", err, stderr.String())
 	}
 	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
@@ -390,17 +999,17 @@ func (c *CLIClient) ListProjectContainers(ctx context.Context, project string) (
 		if len(us) >= 3 && us[0] == project {
 			svc = us[1]
 		}
-		refs = append(refs, ProjectContainerRef{Service: svc, ID: id, ContainerName: name})
+		refs = append(refs, ProjectContainerRef{Service: svc, ID: id, ContainerName: name, Number: containerNumber("", name)})
 	}
 	return refs, nil
 }
 
 func (c *CLIClient) ListProjectContainersByLabel(ctx context.Context, project string) ([]ProjectContainerRef, error) {
-	cmd := exec.CommandContext(ctx, "docker", "ps", "-a", "--filter", "label=com.docker.compose.project="+project, "--format", "{{.ID}}\t{{.Names}}")
+	cmd := exec.CommandContext(ctx, "docker", "ps", "-a", "--filter", "label=com.docker.compose.project="+project, "--format", "{{.ID}}\t{{.Names}}\t{{.Label \"com.docker.compose.service\"}}\t{{.Label \"com.docker.compose.container-number\"}}")
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
+	if err := c.run(cmd); err != nil {
 		return nil, fmt.Errorf("docker ps compose label failed: %v: %s", err, stderr.String())
 	}
 	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
@@ -409,18 +1018,213 @@ func (c *CLIClient) ListProjectContainersByLabel(ctx context.Context, project st
 		if strings.TrimSpace(line) == "" {
 			continue
 		}
-		parts := strings.SplitN(line, "\t", 2)
-		if len(parts) != 2 {
+		parts := strings.SplitN(line, "\t", 4)
+		if len(parts) < 2 {
 			continue
 		}
 		id := parts[0]
 		name := parts[1]
 		svc := name
 		us := strings.Split(name, "_")
-		if len(us) >= 3 && us[0] == project {
+		if len(parts) >= 3 && parts[2] != "" {
+			svc = parts[2]
+		} else if len(us) >= 3 && us[0] == project {
 			svc = us[1]
 		}
-		refs = append(refs, ProjectContainerRef{Service: svc, ID: id, ContainerName: name})
+		number := ""
+		if len(parts) == 4 {
+			number = parts[3]
+		}
+		refs = append(refs, ProjectContainerRef{Service: svc, ID: id, ContainerName: name, Number: containerNumber(number, name)})
+	}
+	return refs, nil
+}
+
+// ComposeUp runs `docker compose up -d` (v2 plugin) for the project in projectDir, with
+// --scale for the services in scale.
+func (c *CLIClient) ComposeUp(ctx context.Context, projectDir string, projectName string, scale map[string]int) error {
+	args := []string{"compose", "--project-directory", projectDir}
+	if projectName != "" {
+		args = append(args, "-p", projectName)
+	}
+	args = append(args, "up", "-d")
+	services := make([]string, 0, len(scale))
+	for svc := range scale {
+		services = append(services, svc)
+	}
+	sort.Strings(services)
+	for _, svc := range services {
+		args = append(args, "--scale", fmt.Sprintf("%s=%d", svc, scale[svc]))
+	}
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Dir = projectDir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := c.runLong(cmd); err != nil {
+		return fmt.Errorf("docker compose up failed: %v: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// CheckpointCreate dumps the process state of a running container into
+// checkpointDir/<name>. Unless leaveRunning is set the container is stopped afterwards.
+func (c *CLIClient) CheckpointCreate(ctx context.Context, containerID, name, checkpointDir string, leaveRunning bool) error {
+	args := []string{"checkpoint", "create", "--checkpoint-dir", checkpointDir}
+	if leaveRunning {
+		args = append(args, "--leave-running")
+	}
+	args = append(args, containerID, name)
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := c.runLong(cmd); err != nil {
+		return fmt.Errorf("docker checkpoint create failed: %v: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// StartContainerFromCheckpoint starts a created container by restoring the named checkpoint
+// from checkpointDir instead of running its entrypoint.
+func (c *CLIClient) StartContainerFromCheckpoint(ctx context.Context, containerID, name, checkpointDir string) error {
+	cmd := exec.CommandContext(ctx, "docker", "start", "--checkpoint", name, "--checkpoint-dir", checkpointDir, containerID)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := c.runLong(cmd); err != nil {
+		return internalerrors.ClassifyDaemonError(fmt.Errorf("docker start from checkpoint failed: %v: %s", err, stderr.String()))
+	}
+	return nil
+}
+
+func (c *CLIClient) RemoveVolume(ctx context.Context, name string) error {
+	cmd := exec.CommandContext(ctx, "docker", "volume", "rm", "-f", name)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := c.run(cmd); err != nil {
+		return fmt.Errorf("docker volume rm %s failed: %v: %s", name, err, stderr.String())
+	}
+	return nil
+}
+
+func (c *CLIClient) RemoveNetwork(ctx context.Context, name string) error {
+	cmd := exec.CommandContext(ctx, "docker", "network", "rm", name)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := c.run(cmd); err != nil {
+		return fmt.Errorf("docker network rm %s failed: %v: %s", name, err, stderr.String())
+	}
+	return nil
+}
+
+// ListContainersByLabel lists all containers (running or not) carrying label (key or key=value).
+func (c *CLIClient) ListContainersByLabel(ctx context.Context, label string) ([]ContainerRef, error) {
+	refs, err := c.listContainers(ctx, "-a", "--filter", "label="+label)
+	if err != nil {
+		return nil, fmt.Errorf("docker ps label filter failed: %w", err)
+	}
+	return refs, nil
+}
+
+// ListRunningContainers lists the running containers.
+func (c *CLIClient) ListRunningContainers(ctx context.Context) ([]ContainerRef, error) {
+	refs, err := c.listContainers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("docker ps failed: %w", err)
+	}
+	return refs, nil
+}
+
+func (c *CLIClient) listContainers(ctx context.Context, args ...string) ([]ContainerRef, error) {
+	args = append([]string{"ps"}, args...)
+	cmd := exec.CommandContext(ctx, "docker", append(args, "--format", `{{.ID}}\t{{.Names}}\t{{.State}}\t{{.Label "com.docker.compose.project"}}`)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := c.run(cmd); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, stderr.String())
+	}
+	refs := []ContainerRef{}
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		parts := strings.SplitN(line, "\t", 4)
+		if len(parts) < 3 {
+			continue
+		}
+		ref := ContainerRef{ID: parts[0], Name: parts[1], State: parts[2]}
+		if len(parts) == 4 {
+			ref.Project = parts[3]
+		}
+		refs = append(refs, ref)
 	}
 	return refs, nil
 }
+
+// ListResourcesByLabel lists containers, volumes, networks and images carrying label (key or
+// key=value), together with all their labels.
+func (c *CLIClient) ListResourcesByLabel(ctx context.Context, label string) ([]LabeledResource, error) {
+	var out []LabeledResource
+	for _, kind := range []string{"container", "volume", "network", "image"} {
+		ids, err := c.listIDsByLabel(ctx, kind, label)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		args := append([]string{kind, "inspect"}, ids...)
+		cmd := exec.CommandContext(ctx, "docker", args...)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := c.run(cmd); err != nil {
+			return nil, fmt.Errorf("docker %s inspect failed: %v: %s", kind, err, stderr.String())
+		}
+		var docs []struct {
+			ID       string            `json:"Id"`
+			Name     string            `json:"Name"`
+			RepoTags []string          `json:"RepoTags"`
+			Labels   map[string]string `json:"Labels"`
+			Config   *struct {
+				Labels map[string]string `json:"Labels"`
+			} `json:"Config"`
+		}
+		if err := json.Unmarshal(stdout.Bytes(), &docs); err != nil {
+			return nil, fmt.Errorf("parse docker %s inspect: %w", kind, err)
+		}
+		for _, d := range docs {
+			r := LabeledResource{Kind: kind, ID: d.ID, Name: strings.TrimPrefix(d.Name, "/"), Labels: d.Labels}
+			if d.Config != nil && d.Config.Labels != nil {
+				r.Labels = d.Config.Labels
+			}
+			if kind == "volume" {
+				r.ID = d.Name
+			}
+			if kind == "image" && len(d.RepoTags) > 0 {
+				r.Name = d.RepoTags[0]
+			}
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func (c *CLIClient) listIDsByLabel(ctx context.Context, kind, label string) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "docker", kind, "ls", "-q", "--filter", "label="+label)
+	if kind == "container" {
+		cmd.Args = append(cmd.Args, "-a")
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := c.run(cmd); err != nil {
+		return nil, fmt.Errorf("docker %s ls label filter failed: %v: %s", kind, err, stderr.String())
+	}
+	seen := map[string]struct{}{}
+	var ids []string
+	for _, id := range strings.Fields(stdout.String()) {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,196 @@
+package docker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	internalerrors "github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/internal/logger"
+
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestCreateArgs(t *testing.T) {
+	opts := CreateOptions{
+		Labels:        map[string]string{"app": "web"},
+		Env:           []string{"A=1"},
+		Entrypoint:    []string{"/bin/sh", "-c"},
+		Cmd:           []string{"run"},
+		WorkingDir:    "/srv",
+		Ports:         []PortBinding{{HostIP: "127.0.0.1", HostPort: "8080", ContainerPort: "80/tcp"}, {HostIP: "::1", HostPort: "53", ContainerPort: "53/udp"}, {ContainerPort: "9000/tcp"}},
+		ExposedPorts:  []string{"9100/tcp"},
+		RestartPolicy: "on-failure:3",
+		Networks:      []NetworkAttachment{{Name: "front", Aliases: []string{"web"}, IPv4: "10.0.0.5"}, {Name: "back"}},
+	}
+	got := strings.Join(createArgs("app:1", "web", []Mount{{Type: "volume", Name: "data", Destination: "/data", RW: true}}, opts), " ")
+	want := "create --name web --label app=web -v data:/data:rw -e A=1 --workdir /srv -p 127.0.0.1:8080:80/tcp -p [::1]:53:53/udp -p 9000/tcp --expose 9100/tcp --restart on-failure:3 --network front --network-alias web --ip 10.0.0.5 --entrypoint /bin/sh app:1 -c run"
+	if got != want {
+		t.Fatalf("args = %s\nwant   %s", got, want)
+	}
+	if got := strings.Join(createArgs("app:1", "", nil, CreateOptions{NetworkMode: "host", Networks: []NetworkAttachment{{Name: "front"}}}), " "); got != "create --network host app:1" {
+		t.Fatalf("host network args = %s", got)
+	}
+	if got := strings.Join(connectArgs("abc", NetworkAttachment{Name: "back", Aliases: []string{"api"}, IPv6: "fd00::5"}), " "); got != "network connect --alias api --ip6 fd00::5 back abc" {
+		t.Fatalf("connect args = %s", got)
+	}
+}
+
+func TestEndpointOrder(t *testing.T) {
+	eps := map[string]*network.EndpointSettings{"zeta": nil, "front": nil, "back": nil}
+	if got := strings.Join(EndpointOrder("zeta", eps), ","); got != "zeta,back,front" {
+		t.Fatalf("order = %s", got)
+	}
+	if got := strings.Join(EndpointOrder("bridge", eps), ","); got != "back,front,zeta" {
+		t.Fatalf("order without mode network = %s", got)
+	}
+}
+
+func TestHelperImage(t *testing.T) {
+	t.Setenv(HelperImageEnv, "")
+	if got := HelperImage(); got != DefaultHelperImage {
+		t.Fatalf("default helper image = %s", got)
+	}
+	t.Setenv(HelperImageEnv, "registry.local/busybox:1.36")
+	c := NewCLIClient().(*CLIClient)
+	got := strings.Join(helperRunArgs(c.helperImage, "h1", []string{"data:/v:ro"}, "ls", "-A", "/v"), " ")
+	want := "run --rm --name h1 --label dockerbackup.helper=true --security-opt label=disable -v data:/v:ro registry.local/busybox:1.36 ls -A /v"
+	if got != want {
+		t.Fatalf("args = %s\nwant   %s", got, want)
+	}
+	if hint := c.helperHint("Unable to find image 'registry.local/busybox:1.36' locally"); !strings.Contains(hint, "registry.local/busybox:1.36 is not available") {
+		t.Fatalf("hint = %q", hint)
+	}
+	if hint := c.helperHint("no space left on device"); hint != "" {
+		t.Fatalf("unexpected hint %q", hint)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	c := &CLIClient{opTimeout: 300 * time.Millisecond}
+	var te *TimeoutError
+	// a quick command is bounded by the timeout however much it prints
+	err := c.run(exec.Command("sh", "-c", "while :; do echo; sleep 0.05; done"))
+	if !errors.As(err, &te) || te.Stalled {
+		t.Fatalf("run: %v", err)
+	}
+	// a streaming one only while it makes no progress
+	if err := c.runLong(exec.Command("sh", "-c", "for i in 1 2 3 4 5 6 7 8; do echo; sleep 0.1; done")); err != nil {
+		t.Fatalf("runLong with progress: %v", err)
+	}
+	err = c.runLong(exec.Command("sh", "-c", "echo; exec sleep 5"))
+	if !errors.As(err, &te) || !te.Stalled {
+		t.Fatalf("runLong stalled: %v", err)
+	}
+	c.opTimeout = 0
+	if err := c.run(exec.Command("sh", "-c", "sleep 0.4")); err != nil {
+		t.Fatalf("disabled: %v", err)
+	}
+}
+
+// recordedLines are the debug lines of a recordingLogger and the loggers derived from it with
+// With; stdout and stderr are logged from different goroutines.
+type recordedLines struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (r *recordedLines) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.lines, "\n")
+}
+
+// recordingLogger keeps the debug lines logged through it.
+type recordingLogger struct {
+	prefix string
+	lines  *recordedLines
+}
+
+func (l recordingLogger) Infof(format string, args ...any)  {}
+func (l recordingLogger) Errorf(format string, args ...any) {}
+func (l recordingLogger) Debugf(format string, args ...any) {
+	l.lines.mu.Lock()
+	defer l.lines.mu.Unlock()
+	l.lines.lines = append(l.lines.lines, l.prefix+fmt.Sprintf(format, args...))
+}
+func (l recordingLogger) With(key string, value any) logger.Logger {
+	return recordingLogger{prefix: fmt.Sprintf("%s[%s=%v] ", l.prefix, key, value), lines: l.lines}
+}
+
+func TestCommandOutputLogged(t *testing.T) {
+	var lines recordedLines
+	c := &CLIClient{log: recordingLogger{lines: &lines}}
+	var stdout bytes.Buffer
+	cmd := exec.Command("sh", "-c", "echo extracted a; echo extracted b; echo 'tar: short read' >&2; exit 2")
+	cmd.Stdout = &stdout
+	if err := c.runLong(cmd); err == nil {
+		t.Fatal("expected the exit status")
+	}
+	if stdout.String() != "extracted a\nextracted b\n" {
+		t.Fatalf("stdout not passed on: %q", stdout.String())
+	}
+	got := lines.String()
+	for _, want := range []string{"[docker=-c] stdout: extracted b", "[docker=-c] stderr: tar: short read", "Failed after"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("debug log lacks %q:\n%s", want, got)
+		}
+	}
+	if got := strings.Join(redactArgs([]string{"docker", "create", "-e", "DB_PASSWORD=hunter2", "-e", "TZ", "app:1"}), " "); got != "docker create -e DB_PASSWORD=*** -e TZ app:1" {
+		t.Fatalf("redacted = %s", got)
+	}
+	if got := commandName([]string{"docker", "volume", "rm", "-f", "data"}); got != "volume rm" {
+		t.Fatalf("command name = %s", got)
+	}
+}
+
+func TestDaemonErrorsClassified(t *testing.T) {
+	cases := []struct {
+		msg     string
+		kind    internalerrors.DaemonErrorKind
+		subject string
+	}{
+		{`docker create failed: exit status 125: docker: Error response from daemon: Conflict. The container name "/web" is already in use by container "3f2a". You have to remove (or rename) that container to be able to reuse that name.`, internalerrors.ErrNameConflict, "web"},
+		{`docker start failed: exit status 1: Error response from daemon: driver failed programming external connectivity on endpoint web: Bind for 0.0.0.0:8080 failed: port is already allocated`, internalerrors.ErrPortAllocated, "0.0.0.0:8080"},
+		{`Error response from daemon: driver failed programming external connectivity on endpoint web: Error starting userland proxy: listen tcp4 0.0.0.0:80: bind: address already in use`, internalerrors.ErrPortAllocated, "0.0.0.0:80"},
+		{`Error response from daemon: -o parent interface was not found on the host: eth1`, internalerrors.ErrParentInterface, "eth1"},
+		{`extract to volume data failed: exit status 1: tar: write error: No space left on device`, internalerrors.ErrNoSpace, ""},
+		{`docker export web failed: exit status 1: Error response from daemon: No such container: web`, "", ""},
+	}
+	for _, c := range cases {
+		// as the engine wraps them
+		err := &internalerrors.OperationError{Op: "restore", Err: errors.New(c.msg)}
+		de, ok := internalerrors.AsDaemonError(err)
+		if c.kind == "" {
+			if ok {
+				t.Fatalf("%q classified as %s", c.msg, de.Kind)
+			}
+			continue
+		}
+		if !ok || de.Kind != c.kind || de.Subject != c.subject {
+			t.Fatalf("%q: got %+v", c.msg, de)
+		}
+	}
+}
+
+func TestContainerNumber(t *testing.T) {
+	for _, tc := range []struct {
+		label, name string
+		want        int
+	}{
+		{"2", "shop-web-2", 2},
+		{"", "shop-web-3", 3},
+		{"", "shop_web_1", 1},
+		{"x", "shop-web", 0},
+		{"", "web", 0},
+	} {
+		if got := containerNumber(tc.label, tc.name); got != tc.want {
+			t.Errorf("containerNumber(%q, %q) = %d, want %d", tc.label, tc.name, got, tc.want)
+		}
+	}
+}
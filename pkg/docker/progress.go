@@ -0,0 +1,100 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/storage"
+)
+
+// progressInterval is how often a long docker export or save reports how far it got.
+var progressInterval = 10 * time.Second
+
+// progress counts the bytes a docker command writes and periodically logs them against an
+// estimated total. docker export and docker save print nothing while they run, and for large
+// containers or images they are the longest stages of a backup.
+type progress struct {
+	log   logger.Logger
+	what  string
+	total int64        // estimated size in bytes, 0 when unknown
+	start atomic.Int64 // unix nanoseconds of the current attempt's start
+	n     atomic.Int64
+}
+
+func newProgress(log logger.Logger, what string, total int64) *progress {
+	p := &progress{log: log, what: what, total: total}
+	p.start.Store(time.Now().UnixNano())
+	return p
+}
+
+// writer counts what is written to w.
+func (p *progress) writer(w io.Writer) io.Writer {
+	return &countingWriter{w: w, n: &p.n}
+}
+
+// reset starts counting again, for a retried attempt.
+func (p *progress) reset() {
+	p.n.Store(0)
+	p.start.Store(time.Now().UnixNano())
+}
+
+// run logs the progress every progressInterval until ctx is done.
+func (p *progress) run(ctx context.Context) {
+	t := time.NewTicker(progressInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			p.log.Infof("%s", p.line(time.Since(time.Unix(0, p.start.Load()))))
+		}
+	}
+}
+
+// line describes the progress after elapsed, e.g.
+// "docker export web: 1.2 GiB of ~3.0 GiB (40%), about 1m30s left".
+func (p *progress) line(elapsed time.Duration) string {
+	done := p.n.Load()
+	if p.total <= 0 {
+		return fmt.Sprintf("%s: %s written", p.what, storage.FormatSize(done))
+	}
+	s := fmt.Sprintf("%s: %s of ~%s", p.what, storage.FormatSize(done), storage.FormatSize(p.total))
+	if done >= p.total {
+		// the estimate was low; there is no sensible ETA
+		return s
+	}
+	s += fmt.Sprintf(" (%d%%)", done*100/p.total)
+	if done > 0 && elapsed > 0 {
+		left := time.Duration(float64(elapsed) * float64(p.total-done) / float64(done))
+		s += fmt.Sprintf(", about %s left", left.Round(time.Second))
+	}
+	return s
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// parseSizeOutput parses a byte count printed by a docker --format template; anything else
+// (an error, "<no value>") yields 0.
+func parseSizeOutput(out []byte) int64 {
+	n, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
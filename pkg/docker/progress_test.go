@@ -0,0 +1,29 @@
+package docker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+)
+
+func TestProgress_Line(t *testing.T) {
+	p := newProgress(logger.New(), "docker export web", 4<<30)
+	p.n.Store(1 << 30)
+	if got, want := p.line(10*time.Second), "docker export web: 1.0 GiB of ~4.0 GiB (25%), about 30s left"; got != want {
+		t.Fatalf("line = %q, want %q", got, want)
+	}
+	p.n.Store(5 << 30)
+	if got, want := p.line(time.Minute), "docker export web: 5.0 GiB of ~4.0 GiB"; got != want {
+		t.Fatalf("line past the estimate = %q, want %q", got, want)
+	}
+	if got, want := newProgress(logger.New(), "docker save app", 0).line(time.Second), "docker save app: 0 B written"; got != want {
+		t.Fatalf("line without estimate = %q, want %q", got, want)
+	}
+}
+
+func TestParseSizeOutput(t *testing.T) {
+	if parseSizeOutput([]byte("12345\n")) != 12345 || parseSizeOutput([]byte("<no value>")) != 0 {
+		t.Fatalf("parseSizeOutput mismatch")
+	}
+}
@@ -2,9 +2,19 @@ package docker
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
 
+	internalerrors "github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/pkg/archive"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
@@ -22,14 +32,50 @@ func NewSDKClient() (*SDKClient, error) {
 	return &SDKClient{cli: cli}, nil
 }
 
+// CreateContainerFromSpec creates the container on its primary network and connects it to
+// the other networks in netCfg afterwards, with their aliases and addresses: daemons before
+// API 1.44 attach only one endpoint at creation.
 func (s *SDKClient) CreateContainerFromSpec(ctx context.Context, cfg *container.Config, hostCfg *container.HostConfig, netCfg *network.NetworkingConfig, name string) (string, error) {
+	var endpoints map[string]*network.EndpointSettings
+	var rest []string
+	if netCfg != nil && len(netCfg.EndpointsConfig) > 1 {
+		endpoints = netCfg.EndpointsConfig
+		order := EndpointOrder(string(hostCfg.NetworkMode), endpoints)
+		rest = order[1:]
+		netCfg = &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{order[0]: endpoints[order[0]]}}
+	}
 	resp, err := s.cli.ContainerCreate(ctx, cfg, hostCfg, netCfg, nil, name)
 	if err != nil {
-		return "", err
+		return "", internalerrors.ClassifyDaemonError(err)
+	}
+	for _, n := range rest {
+		if err := s.cli.NetworkConnect(ctx, n, resp.ID, endpoints[n]); err != nil {
+			_ = s.cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true})
+			return "", fmt.Errorf("connect to network %s: %w", n, err)
+		}
 	}
 	return resp.ID, nil
 }
 
+// EndpointOrder returns the networks of endpoints with the one named by the network mode (the
+// network the container was created on) first and the others sorted.
+func EndpointOrder(networkMode string, endpoints map[string]*network.EndpointSettings) []string {
+	names := make([]string, 0, len(endpoints))
+	for n := range endpoints {
+		names = append(names, n)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		if (a == networkMode) != (b == networkMode) {
+			if a == networkMode {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	return names
+}
+
 func (s *SDKClient) EnsureVolume(ctx context.Context, cfg VolumeConfig) error {
 	_, err := s.cli.VolumeInspect(ctx, cfg.Name)
 	if err == nil {
@@ -63,5 +109,75 @@ func (s *SDKClient) EnsureNetwork(ctx context.Context, cfg NetworkConfig) error
 		Labels:     cfg.Labels,
 		IPAM:       ipam,
 	})
-	return err
+	return internalerrors.ClassifyDaemonError(err)
+}
+
+// ExtractTarGzToVolume writes the data of a volume archive into a volume through the Engine
+// API: a helper container mounting the volume is created but never started, and the entries
+// under expectedRoot/ are streamed into it with PutArchive. Unlike the CLI client, nothing runs
+// in the container and the archive does not need to be on the daemon's host, so this works
+// against remote daemons. Owners are kept as recorded, shifted by the daemon's userns-remap.
+func (s *SDKClient) ExtractTarGzToVolume(ctx context.Context, volumeName string, tarGzPath string, expectedRoot string) error {
+	helper := HelperImage()
+	if err := s.ensureImage(ctx, helper); err != nil {
+		return fmt.Errorf("helper image %s is not available (load it with docker load or point %s or --helper-image at another): %w", helper, HelperImageEnv, err)
+	}
+	resp, err := s.cli.ContainerCreate(ctx,
+		&container.Config{Image: helper, Labels: map[string]string{HelperLabel: "true"}, Cmd: []string{"true"}},
+		&container.HostConfig{
+			Binds:       []string{volumeName + ":/restore"},
+			SecurityOpt: []string{helperSecurityOpt},
+			NetworkMode: "none",
+		},
+		nil, nil, fmt.Sprintf("dockerbackup-helper-%d-%d", os.Getpid(), helperSeq.Add(1)))
+	if err != nil {
+		return fmt.Errorf("create helper for volume %s: %w", volumeName, err)
+	}
+	// removed even when ctx is done, as the container outlives the request
+	defer func() {
+		_ = s.cli.ContainerRemove(context.Background(), resp.ID, container.RemoveOptions{Force: true})
+	}()
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(archive.RebaseTarGz(ctx, tarGzPath, expectedRoot, "restore", pw))
+	}()
+	err = s.cli.CopyToContainer(ctx, resp.ID, "/", pr, container.CopyToContainerOptions{})
+	_ = pr.CloseWithError(err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return internalerrors.ClassifyDaemonError(fmt.Errorf("extract to volume %s failed: %w", volumeName, err))
+	}
+	return nil
+}
+
+// ensureImage pulls ref unless the daemon already has it.
+func (s *SDKClient) ensureImage(ctx context.Context, ref string) error {
+	if _, _, err := s.cli.ImageInspectWithRaw(ctx, ref); err == nil {
+		return nil
+	} else if !client.IsErrNotFound(err) {
+		return err
+	}
+	rc, err := s.cli.ImagePull(ctx, ref, image.PullOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+	// the pull reports failures in its progress stream
+	dec := json.NewDecoder(rc)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&msg); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+	}
 }
@@ -2,6 +2,11 @@ package docker
 
 import (
 	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
 )
 
 // ContainerInfo captures minimal fields we need from `docker inspect` JSON
@@ -19,6 +24,8 @@ type Mount struct {
 	Destination string `json:"Destination"`
 	Type        string `json:"Type"`
 	RW          bool   `json:"RW"`
+	// Volume driver ("local" or a plugin such as rexray/ebs)
+	Driver string `json:"Driver,omitempty"`
 }
 
 func ParseContainerInfo(inspectJSON []byte) (ContainerInfo, error) {
@@ -36,12 +43,53 @@ func ParseContainerInfo(inspectJSON []byte) (ContainerInfo, error) {
 	return info, nil
 }
 
+// ParseContainerJSON decodes full docker inspect output in either single object or array form.
+func ParseContainerJSON(inspectJSON []byte) (types.ContainerJSON, error) {
+	var cj types.ContainerJSON
+	if err := json.Unmarshal(inspectJSON, &cj); err == nil && cj.ContainerJSONBase != nil {
+		return cj, nil
+	}
+	var arr []types.ContainerJSON
+	if err := json.Unmarshal(inspectJSON, &arr); err != nil {
+		return types.ContainerJSON{}, err
+	}
+	if len(arr) == 0 || arr[0].ContainerJSONBase == nil {
+		return types.ContainerJSON{}, ErrEmptyInspect
+	}
+	return arr[0], nil
+}
+
+// ImageInfo captures docker image inspect essentials
+type ImageInfo struct {
+	ID           string            `json:"Id"`
+	RepoTags     []string          `json:"RepoTags,omitempty"`
+	RepoDigests  []string          `json:"RepoDigests,omitempty"`
+	Created      string            `json:"Created,omitempty"`
+	Os           string            `json:"Os,omitempty"`
+	Architecture string            `json:"Architecture,omitempty"`
+	Variant      string            `json:"Variant,omitempty"`
+	Size         int64             `json:"Size,omitempty"` // unpacked
+	Labels       map[string]string `json:"Labels,omitempty"`
+}
+
+// PluginInfo captures docker plugin inspect essentials needed to reinstall a plugin
+type PluginInfo struct {
+	ID        string   `json:"Id"`
+	Name      string   `json:"Name"`
+	Reference string   `json:"Reference,omitempty"`
+	Enabled   bool     `json:"Enabled"`
+	Env       []string `json:"Env,omitempty"`
+	Args      []string `json:"Args,omitempty"`
+}
+
 // VolumeConfig captures docker volume inspect essentials
 type VolumeConfig struct {
 	Name    string            `json:"Name"`
 	Driver  string            `json:"Driver"`
 	Options map[string]string `json:"Options"`
 	Labels  map[string]string `json:"Labels"`
+	// Mountpoint is where the daemon keeps the volume's data; it is not recorded in backups
+	Mountpoint string `json:"-"`
 }
 
 // NetworkConfig captures docker network inspect essentials
@@ -54,6 +102,9 @@ type NetworkConfig struct {
 	Ingress    bool              `json:"Ingress"`
 	IPAM       IPAM              `json:"IPAM"`
 	Labels     map[string]string `json:"Labels"`
+	// InUse maps the container addresses allocated on the network when it was inspected to
+	// the names of the containers holding them; it is not saved in backups
+	InUse map[string]string `json:"-"`
 }
 
 type IPAM struct {
@@ -67,9 +118,104 @@ type IPAMConfig struct {
 	IPRange string `json:"IPRange"`
 }
 
+// CreateOptions are the container settings docker create is given besides image, name and
+// mounts, for creating a container without the SDK.
+type CreateOptions struct {
+	Labels     map[string]string
+	Env        []string
+	Entrypoint []string
+	Cmd        []string
+	User       string
+	WorkingDir string
+	// Ports are published ports; ExposedPorts ("80/tcp") are exposed without publishing
+	Ports        []PortBinding
+	ExposedPorts []string
+	// RestartPolicy is a --restart value: "always", "on-failure:3", ...
+	RestartPolicy string
+	// NetworkMode is "host", "none", "container:<id>" or empty; otherwise the container joins
+	// Networks, the first one at creation
+	NetworkMode string
+	Networks    []NetworkAttachment
+}
+
+// PortBinding publishes ContainerPort ("80/tcp") on HostIP:HostPort; empty parts let Docker
+// choose.
+type PortBinding struct {
+	HostIP        string
+	HostPort      string
+	ContainerPort string
+}
+
+// NetworkAttachment is the container's endpoint on a network.
+type NetworkAttachment struct {
+	Name    string
+	Aliases []string
+	IPv4    string
+	IPv6    string
+}
+
 // ProjectContainerRef references a compose service container
 type ProjectContainerRef struct {
 	Service       string
 	ID            string
 	ContainerName string
+	// Number is the replica of a scaled service (com.docker.compose.container-number, else
+	// the name's _N/-N suffix); 0 when unknown
+	Number int
+}
+
+// containerNumber parses the replica number of a compose container: label when set, else the
+// numeric suffix of its name (project_web_2, project-web-2).
+func containerNumber(label, name string) int {
+	if n, err := strconv.Atoi(strings.TrimSpace(label)); err == nil && n > 0 {
+		return n
+	}
+	if i := strings.LastIndexAny(name, "_-"); i >= 0 {
+		if n, err := strconv.Atoi(name[i+1:]); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// HelperLabel marks short-lived helper containers (volume extraction) so interrupted runs can
+// be cleaned up with `dockerbackup cleanup`.
+const HelperLabel = "dockerbackup.helper"
+
+// DefaultHelperImage runs helper containers unless HelperImageEnv names another image.
+const DefaultHelperImage = "alpine:3.19"
+
+// HelperImageEnv names the helper image, for hosts that cannot pull from Docker Hub: any image
+// with a POSIX sh, tar (with gzip), cp -a and find, such as busybox or a mirror of alpine.
+const HelperImageEnv = "DOCKERBACKUP_HELPER_IMAGE"
+
+// HelperImage returns the image helper containers run: $DOCKERBACKUP_HELPER_IMAGE or
+// DefaultHelperImage.
+func HelperImage() string {
+	if ref := strings.TrimSpace(os.Getenv(HelperImageEnv)); ref != "" {
+		return ref
+	}
+	return DefaultHelperImage
+}
+
+// helperSecurityOpt runs helper containers unconfined by SELinux: they can read volumes
+// labeled for another container (:Z), and files they write take the volume's label instead
+// of the helper's own categories, which the restored container would be denied.
+const helperSecurityOpt = "label=disable"
+
+// LabeledResource is a container, volume, network or image found by label.
+type LabeledResource struct {
+	Kind   string            `json:"kind"`
+	ID     string            `json:"id"`
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// ContainerRef is a container found by label or listed by ListRunningContainers.
+type ContainerRef struct {
+	ID    string
+	Name  string
+	State string
+	// Project is the compose project the container belongs to, if any
+	Project string
 }
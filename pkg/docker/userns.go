@@ -0,0 +1,93 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultSubordinateSize is the size of the range dockerd allocates for the dockremap user,
+// used when /etc/subuid cannot tell.
+const defaultSubordinateSize = 65536
+
+// UsernsRange is the subordinate ID range of a daemon running with userns-remap: container
+// user 0 is host user UID, container group 0 host group GID, for Size IDs.
+type UsernsRange struct {
+	UID  int `json:"uid"`
+	GID  int `json:"gid"`
+	Size int `json:"size"`
+}
+
+// UsernsRemap returns the subordinate range of the daemon, or nil when it does not remap
+// user namespaces. The range is taken from the daemon's root directory, which dockerd names
+// <uid>.<gid> in that mode, and its size from /etc/subuid and /etc/subgid.
+func (c *CLIClient) UsernsRemap(ctx context.Context) (*UsernsRange, error) {
+	cmd := exec.CommandContext(ctx, "docker", "info", "--format", "{{json .SecurityOptions}}\n{{.DockerRootDir}}")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := c.run(cmd); err != nil {
+		return nil, fmt.Errorf("docker info failed: %v: %s", err, stderr.String())
+	}
+	opts, rootDir, _ := strings.Cut(strings.TrimSpace(stdout.String()), "\n")
+	var secOpts []string
+	if err := json.Unmarshal([]byte(opts), &secOpts); err != nil {
+		return nil, fmt.Errorf("parse docker info security options failed: %v", err)
+	}
+	r, err := parseUsernsRange(secOpts, strings.TrimSpace(rootDir))
+	if r == nil || err != nil {
+		return nil, err
+	}
+	subuid, _ := os.ReadFile("/etc/subuid")
+	subgid, _ := os.ReadFile("/etc/subgid")
+	r.Size = min(subordinateSize(subuid, r.UID), subordinateSize(subgid, r.GID))
+	return r, nil
+}
+
+// parseUsernsRange reads the range start from the daemon root directory when the security
+// options include userns.
+func parseUsernsRange(secOpts []string, rootDir string) (*UsernsRange, error) {
+	remapped := false
+	for _, o := range secOpts {
+		for _, kv := range strings.Split(o, ",") {
+			if kv == "name=userns" {
+				remapped = true
+			}
+		}
+	}
+	if !remapped {
+		return nil, nil
+	}
+	u, g, ok := strings.Cut(filepath.Base(rootDir), ".")
+	uid, err1 := strconv.Atoi(u)
+	gid, err2 := strconv.Atoi(g)
+	if !ok || err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("daemon remaps user namespaces but its root directory %q does not name the range", rootDir)
+	}
+	return &UsernsRange{UID: uid, GID: gid, Size: defaultSubordinateSize}, nil
+}
+
+// subordinateSize returns the size of the range starting at start in /etc/subuid (subgid)
+// content, whose lines read name:start:count.
+func subordinateSize(data []byte, start int) int {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		parts := strings.Split(strings.TrimSpace(sc.Text()), ":")
+		if len(parts) != 3 {
+			continue
+		}
+		s, err1 := strconv.Atoi(parts[1])
+		n, err2 := strconv.Atoi(parts[2])
+		if err1 == nil && err2 == nil && s == start && n > 0 {
+			return n
+		}
+	}
+	return defaultSubordinateSize
+}
@@ -0,0 +1,23 @@
+package docker
+
+import "testing"
+
+func TestParseUsernsRange(t *testing.T) {
+	r, err := parseUsernsRange([]string{"name=seccomp,profile=builtin", "name=userns"}, "/var/lib/docker/231072.231072")
+	if err != nil || r == nil || r.UID != 231072 || r.GID != 231072 || r.Size != defaultSubordinateSize {
+		t.Fatalf("range = %+v, %v", r, err)
+	}
+	if r, err := parseUsernsRange([]string{"name=seccomp,profile=builtin"}, "/var/lib/docker"); r != nil || err != nil {
+		t.Fatalf("without userns: %+v, %v", r, err)
+	}
+	if _, err := parseUsernsRange([]string{"name=userns"}, "/var/lib/docker"); err == nil {
+		t.Fatalf("expected an error for a root directory without a range")
+	}
+	subuid := []byte("alice:100000:65536\ndockremap:231072:131072\n")
+	if n := subordinateSize(subuid, 231072); n != 131072 {
+		t.Fatalf("size = %d", n)
+	}
+	if n := subordinateSize(subuid, 500000); n != defaultSubordinateSize {
+		t.Fatalf("size of an unknown range = %d", n)
+	}
+}
@@ -0,0 +1,206 @@
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+)
+
+// DefaultOpTimeout is how long a docker command may go without finishing (quick commands such
+// as inspect, create or start) or without making progress (commands that stream data, such as
+// export, save, load and helper containers) before it is killed.
+const DefaultOpTimeout = 10 * time.Minute
+
+// OpTimeoutEnv overrides DefaultOpTimeout, as a Go duration; 0 disables the timeouts.
+const OpTimeoutEnv = "DOCKERBACKUP_OP_TIMEOUT"
+
+// OpTimeout returns the timeout of docker commands: $DOCKERBACKUP_OP_TIMEOUT or
+// DefaultOpTimeout. Invalid values are ignored.
+func OpTimeout() time.Duration {
+	if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv(OpTimeoutEnv))); err == nil && d >= 0 {
+		return d
+	}
+	return DefaultOpTimeout
+}
+
+// TimeoutError is returned for a docker command killed by the watchdog, which happens when the
+// daemon hangs: the docker CLI waits on it forever otherwise.
+type TimeoutError struct {
+	After time.Duration
+	// Stalled is set when the command was streaming data and stopped making progress
+	Stalled bool
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Stalled {
+		return fmt.Sprintf("stalled: no progress for %s (--op-timeout)", e.After)
+	}
+	return fmt.Sprintf("timed out after %s (--op-timeout)", e.After)
+}
+
+// run runs a docker command that should return promptly, killing it once it has run for the
+// operation timeout.
+func (c *CLIClient) run(cmd *exec.Cmd) error {
+	return c.watch(cmd, false)
+}
+
+// runLong runs a docker command whose duration depends on the data it moves, killing it once
+// nothing went through its stdin, stdout or stderr for the operation timeout.
+func (c *CLIClient) runLong(cmd *exec.Cmd) error {
+	return c.watch(cmd, true)
+}
+
+func (c *CLIClient) watch(cmd *exec.Cmd, stall bool) error {
+	finish := c.logOutput(cmd)
+	err := c.watchdog(cmd, stall)
+	finish(err)
+	return err
+}
+
+// logOutput logs the command line and, as it runs, what the command prints to stderr and
+// to stdout unless stdout carries data (export, save, volume archives), at debug level. The
+// returned function logs how the command ended.
+func (c *CLIClient) logOutput(cmd *exec.Cmd) func(err error) {
+	if c.log == nil {
+		return func(error) {}
+	}
+	log := c.log.With("docker", commandName(cmd.Args))
+	log.Debugf("Running %s", strings.Join(redactArgs(cmd.Args), " "))
+	var writers []io.WriteCloser
+	tee := func(w io.Writer, prefix string) io.Writer {
+		dw := logger.DebugWriter(log, prefix)
+		writers = append(writers, dw)
+		if w == nil {
+			return dw
+		}
+		return io.MultiWriter(w, dw)
+	}
+	cmd.Stderr = tee(cmd.Stderr, "stderr: ")
+	switch cmd.Stdout.(type) {
+	case nil, *bytes.Buffer:
+		cmd.Stdout = tee(cmd.Stdout, "stdout: ")
+	}
+	start := time.Now()
+	return func(err error) {
+		for _, w := range writers {
+			_ = w.Close()
+		}
+		took := time.Since(start).Truncate(time.Millisecond)
+		if err != nil {
+			log.Debugf("Failed after %s: %v", took, err)
+			return
+		}
+		log.Debugf("Done in %s", took)
+	}
+}
+
+// commandName names a docker command for logs: its subcommand, with the object for
+// management commands ("volume rm").
+func commandName(args []string) string {
+	if len(args) < 2 {
+		return strings.Join(args, " ")
+	}
+	switch args[1] {
+	case "volume", "network", "image", "container", "plugin", "checkpoint", "compose":
+		if len(args) > 2 && !strings.HasPrefix(args[2], "-") {
+			return args[1] + " " + args[2]
+		}
+	}
+	return args[1]
+}
+
+// redactArgs hides the values of environment variables (-e KEY=value) and plugin settings,
+// which often hold credentials, from a logged command line.
+func redactArgs(args []string) []string {
+	out := slices.Clone(args)
+	plugin := len(args) > 2 && args[1] == "plugin" && args[2] == "install"
+	for i := 1; i < len(out); i++ {
+		switch {
+		case (out[i-1] == "-e" || out[i-1] == "--env") && strings.Contains(out[i], "="):
+			k, _, _ := strings.Cut(out[i], "=")
+			out[i] = k + "=***"
+		case plugin && !strings.HasPrefix(out[i], "-") && strings.Contains(out[i], "="):
+			k, _, _ := strings.Cut(out[i], "=")
+			out[i] = k + "=***"
+		}
+	}
+	return out
+}
+
+// watchdog runs cmd, killing it when it hangs.
+func (c *CLIClient) watchdog(cmd *exec.Cmd, stall bool) error {
+	limit := c.opTimeout
+	if limit <= 0 {
+		return cmd.Run()
+	}
+	var last atomic.Int64
+	if stall {
+		if cmd.Stdout == nil {
+			cmd.Stdout = io.Discard
+		}
+		if cmd.Stderr == nil {
+			cmd.Stderr = io.Discard
+		}
+		cmd.Stdout = &activityWriter{w: cmd.Stdout, last: &last}
+		cmd.Stderr = &activityWriter{w: cmd.Stderr, last: &last}
+		if cmd.Stdin != nil {
+			cmd.Stdin = &activityReader{r: cmd.Stdin, last: &last}
+		}
+	}
+	// a killed CLI may leave its output pipes open for a moment; do not wait on them forever
+	cmd.WaitDelay = 5 * time.Second
+	last.Store(time.Now().UnixNano())
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	t := time.NewTicker(limit / 4)
+	defer t.Stop()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-t.C:
+			if time.Since(time.Unix(0, last.Load())) < limit {
+				continue
+			}
+			_ = cmd.Process.Kill()
+			<-done
+			return &TimeoutError{After: limit, Stalled: stall}
+		}
+	}
+}
+
+// activityWriter records when data last went through it.
+type activityWriter struct {
+	w    io.Writer
+	last *atomic.Int64
+}
+
+func (a *activityWriter) Write(p []byte) (int, error) {
+	a.last.Store(time.Now().UnixNano())
+	return a.w.Write(p)
+}
+
+// activityReader records when data last went through it.
+type activityReader struct {
+	r    io.Reader
+	last *atomic.Int64
+}
+
+func (a *activityReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if n > 0 {
+		a.last.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
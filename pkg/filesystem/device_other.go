@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package filesystem
+
+import "os"
+
+// DeviceID is not implemented here; walks do not stop at filesystem boundaries.
+func DeviceID(fi os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+package filesystem
+
+import (
+	"os"
+	"syscall"
+)
+
+// DeviceID returns the ID of the filesystem holding the file fi describes.
+func DeviceID(fi os.FileInfo) (uint64, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package filesystem
+
+import "errors"
+
+// FreeSpace is not implemented here; callers skip their free-space checks.
+func FreeSpace(path string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -2,13 +2,34 @@ package filesystem
 
 import (
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 )
 
+// Handler is every file operation the backup engine does on its own: temporary work
+// directories, the metadata and manifests it writes and reads back, and the files it copies.
+// NewHandler works on the host; NewMemHandler keeps everything in memory for tests. Paths handed
+// to the archive handler and to docker must still exist on the host.
 type Handler interface {
 	EnsureDir(path string, perm os.FileMode) error
 	CopyFile(src, dest string, perm os.FileMode) error
+	MkdirTemp(dir, pattern string) (string, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	ReadFile(name string) ([]byte, error)
+	// Create and Open stream a file, for archive entries too large to hold in memory
+	Create(name string) (io.WriteCloser, error)
+	Open(name string) (io.ReadCloser, error)
+	// Append opens name for appending, creating it with perm
+	Append(name string, perm os.FileMode) (io.WriteCloser, error)
+	// ReadDir lists a directory sorted by name, like os.ReadDir
+	ReadDir(name string) ([]os.DirEntry, error)
+	Stat(name string) (os.FileInfo, error)
+	// Rename replaces newpath with oldpath, for files written in place atomically
+	Rename(oldpath, newpath string) error
+	Symlink(oldname, newname string) error
+	Readlink(name string) (string, error)
+	RemoveAll(path string) error
 }
 
 type OSHandler struct{}
@@ -42,3 +63,92 @@ func (h *OSHandler) CopyFile(src, dest string, perm os.FileMode) error {
 	}
 	return out.Sync()
 }
+
+func (h *OSHandler) MkdirTemp(dir, pattern string) (string, error) {
+	return os.MkdirTemp(dir, pattern)
+}
+
+func (h *OSHandler) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+func (h *OSHandler) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (h *OSHandler) Create(name string) (io.WriteCloser, error) {
+	return os.Create(name)
+}
+
+func (h *OSHandler) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (h *OSHandler) Append(name string, perm os.FileMode) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, perm)
+}
+
+func (h *OSHandler) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (h *OSHandler) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (h *OSHandler) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (h *OSHandler) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, newname)
+}
+
+func (h *OSHandler) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+func (h *OSHandler) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+// WalkDir walks the tree rooted at root on h like filepath.WalkDir, in lexical order.
+func WalkDir(h Handler, root string, fn fs.WalkDirFunc) error {
+	fi, err := h.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDir(h, root, fs.FileInfoToDirEntry(fi), fn)
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkDir(h Handler, path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == fs.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+	entries, err := h.ReadDir(path)
+	if err != nil {
+		if err = fn(path, d, err); err != nil {
+			if err == fs.SkipDir && d.IsDir() {
+				err = nil
+			}
+			return err
+		}
+	}
+	for _, en := range entries {
+		if err := walkDir(h, filepath.Join(path, en.Name()), en, fn); err != nil {
+			if err == fs.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package filesystem
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreMatcher evaluates .dockerignore patterns: paths are relative to the build context,
+// `**` matches any number of directories, `!` re-includes and the last matching pattern wins.
+// A pattern that matches a directory also excludes everything below it.
+type IgnoreMatcher struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	re     *regexp.Regexp
+	negate bool
+}
+
+// ParseIgnoreFile parses the contents of a .dockerignore file. Invalid patterns are skipped.
+func ParseIgnoreFile(data []byte) *IgnoreMatcher {
+	m := &IgnoreMatcher{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		negate := false
+		if strings.HasPrefix(line, "!") {
+			negate = true
+			line = strings.TrimSpace(line[1:])
+		}
+		line = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(line)), "/")
+		if line == "" || line == "." {
+			continue
+		}
+		re, err := regexp.Compile(ignorePatternToRegexp(line))
+		if err != nil {
+			continue
+		}
+		m.rules = append(m.rules, ignoreRule{re: re, negate: negate})
+	}
+	return m
+}
+
+// HasExceptions reports whether any pattern re-includes paths, in which case excluded
+// directories still have to be walked.
+func (m *IgnoreMatcher) HasExceptions() bool {
+	if m == nil {
+		return false
+	}
+	for _, r := range m.rules {
+		if r.negate {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether the slash- or OS-separated relative path is excluded.
+func (m *IgnoreMatcher) Matches(rel string) bool {
+	if m == nil {
+		return false
+	}
+	rel = filepath.ToSlash(filepath.Clean(rel))
+	// a path is also matched through any of its parent directories
+	var candidates []string
+	parts := strings.Split(rel, "/")
+	for i := 1; i <= len(parts); i++ {
+		candidates = append(candidates, strings.Join(parts[:i], "/"))
+	}
+	excluded := false
+	for _, r := range m.rules {
+		for _, c := range candidates {
+			if r.re.MatchString(c) {
+				excluded = !r.negate
+				break
+			}
+		}
+	}
+	return excluded
+}
+
+func ignorePatternToRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+		switch ch {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					// "**/" matches zero or more directories
+					i++
+					b.WriteString("(.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
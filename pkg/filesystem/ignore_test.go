@@ -0,0 +1,34 @@
+package filesystem
+
+import "testing"
+
+func TestIgnoreMatcher(t *testing.T) {
+	m := ParseIgnoreFile([]byte(`
+# comment
+node_modules
+*.log
+**/tmp
+/build
+!build/keep.txt
+`))
+	cases := map[string]bool{
+		"node_modules":        true,
+		"node_modules/x/y.js": true,
+		"app.log":             true,
+		"src/app.log":         false,
+		"a/b/tmp/file":        true,
+		"tmp":                 true,
+		"build/out.bin":       true,
+		"build/keep.txt":      false,
+		"src/main.go":         false,
+		"Dockerfile":          false,
+	}
+	for path, want := range cases {
+		if got := m.Matches(path); got != want {
+			t.Errorf("Matches(%q) = %v, want %v", path, got, want)
+		}
+	}
+	if !m.HasExceptions() {
+		t.Fatalf("expected HasExceptions")
+	}
+}
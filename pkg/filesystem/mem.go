@@ -0,0 +1,328 @@
+package filesystem
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemHandler is a Handler that keeps files and directories in memory, so the engine's own file
+// handling can be tested without touching the disk. Temporary directories are created under
+// /tmp when no directory is given, like os.MkdirTemp with the default TMPDIR.
+type MemHandler struct {
+	mu    sync.Mutex
+	files map[string]memFile
+	dirs  map[string]os.FileMode
+	// links are symlinks by path, to their target
+	links map[string]string
+	temp  int
+}
+
+type memFile struct {
+	data    []byte
+	perm    os.FileMode
+	modTime time.Time
+}
+
+func NewMemHandler() *MemHandler {
+	return &MemHandler{files: map[string]memFile{}, dirs: map[string]os.FileMode{}, links: map[string]string{}}
+}
+
+// resolve follows symlinks at path itself; links in parent directories are not followed.
+func (h *MemHandler) resolve(path string) string {
+	for i := 0; i < 40; i++ {
+		target, ok := h.links[path]
+		if !ok {
+			break
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = filepath.Clean(target)
+	}
+	return path
+}
+
+// exists reports whether anything, a link included, is at path.
+func (h *MemHandler) exists(path string) bool {
+	_, file := h.files[path]
+	_, link := h.links[path]
+	return file || link || h.isDir(path)
+}
+
+// isDir reports whether path is a directory; the root and the working directory always are.
+func (h *MemHandler) isDir(path string) bool {
+	if path == "/" || path == "." {
+		return true
+	}
+	_, ok := h.dirs[path]
+	return ok
+}
+
+func (h *MemHandler) mkdirAll(path string, perm os.FileMode) error {
+	if h.isDir(path) {
+		return nil
+	}
+	if _, ok := h.files[path]; ok {
+		return &fs.PathError{Op: "mkdir", Path: path, Err: fs.ErrExist}
+	}
+	if err := h.mkdirAll(filepath.Dir(path), perm); err != nil {
+		return err
+	}
+	h.dirs[path] = perm
+	return nil
+}
+
+func (h *MemHandler) EnsureDir(path string, perm os.FileMode) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.mkdirAll(filepath.Clean(path), perm)
+}
+
+func (h *MemHandler) CopyFile(src, dest string, perm os.FileMode) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	src, dest = h.resolve(filepath.Clean(src)), filepath.Clean(dest)
+	if err := h.mkdirAll(filepath.Dir(dest), perm); err != nil {
+		return err
+	}
+	f, ok := h.files[src]
+	if !ok {
+		return &fs.PathError{Op: "open", Path: src, Err: fs.ErrNotExist}
+	}
+	if h.isDir(dest) {
+		return &fs.PathError{Op: "open", Path: dest, Err: fs.ErrExist}
+	}
+	h.files[dest] = memFile{data: append([]byte(nil), f.data...), perm: perm, modTime: time.Now()}
+	return nil
+}
+
+func (h *MemHandler) MkdirTemp(dir, pattern string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if dir == "" {
+		dir = "/tmp"
+		if err := h.mkdirAll(dir, 0o755); err != nil {
+			return "", err
+		}
+	}
+	dir = filepath.Clean(dir)
+	if !h.isDir(dir) {
+		return "", &fs.PathError{Op: "mkdirtemp", Path: dir, Err: fs.ErrNotExist}
+	}
+	prefix, suffix, _ := strings.Cut(pattern, "*")
+	for {
+		h.temp++
+		name := filepath.Join(dir, prefix+strconv.Itoa(h.temp)+suffix)
+		if !h.exists(name) {
+			h.dirs[name] = 0o700
+			return name, nil
+		}
+	}
+}
+
+func (h *MemHandler) WriteFile(name string, data []byte, perm os.FileMode) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	name = h.resolve(filepath.Clean(name))
+	if !h.isDir(filepath.Dir(name)) || h.isDir(name) {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if f, ok := h.files[name]; ok {
+		perm = f.perm // like os.WriteFile, an existing file keeps its mode
+	}
+	h.files[name] = memFile{data: append([]byte(nil), data...), perm: perm, modTime: time.Now()}
+	return nil
+}
+
+func (h *MemHandler) ReadFile(name string) ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	name = h.resolve(filepath.Clean(name))
+	f, ok := h.files[name]
+	if !ok {
+		if h.isDir(name) {
+			return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+		}
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), f.data...), nil
+}
+
+func (h *MemHandler) Create(name string) (io.WriteCloser, error) {
+	if err := h.WriteFile(name, nil, 0o666); err != nil {
+		return nil, err
+	}
+	return &memWriter{h: h, name: name}, nil
+}
+
+func (h *MemHandler) Append(name string, perm os.FileMode) (io.WriteCloser, error) {
+	b, err := h.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := h.WriteFile(name, b, perm); err != nil {
+		return nil, err
+	}
+	w := &memWriter{h: h, name: name}
+	w.buf.Write(b)
+	return w, nil
+}
+
+func (h *MemHandler) Open(name string) (io.ReadCloser, error) {
+	b, err := h.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+// memWriter stores what was written to a file created on a MemHandler when it is closed.
+type memWriter struct {
+	h    *MemHandler
+	name string
+	buf  bytes.Buffer
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *memWriter) Close() error {
+	return w.h.WriteFile(w.name, w.buf.Bytes(), 0o666)
+}
+
+func (h *MemHandler) Stat(name string) (os.FileInfo, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	name = h.resolve(filepath.Clean(name))
+	if f, ok := h.files[name]; ok {
+		return memFileInfo{name: filepath.Base(name), size: int64(len(f.data)), mode: f.perm, modTime: f.modTime}, nil
+	}
+	if h.isDir(name) {
+		return memFileInfo{name: filepath.Base(name), mode: fs.ModeDir | h.dirs[name]}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (h *MemHandler) ReadDir(name string) ([]os.DirEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	name = filepath.Clean(name)
+	if !h.isDir(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	var out []os.DirEntry
+	for p, f := range h.files {
+		if filepath.Dir(p) == name {
+			out = append(out, fs.FileInfoToDirEntry(memFileInfo{name: filepath.Base(p), size: int64(len(f.data)), mode: f.perm, modTime: f.modTime}))
+		}
+	}
+	for p, perm := range h.dirs {
+		if p != name && filepath.Dir(p) == name {
+			out = append(out, fs.FileInfoToDirEntry(memFileInfo{name: filepath.Base(p), mode: fs.ModeDir | perm}))
+		}
+	}
+	for p, target := range h.links {
+		if filepath.Dir(p) == name {
+			out = append(out, fs.FileInfoToDirEntry(memFileInfo{name: filepath.Base(p), size: int64(len(target)), mode: fs.ModeSymlink | 0o777}))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out, nil
+}
+
+func (h *MemHandler) Rename(oldpath, newpath string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	if target, ok := h.links[oldpath]; ok {
+		if !h.isDir(filepath.Dir(newpath)) || h.isDir(newpath) {
+			return &fs.PathError{Op: "rename", Path: newpath, Err: fs.ErrNotExist}
+		}
+		delete(h.links, oldpath)
+		delete(h.files, newpath)
+		h.links[newpath] = target
+		return nil
+	}
+	f, ok := h.files[oldpath]
+	if !ok {
+		// directories are not renamed by the engine
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrNotExist}
+	}
+	if !h.isDir(filepath.Dir(newpath)) || h.isDir(newpath) {
+		return &fs.PathError{Op: "rename", Path: newpath, Err: fs.ErrNotExist}
+	}
+	delete(h.files, oldpath)
+	delete(h.links, newpath)
+	h.files[newpath] = f
+	return nil
+}
+
+func (h *MemHandler) Symlink(oldname, newname string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	newname = filepath.Clean(newname)
+	if !h.isDir(filepath.Dir(newname)) {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrNotExist}
+	}
+	if h.exists(newname) {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrExist}
+	}
+	h.links[newname] = oldname
+	return nil
+}
+
+func (h *MemHandler) Readlink(name string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	target, ok := h.links[filepath.Clean(name)]
+	if !ok {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return target, nil
+}
+
+func (h *MemHandler) RemoveAll(path string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	path = filepath.Clean(path)
+	under := func(p string) bool { return p == path || strings.HasPrefix(p, path+string(filepath.Separator)) }
+	for p := range h.files {
+		if under(p) {
+			delete(h.files, p)
+		}
+	}
+	for p := range h.dirs {
+		if under(p) {
+			delete(h.dirs, p)
+		}
+	}
+	for p := range h.links {
+		if under(p) {
+			delete(h.links, p)
+		}
+	}
+	return nil
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi memFileInfo) Sys() any           { return nil }
@@ -0,0 +1,140 @@
+package filesystem
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMemHandler(t *testing.T) {
+	var h Handler = NewMemHandler()
+	dir, err := h.MkdirTemp("", "dockerbackup_*")
+	if err != nil {
+		t.Fatalf("MkdirTemp: %v", err)
+	}
+	if !strings.HasPrefix(filepath.Base(dir), "dockerbackup_") {
+		t.Fatalf("temp dir %q does not follow the pattern", dir)
+	}
+	if other, _ := h.MkdirTemp("", "dockerbackup_*"); other == dir {
+		t.Fatalf("MkdirTemp returned %q twice", dir)
+	}
+
+	f := filepath.Join(dir, "sub", "metadata.json")
+	if err := h.WriteFile(f, []byte("{}"), 0o644); !os.IsNotExist(err) {
+		t.Fatalf("WriteFile without its directory: %v", err)
+	}
+	if err := h.EnsureDir(filepath.Dir(f), 0o755); err != nil {
+		t.Fatalf("EnsureDir: %v", err)
+	}
+	if err := h.WriteFile(f, []byte("{}"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if got, err := h.ReadFile(f); err != nil || string(got) != "{}" {
+		t.Fatalf("ReadFile = %q, %v", got, err)
+	}
+	if fi, err := h.Stat(f); err != nil || fi.Size() != 2 || fi.IsDir() {
+		t.Fatalf("Stat = %+v, %v", fi, err)
+	}
+	if fi, err := h.Stat(filepath.Dir(f)); err != nil || !fi.IsDir() {
+		t.Fatalf("Stat of the directory = %+v, %v", fi, err)
+	}
+
+	if entries, err := h.ReadDir(dir); err != nil || len(entries) != 1 || entries[0].Name() != "sub" || !entries[0].IsDir() {
+		t.Fatalf("ReadDir = %v, %v", entries, err)
+	}
+	renamed := filepath.Join(dir, "sub", "renamed.json")
+	if err := h.Rename(f, renamed); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if _, err := h.Stat(f); !os.IsNotExist(err) {
+		t.Fatalf("Stat of the renamed file: %v", err)
+	}
+	if err := h.Rename(renamed, f); err != nil {
+		t.Fatalf("Rename back: %v", err)
+	}
+
+	var walked []string
+	err = WalkDir(h, dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		walked = append(walked, rel)
+		return nil
+	})
+	if err != nil || strings.Join(walked, ",") != ".,sub,"+filepath.Join("sub", "metadata.json") {
+		t.Fatalf("WalkDir = %v, %v", walked, err)
+	}
+
+	stream := filepath.Join(dir, "sub", "stream.log")
+	w, err := h.Create(stream)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	io.WriteString(w, "one\n")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if w, err = h.Append(stream, 0o644); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	io.WriteString(w, "two\n")
+	w.Close()
+	r, err := h.Open(stream)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "one\ntwo\n" {
+		t.Fatalf("streamed = %q", got)
+	}
+	r.Close()
+	if _, err := h.Create(filepath.Join(dir, "missing", "x")); !os.IsNotExist(err) {
+		t.Fatalf("Create without its directory: %v", err)
+	}
+
+	link := filepath.Join(dir, "sub", "latest")
+	if err := h.Symlink("stream.log", link); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+	if err := h.Symlink("stream.log", link); !os.IsExist(err) {
+		t.Fatalf("Symlink over an existing link: %v", err)
+	}
+	if target, err := h.Readlink(link); err != nil || target != "stream.log" {
+		t.Fatalf("Readlink = %q, %v", target, err)
+	}
+	if got, err := h.ReadFile(link); err != nil || string(got) != "one\ntwo\n" {
+		t.Fatalf("ReadFile through the link = %q, %v", got, err)
+	}
+	if _, err := h.Readlink(stream); err == nil {
+		t.Fatal("Readlink of a regular file succeeded")
+	}
+	if err := h.RemoveAll(stream); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+	if err := h.RemoveAll(link); err != nil {
+		t.Fatalf("RemoveAll of the link: %v", err)
+	}
+
+	copied := filepath.Join(dir, "copy", "metadata.json")
+	if err := h.CopyFile(f, copied, 0o600); err != nil {
+		t.Fatalf("CopyFile: %v", err)
+	}
+	if got, _ := h.ReadFile(copied); string(got) != "{}" {
+		t.Fatalf("copied = %q", got)
+	}
+
+	if err := h.RemoveAll(dir); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+	for _, p := range []string{dir, f, copied} {
+		if _, err := h.Stat(p); !os.IsNotExist(err) {
+			t.Fatalf("Stat(%s) after RemoveAll: %v", p, err)
+		}
+	}
+	if err := h.RemoveAll(dir); err != nil {
+		t.Fatalf("RemoveAll of a missing path: %v", err)
+	}
+}
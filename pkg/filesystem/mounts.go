@@ -0,0 +1,107 @@
+package filesystem
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Boundary limits how far a walk of a directory tree descends. Directories it stops at are
+// still visited, so they are kept (empty) in an archive, but not entered.
+type Boundary struct {
+	// OneFileSystem stops at directories on another filesystem than the root, like
+	// tar --one-file-system
+	OneFileSystem bool
+	// Skip stops at these directories, absolute and cleaned: the nested mountpoints a backup
+	// leaves out
+	Skip []string
+}
+
+// Stops reports whether a walk from the directory with info root must not enter path.
+func (b Boundary) Stops(root os.FileInfo, path string, fi os.FileInfo) bool {
+	if !fi.IsDir() {
+		return false
+	}
+	if b.OneFileSystem {
+		rd, ok1 := DeviceID(root)
+		d, ok2 := DeviceID(fi)
+		if ok1 && ok2 && rd != d {
+			return true
+		}
+	}
+	return slices.Contains(b.Skip, filepath.Clean(path))
+}
+
+// NestedMounts returns the mountpoints below root (not root itself), outermost only: a mount
+// inside another nested mount is left out with it. Symlinks in root are resolved first, as
+// the mount table lists real paths.
+func NestedMounts(root string) ([]string, error) {
+	mounts, err := Mountpoints()
+	if err != nil {
+		return nil, err
+	}
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		root = real
+	}
+	return nestedUnder(filepath.Clean(root), mounts), nil
+}
+
+func nestedUnder(root string, mounts []string) []string {
+	prefix := root + string(filepath.Separator)
+	if root == string(filepath.Separator) {
+		prefix = root
+	}
+	var below []string
+	for _, m := range mounts {
+		m = filepath.Clean(m)
+		if m != root && strings.HasPrefix(m, prefix) && !slices.Contains(below, m) {
+			below = append(below, m)
+		}
+	}
+	slices.Sort(below)
+	var out []string
+	for _, m := range below {
+		if len(out) > 0 && strings.HasPrefix(m, out[len(out)-1]+string(filepath.Separator)) {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// parseMountinfo returns the mount points of a /proc/<pid>/mountinfo table, whose fifth field
+// escapes spaces, tabs, newlines and backslashes as octal (\040).
+func parseMountinfo(data []byte) []string {
+	var out []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		out = append(out, unescapeMountPath(fields[4]))
+	}
+	return out
+}
+
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
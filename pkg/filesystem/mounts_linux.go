@@ -0,0 +1,14 @@
+//go:build linux
+
+package filesystem
+
+import "os"
+
+// Mountpoints lists the mount points of this process's mount namespace.
+func Mountpoints() ([]string, error) {
+	data, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	return parseMountinfo(data), nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package filesystem
+
+import "errors"
+
+// Mountpoints is not implemented here; nested mounts are not detected.
+func Mountpoints() ([]string, error) {
+	return nil, errors.ErrUnsupported
+}
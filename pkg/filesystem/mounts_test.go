@@ -0,0 +1,52 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNestedMounts(t *testing.T) {
+	mountinfo := []byte(`22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+23 22 0:5 / /proc rw,nosuid shared:2 - proc proc rw
+30 22 8:17 / /srv/data rw shared:5 - ext4 /dev/sdb1 rw
+31 30 8:17 /cache /srv/data/app/cache rw shared:5 - ext4 /dev/sdb1 rw
+32 30 0:40 / /srv/data/my\040share rw shared:6 - nfs4 nas:/share rw
+33 22 0:41 / /srv/database rw shared:7 - tmpfs tmpfs rw
+`)
+	mounts := parseMountinfo(mountinfo)
+	if want := []string{"/", "/proc", "/srv/data", "/srv/data/app/cache", "/srv/data/my share", "/srv/database"}; !reflect.DeepEqual(mounts, want) {
+		t.Fatalf("mountpoints = %q", mounts)
+	}
+	for root, want := range map[string][]string{
+		"/srv":                {"/srv/data", "/srv/database"},
+		"/srv/data":           {"/srv/data/app/cache", "/srv/data/my share"},
+		"/srv/data/app/cache": nil,
+		"/":                   {"/proc", "/srv/data", "/srv/database"},
+	} {
+		if got := nestedUnder(root, mounts); !reflect.DeepEqual(got, want) {
+			t.Errorf("nestedUnder(%s) = %q, want %q", root, got, want)
+		}
+	}
+}
+
+func TestBoundaryStops(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	rootInfo, _ := os.Stat(root)
+	subInfo, _ := os.Stat(sub)
+	// same filesystem: one-file-system alone does not stop
+	if (Boundary{OneFileSystem: true}).Stops(rootInfo, sub, subInfo) {
+		t.Fatal("stopped at a directory on the same filesystem")
+	}
+	if !(Boundary{Skip: []string{sub}}).Stops(rootInfo, sub+"/", subInfo) {
+		t.Fatal("did not stop at a skipped mountpoint")
+	}
+	if (Boundary{Skip: []string{sub}}).Stops(rootInfo, sub+"2", subInfo) {
+		t.Fatal("stopped at a sibling sharing the mountpoint's prefix")
+	}
+}
@@ -0,0 +1,41 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTreeSummary_ChangesWithContent(t *testing.T) {
+	root := t.TempDir()
+	f := filepath.Join(root, "sub", "a.txt")
+	if err := os.MkdirAll(filepath.Dir(f), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(f, []byte("one"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	first, err := TreeSummary(root)
+	if err != nil {
+		t.Fatalf("TreeSummary: %v", err)
+	}
+	if again, _ := TreeSummary(root); again != first {
+		t.Fatalf("summary not stable: %s vs %s", first, again)
+	}
+
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(f, later, later); err != nil {
+		t.Fatal(err)
+	}
+	touched, _ := TreeSummary(root)
+	if touched == first {
+		t.Fatalf("mtime change not detected")
+	}
+	if err := os.WriteFile(filepath.Join(root, "b.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if added, _ := TreeSummary(root); added == touched {
+		t.Fatalf("new file not detected")
+	}
+}
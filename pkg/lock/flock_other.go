@@ -0,0 +1,29 @@
+//go:build !unix
+
+package lock
+
+import (
+	"errors"
+	"os"
+)
+
+// CheckOwner cannot tell file owners here and accepts every file.
+func CheckOwner(path string, fi os.FileInfo) error {
+	return nil
+}
+
+// tryLock falls back to exclusive creation where flock is unavailable; a crashed run leaves
+// the file behind and it must be removed by hand.
+func tryLock(p string) (*os.File, error) {
+	f, err := os.OpenFile(p, os.O_RDONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return nil, ErrLocked
+	}
+	return f, err
+}
+
+func unlock(f *os.File, p string) error {
+	err := f.Close()
+	_ = os.Remove(p)
+	return err
+}
@@ -0,0 +1,45 @@
+//go:build unix
+
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// CheckOwner fails unless the file fi describes (at path) belongs to the current user.
+func CheckOwner(path string, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if uid := os.Geteuid(); int(st.Uid) != uid {
+		return fmt.Errorf("%s is owned by uid %d, not %d; refusing to use it", path, st.Uid, uid)
+	}
+	return nil
+}
+
+// tryLock opens p, never through a symlink, and takes a non-blocking flock; the kernel drops
+// it if the process dies, so crashed runs never leave a stale lock behind. The file is left
+// empty: the lock is the flock, not its content.
+func tryLock(p string) (*os.File, error) {
+	f, err := os.OpenFile(p, os.O_RDONLY|os.O_CREATE|syscall.O_NOFOLLOW, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func unlock(f *os.File, _ string) error {
+	_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return f.Close()
+}
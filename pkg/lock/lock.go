@@ -0,0 +1,120 @@
+// Package lock serializes dockerbackup runs against the same target with advisory file locks.
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrLocked is returned when another process holds the lock and waiting was not requested or
+// timed out.
+var ErrLocked = errors.New("locked by another dockerbackup run")
+
+const pollInterval = 200 * time.Millisecond
+
+// Lock is an exclusive lock held on <Dir()>/<name>.lock until Release.
+type Lock struct {
+	f    *os.File
+	path string
+}
+
+// Dir returns the lock directory: $DOCKERBACKUP_LOCK_DIR or <RuntimeDir()>/locks.
+func Dir() string {
+	if d := os.Getenv("DOCKERBACKUP_LOCK_DIR"); d != "" {
+		return d
+	}
+	return filepath.Join(RuntimeDir(), "locks")
+}
+
+// RuntimeDir is the private directory that holds state of running dockerbackup processes:
+// /run/dockerbackup for root, $XDG_RUNTIME_DIR/dockerbackup or <tmp>/dockerbackup-<uid> for
+// other users. It is never a shared directory such as /tmp itself, where another local user
+// could create it first.
+func RuntimeDir() string {
+	uid := os.Geteuid()
+	switch {
+	case uid == 0:
+		return "/run/dockerbackup"
+	case os.Getenv("XDG_RUNTIME_DIR") != "":
+		return filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), "dockerbackup")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("dockerbackup-%d", uid))
+}
+
+// PrivateDir creates dir with mode 0700 if needed and checks that it can be trusted: a real
+// directory (not a symlink) owned by the current user that no other user can write to. Files
+// of root runs in a directory someone else controls could be swapped for symlinks to
+// arbitrary targets.
+func PrivateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if fi.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("%s is writable by other users; remove it or make it private (chmod 700)", dir)
+	}
+	return CheckOwner(dir, fi)
+}
+
+// Acquire takes the lock for name (e.g. "container-<id>"). Without wait it fails immediately
+// with ErrLocked when held elsewhere; with wait it retries until the lock is free, ctx is done
+// or timeout (0 = no limit) expires.
+func Acquire(ctx context.Context, name string, wait bool, timeout time.Duration) (*Lock, error) {
+	dir := Dir()
+	if err := PrivateDir(dir); err != nil {
+		return nil, err
+	}
+	p := filepath.Join(dir, sanitize(name)+".lock")
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		f, err := tryLock(p)
+		if err == nil {
+			return &Lock{f: f, path: p}, nil
+		}
+		if !errors.Is(err, ErrLocked) {
+			return nil, err
+		}
+		if !wait || (!deadline.IsZero() && time.Now().After(deadline)) {
+			return nil, fmt.Errorf("%s: %w (%s)", name, ErrLocked, p)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Release drops the lock.
+func (l *Lock) Release() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	err := unlock(l.f, l.path)
+	l.f = nil
+	return err
+}
+
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,80 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquire_ExcludesConcurrentHolders(t *testing.T) {
+	t.Setenv("DOCKERBACKUP_LOCK_DIR", t.TempDir())
+	ctx := context.Background()
+
+	l, err := Acquire(ctx, "container-abc", false, 0)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if _, err := Acquire(ctx, "container-abc", false, 0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second Acquire = %v, want ErrLocked", err)
+	}
+	start := time.Now()
+	if _, err := Acquire(ctx, "container-abc", true, 300*time.Millisecond); !errors.Is(err, ErrLocked) || time.Since(start) < 300*time.Millisecond {
+		t.Fatalf("waiting Acquire = %v after %s, want ErrLocked after the timeout", err, time.Since(start))
+	}
+	if other, err := Acquire(ctx, "container-def", false, 0); err != nil {
+		t.Fatalf("other target must not be blocked: %v", err)
+	} else {
+		_ = other.Release()
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = l.Release()
+	}()
+	l2, err := Acquire(ctx, "container-abc", true, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Acquire after release: %v", err)
+	}
+	_ = l2.Release()
+}
+
+func TestAcquire_RefusesUntrustedFiles(t *testing.T) {
+	ctx := context.Background()
+	shared := filepath.Join(t.TempDir(), "shared")
+	if err := os.Mkdir(shared, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(shared, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOCKERBACKUP_LOCK_DIR", shared)
+	if _, err := Acquire(ctx, "container-abc", false, 0); err == nil {
+		t.Fatal("Acquire used a directory other users can write to")
+	}
+
+	dir := filepath.Join(t.TempDir(), "locks")
+	t.Setenv("DOCKERBACKUP_LOCK_DIR", dir)
+	victim := filepath.Join(t.TempDir(), "victim")
+	if err := os.WriteFile(victim, []byte("keep"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := PrivateDir(dir); err != nil {
+		t.Fatalf("PrivateDir: %v", err)
+	}
+	if fi, _ := os.Stat(dir); fi.Mode().Perm() != 0o700 {
+		t.Fatalf("lock dir mode = %v, want 0700", fi.Mode().Perm())
+	}
+	if err := os.Symlink(victim, filepath.Join(dir, "container-abc.lock")); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := Acquire(ctx, "container-abc", false, 0); err == nil {
+		_ = l.Release()
+		t.Fatal("Acquire followed a planted symlink")
+	}
+	if b, _ := os.ReadFile(victim); string(b) != "keep" {
+		t.Fatalf("symlink target changed to %q", b)
+	}
+}
@@ -0,0 +1,135 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// SSHTarget is a host reachable with the system ssh client, parsed from
+// ssh://[user@]host[:port]. Authentication, host keys and jump hosts come from the user's
+// ssh configuration.
+type SSHTarget struct {
+	User string
+	Host string
+	Port string
+}
+
+func ParseSSHURL(raw string) (*SSHTarget, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh url %q: %w", raw, err)
+	}
+	if u.Scheme != "ssh" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid ssh url %q (expected ssh://[user@]host[:port])", raw)
+	}
+	t := &SSHTarget{Host: u.Hostname(), Port: u.Port()}
+	if u.User != nil {
+		t.User = u.User.Username()
+	}
+	// ssh would take either for an option, like -oProxyCommand=...
+	if strings.HasPrefix(t.Host, "-") || strings.HasPrefix(t.User, "-") {
+		return nil, fmt.Errorf("invalid ssh url %q (host and user must not start with '-')", raw)
+	}
+	return t, nil
+}
+
+func (t *SSHTarget) String() string {
+	s := t.Host
+	if t.User != "" {
+		s = t.User + "@" + s
+	}
+	if t.Port != "" {
+		s += ":" + t.Port
+	}
+	return "ssh://" + s
+}
+
+func (t *SSHTarget) sshArgs() []string {
+	args := []string{"-o", "BatchMode=yes"}
+	if t.Port != "" {
+		args = append(args, "-p", t.Port)
+	}
+	dest := t.Host
+	if t.User != "" {
+		dest = t.User + "@" + dest
+	}
+	return append(args, "--", dest)
+}
+
+// Command builds an ssh invocation running args on the remote host; each argument is
+// quoted for the remote shell.
+func (t *SSHTarget) Command(ctx context.Context, args ...string) *exec.Cmd {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = ShellQuote(a)
+	}
+	return exec.CommandContext(ctx, "ssh", append(t.sshArgs(), strings.Join(quoted, " "))...)
+}
+
+// Run executes args remotely, streaming the remote output to stdout/stderr.
+func (t *SSHTarget) Run(ctx context.Context, args ...string) error {
+	cmd := t.Command(ctx, args...)
+	cmd.Stdout = os.Stdout
+	var stderr bytes.Buffer
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ssh %s %s failed: %v: %s", t.Host, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// Output executes args remotely and returns stdout.
+func (t *SSHTarget) Output(ctx context.Context, args ...string) (string, error) {
+	cmd := t.Command(ctx, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("ssh %s %s failed: %v: %s", t.Host, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// Upload streams a local file to remotePath over the ssh connection; the file is written to a
+// temporary name first and renamed so a broken transfer never leaves a partial archive behind.
+func (t *SSHTarget) Upload(ctx context.Context, localPath, remotePath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tmp := remotePath + ".part"
+	script := fmt.Sprintf("cat > %s && mv -f %s %s", ShellQuote(tmp), ShellQuote(tmp), ShellQuote(remotePath))
+	cmd := exec.CommandContext(ctx, "ssh", append(t.sshArgs(), script)...)
+	cmd.Stdin = f
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("upload to %s:%s failed: %v: %s", t.Host, remotePath, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// ShellQuote quotes s for a POSIX shell.
+func ShellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=,@+%", r)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
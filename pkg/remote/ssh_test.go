@@ -0,0 +1,41 @@
+package remote
+
+import "testing"
+
+func TestParseSSHURL(t *testing.T) {
+	tgt, err := ParseSSHURL("ssh://deploy@example.com:2222")
+	if err != nil {
+		t.Fatalf("ParseSSHURL: %v", err)
+	}
+	if tgt.User != "deploy" || tgt.Host != "example.com" || tgt.Port != "2222" {
+		t.Fatalf("unexpected target %+v", tgt)
+	}
+	if tgt.String() != "ssh://deploy@example.com:2222" {
+		t.Fatalf("unexpected String() %s", tgt.String())
+	}
+	if _, err := ParseSSHURL("example.com"); err == nil {
+		t.Fatalf("expected error for url without scheme")
+	}
+	for _, raw := range []string{"ssh://-oProxyCommand=id", "ssh://-oProxyCommand=id@example.com"} {
+		if _, err := ParseSSHURL(raw); err == nil {
+			t.Fatalf("expected error for %s, which ssh would read as an option", raw)
+		}
+	}
+	if args := tgt.sshArgs(); args[len(args)-2] != "--" || args[len(args)-1] != "deploy@example.com" {
+		t.Fatalf("the destination does not follow --: %v", args)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	cases := map[string]string{
+		"":              "''",
+		"/tmp/a.tar.gz": "/tmp/a.tar.gz",
+		"it's":          `'it'\''s'`,
+		"a b":           "'a b'",
+	}
+	for in, want := range cases {
+		if got := ShellQuote(in); got != want {
+			t.Errorf("ShellQuote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
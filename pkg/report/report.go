@@ -0,0 +1,186 @@
+// Package report renders backup verification results as JUnit XML or TAP for CI systems.
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Result is the outcome of verifying one backup.
+type Result struct {
+	Name     string
+	Status   string // OK, CORRUPT, MISMATCH, ...
+	Detail   string
+	Duration time.Duration
+}
+
+// Failed reports whether the result counts as a test failure.
+func (r Result) Failed() bool { return r.Status != "OK" }
+
+// Suite is one verification run, e.g. all backups of a repository.
+type Suite struct {
+	Name      string
+	Hostname  string
+	Timestamp time.Time
+	Results   []Result
+}
+
+func (s Suite) failures() int {
+	n := 0
+	for _, r := range s.Results {
+		if r.Failed() {
+			n++
+		}
+	}
+	return n
+}
+
+func (s Suite) duration() time.Duration {
+	var d time.Duration
+	for _, r := range s.Results {
+		d += r.Duration
+	}
+	return d
+}
+
+// Formats accepted by Write.
+const (
+	FormatJUnit = "junit"
+	FormatTAP   = "tap"
+)
+
+// FormatFor infers the report format from a file name: *.tap is TAP, anything else JUnit.
+func FormatFor(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".tap") {
+		return FormatTAP
+	}
+	return FormatJUnit
+}
+
+// Write renders s in format ("" infers it from path) to path; "-" writes to stdout.
+func Write(path, format string, s Suite) error {
+	if format == "" {
+		format = FormatFor(path)
+	}
+	var render func(io.Writer, Suite) error
+	switch format {
+	case FormatJUnit:
+		render = WriteJUnit
+	case FormatTAP:
+		render = WriteTAP
+	default:
+		return fmt.Errorf("unknown report format %q (want %s or %s)", format, FormatJUnit, FormatTAP)
+	}
+	if path == "-" {
+		return render(os.Stdout, s)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := render(f, s); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr,omitempty"`
+	Hostname  string      `xml:"hostname,attr,omitempty"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func seconds(d time.Duration) string { return fmt.Sprintf("%.3f", d.Seconds()) }
+
+// WriteJUnit renders s as a JUnit XML report with one testcase per backup.
+func WriteJUnit(w io.Writer, s Suite) error {
+	suite := junitSuite{
+		Name:     s.Name,
+		Tests:    len(s.Results),
+		Failures: s.failures(),
+		Time:     seconds(s.duration()),
+		Hostname: s.Hostname,
+	}
+	if !s.Timestamp.IsZero() {
+		suite.Timestamp = s.Timestamp.UTC().Format("2006-01-02T15:04:05")
+	}
+	for _, r := range s.Results {
+		c := junitCase{Name: r.Name, Classname: "dockerbackup.check", Time: seconds(r.Duration)}
+		if r.Failed() {
+			c.Failure = &junitFailure{Message: r.Status + ": " + r.Detail, Type: r.Status, Text: r.Detail}
+		} else {
+			c.SystemOut = r.Detail
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	doc := junitSuites{Name: "dockerbackup", Tests: suite.Tests, Failures: suite.Failures, Time: suite.Time, Suites: []junitSuite{suite}}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteTAP renders s as TAP version 13 with a YAML diagnostic block for failures.
+func WriteTAP(w io.Writer, s Suite) error {
+	var b strings.Builder
+	b.WriteString("TAP version 13\n")
+	fmt.Fprintf(&b, "1..%d\n", len(s.Results))
+	if s.Name != "" {
+		fmt.Fprintf(&b, "# %s\n", s.Name)
+	}
+	for i, r := range s.Results {
+		name := strings.ReplaceAll(r.Name, "#", "\\#")
+		if !r.Failed() {
+			fmt.Fprintf(&b, "ok %d - %s\n", i+1, name)
+			continue
+		}
+		fmt.Fprintf(&b, "not ok %d - %s\n", i+1, name)
+		b.WriteString("  ---\n")
+		fmt.Fprintf(&b, "  status: %s\n", r.Status)
+		fmt.Fprintf(&b, "  message: %q\n", r.Detail)
+		fmt.Fprintf(&b, "  duration_ms: %d\n", r.Duration.Milliseconds())
+		b.WriteString("  ...\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,57 @@
+package report
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSuite() Suite {
+	return Suite{
+		Name:      "/backups",
+		Timestamp: time.Date(2024, 5, 1, 3, 0, 0, 0, time.UTC),
+		Results: []Result{
+			{Name: "web_2024-05-01.tar.gz", Status: "OK", Detail: "12 entries", Duration: 1500 * time.Millisecond},
+			{Name: "db_2024-05-01.tar.gz", Status: "CORRUPT", Detail: "unexpected EOF", Duration: time.Second},
+		},
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJUnit(&buf, testSuite()); err != nil {
+		t.Fatalf("WriteJUnit: %v", err)
+	}
+	var doc junitSuites
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("report is not valid XML: %v\n%s", err, buf.String())
+	}
+	if doc.Tests != 2 || doc.Failures != 1 || doc.Time != "2.500" || len(doc.Suites) != 1 {
+		t.Fatalf("unexpected totals: %+v", doc)
+	}
+	cases := doc.Suites[0].Cases
+	if cases[0].Failure != nil || cases[1].Failure == nil || cases[1].Failure.Type != "CORRUPT" {
+		t.Fatalf("unexpected testcases: %+v", cases)
+	}
+}
+
+func TestWriteTAP(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteTAP(&buf, testSuite()); err != nil {
+		t.Fatalf("WriteTAP: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"TAP version 13\n1..2\n", "ok 1 - web_2024-05-01.tar.gz\n", "not ok 2 - db_2024-05-01.tar.gz\n", "  status: CORRUPT\n"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("TAP output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestFormatFor(t *testing.T) {
+	if FormatFor("results.tap") != FormatTAP || FormatFor("junit.xml") != FormatJUnit {
+		t.Fatalf("unexpected inferred formats")
+	}
+}
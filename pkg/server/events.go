@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/docker"
+)
+
+// Contents is what GET /api/backups/{file}/contents tells about an archive: enough to browse
+// it and to fill in a restore's network and volume mappings.
+type Contents struct {
+	File    string                 `json:"file"`
+	Lineage []*backup.BackupInfo   `json:"lineage"`
+	Entries []archive.ArchiveEntry `json:"entries"`
+	// Networks and Volumes are the networks and named volumes the backup restores
+	Networks []string `json:"networks"`
+	Volumes  []string `json:"volumes"`
+}
+
+func (s *Server) backupContents(w http.ResponseWriter, r *http.Request) {
+	path, err := backup.FindBackup(s.dir, r.PathValue("file"))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	ctx := r.Context()
+	c := Contents{File: filepath.Base(path), Networks: []string{}, Volumes: []string{}}
+	if c.Lineage, err = backup.Lineage(ctx, path); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	h := archive.NewTarArchiveHandler()
+	if c.Entries, err = h.ListArchive(ctx, path); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	has := map[string]bool{}
+	for _, en := range c.Entries {
+		has[strings.TrimPrefix(en.Path, "./")] = true
+	}
+	if has["networks/network_configs.json"] {
+		var nets []docker.NetworkConfig
+		if b, err := h.ReadEntry(ctx, path, "networks/network_configs.json"); err == nil && json.Unmarshal(b, &nets) == nil {
+			for _, n := range nets {
+				c.Networks = append(c.Networks, n.Name)
+			}
+		}
+	}
+	if has["volumes/volume_configs.json"] {
+		var vols []docker.VolumeConfig
+		if b, err := h.ReadEntry(ctx, path, "volumes/volume_configs.json"); err == nil && json.Unmarshal(b, &vols) == nil {
+			for _, v := range vols {
+				c.Volumes = append(c.Volumes, v.Name)
+			}
+		}
+	} else {
+		// container backups hold a volumes/<name>.tar.gz per named volume
+		for name := range has {
+			vol, ok := strings.CutPrefix(name, "volumes/")
+			if !ok || strings.Contains(vol, "/") || strings.HasPrefix(vol, "bind_") || !strings.HasSuffix(vol, ".tar.gz") {
+				continue
+			}
+			c.Volumes = append(c.Volumes, strings.TrimSuffix(vol, ".tar.gz"))
+		}
+	}
+	sort.Strings(c.Networks)
+	sort.Strings(c.Volumes)
+	writeJSON(w, http.StatusOK, c)
+}
+
+// jobEvents streams a job as server-sent events: a "status" event with the job whenever its
+// status changes, a "log" event per line it logs (from its first line, so late subscribers
+// catch up) and "done" once it finished.
+func (s *Server) jobEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+	id := r.PathValue("id")
+	if _, ok := s.jobs.get(id); !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", id))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event string, v any) {
+		b, _ := json.Marshal(v)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+	}
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	next := 0
+	var status JobStatus
+	for {
+		job, lines, n, changed, ok := s.jobs.watch(id, next)
+		if !ok {
+			// forgotten while watched: pruned after finishing
+			send("done", nil)
+			flusher.Flush()
+			return
+		}
+		next = n
+		for _, l := range lines {
+			send("log", l)
+		}
+		if job.Status != status {
+			status = job.Status
+			send("status", job)
+		}
+		if status == JobSucceeded || status == JobFailed {
+			send("done", job)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		}
+	}
+}
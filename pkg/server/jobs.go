@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/backup"
+)
+
+// JobStatus is where a job is in the queue.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job is a backup or restore requested through the API.
+type Job struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	// Target is the container(s) or compose project backed up, or the archive restored
+	Target   string     `json:"target"`
+	Status   JobStatus  `json:"status"`
+	Error    string     `json:"error,omitempty"`
+	Queued   time.Time  `json:"queued"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// Backup is the archive a backup job wrote, relative to the backup directory
+	Backup     string               `json:"backup,omitempty"`
+	Report     *backup.BackupReport `json:"report,omitempty"`
+	RestoredID string               `json:"restoredId,omitempty"`
+	Warnings   []backup.Warning     `json:"warnings,omitempty"`
+
+	run func(ctx context.Context, job *Job, log logger.Logger) error
+	// log holds what the job logged at info level and above, for its event stream
+	log []LogLine
+	// dropped counts the lines dropped from the start of log
+	dropped int
+	// changed is closed, and replaced, whenever the job's status or log changes
+	changed chan struct{}
+}
+
+// LogLine is a message logged by a job.
+type LogLine struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+}
+
+// maxLogLines bounds the log kept per job; the oldest lines are dropped beyond it.
+const maxLogLines = 5000
+
+// queue runs jobs one at a time, in the order they were submitted: the engine keeps state for
+// the operation in progress, and backups of a homelab's containers contend for the same disks
+// anyway. The most recent finished jobs are kept for status queries.
+type queue struct {
+	log     logger.Logger
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	order   []string
+	pending chan *Job
+	seq     int
+	keep    int
+}
+
+func newQueue(size, keep int, log logger.Logger) *queue {
+	return &queue{log: log, jobs: map[string]*Job{}, pending: make(chan *Job, size), keep: keep}
+}
+
+// errQueueFull is returned by submit when size jobs are waiting already.
+var errQueueFull = fmt.Errorf("the job queue is full; try again later")
+
+func (q *queue) submit(kind, target string, run func(ctx context.Context, job *Job, log logger.Logger) error) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.seq++
+	job := &Job{
+		ID:      fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405"), q.seq),
+		Kind:    kind,
+		Target:  target,
+		Status:  JobQueued,
+		Queued:  time.Now(),
+		run:     run,
+		changed: make(chan struct{}),
+	}
+	select {
+	case q.pending <- job:
+	default:
+		return Job{}, errQueueFull
+	}
+	q.jobs[job.ID] = job
+	q.order = append(q.order, job.ID)
+	q.prune()
+	return *job, nil
+}
+
+// prune forgets the oldest finished jobs beyond keep.
+func (q *queue) prune() {
+	finished := 0
+	for _, id := range q.order {
+		if st := q.jobs[id].Status; st == JobSucceeded || st == JobFailed {
+			finished++
+		}
+	}
+	kept := q.order[:0]
+	for _, id := range q.order {
+		if st := q.jobs[id].Status; finished > q.keep && (st == JobSucceeded || st == JobFailed) {
+			delete(q.jobs, id)
+			finished--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	q.order = kept
+}
+
+// get returns a copy of the job.
+func (q *queue) get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// list returns copies of the known jobs, newest first.
+func (q *queue) list() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([]Job, 0, len(q.order))
+	for i := len(q.order) - 1; i >= 0; i-- {
+		out = append(out, *q.jobs[q.order[i]])
+	}
+	return out
+}
+
+// watch returns the job, the lines it logged from line number from on (those still kept), the
+// number of the line after them and a channel closed on the job's next change.
+func (q *queue) watch(id string, from int) (Job, []LogLine, int, <-chan struct{}, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, nil, from, nil, false
+	}
+	var lines []LogLine
+	if i := max(from-job.dropped, 0); i < len(job.log) {
+		lines = append(lines, job.log[i:]...)
+	}
+	return *job, lines, job.dropped + len(job.log), job.changed, true
+}
+
+// work runs the submitted jobs until ctx is done.
+func (q *queue) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-q.pending:
+			q.runJob(ctx, job)
+		}
+	}
+}
+
+func (q *queue) runJob(ctx context.Context, job *Job) {
+	now := time.Now()
+	q.update(job, func(j *Job) {
+		j.Status = JobRunning
+		j.Started = &now
+	})
+	// run fills in the result on a copy, published when it finishes
+	res := *job
+	err := job.run(ctx, &res, &jobLogger{base: q.log.With("job", job.ID), q: q, job: job})
+	done := time.Now()
+	q.update(job, func(j *Job) {
+		j.Backup, j.Report, j.RestoredID, j.Warnings = res.Backup, res.Report, res.RestoredID, res.Warnings
+		j.Status = JobSucceeded
+		if err != nil {
+			j.Status = JobFailed
+			j.Error = err.Error()
+		}
+		j.Finished = &done
+	})
+	q.mu.Lock()
+	q.prune()
+	q.mu.Unlock()
+}
+
+func (q *queue) update(job *Job, fn func(j *Job)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	fn(job)
+	close(job.changed)
+	job.changed = make(chan struct{})
+}
+
+// jobLogger logs to the server's log and, at info level and above, to the job's own.
+type jobLogger struct {
+	base   logger.Logger
+	q      *queue
+	job    *Job
+	prefix string
+}
+
+func (l *jobLogger) Infof(format string, args ...any) {
+	l.base.Infof(format, args...)
+	l.record("info", format, args...)
+}
+
+func (l *jobLogger) Errorf(format string, args ...any) {
+	l.base.Errorf(format, args...)
+	l.record("error", format, args...)
+}
+
+func (l *jobLogger) Debugf(format string, args ...any) {
+	l.base.Debugf(format, args...)
+}
+
+func (l *jobLogger) With(key string, value any) logger.Logger {
+	return &jobLogger{base: l.base.With(key, value), q: l.q, job: l.job, prefix: l.prefix + fmt.Sprintf("[%s=%v] ", key, value)}
+}
+
+func (l *jobLogger) record(level, format string, args ...any) {
+	line := LogLine{Time: time.Now(), Level: level, Message: l.prefix + fmt.Sprintf(format, args...)}
+	l.q.update(l.job, func(j *Job) {
+		j.log = append(j.log, line)
+		if n := len(j.log) - maxLogLines; n > 0 {
+			j.log = append([]LogLine(nil), j.log[n:]...)
+			j.dropped += n
+		}
+	})
+}
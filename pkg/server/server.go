@@ -0,0 +1,371 @@
+// Package server exposes the backup engine over a small REST API, so dashboards and scripts
+// can trigger backups and restores, follow them and download archives.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	stdErrors "errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/brian033/dockerbackup/internal/errors"
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/archive"
+	"github.com/brian033/dockerbackup/pkg/audit"
+	"github.com/brian033/dockerbackup/pkg/backup"
+)
+
+// TokenEnv holds the API token when --token is not given.
+const TokenEnv = "DOCKERBACKUP_API_TOKEN"
+
+const (
+	// queueSize is how many jobs may wait to run
+	queueSize = 32
+	// keepJobs is how many finished jobs are remembered
+	keepJobs = 100
+)
+
+// Server serves the API and the web UI. Backups are written to, and restored and downloaded
+// from, one directory.
+type Server struct {
+	// newEngine returns the engine a job runs on, logging to the job
+	newEngine func(log logger.Logger) backup.BackupEngine
+	dir       string
+	token     string
+	log       logger.Logger
+	jobs      *queue
+}
+
+// New returns a server for the backups in dir, running each job on an engine from newEngine.
+// Every API request but /api/health must carry "Authorization: Bearer <token>".
+func New(newEngine func(log logger.Logger) backup.BackupEngine, dir, token string, log logger.Logger) (*Server, error) {
+	if token == "" {
+		return nil, &errors.ValidationError{Field: "token", Msg: "an API token is required (--token or $" + TokenEnv + ")"}
+	}
+	return &Server{newEngine: newEngine, dir: dir, token: token, log: log, jobs: newQueue(queueSize, keepJobs, log)}, nil
+}
+
+// NewLocal returns a server that only runs jobs, submitted through SubmitBackup and
+// SubmitRestore by a front end in the same process (the tui command); it has no token, so its
+// Handler rejects every API request.
+func NewLocal(newEngine func(log logger.Logger) backup.BackupEngine, dir string, log logger.Logger) *Server {
+	return &Server{newEngine: newEngine, dir: dir, log: log, jobs: newQueue(queueSize, keepJobs, log)}
+}
+
+// Run runs the queued jobs until ctx is done; a job running then is canceled.
+func (s *Server) Run(ctx context.Context) {
+	s.jobs.work(ctx)
+}
+
+// Handler returns the web UI, at /, and the API's routes:
+//
+//	GET  /api/health                  liveness, without authentication
+//	GET  /api/backups                 the catalog (?tag=, repeatable, and ?target=)
+//	GET  /api/backups/{file}          download an archive
+//	GET  /api/backups/{file}/contents entries, networks and volumes of an archive
+//	POST /api/backups                 queue a backup
+//	POST /api/restores                queue a restore
+//	GET  /api/jobs                    queued, running and recent jobs
+//	GET  /api/jobs/{id}               one job
+//	GET  /api/jobs/{id}/events        a job's log and status changes as server-sent events
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	handleUI(mux)
+	mux.HandleFunc("GET /api/health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.Handle("GET /api/backups", s.auth(s.listBackups))
+	mux.Handle("GET /api/backups/{file}", s.auth(s.downloadBackup))
+	mux.Handle("GET /api/backups/{file}/contents", s.auth(s.backupContents))
+	mux.Handle("POST /api/backups", s.auth(s.queueBackup))
+	mux.Handle("POST /api/restores", s.auth(s.queueRestore))
+	mux.Handle("GET /api/jobs", s.auth(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.jobs.list())
+	}))
+	mux.Handle("GET /api/jobs/{id}", s.auth(func(w http.ResponseWriter, r *http.Request) {
+		job, ok := s.jobs.get(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", r.PathValue("id")))
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	}))
+	mux.Handle("GET /api/jobs/{id}/events", s.auth(s.jobEvents))
+	return mux
+}
+
+// auth rejects requests without the bearer token.
+func (s *Server) auth(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || s.token == "" || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(got)), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dockerbackup"`)
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid token"))
+			return
+		}
+		h(w, r)
+	})
+}
+
+func (s *Server) listBackups(w http.ResponseWriter, r *http.Request) {
+	entries, err := backup.Catalog(r.Context(), s.dir, r.URL.Query().Get("target"), r.URL.Query()["tag"])
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (s *Server) downloadBackup(w http.ResponseWriter, r *http.Request) {
+	path, err := backup.FindBackup(s.dir, r.PathValue("file"))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	if strings.HasSuffix(path, archive.SplitManifestSuffix) {
+		writeError(w, http.StatusConflict, fmt.Errorf("%s is split into parts; download them from the backup directory", filepath.Base(path)))
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+	st, err := f.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(path)))
+	http.ServeContent(w, r, filepath.Base(path), st.ModTime(), f)
+}
+
+// BackupRequest is the body of POST /api/backups: one of Container, Containers or Project.
+type BackupRequest struct {
+	Container  string   `json:"container,omitempty"`
+	Containers []string `json:"containers,omitempty"`
+	// Project is the directory of a compose project on the server
+	Project string   `json:"project,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Note    string   `json:"note,omitempty"`
+}
+
+func (s *Server) queueBackup(w http.ResponseWriter, r *http.Request) {
+	var body BackupRequest
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	job, err := s.SubmitBackup(body)
+	s.accepted(w, job, err)
+}
+
+// SubmitBackup queues a backup into the backup directory.
+func (s *Server) SubmitBackup(body BackupRequest) (Job, error) {
+	req := backup.BackupRequest{
+		TargetType: backup.TargetContainer,
+		Options:    backup.NewBackupOptionsBuilder().WithOutput(s.dir).WithTimestamped(true).WithAnnotations(body.Tags, body.Note).Build(),
+	}
+	var target string
+	switch {
+	case body.Container != "" && len(body.Containers) == 0 && body.Project == "":
+		req.ContainerID, target = body.Container, body.Container
+	case len(body.Containers) > 0 && body.Container == "" && body.Project == "":
+		req.ContainerIDs, target = body.Containers, strings.Join(body.Containers, ",")
+	case body.Project != "" && body.Container == "" && len(body.Containers) == 0:
+		req.TargetType, req.ComposeProjectPath, target = backup.TargetCompose, body.Project, body.Project
+	default:
+		return Job{}, &errors.ValidationError{Msg: "give one of container, containers or project"}
+	}
+	for _, t := range body.Tags {
+		if t == "" || strings.ContainsAny(t, ", \t\n") {
+			return Job{}, &errors.ValidationError{Msg: fmt.Sprintf("invalid tag %q (tags are single words without commas)", t)}
+		}
+	}
+	return s.submit("backup", target, func(ctx context.Context, job *Job, engine backup.BackupEngine) error {
+		res, err := engine.Backup(ctx, req)
+		if err != nil {
+			return err
+		}
+		job.Backup, job.Report, job.Warnings = filepath.Base(res.OutputPath), res.Report, res.Warnings
+		return nil
+	})
+}
+
+// RestoreRequest is the body of POST /api/restores.
+type RestoreRequest struct {
+	// Backup is the archive's file name in the backup directory
+	Backup string `json:"backup"`
+	// Name of the restored container, or project name of a restored compose project
+	Name  string `json:"name,omitempty"`
+	Start bool   `json:"start,omitempty"`
+	// Replace removes a container of the same name first
+	Replace  bool `json:"replace,omitempty"`
+	Isolated bool `json:"isolated,omitempty"`
+	// NetworkMap and VolumeMap rename networks and volumes of the backup, as restore --network-map
+	// and --volume-map do
+	NetworkMap     map[string]string `json:"networkMap,omitempty"`
+	VolumeMap      map[string]string `json:"volumeMap,omitempty"`
+	DropHostIPs    bool              `json:"dropHostIPs,omitempty"`
+	FallbackBridge bool              `json:"fallbackBridge,omitempty"`
+}
+
+func (s *Server) queueRestore(w http.ResponseWriter, r *http.Request) {
+	var body RestoreRequest
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	job, err := s.SubmitRestore(body, "api "+r.RemoteAddr)
+	s.accepted(w, job, err)
+}
+
+// SubmitRestore queues a restore of an archive in the backup directory; via tells the audit
+// log where it was requested.
+func (s *Server) SubmitRestore(body RestoreRequest, via string) (Job, error) {
+	path, err := backup.FindBackup(s.dir, body.Backup)
+	if err != nil {
+		return Job{}, err
+	}
+	req := backup.RestoreRequest{
+		BackupPath:  path,
+		ProjectName: body.Name,
+		Options: backup.RestoreOptions{
+			ContainerName:   body.Name,
+			Start:           body.Start,
+			ReplaceExisting: body.Replace,
+			Isolated:        body.Isolated,
+			NetworkMap:      nonEmpty(body.NetworkMap),
+			VolumeMap:       nonEmpty(body.VolumeMap),
+			DropHostIPs:     body.DropHostIPs,
+			FallbackBridge:  body.FallbackBridge,
+		},
+	}
+	return s.submit("restore", filepath.Base(path), func(ctx context.Context, job *Job, engine backup.BackupEngine) error {
+		rec, err := audit.Begin("restore", path, body.flags())
+		if err != nil {
+			return err
+		}
+		rec.Via = via
+		res, err := engine.Restore(ctx, req)
+		if res != nil {
+			rec.Details = audit.Conflicts(res.Warnings)
+		}
+		if err := rec.End(err); err != nil {
+			return err
+		}
+		job.RestoredID, job.Warnings = res.RestoredID, res.Warnings
+		return nil
+	})
+}
+
+// flags renders the request as the flags of the restore command, for the audit log.
+func (b *RestoreRequest) flags() []string {
+	out := []string{b.Backup}
+	if b.Name != "" {
+		out = append(out, "--name="+b.Name)
+	}
+	for _, f := range []struct {
+		name string
+		on   bool
+	}{{"start", b.Start}, {"replace", b.Replace}, {"isolated", b.Isolated}, {"drop-host-ips", b.DropHostIPs}, {"fallback-bridge", b.FallbackBridge}} {
+		if f.on {
+			out = append(out, "--"+f.name)
+		}
+	}
+	out = append(out, audit.MapFlags("network-map", b.NetworkMap)...)
+	return append(out, audit.MapFlags("volume-map", b.VolumeMap)...)
+}
+
+func (s *Server) submit(kind, target string, run func(ctx context.Context, job *Job, engine backup.BackupEngine) error) (Job, error) {
+	return s.jobs.submit(kind, target, func(ctx context.Context, job *Job, log logger.Logger) error {
+		log.Infof("Starting %s of %s", kind, target)
+		err := run(ctx, job, s.newEngine(log))
+		if err != nil {
+			log.Errorf("Failed %s of %s: %v", kind, target, err)
+		} else {
+			log.Infof("Finished %s of %s", kind, target)
+		}
+		return err
+	})
+}
+
+// accepted answers a request that queued job, or failed to with err.
+func (s *Server) accepted(w http.ResponseWriter, job Job, err error) {
+	switch {
+	case err == errQueueFull:
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	case err != nil:
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.Header().Set("Location", "/api/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// Jobs returns the queued, running and recent jobs, newest first.
+func (s *Server) Jobs() []Job {
+	return s.jobs.list()
+}
+
+// JobLog returns the lines job id logged from line number from on and the number of the line
+// after them, as its event stream does.
+func (s *Server) JobLog(id string, from int) ([]LogLine, int, bool) {
+	_, lines, next, _, ok := s.jobs.watch(id, from)
+	return lines, next, ok
+}
+
+// nonEmpty drops the entries of a mapping form left blank.
+func nonEmpty(m map[string]string) map[string]string {
+	out := map[string]string{}
+	for k, v := range m {
+		if k = strings.TrimSpace(k); k != "" && strings.TrimSpace(v) != "" {
+			out[k] = strings.TrimSpace(v)
+		}
+	}
+	return out
+}
+
+// decodeBody decodes a JSON request body, rejecting unknown fields so typos do not go
+// unnoticed.
+func decodeBody(r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+// statusOf maps a not found or validation error to its HTTP status.
+func statusOf(err error) int {
+	var nf *errors.NotFoundError
+	var ve *errors.ValidationError
+	switch {
+	case stdErrors.As(err, &nf):
+		return http.StatusNotFound
+	case stdErrors.As(err, &ve):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/logger"
+	"github.com/brian033/dockerbackup/pkg/audit"
+	"github.com/brian033/dockerbackup/pkg/backup"
+)
+
+type fakeEngine struct {
+	dir      string
+	requests []backup.BackupRequest
+}
+
+func (f *fakeEngine) Backup(ctx context.Context, req backup.BackupRequest) (*backup.BackupResult, error) {
+	f.requests = append(f.requests, req)
+	out := filepath.Join(f.dir, req.ContainerID+"_2024-01-02T03-04-05.tar.gz")
+	if err := os.WriteFile(out, []byte("archive"), 0o644); err != nil {
+		return nil, err
+	}
+	return &backup.BackupResult{OutputPath: out}, nil
+}
+
+func (f *fakeEngine) Restore(ctx context.Context, req backup.RestoreRequest) (*backup.RestoreResult, error) {
+	return &backup.RestoreResult{RestoredID: "abc"}, nil
+}
+
+func (f *fakeEngine) Validate(ctx context.Context, backupPath string) (*backup.ValidationResult, error) {
+	return &backup.ValidationResult{Valid: true}, nil
+}
+
+func (f *fakeEngine) ValidateDeep(ctx context.Context, backupPath string) (*backup.ValidationResult, error) {
+	return &backup.ValidationResult{Valid: true}, nil
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	auditLog := filepath.Join(t.TempDir(), "audit.jsonl")
+	t.Setenv(audit.PathEnv, auditLog)
+	eng := &fakeEngine{dir: dir}
+	newEngine := func(logger.Logger) backup.BackupEngine { return eng }
+	srv, err := New(newEngine, dir, "secret", logger.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(newEngine, dir, "", logger.New()); err == nil {
+		t.Fatal("a server without a token was created")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.Run(ctx)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	do := func(method, path, token, body string) (*http.Response, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp, b
+	}
+
+	if resp, _ := do("GET", "/api/health", "", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("health: %d", resp.StatusCode)
+	}
+	for _, token := range []string{"", "wrong"} {
+		if resp, _ := do("GET", "/api/jobs", token, ""); resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("token %q: %d, want 401", token, resp.StatusCode)
+		}
+	}
+	if resp, _ := do("POST", "/api/backups", "secret", `{"container":"web","project":"/srv"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("two targets: %d, want 400", resp.StatusCode)
+	}
+	if resp, _ := do("POST", "/api/backups", "secret", `{"contianer":"web"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unknown field: %d, want 400", resp.StatusCode)
+	}
+
+	resp, b := do("POST", "/api/backups", "secret", `{"container":"web","tags":["nightly"]}`)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("queue backup: %d %s", resp.StatusCode, b)
+	}
+	var job Job
+	if err := json.Unmarshal(b, &job); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Location") != "/api/jobs/"+job.ID {
+		t.Fatalf("Location = %q", resp.Header.Get("Location"))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status != JobSucceeded && job.Status != JobFailed {
+		if time.Now().After(deadline) {
+			t.Fatalf("job still %s", job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+		_, b = do("GET", "/api/jobs/"+job.ID, "secret", "")
+		job = Job{}
+		if err := json.Unmarshal(b, &job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if job.Status != JobSucceeded || job.Backup != "web_2024-01-02T03-04-05.tar.gz" {
+		t.Fatalf("job = %+v", job)
+	}
+	_, b = do("GET", "/api/jobs/"+job.ID+"/events", "secret", "")
+	for _, want := range []string{"event: log\ndata: ", "Starting backup of web", "event: status\n", "event: done\n"} {
+		if !strings.Contains(string(b), want) {
+			t.Fatalf("events lack %q:\n%s", want, b)
+		}
+	}
+	if resp, b := do("GET", "/", "", ""); resp.StatusCode != http.StatusOK || !strings.Contains(string(b), "/ui/app.js") {
+		t.Fatalf("UI: %d", resp.StatusCode)
+	}
+	req := eng.requests[0]
+	if req.ContainerID != "web" || req.Options.OutputPath != dir || !req.Options.Timestamped || len(req.Options.Tags) != 1 {
+		t.Fatalf("backup request = %+v", req)
+	}
+
+	resp, b = do("GET", "/api/backups/"+job.Backup, "secret", "")
+	if resp.StatusCode != http.StatusOK || string(b) != "archive" {
+		t.Fatalf("download: %d %q", resp.StatusCode, b)
+	}
+	if resp, _ := do("GET", "/api/backups/..%2Fsecret.tar.gz", "secret", ""); resp.StatusCode != http.StatusBadRequest && resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("download outside the directory: %d", resp.StatusCode)
+	}
+	if resp, _ := do("POST", "/api/restores", "secret", `{"backup":"missing.tar.gz"}`); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("restore of a missing backup: %d, want 404", resp.StatusCode)
+	}
+	if resp, b := do("POST", "/api/restores", "secret", `{"backup":"`+job.Backup+`","name":"web2"}`); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("queue restore: %d %s", resp.StatusCode, b)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		entries, _ := audit.Read(auditLog)
+		if len(entries) == 1 {
+			if e := entries[0]; e.Op != "restore" || !strings.HasPrefix(e.Via, "api ") || e.Result != audit.ResultOK || strings.Join(e.Args, " ") != job.Backup+" --name=web2" {
+				t.Fatalf("audit entry = %+v", e)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the restore was not audited")
+		}
+	}
+}
+
+func TestQueueKeepsRecentJobs(t *testing.T) {
+	q := newQueue(10, 2, logger.New())
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		if _, err := q.submit("backup", "web", func(ctx context.Context, job *Job, log logger.Logger) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		q.runJob(ctx, <-q.pending)
+	}
+	jobs := q.list()
+	if len(jobs) != 2 || !strings.HasSuffix(jobs[0].ID, "-4") {
+		t.Fatalf("jobs = %+v", jobs)
+	}
+	full := newQueue(1, 2, logger.New())
+	run := func(ctx context.Context, job *Job, log logger.Logger) error { return nil }
+	if _, err := full.submit("backup", "a", run); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := full.submit("backup", "b", run); err != errQueueFull {
+		t.Fatalf("err = %v, want errQueueFull", err)
+	}
+}
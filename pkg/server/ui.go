@@ -0,0 +1,26 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// uiFiles is the web UI: a single page on top of the API, which asks for the token once and
+// keeps it in the browser's local storage.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// handleUI serves the web UI at / and its assets under /ui/.
+func handleUI(mux *http.ServeMux) {
+	sub, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	mux.Handle("GET /ui/", http.StripPrefix("/ui/", http.FileServerFS(sub)))
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'self'")
+		http.ServeFileFS(w, r, sub, "index.html")
+	})
+}
@@ -0,0 +1,275 @@
+// The dockerbackup web UI: a thin client of the API served next to it.
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+let token = localStorage.getItem("dockerbackup.token") || "";
+let watching = null;
+
+async function api(path, options = {}) {
+  const res = await fetch(path, {
+    ...options,
+    headers: { Authorization: "Bearer " + token, ...(options.headers || {}) },
+  });
+  if (res.status === 401) {
+    signOut("The token was rejected.");
+    throw new Error("unauthorized");
+  }
+  if (!res.ok) {
+    let msg = res.statusText;
+    try { msg = (await res.json()).error || msg; } catch (e) { /* not JSON */ }
+    throw new Error(msg);
+  }
+  return res;
+}
+
+const getJSON = async (path) => (await api(path)).json();
+
+function postJSON(path, body) {
+  return api(path, { method: "POST", headers: { "Content-Type": "application/json" }, body: JSON.stringify(body) });
+}
+
+function el(tag, props = {}, ...children) {
+  const e = document.createElement(tag);
+  Object.assign(e, props);
+  for (const c of children) e.append(c);
+  return e;
+}
+
+function button(text, onclick) {
+  return el("button", { type: "button", textContent: text, onclick });
+}
+
+function showError(err) {
+  $("error").textContent = err ? String(err.message || err) : "";
+}
+
+function formatSize(n) {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+  return (i ? n.toFixed(1) : n) + " " + units[i];
+}
+
+const formatTime = (t) => (t ? new Date(t).toLocaleString() : "-");
+
+function source(b) {
+  if (b.projectName) return "compose " + b.projectName;
+  if (b.containerName) return "container " + b.containerName.replace(/^\//, "");
+  return "host " + (b.hostname || "");
+}
+
+// Backups
+
+async function loadBackups() {
+  const q = new URLSearchParams();
+  if ($("filter-target").value) q.set("target", $("filter-target").value);
+  if ($("filter-tag").value) q.append("tag", $("filter-tag").value);
+  const backups = await getJSON("/api/backups?" + q);
+  const rows = backups.map((b) => el("tr", {},
+    el("td", { textContent: b.file }),
+    el("td", { textContent: formatTime(b.createdAt) }),
+    el("td", { textContent: source(b) }),
+    el("td", { textContent: (b.tags || []).join(", ") }),
+    el("td", { textContent: formatSize(b.size) }),
+    el("td", { className: "actions" },
+      button("Inspect", () => inspect(b.file).catch(showError)),
+      button("Restore", () => openRestore(b).catch(showError)),
+      button("Download", () => download(b.file).catch(showError))),
+  ));
+  $("backups").replaceChildren(...rows);
+  $("backups-empty").hidden = rows.length > 0;
+}
+
+async function download(file) {
+  const blob = await (await api("/api/backups/" + encodeURIComponent(file))).blob();
+  const url = URL.createObjectURL(blob);
+  el("a", { href: url, download: file }).click();
+  setTimeout(() => URL.revokeObjectURL(url), 60000);
+}
+
+async function inspect(file) {
+  const c = await getJSON("/api/backups/" + encodeURIComponent(file) + "/contents");
+  $("contents-file").textContent = c.file;
+  const info = c.lineage[0] || {};
+  const facts = [
+    ["ID", info.id], ["Source", source(info)], ["Created", formatTime(info.createdAt)],
+    ["Tags", (info.tags || []).join(", ")], ["Note", info.note],
+    ["Services", (info.services || []).join(", ")],
+    ["Networks", c.networks.join(", ")], ["Volumes", c.volumes.join(", ")],
+    ["Lineage", c.lineage.length > 1 ? c.lineage.map((b) => b.id).join(" ← ") : ""],
+  ].filter(([, v]) => v);
+  $("contents-info").replaceChildren(...facts.flatMap(([k, v]) => [el("dt", { textContent: k }), el("dd", { textContent: v })]));
+  const render = () => {
+    const f = $("contents-filter").value;
+    $("contents-entries").replaceChildren(...c.entries.filter((e) => !f || e.path.includes(f)).slice(0, 2000).map((e) => el("tr", {},
+      el("td", { textContent: e.type }),
+      el("td", { textContent: e.type === "file" ? formatSize(e.size) : "" }),
+      el("td", { textContent: e.path }))));
+  };
+  $("contents-filter").value = "";
+  $("contents-filter").oninput = render;
+  render();
+  $("contents").hidden = false;
+  $("contents").scrollIntoView({ behavior: "smooth" });
+}
+
+// Restore
+
+function mappingRows(container, names, placeholder) {
+  const rows = names.map((name) => {
+    const input = el("input", { placeholder });
+    input.dataset.from = name;
+    return el("div", { className: "mapping" }, el("span", { textContent: name }), input);
+  });
+  container.replaceChildren(...(rows.length ? rows : [el("span", { className: "empty", textContent: "none" })]));
+}
+
+function readMapping(container) {
+  const m = {};
+  for (const input of container.querySelectorAll("input")) {
+    if (input.value.trim()) m[input.dataset.from] = input.value.trim();
+  }
+  return m;
+}
+
+async function openRestore(b) {
+  const c = await getJSON("/api/backups/" + encodeURIComponent(b.file) + "/contents");
+  $("restore-file").textContent = b.file;
+  $("restore-form").reset();
+  $("restore-form").dataset.file = b.file;
+  mappingRows($("restore-networks"), c.networks, "keep this network");
+  mappingRows($("restore-volumes"), c.volumes, "keep this volume");
+  $("restore").hidden = false;
+  $("restore").scrollIntoView({ behavior: "smooth" });
+}
+
+async function submitRestore(ev) {
+  ev.preventDefault();
+  const res = await postJSON("/api/restores", {
+    backup: $("restore-form").dataset.file,
+    name: $("restore-name").value.trim() || undefined,
+    start: $("restore-start").checked,
+    replace: $("restore-replace").checked,
+    isolated: $("restore-isolated").checked,
+    dropHostIPs: $("restore-drop-host-ips").checked,
+    fallbackBridge: $("restore-fallback-bridge").checked,
+    networkMap: readMapping($("restore-networks")),
+    volumeMap: readMapping($("restore-volumes")),
+  });
+  $("restore").hidden = true;
+  watchJob((await res.json()).id);
+}
+
+async function submitBackup(ev) {
+  ev.preventDefault();
+  const body = { [$("backup-kind").value]: $("backup-target").value.trim() };
+  const tags = $("backup-tags").value.split(",").map((t) => t.trim()).filter(Boolean);
+  if (tags.length) body.tags = tags;
+  if ($("backup-note").value.trim()) body.note = $("backup-note").value.trim();
+  const res = await postJSON("/api/backups", body);
+  $("backup-form").reset();
+  watchJob((await res.json()).id);
+}
+
+// Jobs
+
+async function loadJobs() {
+  const jobs = await getJSON("/api/jobs");
+  const rows = jobs.map((j) => el("tr", {},
+    el("td", { textContent: j.id }),
+    el("td", { textContent: j.kind }),
+    el("td", { textContent: j.target }),
+    el("td", {}, el("span", { className: "status " + j.status, textContent: j.status })),
+    el("td", { textContent: formatTime(j.queued) }),
+    el("td", { className: "actions" }, button("Log", () => watchJob(j.id)))));
+  $("jobs").replaceChildren(...rows);
+  $("jobs-empty").hidden = rows.length > 0;
+}
+
+function showJob(job) {
+  $("job-status").textContent = job.status;
+  $("job-status").className = "status " + job.status;
+  $("job-error").textContent = job.error || "";
+}
+
+// watchJob follows a job's event stream; fetch is used rather than EventSource so the token
+// travels in the Authorization header instead of the URL.
+async function watchJob(id) {
+  if (watching) watching.abort();
+  const ctrl = new AbortController();
+  watching = ctrl;
+  $("job-id").textContent = id;
+  $("job-log").textContent = "";
+  showJob({ status: "queued" });
+  $("job").hidden = false;
+  loadJobs().catch(showError);
+  try {
+    const res = await api("/api/jobs/" + encodeURIComponent(id) + "/events", { signal: ctrl.signal });
+    const reader = res.body.pipeThrough(new TextDecoderStream()).getReader();
+    let buf = "";
+    for (;;) {
+      const { value, done } = await reader.read();
+      if (done) break;
+      buf += value;
+      let i;
+      while ((i = buf.indexOf("\n\n")) >= 0) {
+        onEvent(buf.slice(0, i));
+        buf = buf.slice(i + 2);
+      }
+    }
+  } catch (err) {
+    if (err.name !== "AbortError") showError(err);
+  }
+}
+
+function onEvent(block) {
+  let event = "message";
+  let data = "";
+  for (const line of block.split("\n")) {
+    if (line.startsWith("event: ")) event = line.slice(7);
+    else if (line.startsWith("data: ")) data += line.slice(6);
+  }
+  const v = data ? JSON.parse(data) : null;
+  if (event === "log") {
+    const log = $("job-log");
+    log.textContent += new Date(v.time).toLocaleTimeString() + " " + v.level.toUpperCase() + " " + v.message + "\n";
+    log.scrollTop = log.scrollHeight;
+  } else if (event === "status" || (event === "done" && v)) {
+    showJob(v);
+    loadJobs().catch(showError);
+    if (event === "done" && v.kind === "backup") loadBackups().catch(showError);
+  }
+}
+
+// Session
+
+function signOut(msg) {
+  token = "";
+  localStorage.removeItem("dockerbackup.token");
+  $("app").hidden = true;
+  $("logout").hidden = true;
+  $("login").hidden = false;
+  $("login-error").textContent = msg || "";
+}
+
+async function start() {
+  $("login").hidden = true;
+  $("app").hidden = false;
+  $("logout").hidden = false;
+  await Promise.all([loadBackups(), loadJobs()]);
+}
+
+$("login-form").addEventListener("submit", (ev) => {
+  ev.preventDefault();
+  token = $("token").value;
+  localStorage.setItem("dockerbackup.token", token);
+  start().catch(showError);
+});
+$("logout").addEventListener("click", () => signOut());
+$("filter-form").addEventListener("submit", (ev) => { ev.preventDefault(); loadBackups().catch(showError); });
+$("backup-form").addEventListener("submit", (ev) => submitBackup(ev).catch(showError));
+$("restore-form").addEventListener("submit", (ev) => submitRestore(ev).catch(showError));
+$("restore-cancel").addEventListener("click", () => { $("restore").hidden = true; });
+
+if (token) start().catch(showError);
+else signOut();
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/brian033/dockerbackup/pkg/archive"
+)
+
+// ChunkManifestSuffix names the manifest of an archive uploaded in chunks: <archive> plus
+// this suffix lists the chunks that make it up, in order.
+const ChunkManifestSuffix = ".chunks.json"
+
+// chunkDir holds the chunks of every chunked upload to a location, named by their SHA-256,
+// so chunks that several backups share are stored once.
+const chunkDir = "chunks"
+
+// ChunkManifest describes an archive stored as content-defined chunks.
+type ChunkManifest struct {
+	Version int     `json:"version"`
+	Name    string  `json:"name"`
+	Size    int64   `json:"size"`
+	SHA256  string  `json:"sha256"`
+	Chunks  []Chunk `json:"chunks"`
+}
+
+// Chunk is a piece of a chunked archive, by SHA-256 of its content.
+type Chunk struct {
+	Hash string `json:"h"`
+	Size int64  `json:"s"`
+}
+
+// ChunkStats summarizes a chunked upload: how much of the archive had to be sent.
+type ChunkStats struct {
+	Chunks   int
+	Size     int64
+	Uploaded int
+	Sent     int64
+}
+
+// chunkName returns the object holding the chunk with SHA-256 hash.
+func chunkName(hash string) string {
+	return chunkDir + "/" + hash[:2] + "/" + hash
+}
+
+// PutChunked stores the content of r under name as content-defined chunks, uploading only
+// those the location does not hold already, then the manifest name+ChunkManifestSuffix. The
+// manifest goes last, so its presence implies every chunk it lists.
+func PutChunked(ctx context.Context, b Backend, name string, r io.Reader) (ChunkStats, error) {
+	var stats ChunkStats
+	objs, err := b.List(ctx, chunkDir+"/")
+	if err != nil {
+		return stats, fmt.Errorf("list %s: %w", chunkDir, err)
+	}
+	have := make(map[string]bool, len(objs))
+	for _, o := range objs {
+		have[o.Name] = true
+	}
+	m := ChunkManifest{Version: 1, Name: name}
+	whole := sha256.New()
+	c := archive.NewChunker(r)
+	for {
+		data, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+		whole.Write(data)
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		m.Chunks = append(m.Chunks, Chunk{Hash: hash, Size: int64(len(data))})
+		m.Size += int64(len(data))
+		stats.Chunks++
+		stats.Size += int64(len(data))
+		if obj := chunkName(hash); !have[obj] {
+			if err := b.Put(ctx, obj, bytes.NewReader(data)); err != nil {
+				return stats, fmt.Errorf("upload chunk %s: %w", hash, err)
+			}
+			have[obj] = true
+			stats.Uploaded++
+			stats.Sent += int64(len(data))
+		}
+	}
+	m.SHA256 = hex.EncodeToString(whole.Sum(nil))
+	body, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return stats, err
+	}
+	if err := b.Put(ctx, name+ChunkManifestSuffix, bytes.NewReader(body)); err != nil {
+		return stats, fmt.Errorf("upload %s: %w", name+ChunkManifestSuffix, err)
+	}
+	stats.Sent += int64(len(body))
+	return stats, nil
+}
+
+// ReadChunkManifest reads the manifest of the chunked archive name.
+func ReadChunkManifest(ctx context.Context, b Backend, name string) (*ChunkManifest, error) {
+	rc, err := b.Get(ctx, strings.TrimSuffix(name, ChunkManifestSuffix)+ChunkManifestSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var m ChunkManifest
+	if err := json.NewDecoder(rc).Decode(&m); err != nil {
+		return nil, fmt.Errorf("parse chunk manifest of %s: %w", name, err)
+	}
+	return &m, nil
+}
+
+// OpenStored opens the archive name in b, stored whole or, by backup --chunked, as chunks;
+// name may also be the chunk manifest. It returns the archive's name.
+func OpenStored(ctx context.Context, b Backend, name string) (io.ReadCloser, string, error) {
+	if n, ok := strings.CutSuffix(name, ChunkManifestSuffix); ok {
+		rc, err := OpenChunked(ctx, b, n)
+		return rc, n, err
+	}
+	rc, err := b.Get(ctx, name)
+	if err != nil {
+		if crc, cerr := OpenChunked(ctx, b, name); cerr == nil {
+			return crc, name, nil
+		}
+	}
+	return rc, name, err
+}
+
+// OpenChunked returns the content of the chunked archive name, reassembled from its chunks.
+// Every chunk is checked against its hash, and the whole against the manifest's, so a
+// damaged or missing chunk fails the read instead of yielding a corrupt archive.
+func OpenChunked(ctx context.Context, b Backend, name string) (io.ReadCloser, error) {
+	m, err := ReadChunkManifest(ctx, b, name)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(m.copyTo(ctx, b, pw))
+	}()
+	return pr, nil
+}
+
+func (m *ChunkManifest) copyTo(ctx context.Context, b Backend, w io.Writer) error {
+	whole := sha256.New()
+	for i, c := range m.Chunks {
+		if len(c.Hash) != sha256.Size*2 {
+			return fmt.Errorf("chunk %d of %s: invalid hash %q", i, m.Name, c.Hash)
+		}
+		rc, err := b.Get(ctx, chunkName(c.Hash))
+		if err != nil {
+			return fmt.Errorf("chunk %d of %s: %w", i, m.Name, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, c.Size+1))
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("chunk %d of %s: %w", i, m.Name, err)
+		}
+		if sum := sha256.Sum256(data); int64(len(data)) != c.Size || hex.EncodeToString(sum[:]) != c.Hash {
+			return fmt.Errorf("chunk %d of %s (%s) is damaged", i, m.Name, c.Hash)
+		}
+		whole.Write(data)
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	if got := hex.EncodeToString(whole.Sum(nil)); got != m.SHA256 {
+		return fmt.Errorf("%s reassembled to SHA-256 %s, manifest says %s", m.Name, got, m.SHA256)
+	}
+	return nil
+}
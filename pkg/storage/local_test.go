@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLocal_PutGetListDelete(t *testing.T) {
+	ctx := context.Background()
+	b, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := b.Put(ctx, "web/a.tar.gz", strings.NewReader("aaa")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := b.Put(ctx, "db.tar.gz", strings.NewReader("b")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	objs, err := b.List(ctx, "")
+	if err != nil || len(objs) != 2 || objs[0].Name != "db.tar.gz" || objs[1].Name != "web/a.tar.gz" || objs[1].Size != 3 {
+		t.Fatalf("unexpected List result %+v, %v", objs, err)
+	}
+	rc, err := b.Get(ctx, "web/a.tar.gz")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "aaa" {
+		t.Fatalf("unexpected content %q", data)
+	}
+	if err := b.Delete(ctx, "db.tar.gz"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if objs, _ := b.List(ctx, "web/"); len(objs) != 1 {
+		t.Fatalf("expected one object under web/, got %+v", objs)
+	}
+	if _, err := Open("gopher://x"); err == nil {
+		t.Fatalf("expected unsupported scheme error")
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for n, want := range map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", 3 << 30: "3.0 GiB"} {
+		if got := FormatSize(n); got != want {
+			t.Errorf("FormatSize(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/brian033/dockerbackup/internal/retry"
+)
+
+// retryPolicy returns the retry policy from the environment, with the retry count overridden
+// by a location's retries= parameter.
+func retryPolicy(q url.Values) (retry.Policy, error) {
+	p := retry.FromEnv()
+	if v := q.Get("retries"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("invalid retries %q", v)
+		}
+		p.Retries = n
+	}
+	return p, nil
+}
+
+// retryableStatus reports whether a response status is worth retrying: request timeouts,
+// throttling and server errors.
+func retryableStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+var errRetryableStatus = stdErrors.New("transient server error")
+
+// sendWithRetry calls send until it gets a response whose status is not retryable or the
+// retries are used up. Connection errors are retried as well. The last response is returned
+// either way (with its body buffered when it was retryable), so callers report statuses as
+// before. send must build a fresh request each time.
+func sendWithRetry(ctx context.Context, p retry.Policy, send func() (*http.Response, error)) (*http.Response, error) {
+	var last *http.Response
+	err := p.Do(ctx, func() error {
+		resp, err := send()
+		if err != nil {
+			if ctx.Err() != nil {
+				return retry.Permanent(err)
+			}
+			return err
+		}
+		if !retryableStatus(resp.StatusCode) {
+			last = resp
+			return nil
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		_ = resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		last = resp
+		return fmt.Errorf("%s %s: %s: %w", resp.Request.Method, resp.Request.URL.Redacted(), resp.Status, errRetryableStatus)
+	})
+	if err != nil && !stdErrors.Is(err, errRetryableStatus) {
+		return nil, err
+	}
+	return last, nil
+}
@@ -0,0 +1,470 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/retry"
+)
+
+const (
+	defaultS3PartSize    = 64 << 20
+	minS3PartSize        = 5 << 20
+	maxS3Parts           = 10000
+	defaultS3Concurrency = 4
+)
+
+// S3 stores objects in an S3-compatible bucket (AWS, MinIO, Ceph RGW, ...) using path-style
+// requests signed with SigV4. Locations look like
+//
+//	s3://ACCESS:SECRET@minio.local:9000/bucket/prefix?region=us-east-1&part_size=64M&concurrency=4
+//
+// (HTTPS) or s3+http://... for plain HTTP; credentials may also come from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN. Objects larger than one part are uploaded with
+// a multipart upload whose parts are sent concurrently; if the upload fails it is aborted so
+// no orphaned parts are left behind in the bucket. Requests, and so each part, are retried
+// individually on connection errors, throttling and server errors (retries=N overrides the
+// count).
+type S3 struct {
+	endpoint     *url.URL // scheme and host only
+	bucket       string
+	prefix       string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	partSize     int64
+	concurrency  int
+	client       *http.Client
+	now          func() time.Time
+	retry        retry.Policy
+}
+
+func NewS3(location string) (*S3, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 location: %w", err)
+	}
+	scheme := "https"
+	switch u.Scheme {
+	case "s3":
+	case "s3+http":
+		scheme = "http"
+	default:
+		return nil, fmt.Errorf("unsupported s3 scheme %q", u.Scheme)
+	}
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if u.Host == "" || bucket == "" {
+		return nil, fmt.Errorf("invalid s3 location %q (expected s3://host/bucket[/prefix])", location)
+	}
+	s := &S3{
+		endpoint:     &url.URL{Scheme: scheme, Host: u.Host},
+		bucket:       bucket,
+		prefix:       strings.Trim(prefix, "/"),
+		region:       "us-east-1",
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		partSize:     defaultS3PartSize,
+		concurrency:  defaultS3Concurrency,
+		client:       &http.Client{},
+		now:          time.Now,
+	}
+	if r := os.Getenv("AWS_REGION"); r != "" {
+		s.region = r
+	}
+	if u.User != nil {
+		s.accessKey = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			s.secretKey = p
+		}
+	}
+	q := u.Query()
+	if s.retry, err = retryPolicy(q); err != nil {
+		return nil, err
+	}
+	if v := q.Get("region"); v != "" {
+		s.region = v
+	}
+	if v := q.Get("part_size"); v != "" {
+		n, err := ParseSize(v)
+		if err != nil || n < minS3PartSize {
+			return nil, fmt.Errorf("invalid part_size %q (minimum 5M)", v)
+		}
+		s.partSize = n
+	}
+	if v := q.Get("concurrency"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid concurrency %q", v)
+		}
+		s.concurrency = n
+	}
+	return s, nil
+}
+
+func (s *S3) String() string {
+	u := *s.endpoint
+	u.Scheme = "s3"
+	if s.endpoint.Scheme == "http" {
+		u.Scheme = "s3+http"
+	}
+	u.Path = "/" + s.bucket
+	if s.prefix != "" {
+		u.Path += "/" + s.prefix
+	}
+	return u.String()
+}
+
+func (s *S3) key(name string) string {
+	if s.prefix == "" {
+		return strings.TrimPrefix(name, "/")
+	}
+	return s.prefix + "/" + strings.TrimPrefix(name, "/")
+}
+
+// do sends a signed request for key (empty for the bucket itself), retrying transient
+// failures.
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, body []byte, headers map[string]string) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = "/" + s.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	return sendWithRetry(ctx, s.retry, func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, retry.Permanent(err)
+		}
+		req.ContentLength = int64(len(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		s.sign(req, body)
+		return s.client.Do(req)
+	})
+}
+
+// expect runs a request, returning the body of a 2xx response and an error otherwise.
+func (s *S3) expect(ctx context.Context, method, key string, query url.Values, body []byte, headers map[string]string) ([]byte, http.Header, error) {
+	resp, err := s.do(ctx, method, key, query, body, headers)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, nil, s3Error(method, key, resp.StatusCode, data)
+	}
+	return data, resp.Header, nil
+}
+
+func s3Error(method, key string, status int, body []byte) error {
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return fmt.Errorf("s3 %s %s: %d %s: %s", method, key, status, e.Code, e.Message)
+	}
+	return fmt.Errorf("s3 %s %s: unexpected status %d", method, key, status)
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (s *S3) sign(req *http.Request, body []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.sessionToken)
+	}
+	if s.accessKey == "" {
+		return
+	}
+
+	names := []string{"host"}
+	for k := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "content-md5" || lk == "content-type" {
+			names = append(names, lk)
+		}
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, n := range names {
+		v := req.Host
+		if v == "" {
+			v = req.URL.Host
+		}
+		if n != "host" {
+			v = strings.TrimSpace(req.Header.Get(n))
+		}
+		canonHeaders.WriteString(n + ":" + v + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonHeaders.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	crSum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crSum[:])
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signed, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3EscapePath URI-encodes every path segment as SigV4 requires (RFC 3986, '/' kept).
+func s3EscapePath(p string) string {
+	segs := strings.Split(p, "/")
+	for i, seg := range segs {
+		segs[i] = strings.ReplaceAll(url.QueryEscape(seg), "+", "%20")
+	}
+	return strings.Join(segs, "/")
+}
+
+func canonicalQuery(q url.Values) string {
+	return strings.ReplaceAll(q.Encode(), "+", "%20")
+}
+
+func (s *S3) Put(ctx context.Context, name string, r io.Reader) error {
+	partSize := s.partSize
+	if f, ok := r.(*os.File); ok {
+		// keep within the 10,000 part limit for very large archives
+		if fi, err := f.Stat(); err == nil && fi.Size() > partSize*maxS3Parts {
+			partSize = (fi.Size() + maxS3Parts - 1) / maxS3Parts
+		}
+	}
+	first, err := readPart(r, partSize)
+	if err != nil {
+		return err
+	}
+	if int64(len(first)) < partSize {
+		_, _, err := s.expect(ctx, http.MethodPut, s.key(name), nil, first, nil)
+		return err
+	}
+	return s.multipartUpload(ctx, s.key(name), first, r, partSize)
+}
+
+// readPart reads up to size bytes; a short result means r is exhausted.
+func readPart(r io.Reader, size int64) ([]byte, error) {
+	buf := make([]byte, size)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return buf[:n], err
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (s *S3) multipartUpload(ctx context.Context, key string, first []byte, r io.Reader, partSize int64) (err error) {
+	data, _, err := s.expect(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(data, &initiated); err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("s3 initiate multipart upload %s: invalid response", key)
+	}
+	uploadID := initiated.UploadID
+	defer func() {
+		if err != nil {
+			// abort with a fresh context so a cancelled run still cleans up its parts
+			abortCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			if _, _, aerr := s.expect(abortCtx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil); aerr != nil {
+				err = fmt.Errorf("%w (abort of upload %s also failed: %v)", err, uploadID, aerr)
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		parts    []s3CompletedPart
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	sem := make(chan struct{}, s.concurrency)
+	fail := func(e error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = e
+			cancel()
+		}
+		mu.Unlock()
+	}
+	buf := first
+	for num := 1; len(buf) > 0; num++ {
+		if num > maxS3Parts {
+			fail(fmt.Errorf("s3 upload %s: more than %d parts; increase part_size", key, maxS3Parts))
+			break
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(num int, body []byte) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			etag, err := s.uploadPart(ctx, key, uploadID, num, body)
+			if err != nil {
+				fail(err)
+				return
+			}
+			mu.Lock()
+			parts = append(parts, s3CompletedPart{PartNumber: num, ETag: etag})
+			mu.Unlock()
+		}(num, buf)
+		if int64(len(buf)) < partSize {
+			break
+		}
+		if buf, err = readPart(r, partSize); err != nil {
+			fail(err)
+			break
+		}
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	data, _, err = s.expect(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body, nil)
+	if err != nil {
+		return err
+	}
+	// S3 may report a failed completion with status 200 and an Error document
+	if bytes.Contains(data, []byte("<Error>")) {
+		return s3Error(http.MethodPost, key, http.StatusOK, data)
+	}
+	return nil
+}
+
+func (s *S3) uploadPart(ctx context.Context, key, uploadID string, num int, body []byte) (string, error) {
+	q := url.Values{"partNumber": {strconv.Itoa(num)}, "uploadId": {uploadID}}
+	_, hdr, err := s.expect(ctx, http.MethodPut, key, q, body, nil)
+	if err != nil {
+		return "", fmt.Errorf("part %d: %w", num, err)
+	}
+	return hdr.Get("ETag"), nil
+}
+
+func (s *S3) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.key(name), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, &os.PathError{Op: "get", Path: name, Err: os.ErrNotExist}
+	}
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, s3Error(http.MethodGet, s.key(name), resp.StatusCode, data)
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Delete(ctx context.Context, name string) error {
+	_, _, err := s.expect(ctx, http.MethodDelete, s.key(name), nil, nil, nil)
+	return err
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]Object, error) {
+	var out []Object
+	base := ""
+	if s.prefix != "" {
+		base = s.prefix + "/"
+	}
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {base + prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		data, _, err := s.expect(ctx, http.MethodGet, "", q, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var res struct {
+			Contents []struct {
+				Key          string `xml:"Key"`
+				Size         int64  `xml:"Size"`
+				LastModified string `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(data, &res); err != nil {
+			return nil, fmt.Errorf("s3 list %s: %w", s.bucket, err)
+		}
+		for _, c := range res.Contents {
+			mod, _ := time.Parse(time.RFC3339, c.LastModified)
+			out = append(out, Object{Name: strings.TrimPrefix(c.Key, base), Size: c.Size, ModTime: mod})
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			break
+		}
+		token = res.NextContinuationToken
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
@@ -0,0 +1,341 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brian033/dockerbackup/internal/retry"
+)
+
+// SFTP stores objects on an SFTP server through the OpenSSH sftp client, so keys, agents and
+// ~/.ssh/config apply. Locations look like
+//
+//	sftp://user@host:port/srv/backups?known_hosts=/path&host_key_check=yes&identity=/path/key&retries=3
+//
+// host_key_check maps to StrictHostKeyChecking (yes, accept-new or no). Commands share one
+// multiplexed SSH connection (ControlMaster) that stays open for a minute, transient failures
+// are retried with exponential backoff, uploads go to <name>.part, resume a partial .part
+// left by an interrupted run and are renamed into place once complete, so readers never see
+// a partial object.
+type SFTP struct {
+	user        string
+	host        string
+	port        string
+	root        string
+	sshOpts     []string
+	retry       retry.Policy
+	controlPath string
+}
+
+func NewSFTP(location string) (*SFTP, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sftp location: %w", err)
+	}
+	if u.Scheme != "sftp" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid sftp location %q (expected sftp://[user@]host[:port]/path)", location)
+	}
+	s := &SFTP{host: u.Hostname(), port: u.Port(), root: u.Path}
+	if u.User != nil {
+		s.user = u.User.Username()
+	}
+	// sftp would take either for an option, like -oProxyCommand=...
+	if strings.HasPrefix(s.host, "-") || strings.HasPrefix(s.user, "-") {
+		return nil, fmt.Errorf("invalid sftp location %q (host and user must not start with '-')", location)
+	}
+	if s.root == "" {
+		s.root = "."
+	}
+	q := u.Query()
+	s.sshOpts = []string{"-o", "BatchMode=yes"}
+	if v := q.Get("host_key_check"); v != "" {
+		switch v {
+		case "yes", "no", "accept-new":
+		default:
+			return nil, fmt.Errorf("invalid host_key_check %q (use yes, accept-new or no)", v)
+		}
+		s.sshOpts = append(s.sshOpts, "-o", "StrictHostKeyChecking="+v)
+	}
+	if v := q.Get("known_hosts"); v != "" {
+		s.sshOpts = append(s.sshOpts, "-o", "UserKnownHostsFile="+v)
+	}
+	if v := q.Get("identity"); v != "" {
+		s.sshOpts = append(s.sshOpts, "-o", "IdentitiesOnly=yes", "-i", v)
+	}
+	if s.retry, err = retryPolicy(q); err != nil {
+		return nil, err
+	}
+	// one control socket per destination; %C is expanded by ssh to a hash of the connection
+	sum := sha1.Sum([]byte(s.String()))
+	s.controlPath = filepath.Join(os.TempDir(), "dockerbackup-sftp-"+hex.EncodeToString(sum[:6]))
+	s.sshOpts = append(s.sshOpts, "-o", "ControlMaster=auto", "-o", "ControlPath="+s.controlPath+"-%C", "-o", "ControlPersist=60")
+	return s, nil
+}
+
+func (s *SFTP) String() string {
+	u := url.URL{Scheme: "sftp", Host: s.host, Path: s.root}
+	if s.port != "" {
+		u.Host += ":" + s.port
+	}
+	if s.user != "" {
+		u.User = url.User(s.user)
+	}
+	return u.String()
+}
+
+func (s *SFTP) remote(name string) string {
+	return path.Join(s.root, name)
+}
+
+func (s *SFTP) args() []string {
+	args := append([]string{}, s.sshOpts...)
+	if s.port != "" {
+		args = append(args, "-P", s.port)
+	}
+	dest := s.host
+	if s.user != "" {
+		dest = s.user + "@" + dest
+	}
+	return append(args, "-b", "-", "--", dest)
+}
+
+// batch runs sftp commands (one per line; a leading '-' ignores that command's failure) and
+// returns stdout. Failures are retried with exponential backoff.
+func (s *SFTP) batch(ctx context.Context, cmds ...string) (string, error) {
+	var out string
+	err := s.retry.Do(ctx, func() error {
+		cmd := exec.CommandContext(ctx, "sftp", s.args()...)
+		cmd.Stdin = strings.NewReader(strings.Join(cmds, "\n") + "\n")
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			err = fmt.Errorf("sftp %s: %v: %s", s.host, err, strings.TrimSpace(stderr.String()))
+			if isPermanentSFTPError(stderr.String()) {
+				return retry.Permanent(err)
+			}
+			return err
+		}
+		out = stdout.String()
+		return nil
+	})
+	return out, err
+}
+
+// isPermanentSFTPError recognizes failures that a retry cannot fix.
+func isPermanentSFTPError(stderr string) bool {
+	for _, s := range []string{"No such file", "Permission denied", "Host key verification failed", "REMOTE HOST IDENTIFICATION HAS CHANGED"} {
+		if strings.Contains(stderr, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func sftpQuote(p string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(p, `\`, `\\`), `"`, `\"`) + `"`
+}
+
+func (s *SFTP) mkdirs(name string) []string {
+	var cmds []string
+	dir := path.Dir(s.remote(name))
+	var parts []string
+	for d := dir; d != "." && d != "/" && d != ""; d = path.Dir(d) {
+		parts = append([]string{d}, parts...)
+	}
+	for _, d := range parts {
+		cmds = append(cmds, "-mkdir "+sftpQuote(d))
+	}
+	return cmds
+}
+
+func (s *SFTP) Put(ctx context.Context, name string, r io.Reader) error {
+	local, size, cleanup, err := localFileFor(r)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	dest := s.remote(name)
+	part := dest + ".part"
+
+	// resume only when the partial upload is not larger than the source
+	resume := false
+	if entries, err := s.list(ctx, path.Dir(dest)); err == nil {
+		for _, e := range entries {
+			if e.Name == path.Base(part) && e.Size > 0 && e.Size <= size {
+				resume = true
+			}
+		}
+	}
+	put := "put"
+	if resume {
+		put = "reput"
+	}
+	cmds := append(s.mkdirs(name), fmt.Sprintf("%s %s %s", put, sftpQuote(local), sftpQuote(part)))
+	if _, err := s.batch(ctx, cmds...); err != nil {
+		return fmt.Errorf("upload %s (re-run to resume): %w", name, err)
+	}
+	// OpenSSH servers support posix-rename, which replaces dest atomically; plain SFTPv3
+	// rename refuses to overwrite, so fall back to moving the old object aside, which is only
+	// removed once the new one is in place and put back when that fails
+	rename := fmt.Sprintf("rename %s %s", sftpQuote(part), sftpQuote(dest))
+	if _, err := s.batch(ctx, rename); err == nil {
+		return nil
+	}
+	aside := sftpQuote(fmt.Sprintf("%s.%s.part", dest, strconv.FormatInt(time.Now().UnixNano(), 36)))
+	if _, err := s.batch(ctx, fmt.Sprintf("-rename %s %s", sftpQuote(dest), aside), rename, "-rm "+aside); err != nil {
+		_, _ = s.batch(ctx, fmt.Sprintf("-rename %s %s", aside, sftpQuote(dest)))
+		return fmt.Errorf("upload %s: %w", name, err)
+	}
+	return nil
+}
+
+// localFileFor returns a path for r, spooling to a temporary file unless r is already a file.
+func localFileFor(r io.Reader) (string, int64, func(), error) {
+	if f, ok := r.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			return f.Name(), fi.Size(), func() {}, nil
+		}
+	}
+	tmp, err := os.CreateTemp("", "dockerbackup_upload_*")
+	if err != nil {
+		return "", 0, nil, err
+	}
+	cleanup := func() { _ = os.Remove(tmp.Name()) }
+	n, err := io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return "", 0, nil, err
+	}
+	return tmp.Name(), n, cleanup, nil
+}
+
+func (s *SFTP) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	tmp, err := os.CreateTemp("", "dockerbackup_download_*")
+	if err != nil {
+		return nil, err
+	}
+	_ = tmp.Close()
+	if _, err := s.batch(ctx, fmt.Sprintf("get %s %s", sftpQuote(s.remote(name)), sftpQuote(tmp.Name()))); err != nil {
+		_ = os.Remove(tmp.Name())
+		if strings.Contains(err.Error(), "No such file") || strings.Contains(err.Error(), "not found") {
+			return nil, &os.PathError{Op: "get", Path: name, Err: os.ErrNotExist}
+		}
+		return nil, err
+	}
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &removeOnClose{File: f}, nil
+}
+
+type removeOnClose struct {
+	*os.File
+}
+
+func (r *removeOnClose) Close() error {
+	err := r.File.Close()
+	_ = os.Remove(r.File.Name())
+	return err
+}
+
+func (s *SFTP) Delete(ctx context.Context, name string) error {
+	_, err := s.batch(ctx, "rm "+sftpQuote(s.remote(name)))
+	return err
+}
+
+func (s *SFTP) List(ctx context.Context, prefix string) ([]Object, error) {
+	var out []Object
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		entries, err := s.list(ctx, s.remote(rel))
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			name := path.Join(rel, e.Name)
+			if e.dir {
+				if err := walk(name); err != nil {
+					return err
+				}
+				continue
+			}
+			if strings.HasPrefix(name, prefix) && !strings.HasSuffix(name, ".part") {
+				out = append(out, Object{Name: name, Size: e.Size, ModTime: e.ModTime})
+			}
+		}
+		return nil
+	}
+	if err := walk(""); err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+type sftpEntry struct {
+	Object
+	dir bool
+}
+
+func (s *SFTP) list(ctx context.Context, dir string) ([]sftpEntry, error) {
+	out, err := s.batch(ctx, "ls -lan "+sftpQuote(dir))
+	if err != nil {
+		return nil, err
+	}
+	var entries []sftpEntry
+	for _, line := range strings.Split(out, "\n") {
+		if e, ok := parseSFTPLsLine(line, time.Now()); ok {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// parseSFTPLsLine parses one line of `ls -ln` output from the OpenSSH sftp client:
+//
+//	-rw-r--r--    1 1000     1000         1234 Jun  1 12:00 /srv/backups/a.tar.gz
+//
+// Dates without a year are in the last 6 months, like ls(1).
+func parseSFTPLsLine(line string, now time.Time) (sftpEntry, bool) {
+	f := strings.Fields(line)
+	if len(f) < 9 || strings.HasPrefix(line, "sftp>") || len(f[0]) != 10 {
+		return sftpEntry{}, false
+	}
+	size, err := strconv.ParseInt(f[4], 10, 64)
+	if err != nil {
+		return sftpEntry{}, false
+	}
+	name := path.Base(strings.Join(f[8:], " "))
+	if name == "." || name == ".." {
+		return sftpEntry{}, false
+	}
+	var mod time.Time
+	if strings.Contains(f[7], ":") {
+		t, err := time.Parse("Jan 2 15:04 2006", fmt.Sprintf("%s %s %s %d", f[5], f[6], f[7], now.Year()))
+		if err == nil && t.After(now.Add(24*time.Hour)) {
+			t = t.AddDate(-1, 0, 0)
+		}
+		mod = t
+	} else {
+		mod, _ = time.Parse("Jan 2 2006", strings.Join(f[5:8], " "))
+	}
+	return sftpEntry{Object: Object{Name: name, Size: size, ModTime: mod}, dir: f[0][0] == 'd'}, true
+}
@@ -0,0 +1,325 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSFTP_Options(t *testing.T) {
+	s, err := NewSFTP("sftp://backup@nas.local:2222/srv/backups?host_key_check=accept-new&known_hosts=/etc/kh&retries=5")
+	if err != nil {
+		t.Fatalf("NewSFTP: %v", err)
+	}
+	args := strings.Join(s.args(), " ")
+	for _, want := range []string{"StrictHostKeyChecking=accept-new", "UserKnownHostsFile=/etc/kh", "ControlMaster=auto", "-P 2222", "backup@nas.local"} {
+		if !strings.Contains(args, want) {
+			t.Fatalf("expected %q in sftp args: %s", want, args)
+		}
+	}
+	if s.retry.Retries != 5 || s.remote("a/b.tar.gz") != "/srv/backups/a/b.tar.gz" {
+		t.Fatalf("unexpected config %+v", s)
+	}
+	if _, err := NewSFTP("sftp://host/x?host_key_check=maybe"); err == nil {
+		t.Fatalf("expected invalid host_key_check error")
+	}
+	if !strings.HasSuffix(args, " -- backup@nas.local") {
+		t.Fatalf("the destination does not follow --: %s", args)
+	}
+	for _, loc := range []string{"sftp://-oProxyCommand=id/x", "sftp://-oProxyCommand=id@nas.local/x"} {
+		if _, err := NewSFTP(loc); err == nil {
+			t.Fatalf("expected error for %s, which sftp would read as an option", loc)
+		}
+	}
+}
+
+func TestParseSFTPLsLine(t *testing.T) {
+	now := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	e, ok := parseSFTPLsLine("-rw-r--r--    1 1000     1000         1234 Jun  1 12:00 /srv/backups/my app.tar.gz", now)
+	if !ok || e.Name != "my app.tar.gz" || e.Size != 1234 || e.dir || e.ModTime.Month() != time.June || e.ModTime.Year() != 2024 {
+		t.Fatalf("unexpected entry %+v ok=%v", e, ok)
+	}
+	e, ok = parseSFTPLsLine("drwxr-xr-x    2 1000     1000         4096 Dec 24  2023 /srv/backups/web", now)
+	if !ok || !e.dir || e.Name != "web" || e.ModTime.Year() != 2023 {
+		t.Fatalf("unexpected dir entry %+v ok=%v", e, ok)
+	}
+	if _, ok := parseSFTPLsLine("sftp> ls -lan /srv/backups", now); ok {
+		t.Fatalf("echoed command must be skipped")
+	}
+}
+
+// TestFakeSFTPHelper is not a test: the fake sftp installed by newFakeSFTP runs the test binary
+// again with FAKE_SFTP_ROOT set, and this serves the batch on stdin from that directory as the
+// OpenSSH client would. FAKE_SFTP_LOG collects the commands run, FAKE_SFTP_DROPS counts down
+// connections that fail before running anything, FAKE_SFTP_FAIL fails the commands containing
+// it, and FAKE_SFTP_V3 makes rename refuse to replace an object, as plain SFTPv3 servers do.
+func TestFakeSFTPHelper(t *testing.T) {
+	root := os.Getenv("FAKE_SFTP_ROOT")
+	if root == "" {
+		return
+	}
+	fail := func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+		os.Exit(1)
+	}
+	if drops := os.Getenv("FAKE_SFTP_DROPS"); drops != "" {
+		b, _ := os.ReadFile(drops)
+		if n, _ := strconv.Atoi(string(b)); n > 0 {
+			_ = os.WriteFile(drops, []byte(strconv.Itoa(n-1)), 0o644)
+			fail("Connection closed")
+		}
+	}
+	logFile, err := os.OpenFile(os.Getenv("FAKE_SFTP_LOG"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		fail("%v", err)
+	}
+	defer logFile.Close()
+	local := func(p string) string { return filepath.Join(root, p) }
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		line := sc.Text()
+		ignore := strings.HasPrefix(line, "-")
+		f := sftpFields(strings.TrimPrefix(line, "-"))
+		fmt.Fprintln(logFile, strings.Join(f, " "))
+		var err error
+		switch {
+		case os.Getenv("FAKE_SFTP_FAIL") != "" && strings.Contains(line, os.Getenv("FAKE_SFTP_FAIL")):
+			err = fmt.Errorf("Failure")
+		case f[0] == "mkdir":
+			err = os.Mkdir(local(f[1]), 0o755)
+		case f[0] == "put":
+			var b []byte
+			if b, err = os.ReadFile(f[1]); err == nil {
+				err = os.WriteFile(local(f[2]), b, 0o644)
+			}
+		case f[0] == "reput":
+			var b []byte
+			var fi os.FileInfo
+			if b, err = os.ReadFile(f[1]); err == nil {
+				if fi, err = os.Stat(local(f[2])); err == nil {
+					var w *os.File
+					if w, err = os.OpenFile(local(f[2]), os.O_APPEND|os.O_WRONLY, 0); err == nil {
+						_, err = w.Write(b[fi.Size():])
+						w.Close()
+					}
+				}
+			}
+		case f[0] == "get":
+			var b []byte
+			if b, err = os.ReadFile(local(f[1])); err == nil {
+				err = os.WriteFile(f[2], b, 0o644)
+			}
+		case f[0] == "rename":
+			if _, serr := os.Stat(local(f[2])); serr == nil && os.Getenv("FAKE_SFTP_V3") != "" {
+				err = fmt.Errorf("Failure")
+			} else {
+				err = os.Rename(local(f[1]), local(f[2]))
+			}
+		case f[0] == "rm":
+			err = os.Remove(local(f[1]))
+		case f[0] == "ls":
+			var entries []os.DirEntry
+			if entries, err = os.ReadDir(local(f[2])); err == nil {
+				for _, e := range entries {
+					fi, _ := e.Info()
+					fmt.Printf("%s    1 1000     1000 %12d %s %s\n", fi.Mode().String(), fi.Size(), fi.ModTime().Format("Jan _2 15:04"), filepath.Join(f[2], e.Name()))
+				}
+			}
+		default:
+			err = fmt.Errorf("unknown command %s", f[0])
+		}
+		if err != nil && !ignore {
+			if os.IsNotExist(err) {
+				fail("No such file or directory: %s", line)
+			}
+			fail("%s: %v", line, err)
+		}
+	}
+	os.Exit(0)
+}
+
+// sftpFields splits a batch command into its words, undoing sftpQuote.
+func sftpFields(line string) []string {
+	var fields []string
+	var cur strings.Builder
+	quoted, inWord := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && quoted && i+1 < len(line):
+			i++
+			cur.WriteByte(line[i])
+		case c == '"':
+			quoted, inWord = !quoted, true
+		case c == ' ' && !quoted:
+			if inWord {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		fields = append(fields, cur.String())
+	}
+	return fields
+}
+
+// newFakeSFTP puts a fake sftp serving a temporary directory on PATH and returns a backend
+// using it, with the directory and the file the fake logs its commands to.
+func newFakeSFTP(t *testing.T) (*SFTP, string, string) {
+	root, bin := t.TempDir(), t.TempDir()
+	logPath := filepath.Join(t.TempDir(), "commands")
+	script := fmt.Sprintf("#!/bin/sh\nexec %q -test.run='^TestFakeSFTPHelper$' -- \"$@\"\n", os.Args[0])
+	if err := os.WriteFile(filepath.Join(bin, "sftp"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_SFTP_ROOT", root)
+	t.Setenv("FAKE_SFTP_LOG", logPath)
+	s, err := NewSFTP("sftp://backup@nas.local/srv/backups?retries=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.retry.Delay = 0
+	if err := os.MkdirAll(filepath.Join(root, "srv", "backups"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return s, filepath.Join(root, "srv", "backups"), logPath
+}
+
+func TestSFTP_PutGetList(t *testing.T) {
+	ctx := context.Background()
+	s, dir, _ := newFakeSFTP(t)
+	if err := s.Put(ctx, "web/a.tar.gz", strings.NewReader("archive")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	// an interrupted upload of another object is not listed
+	_ = os.WriteFile(filepath.Join(dir, "web", "b.tar.gz.part"), []byte("arch"), 0o644)
+	objs, err := s.List(ctx, "web/")
+	if err != nil || len(objs) != 1 || objs[0].Name != "web/a.tar.gz" || objs[0].Size != 7 {
+		t.Fatalf("List = %+v, %v", objs, err)
+	}
+	rc, err := s.Get(ctx, "web/a.tar.gz")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	b, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(b) != "archive" {
+		t.Fatalf("Get = %q", b)
+	}
+	if _, err := s.Get(ctx, "web/missing.tar.gz"); !os.IsNotExist(err) {
+		t.Fatalf("Get of a missing object = %v, want not exist", err)
+	}
+	if err := s.Delete(ctx, "web/a.tar.gz"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "web", "a.tar.gz")); !os.IsNotExist(err) {
+		t.Fatalf("object left after Delete: %v", err)
+	}
+}
+
+func TestSFTP_PutResumesPartialUpload(t *testing.T) {
+	ctx := context.Background()
+	s, dir, logPath := newFakeSFTP(t)
+	part := filepath.Join(dir, "a.tar.gz.part")
+	_ = os.WriteFile(part, []byte("0123"), 0o644)
+	if err := s.Put(ctx, "a.tar.gz", strings.NewReader("0123456789")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.tar.gz")); string(b) != "0123456789" {
+		t.Fatalf("object = %q", b)
+	}
+	if _, err := os.Stat(part); !os.IsNotExist(err) {
+		t.Fatalf("partial upload left behind: %v", err)
+	}
+	if log, _ := os.ReadFile(logPath); !bytes.Contains(log, []byte("\nreput ")) {
+		t.Fatalf("the partial upload was not resumed:\n%s", log)
+	}
+
+	// a partial upload larger than the object is not from this one; it is uploaded again
+	_ = os.WriteFile(part, []byte("a longer leftover upload"), 0o644)
+	_ = os.Remove(logPath)
+	if err := s.Put(ctx, "a.tar.gz", strings.NewReader("new")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.tar.gz")); string(b) != "new" {
+		t.Fatalf("object = %q", b)
+	}
+	if log, _ := os.ReadFile(logPath); bytes.Contains(log, []byte("reput ")) {
+		t.Fatalf("resumed a partial upload of something else:\n%s", log)
+	}
+}
+
+func TestSFTP_PutReplacesWithoutPosixRename(t *testing.T) {
+	ctx := context.Background()
+	s, dir, _ := newFakeSFTP(t)
+	t.Setenv("FAKE_SFTP_V3", "1")
+	dest := filepath.Join(dir, "a.tar.gz")
+	_ = os.WriteFile(dest, []byte("old"), 0o644)
+	if err := s.Put(ctx, "a.tar.gz", strings.NewReader("new")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if b, _ := os.ReadFile(dest); string(b) != "new" || len(entries) != 1 {
+		t.Fatalf("object = %q, directory holds %v", b, entries)
+	}
+
+	// when the new object cannot be renamed into place the old one stays
+	t.Setenv("FAKE_SFTP_FAIL", "rename \""+s.remote("a.tar.gz.part")+"\"")
+	s.retry.Retries = 0
+	if err := s.Put(ctx, "a.tar.gz", strings.NewReader("newer")); err == nil {
+		t.Fatal("expected the failed rename to fail the upload")
+	}
+	if b, _ := os.ReadFile(dest); string(b) != "new" {
+		t.Fatalf("object after a failed replace = %q, want the previous one", b)
+	}
+	entries, _ = os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Fatalf("directory holds %v, want the object and the upload to resume", entries)
+	}
+}
+
+func TestSFTP_RetriesDroppedConnections(t *testing.T) {
+	ctx := context.Background()
+	s, dir, logPath := newFakeSFTP(t)
+	drops := filepath.Join(t.TempDir(), "drops")
+	t.Setenv("FAKE_SFTP_DROPS", drops)
+	_ = os.WriteFile(drops, []byte("2"), 0o644)
+	if err := s.Put(ctx, "a.tar.gz", strings.NewReader("archive")); err != nil {
+		t.Fatalf("Put after two dropped connections: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.tar.gz")); string(b) != "archive" {
+		t.Fatalf("object = %q", b)
+	}
+
+	_ = os.WriteFile(drops, []byte("10"), 0o644)
+	if _, err := s.List(ctx, ""); err == nil || !strings.Contains(err.Error(), "Connection closed") {
+		t.Fatalf("List with the connection always dropped = %v", err)
+	}
+	if b, _ := os.ReadFile(drops); string(b) != "6" {
+		t.Fatalf("connections left to drop = %s, want 6 after four attempts", b)
+	}
+
+	// a missing object is not retried
+	_ = os.WriteFile(drops, []byte("0"), 0o644)
+	_ = os.Remove(logPath)
+	if err := s.Delete(ctx, "missing.tar.gz"); err == nil {
+		t.Fatal("expected Delete of a missing object to fail")
+	}
+	if log, _ := os.ReadFile(logPath); bytes.Count(log, []byte("rm ")) != 1 {
+		t.Fatalf("Delete of a missing object was retried:\n%s", log)
+	}
+}
@@ -0,0 +1,101 @@
+// Package storage abstracts where backup archives live: a local directory or a remote
+// backend addressed by URL.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Object describes a stored file.
+type Object struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Backend stores backup archives by name. Names use forward slashes and are relative to the
+// backend root.
+type Backend interface {
+	// Put stores the content of r under name; implementations must not expose a partially
+	// written object under name.
+	Put(ctx context.Context, name string, r io.Reader) error
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+	// List returns objects whose name starts with prefix, sorted by name.
+	List(ctx context.Context, prefix string) ([]Object, error)
+	Delete(ctx context.Context, name string) error
+	// String returns the location for log messages (without credentials).
+	String() string
+}
+
+// Open returns the backend for a location: a local path, file://, webdav://, sftp:// or s3:// URL.
+func Open(location string) (Backend, error) {
+	scheme, rest, ok := strings.Cut(location, "://")
+	if !ok {
+		return NewLocal(location), nil
+	}
+	switch scheme {
+	case "file":
+		return NewLocal(rest), nil
+	case "webdav", "webdavs", "webdav+http":
+		return NewWebDAV(location)
+	case "sftp":
+		return NewSFTP(location)
+	case "s3", "s3+http":
+		return NewS3(location)
+	default:
+		return nil, fmt.Errorf("unsupported storage location %q", location)
+	}
+}
+
+// IsRemote reports whether location refers to a non-local backend.
+func IsRemote(location string) bool {
+	scheme, _, ok := strings.Cut(location, "://")
+	return ok && scheme != "file"
+}
+
+// FormatSize renders n with a binary unit: 512 B, 1.5 KiB, 3.0 GiB.
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 5; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// ParseSize parses sizes like 512, 64K, 10M, 1G (binary multiples; a trailing B or iB is
+// accepted).
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	mult := int64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return v * mult, nil
+}
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDAV is a minimal in-memory WebDAV server with the Nextcloud chunk assembly MOVE.
+type fakeDAV struct {
+	mu        sync.Mutex
+	files     map[string][]byte
+	dirs      map[string]bool
+	puts      []string
+	failChunk string
+}
+
+func newFakeDAV() *fakeDAV {
+	return &fakeDAV{files: map[string][]byte{}, dirs: map[string]bool{"/": true}}
+}
+
+func (f *fakeDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := strings.TrimSuffix(r.URL.Path, "/")
+	switch r.Method {
+	case "MKCOL":
+		if f.dirs[p] {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		f.dirs[p] = true
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodPut:
+		if f.failChunk != "" && strings.HasSuffix(p, "/"+f.failChunk) {
+			f.failChunk = ""
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		f.files[p] = b
+		f.puts = append(f.puts, p)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		b, ok := f.files[p]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(b)
+	case http.MethodDelete:
+		delete(f.files, p)
+		w.WriteHeader(http.StatusNoContent)
+	case "MOVE":
+		du, _ := url.Parse(r.Header.Get("Destination"))
+		dest := du.Path
+		if path.Base(p) == ".file" {
+			dir := path.Dir(p)
+			var names []string
+			for n := range f.files {
+				if path.Dir(n) == dir {
+					names = append(names, n)
+				}
+			}
+			sort.Strings(names)
+			var buf bytes.Buffer
+			for _, n := range names {
+				buf.Write(f.files[n])
+				delete(f.files, n)
+			}
+			delete(f.dirs, dir)
+			f.files[dest] = buf.Bytes()
+		} else {
+			f.files[dest] = f.files[p]
+			delete(f.files, p)
+		}
+		w.WriteHeader(http.StatusCreated)
+	case "PROPFIND":
+		if !f.dirs[p] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var b strings.Builder
+		b.WriteString(`<?xml version="1.0"?><d:multistatus xmlns:d="DAV:">`)
+		fmt.Fprintf(&b, `<d:response><d:href>%s/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`, p)
+		for d := range f.dirs {
+			if d != p && path.Dir(d) == p {
+				fmt.Fprintf(&b, `<d:response><d:href>%s/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`, d)
+			}
+		}
+		for n, data := range f.files {
+			if path.Dir(n) == p {
+				fmt.Fprintf(&b, `<d:response><d:href>%s</d:href><d:propstat><d:prop><d:getcontentlength>%d</d:getcontentlength><d:getlastmodified>Mon, 03 Jun 2024 10:00:00 GMT</d:getlastmodified><d:resourcetype/></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`, n, len(data))
+			}
+		}
+		b.WriteString(`</d:multistatus>`)
+		w.WriteHeader(http.StatusMultiStatus)
+		_, _ = w.Write([]byte(b.String()))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestWebDAV_PlainPutListGet(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeDAV()
+	fake.dirs["/dav"] = true
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	b, err := Open("webdav+http://" + strings.TrimPrefix(srv.URL, "http://") + "/dav")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := b.Put(ctx, "web/a.tar.gz", strings.NewReader("hello")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	objs, err := b.List(ctx, "")
+	if err != nil || len(objs) != 1 || objs[0].Name != "web/a.tar.gz" || objs[0].Size != 5 {
+		t.Fatalf("unexpected List %+v, %v", objs, err)
+	}
+	rc, err := b.Get(ctx, "web/a.tar.gz")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "hello" {
+		t.Fatalf("unexpected content %q", data)
+	}
+	if _, err := b.Get(ctx, "missing"); err == nil {
+		t.Fatalf("expected not found")
+	}
+}
+
+func TestWebDAV_NextcloudChunkedResume(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeDAV()
+	for _, d := range []string{"/remote.php", "/remote.php/dav", "/remote.php/dav/files", "/remote.php/dav/files/u", "/remote.php/dav/files/u/backups", "/remote.php/dav/uploads", "/remote.php/dav/uploads/u"} {
+		fake.dirs[d] = true
+	}
+	fake.failChunk = "00002"
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	b, err := Open("webdav+http://u:secret@" + strings.TrimPrefix(srv.URL, "http://") + "/remote.php/dav/files/u/backups?chunk_size=4&retries=0")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	content := []byte("0123456789")
+	if err := b.Put(ctx, "app.tar.gz", bytes.NewReader(content)); err == nil {
+		t.Fatalf("expected injected chunk failure")
+	}
+	fake.puts = nil
+	if err := b.Put(ctx, "app.tar.gz", bytes.NewReader(content)); err != nil {
+		t.Fatalf("Put (resume): %v", err)
+	}
+	for _, p := range fake.puts {
+		if strings.HasSuffix(p, "/00001") {
+			t.Fatalf("chunk 1 was uploaded again on resume: %v", fake.puts)
+		}
+	}
+	if got := string(fake.files["/remote.php/dav/files/u/backups/app.tar.gz"]); got != string(content) {
+		t.Fatalf("assembled content = %q", got)
+	}
+}
+
+func TestWebDAV_RetriesTransientChunkFailure(t *testing.T) {
+	t.Setenv("DOCKERBACKUP_RETRY_DELAY", "1ms")
+	ctx := context.Background()
+	fake := newFakeDAV()
+	for _, d := range []string{"/remote.php", "/remote.php/dav", "/remote.php/dav/files", "/remote.php/dav/files/u", "/remote.php/dav/files/u/backups", "/remote.php/dav/uploads", "/remote.php/dav/uploads/u"} {
+		fake.dirs[d] = true
+	}
+	fake.failChunk = "00002"
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	b, err := Open("webdav+http://u:secret@" + strings.TrimPrefix(srv.URL, "http://") + "/remote.php/dav/files/u/backups?chunk_size=4&retries=2")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	content := []byte("0123456789")
+	if err := b.Put(ctx, "app.tar.gz", bytes.NewReader(content)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	var chunks []string
+	for _, p := range fake.puts {
+		chunks = append(chunks, path.Base(p))
+	}
+	if strings.Join(chunks, ",") != "00001,00002,00003" {
+		t.Fatalf("only the failed chunk should be sent again, puts: %v", fake.puts)
+	}
+	if got := string(fake.files["/remote.php/dav/files/u/backups/app.tar.gz"]); got != string(content) {
+		t.Fatalf("assembled content = %q", got)
+	}
+}
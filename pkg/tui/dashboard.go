@@ -0,0 +1,278 @@
+// Package tui is the terminal dashboard of the tui command: the running containers with their
+// newest backups, and the backups and restores started from it, each followed through the
+// lines its job logs (docker export and save report their progress there).
+package tui
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brian033/dockerbackup/pkg/backup"
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/server"
+	"github.com/brian033/dockerbackup/pkg/storage"
+)
+
+// Jobs runs the backups and restores the dashboard starts; *server.Server implements it.
+type Jobs interface {
+	SubmitBackup(body server.BackupRequest) (server.Job, error)
+	SubmitRestore(body server.RestoreRequest, via string) (server.Job, error)
+	Jobs() []server.Job
+	JobLog(id string, from int) ([]server.LogLine, int, bool)
+}
+
+// refreshInterval is how often the dashboard reloads the containers and their backups, besides
+// whenever a job finishes.
+const refreshInterval = 30 * time.Second
+
+// Dashboard is the state of the dashboard: it is updated by Key and Update and drawn by Render.
+type Dashboard struct {
+	dir        string
+	jobs       Jobs
+	containers func(ctx context.Context) ([]docker.ContainerRef, error)
+	now        func() time.Time
+
+	statuses  []backup.ContainerStatus
+	refreshed time.Time
+	selected  int
+	// progress is the last line each job logged, next the number of the line to read next
+	progress map[string]string
+	next     map[string]int
+	// finished counts the finished jobs seen, to refresh when another one finishes
+	finished int
+	message  string
+	// confirm runs when the question in message is answered with y
+	confirm func(ctx context.Context) bool
+}
+
+// New returns a dashboard of the containers containers lists and their backups in dir.
+func New(dir string, jobs Jobs, containers func(ctx context.Context) ([]docker.ContainerRef, error)) *Dashboard {
+	return &Dashboard{dir: dir, jobs: jobs, containers: containers, now: time.Now, progress: map[string]string{}, next: map[string]int{}}
+}
+
+// Refresh reloads the containers and their newest backups.
+func (d *Dashboard) Refresh(ctx context.Context) {
+	d.refreshed = d.now()
+	containers, err := d.containers(ctx)
+	if err != nil {
+		d.message = fmt.Sprintf("Could not list containers: %v", err)
+		return
+	}
+	statuses, err := backup.BackupStatus(ctx, d.dir, containers, 0, d.now())
+	if err != nil {
+		d.message = fmt.Sprintf("Could not read the backups in %s: %v", d.dir, err)
+		return
+	}
+	d.statuses = statuses
+	d.selected = min(d.selected, max(len(statuses)-1, 0))
+}
+
+// Update follows the jobs' logs, and refreshes when a job finished or the statuses are old.
+func (d *Dashboard) Update(ctx context.Context) {
+	finished := 0
+	for _, j := range d.jobs.Jobs() {
+		if done(j) {
+			finished++
+			continue
+		}
+		lines, next, ok := d.jobs.JobLog(j.ID, d.next[j.ID])
+		if !ok {
+			continue
+		}
+		d.next[j.ID] = next
+		if len(lines) > 0 {
+			d.progress[j.ID] = lines[len(lines)-1].Message
+		}
+	}
+	if finished != d.finished || d.now().Sub(d.refreshed) >= refreshInterval {
+		d.finished = finished
+		d.Refresh(ctx)
+	}
+}
+
+func done(j server.Job) bool {
+	return j.Status == server.JobSucceeded || j.Status == server.JobFailed
+}
+
+// Key handles a key press and reports whether the dashboard should quit.
+func (d *Dashboard) Key(ctx context.Context, k Key) bool {
+	if d.confirm != nil {
+		confirm := d.confirm
+		d.confirm, d.message = nil, ""
+		if k == "y" || k == "Y" {
+			return confirm(ctx)
+		}
+		return false
+	}
+	d.message = ""
+	switch k {
+	case KeyUp, "k":
+		d.selected = max(d.selected-1, 0)
+	case KeyDown, "j":
+		d.selected = min(d.selected+1, max(len(d.statuses)-1, 0))
+	case "R":
+		d.Refresh(ctx)
+	case "b":
+		if st, ok := d.current(); ok {
+			d.submitted("backup of " + st.Container)(d.jobs.SubmitBackup(server.BackupRequest{Container: st.Container}))
+		}
+	case "r":
+		st, ok := d.current()
+		switch {
+		case !ok:
+		case st.Backup == "":
+			d.message = st.Container + " has no backup to restore"
+		default:
+			file := filepath.Base(st.Backup)
+			d.ask(fmt.Sprintf("Restore %s, replacing %s (and the other containers it holds)? [y/N]", file, st.Container), func(ctx context.Context) bool {
+				d.submitted("restore of " + file)(d.jobs.SubmitRestore(server.RestoreRequest{Backup: file, Start: true, Replace: true}, "tui"))
+				return false
+			})
+		}
+	case "q", KeyCtrlC:
+		for _, j := range d.jobs.Jobs() {
+			if !done(j) {
+				d.ask("Quitting cancels the running and queued jobs. Quit? [y/N]", func(context.Context) bool { return true })
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (d *Dashboard) current() (backup.ContainerStatus, bool) {
+	if d.selected >= len(d.statuses) {
+		return backup.ContainerStatus{}, false
+	}
+	return d.statuses[d.selected], true
+}
+
+func (d *Dashboard) ask(question string, fn func(ctx context.Context) bool) {
+	d.message, d.confirm = question, fn
+}
+
+// submitted reports the outcome of submitting what.
+func (d *Dashboard) submitted(what string) func(server.Job, error) {
+	return func(j server.Job, err error) {
+		if err != nil {
+			d.message = fmt.Sprintf("Could not queue the %s: %v", what, err)
+			return
+		}
+		d.message = fmt.Sprintf("Queued the %s (job %s)", what, j.ID)
+	}
+}
+
+// maxJobRows bounds the jobs shown; the rest of the screen lists containers.
+const maxJobRows = 8
+
+const help = "↑/↓ select  b back up  r restore newest backup  R refresh  q quit"
+
+// Render draws the dashboard as lines of at most width characters, at most height lines.
+func (d *Dashboard) Render(width, height int) []string {
+	now := d.now()
+	lines := []string{fmt.Sprintf("dockerbackup — backups in %s — %s", d.dir, now.Format("2006-01-02 15:04:05")), ""}
+
+	jobs := d.jobs.Jobs()
+	jobRows := min(len(jobs), maxJobRows)
+	// title, blank, table header, blank, jobs header, jobs, blank, message, help
+	rows := max(height-8-max(jobRows, 1), 1)
+	table := tabulate("CONTAINER\tPROJECT\tLAST BACKUP\tAGE\tSIZE\tFILE", d.containerRows(now))
+	lines = append(lines, "  "+table[0])
+	if len(d.statuses) == 0 {
+		lines = append(lines, "  No running containers")
+	}
+	// scroll so the selected container stays visible
+	first := max(d.selected-rows+1, 0)
+	for i := first; i < len(d.statuses) && i < first+rows; i++ {
+		marker := "  "
+		if i == d.selected {
+			marker = "> "
+		}
+		lines = append(lines, marker+table[i+1])
+	}
+
+	lines = append(lines, "")
+	if len(jobs) == 0 {
+		lines = append(lines, "  JOBS", "  None yet: b backs up the selected container")
+	} else {
+		var jr []string
+		for _, j := range jobs[:jobRows] {
+			jr = append(jr, fmt.Sprintf("%s\t%s\t%s\t%s", j.Kind, j.Target, j.Status, d.jobDetail(j)))
+		}
+		for _, l := range tabulate("JOB\tTARGET\tSTATUS\tPROGRESS", jr) {
+			lines = append(lines, "  "+l)
+		}
+	}
+
+	lines = append(lines, "", d.message, help)
+	if len(lines) > height && height > 2 {
+		lines = append(lines[:height-2], lines[len(lines)-2:]...)
+	}
+	for i, l := range lines {
+		lines[i] = truncate(l, width)
+	}
+	return lines
+}
+
+func (d *Dashboard) containerRows(now time.Time) []string {
+	var rows []string
+	for _, st := range d.statuses {
+		created, age, size, file := "never", "-", "-", "-"
+		if st.Backup != "" {
+			created = st.CreatedAt.Local().Format("2006-01-02 15:04")
+			age = now.Sub(st.CreatedAt).Round(time.Minute).String()
+			size = storage.FormatSize(st.Size)
+			file = filepath.Base(st.Backup)
+		}
+		rows = append(rows, fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s", st.Container, orDash(st.Project), created, age, size, file))
+	}
+	return rows
+}
+
+// jobDetail is the progress of a running job, or the outcome of a finished one.
+func (d *Dashboard) jobDetail(j server.Job) string {
+	switch {
+	case j.Status == server.JobFailed:
+		return j.Error
+	case j.Status == server.JobSucceeded && j.Backup != "":
+		return j.Backup
+	case j.Status == server.JobSucceeded && j.RestoredID != "":
+		return "restored " + j.RestoredID[:min(len(j.RestoredID), 12)]
+	case j.Status == server.JobSucceeded:
+		return "done"
+	}
+	return d.progress[j.ID]
+}
+
+// tabulate aligns the header and tab-separated rows into columns.
+func tabulate(header string, rows []string) []string {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, header)
+	for _, r := range rows {
+		fmt.Fprintln(tw, r)
+	}
+	_ = tw.Flush()
+	return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+}
+
+func truncate(s string, width int) string {
+	s = strings.TrimRight(s, " ")
+	if r := []rune(s); len(r) > width {
+		return string(r[:max(width, 0)])
+	}
+	return s
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
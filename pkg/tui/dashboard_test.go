@@ -0,0 +1,109 @@
+package tui
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brian033/dockerbackup/pkg/docker"
+	"github.com/brian033/dockerbackup/pkg/server"
+)
+
+type fakeJobs struct {
+	jobs     []server.Job
+	log      map[string][]server.LogLine
+	backups  []server.BackupRequest
+	restores []server.RestoreRequest
+}
+
+func (f *fakeJobs) SubmitBackup(body server.BackupRequest) (server.Job, error) {
+	f.backups = append(f.backups, body)
+	j := server.Job{ID: "job-" + body.Container, Kind: "backup", Target: body.Container, Status: server.JobRunning}
+	f.jobs = append([]server.Job{j}, f.jobs...)
+	return j, nil
+}
+
+func (f *fakeJobs) SubmitRestore(body server.RestoreRequest, via string) (server.Job, error) {
+	f.restores = append(f.restores, body)
+	return server.Job{ID: "job-restore", Kind: "restore", Target: body.Backup, Status: server.JobQueued}, nil
+}
+
+func (f *fakeJobs) Jobs() []server.Job { return f.jobs }
+
+func (f *fakeJobs) JobLog(id string, from int) ([]server.LogLine, int, bool) {
+	lines := f.log[id]
+	if from > len(lines) {
+		return nil, from, true
+	}
+	return lines[from:], len(lines), true
+}
+
+func TestDashboard(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	// a backup of db without metadata does not count; web has none
+	if err := os.WriteFile(filepath.Join(dir, "db_backup.tar.gz"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	jobs := &fakeJobs{log: map[string][]server.LogLine{}}
+	d := New(dir, jobs, func(ctx context.Context) ([]docker.ContainerRef, error) {
+		return []docker.ContainerRef{{ID: "1", Name: "web"}, {ID: "2", Name: "db"}}, nil
+	})
+	d.now = func() time.Time { return time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC) }
+	d.Refresh(ctx)
+
+	screen := strings.Join(d.Render(100, 24), "\n")
+	if !strings.Contains(screen, "> web") || !strings.Contains(screen, "  db") || !strings.Contains(screen, "never") {
+		t.Fatalf("screen:\n%s", screen)
+	}
+
+	for _, k := range ParseKeys([]byte("j\x1b[Bb")) {
+		if d.Key(ctx, k) {
+			t.Fatal("quit on a key other than q")
+		}
+	}
+	if !reflect.DeepEqual(jobs.backups, []server.BackupRequest{{Container: "db"}}) {
+		t.Fatalf("backups = %+v", jobs.backups)
+	}
+	jobs.log["job-db"] = []server.LogLine{{Message: "Starting backup of db"}, {Message: "docker export db: 1.0 MiB of ~4.0 MiB (25%)"}}
+	d.Update(ctx)
+	screen = strings.Join(d.Render(100, 24), "\n")
+	if !strings.Contains(screen, "Queued the backup of db") || !strings.Contains(screen, "docker export db: 1.0 MiB of ~4.0 MiB (25%)") {
+		t.Fatalf("screen:\n%s", screen)
+	}
+
+	// no backup to restore yet
+	d.Key(ctx, "r")
+	if len(jobs.restores) != 0 || !strings.Contains(d.message, "no backup") {
+		t.Fatalf("restore without a backup: %q, %+v", d.message, jobs.restores)
+	}
+	d.statuses[1].Backup = filepath.Join(dir, "db_2026-10-16T03-00-00.tar.gz")
+	d.Key(ctx, "r")
+	d.Key(ctx, "n")
+	if len(jobs.restores) != 0 {
+		t.Fatalf("restored after answering no: %+v", jobs.restores)
+	}
+	d.Key(ctx, "r")
+	d.Key(ctx, "y")
+	if want := []server.RestoreRequest{{Backup: "db_2026-10-16T03-00-00.tar.gz", Start: true, Replace: true}}; !reflect.DeepEqual(jobs.restores, want) {
+		t.Fatalf("restores = %+v", jobs.restores)
+	}
+
+	// quitting with a running job asks first
+	if d.Key(ctx, "q") || d.Key(ctx, "n") {
+		t.Fatal("quit with a running job without asking")
+	}
+	if d.Key(ctx, KeyCtrlC); !d.Key(ctx, "y") {
+		t.Fatal("confirmed quit did not quit")
+	}
+
+	for _, l := range d.Render(20, 5) {
+		if len([]rune(l)) > 20 {
+			t.Fatalf("line wider than the screen: %q", l)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package tui
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+)
+
+// Key is a key press: the character typed, or one of the named keys.
+type Key string
+
+const (
+	KeyUp    Key = "up"
+	KeyDown  Key = "down"
+	KeyCtrlC Key = "ctrl-c"
+)
+
+// ParseKeys splits what a terminal in raw mode sent into key presses. Escape sequences other
+// than the arrow keys are dropped.
+func ParseKeys(b []byte) []Key {
+	var keys []Key
+	for i := 0; i < len(b); i++ {
+		switch {
+		case b[i] == 0x1b && i+2 < len(b) && (b[i+1] == '[' || b[i+1] == 'O'):
+			switch b[i+2] {
+			case 'A':
+				keys = append(keys, KeyUp)
+			case 'B':
+				keys = append(keys, KeyDown)
+			}
+			i += 2
+		case b[i] == 0x03:
+			keys = append(keys, KeyCtrlC)
+		case b[i] >= 0x20 && b[i] < 0x7f:
+			keys = append(keys, Key(b[i:i+1]))
+		}
+	}
+	return keys
+}
+
+// Run shows the dashboard on out, a terminal in raw mode of the size size returns, and handles
+// the keys read from in until the dashboard quits, in is closed or ctx is done. The screen is
+// redrawn every second, so running jobs show their progress.
+func Run(ctx context.Context, d *Dashboard, in io.Reader, out io.Writer, size func() (width, height int)) error {
+	keys := make(chan []byte)
+	go func() {
+		defer close(keys)
+		buf := make([]byte, 64)
+		for {
+			n, err := in.Read(buf)
+			if n > 0 {
+				keys <- append([]byte(nil), buf[:n]...)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	// the alternate screen, without cursor, is left again on return
+	io.WriteString(out, "\x1b[?1049h\x1b[?25l")
+	defer io.WriteString(out, "\x1b[?25h\x1b[?1049l")
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	d.Refresh(ctx)
+	for {
+		d.Update(ctx)
+		if err := draw(out, d.Render(size())); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case b, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			for _, k := range ParseKeys(b) {
+				if d.Key(ctx, k) {
+					return nil
+				}
+			}
+		case <-tick.C:
+		}
+	}
+}
+
+// draw repaints the screen with lines, clearing what is left of each line and below them.
+func draw(out io.Writer, lines []string) error {
+	var sb strings.Builder
+	sb.WriteString("\x1b[H")
+	for i, l := range lines {
+		if i > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString(l)
+		sb.WriteString("\x1b[K")
+	}
+	sb.WriteString("\x1b[J")
+	_, err := io.WriteString(out, sb.String())
+	return err
+}